@@ -33,6 +33,52 @@ import (
 type ApplicationSpec struct {
 	// ReleaseTemplate は 各Stageに対応するReleaseのテンプレートを示します
 	ReleaseTemplate ReleaseSpec `json:"releaseTemplate,omitempty"`
+	// Suspend はApplicationとそのReleaseの作成や更新を一時停止することを示します
+	// 一時停止中はReleaseにも ApplicationSuspendedAnnotationKey が付与され､デプロイが停止されます
+	// +optional
+	Suspend bool `json:"suspend,omitempty"`
+	// Promotions はブランチのHEADではなく､前のStageでデプロイされたコミットを受け取るStageを示します
+	// 指定されないStageは各自のブランチのHEADがデプロイされます
+	// +listType=map
+	// +listMapKey=stage
+	// +optional
+	Promotions []StagePromotion `json:"promotions,omitempty"`
+	// StageSecrets は各Stageの環境変数を格納したSecretの名前を示します
+	// ADR003に従い､Stageごとに異なる値を持つSecretを参照させるために使用します
+	// 指定されないStageは `<application>-<stage>-env` のSecretが存在する場合にそれを参照します
+	// どちらもない場合は spec.releaseTemplate.envSecretName を参照します
+	// +listType=map
+	// +listMapKey=stage
+	// +optional
+	StageSecrets []StageSecret `json:"stageSecrets,omitempty"`
+}
+
+// StageSecret はStageのReleaseが参照する環境変数のSecretを示します
+type StageSecret struct {
+	// Stage はSecretを参照するStageの名前を示します
+	// +kubebuilder:validation:MinLength=1
+	Stage string `json:"stage"`
+	// SecretName はApplicationと同じNamespaceに存在するSecretの名前を示します
+	// +kubebuilder:validation:MinLength=1
+	SecretName string `json:"secretName"`
+}
+
+// StagePromotion はStageへのコミットのプロモーションの条件を示します
+// +kubebuilder:validation:XValidation:rule="self.stage != self.from",message="from must be different from stage"
+type StagePromotion struct {
+	// Stage はプロモーションによってコミットを受け取るStageの名前を示します
+	// +kubebuilder:validation:MinLength=1
+	Stage string `json:"stage"`
+	// From はコミットの取得元となるStageの名前を示します
+	// +kubebuilder:validation:MinLength=1
+	From string `json:"from"`
+	// SoakTime は取得元のReleaseがDeployedかつ健全な状態を保つ必要のある時間を示します
+	// +optional
+	SoakTime *metav1.Duration `json:"soakTime,omitempty"`
+	// RequireApproval は手動の承認を必要とすることを示します
+	// Applicationに `promotion.tacokumo.github.io/<stage>: <commit>` のアノテーションを付与することで承認されます
+	// +optional
+	RequireApproval bool `json:"requireApproval,omitempty"`
 }
 
 // RepositoryRef defines a reference to a Git repository.
@@ -51,8 +97,48 @@ type ApplicationStatus struct {
 
 	Releases []corev1.ObjectReference `json:"releases,omitempty"`
 	State    string                   `json:"state,omitempty"`
+
+	// PendingPromotions は条件を満たすのを待っているプロモーションを示します
+	// +optional
+	PendingPromotions []PendingPromotion `json:"pendingPromotions,omitempty"`
+
+	// MissingSecrets は spec.stageSecrets で指定されたSecretのうち､存在しないものを示します
+	// Secretを参照しないStageについては､命名規則に従ったSecretの名前を示します
+	// +optional
+	MissingSecrets []StageSecret `json:"missingSecrets,omitempty"`
 }
 
+// PendingPromotion は条件を満たすのを待っているプロモーションを示します
+type PendingPromotion struct {
+	// Stage はコミットを受け取るStageの名前を示します
+	Stage string `json:"stage"`
+	// From はコミットの取得元となるStageの名前を示します
+	From string `json:"from"`
+	// Commit はプロモーションされるコミットを示します
+	// 取得元のReleaseがまだデプロイされていない場合は空になります
+	// +optional
+	Commit string `json:"commit,omitempty"`
+	// Phase はプロモーションが待っている条件を示します
+	Phase string `json:"phase"`
+	// SoakUntil はSoakTimeが経過する時刻を示します
+	// +optional
+	SoakUntil *metav1.Time `json:"soakUntil,omitempty"`
+	// Message はプロモーションの状態の詳細を示します
+	// +optional
+	Message string `json:"message,omitempty"`
+}
+
+const (
+	// PromotionPhaseWaitingForSource は取得元のReleaseのデプロイが完了し､健全になるのを待っていることを示します
+	PromotionPhaseWaitingForSource = "WaitingForSource"
+	// PromotionPhaseSoaking は取得元のReleaseが健全な状態でSoakTimeが経過するのを待っていることを示します
+	PromotionPhaseSoaking = "Soaking"
+	// PromotionPhaseWaitingForApproval は手動の承認を待っていることを示します
+	PromotionPhaseWaitingForApproval = "WaitingForApproval"
+	// PromotionPhaseTargetSuspended は対象のStageのReleaseの一時停止が解除されるのを待っていることを示します
+	PromotionPhaseTargetSuspended = "TargetSuspended"
+)
+
 const (
 	// ApplicationStateProvisioning は差分検知などによって遷移し､
 	// appconfigなどを読み込んでReleaseを作成している途中であることを示します
@@ -69,6 +155,9 @@ const (
 // +kubebuilder:object:root=true
 // +kubebuilder:subresource:status
 // +kubebuilder:printcolumn:name="STATE",type=string,JSONPath=`.status.state`,description="Current state of the Application"
+// +kubebuilder:printcolumn:name="SUSPENDED",type=string,JSONPath=`.status.conditions[?(@.type=="Suspended")].status`,description="Whether the Application is suspended",priority=1
+// +kubebuilder:printcolumn:name="PENDING",type=string,JSONPath=`.status.pendingPromotions[*].stage`,description="Stages waiting for promotion",priority=1
+// +kubebuilder:printcolumn:name="MISSING-SECRETS",type=string,JSONPath=`.status.missingSecrets[*].stage`,description="Stages whose Secret is missing",priority=1
 // +kubebuilder:printcolumn:name="RELEASES",type=string,JSONPath=`.status.releases[*].name`,description="Release names",priority=1
 // +kubebuilder:printcolumn:name="MESSAGE",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].message`,description="Status message",priority=1
 // +kubebuilder:printcolumn:name="AGE",type=date,JSONPath=`.metadata.creationTimestamp`
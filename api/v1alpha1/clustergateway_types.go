@@ -0,0 +1,157 @@
+/*
+MIT License
+
+Copyright (c) 2025 tacokumo
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// DefaultAuthProxyImage はClusterGatewayが認証プロキシとして使用するoauth2-proxyのイメージ
+	DefaultAuthProxyImage = "quay.io/oauth2-proxy/oauth2-proxy:v7.8.1"
+)
+
+// ClusterGatewaySpec defines the desired state of ClusterGateway
+type ClusterGatewaySpec struct {
+	// OIDC は認証に使用するOIDCプロバイダを示します
+	OIDC ClusterGatewayOIDC `json:"oidc"`
+
+	// AllowedGroups はアクセスを許可するOIDCのグループを示します
+	// 指定されない場合は認証されたユーザー全てを許可します
+	// +optional
+	AllowedGroups []string `json:"allowedGroups,omitempty"`
+
+	// AllowedDomains はアクセスを許可するメールアドレスのドメインを示します
+	// 指定されない場合は全てのドメインを許可します
+	// +optional
+	AllowedDomains []string `json:"allowedDomains,omitempty"`
+
+	// NamespaceSelector はClusterGatewayを参照できるGatewayのnamespaceを示します
+	// クライアントシークレットは認証プロキシを作成するnamespaceにコピーされるため､
+	// 指定されない場合はどのnamespaceのGatewayからも参照できません
+	// 空のセレクタは全てのnamespaceを許可します
+	// +optional
+	NamespaceSelector *metav1.LabelSelector `json:"namespaceSelector,omitempty"`
+
+	// ProxyImage は認証プロキシとして使用するoauth2-proxyのイメージを示します
+	// +kubebuilder:default="quay.io/oauth2-proxy/oauth2-proxy:v7.8.1"
+	// +optional
+	ProxyImage string `json:"proxyImage,omitempty"`
+}
+
+// ClusterGatewayOIDC はOIDCプロバイダのクライアント設定を表します
+type ClusterGatewayOIDC struct {
+	// IssuerURL はOIDCプロバイダのIssuer URLを示します
+	// +kubebuilder:validation:Pattern=`^https://`
+	IssuerURL string `json:"issuerURL"`
+
+	// ClientID はOIDCプロバイダに登録したクライアントのIDを示します
+	// +kubebuilder:validation:MinLength=1
+	ClientID string `json:"clientID"`
+
+	// ClientSecretRef はクライアントシークレットを保持するSecretのキーを示します
+	// 認証プロキシを作成するnamespaceにコピーされます
+	ClientSecretRef SecretKeyReference `json:"clientSecretRef"`
+
+	// Scopes はOIDCプロバイダに要求するスコープを示します
+	// 指定されない場合は openid, email, profile を要求します
+	// +optional
+	Scopes []string `json:"scopes,omitempty"`
+}
+
+// SecretKeyReference はnamespaceを含めたSecretのキーへの参照を表します
+type SecretKeyReference struct {
+	NamespacedName `json:",inline"`
+
+	// Key はSecretのキーを示します
+	// +kubebuilder:default="client-secret"
+	// +optional
+	Key string `json:"key,omitempty"`
+}
+
+// ClusterGatewayStatus defines the observed state of ClusterGateway.
+type ClusterGatewayStatus struct {
+	// conditions represent the current state of the ClusterGateway resource.
+	// +listType=map
+	// +listMapKey=type
+	// +optional
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+
+	// ObservedGeneration はstatusに反映されたspecのgenerationを示します
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// ProtectedApplications は認証プロキシによって保護されているApplicationを示します
+	// +optional
+	ProtectedApplications []ProtectedApplication `json:"protectedApplications,omitempty"`
+}
+
+// ProtectedApplication はClusterGatewayの認証を使用しているApplicationとGatewayを表します
+type ProtectedApplication struct {
+	NamespacedName `json:",inline"`
+
+	// Gateway はApplicationを公開しているGatewayの名前を示します
+	Gateway string `json:"gateway"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:resource:scope=Cluster
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="ISSUER",type=string,JSONPath=`.spec.oidc.issuerURL`
+// +kubebuilder:printcolumn:name="READY",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].status`
+// +kubebuilder:printcolumn:name="MESSAGE",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].message`,description="Status message",priority=1
+// +kubebuilder:printcolumn:name="AGE",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// ClusterGateway is the Schema for the clustergateways API
+// ClusterGateway は管理者がテナントをまたいで共有する､Gatewayの認証設定を表します
+// Gatewayが参照すると､アプリケーションのServiceの前段にOIDCで認証するプロキシが配置されます
+type ClusterGateway struct {
+	metav1.TypeMeta `json:",inline"`
+
+	// metadata is a standard object metadata
+	// +optional
+	metav1.ObjectMeta `json:"metadata,omitempty,omitzero"`
+
+	// spec defines the desired state of ClusterGateway
+	// +required
+	Spec ClusterGatewaySpec `json:"spec"`
+
+	// status defines the observed state of ClusterGateway
+	// +optional
+	Status ClusterGatewayStatus `json:"status,omitempty,omitzero"`
+}
+
+// +kubebuilder:object:root=true
+
+// ClusterGatewayList contains a list of ClusterGateway
+type ClusterGatewayList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []ClusterGateway `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&ClusterGateway{}, &ClusterGatewayList{})
+}
@@ -0,0 +1,187 @@
+/*
+MIT License
+
+Copyright (c) 2025 tacokumo
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// ClusterMigrationPhaseProgressing はweightを段階的に変更していることを示します
+	ClusterMigrationPhaseProgressing = "Progressing"
+	// ClusterMigrationPhaseSucceeded は全てのステップのweightを適用し終えたことを示します
+	ClusterMigrationPhaseSucceeded = "Succeeded"
+	// ClusterMigrationPhaseAborted はヘルスチェックの失敗によってweightを初期値に戻したことを示します
+	ClusterMigrationPhaseAborted = "Aborted"
+)
+
+// ClusterMigrationSpec defines the desired state of ClusterMigration
+type ClusterMigrationSpec struct {
+	// Gateway はマイグレーションの対象とするGatewayの名前を示します
+	// GatewayはClusterMigrationと同じnamespaceに存在する必要があります
+	// +kubebuilder:validation:MinLength=1
+	Gateway string `json:"gateway"`
+
+	// ClusterName はこのクラスタを識別する名前を示します
+	// `<name>.<clusterName>.<tenant>.app.<baseDomain>` がこのクラスタのエンドポイントとしてDNSに登録され､
+	// Gatewayのホスト名からこのエンドポイントへの重み付きCNAMEレコードの識別子として使用されます
+	// +kubebuilder:validation:MinLength=1
+	// +kubebuilder:validation:MaxLength=63
+	// +kubebuilder:validation:Pattern=`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`
+	ClusterName string `json:"clusterName"`
+
+	// InitialWeight はマイグレーション開始時のこのクラスタのweightを示します
+	// マイグレーションが中止された場合はこの値に戻されます
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=100
+	// +optional
+	InitialWeight int32 `json:"initialWeight,omitempty"`
+
+	// Steps は順に適用するこのクラスタのweightを示します
+	// 移行先のクラスタでは 0 から 100 へ､移行元のクラスタでは 100 から 0 へ変化させます
+	// +kubebuilder:validation:MinItems=1
+	// +kubebuilder:validation:items:Minimum=0
+	// +kubebuilder:validation:items:Maximum=100
+	Steps []int32 `json:"steps"`
+
+	// Interval は各ステップのweightを維持する期間を示します
+	// 期間中にヘルスチェックが成功し続けた場合に次のステップに進みます
+	Interval metav1.Duration `json:"interval"`
+
+	// HealthCheck は各ステップを進める前に確認するヘルスチェックを示します
+	HealthCheck ClusterMigrationHealthCheck `json:"healthCheck"`
+}
+
+// ClusterMigrationHealthCheck は外部からのE2Eトラフィックを模したHTTPのヘルスチェックを表します
+type ClusterMigrationHealthCheck struct {
+	// URL はヘルスチェックでGETリクエストを送信するURLを示します
+	// +kubebuilder:validation:Pattern=`^https?://`
+	URL string `json:"url"`
+
+	// ExpectedStatus は正常とみなすHTTPのステータスコードを示します
+	// +kubebuilder:default=200
+	// +optional
+	ExpectedStatus int32 `json:"expectedStatus,omitempty"`
+
+	// Timeout はリクエストのタイムアウトを示します
+	// +kubebuilder:default="5s"
+	// +optional
+	Timeout metav1.Duration `json:"timeout,omitempty"`
+
+	// FailureThreshold はマイグレーションを中止するまでに許容する連続した失敗の回数を示します
+	// +kubebuilder:default=3
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	FailureThreshold int32 `json:"failureThreshold,omitempty"`
+}
+
+// ClusterMigrationStatus defines the observed state of ClusterMigration.
+type ClusterMigrationStatus struct {
+	// conditions represent the current state of the ClusterMigration resource.
+	// +listType=map
+	// +listMapKey=type
+	// +optional
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+
+	// Phase はマイグレーションの進行状況を示します
+	// +optional
+	Phase string `json:"phase,omitempty"`
+
+	// CurrentStep は適用済みのステップの数を示します
+	// 0 は spec.initialWeight を適用していることを示します
+	// +optional
+	CurrentStep int32 `json:"currentStep,omitempty"`
+
+	// CurrentWeight はこのクラスタに設定しているweightを示します
+	// +optional
+	CurrentWeight *int32 `json:"currentWeight,omitempty"`
+
+	// StepStartedAt は現在のステップのweightを適用した時刻を示します
+	// +optional
+	StepStartedAt *metav1.Time `json:"stepStartedAt,omitempty"`
+
+	// ConsecutiveFailures はヘルスチェックが連続して失敗した回数を示します
+	// +optional
+	ConsecutiveFailures int32 `json:"consecutiveFailures,omitempty"`
+
+	// LastHealthCheck は最後に行ったヘルスチェックの結果を示します
+	// +optional
+	LastHealthCheck *ClusterMigrationHealthCheckResult `json:"lastHealthCheck,omitempty"`
+}
+
+// ClusterMigrationHealthCheckResult はヘルスチェックの結果を表します
+type ClusterMigrationHealthCheckResult struct {
+	// Time はヘルスチェックを行った時刻を示します
+	Time metav1.Time `json:"time"`
+
+	// Healthy はヘルスチェックが成功したことを示します
+	Healthy bool `json:"healthy"`
+
+	// Message は失敗した場合の理由を示します
+	// +optional
+	Message string `json:"message,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="GATEWAY",type=string,JSONPath=`.spec.gateway`
+// +kubebuilder:printcolumn:name="CLUSTER",type=string,JSONPath=`.spec.clusterName`
+// +kubebuilder:printcolumn:name="PHASE",type=string,JSONPath=`.status.phase`
+// +kubebuilder:printcolumn:name="WEIGHT",type=integer,JSONPath=`.status.currentWeight`
+// +kubebuilder:printcolumn:name="STEP",type=integer,JSONPath=`.status.currentStep`,priority=1
+// +kubebuilder:printcolumn:name="MESSAGE",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].message`,description="Status message",priority=1
+// +kubebuilder:printcolumn:name="AGE",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// ClusterMigration is the Schema for the clustermigrations API
+// ClusterMigration はクラスタ間でアプリケーションを移行するために､
+// このクラスタのGatewayへ向かうトラフィックの割合を重み付きDNSで段階的に変更します
+type ClusterMigration struct {
+	metav1.TypeMeta `json:",inline"`
+
+	// metadata is a standard object metadata
+	// +optional
+	metav1.ObjectMeta `json:"metadata,omitempty,omitzero"`
+
+	// spec defines the desired state of ClusterMigration
+	// +required
+	Spec ClusterMigrationSpec `json:"spec"`
+
+	// status defines the observed state of ClusterMigration
+	// +optional
+	Status ClusterMigrationStatus `json:"status,omitempty,omitzero"`
+}
+
+// +kubebuilder:object:root=true
+
+// ClusterMigrationList contains a list of ClusterMigration
+type ClusterMigrationList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []ClusterMigration `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&ClusterMigration{}, &ClusterMigrationList{})
+}
@@ -24,6 +24,10 @@ SOFTWARE.
 
 package v1alpha1
 
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
 // NamespacedName はKubernetesリソースのNamespaceとNameを表します
 // これは types.NamespacedName でJSONタグが定義されていないために使われます。
 type NamespacedName struct {
@@ -31,7 +35,58 @@ type NamespacedName struct {
 	Name      string `json:"name"`
 }
 
+// PodReference はコントローラが管理するPodとその状態を表します
 type PodReference struct {
 	NamespacedName `json:",inline"`
 	Ready          bool `json:"ready"`
+	// Phase はPodのフェーズを示します
+	// +optional
+	Phase string `json:"phase,omitempty"`
+	// RestartCount はPod内のコンテナの再起動回数の合計を示します
+	// +optional
+	RestartCount int32 `json:"restartCount,omitempty"`
+	// Reason はコンテナが失敗している理由を示します(CrashLoopBackOff, ImagePullBackOffなど)
+	// +optional
+	Reason string `json:"reason,omitempty"`
+	// LastTerminationReason はコンテナが最後に終了した理由を示します(OOMKilled, Errorなど)
+	// +optional
+	LastTerminationReason string `json:"lastTerminationReason,omitempty"`
+}
+
+// DryRunStatus はdry-runで計算した､適用した場合のクラスタへの変更を表します
+type DryRunStatus struct {
+	// ObservedGeneration は差分を計算したときのmetadata.generationを示します
+	ObservedGeneration int64 `json:"observedGeneration"`
+	// ComputedAt は差分を計算した時刻を示します
+	ComputedAt metav1.Time `json:"computedAt"`
+	// Summary は作成､変更､削除されるオブジェクトの数を示します
+	Summary string `json:"summary"`
+	// Changes は変更されるオブジェクトを示します
+	// 変更のないオブジェクトは含まれません
+	// +optional
+	Changes []DryRunChange `json:"changes,omitempty"`
 }
+
+// DryRunChange はdry-runで検出した1つのオブジェクトの変更を表します
+type DryRunChange struct {
+	// Action は変更の種類を示します
+	// +kubebuilder:validation:Enum=Created;Changed;Deleted
+	Action     string `json:"action"`
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	// +optional
+	Namespace string `json:"namespace,omitempty"`
+	Name      string `json:"name"`
+	// Fields はActionがChangedの場合に､変更されるフィールドのパスを示します
+	// +optional
+	Fields []string `json:"fields,omitempty"`
+}
+
+const (
+	// DryRunActionCreated はオブジェクトが作成されることを示します
+	DryRunActionCreated = "Created"
+	// DryRunActionChanged はオブジェクトが変更されることを示します
+	DryRunActionChanged = "Changed"
+	// DryRunActionDeleted はオブジェクトが削除されることを示します
+	DryRunActionDeleted = "Deleted"
+)
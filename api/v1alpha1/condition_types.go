@@ -33,12 +33,116 @@ import (
 const (
 	// ConditionTypeReady indicates whether the resource is ready
 	ConditionTypeReady = "Ready"
+	// ConditionTypePreDeployHook indicates the result of the pre-deploy hook Jobs of a Release
+	ConditionTypePreDeployHook = "PreDeployHook"
+	// ConditionTypeProgressing indicates whether the workloads of a Release are rolling out
+	ConditionTypeProgressing = "Progressing"
+	// ConditionTypeCanary indicates the progress of the canary release of a Release
+	ConditionTypeCanary = "Canary"
+	// ConditionTypePreview indicates the verification of the blue/green preview of a Release
+	ConditionTypePreview = "Preview"
+	// ConditionTypeDryRun indicates the result of the dry-run of a Release or Portal
+	ConditionTypeDryRun = "DryRun"
+	// ConditionTypeSuspended indicates whether reconciliation of the resource is suspended
+	ConditionTypeSuspended = "Suspended"
+	// ConditionTypeBlockedByDeployWindow indicates a Release is waiting for a DeployWindow to open
+	ConditionTypeBlockedByDeployWindow = "BlockedByDeployWindow"
+	// ConditionTypeDNSReady indicates whether the DNS records of a Gateway are registered to the provider
+	ConditionTypeDNSReady = "DNSReady"
+	// ConditionTypeTLSReady indicates whether the TLS certificate of a Gateway is issued and valid
+	ConditionTypeTLSReady = "TLSReady"
+	// ConditionTypePodsReady indicates whether all of the managed pods are ready
+	ConditionTypePodsReady = "PodsReady"
+	// ConditionTypeDegraded indicates whether some of the managed pods are failing
+	ConditionTypeDegraded = "Degraded"
+	// ConditionTypeServiceBinding indicates whether the connection information bound to a deployed Release is available
+	ConditionTypeServiceBinding = "ServiceBinding"
 )
 
 // Condition Reasons
 const (
 	// ReasonReconcileError indicates a reconciliation error occurred
 	ReasonReconcileError = "ReconcileError"
+	// ReasonInvalidAppConfig indicates the appconfig of the commit is invalid and the Release waits for its spec to be updated
+	ReasonInvalidAppConfig = "InvalidAppConfig"
+	// ReasonHookRunning indicates a pre-deploy hook Job is still running
+	ReasonHookRunning = "HookRunning"
+	// ReasonHookSucceeded indicates all pre-deploy hook Jobs have succeeded
+	ReasonHookSucceeded = "HookSucceeded"
+	// ReasonHookFailed indicates a pre-deploy hook Job has failed
+	ReasonHookFailed = "HookFailed"
+	// ReasonRolloutInProgress indicates the workloads are being rolled out
+	ReasonRolloutInProgress = "RolloutInProgress"
+	// ReasonRolloutComplete indicates the rollout of all workloads has completed
+	ReasonRolloutComplete = "RolloutComplete"
+	// ReasonProgressDeadlineExceeded indicates a Deployment did not progress within its deadline
+	ReasonProgressDeadlineExceeded = "ProgressDeadlineExceeded"
+	// ReasonCanaryProgressing indicates the canary release is running its steps
+	ReasonCanaryProgressing = "CanaryProgressing"
+	// ReasonCanaryPromoted indicates all canary steps succeeded and the new commit was promoted
+	ReasonCanaryPromoted = "CanaryPromoted"
+	// ReasonCanaryAnalysisFailed indicates the canary analysis failed and the release was rolled back
+	ReasonCanaryAnalysisFailed = "CanaryAnalysisFailed"
+	// ReasonPreviewProgressing indicates the preview Deployment is being rolled out or verified
+	ReasonPreviewProgressing = "PreviewProgressing"
+	// ReasonSmokeTestFailed indicates the smoke test Job against the preview has failed
+	ReasonSmokeTestFailed = "SmokeTestFailed"
+	// ReasonPreviewVerified indicates the preview passed verification and traffic was switched to it
+	ReasonPreviewVerified = "PreviewVerified"
+	// ReasonDryRunSucceeded indicates the dry-run diff has been computed
+	ReasonDryRunSucceeded = "DryRunSucceeded"
+	// ReasonDryRunFailed indicates the dry-run could not be completed
+	ReasonDryRunFailed = "DryRunFailed"
+	// ReasonSuspended indicates the resource is suspended by its spec
+	ReasonSuspended = "Suspended"
+	// ReasonApplicationSuspended indicates the Release is suspended by its Application
+	ReasonApplicationSuspended = "ApplicationSuspended"
+	// ReasonDeployWindowClosed indicates a DeployWindow does not allow deployments at the moment
+	ReasonDeployWindowClosed = "DeployWindowClosed"
+	// ReasonRouteReady indicates the routes of a Gateway have been applied and its endpoint is reachable
+	ReasonRouteReady = "RouteReady"
+	// ReasonWaitingForAddress indicates the load balancer address of a Gateway has not been assigned yet
+	ReasonWaitingForAddress = "WaitingForAddress"
+	// ReasonDNSRecordsSynced indicates the DNS records of a Gateway are registered to the provider
+	ReasonDNSRecordsSynced = "DNSRecordsSynced"
+	// ReasonDNSProviderError indicates the DNS provider failed to register or delete records
+	ReasonDNSProviderError = "DNSProviderError"
+	// ReasonFQDNConflict indicates another Gateway already uses the FQDN
+	ReasonFQDNConflict = "FQDNConflict"
+	// ReasonAuthenticationConfigured indicates the OIDC client of a ClusterGateway is ready to be used by auth proxies
+	ReasonAuthenticationConfigured = "AuthenticationConfigured"
+	// ReasonTLSNotConfigured indicates no certificate issuer or wildcard certificate is configured on the Portal,
+	// or the Gateway uses HTTPRoute whose TLS is terminated by the parent gateway
+	ReasonTLSNotConfigured = "TLSNotConfigured"
+	// ReasonIssuerNotFound indicates the cert-manager issuer of a Gateway certificate is not available
+	ReasonIssuerNotFound = "IssuerNotFound"
+	// ReasonCertificatePending indicates the Gateway certificate has not been issued yet
+	ReasonCertificatePending = "CertificatePending"
+	// ReasonCertificateReady indicates the Gateway certificate is issued and valid
+	ReasonCertificateReady = "CertificateReady"
+	// ReasonDefaultCertificateAssumed indicates the wildcard certificate is valid and assumed to be
+	// served as the default certificate of the ingress controller, which the controller cannot verify
+	ReasonDefaultCertificateAssumed = "DefaultCertificateAssumed"
+	// ReasonInvalidCertificate indicates the wildcard certificate is missing, expired or does not cover the host
+	ReasonInvalidCertificate = "InvalidCertificate"
+	// ReasonMigrationProgressing indicates a ClusterMigration is shifting the weight of this cluster step by step
+	ReasonMigrationProgressing = "MigrationProgressing"
+	// ReasonMigrationSucceeded indicates a ClusterMigration has applied all of its steps
+	ReasonMigrationSucceeded = "MigrationSucceeded"
+	// ReasonMigrationAborted indicates a ClusterMigration reverted the weight because the health check kept failing
+	ReasonMigrationAborted = "MigrationAborted"
+	// ReasonSecretBound indicates the Secret of a ServiceBinding has all of the mapped keys
+	ReasonSecretBound = "SecretBound"
+	// ReasonSecretUnavailable indicates the Secret of a ServiceBinding or its keys bound to a deployed Release are missing
+	ReasonSecretUnavailable = "SecretUnavailable"
+	// ReasonPodsReady indicates all managed pods are ready
+	ReasonPodsReady = "PodsReady"
+	// ReasonPodsNotReady indicates some of the managed pods are not ready
+	ReasonPodsNotReady = "PodsNotReady"
+	// ReasonPodsHealthy indicates none of the managed pods are failing
+	ReasonPodsHealthy = "PodsHealthy"
+	// ReasonPodsFailing indicates some of the managed pods are failing
+	ReasonPodsFailing = "PodsFailing"
 )
 
 // SetReadyConditionFalse sets the Ready condition to False with the given reason and message
@@ -52,3 +156,32 @@ func SetReadyConditionFalse(conditions *[]metav1.Condition, generation int64, re
 	}
 	meta.SetStatusCondition(conditions, condition)
 }
+
+// SetReadyConditionTrue sets the Ready condition to True with the given reason and message
+func SetReadyConditionTrue(conditions *[]metav1.Condition, generation int64, reason, message string) {
+	condition := metav1.Condition{
+		Type:               ConditionTypeReady,
+		Status:             metav1.ConditionTrue,
+		ObservedGeneration: generation,
+		Reason:             reason,
+		Message:            message,
+	}
+	meta.SetStatusCondition(conditions, condition)
+}
+
+// SetSuspendedCondition sets the Suspended condition to True with the given reason and message
+// when suspended is true, and removes it otherwise
+func SetSuspendedCondition(conditions *[]metav1.Condition, generation int64, suspended bool, reason, message string) {
+	if !suspended {
+		meta.RemoveStatusCondition(conditions, ConditionTypeSuspended)
+		return
+	}
+	condition := metav1.Condition{
+		Type:               ConditionTypeSuspended,
+		Status:             metav1.ConditionTrue,
+		ObservedGeneration: generation,
+		Reason:             reason,
+		Message:            message,
+	}
+	meta.SetStatusCondition(conditions, condition)
+}
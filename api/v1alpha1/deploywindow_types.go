@@ -0,0 +1,112 @@
+/*
+MIT License
+
+Copyright (c) 2025 tacokumo
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// DeployWindowTypeAllow はウィンドウが有効な間のみデプロイを許可することを示します
+	DeployWindowTypeAllow = "Allow"
+	// DeployWindowTypeDeny はウィンドウが有効な間はデプロイを禁止することを示します
+	DeployWindowTypeDeny = "Deny"
+)
+
+// DeployWindowSpec defines the desired state of DeployWindow
+type DeployWindowSpec struct {
+	// Stages は対象とするReleaseのStageを示します
+	// 指定されない場合は全てのStageが対象になります
+	// +optional
+	Stages []string `json:"stages,omitempty"`
+
+	// ApplicationSelector は対象とするReleaseを所有するApplicationのラベルセレクタを示します
+	// 指定されない場合は全てのApplicationが対象になります
+	// +optional
+	ApplicationSelector *metav1.LabelSelector `json:"applicationSelector,omitempty"`
+
+	// Windows はデプロイを許可､または禁止する期間を示します
+	// Denyのウィンドウが1つでも有効な間はデプロイできません
+	// Allowのウィンドウが存在する場合は､そのいずれかが有効な間のみデプロイできます
+	// +kubebuilder:validation:MinItems=1
+	Windows []DeployWindowRule `json:"windows"`
+}
+
+// DeployWindowRule はcron式で開始時刻を指定したデプロイの許可､禁止の期間を表します
+type DeployWindowRule struct {
+	// Type はウィンドウの種類を示します
+	// +kubebuilder:validation:Enum=Allow;Deny
+	Type string `json:"type"`
+
+	// Schedule はウィンドウが開始する時刻を5フィールドのcron式で示します
+	// 例えば "0 0 * * sat" は毎週土曜日の0時を示します
+	// +kubebuilder:validation:MinLength=1
+	Schedule string `json:"schedule"`
+
+	// Duration はウィンドウが開始してから有効である期間を示します
+	Duration metav1.Duration `json:"duration"`
+
+	// TimeZone は Schedule を解釈するタイムゾーンをIANAのタイムゾーン名で示します
+	// 指定されない場合はUTCとして解釈されます
+	// +optional
+	TimeZone string `json:"timeZone,omitempty"`
+
+	// Reason はウィンドウの理由を示します
+	// ウィンドウによってデプロイが止められた場合に､Releaseのconditionに表示されます
+	// +optional
+	Reason string `json:"reason,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:resource:scope=Cluster
+// +kubebuilder:printcolumn:name="AGE",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// DeployWindow is the Schema for the deploywindows API
+// DeployWindow は管理者がクラスタ全体に対して定義する､デプロイを許可する期間のポリシーです
+// Releaseのデプロイ前に参照され､デプロイが許可されない間はReleaseのデプロイを保留します
+type DeployWindow struct {
+	metav1.TypeMeta `json:",inline"`
+
+	// metadata is a standard object metadata
+	// +optional
+	metav1.ObjectMeta `json:"metadata,omitempty,omitzero"`
+
+	// spec defines the desired state of DeployWindow
+	// +required
+	Spec DeployWindowSpec `json:"spec"`
+}
+
+// +kubebuilder:object:root=true
+
+// DeployWindowList contains a list of DeployWindow
+type DeployWindowList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []DeployWindow `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&DeployWindow{}, &DeployWindowList{})
+}
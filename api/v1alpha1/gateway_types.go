@@ -0,0 +1,259 @@
+/*
+MIT License
+
+Copyright (c) 2025 tacokumo
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package v1alpha1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// GatewayImplementationIngress は networking.k8s.io/v1 のIngressでエンドポイントを公開することを示します
+	GatewayImplementationIngress = "Ingress"
+	// GatewayImplementationHTTPRoute はGateway APIのHTTPRouteでエンドポイントを公開することを示します
+	GatewayImplementationHTTPRoute = "HTTPRoute"
+
+	// GatewayTLSSourceCertificate はcert-managerのCertificateで発行した証明書を使用することを示します
+	GatewayTLSSourceCertificate = "Certificate"
+	// GatewayTLSSourceWildcardSecret はPortalに設定されたワイルドカード証明書をコピーして使用することを示します
+	GatewayTLSSourceWildcardSecret = "WildcardSecret"
+	// GatewayTLSSourceAssumedDefaultCertificate はIngressコントローラーのデフォルト証明書が
+	// Portalに設定されたワイルドカード証明書であると仮定して使用することを示します
+	GatewayTLSSourceAssumedDefaultCertificate = "AssumedDefaultCertificate"
+)
+
+// GatewaySpec defines the desired state of Gateway
+type GatewaySpec struct {
+	// Application は公開するApplicationの名前を示します
+	// ApplicationはGatewayと同じnamespaceに存在する必要があります
+	// +kubebuilder:validation:MinLength=1
+	Application string `json:"application"`
+
+	// Stage は公開するApplicationのStageを示します
+	// `<application>-<stage>` のReleaseが作成するServiceにルーティングされます
+	// +kubebuilder:validation:MinLength=1
+	Stage string `json:"stage"`
+
+	// Ports は公開するServiceのポートを示します
+	// +kubebuilder:validation:MinItems=1
+	Ports []GatewayPort `json:"ports"`
+
+	// Implementation はエンドポイントの公開に使用するリソースを示します
+	// 指定されない場合はPortalが検出したクラスタのコントローラーから選択されます
+	// Gateway APIのGatewayClassが存在し､spec.parentRefs が指定されている場合はHTTPRoute､
+	// それ以外の場合はIngressが使用されます
+	// +kubebuilder:validation:Enum=Ingress;HTTPRoute
+	// +optional
+	Implementation string `json:"implementation,omitempty"`
+
+	// IngressClassName はIngressを使用する場合のIngressClassの名前を示します
+	// 指定されない場合はクラスタのデフォルトのIngressClassが使用されます
+	// +optional
+	IngressClassName *string `json:"ingressClassName,omitempty"`
+
+	// ParentRefs はHTTPRouteを使用する場合に接続するGateway APIのGatewayを示します
+	// +optional
+	ParentRefs []GatewayParentReference `json:"parentRefs,omitempty"`
+
+	// Host はエンドポイントのホスト名を示します
+	// 指定された場合はDNSへの登録を行いません
+	// 指定されず､コントローラーにベースドメインが設定されている場合は
+	// `<dnsName>.<tenant>.app.<baseDomain>` がホスト名として使用され､DNSに登録されます
+	// どちらでもない場合はホスト名によるルーティングを行わず､ロードバランサーのアドレスが status.host に記録されます
+	// +optional
+	Host string `json:"host,omitempty"`
+
+	// DNSName はDNSに登録するホスト名の最初のラベルを示します
+	// 指定されない場合はApplicationの名前が使用されます
+	// 同じApplicationの複数のStageを公開する場合は､Stageごとに異なる値を指定してください
+	// +kubebuilder:validation:MaxLength=63
+	// +optional
+	DNSName string `json:"dnsName,omitempty"`
+
+	// Authentication はエンドポイントへのアクセスに認証を要求する場合の設定を示します
+	// 指定された場合はServiceの前段に認証プロキシが配置されます
+	// +optional
+	Authentication *GatewayAuthentication `json:"authentication,omitempty"`
+}
+
+// GatewayAuthentication はGatewayが使用する認証の設定を表します
+type GatewayAuthentication struct {
+	// ClusterGateway は認証の設定を共有するClusterGatewayの名前を示します
+	// +kubebuilder:validation:MinLength=1
+	ClusterGateway string `json:"clusterGateway"`
+}
+
+// GatewayPort は公開するServiceのポートとパスを表します
+type GatewayPort struct {
+	// Port はServiceのポート番号を示します
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=65535
+	Port int32 `json:"port"`
+
+	// Path はポートにルーティングするパスのprefixを示します
+	// +kubebuilder:default="/"
+	// +optional
+	Path string `json:"path,omitempty"`
+}
+
+// GatewayParentReference はHTTPRouteが接続するGateway APIのGatewayを表します
+type GatewayParentReference struct {
+	// Name はGatewayの名前を示します
+	// +kubebuilder:validation:MinLength=1
+	Name string `json:"name"`
+
+	// Namespace はGatewayのnamespaceを示します
+	// 指定されない場合はGatewayリソースと同じnamespaceが使用されます
+	// +optional
+	Namespace string `json:"namespace,omitempty"`
+
+	// SectionName はGatewayのlistenerの名前を示します
+	// +optional
+	SectionName string `json:"sectionName,omitempty"`
+}
+
+// GatewayStatus defines the observed state of Gateway.
+type GatewayStatus struct {
+	// conditions represent the current state of the Gateway resource.
+	// +listType=map
+	// +listMapKey=type
+	// +optional
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+
+	// ObservedGeneration はstatusに反映されたspecのgenerationを示します
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// Host はエンドポイントのホスト名､またはロードバランサーのアドレスを示します
+	// +optional
+	Host string `json:"host,omitempty"`
+
+	// URL はエンドポイントのURLを示します
+	// +optional
+	URL string `json:"url,omitempty"`
+
+	// Implementation はエンドポイントの公開に使用している実装を示します
+	// +optional
+	Implementation string `json:"implementation,omitempty"`
+
+	// Routes はGatewayが作成したIngressやHTTPRoute､認証プロキシや証明書のリソースを示します
+	// +optional
+	Routes []corev1.ObjectReference `json:"routes,omitempty"`
+
+	// TLS はエンドポイントのTLS証明書の状態を示します
+	// +optional
+	TLS *GatewayTLSStatus `json:"tls,omitempty"`
+
+	// ProtectedBy はエンドポイントを保護している認証プロキシが使用するClusterGatewayの名前を示します
+	// +optional
+	ProtectedBy string `json:"protectedBy,omitempty"`
+
+	// DNSRecords はGatewayがDNSプロバイダに登録したレコードを示します
+	// Gatewayの削除時やホスト名の変更時に､不要になったレコードの削除に使用されます
+	// +optional
+	DNSRecords []DNSRecordStatus `json:"dnsRecords,omitempty"`
+}
+
+// GatewayTLSStatus はGatewayが使用するTLS証明書を表します
+type GatewayTLSStatus struct {
+	// Source は証明書の取得方法を示します
+	// +kubebuilder:validation:Enum=Certificate;WildcardSecret;AssumedDefaultCertificate
+	Source string `json:"source"`
+
+	// SecretName は証明書を保持するGatewayと同じnamespaceのSecretの名前を示します
+	// Ingressコントローラーのデフォルト証明書を使用する場合は記録されません
+	// +optional
+	SecretName string `json:"secretName,omitempty"`
+
+	// NotAfter は証明書の有効期限を示します
+	// 証明書が発行されていない場合や､提供される証明書を確認できないデフォルト証明書の場合は記録されません
+	// +optional
+	NotAfter *metav1.Time `json:"notAfter,omitempty"`
+}
+
+// DNSRecordStatus はDNSプロバイダに登録したレコードを表します
+type DNSRecordStatus struct {
+	// Name はレコードのFQDNを示します
+	Name string `json:"name"`
+
+	// Type はレコードの種類を示します
+	Type string `json:"type"`
+
+	// Targets はレコードの値を示します
+	// +optional
+	Targets []string `json:"targets,omitempty"`
+
+	// SetIdentifier は重み付きレコードの識別子を示します
+	// +optional
+	SetIdentifier string `json:"setIdentifier,omitempty"`
+
+	// Weight は重み付きレコードの重みを示します
+	// +optional
+	Weight *int64 `json:"weight,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="APPLICATION",type=string,JSONPath=`.spec.application`
+// +kubebuilder:printcolumn:name="STAGE",type=string,JSONPath=`.spec.stage`
+// +kubebuilder:printcolumn:name="IMPLEMENTATION",type=string,JSONPath=`.status.implementation`,priority=1
+// +kubebuilder:printcolumn:name="URL",type=string,JSONPath=`.status.url`
+// +kubebuilder:printcolumn:name="READY",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].status`
+// +kubebuilder:printcolumn:name="TLS",type=string,JSONPath=`.status.conditions[?(@.type=="TLSReady")].status`,priority=1
+// +kubebuilder:printcolumn:name="DNS",type=string,JSONPath=`.status.conditions[?(@.type=="DNSReady")].status`,priority=1
+// +kubebuilder:printcolumn:name="MESSAGE",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].message`,description="Status message",priority=1
+// +kubebuilder:printcolumn:name="AGE",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// Gateway is the Schema for the gateways API
+// Gateway はApplicationのStageを外部に公開するエンドポイントを表します
+// IngressやGateway APIのHTTPRouteを抽象化したものです
+type Gateway struct {
+	metav1.TypeMeta `json:",inline"`
+
+	// metadata is a standard object metadata
+	// +optional
+	metav1.ObjectMeta `json:"metadata,omitempty,omitzero"`
+
+	// spec defines the desired state of Gateway
+	// +required
+	Spec GatewaySpec `json:"spec"`
+
+	// status defines the observed state of Gateway
+	// +optional
+	Status GatewayStatus `json:"status,omitempty,omitzero"`
+}
+
+// +kubebuilder:object:root=true
+
+// GatewayList contains a list of Gateway
+type GatewayList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []Gateway `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&Gateway{}, &GatewayList{})
+}
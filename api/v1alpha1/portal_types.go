@@ -25,6 +25,8 @@ SOFTWARE.
 package v1alpha1
 
 import (
+	corev1 "k8s.io/api/core/v1"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
@@ -33,6 +35,123 @@ import (
 
 // PortalSpec defines the desired state of Portal
 type PortalSpec struct {
+	// DryRun はPortalのリソースを変更せず､適用した場合の差分の計算のみを行うことを示します
+	// 差分は status.dryRun に記録されます
+	// +optional
+	DryRun bool `json:"dryRun,omitempty"`
+	// Suspend はPortalのリソースの作成や更新を一時停止することを示します
+	// 一時停止中もPodの状態は監視され､Suspended Conditionが報告されます
+	// +optional
+	Suspend bool `json:"suspend,omitempty"`
+	// TLS はGatewayが公開するホストのTLS証明書の設定を示します
+	// 指定されない場合､GatewayはHTTPで公開されます
+	// +optional
+	TLS *PortalTLS `json:"tls,omitempty"`
+	// Image はPortalのコンテナイメージを示します
+	// 指定されない場合はチャートのデフォルトが使用されます
+	// +optional
+	Image *PortalImage `json:"image,omitempty"`
+	// Replicas はPortalのPodのレプリカ数を示します
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	Replicas *int32 `json:"replicas,omitempty"`
+	// Resources はPortalのコンテナのリソース要求と制限を示します
+	// +optional
+	Resources *corev1.ResourceRequirements `json:"resources,omitempty"`
+	// BaseDomain はPortalを公開するドメインを示します
+	// 指定された場合はGatewayのホスト名の構築とDNSへの登録にも使用され､コントローラーの --base-domain より優先されます
+	// +kubebuilder:validation:MaxLength=253
+	// +kubebuilder:validation:Pattern=`^[a-z0-9]([-a-z0-9]*[a-z0-9])?(\.[a-z0-9]([-a-z0-9]*[a-z0-9])?)*$`
+	// +optional
+	BaseDomain string `json:"baseDomain,omitempty"`
+	// Ingress はPortalを公開するIngressの設定を示します
+	// +optional
+	Ingress *PortalIngress `json:"ingress,omitempty"`
+	// Values はチャートのvaluesを上書きする任意の値を示します
+	// 他のフィールドから生成したvaluesに再帰的にマージされ､同じキーはこちらが優先されます
+	// +kubebuilder:validation:Type=object
+	// +kubebuilder:pruning:PreserveUnknownFields
+	// +optional
+	Values *apiextensionsv1.JSON `json:"values,omitempty"`
+}
+
+// PortalImage はPortalのコンテナイメージを表します
+type PortalImage struct {
+	// Repository はイメージのリポジトリを示します
+	// +kubebuilder:validation:MinLength=1
+	// +optional
+	Repository string `json:"repository,omitempty"`
+
+	// Tag はイメージのタグを示し､Portalのバージョンとして扱われます
+	// +kubebuilder:validation:MaxLength=128
+	// +kubebuilder:validation:Pattern=`^[A-Za-z0-9_][A-Za-z0-9_.-]*$`
+	// +optional
+	Tag string `json:"tag,omitempty"`
+}
+
+// PortalIngress はPortalを公開するIngressの設定を表します
+type PortalIngress struct {
+	// Enabled はIngressを作成することを示します
+	// +optional
+	Enabled bool `json:"enabled,omitempty"`
+
+	// ClassName は使用するIngressClassの名前を示します
+	// 指定されない場合はクラスタのデフォルトのIngressClassが使用されます
+	// +optional
+	ClassName string `json:"className,omitempty"`
+
+	// Host はIngressのホスト名を示します
+	// 指定されない場合は `portal.<BaseDomain>` が使用され､BaseDomain も指定されない場合はチャートのデフォルトが使用されます
+	// +kubebuilder:validation:MaxLength=253
+	// +kubebuilder:validation:Pattern=`^(\*\.)?[a-z0-9]([-a-z0-9]*[a-z0-9])?(\.[a-z0-9]([-a-z0-9]*[a-z0-9])?)*$`
+	// +optional
+	Host string `json:"host,omitempty"`
+
+	// TLSSecretName はIngressでTLSを終端する証明書のSecretの名前を示します
+	// 指定されない場合､ホスト名が決まっていればTLSは設定されません
+	// +kubebuilder:validation:MaxLength=253
+	// +optional
+	TLSSecretName string `json:"tlsSecretName,omitempty"`
+
+	// Annotations はIngressに付与するアノテーションを示します
+	// +optional
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// PortalTLS はGatewayのTLS証明書の発行方法を表します
+// IssuerRef と WildcardCertificateSecret の両方が指定された場合は､
+// cert-managerがクラスタに存在する場合のみ IssuerRef が使用されます
+type PortalTLS struct {
+	// IssuerRef はcert-managerのCertificateで証明書を発行するIssuerを示します
+	// +optional
+	IssuerRef *CertificateIssuerReference `json:"issuerRef,omitempty"`
+
+	// WildcardCertificateSecret はGatewayのホストを含むワイルドカード証明書のSecretを示します
+	// CopyWildcardCertificate が有効でない場合は秘密鍵をGatewayのnamespaceにコピーしないため､
+	// IngressコントローラーのデフォルトのTLS証明書として同じSecretを設定する必要があります
+	// HTTPRouteの場合は接続先のGatewayのリスナーで証明書を設定します
+	// +optional
+	WildcardCertificateSecret *NamespacedName `json:"wildcardCertificateSecret,omitempty"`
+
+	// CopyWildcardCertificate はワイルドカード証明書をGatewayのnamespaceにコピーし､Ingressから参照するかを示します
+	// 秘密鍵がテナントのnamespaceに複製されるため､明示的に有効にする必要があります
+	// 無効の場合はIngressコントローラーのデフォルト証明書が同じ証明書であると仮定し､実際に提供される証明書は検証しません
+	// +optional
+	CopyWildcardCertificate bool `json:"copyWildcardCertificate,omitempty"`
+}
+
+// CertificateIssuerReference はcert-managerのIssuerまたはClusterIssuerへの参照を表します
+type CertificateIssuerReference struct {
+	// Name はIssuerの名前を示します
+	// +kubebuilder:validation:MinLength=1
+	Name string `json:"name"`
+
+	// Kind はIssuerの種類を示します
+	// Issuerの場合はGatewayと同じnamespaceに存在する必要があります
+	// +kubebuilder:validation:Enum=Issuer;ClusterIssuer
+	// +kubebuilder:default=ClusterIssuer
+	// +optional
+	Kind string `json:"kind,omitempty"`
 }
 
 // PortalStatus defines the observed state of Portal.
@@ -52,6 +171,62 @@ type PortalStatus struct {
 	Conditions []metav1.Condition `json:"conditions,omitempty"`
 
 	State string `json:"state,omitempty"`
+
+	// ObservedGeneration はチャートをレンダリングしたときのmetadata.generationを示します
+	// specが更新されると再レンダリングされます
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// Pods はPortalのPodとその状態を示します
+	// +optional
+	Pods []PodReference `json:"pods,omitempty"`
+
+	// DryRun はdry-runで計算した差分を示します
+	// dry-runが指定されていない場合は記録されません
+	// +optional
+	DryRun *DryRunStatus `json:"dryRun,omitempty"`
+
+	// Networking はクラスタで利用可能なIngressとGateway APIのコントローラーを示します
+	// Gatewayの spec.implementation が指定されない場合に､使用する実装の選択に利用されます
+	// +optional
+	Networking *NetworkingStatus `json:"networking,omitempty"`
+}
+
+// NetworkingStatus はクラスタで検出したIngressとGateway APIの利用可否を表します
+type NetworkingStatus struct {
+	// Ingress は networking.k8s.io/v1 のIngressとIngressClassの利用可否を示します
+	Ingress NetworkingAPIStatus `json:"ingress"`
+
+	// GatewayAPI はGateway APIのHTTPRouteとGatewayClassの利用可否を示します
+	GatewayAPI NetworkingAPIStatus `json:"gatewayAPI"`
+
+	// LastDiscoveredTime は最後に検出を行った時刻を示します
+	// +optional
+	LastDiscoveredTime *metav1.Time `json:"lastDiscoveredTime,omitempty"`
+}
+
+// NetworkingAPIStatus はルーティングのAPIがクラスタで提供されているかと､そのコントローラーを表します
+type NetworkingAPIStatus struct {
+	// Available はAPIがクラスタで提供されていることを示します
+	Available bool `json:"available"`
+
+	// Classes はクラスタに存在するIngressClassまたはGatewayClassを示します
+	// +optional
+	Classes []NetworkingClass `json:"classes,omitempty"`
+}
+
+// NetworkingClass はIngressClassまたはGatewayClassを表します
+type NetworkingClass struct {
+	// Name はクラスの名前を示します
+	Name string `json:"name"`
+
+	// Controller はクラスを実装するコントローラーの名前を示します
+	// +optional
+	Controller string `json:"controller,omitempty"`
+
+	// Default はデフォルトのIngressClassであることを示します
+	// +optional
+	Default bool `json:"default,omitempty"`
 }
 
 const (
@@ -71,6 +246,9 @@ const (
 // +kubebuilder:subresource:status
 // +kubebuilder:resource:scope=Cluster
 // +kubebuilder:printcolumn:name="STATE",type=string,JSONPath=`.status.state`,description="Current state of the Portal"
+// +kubebuilder:printcolumn:name="VERSION",type=string,JSONPath=`.spec.image.tag`,description="Image tag of the Portal",priority=1
+// +kubebuilder:printcolumn:name="SUSPENDED",type=string,JSONPath=`.status.conditions[?(@.type=="Suspended")].status`,description="Whether the Portal is suspended",priority=1
+// +kubebuilder:printcolumn:name="DRYRUN",type=string,JSONPath=`.status.dryRun.summary`,description="Changes computed by the dry-run",priority=1
 // +kubebuilder:printcolumn:name="MESSAGE",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].message`,description="Status message",priority=1
 // +kubebuilder:printcolumn:name="AGE",type=date,JSONPath=`.metadata.creationTimestamp`
 
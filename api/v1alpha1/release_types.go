@@ -25,6 +25,7 @@ SOFTWARE.
 package v1alpha1
 
 import (
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
@@ -37,16 +38,216 @@ type ReleaseSpec struct {
 	// AppConfigBranch はappconfigが格納されているGitブランチを示します
 	// 何も指定されない場合はmainが使用されます
 	AppConfigBranch string `json:"appConfigBranch,omitempty"`
+	// Stage はReleaseが属するappconfigのStage名を示します
+	// appconfigのStageごとの上書き設定の選択に使用されます
+	// +optional
+	Stage string `json:"stage,omitempty"`
 	// Commit はReleaseに使用するGitコミットハッシュを示します
 	Commit *string `json:"commit,omitempty"`
 	// APIでアプリケーションに対し環境変数をセットされたときに、
 	// それが格納されたSecretが存在する仮定する
+	// Applicationが作成するReleaseでは､Stageごとに ApplicationSpec.StageSecrets から決定されます
 	EnvSecretName *string `json:"envSecretName,omitempty"`
+	// DecryptionKeySecretName はappconfigの暗号化された環境変数のファイルを復号する秘密鍵を格納したSecretを示します
+	// Secretの全てのキーの値が秘密鍵として使用され､いずれかで復号できれば良い
+	// 何も指定されない場合は DefaultDecryptionKeySecretName が使用されます
+	// +optional
+	DecryptionKeySecretName *string `json:"decryptionKeySecretName,omitempty"`
+	// ImagePullSecrets はコンテナイメージの取得に使用するSecretを示します
+	// イメージのダイジェスト解決にも同じ認証情報が使用されます
+	// +optional
+	ImagePullSecrets []corev1.LocalObjectReference `json:"imagePullSecrets,omitempty"`
+	// DryRun はReleaseのリソースを変更せず､適用した場合の差分の計算のみを行うことを示します
+	// 差分は status.dryRun に記録されます
+	// +optional
+	DryRun bool `json:"dryRun,omitempty"`
+	// Suspend はReleaseのデプロイを一時停止することを示します
+	// 一時停止中もPodの状態は監視され､Suspended Conditionが報告されます
+	// +optional
+	Suspend bool `json:"suspend,omitempty"`
+	// Strategy はワークロードを新しいコミットに更新する方法を示します
+	// 何も指定されない場合はDeploymentのローリングアップデートで一度に更新されます
+	// +optional
+	Strategy *ReleaseStrategy `json:"strategy,omitempty"`
+	// RollbackTo はデプロイするReleaseRevisionのリビジョン番号を示します
+	// 指定された場合はgitを参照せず､リビジョンに記録されたマニフェストをそのまま再適用します
+	// pre-deployフック､カナリアリリース､プレビューは実行されません
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	RollbackTo *int64 `json:"rollbackTo,omitempty"`
+	// RevisionHistoryLimit は保持するReleaseRevisionの数を示します
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:default=10
+	// +optional
+	RevisionHistoryLimit *int32 `json:"revisionHistoryLimit,omitempty"`
+}
+
+// ReleaseStrategy はワークロードの更新方法を示します
+// +kubebuilder:validation:XValidation:rule="self.type != 'Canary' || has(self.canary)",message="canary is required when type is Canary"
+// +kubebuilder:validation:XValidation:rule="self.type != 'BlueGreen' || has(self.blueGreen)",message="blueGreen is required when type is BlueGreen"
+type ReleaseStrategy struct {
+	// Type は更新方法の種類を示します
+	// +kubebuilder:validation:Enum=RollingUpdate;Canary;BlueGreen
+	// +kubebuilder:default=RollingUpdate
+	Type string `json:"type"`
+	// Canary はカナリアリリースの手順を示します
+	// +optional
+	Canary *CanaryStrategy `json:"canary,omitempty"`
+	// BlueGreen はブルーグリーンデプロイの手順を示します
+	// +optional
+	BlueGreen *BlueGreenStrategy `json:"blueGreen,omitempty"`
+}
+
+// DefaultDecryptionKeySecretName はReleaseと同じNamespaceに存在する､秘密鍵を格納したSecretのデフォルトの名前
+const DefaultDecryptionKeySecretName = "portal-decryption-keys"
+
+const (
+	// ReleaseStrategyRollingUpdate はDeploymentのローリングアップデートで一度に更新することを示します
+	ReleaseStrategyRollingUpdate = "RollingUpdate"
+	// ReleaseStrategyCanary はカナリア用のDeploymentを段階的に拡大し､分析が成功した場合のみ更新することを示します
+	ReleaseStrategyCanary = "Canary"
+	// ReleaseStrategyBlueGreen は新しいコミットを別のDeploymentとして起動し､検証後にServiceを切り替えることを示します
+	ReleaseStrategyBlueGreen = "BlueGreen"
+)
+
+// BlueGreenStrategy はブルーグリーンデプロイの手順を示します
+type BlueGreenStrategy struct {
+	// SmokeTest はServiceを切り替える前にプレビューに対して実行するテストを示します
+	// 何も指定されない場合はプレビューのPodがReadyになった時点で切り替えられます
+	// +optional
+	SmokeTest *SmokeTest `json:"smokeTest,omitempty"`
+	// ScaleDownDelay は切り替え後に以前のcolorのDeploymentを残しておく時間を示します
+	// この間にspec.commitを以前のコミットに戻すと､Serviceのみが即座に切り替えられます
+	// +optional
+	ScaleDownDelay *metav1.Duration `json:"scaleDownDelay,omitempty"`
+}
+
+// SmokeTest はプレビューに対して実行するJobを示します
+// コンテナはプレビューと同じイメージと環境変数で起動され､
+// PREVIEW_SERVICE_HOST にプレビュー用のServiceのホスト名が設定されます
+type SmokeTest struct {
+	// Command はテストのコマンドを示します
+	// +kubebuilder:validation:MinItems=1
+	Command []string `json:"command"`
+}
+
+const (
+	// BlueGreenColorBlue はブルーグリーンデプロイの一方のcolorを示します
+	BlueGreenColorBlue = "blue"
+	// BlueGreenColorGreen はブルーグリーンデプロイの一方のcolorを示します
+	BlueGreenColorGreen = "green"
+)
+
+// CanaryStrategy はカナリアリリースの手順を示します
+type CanaryStrategy struct {
+	// Steps はカナリアを拡大する手順を示します
+	// 全てのステップの分析が成功した場合に新しいコミットへ切り替えられます
+	// +kubebuilder:validation:MinItems=1
+	Steps []CanaryStep `json:"steps"`
+	// Analysis は各ステップの終わりに行う分析を示します
+	// 何も指定されない場合はステップの待機のみが行われます
+	// +optional
+	Analysis *CanaryAnalysis `json:"analysis,omitempty"`
+}
+
+// CanaryStep はカナリアリリースの1つのステップを示します
+type CanaryStep struct {
+	// Weight は現在のDeploymentのレプリカ数に対するカナリアのレプリカ数の割合(%)を示します
+	// 両方のPodが同じServiceに属するため､トラフィックはおおよそレプリカ数の比で分配されます
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=100
+	Weight int32 `json:"weight"`
+	// Pause はカナリアのPodが利用可能になってから分析を行うまでに待機する時間を示します
+	// +optional
+	Pause *metav1.Duration `json:"pause,omitempty"`
+}
+
+// CanaryAnalysis はカナリアの分析方法を示します
+type CanaryAnalysis struct {
+	// Address はPrometheus互換のAPIのベースURLを示します
+	// +kubebuilder:validation:MinLength=1
+	Address string `json:"address"`
+	// Metrics は評価するメトリクスを示します
+	// +kubebuilder:validation:MinItems=1
+	Metrics []CanaryMetric `json:"metrics"`
+	// Timeout は分析を評価できない状態が続いた場合に､カナリアをロールバックするまでの時間を示します
+	// 指定されない場合は5分です
+	// +optional
+	Timeout *metav1.Duration `json:"timeout,omitempty"`
+}
+
+// CanaryMetric はカナリアの分析に使用するメトリクスと閾値を示します
+// +kubebuilder:validation:XValidation:rule="has(self.min) || has(self.max)",message="at least one of min or max is required"
+type CanaryMetric struct {
+	// Name はメトリクスの名前を示します
+	Name string `json:"name"`
+	// Query はスカラー値を返すPromQLのクエリを示します
+	// {{ .Namespace }}, {{ .Release }}, {{ .Canary }}, {{ .Commit }} はそれぞれ
+	// Releaseの名前空間､Releaseの名前､カナリアのDeploymentの名前､コミットに置換されます
+	Query string `json:"query"`
+	// Min はクエリの結果が満たすべき最小値を示します
+	// +kubebuilder:validation:Pattern=`^-?[0-9]+(\.[0-9]+)?$`
+	// +optional
+	Min *string `json:"min,omitempty"`
+	// Max はクエリの結果が満たすべき最大値を示します
+	// +kubebuilder:validation:Pattern=`^-?[0-9]+(\.[0-9]+)?$`
+	// +optional
+	Max *string `json:"max,omitempty"`
 }
 
 // ReleaseStatus defines the observed state of Release.
 type ReleaseStatus struct {
 	State string `json:"state,omitempty"`
+
+	// ObservedGeneration はデプロイを開始したときのmetadata.generationを示します
+	// Deployed状態のReleaseは､specが更新されるまで再デプロイされません
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// QOSClass はデプロイされたワークロードのPodに割り当てられるQoSクラスを示します
+	// +optional
+	QOSClass corev1.PodQOSClass `json:"qosClass,omitempty"`
+	// Image はデプロイに使用したコンテナイメージを示します
+	// +optional
+	Image *ReleaseImageStatus `json:"image,omitempty"`
+	// Processes はappconfigの `processes` から作成されたワークロードを示します
+	// +optional
+	Processes []corev1.ObjectReference `json:"processes,omitempty"`
+	// EncryptedEnvSecretName はappconfigの暗号化された環境変数を復号して格納したSecretの名前を示します
+	// 名前は暗号化ファイルの内容から決まるため､ファイルが変わるたびに新しいSecretが作成されます
+	// +optional
+	EncryptedEnvSecretName string `json:"encryptedEnvSecretName,omitempty"`
+	// ServiceBindings はワークロードに接続情報を注入したServiceBindingを示します
+	// +optional
+	ServiceBindings []BoundServiceBinding `json:"serviceBindings,omitempty"`
+	// ServiceBindingsHash はServiceBindingと､そのSecretのうち参照するキーの値からReleaseごとのキーで計算したHMACを示します
+	// Secretが更新された場合はワークロードを再デプロイし､新しい接続情報を読み込ませます
+	// +optional
+	ServiceBindingsHash string `json:"serviceBindingsHash,omitempty"`
+	// DeployedCommit はロールアウトが完了した最後のコミットを示します
+	// カナリアリリースが失敗した場合はこのコミットのワークロードが使用され続けます
+	// +optional
+	DeployedCommit string `json:"deployedCommit,omitempty"`
+	// DeployedAt は DeployedCommit のロールアウトが完了した時刻を示します
+	// +optional
+	DeployedAt *metav1.Time `json:"deployedAt,omitempty"`
+	// Revision は最後に適用したReleaseRevisionのリビジョン番号を示します
+	// +optional
+	Revision int64 `json:"revision,omitempty"`
+	// Canary は進行中または最後に行われたカナリアリリースの状態を示します
+	// +optional
+	Canary *CanaryStatus `json:"canary,omitempty"`
+	// BlueGreen はブルーグリーンデプロイの状態を示します
+	// +optional
+	BlueGreen *BlueGreenStatus `json:"blueGreen,omitempty"`
+	// DryRun はdry-runで計算した差分を示します
+	// dry-runが指定されていない場合は記録されません
+	// +optional
+	DryRun *DryRunStatus `json:"dryRun,omitempty"`
+	// Pods はReleaseのワークロードのPodとその状態を示します
+	// CronJobとpre-deployフックのPodは含まれません
+	// +optional
+	Pods []PodReference `json:"pods,omitempty"`
 	// The status of each condition is one of True, False, or Unknown.
 	// +listType=map
 	// +listMapKey=type
@@ -54,12 +255,98 @@ type ReleaseStatus struct {
 	Conditions []metav1.Condition `json:"conditions,omitempty"`
 }
 
+// ReleaseImageStatus はタグからダイジェストへの解決結果を示します
+type ReleaseImageStatus struct {
+	// Reference はappconfigに記述されたイメージの参照を示します
+	Reference string `json:"reference"`
+	// Digest はReferenceを解決したダイジェストを示します
+	Digest string `json:"digest"`
+	// Commit はダイジェストを解決したときのspec.commitを示します
+	// 同じコミットのReleaseでは､タグが更新されても同じダイジェストが使用されます
+	Commit string `json:"commit"`
+}
+
+// BoundServiceBinding はReleaseに注入されたServiceBindingを示します
+type BoundServiceBinding struct {
+	// Name はServiceBindingの名前を示します
+	Name string `json:"name"`
+	// SecretName は接続情報を格納したSecretの名前を示します
+	SecretName string `json:"secretName"`
+	// Env はSecretのキーと環境変数の名前の対応を示します
+	Env []ServiceBindingEnv `json:"env"`
+}
+
+// CanaryStatus はカナリアリリースの状態を示します
+type CanaryStatus struct {
+	// Commit はカナリアとしてデプロイされたコミットを示します
+	Commit string `json:"commit"`
+	// Phase はカナリアリリースの進行状況を示します
+	Phase string `json:"phase"`
+	// Step は実行中のステップのインデックスを示します
+	Step int32 `json:"step"`
+	// StepStartedAt は実行中のステップのカナリアのPodが利用可能になった時刻を示します
+	// +optional
+	StepStartedAt *metav1.Time `json:"stepStartedAt,omitempty"`
+	// AnalysisFailingSince は分析を評価できない状態が始まった時刻を示します
+	// 分析が評価できた時点でクリアされます
+	// +optional
+	AnalysisFailingSince *metav1.Time `json:"analysisFailingSince,omitempty"`
+	// Message はカナリアリリースの状態の詳細を示します
+	// +optional
+	Message string `json:"message,omitempty"`
+}
+
+// BlueGreenStatus はブルーグリーンデプロイの状態を示します
+type BlueGreenStatus struct {
+	// ActiveColor はServiceがトラフィックを流しているDeploymentのcolorを示します
+	ActiveColor string `json:"activeColor"`
+	// PreviewColor は検証中､または切り替え前に使用されていたDeploymentのcolorを示します
+	// +optional
+	PreviewColor string `json:"previewColor,omitempty"`
+	// PreviewCommit はPreviewColorのDeploymentのコミットを示します
+	// +optional
+	PreviewCommit string `json:"previewCommit,omitempty"`
+	// PreviewVerified はPreviewColorのDeploymentが検証済みであり､即座に切り替えられることを示します
+	// +optional
+	PreviewVerified bool `json:"previewVerified,omitempty"`
+	// ScaleDownAt はPreviewColorのDeploymentを削除する時刻を示します
+	// +optional
+	ScaleDownAt *metav1.Time `json:"scaleDownAt,omitempty"`
+}
+
+const (
+	// CanaryPhaseProgressing はカナリアのステップを実行中であることを示します
+	CanaryPhaseProgressing = "Progressing"
+	// CanaryPhasePromoted は全てのステップが成功し､新しいコミットに切り替えたことを示します
+	CanaryPhasePromoted = "Promoted"
+	// CanaryPhaseFailed は分析が失敗し､カナリアを削除したことを示します
+	CanaryPhaseFailed = "Failed"
+)
+
 const (
 	// ReleaseStateDeploying は差分検知などによって遷移し､
 	// Releaseのリソースをデプロイ中であることを示します
 	ReleaseStateDeploying = "Deploying"
 
-	// ReleaseStateDeployed はReleaseのリソースが正常にデプロイされたことを示します
+	// ReleaseStateRollingOut はReleaseのリソースを適用し､
+	// ワークロードのロールアウトが完了するのを待っていることを示します
+	ReleaseStateRollingOut = "RollingOut"
+
+	// ReleaseStateCanary はカナリアのDeploymentを段階的に拡大し､
+	// 各ステップの分析を行っていることを示します
+	ReleaseStateCanary = "Canary"
+
+	// ReleaseStateRolledBack はカナリアの分析が失敗し､
+	// 以前のコミットのワークロードに戻したことを示します
+	// specが更新されるまで再デプロイされません
+	ReleaseStateRolledBack = "RolledBack"
+
+	// ReleaseStatePreviewing はブルーグリーンデプロイのプレビューのDeploymentを起動し､
+	// ReadinessとスモークテストによるServiceの切り替え前の検証を行っていることを示します
+	ReleaseStatePreviewing = "Previewing"
+
+	// ReleaseStateDeployed はReleaseのリソースが正常にデプロイされ､
+	// ワークロードのロールアウトが完了したことを示します
 	ReleaseStateDeployed = "Deployed"
 
 	// ReleaseStateFailed はReleaseのリソースのデプロイが失敗したことを示します
@@ -69,8 +356,19 @@ const (
 // +kubebuilder:object:root=true
 // +kubebuilder:subresource:status
 // +kubebuilder:printcolumn:name="STATE",type=string,JSONPath=`.status.state`,description="Current state of the Release"
+// +kubebuilder:printcolumn:name="STAGE",type=string,JSONPath=`.spec.stage`,description="Stage of the Application"
 // +kubebuilder:printcolumn:name="REPO",type=string,JSONPath=`.spec.repo.url`,description="Repository URL",priority=1
 // +kubebuilder:printcolumn:name="COMMIT",type=string,JSONPath=`.spec.commit`,description="Git commit hash"
+// +kubebuilder:printcolumn:name="SUSPENDED",type=string,JSONPath=`.status.conditions[?(@.type=="Suspended")].status`,description="Whether the Release is suspended",priority=1
+// +kubebuilder:printcolumn:name="REVISION",type=integer,JSONPath=`.status.revision`,description="Last applied revision"
+// +kubebuilder:printcolumn:name="DEPLOYED",type=string,JSONPath=`.status.deployedCommit`,description="Last commit that was rolled out",priority=1
+// +kubebuilder:printcolumn:name="CANARY",type=string,JSONPath=`.status.canary.phase`,description="Phase of the canary release",priority=1
+// +kubebuilder:printcolumn:name="ACTIVE",type=string,JSONPath=`.status.blueGreen.activeColor`,description="Active color of the blue/green deployment",priority=1
+// +kubebuilder:printcolumn:name="PREVIEW",type=string,JSONPath=`.status.blueGreen.previewColor`,description="Preview color of the blue/green deployment",priority=1
+// +kubebuilder:printcolumn:name="DRYRUN",type=string,JSONPath=`.status.dryRun.summary`,description="Changes computed by the dry-run",priority=1
+// +kubebuilder:printcolumn:name="DIGEST",type=string,JSONPath=`.status.image.digest`,description="Resolved image digest",priority=1
+// +kubebuilder:printcolumn:name="PROCESSES",type=string,JSONPath=`.status.processes[*].name`,description="Additional process workloads",priority=1
+// +kubebuilder:printcolumn:name="QOS",type=string,JSONPath=`.status.qosClass`,description="QoS class of the workload pods",priority=1
 // +kubebuilder:printcolumn:name="MESSAGE",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].message`,description="Status message",priority=1
 // +kubebuilder:printcolumn:name="AGE",type=date,JSONPath=`.metadata.creationTimestamp`
 
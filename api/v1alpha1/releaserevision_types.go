@@ -0,0 +1,117 @@
+/*
+MIT License
+
+Copyright (c) 2025 tacokumo
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ReleaseRevisionSpec defines the desired state of ReleaseRevision
+type ReleaseRevisionSpec struct {
+	// Release はスナップショットを作成したReleaseの名前を示します
+	// +kubebuilder:validation:MinLength=1
+	Release string `json:"release"`
+	// Revision はRelease内で単調に増加するリビジョン番号を示します
+	// +kubebuilder:validation:Minimum=1
+	Revision int64 `json:"revision"`
+	// Commit はデプロイしたGitコミットハッシュを示します
+	Commit string `json:"commit"`
+	// Image はデプロイしたコンテナイメージとそのダイジェストを示します
+	// +optional
+	Image *ReleaseImageStatus `json:"image,omitempty"`
+	// EncryptedEnvSecretName はワークロードが参照する､暗号化された環境変数を復号したSecretの名前を示します
+	// このリビジョンが存在する間はSecretも保持されます
+	// +optional
+	EncryptedEnvSecretName string `json:"encryptedEnvSecretName,omitempty"`
+	// Processes はManifestsのうちappconfigの `processes` から作成されたワークロードを示します
+	// +optional
+	Processes []ReleaseRevisionProcess `json:"processes,omitempty"`
+	// Manifests は適用したマニフェストをgzipで圧縮したものを示します
+	// ロールバック時にはgitを参照せず､このマニフェストがそのまま再適用されます
+	// ブルーグリーンデプロイのcolorは適用時のReleaseの状態に合わせて反映されるため含まれません
+	Manifests []byte `json:"manifests"`
+	// Values はチャートのレンダリングに使用したvaluesのJSONをgzipで圧縮したものを示します
+	// +optional
+	Values []byte `json:"values,omitempty"`
+}
+
+// ReleaseRevisionProcess はスナップショットに含まれるプロセスのワークロードを示します
+type ReleaseRevisionProcess struct {
+	// APIVersion はワークロードのAPIバージョンを示します
+	APIVersion string `json:"apiVersion"`
+	// Kind はワークロードの種類を示します
+	Kind string `json:"kind"`
+	// Name はワークロードの名前を示します
+	Name string `json:"name"`
+}
+
+// ReleaseRevisionStatus defines the observed state of ReleaseRevision.
+type ReleaseRevisionStatus struct {
+	// DeployedAt はこのリビジョンのロールアウトが完了した時刻を示します
+	// ロールバックできるのはロールアウトが完了したリビジョンのみです
+	// +optional
+	DeployedAt *metav1.Time `json:"deployedAt,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="RELEASE",type=string,JSONPath=`.spec.release`,description="Name of the Release"
+// +kubebuilder:printcolumn:name="REVISION",type=integer,JSONPath=`.spec.revision`,description="Revision number"
+// +kubebuilder:printcolumn:name="COMMIT",type=string,JSONPath=`.spec.commit`,description="Git commit hash"
+// +kubebuilder:printcolumn:name="DEPLOYED",type=date,JSONPath=`.status.deployedAt`,description="When the rollout of this revision completed"
+// +kubebuilder:printcolumn:name="AGE",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// ReleaseRevision is the Schema for the releaserevisions API
+// ReleaseRevision はReleaseのデプロイごとに作成される変更不可能なスナップショットです
+// Releaseの spec.rollbackTo で指定することで､gitを参照せずにロールバックできます
+type ReleaseRevision struct {
+	metav1.TypeMeta `json:",inline"`
+
+	// metadata is a standard object metadata
+	// +optional
+	metav1.ObjectMeta `json:"metadata,omitempty,omitzero"`
+
+	// spec defines the desired state of ReleaseRevision
+	// +kubebuilder:validation:XValidation:rule="self == oldSelf",message="spec is immutable"
+	// +required
+	Spec ReleaseRevisionSpec `json:"spec"`
+
+	// status defines the observed state of ReleaseRevision
+	// +optional
+	Status ReleaseRevisionStatus `json:"status,omitempty,omitzero"`
+}
+
+// +kubebuilder:object:root=true
+
+// ReleaseRevisionList contains a list of ReleaseRevision
+type ReleaseRevisionList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []ReleaseRevision `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&ReleaseRevision{}, &ReleaseRevisionList{})
+}
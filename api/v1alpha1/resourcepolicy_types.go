@@ -0,0 +1,86 @@
+/*
+MIT License
+
+Copyright (c) 2025 tacokumo
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package v1alpha1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ResourcePolicySpec defines the desired state of ResourcePolicy
+type ResourcePolicySpec struct {
+	// Flavors はappconfigの `service.machine_config.flavor` から参照できるリソースの組を示します
+	// +listType=map
+	// +listMapKey=name
+	// +optional
+	Flavors []ResourceFlavor `json:"flavors,omitempty"`
+
+	// MaxLimitRequestRatio はリソースごとのlimit/requestの比率の上限を示します
+	// 複数のResourcePolicyが存在する場合は最も小さい値が使用されます
+	// +optional
+	MaxLimitRequestRatio corev1.ResourceList `json:"maxLimitRequestRatio,omitempty"`
+}
+
+// ResourceFlavor は管理者が定義するリソースの組を表します
+type ResourceFlavor struct {
+	// Name はFlavorの名前を示します
+	// +kubebuilder:validation:MinLength=1
+	Name string `json:"name"`
+
+	// Resources はFlavorが割り当てるrequests/limitsを示します
+	Resources corev1.ResourceRequirements `json:"resources"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:resource:scope=Cluster
+// +kubebuilder:printcolumn:name="AGE",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// ResourcePolicy is the Schema for the resourcepolicies API
+// ResourcePolicy は管理者がクラスタ全体に対して定義するリソース割り当てのポリシーです
+// Releaseのデプロイ時に参照されます
+type ResourcePolicy struct {
+	metav1.TypeMeta `json:",inline"`
+
+	// metadata is a standard object metadata
+	// +optional
+	metav1.ObjectMeta `json:"metadata,omitempty,omitzero"`
+
+	// spec defines the desired state of ResourcePolicy
+	// +required
+	Spec ResourcePolicySpec `json:"spec"`
+}
+
+// +kubebuilder:object:root=true
+
+// ResourcePolicyList contains a list of ResourcePolicy
+type ResourcePolicyList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []ResourcePolicy `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&ResourcePolicy{}, &ResourcePolicyList{})
+}
@@ -0,0 +1,119 @@
+/*
+MIT License
+
+Copyright (c) 2025 tacokumo
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ServiceBindingSpec defines the desired state of ServiceBinding
+type ServiceBindingSpec struct {
+	// Application は接続情報を注入するApplicationの名前を示します
+	// ApplicationはServiceBindingと同じnamespaceに存在する必要があります
+	// +kubebuilder:validation:MinLength=1
+	Application string `json:"application"`
+
+	// Stages は接続情報を注入するStageの名前を示します
+	// 指定されない場合はApplicationの全てのStageに注入されます
+	// +optional
+	Stages []string `json:"stages,omitempty"`
+
+	// SecretName はマネージドサービスのプロバイダが作成した､接続情報を格納したSecretの名前を示します
+	// SecretはServiceBindingと同じnamespaceに存在する必要があります
+	// +kubebuilder:validation:MinLength=1
+	SecretName string `json:"secretName"`
+
+	// Env はSecretのキーと､その値を設定する環境変数の名前の対応を示します
+	// +kubebuilder:validation:MinItems=1
+	// +listType=map
+	// +listMapKey=name
+	Env []ServiceBindingEnv `json:"env"`
+}
+
+// ServiceBindingEnv はSecretのキーを環境変数に対応させる
+type ServiceBindingEnv struct {
+	// Name はアプリケーションが参照する環境変数の名前を示します(例: DB_HOST)
+	// +kubebuilder:validation:Pattern=`^[A-Za-z_][A-Za-z0-9_]*$`
+	Name string `json:"name"`
+	// Key は環境変数に設定する値を格納したSecretのキーを示します(例: host)
+	// +kubebuilder:validation:MinLength=1
+	Key string `json:"key"`
+}
+
+// ServiceBindingStatus defines the observed state of ServiceBinding.
+type ServiceBindingStatus struct {
+	// The status of each condition is one of True, False, or Unknown.
+	// +listType=map
+	// +listMapKey=type
+	// +optional
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+
+	// ObservedGeneration は最後に処理したmetadata.generationを示します
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// BoundReleases は接続情報が注入されるReleaseの名前を示します
+	// +optional
+	BoundReleases []string `json:"boundReleases,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="APPLICATION",type=string,JSONPath=`.spec.application`,description="Application to inject connection info into"
+// +kubebuilder:printcolumn:name="SECRET",type=string,JSONPath=`.spec.secretName`,description="Secret of the managed service"
+// +kubebuilder:printcolumn:name="READY",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].status`,description="Whether the Secret has all mapped keys"
+// +kubebuilder:printcolumn:name="RELEASES",type=string,JSONPath=`.status.boundReleases[*]`,description="Bound Releases",priority=1
+// +kubebuilder:printcolumn:name="MESSAGE",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].message`,description="Status message",priority=1
+// +kubebuilder:printcolumn:name="AGE",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// ServiceBinding is the Schema for the servicebindings API
+type ServiceBinding struct {
+	metav1.TypeMeta `json:",inline"`
+
+	// metadata is a standard object metadata
+	// +optional
+	metav1.ObjectMeta `json:"metadata,omitempty,omitzero"`
+
+	// spec defines the desired state of ServiceBinding
+	// +required
+	Spec ServiceBindingSpec `json:"spec"`
+
+	// status defines the observed state of ServiceBinding
+	// +optional
+	Status ServiceBindingStatus `json:"status,omitempty,omitzero"`
+}
+
+// +kubebuilder:object:root=true
+
+// ServiceBindingList contains a list of ServiceBinding
+type ServiceBindingList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []ServiceBinding `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&ServiceBinding{}, &ServiceBindingList{})
+}
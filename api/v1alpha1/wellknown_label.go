@@ -2,6 +2,36 @@ package v1alpha1
 
 const (
 	ManagedByLabelKey = "tacokumo.github.io/managed-by"
+
+	// ReleaseLabelKey はReleaseRevisionを作成したReleaseの名前を示します
+	ReleaseLabelKey = "tacokumo.github.io/release"
+
+	// ApplicationLabelKey はReleaseを所有するApplicationの名前を示します
+	ApplicationLabelKey = "tacokumo.github.io/application"
+
+	// TenantLabelKey はApplicationが属するテナントの名前を示します
+	// 指定されない場合はApplicationのnamespaceがテナントとして扱われます
+	TenantLabelKey = "tacokumo.github.io/tenant"
+
+	// GatewayDNSFinalizer はGatewayの削除前に､登録したDNSレコードを削除するためのfinalizer
+	GatewayDNSFinalizer = "tacokumo.github.io/dns-records"
+
+	// DryRunAnnotationKey は値が "true" の場合に､リソースを変更せず差分の計算のみを行うことを示します
+	// spec.dryRun と同じ意味を持ちます
+	DryRunAnnotationKey = "tacokumo.github.io/dry-run"
+
+	// ApplicationSuspendedAnnotationKey は値が "true" の場合に､
+	// Releaseを所有するApplicationが一時停止されていることを示します
+	// Applicationのコントローラーによって付与､削除されます
+	ApplicationSuspendedAnnotationKey = "tacokumo.github.io/application-suspended"
+
+	// ServiceBindingsHashAnnotationKey はワークロードのPodテンプレートに付与され､
+	// 注入したServiceBindingの接続情報が変わった場合にPodを再作成させます
+	ServiceBindingsHashAnnotationKey = "tacokumo.github.io/service-bindings-hash"
+
+	// PromotionApprovalAnnotationPrefix にStage名を付けたキーのアノテーションは､
+	// 値のコミットをそのStageにプロモーションすることを承認したことを示します
+	PromotionApprovalAnnotationPrefix = "promotion.tacokumo.github.io/"
 )
 
 func IsManagedByTacoKumo(labels map[string]string) bool {
@@ -10,3 +40,13 @@ func IsManagedByTacoKumo(labels map[string]string) bool {
 	}
 	return false
 }
+
+// IsSuspended はspecまたは所有するApplicationによって一時停止が指定されているかどうかを返す
+func IsSuspended(annotations map[string]string, specSuspend bool) bool {
+	return specSuspend || annotations[ApplicationSuspendedAnnotationKey] == "true"
+}
+
+// IsDryRun はアノテーションまたはspecでdry-runが指定されているかどうかを返す
+func IsDryRun(annotations map[string]string, specDryRun bool) bool {
+	return specDryRun || annotations[DryRunAnnotationKey] == "true"
+}
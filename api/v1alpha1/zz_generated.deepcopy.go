@@ -30,6 +30,7 @@ package v1alpha1
 
 import (
 	corev1 "k8s.io/api/core/v1"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1"
 	runtime "k8s.io/apimachinery/pkg/runtime"
 )
@@ -97,6 +98,18 @@ func (in *ApplicationList) DeepCopyObject() runtime.Object {
 func (in *ApplicationSpec) DeepCopyInto(out *ApplicationSpec) {
 	*out = *in
 	in.ReleaseTemplate.DeepCopyInto(&out.ReleaseTemplate)
+	if in.Promotions != nil {
+		in, out := &in.Promotions, &out.Promotions
+		*out = make([]StagePromotion, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.StageSecrets != nil {
+		in, out := &in.StageSecrets, &out.StageSecrets
+		*out = make([]StageSecret, len(*in))
+		copy(*out, *in)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ApplicationSpec.
@@ -124,6 +137,18 @@ func (in *ApplicationStatus) DeepCopyInto(out *ApplicationStatus) {
 		*out = make([]corev1.ObjectReference, len(*in))
 		copy(*out, *in)
 	}
+	if in.PendingPromotions != nil {
+		in, out := &in.PendingPromotions, &out.PendingPromotions
+		*out = make([]PendingPromotion, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.MissingSecrets != nil {
+		in, out := &in.MissingSecrets, &out.MissingSecrets
+		*out = make([]StageSecret, len(*in))
+		copy(*out, *in)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ApplicationStatus.
@@ -137,57 +162,227 @@ func (in *ApplicationStatus) DeepCopy() *ApplicationStatus {
 }
 
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
-func (in *NamespacedName) DeepCopyInto(out *NamespacedName) {
+func (in *BlueGreenStatus) DeepCopyInto(out *BlueGreenStatus) {
 	*out = *in
+	if in.ScaleDownAt != nil {
+		in, out := &in.ScaleDownAt, &out.ScaleDownAt
+		*out = (*in).DeepCopy()
+	}
 }
 
-// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NamespacedName.
-func (in *NamespacedName) DeepCopy() *NamespacedName {
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new BlueGreenStatus.
+func (in *BlueGreenStatus) DeepCopy() *BlueGreenStatus {
 	if in == nil {
 		return nil
 	}
-	out := new(NamespacedName)
+	out := new(BlueGreenStatus)
 	in.DeepCopyInto(out)
 	return out
 }
 
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
-func (in *PodReference) DeepCopyInto(out *PodReference) {
+func (in *BlueGreenStrategy) DeepCopyInto(out *BlueGreenStrategy) {
 	*out = *in
-	out.NamespacedName = in.NamespacedName
+	if in.SmokeTest != nil {
+		in, out := &in.SmokeTest, &out.SmokeTest
+		*out = new(SmokeTest)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.ScaleDownDelay != nil {
+		in, out := &in.ScaleDownDelay, &out.ScaleDownDelay
+		*out = new(v1.Duration)
+		**out = **in
+	}
 }
 
-// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PodReference.
-func (in *PodReference) DeepCopy() *PodReference {
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new BlueGreenStrategy.
+func (in *BlueGreenStrategy) DeepCopy() *BlueGreenStrategy {
 	if in == nil {
 		return nil
 	}
-	out := new(PodReference)
+	out := new(BlueGreenStrategy)
 	in.DeepCopyInto(out)
 	return out
 }
 
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
-func (in *Portal) DeepCopyInto(out *Portal) {
+func (in *BoundServiceBinding) DeepCopyInto(out *BoundServiceBinding) {
+	*out = *in
+	if in.Env != nil {
+		in, out := &in.Env, &out.Env
+		*out = make([]ServiceBindingEnv, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new BoundServiceBinding.
+func (in *BoundServiceBinding) DeepCopy() *BoundServiceBinding {
+	if in == nil {
+		return nil
+	}
+	out := new(BoundServiceBinding)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *CanaryAnalysis) DeepCopyInto(out *CanaryAnalysis) {
+	*out = *in
+	if in.Metrics != nil {
+		in, out := &in.Metrics, &out.Metrics
+		*out = make([]CanaryMetric, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Timeout != nil {
+		in, out := &in.Timeout, &out.Timeout
+		*out = new(v1.Duration)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new CanaryAnalysis.
+func (in *CanaryAnalysis) DeepCopy() *CanaryAnalysis {
+	if in == nil {
+		return nil
+	}
+	out := new(CanaryAnalysis)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *CanaryMetric) DeepCopyInto(out *CanaryMetric) {
+	*out = *in
+	if in.Min != nil {
+		in, out := &in.Min, &out.Min
+		*out = new(string)
+		**out = **in
+	}
+	if in.Max != nil {
+		in, out := &in.Max, &out.Max
+		*out = new(string)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new CanaryMetric.
+func (in *CanaryMetric) DeepCopy() *CanaryMetric {
+	if in == nil {
+		return nil
+	}
+	out := new(CanaryMetric)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *CanaryStatus) DeepCopyInto(out *CanaryStatus) {
+	*out = *in
+	if in.StepStartedAt != nil {
+		in, out := &in.StepStartedAt, &out.StepStartedAt
+		*out = (*in).DeepCopy()
+	}
+	if in.AnalysisFailingSince != nil {
+		in, out := &in.AnalysisFailingSince, &out.AnalysisFailingSince
+		*out = (*in).DeepCopy()
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new CanaryStatus.
+func (in *CanaryStatus) DeepCopy() *CanaryStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(CanaryStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *CanaryStep) DeepCopyInto(out *CanaryStep) {
+	*out = *in
+	if in.Pause != nil {
+		in, out := &in.Pause, &out.Pause
+		*out = new(v1.Duration)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new CanaryStep.
+func (in *CanaryStep) DeepCopy() *CanaryStep {
+	if in == nil {
+		return nil
+	}
+	out := new(CanaryStep)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *CanaryStrategy) DeepCopyInto(out *CanaryStrategy) {
+	*out = *in
+	if in.Steps != nil {
+		in, out := &in.Steps, &out.Steps
+		*out = make([]CanaryStep, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Analysis != nil {
+		in, out := &in.Analysis, &out.Analysis
+		*out = new(CanaryAnalysis)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new CanaryStrategy.
+func (in *CanaryStrategy) DeepCopy() *CanaryStrategy {
+	if in == nil {
+		return nil
+	}
+	out := new(CanaryStrategy)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *CertificateIssuerReference) DeepCopyInto(out *CertificateIssuerReference) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new CertificateIssuerReference.
+func (in *CertificateIssuerReference) DeepCopy() *CertificateIssuerReference {
+	if in == nil {
+		return nil
+	}
+	out := new(CertificateIssuerReference)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ClusterGateway) DeepCopyInto(out *ClusterGateway) {
 	*out = *in
 	out.TypeMeta = in.TypeMeta
 	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
-	out.Spec = in.Spec
+	in.Spec.DeepCopyInto(&out.Spec)
 	in.Status.DeepCopyInto(&out.Status)
 }
 
-// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Portal.
-func (in *Portal) DeepCopy() *Portal {
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ClusterGateway.
+func (in *ClusterGateway) DeepCopy() *ClusterGateway {
 	if in == nil {
 		return nil
 	}
-	out := new(Portal)
+	out := new(ClusterGateway)
 	in.DeepCopyInto(out)
 	return out
 }
 
 // DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
-func (in *Portal) DeepCopyObject() runtime.Object {
+func (in *ClusterGateway) DeepCopyObject() runtime.Object {
 	if c := in.DeepCopy(); c != nil {
 		return c
 	}
@@ -195,31 +390,31 @@ func (in *Portal) DeepCopyObject() runtime.Object {
 }
 
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
-func (in *PortalList) DeepCopyInto(out *PortalList) {
+func (in *ClusterGatewayList) DeepCopyInto(out *ClusterGatewayList) {
 	*out = *in
 	out.TypeMeta = in.TypeMeta
 	in.ListMeta.DeepCopyInto(&out.ListMeta)
 	if in.Items != nil {
 		in, out := &in.Items, &out.Items
-		*out = make([]Portal, len(*in))
+		*out = make([]ClusterGateway, len(*in))
 		for i := range *in {
 			(*in)[i].DeepCopyInto(&(*out)[i])
 		}
 	}
 }
 
-// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PortalList.
-func (in *PortalList) DeepCopy() *PortalList {
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ClusterGatewayList.
+func (in *ClusterGatewayList) DeepCopy() *ClusterGatewayList {
 	if in == nil {
 		return nil
 	}
-	out := new(PortalList)
+	out := new(ClusterGatewayList)
 	in.DeepCopyInto(out)
 	return out
 }
 
 // DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
-func (in *PortalList) DeepCopyObject() runtime.Object {
+func (in *ClusterGatewayList) DeepCopyObject() runtime.Object {
 	if c := in.DeepCopy(); c != nil {
 		return c
 	}
@@ -227,22 +422,59 @@ func (in *PortalList) DeepCopyObject() runtime.Object {
 }
 
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
-func (in *PortalSpec) DeepCopyInto(out *PortalSpec) {
+func (in *ClusterGatewayOIDC) DeepCopyInto(out *ClusterGatewayOIDC) {
 	*out = *in
+	out.ClientSecretRef = in.ClientSecretRef
+	if in.Scopes != nil {
+		in, out := &in.Scopes, &out.Scopes
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
 }
 
-// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PortalSpec.
-func (in *PortalSpec) DeepCopy() *PortalSpec {
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ClusterGatewayOIDC.
+func (in *ClusterGatewayOIDC) DeepCopy() *ClusterGatewayOIDC {
 	if in == nil {
 		return nil
 	}
-	out := new(PortalSpec)
+	out := new(ClusterGatewayOIDC)
 	in.DeepCopyInto(out)
 	return out
 }
 
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
-func (in *PortalStatus) DeepCopyInto(out *PortalStatus) {
+func (in *ClusterGatewaySpec) DeepCopyInto(out *ClusterGatewaySpec) {
+	*out = *in
+	in.OIDC.DeepCopyInto(&out.OIDC)
+	if in.AllowedGroups != nil {
+		in, out := &in.AllowedGroups, &out.AllowedGroups
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.AllowedDomains != nil {
+		in, out := &in.AllowedDomains, &out.AllowedDomains
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.NamespaceSelector != nil {
+		in, out := &in.NamespaceSelector, &out.NamespaceSelector
+		*out = new(v1.LabelSelector)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ClusterGatewaySpec.
+func (in *ClusterGatewaySpec) DeepCopy() *ClusterGatewaySpec {
+	if in == nil {
+		return nil
+	}
+	out := new(ClusterGatewaySpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ClusterGatewayStatus) DeepCopyInto(out *ClusterGatewayStatus) {
 	*out = *in
 	if in.Conditions != nil {
 		in, out := &in.Conditions, &out.Conditions
@@ -251,20 +483,25 @@ func (in *PortalStatus) DeepCopyInto(out *PortalStatus) {
 			(*in)[i].DeepCopyInto(&(*out)[i])
 		}
 	}
+	if in.ProtectedApplications != nil {
+		in, out := &in.ProtectedApplications, &out.ProtectedApplications
+		*out = make([]ProtectedApplication, len(*in))
+		copy(*out, *in)
+	}
 }
 
-// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PortalStatus.
-func (in *PortalStatus) DeepCopy() *PortalStatus {
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ClusterGatewayStatus.
+func (in *ClusterGatewayStatus) DeepCopy() *ClusterGatewayStatus {
 	if in == nil {
 		return nil
 	}
-	out := new(PortalStatus)
+	out := new(ClusterGatewayStatus)
 	in.DeepCopyInto(out)
 	return out
 }
 
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
-func (in *Release) DeepCopyInto(out *Release) {
+func (in *ClusterMigration) DeepCopyInto(out *ClusterMigration) {
 	*out = *in
 	out.TypeMeta = in.TypeMeta
 	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
@@ -272,18 +509,18 @@ func (in *Release) DeepCopyInto(out *Release) {
 	in.Status.DeepCopyInto(&out.Status)
 }
 
-// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Release.
-func (in *Release) DeepCopy() *Release {
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ClusterMigration.
+func (in *ClusterMigration) DeepCopy() *ClusterMigration {
 	if in == nil {
 		return nil
 	}
-	out := new(Release)
+	out := new(ClusterMigration)
 	in.DeepCopyInto(out)
 	return out
 }
 
 // DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
-func (in *Release) DeepCopyObject() runtime.Object {
+func (in *ClusterMigration) DeepCopyObject() runtime.Object {
 	if c := in.DeepCopy(); c != nil {
 		return c
 	}
@@ -291,31 +528,63 @@ func (in *Release) DeepCopyObject() runtime.Object {
 }
 
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
-func (in *ReleaseList) DeepCopyInto(out *ReleaseList) {
+func (in *ClusterMigrationHealthCheck) DeepCopyInto(out *ClusterMigrationHealthCheck) {
+	*out = *in
+	out.Timeout = in.Timeout
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ClusterMigrationHealthCheck.
+func (in *ClusterMigrationHealthCheck) DeepCopy() *ClusterMigrationHealthCheck {
+	if in == nil {
+		return nil
+	}
+	out := new(ClusterMigrationHealthCheck)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ClusterMigrationHealthCheckResult) DeepCopyInto(out *ClusterMigrationHealthCheckResult) {
+	*out = *in
+	in.Time.DeepCopyInto(&out.Time)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ClusterMigrationHealthCheckResult.
+func (in *ClusterMigrationHealthCheckResult) DeepCopy() *ClusterMigrationHealthCheckResult {
+	if in == nil {
+		return nil
+	}
+	out := new(ClusterMigrationHealthCheckResult)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ClusterMigrationList) DeepCopyInto(out *ClusterMigrationList) {
 	*out = *in
 	out.TypeMeta = in.TypeMeta
 	in.ListMeta.DeepCopyInto(&out.ListMeta)
 	if in.Items != nil {
 		in, out := &in.Items, &out.Items
-		*out = make([]Release, len(*in))
+		*out = make([]ClusterMigration, len(*in))
 		for i := range *in {
 			(*in)[i].DeepCopyInto(&(*out)[i])
 		}
 	}
 }
 
-// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ReleaseList.
-func (in *ReleaseList) DeepCopy() *ReleaseList {
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ClusterMigrationList.
+func (in *ClusterMigrationList) DeepCopy() *ClusterMigrationList {
 	if in == nil {
 		return nil
 	}
-	out := new(ReleaseList)
+	out := new(ClusterMigrationList)
 	in.DeepCopyInto(out)
 	return out
 }
 
 // DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
-func (in *ReleaseList) DeepCopyObject() runtime.Object {
+func (in *ClusterMigrationList) DeepCopyObject() runtime.Object {
 	if c := in.DeepCopy(); c != nil {
 		return c
 	}
@@ -323,33 +592,29 @@ func (in *ReleaseList) DeepCopyObject() runtime.Object {
 }
 
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
-func (in *ReleaseSpec) DeepCopyInto(out *ReleaseSpec) {
+func (in *ClusterMigrationSpec) DeepCopyInto(out *ClusterMigrationSpec) {
 	*out = *in
-	out.Repo = in.Repo
-	if in.Commit != nil {
-		in, out := &in.Commit, &out.Commit
-		*out = new(string)
-		**out = **in
-	}
-	if in.EnvSecretName != nil {
-		in, out := &in.EnvSecretName, &out.EnvSecretName
-		*out = new(string)
-		**out = **in
+	if in.Steps != nil {
+		in, out := &in.Steps, &out.Steps
+		*out = make([]int32, len(*in))
+		copy(*out, *in)
 	}
+	out.Interval = in.Interval
+	out.HealthCheck = in.HealthCheck
 }
 
-// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ReleaseSpec.
-func (in *ReleaseSpec) DeepCopy() *ReleaseSpec {
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ClusterMigrationSpec.
+func (in *ClusterMigrationSpec) DeepCopy() *ClusterMigrationSpec {
 	if in == nil {
 		return nil
 	}
-	out := new(ReleaseSpec)
+	out := new(ClusterMigrationSpec)
 	in.DeepCopyInto(out)
 	return out
 }
 
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
-func (in *ReleaseStatus) DeepCopyInto(out *ReleaseStatus) {
+func (in *ClusterMigrationStatus) DeepCopyInto(out *ClusterMigrationStatus) {
 	*out = *in
 	if in.Conditions != nil {
 		in, out := &in.Conditions, &out.Conditions
@@ -358,29 +623,1378 @@ func (in *ReleaseStatus) DeepCopyInto(out *ReleaseStatus) {
 			(*in)[i].DeepCopyInto(&(*out)[i])
 		}
 	}
+	if in.CurrentWeight != nil {
+		in, out := &in.CurrentWeight, &out.CurrentWeight
+		*out = new(int32)
+		**out = **in
+	}
+	if in.StepStartedAt != nil {
+		in, out := &in.StepStartedAt, &out.StepStartedAt
+		*out = (*in).DeepCopy()
+	}
+	if in.LastHealthCheck != nil {
+		in, out := &in.LastHealthCheck, &out.LastHealthCheck
+		*out = new(ClusterMigrationHealthCheckResult)
+		(*in).DeepCopyInto(*out)
+	}
 }
 
-// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ReleaseStatus.
-func (in *ReleaseStatus) DeepCopy() *ReleaseStatus {
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ClusterMigrationStatus.
+func (in *ClusterMigrationStatus) DeepCopy() *ClusterMigrationStatus {
 	if in == nil {
 		return nil
 	}
-	out := new(ReleaseStatus)
+	out := new(ClusterMigrationStatus)
 	in.DeepCopyInto(out)
 	return out
 }
 
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
-func (in *RepositoryRef) DeepCopyInto(out *RepositoryRef) {
+func (in *DNSRecordStatus) DeepCopyInto(out *DNSRecordStatus) {
+	*out = *in
+	if in.Targets != nil {
+		in, out := &in.Targets, &out.Targets
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Weight != nil {
+		in, out := &in.Weight, &out.Weight
+		*out = new(int64)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DNSRecordStatus.
+func (in *DNSRecordStatus) DeepCopy() *DNSRecordStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(DNSRecordStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DeployWindow) DeepCopyInto(out *DeployWindow) {
 	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
 }
 
-// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RepositoryRef.
-func (in *RepositoryRef) DeepCopy() *RepositoryRef {
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DeployWindow.
+func (in *DeployWindow) DeepCopy() *DeployWindow {
 	if in == nil {
 		return nil
 	}
-	out := new(RepositoryRef)
+	out := new(DeployWindow)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *DeployWindow) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DeployWindowList) DeepCopyInto(out *DeployWindowList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]DeployWindow, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DeployWindowList.
+func (in *DeployWindowList) DeepCopy() *DeployWindowList {
+	if in == nil {
+		return nil
+	}
+	out := new(DeployWindowList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *DeployWindowList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DeployWindowRule) DeepCopyInto(out *DeployWindowRule) {
+	*out = *in
+	out.Duration = in.Duration
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DeployWindowRule.
+func (in *DeployWindowRule) DeepCopy() *DeployWindowRule {
+	if in == nil {
+		return nil
+	}
+	out := new(DeployWindowRule)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DeployWindowSpec) DeepCopyInto(out *DeployWindowSpec) {
+	*out = *in
+	if in.Stages != nil {
+		in, out := &in.Stages, &out.Stages
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.ApplicationSelector != nil {
+		in, out := &in.ApplicationSelector, &out.ApplicationSelector
+		*out = new(v1.LabelSelector)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Windows != nil {
+		in, out := &in.Windows, &out.Windows
+		*out = make([]DeployWindowRule, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DeployWindowSpec.
+func (in *DeployWindowSpec) DeepCopy() *DeployWindowSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(DeployWindowSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DryRunChange) DeepCopyInto(out *DryRunChange) {
+	*out = *in
+	if in.Fields != nil {
+		in, out := &in.Fields, &out.Fields
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DryRunChange.
+func (in *DryRunChange) DeepCopy() *DryRunChange {
+	if in == nil {
+		return nil
+	}
+	out := new(DryRunChange)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DryRunStatus) DeepCopyInto(out *DryRunStatus) {
+	*out = *in
+	in.ComputedAt.DeepCopyInto(&out.ComputedAt)
+	if in.Changes != nil {
+		in, out := &in.Changes, &out.Changes
+		*out = make([]DryRunChange, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DryRunStatus.
+func (in *DryRunStatus) DeepCopy() *DryRunStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(DryRunStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *Gateway) DeepCopyInto(out *Gateway) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Gateway.
+func (in *Gateway) DeepCopy() *Gateway {
+	if in == nil {
+		return nil
+	}
+	out := new(Gateway)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *Gateway) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *GatewayAuthentication) DeepCopyInto(out *GatewayAuthentication) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new GatewayAuthentication.
+func (in *GatewayAuthentication) DeepCopy() *GatewayAuthentication {
+	if in == nil {
+		return nil
+	}
+	out := new(GatewayAuthentication)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *GatewayList) DeepCopyInto(out *GatewayList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]Gateway, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new GatewayList.
+func (in *GatewayList) DeepCopy() *GatewayList {
+	if in == nil {
+		return nil
+	}
+	out := new(GatewayList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *GatewayList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *GatewayParentReference) DeepCopyInto(out *GatewayParentReference) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new GatewayParentReference.
+func (in *GatewayParentReference) DeepCopy() *GatewayParentReference {
+	if in == nil {
+		return nil
+	}
+	out := new(GatewayParentReference)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *GatewayPort) DeepCopyInto(out *GatewayPort) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new GatewayPort.
+func (in *GatewayPort) DeepCopy() *GatewayPort {
+	if in == nil {
+		return nil
+	}
+	out := new(GatewayPort)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *GatewaySpec) DeepCopyInto(out *GatewaySpec) {
+	*out = *in
+	if in.Ports != nil {
+		in, out := &in.Ports, &out.Ports
+		*out = make([]GatewayPort, len(*in))
+		copy(*out, *in)
+	}
+	if in.IngressClassName != nil {
+		in, out := &in.IngressClassName, &out.IngressClassName
+		*out = new(string)
+		**out = **in
+	}
+	if in.ParentRefs != nil {
+		in, out := &in.ParentRefs, &out.ParentRefs
+		*out = make([]GatewayParentReference, len(*in))
+		copy(*out, *in)
+	}
+	if in.Authentication != nil {
+		in, out := &in.Authentication, &out.Authentication
+		*out = new(GatewayAuthentication)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new GatewaySpec.
+func (in *GatewaySpec) DeepCopy() *GatewaySpec {
+	if in == nil {
+		return nil
+	}
+	out := new(GatewaySpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *GatewayStatus) DeepCopyInto(out *GatewayStatus) {
+	*out = *in
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]v1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Routes != nil {
+		in, out := &in.Routes, &out.Routes
+		*out = make([]corev1.ObjectReference, len(*in))
+		copy(*out, *in)
+	}
+	if in.TLS != nil {
+		in, out := &in.TLS, &out.TLS
+		*out = new(GatewayTLSStatus)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.DNSRecords != nil {
+		in, out := &in.DNSRecords, &out.DNSRecords
+		*out = make([]DNSRecordStatus, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new GatewayStatus.
+func (in *GatewayStatus) DeepCopy() *GatewayStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(GatewayStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *GatewayTLSStatus) DeepCopyInto(out *GatewayTLSStatus) {
+	*out = *in
+	if in.NotAfter != nil {
+		in, out := &in.NotAfter, &out.NotAfter
+		*out = (*in).DeepCopy()
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new GatewayTLSStatus.
+func (in *GatewayTLSStatus) DeepCopy() *GatewayTLSStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(GatewayTLSStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NamespacedName) DeepCopyInto(out *NamespacedName) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NamespacedName.
+func (in *NamespacedName) DeepCopy() *NamespacedName {
+	if in == nil {
+		return nil
+	}
+	out := new(NamespacedName)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NetworkingAPIStatus) DeepCopyInto(out *NetworkingAPIStatus) {
+	*out = *in
+	if in.Classes != nil {
+		in, out := &in.Classes, &out.Classes
+		*out = make([]NetworkingClass, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NetworkingAPIStatus.
+func (in *NetworkingAPIStatus) DeepCopy() *NetworkingAPIStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(NetworkingAPIStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NetworkingClass) DeepCopyInto(out *NetworkingClass) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NetworkingClass.
+func (in *NetworkingClass) DeepCopy() *NetworkingClass {
+	if in == nil {
+		return nil
+	}
+	out := new(NetworkingClass)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NetworkingStatus) DeepCopyInto(out *NetworkingStatus) {
+	*out = *in
+	in.Ingress.DeepCopyInto(&out.Ingress)
+	in.GatewayAPI.DeepCopyInto(&out.GatewayAPI)
+	if in.LastDiscoveredTime != nil {
+		in, out := &in.LastDiscoveredTime, &out.LastDiscoveredTime
+		*out = (*in).DeepCopy()
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NetworkingStatus.
+func (in *NetworkingStatus) DeepCopy() *NetworkingStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(NetworkingStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PendingPromotion) DeepCopyInto(out *PendingPromotion) {
+	*out = *in
+	if in.SoakUntil != nil {
+		in, out := &in.SoakUntil, &out.SoakUntil
+		*out = (*in).DeepCopy()
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PendingPromotion.
+func (in *PendingPromotion) DeepCopy() *PendingPromotion {
+	if in == nil {
+		return nil
+	}
+	out := new(PendingPromotion)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PodReference) DeepCopyInto(out *PodReference) {
+	*out = *in
+	out.NamespacedName = in.NamespacedName
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PodReference.
+func (in *PodReference) DeepCopy() *PodReference {
+	if in == nil {
+		return nil
+	}
+	out := new(PodReference)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *Portal) DeepCopyInto(out *Portal) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Portal.
+func (in *Portal) DeepCopy() *Portal {
+	if in == nil {
+		return nil
+	}
+	out := new(Portal)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *Portal) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PortalImage) DeepCopyInto(out *PortalImage) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PortalImage.
+func (in *PortalImage) DeepCopy() *PortalImage {
+	if in == nil {
+		return nil
+	}
+	out := new(PortalImage)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PortalIngress) DeepCopyInto(out *PortalIngress) {
+	*out = *in
+	if in.Annotations != nil {
+		in, out := &in.Annotations, &out.Annotations
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PortalIngress.
+func (in *PortalIngress) DeepCopy() *PortalIngress {
+	if in == nil {
+		return nil
+	}
+	out := new(PortalIngress)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PortalList) DeepCopyInto(out *PortalList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]Portal, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PortalList.
+func (in *PortalList) DeepCopy() *PortalList {
+	if in == nil {
+		return nil
+	}
+	out := new(PortalList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *PortalList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PortalSpec) DeepCopyInto(out *PortalSpec) {
+	*out = *in
+	if in.TLS != nil {
+		in, out := &in.TLS, &out.TLS
+		*out = new(PortalTLS)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Image != nil {
+		in, out := &in.Image, &out.Image
+		*out = new(PortalImage)
+		**out = **in
+	}
+	if in.Replicas != nil {
+		in, out := &in.Replicas, &out.Replicas
+		*out = new(int32)
+		**out = **in
+	}
+	if in.Resources != nil {
+		in, out := &in.Resources, &out.Resources
+		*out = new(corev1.ResourceRequirements)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Ingress != nil {
+		in, out := &in.Ingress, &out.Ingress
+		*out = new(PortalIngress)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Values != nil {
+		in, out := &in.Values, &out.Values
+		*out = new(apiextensionsv1.JSON)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PortalSpec.
+func (in *PortalSpec) DeepCopy() *PortalSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(PortalSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PortalStatus) DeepCopyInto(out *PortalStatus) {
+	*out = *in
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]v1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Pods != nil {
+		in, out := &in.Pods, &out.Pods
+		*out = make([]PodReference, len(*in))
+		copy(*out, *in)
+	}
+	if in.DryRun != nil {
+		in, out := &in.DryRun, &out.DryRun
+		*out = new(DryRunStatus)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Networking != nil {
+		in, out := &in.Networking, &out.Networking
+		*out = new(NetworkingStatus)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PortalStatus.
+func (in *PortalStatus) DeepCopy() *PortalStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(PortalStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PortalTLS) DeepCopyInto(out *PortalTLS) {
+	*out = *in
+	if in.IssuerRef != nil {
+		in, out := &in.IssuerRef, &out.IssuerRef
+		*out = new(CertificateIssuerReference)
+		**out = **in
+	}
+	if in.WildcardCertificateSecret != nil {
+		in, out := &in.WildcardCertificateSecret, &out.WildcardCertificateSecret
+		*out = new(NamespacedName)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PortalTLS.
+func (in *PortalTLS) DeepCopy() *PortalTLS {
+	if in == nil {
+		return nil
+	}
+	out := new(PortalTLS)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ProtectedApplication) DeepCopyInto(out *ProtectedApplication) {
+	*out = *in
+	out.NamespacedName = in.NamespacedName
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ProtectedApplication.
+func (in *ProtectedApplication) DeepCopy() *ProtectedApplication {
+	if in == nil {
+		return nil
+	}
+	out := new(ProtectedApplication)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *Release) DeepCopyInto(out *Release) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Release.
+func (in *Release) DeepCopy() *Release {
+	if in == nil {
+		return nil
+	}
+	out := new(Release)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *Release) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ReleaseImageStatus) DeepCopyInto(out *ReleaseImageStatus) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ReleaseImageStatus.
+func (in *ReleaseImageStatus) DeepCopy() *ReleaseImageStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(ReleaseImageStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ReleaseList) DeepCopyInto(out *ReleaseList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]Release, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ReleaseList.
+func (in *ReleaseList) DeepCopy() *ReleaseList {
+	if in == nil {
+		return nil
+	}
+	out := new(ReleaseList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *ReleaseList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ReleaseRevision) DeepCopyInto(out *ReleaseRevision) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ReleaseRevision.
+func (in *ReleaseRevision) DeepCopy() *ReleaseRevision {
+	if in == nil {
+		return nil
+	}
+	out := new(ReleaseRevision)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *ReleaseRevision) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ReleaseRevisionList) DeepCopyInto(out *ReleaseRevisionList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]ReleaseRevision, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ReleaseRevisionList.
+func (in *ReleaseRevisionList) DeepCopy() *ReleaseRevisionList {
+	if in == nil {
+		return nil
+	}
+	out := new(ReleaseRevisionList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *ReleaseRevisionList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ReleaseRevisionProcess) DeepCopyInto(out *ReleaseRevisionProcess) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ReleaseRevisionProcess.
+func (in *ReleaseRevisionProcess) DeepCopy() *ReleaseRevisionProcess {
+	if in == nil {
+		return nil
+	}
+	out := new(ReleaseRevisionProcess)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ReleaseRevisionSpec) DeepCopyInto(out *ReleaseRevisionSpec) {
+	*out = *in
+	if in.Image != nil {
+		in, out := &in.Image, &out.Image
+		*out = new(ReleaseImageStatus)
+		**out = **in
+	}
+	if in.Processes != nil {
+		in, out := &in.Processes, &out.Processes
+		*out = make([]ReleaseRevisionProcess, len(*in))
+		copy(*out, *in)
+	}
+	if in.Manifests != nil {
+		in, out := &in.Manifests, &out.Manifests
+		*out = make([]byte, len(*in))
+		copy(*out, *in)
+	}
+	if in.Values != nil {
+		in, out := &in.Values, &out.Values
+		*out = make([]byte, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ReleaseRevisionSpec.
+func (in *ReleaseRevisionSpec) DeepCopy() *ReleaseRevisionSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(ReleaseRevisionSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ReleaseRevisionStatus) DeepCopyInto(out *ReleaseRevisionStatus) {
+	*out = *in
+	if in.DeployedAt != nil {
+		in, out := &in.DeployedAt, &out.DeployedAt
+		*out = (*in).DeepCopy()
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ReleaseRevisionStatus.
+func (in *ReleaseRevisionStatus) DeepCopy() *ReleaseRevisionStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(ReleaseRevisionStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ReleaseSpec) DeepCopyInto(out *ReleaseSpec) {
+	*out = *in
+	out.Repo = in.Repo
+	if in.Commit != nil {
+		in, out := &in.Commit, &out.Commit
+		*out = new(string)
+		**out = **in
+	}
+	if in.EnvSecretName != nil {
+		in, out := &in.EnvSecretName, &out.EnvSecretName
+		*out = new(string)
+		**out = **in
+	}
+	if in.DecryptionKeySecretName != nil {
+		in, out := &in.DecryptionKeySecretName, &out.DecryptionKeySecretName
+		*out = new(string)
+		**out = **in
+	}
+	if in.ImagePullSecrets != nil {
+		in, out := &in.ImagePullSecrets, &out.ImagePullSecrets
+		*out = make([]corev1.LocalObjectReference, len(*in))
+		copy(*out, *in)
+	}
+	if in.Strategy != nil {
+		in, out := &in.Strategy, &out.Strategy
+		*out = new(ReleaseStrategy)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.RollbackTo != nil {
+		in, out := &in.RollbackTo, &out.RollbackTo
+		*out = new(int64)
+		**out = **in
+	}
+	if in.RevisionHistoryLimit != nil {
+		in, out := &in.RevisionHistoryLimit, &out.RevisionHistoryLimit
+		*out = new(int32)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ReleaseSpec.
+func (in *ReleaseSpec) DeepCopy() *ReleaseSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(ReleaseSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ReleaseStatus) DeepCopyInto(out *ReleaseStatus) {
+	*out = *in
+	if in.Image != nil {
+		in, out := &in.Image, &out.Image
+		*out = new(ReleaseImageStatus)
+		**out = **in
+	}
+	if in.Processes != nil {
+		in, out := &in.Processes, &out.Processes
+		*out = make([]corev1.ObjectReference, len(*in))
+		copy(*out, *in)
+	}
+	if in.ServiceBindings != nil {
+		in, out := &in.ServiceBindings, &out.ServiceBindings
+		*out = make([]BoundServiceBinding, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.DeployedAt != nil {
+		in, out := &in.DeployedAt, &out.DeployedAt
+		*out = (*in).DeepCopy()
+	}
+	if in.Canary != nil {
+		in, out := &in.Canary, &out.Canary
+		*out = new(CanaryStatus)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.BlueGreen != nil {
+		in, out := &in.BlueGreen, &out.BlueGreen
+		*out = new(BlueGreenStatus)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.DryRun != nil {
+		in, out := &in.DryRun, &out.DryRun
+		*out = new(DryRunStatus)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Pods != nil {
+		in, out := &in.Pods, &out.Pods
+		*out = make([]PodReference, len(*in))
+		copy(*out, *in)
+	}
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]v1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ReleaseStatus.
+func (in *ReleaseStatus) DeepCopy() *ReleaseStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(ReleaseStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ReleaseStrategy) DeepCopyInto(out *ReleaseStrategy) {
+	*out = *in
+	if in.Canary != nil {
+		in, out := &in.Canary, &out.Canary
+		*out = new(CanaryStrategy)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.BlueGreen != nil {
+		in, out := &in.BlueGreen, &out.BlueGreen
+		*out = new(BlueGreenStrategy)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ReleaseStrategy.
+func (in *ReleaseStrategy) DeepCopy() *ReleaseStrategy {
+	if in == nil {
+		return nil
+	}
+	out := new(ReleaseStrategy)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RepositoryRef) DeepCopyInto(out *RepositoryRef) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RepositoryRef.
+func (in *RepositoryRef) DeepCopy() *RepositoryRef {
+	if in == nil {
+		return nil
+	}
+	out := new(RepositoryRef)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ResourceFlavor) DeepCopyInto(out *ResourceFlavor) {
+	*out = *in
+	in.Resources.DeepCopyInto(&out.Resources)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ResourceFlavor.
+func (in *ResourceFlavor) DeepCopy() *ResourceFlavor {
+	if in == nil {
+		return nil
+	}
+	out := new(ResourceFlavor)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ResourcePolicy) DeepCopyInto(out *ResourcePolicy) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ResourcePolicy.
+func (in *ResourcePolicy) DeepCopy() *ResourcePolicy {
+	if in == nil {
+		return nil
+	}
+	out := new(ResourcePolicy)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *ResourcePolicy) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ResourcePolicyList) DeepCopyInto(out *ResourcePolicyList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]ResourcePolicy, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ResourcePolicyList.
+func (in *ResourcePolicyList) DeepCopy() *ResourcePolicyList {
+	if in == nil {
+		return nil
+	}
+	out := new(ResourcePolicyList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *ResourcePolicyList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ResourcePolicySpec) DeepCopyInto(out *ResourcePolicySpec) {
+	*out = *in
+	if in.Flavors != nil {
+		in, out := &in.Flavors, &out.Flavors
+		*out = make([]ResourceFlavor, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.MaxLimitRequestRatio != nil {
+		in, out := &in.MaxLimitRequestRatio, &out.MaxLimitRequestRatio
+		*out = make(corev1.ResourceList, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val.DeepCopy()
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ResourcePolicySpec.
+func (in *ResourcePolicySpec) DeepCopy() *ResourcePolicySpec {
+	if in == nil {
+		return nil
+	}
+	out := new(ResourcePolicySpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SecretKeyReference) DeepCopyInto(out *SecretKeyReference) {
+	*out = *in
+	out.NamespacedName = in.NamespacedName
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SecretKeyReference.
+func (in *SecretKeyReference) DeepCopy() *SecretKeyReference {
+	if in == nil {
+		return nil
+	}
+	out := new(SecretKeyReference)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ServiceBinding) DeepCopyInto(out *ServiceBinding) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ServiceBinding.
+func (in *ServiceBinding) DeepCopy() *ServiceBinding {
+	if in == nil {
+		return nil
+	}
+	out := new(ServiceBinding)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *ServiceBinding) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ServiceBindingEnv) DeepCopyInto(out *ServiceBindingEnv) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ServiceBindingEnv.
+func (in *ServiceBindingEnv) DeepCopy() *ServiceBindingEnv {
+	if in == nil {
+		return nil
+	}
+	out := new(ServiceBindingEnv)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ServiceBindingList) DeepCopyInto(out *ServiceBindingList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]ServiceBinding, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ServiceBindingList.
+func (in *ServiceBindingList) DeepCopy() *ServiceBindingList {
+	if in == nil {
+		return nil
+	}
+	out := new(ServiceBindingList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *ServiceBindingList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ServiceBindingSpec) DeepCopyInto(out *ServiceBindingSpec) {
+	*out = *in
+	if in.Stages != nil {
+		in, out := &in.Stages, &out.Stages
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Env != nil {
+		in, out := &in.Env, &out.Env
+		*out = make([]ServiceBindingEnv, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ServiceBindingSpec.
+func (in *ServiceBindingSpec) DeepCopy() *ServiceBindingSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(ServiceBindingSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ServiceBindingStatus) DeepCopyInto(out *ServiceBindingStatus) {
+	*out = *in
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]v1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.BoundReleases != nil {
+		in, out := &in.BoundReleases, &out.BoundReleases
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ServiceBindingStatus.
+func (in *ServiceBindingStatus) DeepCopy() *ServiceBindingStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(ServiceBindingStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SmokeTest) DeepCopyInto(out *SmokeTest) {
+	*out = *in
+	if in.Command != nil {
+		in, out := &in.Command, &out.Command
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SmokeTest.
+func (in *SmokeTest) DeepCopy() *SmokeTest {
+	if in == nil {
+		return nil
+	}
+	out := new(SmokeTest)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *StagePromotion) DeepCopyInto(out *StagePromotion) {
+	*out = *in
+	if in.SoakTime != nil {
+		in, out := &in.SoakTime, &out.SoakTime
+		*out = new(v1.Duration)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new StagePromotion.
+func (in *StagePromotion) DeepCopy() *StagePromotion {
+	if in == nil {
+		return nil
+	}
+	out := new(StagePromotion)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *StageSecret) DeepCopyInto(out *StageSecret) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new StageSecret.
+func (in *StageSecret) DeepCopy() *StageSecret {
+	if in == nil {
+		return nil
+	}
+	out := new(StageSecret)
 	in.DeepCopyInto(out)
 	return out
 }
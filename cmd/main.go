@@ -27,7 +27,9 @@ package main
 import (
 	"crypto/tls"
 	"flag"
+	"fmt"
 	"os"
+	"strings"
 
 	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
 	// to ensure that exec-entrypoint and run can make use of them.
@@ -35,6 +37,7 @@ import (
 
 	"k8s.io/apimachinery/pkg/runtime"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/discovery"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
@@ -44,6 +47,8 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/webhook"
 
 	"github.com/tacokumo/portal-controller-kubernetes/internal/controller"
+	"github.com/tacokumo/portal-controller-kubernetes/pkg/dns"
+	"github.com/tacokumo/portal-controller-kubernetes/pkg/networkdiscovery"
 
 	tacokumogithubiov1alpha1 "github.com/tacokumo/portal-controller-kubernetes/api/v1alpha1"
 	// +kubebuilder:scaffold:imports
@@ -71,6 +76,8 @@ func main() {
 	var probeAddr string
 	var secureMetrics bool
 	var enableHTTP2 bool
+	var plainHTTPRegistries string
+	var baseDomain, dnsProviderName, dnsRecordsFile string
 	var tlsOpts []func(*tls.Config)
 	flag.StringVar(&metricsAddr, "metrics-bind-address", "0", "The address the metrics endpoint binds to. "+
 		"Use :8443 for HTTPS or :8080 for HTTP, or leave as 0 to disable the metrics service.")
@@ -89,6 +96,17 @@ func main() {
 	flag.StringVar(&metricsCertKey, "metrics-cert-key", "tls.key", "The name of the metrics server key file.")
 	flag.BoolVar(&enableHTTP2, "enable-http2", false,
 		"If set, HTTP/2 will be enabled for the metrics and webhook servers")
+	flag.StringVar(&plainHTTPRegistries, "plain-http-registries", "",
+		"Comma-separated list of container registries accessed without TLS when resolving image digests. "+
+			"Registries on localhost are always accessed without TLS.")
+	flag.StringVar(&baseDomain, "base-domain", "",
+		"The base domain used to build Gateway hostnames in the form <name>.<tenant>.app.<base-domain>. "+
+			"The spec.baseDomain of a Portal takes precedence when set. "+
+			"Hostnames are not registered to DNS if neither is set.")
+	flag.StringVar(&dnsProviderName, "dns-provider", "",
+		"The DNS provider used to register Gateway hostnames. One of: file. Leave empty to disable DNS management.")
+	flag.StringVar(&dnsRecordsFile, "dns-records-file", "/tmp/portal-controller/dns-records.json",
+		"The file the file DNS provider stores records in.")
 	opts := zap.Options{
 		Development: true,
 	}
@@ -203,10 +221,59 @@ func main() {
 		os.Exit(1)
 	}
 	if err := (&controller.ReleaseReconciler{
+		Client:              mgr.GetClient(),
+		Scheme:              mgr.GetScheme(),
+		PlainHTTPRegistries: splitCommaSeparated(plainHTTPRegistries),
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "Release")
+		os.Exit(1)
+	}
+	dnsProvider, err := newDNSProvider(dnsProviderName, dnsRecordsFile)
+	if err != nil {
+		setupLog.Error(err, "unable to create dns provider")
+		os.Exit(1)
+	}
+	if err := (&controller.GatewayReconciler{
+		Client:      mgr.GetClient(),
+		Scheme:      mgr.GetScheme(),
+		BaseDomain:  baseDomain,
+		DNSProvider: dnsProvider,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "Gateway")
+		os.Exit(1)
+	}
+	if err := (&controller.ClusterGatewayReconciler{
 		Client: mgr.GetClient(),
 		Scheme: mgr.GetScheme(),
 	}).SetupWithManager(mgr); err != nil {
-		setupLog.Error(err, "unable to create controller", "controller", "Release")
+		setupLog.Error(err, "unable to create controller", "controller", "ClusterGateway")
+		os.Exit(1)
+	}
+	if err := (&controller.ClusterMigrationReconciler{
+		Client: mgr.GetClient(),
+		Scheme: mgr.GetScheme(),
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "ClusterMigration")
+		os.Exit(1)
+	}
+	if err := (&controller.ServiceBindingReconciler{
+		Client: mgr.GetClient(),
+		Scheme: mgr.GetScheme(),
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "ServiceBinding")
+		os.Exit(1)
+	}
+	discoveryClient, err := discovery.NewDiscoveryClientForConfig(mgr.GetConfig())
+	if err != nil {
+		setupLog.Error(err, "unable to create discovery client")
+		os.Exit(1)
+	}
+	if err := (&controller.NetworkingReconciler{
+		Client:     mgr.GetClient(),
+		Scheme:     mgr.GetScheme(),
+		Discoverer: networkdiscovery.NewDiscoverer(discoveryClient, mgr.GetClient()),
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "Networking")
 		os.Exit(1)
 	}
 	// +kubebuilder:scaffold:builder
@@ -226,3 +293,31 @@ func main() {
 		os.Exit(1)
 	}
 }
+
+// splitCommaSeparated splits a comma-separated flag value, dropping empty items.
+func splitCommaSeparated(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// newDNSProvider は --dns-provider で指定されたDNSプロバイダを作成する
+// 指定されない場合は nil を返し､DNSレコードを管理しない
+func newDNSProvider(name, recordsFile string) (dns.Provider, error) {
+	switch name {
+	case "":
+		return nil, nil
+	case "file":
+		provider, err := dns.NewFileProvider(recordsFile)
+		if err != nil {
+			return nil, err
+		}
+		return provider, nil
+	default:
+		return nil, fmt.Errorf("unsupported dns provider %q", name)
+	}
+}
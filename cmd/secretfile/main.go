@@ -0,0 +1,101 @@
+/*
+MIT License
+
+Copyright (c) 2025 tacokumo
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+// secretfile はアプリケーションの設定ファイルの secrets.encrypted_file に指定する暗号化ファイルを作成するコマンド
+//
+//	secretfile keygen
+//	secretfile encrypt -public-key <公開鍵> [-in .env] [-out secrets.enc.env]
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/tacokumo/portal-controller-kubernetes/pkg/secretfile"
+)
+
+func main() {
+	if err := run(os.Args[1:], os.Stdin, os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func run(args []string, stdin io.Reader, stdout io.Writer) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: secretfile <keygen|encrypt> [flags]")
+	}
+	switch args[0] {
+	case "keygen":
+		publicKey, privateKey, err := secretfile.GenerateKey()
+		if err != nil {
+			return err
+		}
+		// 秘密鍵はクラスタの復号鍵のSecretに格納し､公開鍵はリポジトリの利用者に共有する
+		_, err = fmt.Fprintf(stdout, "public-key: %s\nprivate-key: %s\n", publicKey, privateKey)
+		return err
+	case "encrypt":
+		return encrypt(args[1:], stdin, stdout)
+	default:
+		return fmt.Errorf("unknown command %q", args[0])
+	}
+}
+
+// encrypt は平文のdotenv形式のファイルを暗号化する
+// -in が指定されない場合は標準入力から読み込み､-out が指定されない場合は標準出力に書き込む
+func encrypt(args []string, stdin io.Reader, stdout io.Writer) error {
+	fs := flag.NewFlagSet("encrypt", flag.ContinueOnError)
+	publicKey := fs.String("public-key", os.Getenv("SECRETFILE_PUBLIC_KEY"),
+		"The public key to encrypt values. Defaults to $SECRETFILE_PUBLIC_KEY.")
+	in := fs.String("in", "", "The plaintext dotenv file. Reads stdin if not specified.")
+	out := fs.String("out", "", "The encrypted file to write. Writes stdout if not specified.")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *publicKey == "" {
+		return fmt.Errorf("-public-key is required")
+	}
+
+	var data []byte
+	var err error
+	if *in == "" {
+		data, err = io.ReadAll(stdin)
+	} else {
+		data, err = os.ReadFile(*in)
+	}
+	if err != nil {
+		return err
+	}
+	encrypted, err := secretfile.EncryptFile(*publicKey, data)
+	if err != nil {
+		return err
+	}
+	if *out == "" {
+		_, err = stdout.Write(encrypted)
+		return err
+	}
+	return os.WriteFile(*out, encrypted, 0o644)
+}
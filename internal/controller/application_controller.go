@@ -49,6 +49,7 @@ type ApplicationReconciler struct {
 // +kubebuilder:rbac:groups=tacokumo.github.io,resources=applications,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=tacokumo.github.io,resources=applications/status,verbs=get;update;patch
 // +kubebuilder:rbac:groups=tacokumo.github.io,resources=applications/finalizers,verbs=update
+// +kubebuilder:rbac:groups=core,resources=secrets,verbs=get
 
 // Reconcile is part of the main kubernetes reconciliation loop which aims to
 // move the current state of the cluster closer to the desired state.
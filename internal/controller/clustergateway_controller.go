@@ -0,0 +1,108 @@
+/*
+MIT License
+
+Copyright (c) 2025 tacokumo
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package controller
+
+import (
+	"context"
+	"time"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	tacokumogithubiov1alpha1 "github.com/tacokumo/portal-controller-kubernetes/api/v1alpha1"
+	"github.com/tacokumo/portal-controller-kubernetes/pkg/clustergateway"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+// clusterGatewayRecheckInterval はwatchしていないクライアントシークレットの変更を検出するための間隔
+const clusterGatewayRecheckInterval = time.Minute
+
+// ClusterGatewayReconciler reconciles a ClusterGateway object
+type ClusterGatewayReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+}
+
+// +kubebuilder:rbac:groups=tacokumo.github.io,resources=clustergateways,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=tacokumo.github.io,resources=clustergateways/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=tacokumo.github.io,resources=clustergateways/finalizers,verbs=update
+// +kubebuilder:rbac:groups=tacokumo.github.io,resources=gateways,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=secrets,verbs=get
+
+// Reconcile is part of the main kubernetes reconciliation loop which aims to
+// move the current state of the cluster closer to the desired state.
+func (r *ClusterGatewayReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	logger := logf.FromContext(ctx)
+	cg := tacokumogithubiov1alpha1.ClusterGateway{}
+	if err := r.Get(ctx, types.NamespacedName{Name: req.Name}, &cg); err != nil {
+		if apierrors.IsNotFound(err) {
+			return ctrl.Result{}, nil
+		}
+		return ctrl.Result{}, err
+	}
+
+	manager := clustergateway.NewManager(logger, r.Client)
+
+	if err := manager.Reconcile(ctx, &cg); err != nil {
+		logger.Error(err, "failed to reconcile with manager")
+		return ctrl.Result{RequeueAfter: time.Second * 2}, nil
+	}
+	return ctrl.Result{RequeueAfter: clusterGatewayRecheckInterval}, nil
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *ClusterGatewayReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	// Gatewayが参照を追加､削除した場合に､保護しているApplicationの一覧を更新する
+	enqueueClusterGateways := handler.EnqueueRequestsFromMapFunc(func(_ context.Context, obj client.Object) []reconcile.Request {
+		gw, ok := obj.(*tacokumogithubiov1alpha1.Gateway)
+		if !ok {
+			return nil
+		}
+		var names []string
+		if gw.Spec.Authentication != nil {
+			names = append(names, gw.Spec.Authentication.ClusterGateway)
+		}
+		if gw.Status.ProtectedBy != "" {
+			names = append(names, gw.Status.ProtectedBy)
+		}
+		var requests []reconcile.Request
+		for _, name := range names {
+			requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{Name: name}})
+		}
+		return requests
+	})
+
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&tacokumogithubiov1alpha1.ClusterGateway{}).
+		Watches(&tacokumogithubiov1alpha1.Gateway{}, enqueueClusterGateways).
+		Named("clustergateway").
+		Complete(r)
+}
@@ -0,0 +1,99 @@
+/*
+MIT License
+
+Copyright (c) 2025 tacokumo
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package controller
+
+import (
+	"context"
+	"time"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+
+	tacokumogithubiov1alpha1 "github.com/tacokumo/portal-controller-kubernetes/api/v1alpha1"
+	"github.com/tacokumo/portal-controller-kubernetes/pkg/clustermigration"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+// clusterMigrationProbeInterval はマイグレーション中にヘルスチェックを行う間隔
+const clusterMigrationProbeInterval = 10 * time.Second
+
+// ClusterMigrationReconciler reconciles a ClusterMigration object
+type ClusterMigrationReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+}
+
+// +kubebuilder:rbac:groups=tacokumo.github.io,resources=clustermigrations,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=tacokumo.github.io,resources=clustermigrations/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=tacokumo.github.io,resources=clustermigrations/finalizers,verbs=update
+// +kubebuilder:rbac:groups=tacokumo.github.io,resources=gateways,verbs=get;list;watch
+
+// Reconcile is part of the main kubernetes reconciliation loop which aims to
+// move the current state of the cluster closer to the desired state.
+func (r *ClusterMigrationReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	logger := logf.FromContext(ctx)
+	key := types.NamespacedName{
+		Namespace: req.Namespace,
+		Name:      req.Name,
+	}
+	cm := tacokumogithubiov1alpha1.ClusterMigration{}
+	if err := r.Get(ctx, key, &cm); err != nil {
+		if apierrors.IsNotFound(err) {
+			return ctrl.Result{}, nil
+		}
+		return ctrl.Result{}, err
+	}
+
+	manager := clustermigration.NewManager(logger, r.Client)
+
+	if err := manager.Reconcile(ctx, &cm); err != nil {
+		logger.Error(err, "failed to reconcile with manager")
+		return ctrl.Result{RequeueAfter: time.Second * 2}, nil
+	}
+
+	switch cm.Status.Phase {
+	case tacokumogithubiov1alpha1.ClusterMigrationPhaseSucceeded, tacokumogithubiov1alpha1.ClusterMigrationPhaseAborted:
+		return ctrl.Result{}, nil
+	}
+	// ステップの間もヘルスチェックを続けるため､再度Requeueする
+	return ctrl.Result{RequeueAfter: clusterMigrationProbeInterval}, nil
+}
+
+// SetupWithManager sets up the controller with the Manager.
+// ヘルスチェックの結果をstatusに記録するたびにreconcileされると､
+// clusterMigrationProbeInterval より短い間隔でヘルスチェックが行われるため､specの更新のみをwatchする
+func (r *ClusterMigrationReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&tacokumogithubiov1alpha1.ClusterMigration{},
+			builder.WithPredicates(predicate.GenerationChangedPredicate{})).
+		Named("clustermigration").
+		Complete(r)
+}
@@ -0,0 +1,106 @@
+/*
+MIT License
+
+Copyright (c) 2025 tacokumo
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package controller
+
+import (
+	"context"
+	"time"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+
+	tacokumogithubiov1alpha1 "github.com/tacokumo/portal-controller-kubernetes/api/v1alpha1"
+	"github.com/tacokumo/portal-controller-kubernetes/pkg/dns"
+	"github.com/tacokumo/portal-controller-kubernetes/pkg/gateway"
+
+	networkingv1 "k8s.io/api/networking/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+// GatewayReconciler reconciles a Gateway object
+type GatewayReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+	// BaseDomain と DNSProvider が設定されている場合は､Gatewayのホスト名をDNSに登録する
+	BaseDomain  string
+	DNSProvider dns.Provider
+}
+
+// +kubebuilder:rbac:groups=tacokumo.github.io,resources=gateways,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=tacokumo.github.io,resources=gateways/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=tacokumo.github.io,resources=gateways/finalizers,verbs=update
+// +kubebuilder:rbac:groups=tacokumo.github.io,resources=applications,verbs=get
+// +kubebuilder:rbac:groups=tacokumo.github.io,resources=portals,verbs=get;list;watch
+// +kubebuilder:rbac:groups=tacokumo.github.io,resources=clustergateways,verbs=get;list;watch
+// +kubebuilder:rbac:groups=tacokumo.github.io,resources=clustermigrations,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=secrets;services,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups="",resources=namespaces,verbs=get
+// +kubebuilder:rbac:groups=apps,resources=deployments,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=cert-manager.io,resources=certificates,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=cert-manager.io,resources=issuers;clusterissuers,verbs=get
+// +kubebuilder:rbac:groups=networking.k8s.io,resources=ingresses,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=gateway.networking.k8s.io,resources=httproutes,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=gateway.networking.k8s.io,resources=gateways,verbs=get;list;watch
+
+// Reconcile is part of the main kubernetes reconciliation loop which aims to
+// move the current state of the cluster closer to the desired state.
+func (r *GatewayReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	logger := logf.FromContext(ctx)
+	key := types.NamespacedName{
+		Namespace: req.Namespace,
+		Name:      req.Name,
+	}
+	gw := tacokumogithubiov1alpha1.Gateway{}
+	if err := r.Get(ctx, key, &gw); err != nil {
+		if apierrors.IsNotFound(err) {
+			// 作成したIngressやHTTPRoute､認証プロキシや証明書はOwnerReferenceによって削除される
+			return ctrl.Result{}, nil
+		}
+		return ctrl.Result{}, err
+	}
+
+	manager := gateway.NewManager(logger, r.Client).
+		WithDNS(r.DNSProvider, r.BaseDomain)
+
+	if err := manager.Reconcile(ctx, &gw); err != nil {
+		logger.Error(err, "failed to reconcile with manager")
+		return ctrl.Result{RequeueAfter: time.Second * 2}, nil
+	}
+
+	// ロードバランサーのアドレスが割り当てられるのを待つため､再度Requeueする
+	return ctrl.Result{RequeueAfter: time.Second * 2}, nil
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *GatewayReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&tacokumogithubiov1alpha1.Gateway{}).
+		Owns(&networkingv1.Ingress{}).
+		Named("gateway").
+		Complete(r)
+}
@@ -0,0 +1,99 @@
+/*
+MIT License
+
+Copyright (c) 2025 tacokumo
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package controller
+
+import (
+	"context"
+	"time"
+
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	tacokumogithubiov1alpha1 "github.com/tacokumo/portal-controller-kubernetes/api/v1alpha1"
+	"github.com/tacokumo/portal-controller-kubernetes/pkg/networkdiscovery"
+)
+
+// networkingRediscoveryInterval はGateway APIのCRDのインストールなど､
+// watchで検知できないAPIの提供状況の変化を検出するための間隔
+const networkingRediscoveryInterval = 5 * time.Minute
+
+// NetworkingReconciler はクラスタで利用可能なIngressとGateway APIのコントローラーを検出し､
+// Portalのstatusに記録する
+type NetworkingReconciler struct {
+	client.Client
+	Scheme     *runtime.Scheme
+	Discoverer *networkdiscovery.Discoverer
+}
+
+// +kubebuilder:rbac:groups=tacokumo.github.io,resources=portals,verbs=get;list;watch
+// +kubebuilder:rbac:groups=tacokumo.github.io,resources=portals/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=networking.k8s.io,resources=ingressclasses,verbs=get;list;watch
+// +kubebuilder:rbac:groups=gateway.networking.k8s.io,resources=gatewayclasses,verbs=get;list;watch
+
+// Reconcile はどのPortalやクラスに対するイベントであっても､クラスタ全体の検出をやり直す
+func (r *NetworkingReconciler) Reconcile(ctx context.Context, _ ctrl.Request) (ctrl.Result, error) {
+	logger := logf.FromContext(ctx)
+
+	if err := r.Discoverer.Reconcile(ctx); err != nil {
+		logger.Error(err, "failed to discover networking controllers")
+		return ctrl.Result{RequeueAfter: time.Second * 2}, nil
+	}
+	return ctrl.Result{RequeueAfter: networkingRediscoveryInterval}, nil
+}
+
+// SetupWithManager sets up the controller with the Manager.
+// Gateway APIのGatewayClassは､起動時にAPIが提供されている場合のみwatchする
+func (r *NetworkingReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	// 検出結果はクラスタで一つなので､全てのイベントを同じリクエストにまとめる
+	enqueueDiscovery := handler.EnqueueRequestsFromMapFunc(func(context.Context, client.Object) []reconcile.Request {
+		return []reconcile.Request{{NamespacedName: client.ObjectKey{Name: "networking"}}}
+	})
+
+	b := ctrl.NewControllerManagedBy(mgr).
+		Named("networking").
+		Watches(&tacokumogithubiov1alpha1.Portal{}, enqueueDiscovery,
+			builder.WithPredicates(predicate.GenerationChangedPredicate{})).
+		Watches(&networkingv1.IngressClass{}, enqueueDiscovery)
+
+	gatewayAvailable, err := r.Discoverer.IsAvailable(
+		networkdiscovery.GatewayClassGVK.GroupVersion().String(), "gatewayclasses")
+	if err != nil {
+		return err
+	}
+	if gatewayAvailable {
+		gatewayClass := &unstructured.Unstructured{}
+		gatewayClass.SetGroupVersionKind(networkdiscovery.GatewayClassGVK)
+		b = b.Watches(gatewayClass, enqueueDiscovery)
+	}
+	return b.Complete(r)
+}
@@ -49,6 +49,7 @@ type PortalReconciler struct {
 // +kubebuilder:rbac:groups=tacokumo.github.io,resources=portals,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=tacokumo.github.io,resources=portals/status,verbs=get;update;patch
 // +kubebuilder:rbac:groups=tacokumo.github.io,resources=portals/finalizers,verbs=update
+// +kubebuilder:rbac:groups=core,resources=pods,verbs=get;list;watch
 
 // Reconcile is part of the main kubernetes reconciliation loop which aims to
 // move the current state of the cluster closer to the desired state.
@@ -36,6 +36,7 @@ import (
 	logf "sigs.k8s.io/controller-runtime/pkg/log"
 
 	tacokumogithubiov1alpha1 "github.com/tacokumo/portal-controller-kubernetes/api/v1alpha1"
+	"github.com/tacokumo/portal-controller-kubernetes/pkg/imageresolver"
 	"github.com/tacokumo/portal-controller-kubernetes/pkg/release"
 )
 
@@ -43,11 +44,26 @@ import (
 type ReleaseReconciler struct {
 	client.Client
 	Scheme *runtime.Scheme
+
+	// PlainHTTPRegistries はイメージのダイジェスト解決時にTLSを使用せずに接続するレジストリ
+	PlainHTTPRegistries []string
 }
 
 // +kubebuilder:rbac:groups=tacokumo.github.io,resources=releases,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=tacokumo.github.io,resources=releases/status,verbs=get;update;patch
 // +kubebuilder:rbac:groups=tacokumo.github.io,resources=releases/finalizers,verbs=update
+// +kubebuilder:rbac:groups=tacokumo.github.io,resources=resourcepolicies,verbs=get;list;watch
+// +kubebuilder:rbac:groups=tacokumo.github.io,resources=deploywindows,verbs=get;list;watch
+// +kubebuilder:rbac:groups=tacokumo.github.io,resources=applications,verbs=get
+// +kubebuilder:rbac:groups=tacokumo.github.io,resources=releaserevisions,verbs=get;list;watch;create;delete
+// +kubebuilder:rbac:groups=tacokumo.github.io,resources=releaserevisions/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=tacokumo.github.io,resources=servicebindings,verbs=get;list;watch
+// +kubebuilder:rbac:groups=core,resources=secrets,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=apps,resources=deployments,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=batch,resources=cronjobs,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=batch,resources=jobs,verbs=get;list;watch;create;delete
+// +kubebuilder:rbac:groups=core,resources=pods,verbs=get;list;watch
+// +kubebuilder:rbac:groups=core,resources=services,verbs=get;list;watch;create;update;patch;delete
 
 // Reconcile is part of the main kubernetes reconciliation loop which aims to
 // move the current state of the cluster closer to the desired state.
@@ -66,7 +82,8 @@ func (r *ReleaseReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ct
 		}
 	}
 
-	manager := release.NewManager(logger, r.Client, ".")
+	manager := release.NewManager(logger, r.Client, ".").
+		WithImageResolver(imageresolver.NewRegistryResolver().WithPlainHTTPRegistries(r.PlainHTTPRegistries...))
 
 	if err := manager.Reconcile(ctx, &rel); err != nil {
 		// TODO: いつもrequeueするべきかどうかを考える
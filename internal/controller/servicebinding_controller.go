@@ -0,0 +1,111 @@
+/*
+MIT License
+
+Copyright (c) 2025 tacokumo
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package controller
+
+import (
+	"context"
+	"time"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	tacokumogithubiov1alpha1 "github.com/tacokumo/portal-controller-kubernetes/api/v1alpha1"
+	"github.com/tacokumo/portal-controller-kubernetes/pkg/servicebinding"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+// serviceBindingRecheckInterval はwatchしていないSecretの変更を検出するための間隔
+const serviceBindingRecheckInterval = time.Minute
+
+// ServiceBindingReconciler reconciles a ServiceBinding object
+type ServiceBindingReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+}
+
+// +kubebuilder:rbac:groups=tacokumo.github.io,resources=servicebindings,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=tacokumo.github.io,resources=servicebindings/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=tacokumo.github.io,resources=servicebindings/finalizers,verbs=update
+// +kubebuilder:rbac:groups=tacokumo.github.io,resources=releases,verbs=get;list;watch
+// +kubebuilder:rbac:groups=core,resources=secrets,verbs=get
+
+// Reconcile is part of the main kubernetes reconciliation loop which aims to
+// move the current state of the cluster closer to the desired state.
+func (r *ServiceBindingReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	logger := logf.FromContext(ctx)
+	sb := tacokumogithubiov1alpha1.ServiceBinding{}
+	if err := r.Get(ctx, req.NamespacedName, &sb); err != nil {
+		if apierrors.IsNotFound(err) {
+			return ctrl.Result{}, nil
+		}
+		return ctrl.Result{}, err
+	}
+
+	manager := servicebinding.NewManager(logger, r.Client)
+
+	if err := manager.Reconcile(ctx, &sb); err != nil {
+		logger.Error(err, "failed to reconcile with manager")
+		return ctrl.Result{RequeueAfter: time.Second * 2}, nil
+	}
+	return ctrl.Result{RequeueAfter: serviceBindingRecheckInterval}, nil
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *ServiceBindingReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	// Releaseが作成､削除された場合に､接続情報が注入されるReleaseの一覧を更新する
+	enqueueServiceBindings := handler.EnqueueRequestsFromMapFunc(func(ctx context.Context, obj client.Object) []reconcile.Request {
+		rel, ok := obj.(*tacokumogithubiov1alpha1.Release)
+		if !ok {
+			return nil
+		}
+		application := rel.Labels[tacokumogithubiov1alpha1.ApplicationLabelKey]
+		if application == "" {
+			return nil
+		}
+		bindings, err := servicebinding.ForRelease(ctx, r.Client, rel.Namespace, application, rel.Spec.Stage)
+		if err != nil {
+			return nil
+		}
+		requests := make([]reconcile.Request, 0, len(bindings))
+		for _, sb := range bindings {
+			requests = append(requests, reconcile.Request{
+				NamespacedName: types.NamespacedName{Namespace: sb.Namespace, Name: sb.Name},
+			})
+		}
+		return requests
+	})
+
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&tacokumogithubiov1alpha1.ServiceBinding{}).
+		Watches(&tacokumogithubiov1alpha1.Release{}, enqueueServiceBindings).
+		Named("servicebinding").
+		Complete(r)
+}
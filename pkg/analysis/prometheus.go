@@ -0,0 +1,125 @@
+package analysis
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultQueryTimeout は1回のクエリのタイムアウト
+// 分析はReleaseのreconcileの中で行われるため､応答しないバックエンドでワーカーが止まらないようにする
+const defaultQueryTimeout = 30 * time.Second
+
+// PrometheusProvider は Prometheus 互換の HTTP API を使用する Provider の実装
+type PrometheusProvider struct {
+	httpClient *http.Client
+	// queryTimeout は WithHTTPClient で設定されたクライアントにも適用されるクエリのタイムアウト
+	queryTimeout time.Duration
+}
+
+// NewPrometheusProvider は PrometheusProvider を生成する
+func NewPrometheusProvider() *PrometheusProvider {
+	return &PrometheusProvider{
+		httpClient:   &http.Client{Timeout: defaultQueryTimeout},
+		queryTimeout: defaultQueryTimeout,
+	}
+}
+
+// WithHTTPClient は分析バックエンドとの通信に使用する http.Client を設定する
+func (p *PrometheusProvider) WithHTTPClient(c *http.Client) *PrometheusProvider {
+	p.httpClient = c
+	return p
+}
+
+// queryResponse は /api/v1/query のレスポンス
+type queryResponse struct {
+	Status    string `json:"status"`
+	ErrorType string `json:"errorType"`
+	Error     string `json:"error"`
+	Data      struct {
+		ResultType string          `json:"resultType"`
+		Result     json.RawMessage `json:"result"`
+	} `json:"data"`
+}
+
+// vectorSample は instant vector の要素
+type vectorSample struct {
+	Metric map[string]string `json:"metric"`
+	Value  []interface{}     `json:"value"`
+}
+
+// Query は instant query を実行し､結果のスカラー値を返す
+// 結果が vector の場合はちょうど1つの要素を持つ必要がある
+func (p *PrometheusProvider) Query(ctx context.Context, address string, query string) (float64, error) {
+	endpoint, err := url.JoinPath(address, "api", "v1", "query")
+	if err != nil {
+		return 0, fmt.Errorf("invalid analysis address %q: %w", address, err)
+	}
+	ctx, cancel := context.WithTimeout(ctx, p.queryTimeout)
+	defer cancel()
+
+	form := url.Values{"query": []string{query}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("failed to query %s: %w", endpoint, err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return 0, err
+	}
+
+	var qr queryResponse
+	if err := json.Unmarshal(body, &qr); err != nil {
+		return 0, fmt.Errorf("unexpected response from %s (status %d): %w", endpoint, resp.StatusCode, err)
+	}
+	if qr.Status != "success" {
+		return 0, fmt.Errorf("query failed with %s: %s", qr.ErrorType, qr.Error)
+	}
+
+	switch qr.Data.ResultType {
+	case "scalar":
+		var sample []interface{}
+		if err := json.Unmarshal(qr.Data.Result, &sample); err != nil {
+			return 0, err
+		}
+		return sampleValue(sample)
+	case "vector":
+		var samples []vectorSample
+		if err := json.Unmarshal(qr.Data.Result, &samples); err != nil {
+			return 0, err
+		}
+		if len(samples) != 1 {
+			return 0, fmt.Errorf("query returned %d series, expected exactly 1", len(samples))
+		}
+		return sampleValue(samples[0].Value)
+	default:
+		return 0, fmt.Errorf("unsupported result type %q", qr.Data.ResultType)
+	}
+}
+
+// sampleValue は [<timestamp>, "<value>"] 形式のサンプルから値を取り出す
+func sampleValue(sample []interface{}) (float64, error) {
+	if len(sample) != 2 {
+		return 0, fmt.Errorf("malformed sample: %v", sample)
+	}
+	s, ok := sample[1].(string)
+	if !ok {
+		return 0, fmt.Errorf("malformed sample value: %v", sample[1])
+	}
+	return strconv.ParseFloat(s, 64)
+}
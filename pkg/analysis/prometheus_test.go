@@ -0,0 +1,127 @@
+package analysis
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/utils/ptr"
+)
+
+// startTestBackend はクエリごとに固定のレスポンスを返す Prometheus 互換のサーバーを起動する
+func startTestBackend(t *testing.T, responses map[string]string) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/prometheus/api/v1/query" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if err := req.ParseForm(); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		body, ok := responses[req.Form.Get("query")]
+		if !ok {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = fmt.Fprint(w, `{"status":"error","errorType":"bad_data","error":"parse error"}`)
+			return
+		}
+		_, _ = fmt.Fprint(w, body)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestPrometheusProvider_Query(t *testing.T) {
+	server := startTestBackend(t, map[string]string{
+		"scalar(success_rate)": `{"status":"success","data":{"resultType":"scalar","result":[1700000000.1,"0.995"]}}`,
+		"error_rate": `{"status":"success","data":{"resultType":"vector",` +
+			`"result":[{"metric":{"app":"test"},"value":[1700000000.1,"0.02"]}]}}`,
+		"empty": `{"status":"success","data":{"resultType":"vector","result":[]}}`,
+		"multiple": `{"status":"success","data":{"resultType":"vector",` +
+			`"result":[{"metric":{"a":"1"},"value":[1,"1"]},{"metric":{"a":"2"},"value":[1,"2"]}]}}`,
+		"matrix": `{"status":"success","data":{"resultType":"matrix","result":[]}}`,
+	})
+
+	tests := []struct {
+		name    string
+		query   string
+		want    float64
+		wantErr string
+	}{
+		{name: "scalar", query: "scalar(success_rate)", want: 0.995},
+		{name: "single element vector", query: "error_rate", want: 0.02},
+		{name: "empty vector", query: "empty", wantErr: "returned 0 series"},
+		{name: "multiple series", query: "multiple", wantErr: "returned 2 series"},
+		{name: "unsupported result type", query: "matrix", wantErr: "unsupported result type"},
+		{name: "query error", query: "rate(", wantErr: "bad_data: parse error"},
+	}
+
+	provider := NewPrometheusProvider().WithHTTPClient(server.Client())
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := provider.Query(context.Background(), server.URL+"/prometheus", tt.query)
+			if tt.wantErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.InDelta(t, tt.want, got, 1e-9)
+		})
+	}
+}
+
+func TestPrometheusProvider_Query_Timeout(t *testing.T) {
+	// 応答しないバックエンドでreconcileが止まらないよう､クエリはタイムアウトする
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		<-release
+	}))
+	t.Cleanup(server.Close)
+	t.Cleanup(func() { close(release) })
+
+	provider := NewPrometheusProvider().WithHTTPClient(server.Client())
+	provider.queryTimeout = 50 * time.Millisecond
+
+	_, err := provider.Query(context.Background(), server.URL, "up")
+	require.Error(t, err)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestThreshold_Evaluate(t *testing.T) {
+	tests := []struct {
+		name      string
+		threshold Threshold
+		value     float64
+		want      bool
+		wantErr   bool
+	}{
+		{name: "no bounds", threshold: Threshold{}, value: 42, want: true},
+		{name: "within bounds", threshold: Threshold{Min: ptr.To("0.9"), Max: ptr.To("1")}, value: 0.95, want: true},
+		{name: "below min", threshold: Threshold{Min: ptr.To("0.99")}, value: 0.95, want: false},
+		{name: "above max", threshold: Threshold{Max: ptr.To("0.01")}, value: 0.05, want: false},
+		{name: "equal to max", threshold: Threshold{Max: ptr.To("0.05")}, value: 0.05, want: true},
+		{name: "invalid threshold", threshold: Threshold{Max: ptr.To("high")}, value: 1, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, reason, err := tt.threshold.Evaluate(tt.value)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+			if !tt.want {
+				assert.NotEmpty(t, reason)
+			}
+		})
+	}
+}
@@ -0,0 +1,48 @@
+package analysis
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// Provider はカナリアリリースの分析に使用するメトリクスの取得元
+type Provider interface {
+	// Query は address の分析バックエンドで query を実行し､結果のスカラー値を返す
+	Query(ctx context.Context, address string, query string) (float64, error)
+}
+
+// Threshold はメトリクスの値が満たすべき範囲
+// Min, Max は指定されたもののみ評価される
+type Threshold struct {
+	Min *string
+	Max *string
+}
+
+// Evaluate は value が閾値の範囲内かどうかを返す
+// 範囲外の場合はその理由を返す
+func (t Threshold) Evaluate(value float64) (bool, string, error) {
+	if math.IsNaN(value) {
+		return false, "value is NaN", nil
+	}
+	if t.Min != nil {
+		lower, err := strconv.ParseFloat(*t.Min, 64)
+		if err != nil {
+			return false, "", fmt.Errorf("invalid min threshold %q: %w", *t.Min, err)
+		}
+		if value < lower {
+			return false, fmt.Sprintf("value %g is below the minimum %g", value, lower), nil
+		}
+	}
+	if t.Max != nil {
+		upper, err := strconv.ParseFloat(*t.Max, 64)
+		if err != nil {
+			return false, "", fmt.Errorf("invalid max threshold %q: %w", *t.Max, err)
+		}
+		if value > upper {
+			return false, fmt.Sprintf("value %g is above the maximum %g", value, upper), nil
+		}
+	}
+	return true, "", nil
+}
@@ -0,0 +1,372 @@
+package appconfigext
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	appconfig "github.com/tacokumo/appconfig"
+
+	"go.yaml.in/yaml/v3"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// AppConfig は tacokumo/appconfig の AppConfig に､
+// 上流のスキーマにまだ取り込まれていない拡張設定を加えたもの
+// 拡張設定は同じappconfig.yamlから読み込まれる
+type AppConfig struct {
+	appconfig.AppConfig
+
+	// Ext は上流の AppConfig が解釈しない拡張設定を示します
+	Ext Extension
+}
+
+// Extension は appconfig.yaml のうち､このコントローラが独自に解釈する設定を表す
+type Extension struct {
+	// Service はサービスの拡張設定
+	Service ServiceExtension `json:"service" yaml:"service"`
+	// Stages はStageごとの拡張設定
+	// `stages` の各要素に `service` を追加する形で記述する
+	Stages []StageExtension `json:"stages,omitempty" yaml:"stages,omitempty"`
+	// Processes はメインのサービス以外に起動するプロセス
+	Processes []ProcessConfig `json:"processes,omitempty" yaml:"processes,omitempty"`
+	// Secrets はリポジトリにコミットされた暗号化された環境変数の設定
+	Secrets *SecretsConfig `json:"secrets,omitempty" yaml:"secrets,omitempty"`
+}
+
+// SecretsConfig は暗号化された環境変数のファイルの設定を表す
+type SecretsConfig struct {
+	// EncryptedFile はリポジトリのルートからの暗号化されたファイルのパス
+	// ファイルはdotenv形式で､値はクラスタの公開鍵で暗号化された `ENC[...]` の形式で記述する
+	EncryptedFile string `json:"encrypted_file,omitempty" yaml:"encrypted_file,omitempty"`
+}
+
+// ServiceExtension はサービスの拡張設定を表す
+type ServiceExtension struct {
+	// Type はServiceの種類
+	// 何も指定されない場合は ClusterIP が使用される
+	Type string `json:"type,omitempty" yaml:"type,omitempty"`
+	// Ports はHTTP以外も含むサービスのポート設定
+	// `service.http` と併用した場合は両方が公開される
+	Ports []ServicePortConfig `json:"ports,omitempty" yaml:"ports,omitempty"`
+	// Resources はコンテナのrequests/limitsを個別に指定する
+	// `service.machine_config` から決まる値を項目ごとに上書きする
+	Resources *ResourcesConfig `json:"resources,omitempty" yaml:"resources,omitempty"`
+	// Env はコンテナに設定する環境変数
+	Env map[string]string `json:"env,omitempty" yaml:"env,omitempty"`
+}
+
+// StageExtension はStageごとの拡張設定を表す
+type StageExtension struct {
+	// Name は上書き対象のStageの名前
+	Name string `json:"name" yaml:"name"`
+	// Service は `service` の設定に上書きマージされる
+	Service *ServiceOverride `json:"service,omitempty" yaml:"service,omitempty"`
+	// Secrets は `secrets` の設定を置き換える
+	// Stageごとに異なる値を持つファイルを参照させるために使用する
+	Secrets *SecretsConfig `json:"secrets,omitempty" yaml:"secrets,omitempty"`
+}
+
+// ServiceOverride はStageごとに上書きできるサービスの設定を表す
+// 指定された項目のみが上書きされる
+type ServiceOverride struct {
+	// Scale はレプリカ数の設定
+	Scale *ScaleOverride `json:"scale,omitempty" yaml:"scale,omitempty"`
+	// MachineConfig はマシンの設定
+	MachineConfig *MachineConfigOverride `json:"machine_config,omitempty" yaml:"machine_config,omitempty"`
+	// Resources はコンテナのrequests/limitsの設定
+	Resources *ResourcesConfig `json:"resources,omitempty" yaml:"resources,omitempty"`
+	// Env は環境変数
+	// 同じ名前の環境変数はStageの値が優先される
+	Env map[string]string `json:"env,omitempty" yaml:"env,omitempty"`
+}
+
+// ScaleOverride はレプリカ数の上書き設定を表す
+type ScaleOverride struct {
+	Min *int `json:"min,omitempty" yaml:"min,omitempty"`
+	Max *int `json:"max,omitempty" yaml:"max,omitempty"`
+}
+
+// MachineConfigOverride はマシン設定の上書き設定を表す
+type MachineConfigOverride struct {
+	CPU    string `json:"cpu,omitempty" yaml:"cpu,omitempty"`
+	Memory string `json:"memory,omitempty" yaml:"memory,omitempty"`
+	Flavor string `json:"flavor,omitempty" yaml:"flavor,omitempty"`
+}
+
+// ResourcesConfig はコンテナのrequests/limitsの設定を表す
+type ResourcesConfig struct {
+	// Requests はスケジューリング時に確保されるリソース量
+	Requests ResourceValues `json:"requests,omitempty" yaml:"requests,omitempty"`
+	// Limits はコンテナが使用できるリソース量の上限
+	Limits ResourceValues `json:"limits,omitempty" yaml:"limits,omitempty"`
+}
+
+// ResourceValues はCPUとメモリのリソース量を表す
+type ResourceValues struct {
+	// CPU はCPUリソースの量(例: 100m)
+	CPU string `json:"cpu,omitempty" yaml:"cpu,omitempty"`
+	// Memory はメモリリソースの量(例: 128Mi)
+	Memory string `json:"memory,omitempty" yaml:"memory,omitempty"`
+}
+
+// ServicePortConfig はServiceのポート設定を表す
+type ServicePortConfig struct {
+	// Name はポートの名前
+	// 何も指定されない場合は プロトコルとポート番号から生成される
+	Name string `json:"name,omitempty" yaml:"name,omitempty"`
+	// Port はServiceが公開するポート
+	Port int `json:"port" yaml:"port"`
+	// TargetPort はコンテナがリッスンするポート
+	// 何も指定されない場合は Port と同じ値が使用される
+	TargetPort int `json:"target_port,omitempty" yaml:"target_port,omitempty"`
+	// Protocol はL4のプロトコル(TCP, UDP, SCTP)
+	// 何も指定されない場合は TCP が使用される
+	Protocol string `json:"protocol,omitempty" yaml:"protocol,omitempty"`
+	// AppProtocol はアプリケーション層のプロトコルのヒント(grpc, http, h2cなど)
+	AppProtocol string `json:"app_protocol,omitempty" yaml:"app_protocol,omitempty"`
+}
+
+const (
+	ServiceTypeClusterIP    = "ClusterIP"
+	ServiceTypeNodePort     = "NodePort"
+	ServiceTypeLoadBalancer = "LoadBalancer"
+
+	ProtocolTCP  = "TCP"
+	ProtocolUDP  = "UDP"
+	ProtocolSCTP = "SCTP"
+)
+
+// Parse はappconfig.yamlの内容を AppConfig として読み込む
+func Parse(data []byte) (AppConfig, error) {
+	var cfg AppConfig
+	if err := yaml.Unmarshal(data, &cfg.AppConfig); err != nil {
+		return AppConfig{}, err
+	}
+	if err := yaml.Unmarshal(data, &cfg.Ext); err != nil {
+		return AppConfig{}, err
+	}
+	return cfg, nil
+}
+
+// Validate は拡張設定のバリデーションを行う
+// 上流の AppConfig のバリデーションは含まれない
+func (c *AppConfig) Validate() error {
+	switch c.Ext.Service.Type {
+	case "", ServiceTypeClusterIP, ServiceTypeNodePort, ServiceTypeLoadBalancer:
+	default:
+		return fmt.Errorf("service.type: unsupported service type %q", c.Ext.Service.Type)
+	}
+	if scale := c.Service.Scale; scale != nil && scale.Min > scale.Max {
+		return fmt.Errorf("service.scale: min %d must not exceed max %d", scale.Min, scale.Max)
+	}
+	stages := map[string]struct{}{}
+	for _, stage := range c.Ext.Stages {
+		if _, ok := stages[stage.Name]; ok {
+			return fmt.Errorf("stages: duplicate stage %q", stage.Name)
+		}
+		stages[stage.Name] = struct{}{}
+	}
+	return errors.Join(
+		ValidateServicePorts(c.ServicePorts()),
+		ValidateProcesses(c.Ext.Processes),
+		ValidateReleaseHooks(c.Releases),
+	)
+}
+
+// ForStage は指定されたStageの上書き設定を `service` にマージした AppConfig を返す
+// 上書き設定がないStageの場合はそのままの設定を返す
+// レシーバは変更されない
+func (c *AppConfig) ForStage(stage string) AppConfig {
+	merged := *c
+	var override *ServiceOverride
+	for _, s := range c.Ext.Stages {
+		if s.Name == stage {
+			override = s.Service
+			if s.Secrets != nil {
+				merged.Ext.Secrets = s.Secrets
+			}
+			break
+		}
+	}
+	if override == nil {
+		return merged
+	}
+
+	if override.Scale != nil {
+		scale := appconfig.ServiceScaleConfig{Min: 1, Max: 1}
+		if c.Service.Scale != nil {
+			scale = *c.Service.Scale
+		}
+		if override.Scale.Min != nil {
+			scale.Min = *override.Scale.Min
+		}
+		if override.Scale.Max != nil {
+			scale.Max = *override.Scale.Max
+		}
+		merged.Service.Scale = &scale
+	}
+
+	if override.MachineConfig != nil {
+		machine := appconfig.MachineConfig{}
+		if c.Service.MachineConfig != nil {
+			machine = *c.Service.MachineConfig
+		}
+		machine.CPU = overrideString(machine.CPU, override.MachineConfig.CPU)
+		machine.Memory = overrideString(machine.Memory, override.MachineConfig.Memory)
+		machine.Flavor = overrideString(machine.Flavor, override.MachineConfig.Flavor)
+		merged.Service.MachineConfig = &machine
+	}
+
+	if override.Resources != nil {
+		merged.Ext.Service.Resources = mergeResources(c.Ext.Service.Resources, override.Resources)
+	}
+
+	if len(override.Env) > 0 {
+		env := make(map[string]string, len(c.Ext.Service.Env)+len(override.Env))
+		for k, v := range c.Ext.Service.Env {
+			env[k] = v
+		}
+		for k, v := range override.Env {
+			env[k] = v
+		}
+		merged.Ext.Service.Env = env
+	}
+	return merged
+}
+
+// mergeResources は base に override で指定された項目を上書きした ResourcesConfig を返す
+func mergeResources(base *ResourcesConfig, override *ResourcesConfig) *ResourcesConfig {
+	merged := ResourcesConfig{}
+	if base != nil {
+		merged = *base
+	}
+	merged.Requests = merged.Requests.merge(override.Requests)
+	merged.Limits = merged.Limits.merge(override.Limits)
+	return &merged
+}
+
+// merge は other で指定された項目を上書きした ResourceValues を返す
+func (v ResourceValues) merge(other ResourceValues) ResourceValues {
+	return ResourceValues{
+		CPU:    overrideString(v.CPU, other.CPU),
+		Memory: overrideString(v.Memory, other.Memory),
+	}
+}
+
+func overrideString(base, override string) string {
+	if override != "" {
+		return override
+	}
+	return base
+}
+
+// EncryptedSecretsFile は暗号化された環境変数のファイルのパスを返す
+// 設定されていない場合は空文字を返す
+func (c *AppConfig) EncryptedSecretsFile() string {
+	if c.Ext.Secrets == nil {
+		return ""
+	}
+	return c.Ext.Secrets.EncryptedFile
+}
+
+// ServicePorts は `service.http` と `service.ports` を合わせたポート設定を返す
+// `service.http` は後方互換のため target_port をServiceのポートとしても使用する
+func (c *AppConfig) ServicePorts() []ServicePortConfig {
+	ports := make([]ServicePortConfig, 0, len(c.Service.HTTP)+len(c.Ext.Service.Ports))
+	for _, h := range c.Service.HTTP {
+		ports = append(ports, ServicePortConfig{
+			Name:       fmt.Sprintf("http-%d", h.TargetPort),
+			Port:       h.TargetPort,
+			TargetPort: h.TargetPort,
+			Protocol:   ProtocolTCP,
+		})
+	}
+	return append(ports, c.Ext.Service.Ports...)
+}
+
+// ValidateServicePorts はポート設定のバリデーションを行う
+// ポート名､あるいはプロトコルとポート番号の組が重複している場合はエラーとなる
+// ポート名はServiceとコンテナのポート名に使用されるため､IANA_SVC_NAMEである必要がある
+func ValidateServicePorts(ports []ServicePortConfig) error {
+	var errs []error
+	names := map[string]struct{}{}
+	numbers := map[string]struct{}{}
+	for _, p := range ports {
+		name := p.NormalizedName()
+		for _, msg := range validation.IsValidPortName(name) {
+			errs = append(errs, fmt.Errorf("service port %q: invalid name: %s", name, msg))
+		}
+		if p.Port < 1 || p.Port > 65535 {
+			errs = append(errs, fmt.Errorf("service port %q: port %d is out of range", name, p.Port))
+		}
+		if p.TargetPort != 0 && (p.TargetPort < 1 || p.TargetPort > 65535) {
+			errs = append(errs, fmt.Errorf("service port %q: target_port %d is out of range", name, p.TargetPort))
+		}
+		switch p.NormalizedProtocol() {
+		case ProtocolTCP, ProtocolUDP, ProtocolSCTP:
+		default:
+			errs = append(errs, fmt.Errorf("service port %q: unsupported protocol %q", name, p.Protocol))
+		}
+
+		if _, ok := names[name]; ok {
+			errs = append(errs, fmt.Errorf("service port %q: duplicate port name", name))
+		}
+		names[name] = struct{}{}
+
+		// 同じポート番号でもプロトコルが異なれば共存できる(例: DNSのTCP/UDP 53)
+		key := fmt.Sprintf("%d/%s", p.Port, p.NormalizedProtocol())
+		if _, ok := numbers[key]; ok {
+			errs = append(errs, fmt.Errorf("service port %q: duplicate port %s", name, key))
+		}
+		numbers[key] = struct{}{}
+	}
+	return errors.Join(errs...)
+}
+
+// ValidateReleaseHooks は `releases` に宣言されたpre-deployフックのバリデーションを行う
+// フックの名前はJobの名前とラベルの値に使用されるため､DNS-1123ラベルである必要がある
+func ValidateReleaseHooks(hooks []appconfig.ReleaseConfig) error {
+	var errs []error
+	names := map[string]struct{}{}
+	for _, hook := range hooks {
+		for _, msg := range validation.IsDNS1123Label(hook.Name) {
+			errs = append(errs, fmt.Errorf("release %q: invalid name: %s", hook.Name, msg))
+		}
+		if _, ok := names[hook.Name]; ok {
+			errs = append(errs, fmt.Errorf("release %q: duplicate release name", hook.Name))
+		}
+		names[hook.Name] = struct{}{}
+	}
+	return errors.Join(errs...)
+}
+
+// NormalizedProtocol はデフォルト値を考慮したプロトコルを返す
+func (p ServicePortConfig) NormalizedProtocol() string {
+	if p.Protocol == "" {
+		return ProtocolTCP
+	}
+	return strings.ToUpper(p.Protocol)
+}
+
+// NormalizedTargetPort はデフォルト値を考慮したターゲットポートを返す
+func (p ServicePortConfig) NormalizedTargetPort() int {
+	if p.TargetPort == 0 {
+		return p.Port
+	}
+	return p.TargetPort
+}
+
+// NormalizedName はデフォルト値を考慮したポート名を返す
+// 名前が指定されていない場合は `<app_protocol or protocol>-<port>` となる
+func (p ServicePortConfig) NormalizedName() string {
+	if p.Name != "" {
+		return p.Name
+	}
+	prefix := strings.ToLower(p.NormalizedProtocol())
+	if p.AppProtocol != "" {
+		// kubernetes.io/h2c のような接頭辞付きの値はポート名に使えないため末尾のみを使う
+		prefix = strings.ToLower(p.AppProtocol[strings.LastIndex(p.AppProtocol, "/")+1:])
+	}
+	return fmt.Sprintf("%s-%d", prefix, p.Port)
+}
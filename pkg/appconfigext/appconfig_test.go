@@ -0,0 +1,385 @@
+package appconfigext
+
+import (
+	"testing"
+
+	appconfig "github.com/tacokumo/appconfig"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	data := []byte(`
+app_name: test-app
+build:
+  image: "example.com/test-app:v1"
+service:
+  name: web
+  command: ["./server"]
+  http:
+    - target_port: 8080
+  type: LoadBalancer
+  ports:
+    - name: grpc
+      port: 443
+      target_port: 50051
+      app_protocol: grpc
+  resources:
+    requests:
+      cpu: 100m
+    limits:
+      memory: 256Mi
+`)
+
+	cfg, err := Parse(data)
+	require.NoError(t, err)
+
+	assert.Equal(t, "test-app", cfg.AppName)
+	assert.Equal(t, "web", cfg.Service.Name)
+	require.Len(t, cfg.Service.HTTP, 1)
+	assert.Equal(t, "LoadBalancer", cfg.Ext.Service.Type)
+	assert.Equal(t, []ServicePortConfig{
+		{Name: "grpc", Port: 443, TargetPort: 50051, AppProtocol: "grpc"},
+	}, cfg.Ext.Service.Ports)
+	require.NotNil(t, cfg.Ext.Service.Resources)
+	assert.Equal(t, ResourcesConfig{
+		Requests: ResourceValues{CPU: "100m"},
+		Limits:   ResourceValues{Memory: "256Mi"},
+	}, *cfg.Ext.Service.Resources)
+}
+
+func TestParse_InvalidYAML(t *testing.T) {
+	_, err := Parse([]byte("service:\n  http:\n    target_port: 3000\n"))
+	assert.Error(t, err)
+}
+
+func TestValidateServicePorts(t *testing.T) {
+	tests := []struct {
+		name         string
+		ports        []ServicePortConfig
+		expectErrMsg string
+	}{
+		{
+			name: "accepts multiple named ports",
+			ports: []ServicePortConfig{
+				{Name: "http", Port: 80, TargetPort: 8080},
+				{Name: "grpc", Port: 443, TargetPort: 50051, AppProtocol: "grpc"},
+			},
+		},
+		{
+			name: "accepts same number with different protocols",
+			ports: []ServicePortConfig{
+				{Port: 53, Protocol: "TCP"},
+				{Port: 53, Protocol: "UDP"},
+			},
+		},
+		{
+			name: "rejects duplicate names",
+			ports: []ServicePortConfig{
+				{Name: "api", Port: 80},
+				{Name: "api", Port: 81},
+			},
+			expectErrMsg: `service port "api": duplicate port name`,
+		},
+		{
+			name: "rejects duplicate numbers",
+			ports: []ServicePortConfig{
+				{Name: "a", Port: 80},
+				{Name: "b", Port: 80, Protocol: "tcp"},
+			},
+			expectErrMsg: "duplicate port 80/TCP",
+		},
+		{
+			name:         "rejects unsupported protocol",
+			ports:        []ServicePortConfig{{Port: 80, Protocol: "QUIC"}},
+			expectErrMsg: `unsupported protocol "QUIC"`,
+		},
+		{
+			name:         "rejects out of range port",
+			ports:        []ServicePortConfig{{Name: "big", Port: 70000}},
+			expectErrMsg: "port 70000 is out of range",
+		},
+		{
+			name:         "rejects too long name",
+			ports:        []ServicePortConfig{{Name: "very-long-port-name", Port: 80}},
+			expectErrMsg: `service port "very-long-port-name": invalid name`,
+		},
+		{
+			name:         "rejects uppercase name",
+			ports:        []ServicePortConfig{{Name: "HTTP", Port: 80}},
+			expectErrMsg: `service port "HTTP": invalid name`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateServicePorts(tt.ports)
+			if tt.expectErrMsg == "" {
+				assert.NoError(t, err)
+			} else {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.expectErrMsg)
+			}
+		})
+	}
+}
+
+func TestServicePortConfig_NormalizedName(t *testing.T) {
+	assert.Equal(t, "tcp-80", ServicePortConfig{Port: 80}.NormalizedName())
+	assert.Equal(t, "udp-53", ServicePortConfig{Port: 53, Protocol: "udp"}.NormalizedName())
+	assert.Equal(t, "h2c-8080", ServicePortConfig{Port: 8080, AppProtocol: "kubernetes.io/h2c"}.NormalizedName())
+	assert.Equal(t, "custom", ServicePortConfig{Name: "custom", Port: 80}.NormalizedName())
+}
+
+func TestAppConfig_ForStage(t *testing.T) {
+	data := []byte(`
+app_name: test-app
+service:
+  name: web
+  scale:
+    min: 1
+    max: 2
+  machine_config:
+    cpu: 500m
+    memory: 256Mi
+  resources:
+    requests:
+      cpu: 100m
+  env:
+    LOG_LEVEL: info
+    APP_ENV: base
+stages:
+  - name: staging
+    policy:
+      type: branch
+      branch:
+        name: staging
+    service:
+      env:
+        APP_ENV: staging
+  - name: production
+    policy:
+      type: branch
+      branch:
+        name: main
+    service:
+      scale:
+        max: 10
+      machine_config:
+        memory: 1Gi
+      resources:
+        requests:
+          memory: 512Mi
+`)
+
+	cfg, err := Parse(data)
+	require.NoError(t, err)
+	require.NoError(t, cfg.Validate())
+
+	staging := cfg.ForStage("staging")
+	assert.Equal(t, map[string]string{"LOG_LEVEL": "info", "APP_ENV": "staging"}, staging.Ext.Service.Env)
+	assert.Equal(t, 2, staging.Service.Scale.Max)
+
+	production := cfg.ForStage("production")
+	assert.Equal(t, 1, production.Service.Scale.Min)
+	assert.Equal(t, 10, production.Service.Scale.Max)
+	assert.Equal(t, "500m", production.Service.MachineConfig.CPU)
+	assert.Equal(t, "1Gi", production.Service.MachineConfig.Memory)
+	assert.Equal(t, ResourceValues{CPU: "100m", Memory: "512Mi"}, production.Ext.Service.Resources.Requests)
+	assert.Equal(t, map[string]string{"LOG_LEVEL": "info", "APP_ENV": "base"}, production.Ext.Service.Env)
+
+	// 元の設定は変更されない
+	assert.Equal(t, 2, cfg.Service.Scale.Max)
+	assert.Equal(t, "256Mi", cfg.Service.MachineConfig.Memory)
+	assert.Equal(t, ResourceValues{CPU: "100m"}, cfg.Ext.Service.Resources.Requests)
+	assert.Equal(t, "base", cfg.Ext.Service.Env["APP_ENV"])
+
+	unknown := cfg.ForStage("unknown")
+	assert.Equal(t, cfg, unknown)
+}
+
+func TestAppConfig_ForStage_Secrets(t *testing.T) {
+	data := []byte(`
+app_name: test-app
+service:
+  name: web
+secrets:
+  encrypted_file: secrets.enc.env
+stages:
+  - name: staging
+    policy:
+      type: branch
+      branch:
+        name: staging
+  - name: production
+    policy:
+      type: branch
+      branch:
+        name: main
+    secrets:
+      encrypted_file: secrets.production.enc.env
+`)
+
+	cfg, err := Parse(data)
+	require.NoError(t, err)
+
+	staging := cfg.ForStage("staging")
+	assert.Equal(t, "secrets.enc.env", staging.EncryptedSecretsFile())
+
+	production := cfg.ForStage("production")
+	assert.Equal(t, "secrets.production.enc.env", production.EncryptedSecretsFile())
+
+	// 元の設定は変更されない
+	assert.Equal(t, "secrets.enc.env", cfg.EncryptedSecretsFile())
+	assert.Empty(t, (&AppConfig{}).EncryptedSecretsFile())
+}
+
+func TestAppConfig_Validate_Stages(t *testing.T) {
+	cfg := AppConfig{Ext: Extension{Stages: []StageExtension{{Name: "staging"}, {Name: "staging"}}}}
+	err := cfg.Validate()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `duplicate stage "staging"`)
+}
+
+func TestValidateReleaseHooks(t *testing.T) {
+	tests := []struct {
+		name         string
+		hooks        []appconfig.ReleaseConfig
+		expectErrMsg string
+	}{
+		{
+			name:  "accepts DNS-1123 label names",
+			hooks: []appconfig.ReleaseConfig{{Name: "migrate"}, {Name: "seed-data"}},
+		},
+		{
+			name:         "rejects name with underscore",
+			hooks:        []appconfig.ReleaseConfig{{Name: "db_migrate"}},
+			expectErrMsg: `release "db_migrate": invalid name`,
+		},
+		{
+			name:         "rejects name with upper case and spaces",
+			hooks:        []appconfig.ReleaseConfig{{Name: "Run Migrations"}},
+			expectErrMsg: `release "Run Migrations": invalid name`,
+		},
+		{
+			name:         "rejects duplicate names",
+			hooks:        []appconfig.ReleaseConfig{{Name: "migrate"}, {Name: "migrate"}},
+			expectErrMsg: `release "migrate": duplicate release name`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateReleaseHooks(tt.hooks)
+			if tt.expectErrMsg == "" {
+				assert.NoError(t, err)
+				return
+			}
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.expectErrMsg)
+		})
+	}
+}
+
+func TestValidateProcesses(t *testing.T) {
+	replicas := -1
+	tests := []struct {
+		name         string
+		processes    []ProcessConfig
+		expectErrMsg string
+	}{
+		{
+			name: "accepts worker and cron",
+			processes: []ProcessConfig{
+				{Name: "worker", Type: ProcessTypeWorker, Command: []string{"./worker"}},
+				{Name: "cleanup", Type: ProcessTypeCron, Command: []string{"./cleanup"}, Schedule: "@daily"},
+			},
+		},
+		{
+			name: "rejects invalid name",
+			processes: []ProcessConfig{
+				{Name: "Queue_Worker", Type: ProcessTypeWorker, Command: []string{"./worker"}},
+			},
+			expectErrMsg: `process "Queue_Worker": invalid name`,
+		},
+		{
+			name: "rejects name reserved by the controller",
+			processes: []ProcessConfig{
+				{Name: "canary", Type: ProcessTypeWorker, Command: []string{"./worker"}},
+			},
+			expectErrMsg: `process "canary": name is reserved by the controller`,
+		},
+		{
+			name: "rejects name of a blue/green color",
+			processes: []ProcessConfig{
+				{Name: "green", Type: ProcessTypeWorker, Command: []string{"./worker"}},
+			},
+			expectErrMsg: `process "green": name is reserved by the controller`,
+		},
+		{
+			name: "rejects duplicate names",
+			processes: []ProcessConfig{
+				{Name: "worker", Type: ProcessTypeWorker, Command: []string{"./worker"}},
+				{Name: "worker", Type: ProcessTypeWorker, Command: []string{"./worker"}},
+			},
+			expectErrMsg: `process "worker": duplicate process name`,
+		},
+		{
+			name:         "rejects missing command",
+			processes:    []ProcessConfig{{Name: "worker", Type: ProcessTypeWorker}},
+			expectErrMsg: `process "worker": command is required`,
+		},
+		{
+			name: "rejects negative replicas",
+			processes: []ProcessConfig{
+				{Name: "worker", Type: ProcessTypeWorker, Command: []string{"./worker"}, Replicas: &replicas},
+			},
+			expectErrMsg: "replicas must not be negative",
+		},
+		{
+			name: "rejects cron without schedule",
+			processes: []ProcessConfig{
+				{Name: "cleanup", Type: ProcessTypeCron, Command: []string{"./cleanup"}},
+			},
+			expectErrMsg: "schedule is required",
+		},
+		{
+			name: "rejects malformed schedule",
+			processes: []ProcessConfig{
+				{Name: "cleanup", Type: ProcessTypeCron, Command: []string{"./cleanup"}, Schedule: "0 3 * *"},
+			},
+			expectErrMsg: "must have 5 fields",
+		},
+		{
+			name: "rejects unsupported concurrency policy",
+			processes: []ProcessConfig{
+				{
+					Name:              "cleanup",
+					Type:              ProcessTypeCron,
+					Command:           []string{"./cleanup"},
+					Schedule:          "@daily",
+					ConcurrencyPolicy: "Never",
+				},
+			},
+			expectErrMsg: `unsupported concurrency_policy "Never"`,
+		},
+		{
+			name:         "rejects unsupported type",
+			processes:    []ProcessConfig{{Name: "web", Type: "server", Command: []string{"./server"}}},
+			expectErrMsg: `unsupported type "server"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateProcesses(tt.processes)
+			if tt.expectErrMsg == "" {
+				assert.NoError(t, err)
+				return
+			}
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.expectErrMsg)
+		})
+	}
+}
@@ -0,0 +1,138 @@
+package appconfigext
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+const (
+	// ProcessTypeWorker はServiceを持たない常駐プロセスを示す
+	ProcessTypeWorker = "worker"
+	// ProcessTypeCron はスケジュール実行されるプロセスを示す
+	ProcessTypeCron = "cron"
+
+	ConcurrencyPolicyAllow   = "Allow"
+	ConcurrencyPolicyForbid  = "Forbid"
+	ConcurrencyPolicyReplace = "Replace"
+)
+
+// reservedProcessNames はコントローラーが `<Release名>-<Name>` の形で作成するワークロードの名前
+// プロセスのワークロードと衝突するため､プロセス名には使用できない
+var reservedProcessNames = map[string]struct{}{
+	// カナリアリリースのDeployment
+	"canary": {},
+	// ブルーグリーンデプロイのDeployment
+	"blue":  {},
+	"green": {},
+	// プレビュー用のService
+	"preview": {},
+}
+
+// ProcessConfig はメインのサービス以外のプロセスの設定を表す
+// プロセスはReleaseのイメージと環境変数を共有する
+type ProcessConfig struct {
+	// Name はプロセスの名前
+	// ワークロードの名前は `<Release名>-<Name>` となる
+	Name string `json:"name" yaml:"name"`
+	// Type はプロセスの種類(worker, cron)
+	Type string `json:"type" yaml:"type"`
+	// Command はコンテナで実行するコマンド
+	Command []string `json:"command" yaml:"command"`
+	// Replicas は worker のレプリカ数
+	// 何も指定されない場合は 1 が使用される
+	Replicas *int `json:"replicas,omitempty" yaml:"replicas,omitempty"`
+	// Schedule は cron の実行スケジュール(cron形式)
+	Schedule string `json:"schedule,omitempty" yaml:"schedule,omitempty"`
+	// ConcurrencyPolicy は cron の前回の実行が終わっていない場合の扱い
+	// 何も指定されない場合は Forbid が使用される
+	ConcurrencyPolicy string `json:"concurrency_policy,omitempty" yaml:"concurrency_policy,omitempty"`
+	// Resources はコンテナのrequests/limits
+	// `service` のリソース設定に上書きマージされる
+	Resources *ResourcesConfig `json:"resources,omitempty" yaml:"resources,omitempty"`
+}
+
+// ValidateProcesses はプロセス設定のバリデーションを行う
+func ValidateProcesses(processes []ProcessConfig) error {
+	var errs []error
+	names := map[string]struct{}{}
+	for _, p := range processes {
+		for _, msg := range validation.IsDNS1123Label(p.Name) {
+			errs = append(errs, fmt.Errorf("process %q: invalid name: %s", p.Name, msg))
+		}
+		if _, ok := reservedProcessNames[p.Name]; ok {
+			errs = append(errs, fmt.Errorf("process %q: name is reserved by the controller", p.Name))
+		}
+		if _, ok := names[p.Name]; ok {
+			errs = append(errs, fmt.Errorf("process %q: duplicate process name", p.Name))
+		}
+		names[p.Name] = struct{}{}
+
+		if len(p.Command) == 0 {
+			errs = append(errs, fmt.Errorf("process %q: command is required", p.Name))
+		}
+
+		switch p.Type {
+		case ProcessTypeWorker:
+			if p.Replicas != nil && *p.Replicas < 0 {
+				errs = append(errs, fmt.Errorf("process %q: replicas must not be negative", p.Name))
+			}
+		case ProcessTypeCron:
+			if err := validateSchedule(p.Schedule); err != nil {
+				errs = append(errs, fmt.Errorf("process %q: %w", p.Name, err))
+			}
+			switch p.ConcurrencyPolicy {
+			case "", ConcurrencyPolicyAllow, ConcurrencyPolicyForbid, ConcurrencyPolicyReplace:
+			default:
+				errs = append(errs,
+					fmt.Errorf("process %q: unsupported concurrency_policy %q", p.Name, p.ConcurrencyPolicy))
+			}
+		default:
+			errs = append(errs, fmt.Errorf("process %q: unsupported type %q", p.Name, p.Type))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// validateSchedule はスケジュールの形式を簡易的に検証する
+// 各フィールドの値の検証はkube-apiserverに任せる
+func validateSchedule(schedule string) error {
+	if schedule == "" {
+		return fmt.Errorf("schedule is required")
+	}
+	if strings.HasPrefix(schedule, "@") {
+		return nil
+	}
+	if fields := strings.Fields(schedule); len(fields) != 5 {
+		return fmt.Errorf("schedule %q must have 5 fields", schedule)
+	}
+	return nil
+}
+
+// NormalizedReplicas はデフォルト値を考慮したレプリカ数を返す
+func (p ProcessConfig) NormalizedReplicas() int {
+	if p.Replicas == nil {
+		return 1
+	}
+	return *p.Replicas
+}
+
+// NormalizedConcurrencyPolicy はデフォルト値を考慮したConcurrencyPolicyを返す
+func (p ProcessConfig) NormalizedConcurrencyPolicy() string {
+	if p.ConcurrencyPolicy == "" {
+		return ConcurrencyPolicyForbid
+	}
+	return p.ConcurrencyPolicy
+}
+
+// ForProcess はプロセスのリソース設定を `service` の設定にマージした AppConfig を返す
+// レシーバは変更されない
+func (c *AppConfig) ForProcess(p ProcessConfig) AppConfig {
+	merged := *c
+	if p.Resources != nil {
+		merged.Ext.Service.Resources = mergeResources(c.Ext.Service.Resources, p.Resources)
+	}
+	return merged
+}
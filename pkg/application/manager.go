@@ -13,6 +13,7 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/utils/ptr"
 )
@@ -48,8 +49,18 @@ func (m *Manager) Reconcile(
 	ctx context.Context,
 	app *tacokumogithubiov1alpha1.Application,
 ) error {
+	if err := m.syncReleaseSuspension(ctx, app); err != nil {
+		return m.handleError(ctx, app, err)
+	}
+	tacokumogithubiov1alpha1.SetSuspendedCondition(&app.Status.Conditions, app.Generation, app.Spec.Suspend,
+		tacokumogithubiov1alpha1.ReasonSuspended, "application is suspended by spec.suspend")
+
 	switch app.Status.State {
 	case tacokumogithubiov1alpha1.ApplicationStateProvisioning:
+		// 一時停止中はReleaseを作成､更新しない
+		if app.Spec.Suspend {
+			break
+		}
 		if err := m.reconcileOnProvisioningState(ctx, app); err != nil {
 			return m.handleError(ctx, app, err)
 		}
@@ -59,12 +70,19 @@ func (m *Manager) Reconcile(
 		}
 	case tacokumogithubiov1alpha1.ApplicationStateRunning:
 		// TODO: 差分を検知したらProvisioningに戻す
+		if err := m.reconcileOnRunningState(ctx, app); err != nil {
+			return m.handleError(ctx, app, err)
+		}
 	case tacokumogithubiov1alpha1.ApplicationStateError:
 		// TODO: 差分を検知したらProvisioningに戻す
 	default:
 		app.Status.State = tacokumogithubiov1alpha1.ApplicationStateProvisioning
 	}
 
+	// Provisioningで作成したReleaseのStageも含めて記録する
+	if err := m.updateMissingSecrets(ctx, app); err != nil {
+		return m.handleError(ctx, app, err)
+	}
 	if err := m.k8sClient.Status().Update(ctx, app); err != nil {
 		return m.handleError(ctx, app, err)
 	}
@@ -94,6 +112,43 @@ func (m *Manager) handleError(
 	return err
 }
 
+// syncReleaseSuspension はApplicationの一時停止の状態を各Releaseのアノテーションに反映する
+// Releaseのコントローラーはアノテーションを見てデプロイを停止する
+func (m *Manager) syncReleaseSuspension(
+	ctx context.Context,
+	app *tacokumogithubiov1alpha1.Application,
+) error {
+	for _, relRef := range app.Status.Releases {
+		rel := &tacokumogithubiov1alpha1.Release{}
+		if err := m.k8sClient.Get(ctx, client.ObjectKey{
+			Namespace: relRef.Namespace,
+			Name:      relRef.Name,
+		}, rel); err != nil {
+			if apierrors.IsNotFound(err) {
+				continue
+			}
+			return err
+		}
+
+		_, suspended := rel.Annotations[tacokumogithubiov1alpha1.ApplicationSuspendedAnnotationKey]
+		if suspended == app.Spec.Suspend {
+			continue
+		}
+		if app.Spec.Suspend {
+			if rel.Annotations == nil {
+				rel.Annotations = map[string]string{}
+			}
+			rel.Annotations[tacokumogithubiov1alpha1.ApplicationSuspendedAnnotationKey] = "true"
+		} else {
+			delete(rel.Annotations, tacokumogithubiov1alpha1.ApplicationSuspendedAnnotationKey)
+		}
+		if err := m.k8sClient.Update(ctx, rel); err != nil {
+			return fmt.Errorf("failed to update suspension of release %s/%s: %w", rel.Namespace, rel.Name, err)
+		}
+	}
+	return nil
+}
+
 func (m *Manager) reconcileOnProvisioningState(
 	ctx context.Context,
 	app *tacokumogithubiov1alpha1.Application,
@@ -116,8 +171,19 @@ func (m *Manager) reconcileOnProvisioningState(
 		appCfg.Stages = m.setDefaultStages()
 	}
 
+	if err := validatePromotions(app.Spec.Promotions, appCfg.Stages); err != nil {
+		return err
+	}
+	if err := validateStageSecrets(app.Spec.StageSecrets, appCfg.Stages); err != nil {
+		return err
+	}
+
 	app.Status.Releases = make([]corev1.ObjectReference, 0, len(appCfg.Stages))
 	for _, stage := range appCfg.Stages {
+		// プロモーションの対象のStageは､取得元のStageの状態に応じて更新する
+		if findPromotion(app, stage.Name) != nil {
+			continue
+		}
 		if stage.Policy.Branch == nil {
 			return fmt.Errorf("stage %q: branch policy is required but not configured", stage.Name)
 		}
@@ -128,36 +194,97 @@ func (m *Manager) reconcileOnProvisioningState(
 			return fmt.Errorf("failed to get latest commit for branch %q: %w", branchName, err)
 		}
 
-		rel := tacokumogithubiov1alpha1.Release{
-			ObjectMeta: metav1.ObjectMeta{
-				Namespace: app.Namespace,
-				Name:      fmt.Sprintf("%s-%s", app.Name, stage.Name),
-			},
-		}
-
-		if _, err := controllerutil.CreateOrUpdate(ctx, m.k8sClient, &rel, func() error {
-			rel.Spec = app.Spec.ReleaseTemplate
-			rel.Spec.Commit = ptr.To(latestCommit)
-			return nil
-		}); err != nil {
+		rel, err := m.applyRelease(ctx, app, stage.Name, latestCommit)
+		if err != nil {
 			return err
 		}
-		app.Status.Releases = append(app.Status.Releases, corev1.ObjectReference{
-			Kind:      rel.Kind,
-			Namespace: rel.Namespace,
-			Name:      rel.Name,
-			UID:       rel.UID,
-		})
+		addReleaseReference(app, rel)
+	}
+
+	if _, err := m.reconcilePromotions(ctx, app); err != nil {
+		return err
 	}
 
 	app.Status.State = tacokumogithubiov1alpha1.ApplicationStateWaiting
 	return nil
 }
 
+// applyRelease はStageのReleaseを作成または更新し､commit をデプロイさせる
+// 環境変数のSecretはStageごとに異なるため､テンプレートの値ではなくStageに対応するSecretを参照させる
+// spec.suspend と spec.rollbackTo はReleaseに対して直接操作されるため､既存のReleaseの値を保持する
+func (m *Manager) applyRelease(
+	ctx context.Context,
+	app *tacokumogithubiov1alpha1.Application,
+	stage string,
+	commit string,
+) (*tacokumogithubiov1alpha1.Release, error) {
+	envSecretName, err := m.envSecretNameOf(ctx, app, stage)
+	if err != nil {
+		return nil, err
+	}
+
+	rel := &tacokumogithubiov1alpha1.Release{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: app.Namespace,
+			Name:      releaseName(app, stage),
+		},
+	}
+
+	if _, err := controllerutil.CreateOrUpdate(ctx, m.k8sClient, rel, func() error {
+		// DeployWindowのApplicationセレクタを評価できるよう､所有するApplicationを記録する
+		if rel.Labels == nil {
+			rel.Labels = map[string]string{}
+		}
+		rel.Labels[tacokumogithubiov1alpha1.ApplicationLabelKey] = app.Name
+		suspend, rollbackTo := rel.Spec.Suspend, rel.Spec.RollbackTo
+		rel.Spec = app.Spec.ReleaseTemplate
+		if rel.ResourceVersion != "" {
+			rel.Spec.Suspend = suspend
+			rel.Spec.RollbackTo = rollbackTo
+		}
+		rel.Spec.Stage = stage
+		rel.Spec.Commit = ptr.To(commit)
+		rel.Spec.EnvSecretName = envSecretName
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return rel, nil
+}
+
+// addReleaseReference は app.Status.Releases にReleaseが含まれていない場合に追加する
+func addReleaseReference(
+	app *tacokumogithubiov1alpha1.Application,
+	rel *tacokumogithubiov1alpha1.Release,
+) {
+	for _, ref := range app.Status.Releases {
+		if ref.Namespace == rel.Namespace && ref.Name == rel.Name {
+			return
+		}
+	}
+	app.Status.Releases = append(app.Status.Releases, corev1.ObjectReference{
+		Kind:      rel.Kind,
+		Namespace: rel.Namespace,
+		Name:      rel.Name,
+		UID:       rel.UID,
+	})
+}
+
+func releaseName(app *tacokumogithubiov1alpha1.Application, stage string) string {
+	return fmt.Sprintf("%s-%s", app.Name, stage)
+}
+
 func (m *Manager) reconcileOnWaitingState(
 	ctx context.Context,
 	app *tacokumogithubiov1alpha1.Application,
 ) (err error) {
+	// 一時停止中はReleaseの状態の監視のみを行う
+	if !app.Spec.Suspend {
+		if _, err := m.reconcilePromotions(ctx, app); err != nil {
+			return err
+		}
+	}
+
 	for _, relRef := range app.Status.Releases {
 		rel := &tacokumogithubiov1alpha1.Release{}
 		if err := m.k8sClient.Get(ctx, client.ObjectKey{
@@ -166,10 +293,15 @@ func (m *Manager) reconcileOnWaitingState(
 		}, rel); err != nil {
 			return err
 		}
-		if rel.Status.State != tacokumogithubiov1alpha1.ReleaseStateDeployed {
+		// specの更新直後はReleaseのコントローラーが反映する前の古いDeployedが残っているため､
+		// 現在のspecがデプロイされたことも確認する
+		if rel.Status.State != tacokumogithubiov1alpha1.ReleaseStateDeployed ||
+			rel.Status.ObservedGeneration != rel.Generation {
 			m.logger.Info("waiting for all Releases to be in Deployed state",
 				"release", fmt.Sprintf("%s/%s", rel.Namespace, rel.Name),
 				"state", rel.Status.State,
+				"generation", rel.Generation,
+				"observedGeneration", rel.Status.ObservedGeneration,
 			)
 			return nil
 		}
@@ -178,6 +310,29 @@ func (m *Manager) reconcileOnWaitingState(
 	return nil
 }
 
+// reconcileOnRunningState は環境変数のSecretとプロモーションの条件を評価し､
+// Releaseを更新した場合はデプロイの完了を待つためWaitingに遷移する
+func (m *Manager) reconcileOnRunningState(
+	ctx context.Context,
+	app *tacokumogithubiov1alpha1.Application,
+) error {
+	if app.Spec.Suspend {
+		return nil
+	}
+	secretsUpdated, err := m.syncEnvSecretNames(ctx, app)
+	if err != nil {
+		return err
+	}
+	promoted, err := m.reconcilePromotions(ctx, app)
+	if err != nil {
+		return err
+	}
+	if secretsUpdated || promoted {
+		app.Status.State = tacokumogithubiov1alpha1.ApplicationStateWaiting
+	}
+	return nil
+}
+
 // setDefaultStages は､AppConfigにStagesが定義されていない場合のデフォルト値を返す
 func (m *Manager) setDefaultStages() []appconfig.StageConfig {
 	return []appconfig.StageConfig{
@@ -13,6 +13,7 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	k8sruntime "k8s.io/apimachinery/pkg/runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
@@ -29,6 +30,8 @@ func newTestScheme(t *testing.T) *k8sruntime.Scheme {
 	scheme := k8sruntime.NewScheme()
 	err := tacokumogithubiov1alpha1.AddToScheme(scheme)
 	require.NoError(t, err)
+	err = corev1.AddToScheme(scheme)
+	require.NoError(t, err)
 	return scheme
 }
 
@@ -172,6 +175,7 @@ func TestManager_Reconcile_OnProvisioningState_SetsCommit(t *testing.T) {
 	require.NoError(t, err)
 	require.NotNil(t, stagingRelease.Spec.Commit)
 	assert.Equal(t, "abc123staging", *stagingRelease.Spec.Commit)
+	assert.Equal(t, "staging", stagingRelease.Spec.Stage)
 
 	productionRelease := &tacokumogithubiov1alpha1.Release{}
 	err = k8sClient.Get(t.Context(), client.ObjectKey{
@@ -181,6 +185,7 @@ func TestManager_Reconcile_OnProvisioningState_SetsCommit(t *testing.T) {
 	require.NoError(t, err)
 	require.NotNil(t, productionRelease.Spec.Commit)
 	assert.Equal(t, "def456main", *productionRelease.Spec.Commit)
+	assert.Equal(t, "production", productionRelease.Spec.Stage)
 }
 
 func TestManager_Reconcile_OnWaitingState(t *testing.T) {
@@ -273,6 +278,33 @@ func TestManager_Reconcile_OnWaitingState(t *testing.T) {
 	}
 }
 
+func TestManager_Reconcile_OnWaitingState_StaleRelease(t *testing.T) {
+	// specの更新がまだ反映されていないReleaseは､以前のコミットのDeployedが残っていても待機する
+	rel := &tacokumogithubiov1alpha1.Release{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "test-app-production", Generation: 2},
+		Status: tacokumogithubiov1alpha1.ReleaseStatus{
+			State:              tacokumogithubiov1alpha1.ReleaseStateDeployed,
+			ObservedGeneration: 1,
+		},
+	}
+	app := &tacokumogithubiov1alpha1.Application{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "test-app"},
+		Status: tacokumogithubiov1alpha1.ApplicationStatus{
+			State:    tacokumogithubiov1alpha1.ApplicationStateWaiting,
+			Releases: []corev1.ObjectReference{{Namespace: rel.Namespace, Name: rel.Name}},
+		},
+	}
+	k8sClient := fake.NewClientBuilder().
+		WithScheme(newTestScheme(t)).
+		WithObjects(rel, app).
+		WithStatusSubresource(app).
+		Build()
+	m := newTestManager(t, k8sClient, nil)
+
+	require.NoError(t, m.Reconcile(t.Context(), app))
+	assert.Equal(t, tacokumogithubiov1alpha1.ApplicationStateWaiting, app.Status.State)
+}
+
 func TestManager_Reconcile_OnWaitingState_ReleaseNotFound(t *testing.T) {
 	scheme := newTestScheme(t)
 
@@ -398,3 +430,91 @@ func TestManager_Reconcile_OnDefaultState(t *testing.T) {
 		})
 	}
 }
+
+func TestManager_Reconcile_Suspend(t *testing.T) {
+	tests := []struct {
+		name               string
+		suspend            bool
+		state              string
+		releaseAnnotations map[string]string
+		expectedState      string
+		expectAnnotation   bool
+	}{
+		{
+			name:             "suspended application skips provisioning and suspends releases",
+			suspend:          true,
+			state:            tacokumogithubiov1alpha1.ApplicationStateProvisioning,
+			expectedState:    tacokumogithubiov1alpha1.ApplicationStateProvisioning,
+			expectAnnotation: true,
+		},
+		{
+			name:             "suspended application still observes releases",
+			suspend:          true,
+			state:            tacokumogithubiov1alpha1.ApplicationStateWaiting,
+			expectedState:    tacokumogithubiov1alpha1.ApplicationStateRunning,
+			expectAnnotation: true,
+		},
+		{
+			name:  "resumed application removes suspension from releases",
+			state: tacokumogithubiov1alpha1.ApplicationStateWaiting,
+			releaseAnnotations: map[string]string{
+				tacokumogithubiov1alpha1.ApplicationSuspendedAnnotationKey: "true",
+			},
+			expectedState:    tacokumogithubiov1alpha1.ApplicationStateRunning,
+			expectAnnotation: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rel := &tacokumogithubiov1alpha1.Release{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace:   "default",
+					Name:        "test-app-production",
+					Annotations: tt.releaseAnnotations,
+				},
+				Status: tacokumogithubiov1alpha1.ReleaseStatus{
+					State: tacokumogithubiov1alpha1.ReleaseStateDeployed,
+				},
+			}
+			app := &tacokumogithubiov1alpha1.Application{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "test-app"},
+				Spec: tacokumogithubiov1alpha1.ApplicationSpec{
+					ReleaseTemplate: tacokumogithubiov1alpha1.ReleaseSpec{AppConfigPath: "appconfig.yaml"},
+					Suspend:         tt.suspend,
+				},
+				Status: tacokumogithubiov1alpha1.ApplicationStatus{
+					State: tt.state,
+					Releases: []corev1.ObjectReference{
+						{Kind: "Release", Namespace: rel.Namespace, Name: rel.Name},
+					},
+				},
+			}
+
+			k8sClient := fake.NewClientBuilder().
+				WithScheme(newTestScheme(t)).
+				WithObjects(app, rel).
+				WithStatusSubresource(app, rel).
+				Build()
+			// 一時停止中はリポジトリにアクセスしないため､connectorは不要
+			m := newTestManager(t, k8sClient, repoconnector.NewLocalConnector(testdataPath("nonexistent")))
+
+			require.NoError(t, m.Reconcile(t.Context(), app))
+			assert.Equal(t, tt.expectedState, app.Status.State)
+
+			updated := &tacokumogithubiov1alpha1.Release{}
+			require.NoError(t, k8sClient.Get(t.Context(), client.ObjectKeyFromObject(rel), updated))
+			assert.Equal(t, tt.expectAnnotation,
+				tacokumogithubiov1alpha1.IsSuspended(updated.Annotations, updated.Spec.Suspend))
+
+			condition := meta.FindStatusCondition(
+				app.Status.Conditions, tacokumogithubiov1alpha1.ConditionTypeSuspended)
+			if tt.suspend {
+				require.NotNil(t, condition)
+				assert.Equal(t, metav1.ConditionTrue, condition.Status)
+			} else {
+				assert.Nil(t, condition)
+			}
+		})
+	}
+}
@@ -0,0 +1,195 @@
+package application
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	tacokumogithubiov1alpha1 "github.com/tacokumo/portal-controller-kubernetes/api/v1alpha1"
+
+	appconfig "github.com/tacokumo/appconfig"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// reconcilePromotions は条件を満たしたプロモーションについて､取得元のStageでデプロイされたコミットを
+// 対象のStageのReleaseにデプロイする
+// 条件を満たしていないプロモーションは app.Status.PendingPromotions に記録される
+// Releaseを更新した場合は true を返す
+func (m *Manager) reconcilePromotions(
+	ctx context.Context,
+	app *tacokumogithubiov1alpha1.Application,
+) (bool, error) {
+	now := time.Now()
+	promoted := false
+	var pendings []tacokumogithubiov1alpha1.PendingPromotion
+	for _, promotion := range app.Spec.Promotions {
+		target := &tacokumogithubiov1alpha1.Release{}
+		err := m.k8sClient.Get(ctx, client.ObjectKey{
+			Namespace: app.Namespace,
+			Name:      releaseName(app, promotion.Stage),
+		}, target)
+		if client.IgnoreNotFound(err) != nil {
+			return false, err
+		}
+		current := ""
+		if err == nil {
+			addReleaseReference(app, target)
+			current = ptr.Deref(target.Spec.Commit, "")
+		}
+
+		commit, pending, err := m.evaluatePromotion(ctx, app, promotion, current, now)
+		if err != nil {
+			return false, err
+		}
+		if pending != nil {
+			pendings = append(pendings, *pending)
+			continue
+		}
+		if commit == "" {
+			continue
+		}
+		// 障害対応などで一時停止されたReleaseには､解除されるまでプロモーションしない
+		if target.Spec.Suspend {
+			pendings = append(pendings, tacokumogithubiov1alpha1.PendingPromotion{
+				Stage:   promotion.Stage,
+				From:    promotion.From,
+				Commit:  commit,
+				Phase:   tacokumogithubiov1alpha1.PromotionPhaseTargetSuspended,
+				Message: fmt.Sprintf("release %s is suspended by spec.suspend", target.Name),
+			})
+			continue
+		}
+
+		m.logger.Info("promoting commit",
+			"from", promotion.From,
+			"stage", promotion.Stage,
+			"commit", commit,
+		)
+		rel, err := m.applyRelease(ctx, app, promotion.Stage, commit)
+		if err != nil {
+			return false, err
+		}
+		addReleaseReference(app, rel)
+		promoted = true
+	}
+	app.Status.PendingPromotions = pendings
+	return promoted, nil
+}
+
+// evaluatePromotion はプロモーションの条件を評価し､条件を満たしている場合はデプロイするコミットを返す
+// 条件を満たしていない場合は待っている条件を返す
+// 取得元のコミットが既に current としてデプロイされている場合はどちらも返さない
+func (m *Manager) evaluatePromotion(
+	ctx context.Context,
+	app *tacokumogithubiov1alpha1.Application,
+	promotion tacokumogithubiov1alpha1.StagePromotion,
+	current string,
+	now time.Time,
+) (string, *tacokumogithubiov1alpha1.PendingPromotion, error) {
+	pending := &tacokumogithubiov1alpha1.PendingPromotion{
+		Stage: promotion.Stage,
+		From:  promotion.From,
+		Phase: tacokumogithubiov1alpha1.PromotionPhaseWaitingForSource,
+	}
+
+	sourceName := releaseName(app, promotion.From)
+	source := &tacokumogithubiov1alpha1.Release{}
+	if err := m.k8sClient.Get(ctx, client.ObjectKey{Namespace: app.Namespace, Name: sourceName}, source); err != nil {
+		if apierrors.IsNotFound(err) {
+			pending.Message = fmt.Sprintf("release %s not found", sourceName)
+			return "", pending, nil
+		}
+		return "", nil, err
+	}
+
+	commit := source.Status.DeployedCommit
+	if commit == "" {
+		pending.Message = fmt.Sprintf("waiting for release %s to be deployed", sourceName)
+		return "", pending, nil
+	}
+	if commit == current {
+		return "", nil, nil
+	}
+	pending.Commit = commit
+
+	since, healthy := healthySince(source)
+	if !healthy {
+		pending.Message = fmt.Sprintf("waiting for release %s to be deployed and healthy", sourceName)
+		return "", pending, nil
+	}
+	if promotion.SoakTime != nil {
+		until := since.Add(promotion.SoakTime.Duration)
+		if now.Before(until) {
+			pending.Phase = tacokumogithubiov1alpha1.PromotionPhaseSoaking
+			pending.SoakUntil = ptr.To(metav1.NewTime(until))
+			pending.Message = fmt.Sprintf("release %s has been healthy since %s",
+				sourceName, since.Format(time.RFC3339))
+			return "", pending, nil
+		}
+	}
+	if promotion.RequireApproval && app.Annotations[promotionApprovalAnnotationKey(promotion.Stage)] != commit {
+		pending.Phase = tacokumogithubiov1alpha1.PromotionPhaseWaitingForApproval
+		pending.Message = fmt.Sprintf("annotate the application with %s=%s to approve",
+			promotionApprovalAnnotationKey(promotion.Stage), commit)
+		return "", pending, nil
+	}
+	return commit, nil, nil
+}
+
+// healthySince はReleaseが DeployedCommit をデプロイし､健全な状態を保っている場合にその開始時刻を返す
+// 健全な状態とは､Deployedであり､PodがReadyかつ失敗していない状態を指す
+func healthySince(rel *tacokumogithubiov1alpha1.Release) (time.Time, bool) {
+	if rel.Status.State != tacokumogithubiov1alpha1.ReleaseStateDeployed || rel.Status.DeployedAt == nil {
+		return time.Time{}, false
+	}
+	ready := meta.FindStatusCondition(rel.Status.Conditions, tacokumogithubiov1alpha1.ConditionTypePodsReady)
+	if ready == nil || ready.Status != metav1.ConditionTrue {
+		return time.Time{}, false
+	}
+	if meta.IsStatusConditionTrue(rel.Status.Conditions, tacokumogithubiov1alpha1.ConditionTypeDegraded) {
+		return time.Time{}, false
+	}
+
+	since := rel.Status.DeployedAt.Time
+	if ready.LastTransitionTime.After(since) {
+		since = ready.LastTransitionTime.Time
+	}
+	degraded := meta.FindStatusCondition(rel.Status.Conditions, tacokumogithubiov1alpha1.ConditionTypeDegraded)
+	if degraded != nil && degraded.LastTransitionTime.After(since) {
+		since = degraded.LastTransitionTime.Time
+	}
+	return since, true
+}
+
+// validatePromotions はプロモーションが参照するStageがappconfigに定義されていることを検証する
+func validatePromotions(promotions []tacokumogithubiov1alpha1.StagePromotion, stages []appconfig.StageConfig) error {
+	defined := make(map[string]bool, len(stages))
+	for _, stage := range stages {
+		defined[stage.Name] = true
+	}
+	for _, promotion := range promotions {
+		for _, name := range []string{promotion.Stage, promotion.From} {
+			if !defined[name] {
+				return fmt.Errorf("promotion %q: stage %q is not defined in appconfig", promotion.Stage, name)
+			}
+		}
+	}
+	return nil
+}
+
+func findPromotion(app *tacokumogithubiov1alpha1.Application, stage string) *tacokumogithubiov1alpha1.StagePromotion {
+	for i := range app.Spec.Promotions {
+		if app.Spec.Promotions[i].Stage == stage {
+			return &app.Spec.Promotions[i]
+		}
+	}
+	return nil
+}
+
+func promotionApprovalAnnotationKey(stage string) string {
+	return tacokumogithubiov1alpha1.PromotionApprovalAnnotationPrefix + stage
+}
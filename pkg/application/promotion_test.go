@@ -0,0 +1,304 @@
+package application
+
+import (
+	"testing"
+	"time"
+
+	tacokumogithubiov1alpha1 "github.com/tacokumo/portal-controller-kubernetes/api/v1alpha1"
+	"github.com/tacokumo/portal-controller-kubernetes/pkg/repoconnector"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// newHealthyRelease は since からDeployedかつ健全な状態を保っているReleaseを返す
+func newHealthyRelease(name, commit string, since time.Time) *tacokumogithubiov1alpha1.Release {
+	return &tacokumogithubiov1alpha1.Release{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name},
+		Spec:       tacokumogithubiov1alpha1.ReleaseSpec{Commit: ptr.To(commit)},
+		Status: tacokumogithubiov1alpha1.ReleaseStatus{
+			State:          tacokumogithubiov1alpha1.ReleaseStateDeployed,
+			DeployedCommit: commit,
+			DeployedAt:     ptr.To(metav1.NewTime(since)),
+			Conditions: []metav1.Condition{
+				{
+					Type:               tacokumogithubiov1alpha1.ConditionTypePodsReady,
+					Status:             metav1.ConditionTrue,
+					Reason:             tacokumogithubiov1alpha1.ReasonPodsReady,
+					LastTransitionTime: metav1.NewTime(since),
+				},
+				{
+					Type:               tacokumogithubiov1alpha1.ConditionTypeDegraded,
+					Status:             metav1.ConditionFalse,
+					Reason:             tacokumogithubiov1alpha1.ReasonPodsHealthy,
+					LastTransitionTime: metav1.NewTime(since),
+				},
+			},
+		},
+	}
+}
+
+func TestHealthySince(t *testing.T) {
+	deployedAt := time.Now().Add(-time.Hour).Truncate(time.Second)
+
+	tests := []struct {
+		name          string
+		mutate        func(rel *tacokumogithubiov1alpha1.Release)
+		expectHealthy bool
+		expectSince   time.Time
+	}{
+		{
+			name:          "healthy since deployment",
+			mutate:        func(*tacokumogithubiov1alpha1.Release) {},
+			expectHealthy: true,
+			expectSince:   deployedAt,
+		},
+		{
+			name: "soak restarts when pods become ready again",
+			mutate: func(rel *tacokumogithubiov1alpha1.Release) {
+				rel.Status.Conditions[0].LastTransitionTime = metav1.NewTime(deployedAt.Add(30 * time.Minute))
+			},
+			expectHealthy: true,
+			expectSince:   deployedAt.Add(30 * time.Minute),
+		},
+		{
+			name: "not healthy while redeploying",
+			mutate: func(rel *tacokumogithubiov1alpha1.Release) {
+				rel.Status.State = tacokumogithubiov1alpha1.ReleaseStateRollingOut
+			},
+		},
+		{
+			name: "not healthy while degraded",
+			mutate: func(rel *tacokumogithubiov1alpha1.Release) {
+				rel.Status.Conditions[1].Status = metav1.ConditionTrue
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rel := newHealthyRelease("test-app-staging", "abc123", deployedAt)
+			tt.mutate(rel)
+
+			since, healthy := healthySince(rel)
+			assert.Equal(t, tt.expectHealthy, healthy)
+			if tt.expectHealthy {
+				assert.True(t, tt.expectSince.Equal(since), "expected %s, got %s", tt.expectSince, since)
+			}
+		})
+	}
+}
+
+func TestManager_reconcilePromotions(t *testing.T) {
+	promotion := tacokumogithubiov1alpha1.StagePromotion{
+		Stage:           "production",
+		From:            "staging",
+		SoakTime:        &metav1.Duration{Duration: time.Hour},
+		RequireApproval: true,
+	}
+
+	tests := []struct {
+		name             string
+		source           *tacokumogithubiov1alpha1.Release
+		targetCommit     string
+		targetSuspend    bool
+		targetRollbackTo *int64
+		approval         string
+		expectCommit     string
+		expectPhase      string
+		expectPromote    bool
+	}{
+		{
+			name:        "waits for the source release to exist",
+			expectPhase: tacokumogithubiov1alpha1.PromotionPhaseWaitingForSource,
+		},
+		{
+			name:         "waits for the soak time",
+			source:       newHealthyRelease("test-app-staging", "new456", time.Now().Add(-10*time.Minute)),
+			targetCommit: "old123",
+			expectCommit: "old123",
+			expectPhase:  tacokumogithubiov1alpha1.PromotionPhaseSoaking,
+		},
+		{
+			name:         "waits for approval after the soak time",
+			source:       newHealthyRelease("test-app-staging", "new456", time.Now().Add(-2*time.Hour)),
+			targetCommit: "old123",
+			approval:     "other789",
+			expectCommit: "old123",
+			expectPhase:  tacokumogithubiov1alpha1.PromotionPhaseWaitingForApproval,
+		},
+		{
+			name:          "promotes the approved commit",
+			source:        newHealthyRelease("test-app-staging", "new456", time.Now().Add(-2*time.Hour)),
+			targetCommit:  "old123",
+			approval:      "new456",
+			expectCommit:  "new456",
+			expectPromote: true,
+		},
+		{
+			name:          "waits for the suspended target release",
+			source:        newHealthyRelease("test-app-staging", "new456", time.Now().Add(-2*time.Hour)),
+			targetCommit:  "old123",
+			targetSuspend: true,
+			approval:      "new456",
+			expectCommit:  "old123",
+			expectPhase:   tacokumogithubiov1alpha1.PromotionPhaseTargetSuspended,
+		},
+		{
+			name:             "keeps spec.rollbackTo of the target release",
+			source:           newHealthyRelease("test-app-staging", "new456", time.Now().Add(-2*time.Hour)),
+			targetCommit:     "old123",
+			targetRollbackTo: ptr.To(int64(3)),
+			approval:         "new456",
+			expectCommit:     "new456",
+			expectPromote:    true,
+		},
+		{
+			name:          "creates the target release on first promotion",
+			source:        newHealthyRelease("test-app-staging", "new456", time.Now().Add(-2*time.Hour)),
+			approval:      "new456",
+			expectCommit:  "new456",
+			expectPromote: true,
+		},
+		{
+			name:         "does nothing when the commit is already promoted",
+			source:       newHealthyRelease("test-app-staging", "new456", time.Now()),
+			targetCommit: "new456",
+			expectCommit: "new456",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := &tacokumogithubiov1alpha1.Application{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace:   "default",
+					Name:        "test-app",
+					Annotations: map[string]string{"promotion.tacokumo.github.io/production": tt.approval},
+				},
+				Spec: tacokumogithubiov1alpha1.ApplicationSpec{
+					ReleaseTemplate: tacokumogithubiov1alpha1.ReleaseSpec{AppConfigPath: "appconfig.yaml"},
+					Promotions:      []tacokumogithubiov1alpha1.StagePromotion{promotion},
+				},
+			}
+			objects := []client.Object{app}
+			if tt.source != nil {
+				objects = append(objects, tt.source)
+			}
+			if tt.targetCommit != "" {
+				objects = append(objects, &tacokumogithubiov1alpha1.Release{
+					ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "test-app-production"},
+					Spec: tacokumogithubiov1alpha1.ReleaseSpec{
+						Stage:      "production",
+						Commit:     ptr.To(tt.targetCommit),
+						Suspend:    tt.targetSuspend,
+						RollbackTo: tt.targetRollbackTo,
+					},
+				})
+			}
+			k8sClient := fake.NewClientBuilder().
+				WithScheme(newTestScheme(t)).
+				WithObjects(objects...).
+				Build()
+			m := newTestManager(t, k8sClient, nil)
+
+			promoted, err := m.reconcilePromotions(t.Context(), app)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectPromote, promoted)
+
+			if tt.expectPhase == "" {
+				assert.Empty(t, app.Status.PendingPromotions)
+			} else {
+				require.Len(t, app.Status.PendingPromotions, 1)
+				pending := app.Status.PendingPromotions[0]
+				assert.Equal(t, "production", pending.Stage)
+				assert.Equal(t, "staging", pending.From)
+				assert.Equal(t, tt.expectPhase, pending.Phase)
+			}
+
+			target := &tacokumogithubiov1alpha1.Release{}
+			key := client.ObjectKey{Namespace: "default", Name: "test-app-production"}
+			err = k8sClient.Get(t.Context(), key, target)
+			if tt.expectCommit == "" {
+				assert.True(t, apierrors.IsNotFound(err))
+				assert.Empty(t, app.Status.Releases)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectCommit, ptr.Deref(target.Spec.Commit, ""))
+			assert.Equal(t, "production", target.Spec.Stage)
+			// Releaseに対して直接操作されたフィールドはテンプレートで上書きしない
+			assert.Equal(t, tt.targetSuspend, target.Spec.Suspend)
+			assert.Equal(t, tt.targetRollbackTo, target.Spec.RollbackTo)
+			if tt.expectPromote {
+				assert.Equal(t, "test-app", target.Labels[tacokumogithubiov1alpha1.ApplicationLabelKey])
+			}
+			require.Len(t, app.Status.Releases, 1)
+			assert.Equal(t, "test-app-production", app.Status.Releases[0].Name)
+		})
+	}
+}
+
+func TestManager_Reconcile_OnProvisioningState_WithPromotions(t *testing.T) {
+	tests := []struct {
+		name        string
+		promotion   tacokumogithubiov1alpha1.StagePromotion
+		expectError string
+	}{
+		{
+			name:      "production waits for staging instead of its branch",
+			promotion: tacokumogithubiov1alpha1.StagePromotion{Stage: "production", From: "staging"},
+		},
+		{
+			name:        "rejects promotion from an undefined stage",
+			promotion:   tacokumogithubiov1alpha1.StagePromotion{Stage: "production", From: "qa"},
+			expectError: `promotion "production": stage "qa" is not defined in appconfig`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := &tacokumogithubiov1alpha1.Application{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "test-app"},
+				Spec: tacokumogithubiov1alpha1.ApplicationSpec{
+					ReleaseTemplate: tacokumogithubiov1alpha1.ReleaseSpec{AppConfigPath: "appconfig.yaml"},
+					Promotions:      []tacokumogithubiov1alpha1.StagePromotion{tt.promotion},
+				},
+				Status: tacokumogithubiov1alpha1.ApplicationStatus{
+					State: tacokumogithubiov1alpha1.ApplicationStateProvisioning,
+				},
+			}
+			k8sClient := fake.NewClientBuilder().
+				WithScheme(newTestScheme(t)).
+				WithObjects(app).
+				WithStatusSubresource(app).
+				Build()
+			connector := repoconnector.NewLocalConnector(testdataPath("valid-appconfig")).
+				WithLatestCommits(map[string]string{"staging": "abc123staging", "main": "def456main"})
+			m := newTestManager(t, k8sClient, connector)
+
+			err := m.Reconcile(t.Context(), app)
+			if tt.expectError != "" {
+				require.EqualError(t, err, tt.expectError)
+				return
+			}
+			require.NoError(t, err)
+
+			assert.Equal(t, tacokumogithubiov1alpha1.ApplicationStateWaiting, app.Status.State)
+			require.Len(t, app.Status.Releases, 1)
+			assert.Equal(t, "test-app-staging", app.Status.Releases[0].Name)
+			require.Len(t, app.Status.PendingPromotions, 1)
+			assert.Equal(t,
+				tacokumogithubiov1alpha1.PromotionPhaseWaitingForSource, app.Status.PendingPromotions[0].Phase)
+
+			err = k8sClient.Get(t.Context(), client.ObjectKey{Namespace: "default", Name: "test-app-production"},
+				&tacokumogithubiov1alpha1.Release{})
+			assert.True(t, apierrors.IsNotFound(err))
+		})
+	}
+}
@@ -0,0 +1,162 @@
+package application
+
+import (
+	"context"
+	"fmt"
+
+	tacokumogithubiov1alpha1 "github.com/tacokumo/portal-controller-kubernetes/api/v1alpha1"
+
+	appconfig "github.com/tacokumo/appconfig"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// conventionalEnvSecretName はspec.stageSecretsで指定されないStageが参照するSecretの名前を返す
+func conventionalEnvSecretName(app *tacokumogithubiov1alpha1.Application, stage string) string {
+	return fmt.Sprintf("%s-%s-env", app.Name, stage)
+}
+
+func findStageSecret(app *tacokumogithubiov1alpha1.Application, stage string) *tacokumogithubiov1alpha1.StageSecret {
+	for i := range app.Spec.StageSecrets {
+		if app.Spec.StageSecrets[i].Stage == stage {
+			return &app.Spec.StageSecrets[i]
+		}
+	}
+	return nil
+}
+
+// envSecretNameOf はStageのReleaseが参照する環境変数のSecretの名前を返す
+// spec.stageSecrets で指定されている場合はSecretが存在しなくてもその名前を返し､
+// 指定されていない場合は命名規則に従ったSecretが存在する場合のみその名前を返す
+// どちらもない場合は spec.releaseTemplate.envSecretName を返す
+func (m *Manager) envSecretNameOf(
+	ctx context.Context,
+	app *tacokumogithubiov1alpha1.Application,
+	stage string,
+) (*string, error) {
+	if stageSecret := findStageSecret(app, stage); stageSecret != nil {
+		return ptr.To(stageSecret.SecretName), nil
+	}
+
+	name := conventionalEnvSecretName(app, stage)
+	found, err := m.secretExists(ctx, app.Namespace, name)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return app.Spec.ReleaseTemplate.EnvSecretName, nil
+	}
+	return ptr.To(name), nil
+}
+
+// syncEnvSecretNames は作成済みのReleaseが参照する環境変数のSecretを再評価し､変わった場合はReleaseを更新する
+// Releaseを作成した後に命名規則に従ったSecretが作成された場合に反映するため､Running中も呼び出される
+// 更新したReleaseがある場合は true を返す
+func (m *Manager) syncEnvSecretNames(
+	ctx context.Context,
+	app *tacokumogithubiov1alpha1.Application,
+) (bool, error) {
+	updated := false
+	for _, relRef := range app.Status.Releases {
+		rel := &tacokumogithubiov1alpha1.Release{}
+		if err := m.k8sClient.Get(ctx, client.ObjectKey{
+			Namespace: relRef.Namespace,
+			Name:      relRef.Name,
+		}, rel); err != nil {
+			if apierrors.IsNotFound(err) {
+				continue
+			}
+			return false, err
+		}
+
+		envSecretName, err := m.envSecretNameOf(ctx, app, rel.Spec.Stage)
+		if err != nil {
+			return false, err
+		}
+		if ptr.Equal(envSecretName, rel.Spec.EnvSecretName) {
+			continue
+		}
+		rel.Spec.EnvSecretName = envSecretName
+		if err := m.k8sClient.Update(ctx, rel); err != nil {
+			return false, fmt.Errorf("failed to update env secret of release %s/%s: %w", rel.Namespace, rel.Name, err)
+		}
+		m.logger.Info("updated env secret of release",
+			"release", fmt.Sprintf("%s/%s", rel.Namespace, rel.Name),
+			"secret", ptr.Deref(envSecretName, ""))
+		updated = true
+	}
+	return updated, nil
+}
+
+// updateMissingSecrets はStageが参照する環境変数のSecretのうち､存在しないものを app.Status.MissingSecrets に記録する
+// spec.stageSecrets で指定されたSecretに加えて､作成済みのReleaseのStageで参照するSecretがない場合は
+// 命名規則に従ったSecretの名前を記録する
+func (m *Manager) updateMissingSecrets(
+	ctx context.Context,
+	app *tacokumogithubiov1alpha1.Application,
+) error {
+	var missing []tacokumogithubiov1alpha1.StageSecret
+	for _, stageSecret := range app.Spec.StageSecrets {
+		found, err := m.secretExists(ctx, app.Namespace, stageSecret.SecretName)
+		if err != nil {
+			return err
+		}
+		if !found {
+			missing = append(missing, stageSecret)
+		}
+	}
+	for _, relRef := range app.Status.Releases {
+		rel := &tacokumogithubiov1alpha1.Release{}
+		if err := m.k8sClient.Get(ctx, client.ObjectKey{
+			Namespace: relRef.Namespace,
+			Name:      relRef.Name,
+		}, rel); err != nil {
+			if apierrors.IsNotFound(err) {
+				continue
+			}
+			return err
+		}
+		stage := rel.Spec.Stage
+		if findStageSecret(app, stage) != nil || app.Spec.ReleaseTemplate.EnvSecretName != nil {
+			continue
+		}
+		name := conventionalEnvSecretName(app, stage)
+		found, err := m.secretExists(ctx, app.Namespace, name)
+		if err != nil {
+			return err
+		}
+		if !found {
+			missing = append(missing, tacokumogithubiov1alpha1.StageSecret{Stage: stage, SecretName: name})
+		}
+	}
+	app.Status.MissingSecrets = missing
+	return nil
+}
+
+func (m *Manager) secretExists(ctx context.Context, namespace, name string) (bool, error) {
+	secret := &corev1.Secret{}
+	if err := m.k8sClient.Get(ctx, client.ObjectKey{Namespace: namespace, Name: name}, secret); err != nil {
+		if apierrors.IsNotFound(err) {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to get secret %s/%s: %w", namespace, name, err)
+	}
+	return true, nil
+}
+
+// validateStageSecrets は spec.stageSecrets がappconfigに定義されたStageのみを参照していることを確認する
+func validateStageSecrets(stageSecrets []tacokumogithubiov1alpha1.StageSecret, stages []appconfig.StageConfig) error {
+	defined := make(map[string]bool, len(stages))
+	for _, stage := range stages {
+		defined[stage.Name] = true
+	}
+	for _, stageSecret := range stageSecrets {
+		if !defined[stageSecret.Stage] {
+			return fmt.Errorf("stage secret %q: stage %q is not defined in appconfig",
+				stageSecret.SecretName, stageSecret.Stage)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,235 @@
+package application
+
+import (
+	"testing"
+
+	tacokumogithubiov1alpha1 "github.com/tacokumo/portal-controller-kubernetes/api/v1alpha1"
+	"github.com/tacokumo/portal-controller-kubernetes/pkg/repoconnector"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func newTestSecret(name string) *corev1.Secret {
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "default",
+			Name:      name,
+		},
+	}
+}
+
+func TestManager_Reconcile_StageSecrets(t *testing.T) {
+	tests := []struct {
+		name                  string
+		stageSecrets          []tacokumogithubiov1alpha1.StageSecret
+		templateSecretName    *string
+		secrets               []client.Object
+		expectedState         string
+		expectedEnvSecrets    map[string]*string
+		expectedMissingStages []string
+	}{
+		{
+			name: "mapped secrets are wired into each stage",
+			stageSecrets: []tacokumogithubiov1alpha1.StageSecret{
+				{Stage: "staging", SecretName: "staging-secret"},
+				{Stage: "production", SecretName: "production-secret"},
+			},
+			templateSecretName: ptr.To("shared-secret"),
+			secrets: []client.Object{
+				newTestSecret("staging-secret"),
+				newTestSecret("production-secret"),
+			},
+			expectedState: tacokumogithubiov1alpha1.ApplicationStateWaiting,
+			expectedEnvSecrets: map[string]*string{
+				"staging":    ptr.To("staging-secret"),
+				"production": ptr.To("production-secret"),
+			},
+		},
+		{
+			name: "conventional secret is used when no mapping is given",
+			secrets: []client.Object{
+				newTestSecret("test-app-production-env"),
+			},
+			expectedState: tacokumogithubiov1alpha1.ApplicationStateWaiting,
+			expectedEnvSecrets: map[string]*string{
+				"staging":    nil,
+				"production": ptr.To("test-app-production-env"),
+			},
+			expectedMissingStages: []string{"staging"},
+		},
+		{
+			name:               "template secret is used when no conventional secret exists",
+			templateSecretName: ptr.To("shared-secret"),
+			secrets: []client.Object{
+				newTestSecret("test-app-production-env"),
+			},
+			expectedState: tacokumogithubiov1alpha1.ApplicationStateWaiting,
+			expectedEnvSecrets: map[string]*string{
+				"staging":    ptr.To("shared-secret"),
+				"production": ptr.To("test-app-production-env"),
+			},
+		},
+		{
+			name: "missing mapped secret is reported",
+			stageSecrets: []tacokumogithubiov1alpha1.StageSecret{
+				{Stage: "staging", SecretName: "staging-secret"},
+				{Stage: "production", SecretName: "production-secret"},
+			},
+			secrets: []client.Object{
+				newTestSecret("staging-secret"),
+			},
+			expectedState: tacokumogithubiov1alpha1.ApplicationStateWaiting,
+			expectedEnvSecrets: map[string]*string{
+				"staging":    ptr.To("staging-secret"),
+				"production": ptr.To("production-secret"),
+			},
+			expectedMissingStages: []string{"production"},
+		},
+		{
+			name: "mapping to an undefined stage causes error",
+			stageSecrets: []tacokumogithubiov1alpha1.StageSecret{
+				{Stage: "development", SecretName: "development-secret"},
+			},
+			secrets: []client.Object{
+				newTestSecret("development-secret"),
+			},
+			expectedState: tacokumogithubiov1alpha1.ApplicationStateError,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scheme := newTestScheme(t)
+			app := &tacokumogithubiov1alpha1.Application{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "default",
+					Name:      "test-app",
+				},
+				Spec: tacokumogithubiov1alpha1.ApplicationSpec{
+					ReleaseTemplate: tacokumogithubiov1alpha1.ReleaseSpec{
+						AppConfigPath: "appconfig.yaml",
+						EnvSecretName: tt.templateSecretName,
+					},
+					StageSecrets: tt.stageSecrets,
+				},
+				Status: tacokumogithubiov1alpha1.ApplicationStatus{
+					State: tacokumogithubiov1alpha1.ApplicationStateProvisioning,
+				},
+			}
+
+			k8sClient := fake.NewClientBuilder().
+				WithScheme(scheme).
+				WithObjects(append(tt.secrets, app)...).
+				WithStatusSubresource(app).
+				Build()
+
+			connector := repoconnector.NewLocalConnector(testdataPath("valid-appconfig")).
+				WithLatestCommits(map[string]string{
+					"staging": "abc123staging",
+					"main":    "def456main",
+				})
+			m := newTestManager(t, k8sClient, connector)
+
+			err := m.Reconcile(t.Context(), app)
+			if tt.expectedState == tacokumogithubiov1alpha1.ApplicationStateError {
+				assert.Error(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+			assert.Equal(t, tt.expectedState, app.Status.State)
+
+			for stage, expected := range tt.expectedEnvSecrets {
+				rel := &tacokumogithubiov1alpha1.Release{}
+				require.NoError(t, k8sClient.Get(t.Context(), client.ObjectKey{
+					Namespace: "default",
+					Name:      releaseName(app, stage),
+				}, rel))
+				assert.Equal(t, expected, rel.Spec.EnvSecretName, "stage %s", stage)
+			}
+
+			var missingStages []string
+			for _, missing := range app.Status.MissingSecrets {
+				missingStages = append(missingStages, missing.Stage)
+			}
+			assert.Equal(t, tt.expectedMissingStages, missingStages)
+		})
+	}
+}
+
+func TestManager_Reconcile_OnRunningState_EnvSecrets(t *testing.T) {
+	app := &tacokumogithubiov1alpha1.Application{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "test-app"},
+		Spec: tacokumogithubiov1alpha1.ApplicationSpec{
+			ReleaseTemplate: tacokumogithubiov1alpha1.ReleaseSpec{AppConfigPath: "appconfig.yaml"},
+		},
+		Status: tacokumogithubiov1alpha1.ApplicationStatus{
+			State: tacokumogithubiov1alpha1.ApplicationStateRunning,
+			Releases: []corev1.ObjectReference{
+				{Namespace: "default", Name: "test-app-staging"},
+				{Namespace: "default", Name: "test-app-production"},
+			},
+		},
+	}
+	staging := &tacokumogithubiov1alpha1.Release{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "test-app-staging"},
+		Spec:       tacokumogithubiov1alpha1.ReleaseSpec{Stage: "staging", Commit: ptr.To("abc123")},
+	}
+	production := &tacokumogithubiov1alpha1.Release{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "test-app-production"},
+		Spec:       tacokumogithubiov1alpha1.ReleaseSpec{Stage: "production", Commit: ptr.To("def456")},
+	}
+	// Releaseの作成後に命名規則に従ったSecretが作成された状態を再現する
+	k8sClient := fake.NewClientBuilder().
+		WithScheme(newTestScheme(t)).
+		WithObjects(app, staging, production, newTestSecret("test-app-staging-env")).
+		WithStatusSubresource(app).
+		Build()
+	m := newTestManager(t, k8sClient, nil)
+
+	require.NoError(t, m.Reconcile(t.Context(), app))
+
+	assert.Equal(t, tacokumogithubiov1alpha1.ApplicationStateWaiting, app.Status.State)
+	rel := &tacokumogithubiov1alpha1.Release{}
+	require.NoError(t, k8sClient.Get(t.Context(), client.ObjectKeyFromObject(staging), rel))
+	assert.Equal(t, ptr.To("test-app-staging-env"), rel.Spec.EnvSecretName)
+	assert.Equal(t, ptr.To("abc123"), rel.Spec.Commit)
+	require.NoError(t, k8sClient.Get(t.Context(), client.ObjectKeyFromObject(production), rel))
+	assert.Nil(t, rel.Spec.EnvSecretName)
+	assert.Equal(t, []tacokumogithubiov1alpha1.StageSecret{
+		{Stage: "production", SecretName: "test-app-production-env"},
+	}, app.Status.MissingSecrets)
+}
+
+func TestManager_updateMissingSecrets_UsesReleaseStage(t *testing.T) {
+	app := &tacokumogithubiov1alpha1.Application{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "test-app"},
+		Status: tacokumogithubiov1alpha1.ApplicationStatus{
+			Releases: []corev1.ObjectReference{
+				// Releaseの名前からはStageを判定できない
+				{Namespace: "default", Name: "legacy-release"},
+				{Namespace: "default", Name: "test-app-deleted"},
+			},
+		},
+	}
+	rel := &tacokumogithubiov1alpha1.Release{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "legacy-release"},
+		Spec:       tacokumogithubiov1alpha1.ReleaseSpec{Stage: "production"},
+	}
+	k8sClient := fake.NewClientBuilder().
+		WithScheme(newTestScheme(t)).
+		WithObjects(app, rel).
+		Build()
+	m := newTestManager(t, k8sClient, nil)
+
+	require.NoError(t, m.updateMissingSecrets(t.Context(), app))
+
+	assert.Equal(t, []tacokumogithubiov1alpha1.StageSecret{
+		{Stage: "production", SecretName: "test-app-production-env"},
+	}, app.Status.MissingSecrets)
+}
@@ -0,0 +1,161 @@
+package clustergateway
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	tacokumogithubiov1alpha1 "github.com/tacokumo/portal-controller-kubernetes/api/v1alpha1"
+
+	"github.com/go-logr/logr"
+	"github.com/samber/lo"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// DefaultClientSecretKey は spec.oidc.clientSecretRef.key が指定されない場合に使用されるキー
+const DefaultClientSecretKey = "client-secret"
+
+type Manager struct {
+	logger    logr.Logger
+	k8sClient client.Client
+}
+
+func NewManager(
+	logger logr.Logger,
+	k8sClient client.Client,
+) *Manager {
+	return &Manager{
+		logger:    logger,
+		k8sClient: k8sClient,
+	}
+}
+
+// Reconcile はClusterGatewayのクライアントシークレットが利用可能か確認し､
+// 認証プロキシによって保護されているApplicationをstatusに記録する
+func (m *Manager) Reconcile(
+	ctx context.Context,
+	cg *tacokumogithubiov1alpha1.ClusterGateway,
+) error {
+	if _, err := ClientSecret(ctx, m.k8sClient, cg); err != nil {
+		return m.handleError(ctx, cg, err)
+	}
+
+	protected, err := m.protectedApplications(ctx, cg)
+	if err != nil {
+		return m.handleError(ctx, cg, err)
+	}
+	cg.Status.ProtectedApplications = protected
+	cg.Status.ObservedGeneration = cg.Generation
+	tacokumogithubiov1alpha1.SetReadyConditionTrue(&cg.Status.Conditions, cg.Generation,
+		tacokumogithubiov1alpha1.ReasonAuthenticationConfigured,
+		fmt.Sprintf("protecting %d applications", len(protected)))
+
+	if err := m.k8sClient.Status().Update(ctx, cg); err != nil {
+		return m.handleError(ctx, cg, err)
+	}
+	return nil
+}
+
+// ClientSecret は spec.oidc.clientSecretRef が参照するクライアントシークレットを返す
+func ClientSecret(
+	ctx context.Context,
+	k8sClient client.Client,
+	cg *tacokumogithubiov1alpha1.ClusterGateway,
+) ([]byte, error) {
+	ref := cg.Spec.OIDC.ClientSecretRef
+	secret := &corev1.Secret{}
+	if err := k8sClient.Get(ctx, client.ObjectKey{Namespace: ref.Namespace, Name: ref.Name}, secret); err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil, fmt.Errorf("client secret %s/%s of clustergateway %s not found",
+				ref.Namespace, ref.Name, cg.Name)
+		}
+		return nil, err
+	}
+	key := lo.CoalesceOrEmpty(ref.Key, DefaultClientSecretKey)
+	value, ok := secret.Data[key]
+	if !ok || len(value) == 0 {
+		return nil, fmt.Errorf("key %q not found in client secret %s/%s of clustergateway %s",
+			key, ref.Namespace, ref.Name, cg.Name)
+	}
+	return value, nil
+}
+
+// AllowsNamespace は namespace のGatewayが spec.namespaceSelector によってClusterGatewayの参照を許可されているかを返す
+func AllowsNamespace(
+	ctx context.Context,
+	k8sClient client.Client,
+	cg *tacokumogithubiov1alpha1.ClusterGateway,
+	namespace string,
+) (bool, error) {
+	if cg.Spec.NamespaceSelector == nil {
+		return false, nil
+	}
+	selector, err := metav1.LabelSelectorAsSelector(cg.Spec.NamespaceSelector)
+	if err != nil {
+		return false, fmt.Errorf("invalid namespaceSelector of clustergateway %s: %w", cg.Name, err)
+	}
+	ns := &corev1.Namespace{}
+	if err := k8sClient.Get(ctx, client.ObjectKey{Name: namespace}, ns); err != nil {
+		return false, err
+	}
+	return selector.Matches(labels.Set(ns.Labels)), nil
+}
+
+// protectedApplications はClusterGatewayの認証プロキシを配置済みのGatewayが公開しているApplicationを返す
+func (m *Manager) protectedApplications(
+	ctx context.Context,
+	cg *tacokumogithubiov1alpha1.ClusterGateway,
+) ([]tacokumogithubiov1alpha1.ProtectedApplication, error) {
+	gateways := &tacokumogithubiov1alpha1.GatewayList{}
+	if err := m.k8sClient.List(ctx, gateways); err != nil {
+		return nil, err
+	}
+
+	var protected []tacokumogithubiov1alpha1.ProtectedApplication
+	for _, gw := range gateways.Items {
+		if gw.Status.ProtectedBy != cg.Name {
+			continue
+		}
+		protected = append(protected, tacokumogithubiov1alpha1.ProtectedApplication{
+			NamespacedName: tacokumogithubiov1alpha1.NamespacedName{
+				Namespace: gw.Namespace,
+				Name:      gw.Spec.Application,
+			},
+			Gateway: gw.Name,
+		})
+	}
+	sort.Slice(protected, func(i, j int) bool {
+		if protected[i].Namespace != protected[j].Namespace {
+			return protected[i].Namespace < protected[j].Namespace
+		}
+		if protected[i].Name != protected[j].Name {
+			return protected[i].Name < protected[j].Name
+		}
+		return protected[i].Gateway < protected[j].Gateway
+	})
+	return protected, nil
+}
+
+func (m *Manager) handleError(
+	ctx context.Context,
+	cg *tacokumogithubiov1alpha1.ClusterGateway,
+	err error,
+) error {
+	// 引数のerrorは必ずnilではない
+	tacokumogithubiov1alpha1.SetReadyConditionFalse(
+		&cg.Status.Conditions,
+		cg.Generation,
+		tacokumogithubiov1alpha1.ReasonReconcileError,
+		err.Error(),
+	)
+
+	// errorだとしても､Statusの更新は必要
+	if updateErr := m.k8sClient.Status().Update(ctx, cg); updateErr != nil {
+		return updateErr
+	}
+	return err
+}
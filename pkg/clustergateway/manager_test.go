@@ -0,0 +1,136 @@
+package clustergateway
+
+import (
+	"context"
+	"testing"
+
+	tacokumogithubiov1alpha1 "github.com/tacokumo/portal-controller-kubernetes/api/v1alpha1"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sruntime "k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func newTestScheme(t *testing.T) *k8sruntime.Scheme {
+	t.Helper()
+	scheme := k8sruntime.NewScheme()
+	require.NoError(t, tacokumogithubiov1alpha1.AddToScheme(scheme))
+	require.NoError(t, corev1.AddToScheme(scheme))
+	return scheme
+}
+
+func newTestClusterGateway(key string) *tacokumogithubiov1alpha1.ClusterGateway {
+	return &tacokumogithubiov1alpha1.ClusterGateway{
+		ObjectMeta: metav1.ObjectMeta{Name: "intranet", Generation: 1},
+		Spec: tacokumogithubiov1alpha1.ClusterGatewaySpec{
+			OIDC: tacokumogithubiov1alpha1.ClusterGatewayOIDC{
+				IssuerURL: "https://accounts.example.com",
+				ClientID:  "tacokumo-intranet",
+				ClientSecretRef: tacokumogithubiov1alpha1.SecretKeyReference{
+					NamespacedName: tacokumogithubiov1alpha1.NamespacedName{
+						Namespace: "tacokumo-system", Name: "intranet-oidc",
+					},
+					Key: key,
+				},
+			},
+		},
+	}
+}
+
+func newTestGateway(namespace, name, application, protectedBy string) *tacokumogithubiov1alpha1.Gateway {
+	return &tacokumogithubiov1alpha1.Gateway{
+		ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name},
+		Spec:       tacokumogithubiov1alpha1.GatewaySpec{Application: application},
+		Status:     tacokumogithubiov1alpha1.GatewayStatus{ProtectedBy: protectedBy},
+	}
+}
+
+func TestManager_Reconcile(t *testing.T) {
+	tests := []struct {
+		name            string
+		key             string
+		secret          *corev1.Secret
+		expectReady     metav1.ConditionStatus
+		expectMessage   string
+		expectProtected []tacokumogithubiov1alpha1.ProtectedApplication
+	}{
+		{
+			name: "reports protected applications",
+			secret: &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "tacokumo-system", Name: "intranet-oidc"},
+				Data:       map[string][]byte{"client-secret": []byte("s3cr3t")},
+			},
+			expectReady:   metav1.ConditionTrue,
+			expectMessage: "protecting 2 applications",
+			expectProtected: []tacokumogithubiov1alpha1.ProtectedApplication{
+				{
+					NamespacedName: tacokumogithubiov1alpha1.NamespacedName{Namespace: "team-a", Name: "dashboard"},
+					Gateway:        "dashboard",
+				},
+				{
+					NamespacedName: tacokumogithubiov1alpha1.NamespacedName{Namespace: "team-b", Name: "wiki"},
+					Gateway:        "wiki",
+				},
+			},
+		},
+		{
+			name:          "client secret not found",
+			expectReady:   metav1.ConditionFalse,
+			expectMessage: "client secret tacokumo-system/intranet-oidc of clustergateway intranet not found",
+		},
+		{
+			name: "client secret key not found",
+			key:  "secret",
+			secret: &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "tacokumo-system", Name: "intranet-oidc"},
+				Data:       map[string][]byte{"client-secret": []byte("s3cr3t")},
+			},
+			expectReady: metav1.ConditionFalse,
+			expectMessage: `key "secret" not found in client secret tacokumo-system/intranet-oidc ` +
+				"of clustergateway intranet",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cg := newTestClusterGateway(tt.key)
+			objects := []client.Object{
+				cg,
+				newTestGateway("team-b", "wiki", "wiki", "intranet"),
+				newTestGateway("team-a", "dashboard", "dashboard", "intranet"),
+				newTestGateway("team-a", "public", "public", ""),
+				newTestGateway("team-a", "admin", "admin", "other"),
+			}
+			if tt.secret != nil {
+				objects = append(objects, tt.secret)
+			}
+			k8sClient := fake.NewClientBuilder().
+				WithScheme(newTestScheme(t)).
+				WithObjects(objects...).
+				WithStatusSubresource(cg).
+				Build()
+			m := NewManager(logr.Discard(), k8sClient)
+
+			err := m.Reconcile(context.Background(), cg)
+			if tt.expectReady == metav1.ConditionTrue {
+				require.NoError(t, err)
+			} else {
+				require.Error(t, err)
+			}
+
+			stored := &tacokumogithubiov1alpha1.ClusterGateway{}
+			require.NoError(t, k8sClient.Get(context.Background(), client.ObjectKeyFromObject(cg), stored))
+			assert.Equal(t, tt.expectProtected, stored.Status.ProtectedApplications)
+			cond := meta.FindStatusCondition(stored.Status.Conditions, tacokumogithubiov1alpha1.ConditionTypeReady)
+			require.NotNil(t, cond)
+			assert.Equal(t, tt.expectReady, cond.Status)
+			assert.Equal(t, tt.expectMessage, cond.Message)
+		})
+	}
+}
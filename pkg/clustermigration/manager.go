@@ -0,0 +1,205 @@
+package clustermigration
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	tacokumogithubiov1alpha1 "github.com/tacokumo/portal-controller-kubernetes/api/v1alpha1"
+
+	"github.com/go-logr/logr"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	defaultHealthCheckTimeout          = 5 * time.Second
+	defaultHealthCheckExpectedStatus   = http.StatusOK
+	defaultHealthCheckFailureThreshold = 3
+)
+
+type Manager struct {
+	logger     logr.Logger
+	k8sClient  client.Client
+	httpClient *http.Client
+}
+
+func NewManager(
+	logger logr.Logger,
+	k8sClient client.Client,
+) *Manager {
+	return &Manager{
+		logger:     logger,
+		k8sClient:  k8sClient,
+		httpClient: http.DefaultClient,
+	}
+}
+
+// Reconcile はヘルスチェックの結果に応じて､このクラスタのweightを次のステップに進める
+// ヘルスチェックが連続して失敗した場合は､weightを初期値に戻してマイグレーションを中止する
+// 適用したweightはGatewayのDNSレコードの調整時に参照される
+func (m *Manager) Reconcile(
+	ctx context.Context,
+	cm *tacokumogithubiov1alpha1.ClusterMigration,
+) error {
+	switch cm.Status.Phase {
+	case tacokumogithubiov1alpha1.ClusterMigrationPhaseSucceeded, tacokumogithubiov1alpha1.ClusterMigrationPhaseAborted:
+		return nil
+	}
+
+	if err := m.verifyGateway(ctx, cm); err != nil {
+		return m.handleError(ctx, cm, err)
+	}
+
+	m.progress(ctx, cm, time.Now())
+	if err := m.k8sClient.Status().Update(ctx, cm); err != nil {
+		return m.handleError(ctx, cm, err)
+	}
+	return nil
+}
+
+func (m *Manager) progress(
+	ctx context.Context,
+	cm *tacokumogithubiov1alpha1.ClusterMigration,
+	now time.Time,
+) {
+	if cm.Status.Phase == "" {
+		cm.Status.Phase = tacokumogithubiov1alpha1.ClusterMigrationPhaseProgressing
+		m.applyStep(cm, 0, now)
+		return
+	}
+
+	result := m.probe(ctx, cm.Spec.HealthCheck, now)
+	cm.Status.LastHealthCheck = &result
+	if !result.Healthy {
+		cm.Status.ConsecutiveFailures++
+		threshold := cm.Spec.HealthCheck.FailureThreshold
+		if threshold <= 0 {
+			threshold = defaultHealthCheckFailureThreshold
+		}
+		if cm.Status.ConsecutiveFailures < threshold {
+			return
+		}
+		cm.Status.Phase = tacokumogithubiov1alpha1.ClusterMigrationPhaseAborted
+		cm.Status.CurrentStep = 0
+		cm.Status.CurrentWeight = ptr.To(cm.Spec.InitialWeight)
+		cm.Status.StepStartedAt = ptr.To(metav1.NewTime(now))
+		m.logger.Info("aborted cluster migration", "gateway", cm.Spec.Gateway, "reason", result.Message)
+		tacokumogithubiov1alpha1.SetReadyConditionFalse(&cm.Status.Conditions, cm.Generation,
+			tacokumogithubiov1alpha1.ReasonMigrationAborted,
+			fmt.Sprintf("health check failed %d times in a row (%s): weight of cluster %s is reverted to %d",
+				cm.Status.ConsecutiveFailures, result.Message, cm.Spec.ClusterName, cm.Spec.InitialWeight))
+		return
+	}
+	cm.Status.ConsecutiveFailures = 0
+
+	if cm.Status.StepStartedAt != nil && now.Before(cm.Status.StepStartedAt.Add(cm.Spec.Interval.Duration)) {
+		return
+	}
+	if int(cm.Status.CurrentStep) >= len(cm.Spec.Steps) {
+		cm.Status.Phase = tacokumogithubiov1alpha1.ClusterMigrationPhaseSucceeded
+		weight := ptr.Deref(cm.Status.CurrentWeight, cm.Spec.InitialWeight)
+		tacokumogithubiov1alpha1.SetReadyConditionTrue(&cm.Status.Conditions, cm.Generation,
+			tacokumogithubiov1alpha1.ReasonMigrationSucceeded,
+			fmt.Sprintf("weight of cluster %s is %d", cm.Spec.ClusterName, weight))
+		return
+	}
+	m.applyStep(cm, cm.Status.CurrentStep+1, now)
+}
+
+// applyStep は step 番目のステップのweightを適用する
+// step が 0 の場合は spec.initialWeight を適用する
+func (m *Manager) applyStep(cm *tacokumogithubiov1alpha1.ClusterMigration, step int32, now time.Time) {
+	weight := cm.Spec.InitialWeight
+	if step > 0 {
+		weight = cm.Spec.Steps[step-1]
+	}
+	cm.Status.CurrentStep = step
+	cm.Status.CurrentWeight = ptr.To(weight)
+	cm.Status.StepStartedAt = ptr.To(metav1.NewTime(now))
+	m.logger.Info("applied cluster migration step", "gateway", cm.Spec.Gateway, "step", step, "weight", weight)
+	tacokumogithubiov1alpha1.SetReadyConditionFalse(&cm.Status.Conditions, cm.Generation,
+		tacokumogithubiov1alpha1.ReasonMigrationProgressing,
+		fmt.Sprintf("step %d/%d: weight of cluster %s is set to %d",
+			step, len(cm.Spec.Steps), cm.Spec.ClusterName, weight))
+}
+
+// verifyGateway はマイグレーションの対象のGatewayがDNSレコードを管理できるか確認する
+func (m *Manager) verifyGateway(
+	ctx context.Context,
+	cm *tacokumogithubiov1alpha1.ClusterMigration,
+) error {
+	gw := &tacokumogithubiov1alpha1.Gateway{}
+	if err := m.k8sClient.Get(ctx, client.ObjectKey{Namespace: cm.Namespace, Name: cm.Spec.Gateway}, gw); err != nil {
+		if apierrors.IsNotFound(err) {
+			return fmt.Errorf("gateway %s not found", cm.Spec.Gateway)
+		}
+		return err
+	}
+	if gw.Spec.Host != "" {
+		return fmt.Errorf("gateway %s uses spec.host and does not register DNS records", cm.Spec.Gateway)
+	}
+	return nil
+}
+
+// probe はヘルスチェックのURLにGETリクエストを送信し､その結果を返す
+func (m *Manager) probe(
+	ctx context.Context,
+	hc tacokumogithubiov1alpha1.ClusterMigrationHealthCheck,
+	now time.Time,
+) tacokumogithubiov1alpha1.ClusterMigrationHealthCheckResult {
+	result := tacokumogithubiov1alpha1.ClusterMigrationHealthCheckResult{Time: metav1.NewTime(now)}
+
+	timeout := hc.Timeout.Duration
+	if timeout <= 0 {
+		timeout = defaultHealthCheckTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, hc.URL, nil)
+	if err != nil {
+		result.Message = err.Error()
+		return result
+	}
+	resp, err := m.httpClient.Do(req)
+	if err != nil {
+		result.Message = err.Error()
+		return result
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	expected := int(hc.ExpectedStatus)
+	if expected == 0 {
+		expected = defaultHealthCheckExpectedStatus
+	}
+	if resp.StatusCode != expected {
+		result.Message = fmt.Sprintf("expected status %d but got %d", expected, resp.StatusCode)
+		return result
+	}
+	result.Healthy = true
+	return result
+}
+
+func (m *Manager) handleError(
+	ctx context.Context,
+	cm *tacokumogithubiov1alpha1.ClusterMigration,
+	err error,
+) error {
+	// 引数のerrorは必ずnilではない
+	tacokumogithubiov1alpha1.SetReadyConditionFalse(
+		&cm.Status.Conditions,
+		cm.Generation,
+		tacokumogithubiov1alpha1.ReasonReconcileError,
+		err.Error(),
+	)
+
+	// errorだとしても､Statusの更新は必要
+	if updateErr := m.k8sClient.Status().Update(ctx, cm); updateErr != nil {
+		return updateErr
+	}
+	return err
+}
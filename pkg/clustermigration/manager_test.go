@@ -0,0 +1,243 @@
+package clustermigration
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	tacokumogithubiov1alpha1 "github.com/tacokumo/portal-controller-kubernetes/api/v1alpha1"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sruntime "k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func newTestScheme(t *testing.T) *k8sruntime.Scheme {
+	t.Helper()
+	scheme := k8sruntime.NewScheme()
+	require.NoError(t, tacokumogithubiov1alpha1.AddToScheme(scheme))
+	return scheme
+}
+
+func newTestGateway(host string) *tacokumogithubiov1alpha1.Gateway {
+	return &tacokumogithubiov1alpha1.Gateway{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "test-app"},
+		Spec:       tacokumogithubiov1alpha1.GatewaySpec{Application: "test-app", Host: host},
+	}
+}
+
+func newTestHealthServer(t *testing.T, status int) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestManager_Reconcile(t *testing.T) {
+	longAgo := ptr.To(metav1.NewTime(time.Now().Add(-time.Hour)))
+	justNow := ptr.To(metav1.NewTime(time.Now()))
+
+	tests := []struct {
+		name              string
+		healthStatus      int
+		status            tacokumogithubiov1alpha1.ClusterMigrationStatus
+		expectPhase       string
+		expectStep        int32
+		expectWeight      int32
+		expectFailures    int32
+		expectReason      string
+		expectReady       metav1.ConditionStatus
+		expectHealthCheck bool
+	}{
+		{
+			name:         "starts with the initial weight",
+			healthStatus: http.StatusOK,
+			expectPhase:  tacokumogithubiov1alpha1.ClusterMigrationPhaseProgressing,
+			expectStep:   0,
+			expectWeight: 0,
+			expectReason: tacokumogithubiov1alpha1.ReasonMigrationProgressing,
+			expectReady:  metav1.ConditionFalse,
+		},
+		{
+			name:         "advances to the next step after the interval",
+			healthStatus: http.StatusOK,
+			status: tacokumogithubiov1alpha1.ClusterMigrationStatus{
+				Phase:         tacokumogithubiov1alpha1.ClusterMigrationPhaseProgressing,
+				CurrentStep:   1,
+				CurrentWeight: ptr.To(int32(10)),
+				StepStartedAt: longAgo,
+			},
+			expectPhase:       tacokumogithubiov1alpha1.ClusterMigrationPhaseProgressing,
+			expectStep:        2,
+			expectWeight:      50,
+			expectReason:      tacokumogithubiov1alpha1.ReasonMigrationProgressing,
+			expectReady:       metav1.ConditionFalse,
+			expectHealthCheck: true,
+		},
+		{
+			name:         "keeps the weight within the interval",
+			healthStatus: http.StatusOK,
+			status: tacokumogithubiov1alpha1.ClusterMigrationStatus{
+				Phase:               tacokumogithubiov1alpha1.ClusterMigrationPhaseProgressing,
+				CurrentStep:         1,
+				CurrentWeight:       ptr.To(int32(10)),
+				StepStartedAt:       justNow,
+				ConsecutiveFailures: 2,
+			},
+			expectPhase:       tacokumogithubiov1alpha1.ClusterMigrationPhaseProgressing,
+			expectStep:        1,
+			expectWeight:      10,
+			expectHealthCheck: true,
+		},
+		{
+			name:         "succeeds after the last step",
+			healthStatus: http.StatusOK,
+			status: tacokumogithubiov1alpha1.ClusterMigrationStatus{
+				Phase:         tacokumogithubiov1alpha1.ClusterMigrationPhaseProgressing,
+				CurrentStep:   3,
+				CurrentWeight: ptr.To(int32(100)),
+				StepStartedAt: longAgo,
+			},
+			expectPhase:       tacokumogithubiov1alpha1.ClusterMigrationPhaseSucceeded,
+			expectStep:        3,
+			expectWeight:      100,
+			expectReason:      tacokumogithubiov1alpha1.ReasonMigrationSucceeded,
+			expectReady:       metav1.ConditionTrue,
+			expectHealthCheck: true,
+		},
+		{
+			name:         "does not advance while the health check fails",
+			healthStatus: http.StatusServiceUnavailable,
+			status: tacokumogithubiov1alpha1.ClusterMigrationStatus{
+				Phase:         tacokumogithubiov1alpha1.ClusterMigrationPhaseProgressing,
+				CurrentStep:   1,
+				CurrentWeight: ptr.To(int32(10)),
+				StepStartedAt: longAgo,
+			},
+			expectPhase:       tacokumogithubiov1alpha1.ClusterMigrationPhaseProgressing,
+			expectStep:        1,
+			expectWeight:      10,
+			expectFailures:    1,
+			expectHealthCheck: true,
+		},
+		{
+			name:         "aborts and reverts the weight after repeated failures",
+			healthStatus: http.StatusServiceUnavailable,
+			status: tacokumogithubiov1alpha1.ClusterMigrationStatus{
+				Phase:               tacokumogithubiov1alpha1.ClusterMigrationPhaseProgressing,
+				CurrentStep:         2,
+				CurrentWeight:       ptr.To(int32(50)),
+				StepStartedAt:       longAgo,
+				ConsecutiveFailures: 2,
+			},
+			expectPhase:       tacokumogithubiov1alpha1.ClusterMigrationPhaseAborted,
+			expectStep:        0,
+			expectWeight:      0,
+			expectFailures:    3,
+			expectReason:      tacokumogithubiov1alpha1.ReasonMigrationAborted,
+			expectReady:       metav1.ConditionFalse,
+			expectHealthCheck: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newTestHealthServer(t, tt.healthStatus)
+			cm := &tacokumogithubiov1alpha1.ClusterMigration{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "migrate-to-b", Generation: 1},
+				Spec: tacokumogithubiov1alpha1.ClusterMigrationSpec{
+					Gateway:     "test-app",
+					ClusterName: "cluster-b",
+					Steps:       []int32{10, 50, 100},
+					Interval:    metav1.Duration{Duration: 10 * time.Minute},
+					HealthCheck: tacokumogithubiov1alpha1.ClusterMigrationHealthCheck{
+						URL:              server.URL + "/healthz",
+						FailureThreshold: 3,
+					},
+				},
+				Status: tt.status,
+			}
+			k8sClient := fake.NewClientBuilder().
+				WithScheme(newTestScheme(t)).
+				WithObjects(cm, newTestGateway("")).
+				WithStatusSubresource(cm).
+				Build()
+			m := NewManager(logr.Discard(), k8sClient)
+
+			require.NoError(t, m.Reconcile(context.Background(), cm))
+
+			stored := &tacokumogithubiov1alpha1.ClusterMigration{}
+			require.NoError(t, k8sClient.Get(context.Background(), client.ObjectKeyFromObject(cm), stored))
+			assert.Equal(t, tt.expectPhase, stored.Status.Phase)
+			assert.Equal(t, tt.expectStep, stored.Status.CurrentStep)
+			assert.Equal(t, ptr.To(tt.expectWeight), stored.Status.CurrentWeight)
+			assert.Equal(t, tt.expectFailures, stored.Status.ConsecutiveFailures)
+			if tt.expectHealthCheck {
+				require.NotNil(t, stored.Status.LastHealthCheck)
+				assert.Equal(t, tt.healthStatus == http.StatusOK, stored.Status.LastHealthCheck.Healthy)
+			} else {
+				assert.Nil(t, stored.Status.LastHealthCheck)
+			}
+			if tt.expectReason != "" {
+				cond := meta.FindStatusCondition(stored.Status.Conditions, tacokumogithubiov1alpha1.ConditionTypeReady)
+				require.NotNil(t, cond)
+				assert.Equal(t, tt.expectReady, cond.Status)
+				assert.Equal(t, tt.expectReason, cond.Reason)
+			}
+		})
+	}
+}
+
+func TestManager_Reconcile_Error(t *testing.T) {
+	tests := []struct {
+		name          string
+		objects       []client.Object
+		expectMessage string
+	}{
+		{
+			name:          "gateway not found",
+			expectMessage: "gateway test-app not found",
+		},
+		{
+			name:          "gateway does not register DNS records",
+			objects:       []client.Object{newTestGateway("www.example.com")},
+			expectMessage: "gateway test-app uses spec.host and does not register DNS records",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cm := &tacokumogithubiov1alpha1.ClusterMigration{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "migrate-to-b"},
+				Spec: tacokumogithubiov1alpha1.ClusterMigrationSpec{
+					Gateway:     "test-app",
+					ClusterName: "cluster-b",
+					Steps:       []int32{100},
+				},
+			}
+			k8sClient := fake.NewClientBuilder().
+				WithScheme(newTestScheme(t)).
+				WithObjects(append([]client.Object{cm}, tt.objects...)...).
+				WithStatusSubresource(cm).
+				Build()
+			m := NewManager(logr.Discard(), k8sClient)
+
+			require.Error(t, m.Reconcile(context.Background(), cm))
+
+			assert.Empty(t, cm.Status.Phase)
+			cond := meta.FindStatusCondition(cm.Status.Conditions, tacokumogithubiov1alpha1.ConditionTypeReady)
+			require.NotNil(t, cond)
+			assert.Equal(t, tt.expectMessage, cond.Message)
+		})
+	}
+}
@@ -0,0 +1,210 @@
+package deploywindow
+
+import (
+	"fmt"
+	stdbits "math/bits"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// schedule は5フィールドのcron式 (分 時 日 月 曜日) を表す
+type schedule struct {
+	minute     uint64
+	hour       uint64
+	dayOfMonth uint64
+	month      uint64
+	dayOfWeek  uint64
+	// domStar と dowStar は日と曜日が `*` で指定されたかどうかを示す
+	// 両方が制限されている場合はcronと同様にどちらかに一致すればよい
+	domStar bool
+	dowStar bool
+}
+
+type fieldRange struct {
+	min, max int
+	names    map[string]int
+}
+
+var (
+	minuteRange     = fieldRange{min: 0, max: 59}
+	hourRange       = fieldRange{min: 0, max: 23}
+	dayOfMonthRange = fieldRange{min: 1, max: 31}
+	monthRange      = fieldRange{min: 1, max: 12, names: map[string]int{
+		"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+		"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+	}}
+	// 曜日は0と7のどちらも日曜日を示す
+	dayOfWeekRange = fieldRange{min: 0, max: 7, names: map[string]int{
+		"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+	}}
+)
+
+// parseSchedule はcron式を解釈する
+// `*`､数値､範囲 (`1-5`)､間隔 (`*/15`)､カンマ区切りのリストと､月と曜日の英語の略称に対応する
+func parseSchedule(spec string) (*schedule, error) {
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("invalid schedule %q: expected 5 fields, got %d", spec, len(fields))
+	}
+
+	s := &schedule{
+		domStar: fields[2] == "*",
+		dowStar: fields[4] == "*",
+	}
+	var err error
+	for i, target := range []struct {
+		bits *uint64
+		r    fieldRange
+	}{
+		{&s.minute, minuteRange},
+		{&s.hour, hourRange},
+		{&s.dayOfMonth, dayOfMonthRange},
+		{&s.month, monthRange},
+		{&s.dayOfWeek, dayOfWeekRange},
+	} {
+		if *target.bits, err = parseField(fields[i], target.r); err != nil {
+			return nil, fmt.Errorf("invalid schedule %q: %w", spec, err)
+		}
+	}
+	if s.dayOfWeek&(1<<7) != 0 {
+		s.dayOfWeek |= 1 << 0
+	}
+	return s, nil
+}
+
+func parseField(field string, r fieldRange) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		rangePart, stepPart, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			var err error
+			if step, err = strconv.Atoi(stepPart); err != nil || step <= 0 {
+				return 0, fmt.Errorf("invalid step %q", part)
+			}
+		}
+
+		var lower, upper int
+		switch {
+		case rangePart == "*":
+			lower, upper = r.min, r.max
+		case strings.Contains(rangePart, "-"):
+			lo, hi, _ := strings.Cut(rangePart, "-")
+			var err error
+			if lower, err = parseValue(lo, r); err != nil {
+				return 0, err
+			}
+			if upper, err = parseValue(hi, r); err != nil {
+				return 0, err
+			}
+			if lower > upper {
+				return 0, fmt.Errorf("invalid range %q", rangePart)
+			}
+		default:
+			value, err := parseValue(rangePart, r)
+			if err != nil {
+				return 0, err
+			}
+			lower, upper = value, value
+			if hasStep {
+				upper = r.max
+			}
+		}
+
+		for v := lower; v <= upper; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+func parseValue(value string, r fieldRange) (int, error) {
+	if n, ok := r.names[strings.ToLower(value)]; ok {
+		return n, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value %q", value)
+	}
+	if n < r.min || n > r.max {
+		return 0, fmt.Errorf("value %d out of range [%d, %d]", n, r.min, r.max)
+	}
+	return n, nil
+}
+
+// matches は t の分がスケジュールに一致するかどうかを返す
+func (s *schedule) matches(t time.Time) bool {
+	if s.minute&(1<<uint(t.Minute())) == 0 ||
+		s.hour&(1<<uint(t.Hour())) == 0 ||
+		s.month&(1<<uint(t.Month())) == 0 {
+		return false
+	}
+	return s.matchesDay(t)
+}
+
+// lastActivation は (t-duration, t] の範囲でスケジュールに一致する最後の時刻を返す
+// 一致する時刻がない場合は false を返す
+// 一致しない月､日､時はまとめて読み飛ばすため､長いウィンドウでも反復回数は日数程度に収まる
+func (s *schedule) lastActivation(t time.Time, duration time.Duration) (time.Time, bool) {
+	start := t.Add(-duration)
+	for m := t.Truncate(time.Minute); m.After(start); {
+		prev := s.previousCandidate(m)
+		if prev.Equal(m) {
+			return m, true
+		}
+		// 夏時間の切り替えで正規化された時刻が戻らない場合も必ず進むようにする
+		if !prev.Before(m) {
+			prev = m.Add(-time.Minute)
+		}
+		m = prev
+	}
+	return time.Time{}, false
+}
+
+// previousCandidate は m がスケジュールに一致する場合は m を返し､
+// 一致しない場合は一致し得ない範囲を読み飛ばした m より前の時刻を返す
+func (s *schedule) previousCandidate(m time.Time) time.Time {
+	year, month, day := m.Date()
+	loc := m.Location()
+	switch {
+	case s.month&(1<<uint(month)) == 0:
+		// 前月の最後の分
+		return time.Date(year, month, 1, 0, 0, 0, 0, loc).Add(-time.Minute)
+	case !s.matchesDay(m):
+		// 前日の最後の分
+		return time.Date(year, month, day, 0, 0, 0, 0, loc).Add(-time.Minute)
+	}
+
+	hour, ok := highestBitAtMost(s.hour, m.Hour())
+	if !ok {
+		return time.Date(year, month, day, 0, 0, 0, 0, loc).Add(-time.Minute)
+	}
+	if hour != m.Hour() {
+		return time.Date(year, month, day, hour, 59, 0, 0, loc)
+	}
+	minute, ok := highestBitAtMost(s.minute, m.Minute())
+	if !ok {
+		return time.Date(year, month, day, hour, 0, 0, 0, loc).Add(-time.Minute)
+	}
+	return time.Date(year, month, day, hour, minute, 0, 0, loc)
+}
+
+// matchesDay は t の日付が日と曜日のフィールドに一致するかどうかを返す
+func (s *schedule) matchesDay(t time.Time) bool {
+	dom := s.dayOfMonth&(1<<uint(t.Day())) != 0
+	dow := s.dayOfWeek&(1<<uint(t.Weekday())) != 0
+	if s.domStar || s.dowStar {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+// highestBitAtMost は bits のうち n 以下で最大の立っているビットの位置を返す
+func highestBitAtMost(bits uint64, n int) (int, bool) {
+	masked := bits & (1<<uint(n+1) - 1)
+	if masked == 0 {
+		return 0, false
+	}
+	return stdbits.Len64(masked) - 1, true
+}
@@ -0,0 +1,113 @@
+package deploywindow
+
+import (
+	"fmt"
+	"slices"
+	"time"
+	// distrolessイメージにはタイムゾーンのデータベースが含まれないため埋め込む
+	_ "time/tzdata"
+
+	tacokumogithubiov1alpha1 "github.com/tacokumo/portal-controller-kubernetes/api/v1alpha1"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// Target はデプロイウィンドウを評価するReleaseの属性を表す
+type Target struct {
+	// Stage はReleaseのStage
+	Stage string
+	// ApplicationLabels はReleaseを所有するApplicationのラベル
+	// Applicationに所有されていない場合は nil
+	ApplicationLabels labels.Set
+}
+
+// Evaluate は now において target のデプロイがDeployWindowによって止められているかどうかを返す
+// 止められている場合はその理由を返す
+// 解釈できないウィンドウは､管理者が修正するまでデプロイを止めるものとして扱う
+func Evaluate(windows []tacokumogithubiov1alpha1.DeployWindow, target Target, now time.Time) (bool, string) {
+	for _, window := range windows {
+		selected, err := selects(&window.Spec, target)
+		if err != nil {
+			return true, fmt.Sprintf("deploy window %q is invalid: %v", window.Name, err)
+		}
+		if !selected {
+			continue
+		}
+		if blocked, message := evaluateRules(window.Spec.Windows, now); blocked {
+			return true, fmt.Sprintf("deploy window %q %s", window.Name, message)
+		}
+	}
+	return false, ""
+}
+
+func selects(spec *tacokumogithubiov1alpha1.DeployWindowSpec, target Target) (bool, error) {
+	if len(spec.Stages) > 0 && !slices.Contains(spec.Stages, target.Stage) {
+		return false, nil
+	}
+	if spec.ApplicationSelector == nil {
+		return true, nil
+	}
+	selector, err := metav1.LabelSelectorAsSelector(spec.ApplicationSelector)
+	if err != nil {
+		return false, err
+	}
+	// Applicationに所有されていないReleaseはセレクタに一致しない
+	if target.ApplicationLabels == nil {
+		return false, nil
+	}
+	return selector.Matches(target.ApplicationLabels), nil
+}
+
+// evaluateRules は1つのDeployWindowに含まれるウィンドウを評価する
+func evaluateRules(rules []tacokumogithubiov1alpha1.DeployWindowRule, now time.Time) (bool, string) {
+	hasAllow := false
+	allowed := false
+	for _, rule := range rules {
+		until, active, err := activeUntil(rule, now)
+		if err != nil {
+			return true, fmt.Sprintf("is invalid: %v", err)
+		}
+		switch rule.Type {
+		case tacokumogithubiov1alpha1.DeployWindowTypeDeny:
+			if active {
+				message := fmt.Sprintf("denies deployments until %s", until.Format(time.RFC3339))
+				return true, withReason(message, rule.Reason)
+			}
+		case tacokumogithubiov1alpha1.DeployWindowTypeAllow:
+			hasAllow = true
+			allowed = allowed || active
+		}
+	}
+	if hasAllow && !allowed {
+		return true, "allows deployments only during its allow windows"
+	}
+	return false, ""
+}
+
+// activeUntil はウィンドウが now において有効かどうかと､有効な場合はその終了時刻を返す
+func activeUntil(rule tacokumogithubiov1alpha1.DeployWindowRule, now time.Time) (time.Time, bool, error) {
+	location := time.UTC
+	if rule.TimeZone != "" {
+		var err error
+		if location, err = time.LoadLocation(rule.TimeZone); err != nil {
+			return time.Time{}, false, fmt.Errorf("invalid time zone %q: %w", rule.TimeZone, err)
+		}
+	}
+	s, err := parseSchedule(rule.Schedule)
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	start, ok := s.lastActivation(now.In(location), rule.Duration.Duration)
+	if !ok {
+		return time.Time{}, false, nil
+	}
+	return start.Add(rule.Duration.Duration), true, nil
+}
+
+func withReason(message, reason string) string {
+	if reason == "" {
+		return message
+	}
+	return fmt.Sprintf("%s: %s", message, reason)
+}
@@ -0,0 +1,236 @@
+package deploywindow
+
+import (
+	"testing"
+	"time"
+
+	tacokumogithubiov1alpha1 "github.com/tacokumo/portal-controller-kubernetes/api/v1alpha1"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+func TestParseSchedule(t *testing.T) {
+	tests := []struct {
+		name        string
+		spec        string
+		matches     []string
+		notMatches  []string
+		expectError string
+	}{
+		{
+			name:       "weekday business hours",
+			spec:       "*/30 9-17 * * mon-fri",
+			matches:    []string{"2026-10-19T09:00:00Z", "2026-10-23T17:30:00Z"},
+			notMatches: []string{"2026-10-19T09:15:00Z", "2026-10-19T18:00:00Z", "2026-10-18T10:00:00Z"},
+		},
+		{
+			name:       "sunday as 7",
+			spec:       "0 0 * * 7",
+			matches:    []string{"2026-10-18T00:00:00Z"},
+			notMatches: []string{"2026-10-17T00:00:00Z"},
+		},
+		{
+			name:       "day of month or day of week",
+			spec:       "0 0 1,15 dec sun",
+			matches:    []string{"2026-12-01T00:00:00Z", "2026-12-06T00:00:00Z"},
+			notMatches: []string{"2026-12-02T00:00:00Z", "2026-11-01T00:00:00Z"},
+		},
+		{
+			name:        "wrong number of fields",
+			spec:        "0 0 * *",
+			expectError: `invalid schedule "0 0 * *": expected 5 fields, got 4`,
+		},
+		{
+			name:        "out of range",
+			spec:        "0 24 * * *",
+			expectError: `invalid schedule "0 24 * * *": value 24 out of range [0, 23]`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := parseSchedule(tt.spec)
+			if tt.expectError != "" {
+				require.EqualError(t, err, tt.expectError)
+				return
+			}
+			require.NoError(t, err)
+			for _, value := range tt.matches {
+				assert.True(t, s.matches(mustParseTime(t, value)), "expected %s to match", value)
+			}
+			for _, value := range tt.notMatches {
+				assert.False(t, s.matches(mustParseTime(t, value)), "expected %s not to match", value)
+			}
+		})
+	}
+}
+
+func TestSchedule_lastActivation(t *testing.T) {
+	// 1分ずつ遡って探索した結果と一致することを確認する
+	naive := func(s *schedule, now time.Time, duration time.Duration) (time.Time, bool) {
+		start := now.Add(-duration)
+		for m := now.Truncate(time.Minute); m.After(start); m = m.Add(-time.Minute) {
+			if s.matches(m) {
+				return m, true
+			}
+		}
+		return time.Time{}, false
+	}
+
+	tokyo, err := time.LoadLocation("Asia/Tokyo")
+	require.NoError(t, err)
+	newYork, err := time.LoadLocation("America/New_York")
+	require.NoError(t, err)
+
+	specs := []string{
+		"0 0 * * sat",
+		"*/30 9-17 * * mon-fri",
+		"0 0 1,15 dec sun",
+		"45 23 31 * *",
+		"0 2 * mar sun",
+		"* * * * *",
+	}
+	nows := []time.Time{
+		time.Date(2026, 10, 19, 12, 34, 56, 0, time.UTC),
+		time.Date(2026, 12, 31, 23, 59, 0, 0, tokyo),
+		time.Date(2026, 3, 8, 3, 30, 0, 0, newYork),
+		time.Date(2026, 11, 1, 1, 30, 0, 0, newYork),
+	}
+	for _, spec := range specs {
+		s, err := parseSchedule(spec)
+		require.NoError(t, err)
+		for _, now := range nows {
+			for _, duration := range []time.Duration{time.Minute, 90 * time.Minute, 48 * time.Hour, 14 * 24 * time.Hour} {
+				want, wantOK := naive(s, now, duration)
+				got, gotOK := s.lastActivation(now, duration)
+				assert.Equal(t, wantOK, gotOK, "%s at %s for %s", spec, now, duration)
+				assert.True(t, want.Equal(got), "%s at %s for %s: want %s, got %s", spec, now, duration, want, got)
+			}
+		}
+	}
+}
+
+func TestEvaluate(t *testing.T) {
+	weekendFreeze := tacokumogithubiov1alpha1.DeployWindow{
+		ObjectMeta: metav1.ObjectMeta{Name: "weekend-freeze"},
+		Spec: tacokumogithubiov1alpha1.DeployWindowSpec{
+			Stages: []string{"production"},
+			Windows: []tacokumogithubiov1alpha1.DeployWindowRule{
+				{
+					Type:     tacokumogithubiov1alpha1.DeployWindowTypeDeny,
+					Schedule: "0 0 * * sat",
+					Duration: metav1.Duration{Duration: 48 * time.Hour},
+					TimeZone: "Asia/Tokyo",
+					Reason:   "weekend",
+				},
+			},
+		},
+	}
+	businessHours := tacokumogithubiov1alpha1.DeployWindow{
+		ObjectMeta: metav1.ObjectMeta{Name: "business-hours"},
+		Spec: tacokumogithubiov1alpha1.DeployWindowSpec{
+			ApplicationSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"team": "payments"}},
+			Windows: []tacokumogithubiov1alpha1.DeployWindowRule{
+				{
+					Type:     tacokumogithubiov1alpha1.DeployWindowTypeAllow,
+					Schedule: "0 9 * * mon-fri",
+					Duration: metav1.Duration{Duration: 8 * time.Hour},
+				},
+			},
+		},
+	}
+	production := Target{Stage: "production", ApplicationLabels: labels.Set{}}
+
+	tests := []struct {
+		name          string
+		windows       []tacokumogithubiov1alpha1.DeployWindow
+		target        Target
+		now           string
+		expectBlocked bool
+		expectMessage string
+	}{
+		{
+			name:          "deny window is active in its time zone",
+			windows:       []tacokumogithubiov1alpha1.DeployWindow{weekendFreeze},
+			target:        production,
+			now:           "2026-10-16T16:00:00Z",
+			expectBlocked: true,
+			expectMessage: `deploy window "weekend-freeze" denies deployments until 2026-10-19T00:00:00+09:00: weekend`,
+		},
+		{
+			name:    "deny window has ended",
+			windows: []tacokumogithubiov1alpha1.DeployWindow{weekendFreeze},
+			target:  production,
+			now:     "2026-10-18T15:00:00Z",
+		},
+		{
+			name:    "other stages are not selected",
+			windows: []tacokumogithubiov1alpha1.DeployWindow{weekendFreeze},
+			target:  Target{Stage: "staging"},
+			now:     "2026-10-17T12:00:00Z",
+		},
+		{
+			name:    "allow window is active",
+			windows: []tacokumogithubiov1alpha1.DeployWindow{businessHours},
+			target:  Target{Stage: "staging", ApplicationLabels: labels.Set{"team": "payments"}},
+			now:     "2026-10-19T16:59:00Z",
+		},
+		{
+			name:          "outside of the allow windows",
+			windows:       []tacokumogithubiov1alpha1.DeployWindow{businessHours},
+			target:        Target{Stage: "staging", ApplicationLabels: labels.Set{"team": "payments"}},
+			now:           "2026-10-19T17:00:00Z",
+			expectBlocked: true,
+			expectMessage: `deploy window "business-hours" allows deployments only during its allow windows`,
+		},
+		{
+			name:    "applications not matching the selector are not selected",
+			windows: []tacokumogithubiov1alpha1.DeployWindow{businessHours},
+			target:  Target{Stage: "staging", ApplicationLabels: labels.Set{"team": "search"}},
+			now:     "2026-10-19T17:00:00Z",
+		},
+		{
+			name:    "releases without an application are not selected by the selector",
+			windows: []tacokumogithubiov1alpha1.DeployWindow{businessHours},
+			target:  Target{Stage: "staging"},
+			now:     "2026-10-19T17:00:00Z",
+		},
+		{
+			name: "invalid windows block deployments",
+			windows: []tacokumogithubiov1alpha1.DeployWindow{{
+				ObjectMeta: metav1.ObjectMeta{Name: "broken"},
+				Spec: tacokumogithubiov1alpha1.DeployWindowSpec{
+					Windows: []tacokumogithubiov1alpha1.DeployWindowRule{{
+						Type:     tacokumogithubiov1alpha1.DeployWindowTypeDeny,
+						Schedule: "0 0 * * *",
+						Duration: metav1.Duration{Duration: time.Hour},
+						TimeZone: "Mars/Olympus",
+					}},
+				},
+			}},
+			target:        production,
+			now:           "2026-10-19T17:00:00Z",
+			expectBlocked: true,
+			expectMessage: `deploy window "broken" is invalid: ` +
+				`invalid time zone "Mars/Olympus": unknown time zone Mars/Olympus`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			blocked, message := Evaluate(tt.windows, tt.target, mustParseTime(t, tt.now))
+			assert.Equal(t, tt.expectBlocked, blocked)
+			assert.Equal(t, tt.expectMessage, message)
+		})
+	}
+}
+
+func mustParseTime(t *testing.T, value string) time.Time {
+	t.Helper()
+	parsed, err := time.Parse(time.RFC3339, value)
+	require.NoError(t, err)
+	return parsed
+}
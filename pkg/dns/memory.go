@@ -0,0 +1,109 @@
+package dns
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// MemoryProvider はレコードをメモリに保持するProvider
+// ファイルのパスが指定された場合は､変更のたびにJSONとして書き出し､起動時に読み込む
+// テストや､外部のDNSサービスを使用しない開発環境での利用を想定している
+type MemoryProvider struct {
+	mu      sync.Mutex
+	path    string
+	records map[string]Record
+}
+
+var _ Provider = (*MemoryProvider)(nil)
+
+func NewMemoryProvider() *MemoryProvider {
+	return &MemoryProvider{
+		records: map[string]Record{},
+	}
+}
+
+// NewFileProvider は path にレコードを永続化するMemoryProviderを作成する
+// path が存在する場合は登録済みのレコードとして読み込む
+func NewFileProvider(path string) (*MemoryProvider, error) {
+	p := NewMemoryProvider()
+	p.path = path
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return p, nil
+		}
+		return nil, err
+	}
+	var records []Record
+	if err := json.Unmarshal(data, &records); err != nil {
+		return nil, fmt.Errorf("failed to load dns records from %s: %w", path, err)
+	}
+	for _, record := range records {
+		p.records[record.Key()] = record
+	}
+	return p, nil
+}
+
+func (p *MemoryProvider) UpsertRecord(_ context.Context, record Record) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.records[record.Key()] = record
+	return p.save()
+}
+
+func (p *MemoryProvider) DeleteRecord(_ context.Context, record Record) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.records, record.Key())
+	return p.save()
+}
+
+func (p *MemoryProvider) ListRecords(_ context.Context, name string) ([]Record, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var records []Record
+	for _, record := range p.sortedRecords() {
+		if record.Name == name {
+			records = append(records, record)
+		}
+	}
+	return records, nil
+}
+
+// sortedRecords は書き出すファイルの内容が安定するよう､レコードをKey順に返す
+func (p *MemoryProvider) sortedRecords() []Record {
+	records := make([]Record, 0, len(p.records))
+	for _, record := range p.records {
+		records = append(records, record)
+	}
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].Key() < records[j].Key()
+	})
+	return records
+}
+
+func (p *MemoryProvider) save() error {
+	if p.path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(p.sortedRecords(), "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(p.path), 0o755); err != nil {
+		return err
+	}
+	// 書き込み途中のファイルを読み込まないよう､一時ファイルからrenameする
+	tmp := p.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, p.path)
+}
@@ -0,0 +1,68 @@
+package dns
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/utils/ptr"
+)
+
+func TestMemoryProvider(t *testing.T) {
+	ctx := context.Background()
+	p := NewMemoryProvider()
+	blue := Record{
+		Name: "web.team-a.app.tacokumo.dev", Type: RecordTypeA, Targets: []string{"203.0.113.10"},
+		SetIdentifier: "blue", Weight: ptr.To(int64(90)),
+	}
+	green := Record{
+		Name: "web.team-a.app.tacokumo.dev", Type: RecordTypeA, Targets: []string{"203.0.113.20"},
+		SetIdentifier: "green", Weight: ptr.To(int64(10)),
+	}
+	require.NoError(t, p.UpsertRecord(ctx, blue))
+	require.NoError(t, p.UpsertRecord(ctx, green))
+
+	// 同じKeyのレコードは置き換えられる
+	green.Weight = ptr.To(int64(50))
+	require.NoError(t, p.UpsertRecord(ctx, green))
+
+	records, err := p.ListRecords(ctx, "web.team-a.app.tacokumo.dev")
+	require.NoError(t, err)
+	assert.Equal(t, []Record{blue, green}, records)
+
+	require.NoError(t, p.DeleteRecord(ctx, blue))
+	// 存在しないレコードの削除はエラーにならない
+	require.NoError(t, p.DeleteRecord(ctx, blue))
+	records, err = p.ListRecords(ctx, "web.team-a.app.tacokumo.dev")
+	require.NoError(t, err)
+	assert.Equal(t, []Record{green}, records)
+}
+
+func TestFileProvider(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "dns", "records.json")
+	record := Record{
+		Name: "web.team-a.app.tacokumo.dev", Type: RecordTypeCNAME,
+		Targets: []string{"lb.example.net"}, TTL: DefaultTTL,
+	}
+
+	p, err := NewFileProvider(path)
+	require.NoError(t, err)
+	require.NoError(t, p.UpsertRecord(ctx, record))
+
+	// 再起動後も登録済みのレコードを読み込める
+	reloaded, err := NewFileProvider(path)
+	require.NoError(t, err)
+	records, err := reloaded.ListRecords(ctx, record.Name)
+	require.NoError(t, err)
+	assert.Equal(t, []Record{record}, records)
+
+	require.NoError(t, reloaded.DeleteRecord(ctx, record))
+	reloaded, err = NewFileProvider(path)
+	require.NoError(t, err)
+	records, err = reloaded.ListRecords(ctx, record.Name)
+	require.NoError(t, err)
+	assert.Empty(t, records)
+}
@@ -0,0 +1,108 @@
+package dns
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+const (
+	// RecordTypeA はIPアドレスを指すレコードを示す
+	RecordTypeA = "A"
+	// RecordTypeCNAME は別のホスト名を指すレコードを示す
+	RecordTypeCNAME = "CNAME"
+
+	// DefaultTTL はレコードのTTLが指定されない場合に使用される秒数
+	DefaultTTL = 300
+)
+
+// Record はDNSプロバイダに登録するレコードを表す
+type Record struct {
+	// Name はレコードのFQDN
+	Name string `json:"name"`
+	// Type はレコードの種類
+	Type string `json:"type"`
+	// Targets はレコードの値
+	Targets []string `json:"targets"`
+	// TTL はレコードのTTLの秒数
+	TTL int64 `json:"ttl"`
+	// SetIdentifier は同じ名前の重み付きレコードを識別する
+	// 空の場合は重み付きレコードではない
+	SetIdentifier string `json:"setIdentifier,omitempty"`
+	// Weight は重み付きレコードの重み
+	// SetIdentifier が指定されている場合のみ使用される
+	Weight *int64 `json:"weight,omitempty"`
+}
+
+// Key はプロバイダ内でレコードを一意に識別する文字列を返す
+func (r Record) Key() string {
+	return fmt.Sprintf("%s/%s/%s", r.Name, r.Type, r.SetIdentifier)
+}
+
+// Provider はDNSのレコードを管理する外部サービスを抽象化したもの
+// AWS Route53やCloudflare DNSなどの実装を差し替えられるようにする
+type Provider interface {
+	// UpsertRecord はレコードを作成､または同じ Key のレコードを置き換える
+	UpsertRecord(ctx context.Context, record Record) error
+	// DeleteRecord は同じ Key のレコードを削除する
+	// レコードが存在しない場合はエラーにならない
+	DeleteRecord(ctx context.Context, record Record) error
+	// ListRecords は name のレコードを全て返す
+	ListRecords(ctx context.Context, name string) ([]Record, error)
+}
+
+// FQDN はテナントとアプリケーションの名前からホスト名を構築する
+// ADR004のドメイン設計に従い､ `<name>.<tenant>.app.<baseDomain>` の形式になる
+func FQDN(name, tenant, baseDomain string) (string, error) {
+	fqdn := strings.ToLower(fmt.Sprintf("%s.%s.app.%s", name, tenant, strings.TrimSuffix(baseDomain, ".")))
+	if errs := validation.IsDNS1123Subdomain(fqdn); len(errs) > 0 {
+		return "", fmt.Errorf("invalid fqdn %q: %s", fqdn, strings.Join(errs, ", "))
+	}
+	for _, label := range strings.Split(fqdn, ".") {
+		if errs := validation.IsDNS1123Label(label); len(errs) > 0 {
+			return "", fmt.Errorf("invalid fqdn %q: %s", fqdn, strings.Join(errs, ", "))
+		}
+	}
+	return fqdn, nil
+}
+
+// RecordFor は name が address を指すレコードを返す
+// address がIPアドレスの場合はAレコード､ホスト名の場合はCNAMEレコードになる
+func RecordFor(name, address string) Record {
+	recordType := RecordTypeCNAME
+	if net.ParseIP(address) != nil {
+		recordType = RecordTypeA
+	}
+	return Record{
+		Name:    name,
+		Type:    recordType,
+		Targets: []string{address},
+		TTL:     DefaultTTL,
+	}
+}
+
+// WeightedRecord は name が target を指す重み付きのCNAMEレコードを返す
+// 同じ name の重み付きレコードは setIdentifier で区別され､weight の比率でトラフィックが分配される
+func WeightedRecord(name, target, setIdentifier string, weight int64) Record {
+	return Record{
+		Name:          name,
+		Type:          RecordTypeCNAME,
+		Targets:       []string{target},
+		TTL:           DefaultTTL,
+		SetIdentifier: setIdentifier,
+		Weight:        &weight,
+	}
+}
+
+// ClusterFQDN は fqdn の最初のラベルの後にクラスタの名前を挿入した､クラスタ固有のホスト名を返す
+// ADR002に従い､ `app.example.com` に対して `app.<cluster>.example.com` となる
+func ClusterFQDN(fqdn, cluster string) string {
+	name, rest, found := strings.Cut(fqdn, ".")
+	if !found {
+		return fmt.Sprintf("%s.%s", name, cluster)
+	}
+	return fmt.Sprintf("%s.%s.%s", name, cluster, rest)
+}
@@ -0,0 +1,84 @@
+package dns
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFQDN(t *testing.T) {
+	tests := []struct {
+		name       string
+		appName    string
+		tenant     string
+		baseDomain string
+		expect     string
+		expectErr  bool
+	}{
+		{
+			name:       "builds the fqdn from tenant and application",
+			appName:    "web",
+			tenant:     "team-a",
+			baseDomain: "tacokumo.dev",
+			expect:     "web.team-a.app.tacokumo.dev",
+		},
+		{
+			name:       "lowercases and trims the trailing dot",
+			appName:    "Web",
+			tenant:     "Team-A",
+			baseDomain: "tacokumo.dev.",
+			expect:     "web.team-a.app.tacokumo.dev",
+		},
+		{
+			name:       "rejects invalid labels",
+			appName:    "web_app",
+			tenant:     "team-a",
+			baseDomain: "tacokumo.dev",
+			expectErr:  true,
+		},
+		{
+			name:       "rejects empty tenant",
+			appName:    "web",
+			baseDomain: "tacokumo.dev",
+			expectErr:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fqdn, err := FQDN(tt.appName, tt.tenant, tt.baseDomain)
+			if tt.expectErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expect, fqdn)
+		})
+	}
+}
+
+func TestRecordFor(t *testing.T) {
+	tests := []struct {
+		name       string
+		address    string
+		expectType string
+	}{
+		{name: "ipv4 address", address: "203.0.113.10", expectType: RecordTypeA},
+		{name: "hostname", address: "lb.example.net", expectType: RecordTypeCNAME},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			record := RecordFor("web.team-a.app.tacokumo.dev", tt.address)
+			assert.Equal(t, tt.expectType, record.Type)
+			assert.Equal(t, []string{tt.address}, record.Targets)
+			assert.Equal(t, int64(DefaultTTL), record.TTL)
+		})
+	}
+}
+
+func TestClusterFQDN(t *testing.T) {
+	assert.Equal(t, "web.cluster-b.team-a.app.tacokumo.dev", ClusterFQDN("web.team-a.app.tacokumo.dev", "cluster-b"))
+	assert.Equal(t, "web.cluster-b", ClusterFQDN("web", "cluster-b"))
+}
@@ -0,0 +1,219 @@
+package dryrun
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+
+	tacokumogithubiov1alpha1 "github.com/tacokumo/portal-controller-kubernetes/api/v1alpha1"
+	"github.com/tacokumo/portal-controller-kubernetes/pkg/helmutil"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// maxFieldsPerChange は1つのオブジェクトについて記録する変更フィールドの最大数
+	// statusの肥大化を防ぐため､超えた分は件数のみを記録する
+	maxFieldsPerChange = 20
+)
+
+// ignoredMetadataFields はサーバーが管理し､差分に含めないmetadataのフィールド
+var ignoredMetadataFields = []string{
+	"managedFields",
+	"resourceVersion",
+	"generation",
+	"uid",
+	"creationTimestamp",
+	"selfLink",
+}
+
+// Compute は desired を実際の適用(helmutil.CreateOrUpdateObject)と同じ作成または置き換えでserver-side dry-runし､
+// 現在のオブジェクトとの差分を返す
+// deleted は適用時に削除されるオブジェクトで､現在存在するもののみが差分に含まれる
+// クラスタ上のオブジェクトは変更されない
+func Compute(
+	ctx context.Context,
+	k8sClient client.Client,
+	desired []*unstructured.Unstructured,
+	deleted []corev1.ObjectReference,
+) ([]tacokumogithubiov1alpha1.DryRunChange, error) {
+	var changes []tacokumogithubiov1alpha1.DryRunChange
+
+	for _, obj := range desired {
+		live := &unstructured.Unstructured{}
+		live.SetGroupVersionKind(obj.GroupVersionKind())
+		err := k8sClient.Get(ctx, client.ObjectKeyFromObject(obj), live)
+		exists := err == nil
+		if err != nil && !apierrors.IsNotFound(err) {
+			return nil, fmt.Errorf("failed to get %s %s: %w", obj.GetKind(), obj.GetName(), err)
+		}
+
+		result := obj.DeepCopy()
+		if err := helmutil.DryRunCreateOrUpdateObject(ctx, k8sClient, result); err != nil {
+			// 作成されるNamespaceのオブジェクトはNamespaceが存在しないため検証できない
+			if exists || !apierrors.IsNotFound(err) {
+				return nil, fmt.Errorf("dry-run apply of %s %s failed: %w", obj.GetKind(), obj.GetName(), err)
+			}
+		}
+
+		if !exists {
+			changes = append(changes, changeOf(obj, tacokumogithubiov1alpha1.DryRunActionCreated, nil))
+			continue
+		}
+		if fields := DiffFields(live, result); len(fields) > 0 {
+			changes = append(changes, changeOf(obj, tacokumogithubiov1alpha1.DryRunActionChanged, fields))
+		}
+	}
+
+	for _, ref := range deleted {
+		live := &unstructured.Unstructured{}
+		live.SetAPIVersion(ref.APIVersion)
+		live.SetKind(ref.Kind)
+		err := k8sClient.Get(ctx, client.ObjectKey{Namespace: ref.Namespace, Name: ref.Name}, live)
+		if apierrors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to get %s %s: %w", ref.Kind, ref.Name, err)
+		}
+		changes = append(changes, changeOf(live, tacokumogithubiov1alpha1.DryRunActionDeleted, nil))
+	}
+	return changes, nil
+}
+
+// NewStatus は changes から status に記録するdry-runの結果を作成する
+func NewStatus(
+	generation int64,
+	changes []tacokumogithubiov1alpha1.DryRunChange,
+) *tacokumogithubiov1alpha1.DryRunStatus {
+	return &tacokumogithubiov1alpha1.DryRunStatus{
+		ObservedGeneration: generation,
+		ComputedAt:         metav1.Now(),
+		Summary:            Summarize(changes),
+		Changes:            changes,
+	}
+}
+
+// Summarize は変更の種類ごとの件数を返す
+func Summarize(changes []tacokumogithubiov1alpha1.DryRunChange) string {
+	counts := map[string]int{}
+	for _, c := range changes {
+		counts[c.Action]++
+	}
+	return fmt.Sprintf("%d created, %d changed, %d deleted",
+		counts[tacokumogithubiov1alpha1.DryRunActionCreated],
+		counts[tacokumogithubiov1alpha1.DryRunActionChanged],
+		counts[tacokumogithubiov1alpha1.DryRunActionDeleted])
+}
+
+// DiffFields は live と desired で値が異なるフィールドのパスを返す
+// サーバーが管理するmetadataとstatusは比較しない
+func DiffFields(live, desired *unstructured.Unstructured) []string {
+	var fields []string
+	diffValues("", normalize(live), normalize(desired), &fields)
+	sort.Strings(fields)
+	if len(fields) > maxFieldsPerChange {
+		rest := len(fields) - maxFieldsPerChange
+		fields = append(fields[:maxFieldsPerChange], fmt.Sprintf("... and %d more", rest))
+	}
+	return fields
+}
+
+func normalize(obj *unstructured.Unstructured) map[string]interface{} {
+	normalized := obj.DeepCopy().Object
+	delete(normalized, "status")
+	if metadata, ok := normalized["metadata"].(map[string]interface{}); ok {
+		for _, field := range ignoredMetadataFields {
+			delete(metadata, field)
+		}
+	}
+	return normalized
+}
+
+func diffValues(path string, live, desired interface{}, fields *[]string) {
+	// 空のmapやsliceはフィールドが存在しないものと同じとみなす
+	if isEmptyValue(live) && isEmptyValue(desired) {
+		return
+	}
+
+	liveMap, liveIsMap := live.(map[string]interface{})
+	desiredMap, desiredIsMap := desired.(map[string]interface{})
+	if liveIsMap && desiredIsMap {
+		for key, value := range desiredMap {
+			diffValues(joinPath(path, key), liveMap[key], value, fields)
+		}
+		for key, value := range liveMap {
+			if _, ok := desiredMap[key]; !ok {
+				diffValues(joinPath(path, key), value, nil, fields)
+			}
+		}
+		return
+	}
+
+	liveSlice, liveIsSlice := live.([]interface{})
+	desiredSlice, desiredIsSlice := desired.([]interface{})
+	if liveIsSlice && desiredIsSlice && len(liveSlice) == len(desiredSlice) {
+		for i := range desiredSlice {
+			diffValues(fmt.Sprintf("%s[%d]", path, i), liveSlice[i], desiredSlice[i], fields)
+		}
+		return
+	}
+
+	if !reflect.DeepEqual(live, desired) {
+		*fields = append(*fields, path)
+	}
+}
+
+func isEmptyValue(v interface{}) bool {
+	switch value := v.(type) {
+	case nil:
+		return true
+	case map[string]interface{}:
+		return len(value) == 0
+	case []interface{}:
+		return len(value) == 0
+	}
+	return false
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+func changeOf(obj *unstructured.Unstructured, action string, fields []string) tacokumogithubiov1alpha1.DryRunChange {
+	return tacokumogithubiov1alpha1.DryRunChange{
+		Action:     action,
+		APIVersion: obj.GetAPIVersion(),
+		Kind:       obj.GetKind(),
+		Namespace:  obj.GetNamespace(),
+		Name:       obj.GetName(),
+		Fields:     fields,
+	}
+}
+
+// SetCondition はdry-runの結果をDryRun Conditionに記録する
+// err が nil でない場合は失敗として記録する
+func SetCondition(conditions *[]metav1.Condition, generation int64, summary string, err error) {
+	condition := metav1.Condition{
+		Type:               tacokumogithubiov1alpha1.ConditionTypeDryRun,
+		Status:             metav1.ConditionTrue,
+		ObservedGeneration: generation,
+		Reason:             tacokumogithubiov1alpha1.ReasonDryRunSucceeded,
+		Message:            summary,
+	}
+	if err != nil {
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = tacokumogithubiov1alpha1.ReasonDryRunFailed
+		condition.Message = err.Error()
+	}
+	meta.SetStatusCondition(conditions, condition)
+}
@@ -0,0 +1,167 @@
+package dryrun
+
+import (
+	"context"
+	"testing"
+
+	tacokumogithubiov1alpha1 "github.com/tacokumo/portal-controller-kubernetes/api/v1alpha1"
+	"github.com/tacokumo/portal-controller-kubernetes/pkg/dryrun/dryruntest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	k8sruntime "k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func newConfigMap(name string, data map[string]interface{}) *unstructured.Unstructured {
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "ConfigMap",
+		"metadata":   map[string]interface{}{"name": name, "namespace": "default"},
+		"data":       data,
+	}}
+}
+
+func TestDiffFields(t *testing.T) {
+	tests := []struct {
+		name     string
+		live     map[string]interface{}
+		desired  map[string]interface{}
+		expected []string
+	}{
+		{
+			name: "ignores server managed metadata and status",
+			live: map[string]interface{}{
+				"metadata": map[string]interface{}{"name": "a", "resourceVersion": "3", "uid": "x"},
+				"status":   map[string]interface{}{"ready": true},
+			},
+			desired: map[string]interface{}{"metadata": map[string]interface{}{"name": "a"}},
+		},
+		{
+			name:     "reports changed scalar",
+			live:     map[string]interface{}{"spec": map[string]interface{}{"replicas": int64(1)}},
+			desired:  map[string]interface{}{"spec": map[string]interface{}{"replicas": int64(3)}},
+			expected: []string{"spec.replicas"},
+		},
+		{
+			name:     "reports added and removed fields",
+			live:     map[string]interface{}{"data": map[string]interface{}{"old": "1"}},
+			desired:  map[string]interface{}{"data": map[string]interface{}{"new": "1"}},
+			expected: []string{"data.new", "data.old"},
+		},
+		{
+			name: "reports list element changes by index",
+			live: map[string]interface{}{"spec": map[string]interface{}{"containers": []interface{}{
+				map[string]interface{}{"name": "app", "image": "app:v1"},
+			}}},
+			desired: map[string]interface{}{"spec": map[string]interface{}{"containers": []interface{}{
+				map[string]interface{}{"name": "app", "image": "app:v2"},
+			}}},
+			expected: []string{"spec.containers[0].image"},
+		},
+		{
+			name:     "reports resized list as a whole",
+			live:     map[string]interface{}{"spec": map[string]interface{}{"args": []interface{}{"a"}}},
+			desired:  map[string]interface{}{"spec": map[string]interface{}{"args": []interface{}{"a", "b"}}},
+			expected: []string{"spec.args"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fields := DiffFields(
+				&unstructured.Unstructured{Object: tt.live}, &unstructured.Unstructured{Object: tt.desired})
+			assert.Equal(t, tt.expected, fields)
+		})
+	}
+}
+
+func TestDiffFields_TruncatesLongDiffs(t *testing.T) {
+	live := map[string]interface{}{}
+	desired := map[string]interface{}{}
+	for i := 0; i < maxFieldsPerChange+5; i++ {
+		desired[string(rune('a'+i))] = "value"
+	}
+
+	fields := DiffFields(&unstructured.Unstructured{Object: live}, &unstructured.Unstructured{Object: desired})
+	require.Len(t, fields, maxFieldsPerChange+1)
+	assert.Equal(t, "... and 5 more", fields[maxFieldsPerChange])
+}
+
+func TestCompute(t *testing.T) {
+	scheme := k8sruntime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(scheme))
+	require.NoError(t, appsv1.AddToScheme(scheme))
+
+	liveConfig := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "changed"},
+		// removed はチャートから削除されたフィールドで､置き換えによって削除される
+		Data: map[string]string{"image": "app:v1", "removed": "value"},
+	}
+	unchanged := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "unchanged"},
+		Data:       map[string]string{"key": "value"},
+	}
+	worker := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "app-worker"},
+		Spec:       appsv1.DeploymentSpec{Replicas: ptr.To(int32(1))},
+	}
+	k8sClient := fake.NewClientBuilder().
+		WithScheme(scheme).
+		WithObjects(liveConfig, unchanged, worker).
+		WithInterceptorFuncs(dryruntest.ServerDryRun(t)).
+		Build()
+
+	desired := []*unstructured.Unstructured{
+		newConfigMap("changed", map[string]interface{}{"image": "app:v2"}),
+		newConfigMap("unchanged", map[string]interface{}{"key": "value"}),
+		newConfigMap("created", map[string]interface{}{"key": "value"}),
+	}
+	deleted := []corev1.ObjectReference{
+		{APIVersion: "apps/v1", Kind: "Deployment", Namespace: "default", Name: "app-worker"},
+		{APIVersion: "apps/v1", Kind: "Deployment", Namespace: "default", Name: "already-gone"},
+	}
+
+	changes, err := Compute(context.Background(), k8sClient, desired, deleted)
+	require.NoError(t, err)
+	assert.Equal(t, []tacokumogithubiov1alpha1.DryRunChange{
+		{Action: "Changed", APIVersion: "v1", Kind: "ConfigMap", Namespace: "default", Name: "changed",
+			Fields: []string{"data.image", "data.removed"}},
+		{Action: "Created", APIVersion: "v1", Kind: "ConfigMap", Namespace: "default", Name: "created"},
+		{Action: "Deleted", APIVersion: "apps/v1", Kind: "Deployment", Namespace: "default", Name: "app-worker"},
+	}, changes)
+	assert.Equal(t, "1 created, 1 changed, 1 deleted", Summarize(changes))
+
+	// クラスタ上のオブジェクトは変更されない
+	got := &corev1.ConfigMap{}
+	require.NoError(t, k8sClient.Get(context.Background(), client.ObjectKeyFromObject(liveConfig), got))
+	assert.Equal(t, "app:v1", got.Data["image"])
+	key := client.ObjectKey{Namespace: "default", Name: "created"}
+	err = k8sClient.Get(context.Background(), key, &corev1.ConfigMap{})
+	assert.Error(t, err)
+	require.NoError(t, k8sClient.Get(context.Background(), client.ObjectKeyFromObject(worker), &appsv1.Deployment{}))
+}
+
+func TestSetCondition(t *testing.T) {
+	var conditions []metav1.Condition
+
+	SetCondition(&conditions, 2, "1 created, 0 changed, 0 deleted", nil)
+	cond := meta.FindStatusCondition(conditions, tacokumogithubiov1alpha1.ConditionTypeDryRun)
+	require.NotNil(t, cond)
+	assert.Equal(t, metav1.ConditionTrue, cond.Status)
+	assert.Equal(t, "1 created, 0 changed, 0 deleted", cond.Message)
+
+	SetCondition(&conditions, 3, "", assert.AnError)
+	cond = meta.FindStatusCondition(conditions, tacokumogithubiov1alpha1.ConditionTypeDryRun)
+	require.NotNil(t, cond)
+	assert.Equal(t, metav1.ConditionFalse, cond.Status)
+	assert.Equal(t, tacokumogithubiov1alpha1.ReasonDryRunFailed, cond.Reason)
+	assert.Equal(t, int64(3), cond.ObservedGeneration)
+}
@@ -0,0 +1,32 @@
+// Package dryruntest はdry-runのテストで使用するヘルパーを提供する
+package dryruntest
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+)
+
+// ServerDryRun は作成と更新に DryRunAll が指定されていることを確認するインターセプタを返す
+// fake client は dry-run の作成と更新を保存しない
+func ServerDryRun(t *testing.T) interceptor.Funcs {
+	t.Helper()
+	return interceptor.Funcs{
+		Create: func(ctx context.Context, c client.WithWatch, obj client.Object, opts ...client.CreateOption) error {
+			createOpts := &client.CreateOptions{}
+			createOpts.ApplyOptions(opts)
+			assert.Equal(t, []string{metav1.DryRunAll}, createOpts.DryRun)
+			return c.Create(ctx, obj, opts...)
+		},
+		Update: func(ctx context.Context, c client.WithWatch, obj client.Object, opts ...client.UpdateOption) error {
+			updateOpts := &client.UpdateOptions{}
+			updateOpts.ApplyOptions(opts)
+			assert.Equal(t, []string{metav1.DryRunAll}, updateOpts.DryRun)
+			return c.Update(ctx, obj, opts...)
+		},
+	}
+}
@@ -0,0 +1,238 @@
+package gateway
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	tacokumogithubiov1alpha1 "github.com/tacokumo/portal-controller-kubernetes/api/v1alpha1"
+	"github.com/tacokumo/portal-controller-kubernetes/pkg/clustergateway"
+
+	"github.com/samber/lo"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// authProxyPort はoauth2-proxyがリクエストを受け付けるポート
+	authProxyPort = 4180
+
+	authProxyClientSecretKey = "client-secret"
+	authProxyCookieSecretKey = "cookie-secret"
+)
+
+var defaultAuthProxyScopes = []string{"openid", "email", "profile"}
+
+// authProxyName はGatewayの認証プロキシのDeployment､Service､Secretの名前を返す
+func authProxyName(gw *tacokumogithubiov1alpha1.Gateway) string {
+	return fmt.Sprintf("%s-auth-proxy", gw.Name)
+}
+
+// resolveClusterGateway は spec.authentication が参照するClusterGatewayを返す
+// 認証が不要な場合は nil を返す
+// Gatewayのnamespaceが spec.namespaceSelector に一致しない場合はエラーを返す
+func (m *Manager) resolveClusterGateway(
+	ctx context.Context,
+	gw *tacokumogithubiov1alpha1.Gateway,
+) (*tacokumogithubiov1alpha1.ClusterGateway, error) {
+	if gw.Spec.Authentication == nil {
+		return nil, nil
+	}
+	cg := &tacokumogithubiov1alpha1.ClusterGateway{}
+	if err := m.k8sClient.Get(ctx, client.ObjectKey{Name: gw.Spec.Authentication.ClusterGateway}, cg); err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil, fmt.Errorf("clustergateway %s not found", gw.Spec.Authentication.ClusterGateway)
+		}
+		return nil, err
+	}
+	// 許可されていないnamespaceにクライアントシークレットをコピーしない
+	allowed, err := clustergateway.AllowsNamespace(ctx, m.k8sClient, cg, gw.Namespace)
+	if err != nil {
+		return nil, err
+	}
+	if !allowed {
+		return nil, fmt.Errorf("namespace %s is not allowed to use clustergateway %s by its namespaceSelector",
+			gw.Namespace, cg.Name)
+	}
+	return cg, nil
+}
+
+// authProxySecretData は認証プロキシが使用するクライアントシークレットとCookieのシークレットを返す
+// ClusterGatewayのSecretは別のnamespaceにあるため､Gatewayのnamespaceにコピーして使用する
+func (m *Manager) authProxySecretData(
+	ctx context.Context,
+	gw *tacokumogithubiov1alpha1.Gateway,
+	cg *tacokumogithubiov1alpha1.ClusterGateway,
+) (map[string][]byte, error) {
+	clientSecret, err := clustergateway.ClientSecret(ctx, m.k8sClient, cg)
+	if err != nil {
+		return nil, err
+	}
+
+	// 認証済みのセッションを維持するため､Cookieのシークレットは作成済みの値を使い続ける
+	var cookieSecret []byte
+	existing := &corev1.Secret{}
+	key := client.ObjectKey{Namespace: gw.Namespace, Name: authProxyName(gw)}
+	if err := m.k8sClient.Get(ctx, key, existing); err != nil {
+		if !apierrors.IsNotFound(err) {
+			return nil, err
+		}
+	} else {
+		cookieSecret = existing.Data[authProxyCookieSecretKey]
+	}
+	if len(cookieSecret) == 0 {
+		b := make([]byte, 16)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		// oauth2-proxyは32バイトの文字列をそのままAESの鍵として使用する
+		cookieSecret = []byte(hex.EncodeToString(b))
+	}
+
+	return map[string][]byte{
+		authProxyClientSecretKey: clientSecret,
+		authProxyCookieSecretKey: cookieSecret,
+	}, nil
+}
+
+// renderAuthProxy はアプリケーションのServiceの前段でOIDCによる認証を行うoauth2-proxyのリソースを構築する
+// secure はGatewayがTLSを終端するかを示す
+func renderAuthProxy(
+	gw *tacokumogithubiov1alpha1.Gateway,
+	cg *tacokumogithubiov1alpha1.ClusterGateway,
+	secretData map[string][]byte,
+	secure bool,
+) ([]*unstructured.Unstructured, error) {
+	name := authProxyName(gw)
+	labels := lo.Assign(routeLabels(gw), authProxySelector(gw))
+	image := lo.CoalesceOrEmpty(cg.Spec.ProxyImage, tacokumogithubiov1alpha1.DefaultAuthProxyImage)
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: gw.Namespace, Name: name, Labels: labels},
+		Type:       corev1.SecretTypeOpaque,
+		Data:       secretData,
+	}
+
+	deployment := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Namespace: gw.Namespace, Name: name, Labels: labels},
+		Spec: appsv1.DeploymentSpec{
+			Replicas: ptr.To(int32(1)),
+			Selector: &metav1.LabelSelector{MatchLabels: authProxySelector(gw)},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: labels},
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{
+						{
+							Name:  "oauth2-proxy",
+							Image: image,
+							Args:  authProxyArgs(gw, cg, secure),
+							Env: []corev1.EnvVar{
+								secretEnv("OAUTH2_PROXY_CLIENT_SECRET", name, authProxyClientSecretKey),
+								secretEnv("OAUTH2_PROXY_COOKIE_SECRET", name, authProxyCookieSecretKey),
+							},
+							Ports: []corev1.ContainerPort{
+								{Name: "http", ContainerPort: authProxyPort},
+							},
+							ReadinessProbe: &corev1.Probe{
+								ProbeHandler: corev1.ProbeHandler{
+									HTTPGet: &corev1.HTTPGetAction{Path: "/ping", Port: intstr.FromString("http")},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	service := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Namespace: gw.Namespace, Name: name, Labels: labels},
+		Spec: corev1.ServiceSpec{
+			Selector: authProxySelector(gw),
+			Ports: []corev1.ServicePort{
+				{Name: "http", Port: authProxyPort, TargetPort: intstr.FromString("http")},
+			},
+		},
+	}
+
+	secretObj, err := toUnstructured(secret, corev1.SchemeGroupVersion.WithKind("Secret"))
+	if err != nil {
+		return nil, err
+	}
+	deploymentObj, err := toUnstructured(deployment, appsv1.SchemeGroupVersion.WithKind("Deployment"))
+	if err != nil {
+		return nil, err
+	}
+	serviceObj, err := toUnstructured(service, corev1.SchemeGroupVersion.WithKind("Service"))
+	if err != nil {
+		return nil, err
+	}
+	return []*unstructured.Unstructured{secretObj, deploymentObj, serviceObj}, nil
+}
+
+// authProxyArgs はoauth2-proxyの引数を構築する
+// 公開するポートごとに､パスに対応するアプリケーションのServiceをupstreamとして指定する
+func authProxyArgs(
+	gw *tacokumogithubiov1alpha1.Gateway,
+	cg *tacokumogithubiov1alpha1.ClusterGateway,
+	secure bool,
+) []string {
+	scopes := cg.Spec.OIDC.Scopes
+	if len(scopes) == 0 {
+		scopes = defaultAuthProxyScopes
+	}
+	args := []string{
+		"--provider=oidc",
+		fmt.Sprintf("--oidc-issuer-url=%s", cg.Spec.OIDC.IssuerURL),
+		fmt.Sprintf("--client-id=%s", cg.Spec.OIDC.ClientID),
+		fmt.Sprintf("--scope=%s", strings.Join(scopes, " ")),
+		fmt.Sprintf("--http-address=0.0.0.0:%d", authProxyPort),
+		"--reverse-proxy=true",
+		"--skip-provider-button=true",
+	}
+	if !secure {
+		// Secure属性のCookieはHTTPでは送信されず､ログインできなくなる
+		args = append(args, "--cookie-secure=false")
+	}
+	if len(cg.Spec.AllowedDomains) == 0 {
+		args = append(args, "--email-domain=*")
+	}
+	for _, domain := range cg.Spec.AllowedDomains {
+		args = append(args, fmt.Sprintf("--email-domain=%s", domain))
+	}
+	for _, group := range cg.Spec.AllowedGroups {
+		args = append(args, fmt.Sprintf("--allowed-group=%s", group))
+	}
+	for _, p := range gw.Spec.Ports {
+		args = append(args,
+			fmt.Sprintf("--upstream=http://%s.%s.svc:%d%s", serviceName(gw), gw.Namespace, p.Port, pathOf(p)))
+	}
+	return args
+}
+
+func authProxySelector(gw *tacokumogithubiov1alpha1.Gateway) map[string]string {
+	return map[string]string{
+		"app.kubernetes.io/name":     "oauth2-proxy",
+		"app.kubernetes.io/instance": gw.Name,
+	}
+}
+
+func secretEnv(name, secretName, key string) corev1.EnvVar {
+	return corev1.EnvVar{
+		Name: name,
+		ValueFrom: &corev1.EnvVarSource{
+			SecretKeyRef: &corev1.SecretKeySelector{
+				LocalObjectReference: corev1.LocalObjectReference{Name: secretName},
+				Key:                  key,
+			},
+		},
+	}
+}
@@ -0,0 +1,203 @@
+package gateway
+
+import (
+	"context"
+	"testing"
+
+	tacokumogithubiov1alpha1 "github.com/tacokumo/portal-controller-kubernetes/api/v1alpha1"
+
+	"github.com/go-logr/logr"
+	"github.com/samber/lo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func newTestClusterGateway() *tacokumogithubiov1alpha1.ClusterGateway {
+	return &tacokumogithubiov1alpha1.ClusterGateway{
+		ObjectMeta: metav1.ObjectMeta{Name: "intranet"},
+		Spec: tacokumogithubiov1alpha1.ClusterGatewaySpec{
+			OIDC: tacokumogithubiov1alpha1.ClusterGatewayOIDC{
+				IssuerURL: "https://accounts.example.com",
+				ClientID:  "tacokumo-intranet",
+				ClientSecretRef: tacokumogithubiov1alpha1.SecretKeyReference{
+					NamespacedName: tacokumogithubiov1alpha1.NamespacedName{
+						Namespace: "tacokumo-system", Name: "intranet-oidc",
+					},
+				},
+			},
+			AllowedGroups:  []string{"engineering"},
+			AllowedDomains: []string{"example.com"},
+			NamespaceSelector: &metav1.LabelSelector{
+				MatchLabels: map[string]string{"tacokumo.github.io/intranet": "true"},
+			},
+		},
+	}
+}
+
+func newTestNamespace(labels map[string]string) *corev1.Namespace {
+	return &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default", Labels: labels}}
+}
+
+func newTestClientSecret() *corev1.Secret {
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "tacokumo-system", Name: "intranet-oidc"},
+		Data:       map[string][]byte{"client-secret": []byte("s3cr3t")},
+	}
+}
+
+func TestManager_Reconcile_AuthProxy(t *testing.T) {
+	gw := newTestGateway(func(gw *tacokumogithubiov1alpha1.Gateway) {
+		gw.Spec.Host = "test-app.example.com"
+		gw.Spec.IngressClassName = ptr.To("nginx")
+		gw.Spec.Authentication = &tacokumogithubiov1alpha1.GatewayAuthentication{ClusterGateway: "intranet"}
+	})
+	k8sClient := fake.NewClientBuilder().
+		WithScheme(newTestScheme(t)).
+		WithObjects(
+			newTestApplication(), gw, newTestClusterGateway(), newTestClientSecret(),
+			newTestNamespace(map[string]string{"tacokumo.github.io/intranet": "true"}),
+		).
+		WithStatusSubresource(gw).
+		Build()
+	m := NewManager(logr.Discard(), k8sClient)
+
+	require.NoError(t, m.Reconcile(context.Background(), gw))
+
+	key := client.ObjectKey{Namespace: "default", Name: "test-app-auth-proxy"}
+	secret := &corev1.Secret{}
+	require.NoError(t, k8sClient.Get(context.Background(), key, secret))
+	assert.Equal(t, []byte("s3cr3t"), secret.Data["client-secret"])
+	cookieSecret := secret.Data["cookie-secret"]
+	assert.Len(t, cookieSecret, 32)
+
+	deployment := &appsv1.Deployment{}
+	require.NoError(t, k8sClient.Get(context.Background(), key, deployment))
+	container := deployment.Spec.Template.Spec.Containers[0]
+	assert.Equal(t, tacokumogithubiov1alpha1.DefaultAuthProxyImage, container.Image)
+	assert.Contains(t, container.Args, "--oidc-issuer-url=https://accounts.example.com")
+	assert.Contains(t, container.Args, "--client-id=tacokumo-intranet")
+	assert.Contains(t, container.Args, "--allowed-group=engineering")
+	assert.Contains(t, container.Args, "--email-domain=example.com")
+	assert.Contains(t, container.Args, "--upstream=http://test-app-production.default.svc:8080/")
+	assert.Contains(t, container.Args, "--upstream=http://test-app-production.default.svc:9090/metrics")
+	// TLSを終端しないGatewayではSecure属性なしのCookieでセッションを維持する
+	assert.Contains(t, container.Args, "--cookie-secure=false")
+	require.NoError(t, k8sClient.Get(context.Background(), key, &corev1.Service{}))
+
+	// 全てのパスが認証プロキシに転送される
+	ingress := &networkingv1.Ingress{}
+	ingressKey := client.ObjectKey{Namespace: "default", Name: "test-app"}
+	require.NoError(t, k8sClient.Get(context.Background(), ingressKey, ingress))
+	for _, path := range ingress.Spec.Rules[0].HTTP.Paths {
+		assert.Equal(t, "test-app-auth-proxy", path.Backend.Service.Name)
+		assert.Equal(t, int32(4180), path.Backend.Service.Port.Number)
+	}
+	assert.Equal(t, "intranet", gw.Status.ProtectedBy)
+	assert.Len(t, gw.Status.Routes, 4)
+
+	// 再度reconcileしてもCookieのシークレットは変わらない
+	require.NoError(t, m.Reconcile(context.Background(), gw))
+	require.NoError(t, k8sClient.Get(context.Background(), key, secret))
+	assert.Equal(t, cookieSecret, secret.Data["cookie-secret"])
+
+	// 認証が不要になった場合は認証プロキシを削除する
+	gw.Spec.Authentication = nil
+	require.NoError(t, m.Reconcile(context.Background(), gw))
+	err := k8sClient.Get(context.Background(), key, &appsv1.Deployment{})
+	assert.True(t, apierrors.IsNotFound(err))
+	err = k8sClient.Get(context.Background(), key, &corev1.Secret{})
+	assert.True(t, apierrors.IsNotFound(err))
+	require.NoError(t, k8sClient.Get(context.Background(), ingressKey, ingress))
+	assert.Equal(t, "test-app-production", ingress.Spec.Rules[0].HTTP.Paths[0].Backend.Service.Name)
+	assert.Empty(t, gw.Status.ProtectedBy)
+	assert.Len(t, gw.Status.Routes, 1)
+}
+
+func TestManager_Reconcile_AuthProxyError(t *testing.T) {
+	tests := []struct {
+		name            string
+		objects         []client.Object
+		namespaceLabels map[string]string
+		expectMessage   string
+	}{
+		{
+			name:          "clustergateway not found",
+			expectMessage: "clustergateway intranet not found",
+		},
+		{
+			name:          "client secret not found",
+			objects:       []client.Object{newTestClusterGateway()},
+			expectMessage: "client secret tacokumo-system/intranet-oidc of clustergateway intranet not found",
+		},
+		{
+			name: "namespace does not match the namespace selector",
+			objects: []client.Object{
+				newTestClusterGateway(), newTestClientSecret(),
+			},
+			namespaceLabels: map[string]string{"tacokumo.github.io/intranet": "false"},
+			expectMessage:   "namespace default is not allowed to use clustergateway intranet by its namespaceSelector",
+		},
+		{
+			name: "namespace selector is not set",
+			objects: []client.Object{
+				func() client.Object {
+					cg := newTestClusterGateway()
+					cg.Spec.NamespaceSelector = nil
+					return cg
+				}(),
+				newTestClientSecret(),
+			},
+			expectMessage: "namespace default is not allowed to use clustergateway intranet by its namespaceSelector",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gw := newTestGateway(func(gw *tacokumogithubiov1alpha1.Gateway) {
+				gw.Spec.Authentication = &tacokumogithubiov1alpha1.GatewayAuthentication{ClusterGateway: "intranet"}
+			})
+			objects := append([]client.Object{
+				newTestApplication(), gw,
+				newTestNamespace(lo.CoalesceMapOrEmpty(tt.namespaceLabels,
+					map[string]string{"tacokumo.github.io/intranet": "true"})),
+			}, tt.objects...)
+			k8sClient := fake.NewClientBuilder().
+				WithScheme(newTestScheme(t)).
+				WithObjects(objects...).
+				WithStatusSubresource(gw).
+				Build()
+			m := NewManager(logr.Discard(), k8sClient)
+
+			require.Error(t, m.Reconcile(context.Background(), gw))
+
+			// 認証なしでアプリケーションを公開しない
+			key := client.ObjectKey{Namespace: "default", Name: "test-app"}
+			err := k8sClient.Get(context.Background(), key, &networkingv1.Ingress{})
+			assert.True(t, apierrors.IsNotFound(err))
+			// クライアントシークレットをGatewayのnamespaceにコピーしない
+			proxyKey := client.ObjectKey{Namespace: "default", Name: "test-app-auth-proxy"}
+			err = k8sClient.Get(context.Background(), proxyKey, &corev1.Secret{})
+			assert.True(t, apierrors.IsNotFound(err))
+			cond := meta.FindStatusCondition(gw.Status.Conditions, tacokumogithubiov1alpha1.ConditionTypeReady)
+			require.NotNil(t, cond)
+			assert.Equal(t, metav1.ConditionFalse, cond.Status)
+			assert.Equal(t, tt.expectMessage, cond.Message)
+		})
+	}
+}
+
+func TestAuthProxyArgs_CookieSecure(t *testing.T) {
+	gw := newTestGateway(func(*tacokumogithubiov1alpha1.Gateway) {})
+
+	assert.NotContains(t, authProxyArgs(gw, newTestClusterGateway(), true), "--cookie-secure=false")
+	assert.Contains(t, authProxyArgs(gw, newTestClusterGateway(), false), "--cookie-secure=false")
+}
@@ -0,0 +1,214 @@
+package gateway
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	tacokumogithubiov1alpha1 "github.com/tacokumo/portal-controller-kubernetes/api/v1alpha1"
+	"github.com/tacokumo/portal-controller-kubernetes/pkg/dns"
+
+	"github.com/samber/lo"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// reconcileDNSRecords は fqdn が address を指すレコードをDNSプロバイダに登録し､不要になったレコードを削除する
+// fqdn が空の場合は､登録済みのレコードを全て削除する
+// 登録済みのレコードは gw.Status.DNSRecords と比較し､変わったものだけをプロバイダに登録する
+// プロバイダの失敗は DNSReady Conditionに記録される
+func (m *Manager) reconcileDNSRecords(
+	ctx context.Context,
+	gw *tacokumogithubiov1alpha1.Gateway,
+	fqdn string,
+	address string,
+) error {
+	if fqdn == "" && len(gw.Status.DNSRecords) == 0 {
+		meta.RemoveStatusCondition(&gw.Status.Conditions, tacokumogithubiov1alpha1.ConditionTypeDNSReady)
+		return nil
+	}
+	if m.dnsProvider == nil {
+		// レコードを登録したプロバイダが設定されていないため､削除できない
+		m.logger.Info("dns provider is not configured, leaving records", "records", gw.Status.DNSRecords)
+		gw.Status.DNSRecords = nil
+		meta.RemoveStatusCondition(&gw.Status.Conditions, tacokumogithubiov1alpha1.ConditionTypeDNSReady)
+		return nil
+	}
+
+	var desired []dns.Record
+	if fqdn != "" {
+		owner, err := m.findFQDNOwner(ctx, gw, fqdn)
+		if err != nil {
+			return err
+		}
+		if owner != "" {
+			message := fmt.Sprintf("fqdn %s is already used by gateway %s", fqdn, owner)
+			setDNSReadyCondition(gw, metav1.ConditionFalse, tacokumogithubiov1alpha1.ReasonFQDNConflict, message)
+			return fmt.Errorf("%s", message)
+		}
+		if address == "" {
+			setDNSReadyCondition(gw, metav1.ConditionFalse, tacokumogithubiov1alpha1.ReasonWaitingForAddress,
+				fmt.Sprintf("waiting for the load balancer address to register %s", fqdn))
+			return nil
+		}
+		records, err := m.desiredDNSRecords(ctx, gw, fqdn, address)
+		if err != nil {
+			return err
+		}
+		desired = records
+	}
+
+	for _, record := range desired {
+		// プロバイダのレート制限を避けるため､登録済みのレコードと同じ場合は登録しない
+		if lo.ContainsBy(gw.Status.DNSRecords, func(s tacokumogithubiov1alpha1.DNSRecordStatus) bool {
+			return equality.Semantic.DeepEqual(s, recordStatusOf(record))
+		}) {
+			continue
+		}
+		if err := m.dnsProvider.UpsertRecord(ctx, record); err != nil {
+			return m.dnsProviderError(gw, fmt.Errorf("failed to register dns record %s: %w", record.Name, err))
+		}
+	}
+
+	desiredKeys := lo.Map(desired, func(r dns.Record, _ int) string { return r.Key() })
+	remaining := lo.Map(desired, func(r dns.Record, _ int) tacokumogithubiov1alpha1.DNSRecordStatus {
+		return recordStatusOf(r)
+	})
+	stale := lo.Filter(gw.Status.DNSRecords, func(s tacokumogithubiov1alpha1.DNSRecordStatus, _ int) bool {
+		return !lo.Contains(desiredKeys, recordOf(s).Key())
+	})
+	stale = lo.UniqBy(stale, func(s tacokumogithubiov1alpha1.DNSRecordStatus) string { return recordOf(s).Key() })
+	for i, status := range stale {
+		record := recordOf(status)
+		if err := m.dnsProvider.DeleteRecord(ctx, record); err != nil {
+			// 削除できなかったレコードと未処理のレコードは次回に再度削除する
+			gw.Status.DNSRecords = append(remaining, stale[i:]...)
+			return m.dnsProviderError(gw, fmt.Errorf("failed to delete dns record %s: %w", record.Name, err))
+		}
+		m.logger.Info("deleted dns record", "name", record.Name, "type", record.Type)
+	}
+	gw.Status.DNSRecords = remaining
+
+	if fqdn == "" {
+		meta.RemoveStatusCondition(&gw.Status.Conditions, tacokumogithubiov1alpha1.ConditionTypeDNSReady)
+		return nil
+	}
+	setDNSReadyCondition(gw, metav1.ConditionTrue, tacokumogithubiov1alpha1.ReasonDNSRecordsSynced,
+		fmt.Sprintf("%s points to %s", fqdn, address))
+	return nil
+}
+
+// desiredDNSRecords は fqdn を address に向けるために登録するレコードを返す
+// ClusterMigrationの対象の場合は､クラスタ固有のホスト名を address に向け､
+// fqdn からそのホスト名への重み付きレコードを登録する
+func (m *Manager) desiredDNSRecords(
+	ctx context.Context,
+	gw *tacokumogithubiov1alpha1.Gateway,
+	fqdn string,
+	address string,
+) ([]dns.Record, error) {
+	migration, err := m.findClusterMigration(ctx, gw)
+	if err != nil {
+		return nil, err
+	}
+	if migration == nil {
+		return []dns.Record{dns.RecordFor(fqdn, address)}, nil
+	}
+
+	clusterFQDN := dns.ClusterFQDN(fqdn, migration.Spec.ClusterName)
+	weight := ptr.Deref(migration.Status.CurrentWeight, migration.Spec.InitialWeight)
+	return []dns.Record{
+		dns.RecordFor(clusterFQDN, address),
+		dns.WeightedRecord(fqdn, clusterFQDN, migration.Spec.ClusterName, int64(weight)),
+	}, nil
+}
+
+// findClusterMigration はGatewayを対象とするClusterMigrationを返す
+// 複数存在する場合は名前順で最初のものを使用する
+func (m *Manager) findClusterMigration(
+	ctx context.Context,
+	gw *tacokumogithubiov1alpha1.Gateway,
+) (*tacokumogithubiov1alpha1.ClusterMigration, error) {
+	migrations := &tacokumogithubiov1alpha1.ClusterMigrationList{}
+	if err := m.k8sClient.List(ctx, migrations, client.InNamespace(gw.Namespace)); err != nil {
+		return nil, err
+	}
+	targets := lo.Filter(migrations.Items, func(cm tacokumogithubiov1alpha1.ClusterMigration, _ int) bool {
+		return cm.Spec.Gateway == gw.Name
+	})
+	if len(targets) == 0 {
+		return nil, nil
+	}
+	sort.Slice(targets, func(i, j int) bool {
+		return targets[i].Name < targets[j].Name
+	})
+	return &targets[0], nil
+}
+
+// findFQDNOwner は fqdn のレコードを既に登録している他のGatewayを `<namespace>/<name>` の形式で返す
+// 存在しない場合は空文字列を返す
+func (m *Manager) findFQDNOwner(
+	ctx context.Context,
+	gw *tacokumogithubiov1alpha1.Gateway,
+	fqdn string,
+) (string, error) {
+	gateways := &tacokumogithubiov1alpha1.GatewayList{}
+	if err := m.k8sClient.List(ctx, gateways); err != nil {
+		return "", err
+	}
+	for _, other := range gateways.Items {
+		if other.UID == gw.UID {
+			continue
+		}
+		if lo.ContainsBy(other.Status.DNSRecords, func(r tacokumogithubiov1alpha1.DNSRecordStatus) bool {
+			return r.Name == fqdn
+		}) {
+			return fmt.Sprintf("%s/%s", other.Namespace, other.Name), nil
+		}
+	}
+	return "", nil
+}
+
+func (m *Manager) dnsProviderError(gw *tacokumogithubiov1alpha1.Gateway, err error) error {
+	setDNSReadyCondition(gw, metav1.ConditionFalse, tacokumogithubiov1alpha1.ReasonDNSProviderError, err.Error())
+	return err
+}
+
+// tenantOf はApplicationが属するテナントを返す
+func tenantOf(app *tacokumogithubiov1alpha1.Application) string {
+	return lo.CoalesceOrEmpty(app.Labels[tacokumogithubiov1alpha1.TenantLabelKey], app.Namespace)
+}
+
+func setDNSReadyCondition(gw *tacokumogithubiov1alpha1.Gateway, status metav1.ConditionStatus, reason, message string) {
+	meta.SetStatusCondition(&gw.Status.Conditions, metav1.Condition{
+		Type:               tacokumogithubiov1alpha1.ConditionTypeDNSReady,
+		Status:             status,
+		ObservedGeneration: gw.Generation,
+		Reason:             reason,
+		Message:            message,
+	})
+}
+
+func recordStatusOf(record dns.Record) tacokumogithubiov1alpha1.DNSRecordStatus {
+	return tacokumogithubiov1alpha1.DNSRecordStatus{
+		Name:          record.Name,
+		Type:          record.Type,
+		Targets:       record.Targets,
+		SetIdentifier: record.SetIdentifier,
+		Weight:        record.Weight,
+	}
+}
+
+func recordOf(status tacokumogithubiov1alpha1.DNSRecordStatus) dns.Record {
+	return dns.Record{
+		Name:          status.Name,
+		Type:          status.Type,
+		Targets:       status.Targets,
+		TTL:           dns.DefaultTTL,
+		SetIdentifier: status.SetIdentifier,
+		Weight:        status.Weight,
+	}
+}
@@ -0,0 +1,426 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	tacokumogithubiov1alpha1 "github.com/tacokumo/portal-controller-kubernetes/api/v1alpha1"
+	"github.com/tacokumo/portal-controller-kubernetes/pkg/dns"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	networkingv1 "k8s.io/api/networking/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+// failingProvider は全ての操作に失敗するDNSプロバイダ
+type failingProvider struct {
+	dns.MemoryProvider
+}
+
+func (p *failingProvider) UpsertRecord(context.Context, dns.Record) error {
+	return errors.New("rate limited")
+}
+
+// deleteFailingProvider は failName のレコードの削除に失敗するDNSプロバイダ
+type deleteFailingProvider struct {
+	*dns.MemoryProvider
+	failName string
+}
+
+func (p *deleteFailingProvider) DeleteRecord(ctx context.Context, record dns.Record) error {
+	if record.Name == p.failName {
+		return errors.New("rate limited")
+	}
+	return p.MemoryProvider.DeleteRecord(ctx, record)
+}
+
+// countingProvider はレコードの登録回数を数えるDNSプロバイダ
+type countingProvider struct {
+	*dns.MemoryProvider
+	upserts int
+}
+
+func (p *countingProvider) UpsertRecord(ctx context.Context, record dns.Record) error {
+	p.upserts++
+	return p.MemoryProvider.UpsertRecord(ctx, record)
+}
+
+func newTestLoadBalancedIngress(address string) *networkingv1.Ingress {
+	return &networkingv1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "test-app"},
+		Status: networkingv1.IngressStatus{
+			LoadBalancer: networkingv1.IngressLoadBalancerStatus{
+				Ingress: []networkingv1.IngressLoadBalancerIngress{{Hostname: address}},
+			},
+		},
+	}
+}
+
+func TestManager_Reconcile_DNS(t *testing.T) {
+	tests := []struct {
+		name             string
+		tenant           string
+		dnsName          string
+		portalBaseDomain string
+		address          string
+		expectHost       string
+		expectRecords    []dns.Record
+		expectDNS        metav1.ConditionStatus
+		expectReason     string
+	}{
+		{
+			name:       "registers the tenant based fqdn",
+			tenant:     "team-a",
+			address:    "lb.example.net",
+			expectHost: "test-app.team-a.app.tacokumo.dev",
+			expectRecords: []dns.Record{
+				{
+					Name: "test-app.team-a.app.tacokumo.dev", Type: dns.RecordTypeCNAME,
+					Targets: []string{"lb.example.net"}, TTL: dns.DefaultTTL,
+				},
+			},
+			expectDNS:    metav1.ConditionTrue,
+			expectReason: tacokumogithubiov1alpha1.ReasonDNSRecordsSynced,
+		},
+		{
+			name:       "uses the namespace as tenant and dnsName as the first label",
+			dnsName:    "staging-app",
+			address:    "lb.example.net",
+			expectHost: "staging-app.default.app.tacokumo.dev",
+			expectRecords: []dns.Record{
+				{
+					Name: "staging-app.default.app.tacokumo.dev", Type: dns.RecordTypeCNAME,
+					Targets: []string{"lb.example.net"}, TTL: dns.DefaultTTL,
+				},
+			},
+			expectDNS:    metav1.ConditionTrue,
+			expectReason: tacokumogithubiov1alpha1.ReasonDNSRecordsSynced,
+		},
+		{
+			name:             "prefers the base domain of the Portal over the flag",
+			tenant:           "team-a",
+			portalBaseDomain: "example.org",
+			address:          "lb.example.net",
+			expectHost:       "test-app.team-a.app.example.org",
+			expectRecords: []dns.Record{
+				{Name: "test-app.team-a.app.example.org", Type: dns.RecordTypeCNAME,
+					Targets: []string{"lb.example.net"}, TTL: dns.DefaultTTL},
+			},
+			expectDNS:    metav1.ConditionTrue,
+			expectReason: tacokumogithubiov1alpha1.ReasonDNSRecordsSynced,
+		},
+		{
+			name:         "waits for the load balancer address",
+			tenant:       "team-a",
+			expectHost:   "test-app.team-a.app.tacokumo.dev",
+			expectDNS:    metav1.ConditionFalse,
+			expectReason: tacokumogithubiov1alpha1.ReasonWaitingForAddress,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApplication()
+			if tt.tenant != "" {
+				app.Labels = map[string]string{tacokumogithubiov1alpha1.TenantLabelKey: tt.tenant}
+			}
+			gw := newTestGateway(func(gw *tacokumogithubiov1alpha1.Gateway) {
+				gw.Spec.IngressClassName = ptr.To("nginx")
+				gw.Spec.DNSName = tt.dnsName
+			})
+			objects := []client.Object{app, gw}
+			if tt.address != "" {
+				objects = append(objects, newTestLoadBalancedIngress(tt.address))
+			}
+			if tt.portalBaseDomain != "" {
+				objects = append(objects, &tacokumogithubiov1alpha1.Portal{
+					ObjectMeta: metav1.ObjectMeta{Name: "portal"},
+					Spec:       tacokumogithubiov1alpha1.PortalSpec{BaseDomain: tt.portalBaseDomain},
+				})
+			}
+			k8sClient := fake.NewClientBuilder().
+				WithScheme(newTestScheme(t)).
+				WithObjects(objects...).
+				WithStatusSubresource(gw, &networkingv1.Ingress{}).
+				Build()
+			provider := dns.NewMemoryProvider()
+			m := NewManager(logr.Discard(), k8sClient).WithDNS(provider, "tacokumo.dev")
+
+			require.NoError(t, m.Reconcile(context.Background(), gw))
+
+			assert.True(t, controllerutil.ContainsFinalizer(gw, tacokumogithubiov1alpha1.GatewayDNSFinalizer))
+			ingress := &networkingv1.Ingress{}
+			key := client.ObjectKey{Namespace: "default", Name: "test-app"}
+			require.NoError(t, k8sClient.Get(context.Background(), key, ingress))
+			assert.Equal(t, tt.expectHost, ingress.Spec.Rules[0].Host)
+			assert.Equal(t, tt.expectHost, gw.Status.Host)
+
+			records, err := provider.ListRecords(context.Background(), tt.expectHost)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectRecords, records)
+			assert.Len(t, gw.Status.DNSRecords, len(tt.expectRecords))
+
+			cond := meta.FindStatusCondition(gw.Status.Conditions, tacokumogithubiov1alpha1.ConditionTypeDNSReady)
+			require.NotNil(t, cond)
+			assert.Equal(t, tt.expectDNS, cond.Status)
+			assert.Equal(t, tt.expectReason, cond.Reason)
+		})
+	}
+}
+
+func TestManager_Reconcile_DNSSkipsUnchangedRecords(t *testing.T) {
+	app := newTestApplication()
+	gw := newTestGateway(func(gw *tacokumogithubiov1alpha1.Gateway) {
+		gw.Spec.IngressClassName = ptr.To("nginx")
+	})
+	ingress := newTestLoadBalancedIngress("lb.example.net")
+	k8sClient := fake.NewClientBuilder().
+		WithScheme(newTestScheme(t)).
+		WithObjects(app, gw, ingress).
+		WithStatusSubresource(gw, &networkingv1.Ingress{}).
+		Build()
+	provider := &countingProvider{MemoryProvider: dns.NewMemoryProvider()}
+	m := NewManager(logr.Discard(), k8sClient).WithDNS(provider, "tacokumo.dev")
+
+	require.NoError(t, m.Reconcile(context.Background(), gw))
+	require.NoError(t, m.Reconcile(context.Background(), gw))
+	assert.Equal(t, 1, provider.upserts, "unchanged records must not be registered again")
+
+	require.NoError(t, k8sClient.Get(context.Background(), client.ObjectKeyFromObject(ingress), ingress))
+	ingress.Status.LoadBalancer.Ingress[0].Hostname = "lb2.example.net"
+	require.NoError(t, k8sClient.Status().Update(context.Background(), ingress))
+
+	require.NoError(t, m.Reconcile(context.Background(), gw))
+	assert.Equal(t, 2, provider.upserts, "changed records must be registered")
+	records, err := provider.ListRecords(context.Background(), gw.Status.Host)
+	require.NoError(t, err)
+	require.Len(t, records, 1)
+	assert.Equal(t, []string{"lb2.example.net"}, records[0].Targets)
+}
+
+func TestManager_Reconcile_DNSError(t *testing.T) {
+	tests := []struct {
+		name         string
+		provider     dns.Provider
+		others       []client.Object
+		expectReason string
+	}{
+		{
+			name:         "reports provider failures",
+			provider:     &failingProvider{MemoryProvider: *dns.NewMemoryProvider()},
+			expectReason: tacokumogithubiov1alpha1.ReasonDNSProviderError,
+		},
+		{
+			name:     "reports fqdn used by another gateway",
+			provider: dns.NewMemoryProvider(),
+			others: []client.Object{
+				&tacokumogithubiov1alpha1.Gateway{
+					ObjectMeta: metav1.ObjectMeta{Namespace: "other", Name: "test-app", UID: "other-uid"},
+					Status: tacokumogithubiov1alpha1.GatewayStatus{
+						DNSRecords: []tacokumogithubiov1alpha1.DNSRecordStatus{
+							{
+								Name: "test-app.default.app.tacokumo.dev", Type: dns.RecordTypeA,
+								Targets: []string{"203.0.113.20"},
+							},
+						},
+					},
+				},
+			},
+			expectReason: tacokumogithubiov1alpha1.ReasonFQDNConflict,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gw := newTestGateway(func(gw *tacokumogithubiov1alpha1.Gateway) {
+				gw.Spec.IngressClassName = ptr.To("nginx")
+			})
+			objects := append(
+				[]client.Object{newTestApplication(), gw, newTestLoadBalancedIngress("lb.example.net")},
+				tt.others...,
+			)
+			k8sClient := fake.NewClientBuilder().
+				WithScheme(newTestScheme(t)).
+				WithObjects(objects...).
+				WithStatusSubresource(gw, &networkingv1.Ingress{}).
+				Build()
+			m := NewManager(logr.Discard(), k8sClient).WithDNS(tt.provider, "tacokumo.dev")
+
+			require.Error(t, m.Reconcile(context.Background(), gw))
+
+			stored := &tacokumogithubiov1alpha1.Gateway{}
+			require.NoError(t, k8sClient.Get(context.Background(), client.ObjectKeyFromObject(gw), stored))
+			assert.Empty(t, stored.Status.DNSRecords)
+			cond := meta.FindStatusCondition(stored.Status.Conditions, tacokumogithubiov1alpha1.ConditionTypeDNSReady)
+			require.NotNil(t, cond)
+			assert.Equal(t, metav1.ConditionFalse, cond.Status)
+			assert.Equal(t, tt.expectReason, cond.Reason)
+			ready := meta.FindStatusCondition(stored.Status.Conditions, tacokumogithubiov1alpha1.ConditionTypeReady)
+			require.NotNil(t, ready)
+			assert.Equal(t, metav1.ConditionFalse, ready.Status)
+		})
+	}
+}
+
+func TestManager_Reconcile_DNSCleanup(t *testing.T) {
+	record := dns.Record{
+		Name: "test-app.default.app.tacokumo.dev", Type: dns.RecordTypeCNAME,
+		Targets: []string{"lb.example.net"}, TTL: dns.DefaultTTL,
+	}
+	tests := []struct {
+		name   string
+		mutate func(gw *tacokumogithubiov1alpha1.Gateway)
+	}{
+		{
+			name: "deletes records when spec.host is set",
+			mutate: func(gw *tacokumogithubiov1alpha1.Gateway) {
+				gw.Spec.Host = "www.example.com"
+			},
+		},
+		{
+			name: "deletes records and removes finalizer on deletion",
+			mutate: func(gw *tacokumogithubiov1alpha1.Gateway) {
+				gw.DeletionTimestamp = ptr.To(metav1.Now())
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gw := newTestGateway(func(gw *tacokumogithubiov1alpha1.Gateway) {
+				gw.Finalizers = []string{tacokumogithubiov1alpha1.GatewayDNSFinalizer}
+				gw.Spec.IngressClassName = ptr.To("nginx")
+				gw.Status.DNSRecords = []tacokumogithubiov1alpha1.DNSRecordStatus{recordStatusOf(record)}
+				tt.mutate(gw)
+			})
+			k8sClient := fake.NewClientBuilder().
+				WithScheme(newTestScheme(t)).
+				WithObjects(newTestApplication(), gw, newTestLoadBalancedIngress("lb.example.net")).
+				WithStatusSubresource(gw, &networkingv1.Ingress{}).
+				Build()
+			provider := dns.NewMemoryProvider()
+			require.NoError(t, provider.UpsertRecord(context.Background(), record))
+			m := NewManager(logr.Discard(), k8sClient).WithDNS(provider, "tacokumo.dev")
+
+			require.NoError(t, m.Reconcile(context.Background(), gw))
+
+			records, err := provider.ListRecords(context.Background(), record.Name)
+			require.NoError(t, err)
+			assert.Empty(t, records)
+			if !gw.DeletionTimestamp.IsZero() {
+				// finalizerが外れるとGatewayは削除される
+				err := k8sClient.Get(
+					context.Background(), client.ObjectKeyFromObject(gw), &tacokumogithubiov1alpha1.Gateway{})
+				assert.True(t, apierrors.IsNotFound(err))
+				return
+			}
+			assert.Empty(t, gw.Status.DNSRecords)
+			assert.Nil(t,
+				meta.FindStatusCondition(gw.Status.Conditions, tacokumogithubiov1alpha1.ConditionTypeDNSReady))
+		})
+	}
+}
+
+func TestManager_Reconcile_DNSDeleteError(t *testing.T) {
+	desired := dns.Record{
+		Name: "test-app.default.app.tacokumo.dev", Type: dns.RecordTypeCNAME,
+		Targets: []string{"lb.example.net"}, TTL: dns.DefaultTTL,
+	}
+	deleted := dns.Record{Name: "old.default.app.tacokumo.dev", Type: dns.RecordTypeA, Targets: []string{"203.0.113.10"}}
+	failed := dns.Record{Name: "stuck.default.app.tacokumo.dev", Type: dns.RecordTypeA, Targets: []string{"203.0.113.20"}}
+	pending := dns.Record{Name: "next.default.app.tacokumo.dev", Type: dns.RecordTypeA, Targets: []string{"203.0.113.30"}}
+
+	gw := newTestGateway(func(gw *tacokumogithubiov1alpha1.Gateway) {
+		gw.Spec.IngressClassName = ptr.To("nginx")
+		gw.Status.DNSRecords = []tacokumogithubiov1alpha1.DNSRecordStatus{
+			recordStatusOf(deleted),
+			recordStatusOf(desired),
+			recordStatusOf(failed),
+			recordStatusOf(desired),
+			recordStatusOf(pending),
+			recordStatusOf(failed),
+		}
+	})
+	k8sClient := fake.NewClientBuilder().
+		WithScheme(newTestScheme(t)).
+		WithObjects(newTestApplication(), gw, newTestLoadBalancedIngress("lb.example.net")).
+		WithStatusSubresource(gw, &networkingv1.Ingress{}).
+		Build()
+	provider := &deleteFailingProvider{MemoryProvider: dns.NewMemoryProvider(), failName: failed.Name}
+	m := NewManager(logr.Discard(), k8sClient).WithDNS(provider, "tacokumo.dev")
+
+	require.Error(t, m.Reconcile(context.Background(), gw))
+
+	// 削除済みのレコードは含めず､各レコードを一度だけ保持する
+	assert.Equal(t, []tacokumogithubiov1alpha1.DNSRecordStatus{
+		recordStatusOf(desired),
+		recordStatusOf(failed),
+		recordStatusOf(pending),
+	}, gw.Status.DNSRecords)
+	cond := meta.FindStatusCondition(gw.Status.Conditions, tacokumogithubiov1alpha1.ConditionTypeDNSReady)
+	require.NotNil(t, cond)
+	assert.Equal(t, tacokumogithubiov1alpha1.ReasonDNSProviderError, cond.Reason)
+}
+
+func TestManager_Reconcile_DNSClusterMigration(t *testing.T) {
+	app := newTestApplication()
+	app.Labels = map[string]string{tacokumogithubiov1alpha1.TenantLabelKey: "team-a"}
+	plain := dns.RecordFor("test-app.team-a.app.tacokumo.dev", "lb.example.net")
+	gw := newTestGateway(func(gw *tacokumogithubiov1alpha1.Gateway) {
+		gw.Finalizers = []string{tacokumogithubiov1alpha1.GatewayDNSFinalizer}
+		gw.Spec.IngressClassName = ptr.To("nginx")
+		gw.Status.DNSRecords = []tacokumogithubiov1alpha1.DNSRecordStatus{recordStatusOf(plain)}
+	})
+	migration := &tacokumogithubiov1alpha1.ClusterMigration{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "migrate-to-b"},
+		Spec: tacokumogithubiov1alpha1.ClusterMigrationSpec{
+			Gateway:     "test-app",
+			ClusterName: "cluster-b",
+			Steps:       []int32{50, 100},
+		},
+		Status: tacokumogithubiov1alpha1.ClusterMigrationStatus{CurrentWeight: ptr.To(int32(50))},
+	}
+	k8sClient := fake.NewClientBuilder().
+		WithScheme(newTestScheme(t)).
+		WithObjects(app, gw, migration, newTestLoadBalancedIngress("lb.example.net")).
+		WithStatusSubresource(gw, migration, &networkingv1.Ingress{}).
+		Build()
+	provider := dns.NewMemoryProvider()
+	require.NoError(t, provider.UpsertRecord(context.Background(), plain))
+	m := NewManager(logr.Discard(), k8sClient).WithDNS(provider, "tacokumo.dev")
+
+	require.NoError(t, m.Reconcile(context.Background(), gw))
+
+	// クラスタ固有のホスト名をロードバランサーに向け､重み付きレコードでトラフィックを分配する
+	records, err := provider.ListRecords(context.Background(), "test-app.team-a.app.tacokumo.dev")
+	require.NoError(t, err)
+	assert.Equal(t, []dns.Record{
+		dns.WeightedRecord(
+			"test-app.team-a.app.tacokumo.dev", "test-app.cluster-b.team-a.app.tacokumo.dev", "cluster-b", 50),
+	}, records)
+	records, err = provider.ListRecords(context.Background(), "test-app.cluster-b.team-a.app.tacokumo.dev")
+	require.NoError(t, err)
+	assert.Equal(t, []dns.Record{
+		dns.RecordFor("test-app.cluster-b.team-a.app.tacokumo.dev", "lb.example.net"),
+	}, records)
+	assert.Len(t, gw.Status.DNSRecords, 2)
+
+	// ClusterMigrationが削除されると重み付きでないレコードに戻す
+	require.NoError(t, k8sClient.Delete(context.Background(), migration))
+	require.NoError(t, m.Reconcile(context.Background(), gw))
+	records, err = provider.ListRecords(context.Background(), "test-app.team-a.app.tacokumo.dev")
+	require.NoError(t, err)
+	assert.Equal(t, []dns.Record{plain}, records)
+	records, err = provider.ListRecords(context.Background(), "test-app.cluster-b.team-a.app.tacokumo.dev")
+	require.NoError(t, err)
+	assert.Empty(t, records)
+}
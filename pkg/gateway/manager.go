@@ -0,0 +1,374 @@
+package gateway
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	tacokumogithubiov1alpha1 "github.com/tacokumo/portal-controller-kubernetes/api/v1alpha1"
+	"github.com/tacokumo/portal-controller-kubernetes/pkg/dns"
+	"github.com/tacokumo/portal-controller-kubernetes/pkg/helmutil"
+
+	"github.com/go-logr/logr"
+	"github.com/samber/lo"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+type Manager struct {
+	logger    logr.Logger
+	k8sClient client.Client
+	// dnsProvider とベースドメインが設定されている場合は､Gatewayのホスト名を構築してDNSに登録する
+	dnsProvider dns.Provider
+	// baseDomain はPortalの spec.baseDomain が設定されていない場合に使用されるベースドメイン
+	baseDomain string
+}
+
+func NewManager(
+	logger logr.Logger,
+	k8sClient client.Client,
+) *Manager {
+	return &Manager{
+		logger:    logger,
+		k8sClient: k8sClient,
+	}
+}
+
+// WithDNS は Manager にDNSプロバイダとホスト名の構築に使用するベースドメインを設定する
+func (m *Manager) WithDNS(provider dns.Provider, baseDomain string) *Manager {
+	m.dnsProvider = provider
+	m.baseDomain = baseDomain
+	return m
+}
+
+// Reconcile はGatewayのspecからIngressやHTTPRouteを作成し､エンドポイントのホスト名とURLをstatusに記録する
+func (m *Manager) Reconcile(
+	ctx context.Context,
+	gw *tacokumogithubiov1alpha1.Gateway,
+) error {
+	if !gw.DeletionTimestamp.IsZero() {
+		return m.reconcileDeletion(ctx, gw)
+	}
+
+	baseDomain, err := m.resolveBaseDomain(ctx)
+	if err != nil {
+		return m.handleError(ctx, gw, err)
+	}
+
+	// 削除時にDNSレコードを削除できるよう､statusを変更する前にfinalizerを追加する
+	if m.dnsEnabled(baseDomain) && controllerutil.AddFinalizer(gw, tacokumogithubiov1alpha1.GatewayDNSFinalizer) {
+		if err := m.k8sClient.Update(ctx, gw); err != nil {
+			return err
+		}
+	}
+
+	if err := m.reconcileRoutes(ctx, gw, baseDomain); err != nil {
+		return m.handleError(ctx, gw, err)
+	}
+	if err := m.k8sClient.Status().Update(ctx, gw); err != nil {
+		return m.handleError(ctx, gw, err)
+	}
+	return nil
+}
+
+func (m *Manager) reconcileRoutes(
+	ctx context.Context,
+	gw *tacokumogithubiov1alpha1.Gateway,
+	baseDomain string,
+) error {
+	app := &tacokumogithubiov1alpha1.Application{}
+	appKey := client.ObjectKey{Namespace: gw.Namespace, Name: gw.Spec.Application}
+	if err := m.k8sClient.Get(ctx, appKey, app); err != nil {
+		if apierrors.IsNotFound(err) {
+			return fmt.Errorf("application %s not found", gw.Spec.Application)
+		}
+		return err
+	}
+
+	implementation, err := m.chooseImplementation(ctx, gw)
+	if err != nil {
+		return err
+	}
+	gw.Status.Implementation = implementation
+
+	host, managed, err := m.desiredHost(gw, app, baseDomain)
+	if err != nil {
+		return err
+	}
+
+	cg, err := m.resolveClusterGateway(ctx, gw)
+	if err != nil {
+		return err
+	}
+	tlsSecret, routes, err := m.reconcileTLS(ctx, gw, implementation, host)
+	if err != nil {
+		return err
+	}
+	if cg != nil {
+		secretData, err := m.authProxySecretData(ctx, gw, cg)
+		if err != nil {
+			return err
+		}
+		proxy, err := renderAuthProxy(gw, cg, secretData, gw.Status.TLS != nil)
+		if err != nil {
+			return err
+		}
+		routes = append(routes, proxy...)
+	}
+	rendered, err := renderRoutes(gw, implementation, routeOptions{
+		host:          host,
+		tls:           gw.Status.TLS != nil,
+		tlsSecretName: tlsSecret,
+		protected:     cg != nil,
+	})
+	if err != nil {
+		return err
+	}
+	routes = append(routes, rendered...)
+	for _, route := range routes {
+		if err := controllerutil.SetControllerReference(gw, route, m.k8sClient.Scheme()); err != nil {
+			return err
+		}
+		if err := helmutil.CreateOrUpdateObject(ctx, m.k8sClient, route); err != nil {
+			return fmt.Errorf("failed to apply %s %s: %w", route.GetKind(), route.GetName(), err)
+		}
+	}
+
+	refs := routeReferencesOf(routes)
+	// 実装が切り替わった場合や認証､TLSが不要になった場合に､以前に作成したリソースを削除する
+	if err := m.pruneRoutes(ctx, gw, refs); err != nil {
+		return err
+	}
+	gw.Status.Routes = refs
+	gw.Status.ProtectedBy = ""
+	if cg != nil {
+		gw.Status.ProtectedBy = cg.Name
+	}
+	gw.Status.ObservedGeneration = gw.Generation
+
+	address, err := m.resolveAddress(ctx, gw, implementation)
+	if err != nil {
+		return err
+	}
+	// ホスト名をDNSに登録しない場合は､以前に登録したレコードを削除する
+	fqdn := ""
+	if managed {
+		fqdn = host
+	}
+	if err := m.reconcileDNSRecords(ctx, gw, fqdn, address); err != nil {
+		return err
+	}
+
+	gw.Status.Host = lo.CoalesceOrEmpty(host, address)
+	// DNSに登録するホスト名は､ロードバランサーのアドレスが割り当てられるまで解決できない
+	if gw.Status.Host == "" || (managed && address == "") {
+		gw.Status.URL = ""
+		tacokumogithubiov1alpha1.SetReadyConditionFalse(&gw.Status.Conditions, gw.Generation,
+			tacokumogithubiov1alpha1.ReasonWaitingForAddress, "waiting for the load balancer address to be assigned")
+		return nil
+	}
+	scheme := "http"
+	if gw.Status.TLS != nil {
+		scheme = "https"
+	}
+	gw.Status.URL = fmt.Sprintf("%s://%s", scheme, gw.Status.Host)
+	message := fmt.Sprintf("%s is routed to service %s", gw.Status.URL, serviceName(gw))
+	if gw.Status.ProtectedBy != "" {
+		message = fmt.Sprintf("%s through the auth proxy of clustergateway %s", message, gw.Status.ProtectedBy)
+	}
+	tacokumogithubiov1alpha1.SetReadyConditionTrue(&gw.Status.Conditions, gw.Generation,
+		tacokumogithubiov1alpha1.ReasonRouteReady, message)
+	return nil
+}
+
+// chooseImplementation はGatewayが使用する実装を返す
+// spec.implementation が指定されない場合は､Portalが検出したクラスタのコントローラーから選択する
+func (m *Manager) chooseImplementation(
+	ctx context.Context,
+	gw *tacokumogithubiov1alpha1.Gateway,
+) (string, error) {
+	if gw.Spec.Implementation != "" {
+		return gw.Spec.Implementation, nil
+	}
+
+	portals, err := m.listPortals(ctx)
+	if err != nil {
+		return "", err
+	}
+	for _, p := range portals {
+		if p.Status.Networking != nil {
+			return selectImplementation(gw, p.Status.Networking)
+		}
+	}
+	// 検出が完了していない場合はIngressを使用する
+	return tacokumogithubiov1alpha1.GatewayImplementationIngress, nil
+}
+
+// listPortals はPortalを名前順に返す
+func (m *Manager) listPortals(ctx context.Context) ([]tacokumogithubiov1alpha1.Portal, error) {
+	portals := &tacokumogithubiov1alpha1.PortalList{}
+	if err := m.k8sClient.List(ctx, portals); err != nil {
+		return nil, err
+	}
+	sort.Slice(portals.Items, func(i, j int) bool {
+		return portals.Items[i].Name < portals.Items[j].Name
+	})
+	return portals.Items, nil
+}
+
+// resolveBaseDomain はホスト名の構築に使用するベースドメインを返す
+// Portalに spec.baseDomain が設定されている場合は､名前順で最初に設定されているものを優先する
+func (m *Manager) resolveBaseDomain(ctx context.Context) (string, error) {
+	portals, err := m.listPortals(ctx)
+	if err != nil {
+		return "", err
+	}
+	for _, p := range portals {
+		if p.Spec.BaseDomain != "" {
+			return p.Spec.BaseDomain, nil
+		}
+	}
+	return m.baseDomain, nil
+}
+
+// desiredHost はルーティングに使用するホスト名を返す
+// ホスト名をDNSに登録する必要がある場合は true を返す
+func (m *Manager) desiredHost(
+	gw *tacokumogithubiov1alpha1.Gateway,
+	app *tacokumogithubiov1alpha1.Application,
+	baseDomain string,
+) (string, bool, error) {
+	if gw.Spec.Host != "" {
+		return gw.Spec.Host, false, nil
+	}
+	if !m.dnsEnabled(baseDomain) {
+		return "", false, nil
+	}
+	fqdn, err := dns.FQDN(lo.CoalesceOrEmpty(gw.Spec.DNSName, app.Name), tenantOf(app), baseDomain)
+	if err != nil {
+		return "", false, err
+	}
+	return fqdn, true, nil
+}
+
+// resolveAddress はIngressやGateway APIのGatewayに割り当てられたロードバランサーのアドレスを返す
+// アドレスが割り当てられていない場合は空文字列を返す
+func (m *Manager) resolveAddress(
+	ctx context.Context,
+	gw *tacokumogithubiov1alpha1.Gateway,
+	implementation string,
+) (string, error) {
+	switch implementation {
+	case tacokumogithubiov1alpha1.GatewayImplementationHTTPRoute:
+		parent := gw.Spec.ParentRefs[0]
+		namespace := lo.CoalesceOrEmpty(parent.Namespace, gw.Namespace)
+		obj := &unstructured.Unstructured{}
+		obj.SetGroupVersionKind(gatewayGVK)
+		if err := m.k8sClient.Get(ctx, client.ObjectKey{Namespace: namespace, Name: parent.Name}, obj); err != nil {
+			return "", client.IgnoreNotFound(err)
+		}
+		addresses, _, err := unstructured.NestedSlice(obj.Object, "status", "addresses")
+		if err != nil {
+			return "", err
+		}
+		for _, address := range addresses {
+			if value, ok := address.(map[string]any)["value"].(string); ok && value != "" {
+				return value, nil
+			}
+		}
+		return "", nil
+	default:
+		ingress := &networkingv1.Ingress{}
+		if err := m.k8sClient.Get(ctx, client.ObjectKey{Namespace: gw.Namespace, Name: gw.Name}, ingress); err != nil {
+			return "", client.IgnoreNotFound(err)
+		}
+		for _, lb := range ingress.Status.LoadBalancer.Ingress {
+			if address := lo.CoalesceOrEmpty(lb.Hostname, lb.IP); address != "" {
+				return address, nil
+			}
+		}
+		return "", nil
+	}
+}
+
+// reconcileDeletion はGatewayが登録したDNSレコードを削除してからfinalizerを外す
+func (m *Manager) reconcileDeletion(
+	ctx context.Context,
+	gw *tacokumogithubiov1alpha1.Gateway,
+) error {
+	if !controllerutil.ContainsFinalizer(gw, tacokumogithubiov1alpha1.GatewayDNSFinalizer) {
+		return nil
+	}
+	if err := m.reconcileDNSRecords(ctx, gw, "", ""); err != nil {
+		if updateErr := m.k8sClient.Status().Update(ctx, gw); updateErr != nil {
+			return updateErr
+		}
+		return err
+	}
+	controllerutil.RemoveFinalizer(gw, tacokumogithubiov1alpha1.GatewayDNSFinalizer)
+	return m.k8sClient.Update(ctx, gw)
+}
+
+// pruneRoutes は以前に作成し､現在は不要になったIngressやHTTPRoute､認証プロキシや証明書のリソースを削除する
+func (m *Manager) pruneRoutes(
+	ctx context.Context,
+	gw *tacokumogithubiov1alpha1.Gateway,
+	current []corev1.ObjectReference,
+) error {
+	for _, ref := range gw.Status.Routes {
+		if lo.ContainsBy(current, func(c corev1.ObjectReference) bool {
+			return c.APIVersion == ref.APIVersion && c.Kind == ref.Kind && c.Name == ref.Name
+		}) {
+			continue
+		}
+		obj := &unstructured.Unstructured{}
+		obj.SetAPIVersion(ref.APIVersion)
+		obj.SetKind(ref.Kind)
+		obj.SetNamespace(ref.Namespace)
+		obj.SetName(ref.Name)
+		if err := m.k8sClient.Delete(ctx, obj); client.IgnoreNotFound(err) != nil {
+			return fmt.Errorf("failed to delete %s %s: %w", ref.Kind, ref.Name, err)
+		}
+		m.logger.Info("deleted route", "kind", ref.Kind, "name", ref.Name)
+	}
+	return nil
+}
+
+func (m *Manager) dnsEnabled(baseDomain string) bool {
+	return m.dnsProvider != nil && baseDomain != ""
+}
+
+func (m *Manager) handleError(
+	ctx context.Context,
+	gw *tacokumogithubiov1alpha1.Gateway,
+	err error,
+) error {
+	// 引数のerrorは必ずnilではない
+	tacokumogithubiov1alpha1.SetReadyConditionFalse(
+		&gw.Status.Conditions,
+		gw.Generation,
+		tacokumogithubiov1alpha1.ReasonReconcileError,
+		err.Error(),
+	)
+
+	// errorだとしても､Statusの更新は必要
+	if updateErr := m.k8sClient.Status().Update(ctx, gw); updateErr != nil {
+		return updateErr
+	}
+	return err
+}
+
+func routeReferencesOf(objects []*unstructured.Unstructured) []corev1.ObjectReference {
+	return lo.Map(objects, func(obj *unstructured.Unstructured, _ int) corev1.ObjectReference {
+		return corev1.ObjectReference{
+			APIVersion: obj.GetAPIVersion(),
+			Kind:       obj.GetKind(),
+			Namespace:  obj.GetNamespace(),
+			Name:       obj.GetName(),
+		}
+	})
+}
@@ -0,0 +1,332 @@
+package gateway
+
+import (
+	"context"
+	"testing"
+
+	tacokumogithubiov1alpha1 "github.com/tacokumo/portal-controller-kubernetes/api/v1alpha1"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	k8sruntime "k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func newTestScheme(t *testing.T) *k8sruntime.Scheme {
+	t.Helper()
+	scheme := k8sruntime.NewScheme()
+	require.NoError(t, tacokumogithubiov1alpha1.AddToScheme(scheme))
+	require.NoError(t, corev1.AddToScheme(scheme))
+	require.NoError(t, appsv1.AddToScheme(scheme))
+	require.NoError(t, networkingv1.AddToScheme(scheme))
+	return scheme
+}
+
+func newTestApplication() *tacokumogithubiov1alpha1.Application {
+	return &tacokumogithubiov1alpha1.Application{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "test-app"},
+	}
+}
+
+func newTestGateway(mutate func(gw *tacokumogithubiov1alpha1.Gateway)) *tacokumogithubiov1alpha1.Gateway {
+	gw := &tacokumogithubiov1alpha1.Gateway{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "test-app", UID: "gw-uid", Generation: 1},
+		Spec: tacokumogithubiov1alpha1.GatewaySpec{
+			Application: "test-app",
+			Stage:       "production",
+			Ports: []tacokumogithubiov1alpha1.GatewayPort{
+				{Port: 8080, Path: "/"},
+				{Port: 9090, Path: "/metrics"},
+			},
+		},
+	}
+	mutate(gw)
+	return gw
+}
+
+func TestManager_Reconcile_Ingress(t *testing.T) {
+	tests := []struct {
+		name         string
+		host         string
+		loadBalancer []networkingv1.IngressLoadBalancerIngress
+		expectHost   string
+		expectURL    string
+		expectReady  metav1.ConditionStatus
+		expectReason string
+	}{
+		{
+			name:         "routes the host to the release service",
+			host:         "test-app.example.com",
+			expectHost:   "test-app.example.com",
+			expectURL:    "http://test-app.example.com",
+			expectReady:  metav1.ConditionTrue,
+			expectReason: tacokumogithubiov1alpha1.ReasonRouteReady,
+		},
+		{
+			name:         "waits for the load balancer address without host",
+			expectReady:  metav1.ConditionFalse,
+			expectReason: tacokumogithubiov1alpha1.ReasonWaitingForAddress,
+		},
+		{
+			name:         "reports the load balancer address without host",
+			loadBalancer: []networkingv1.IngressLoadBalancerIngress{{IP: "203.0.113.10"}},
+			expectHost:   "203.0.113.10",
+			expectURL:    "http://203.0.113.10",
+			expectReady:  metav1.ConditionTrue,
+			expectReason: tacokumogithubiov1alpha1.ReasonRouteReady,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gw := newTestGateway(func(gw *tacokumogithubiov1alpha1.Gateway) {
+				gw.Spec.Host = tt.host
+				gw.Spec.IngressClassName = ptr.To("nginx")
+			})
+			objects := []client.Object{newTestApplication(), gw}
+			if tt.loadBalancer != nil {
+				objects = append(objects, &networkingv1.Ingress{
+					ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "test-app"},
+					Status: networkingv1.IngressStatus{
+						LoadBalancer: networkingv1.IngressLoadBalancerStatus{Ingress: tt.loadBalancer},
+					},
+				})
+			}
+			k8sClient := fake.NewClientBuilder().
+				WithScheme(newTestScheme(t)).
+				WithObjects(objects...).
+				WithStatusSubresource(gw, &networkingv1.Ingress{}).
+				Build()
+			m := NewManager(logr.Discard(), k8sClient)
+
+			require.NoError(t, m.Reconcile(context.Background(), gw))
+
+			ingress := &networkingv1.Ingress{}
+			key := client.ObjectKey{Namespace: "default", Name: "test-app"}
+			require.NoError(t, k8sClient.Get(context.Background(), key, ingress))
+			assert.Equal(t, ptr.To("nginx"), ingress.Spec.IngressClassName)
+			require.Len(t, ingress.Spec.Rules, 1)
+			assert.Equal(t, tt.host, ingress.Spec.Rules[0].Host)
+			paths := ingress.Spec.Rules[0].HTTP.Paths
+			require.Len(t, paths, 2)
+			assert.Equal(t, "/metrics", paths[1].Path)
+			assert.Equal(t, "test-app-production", paths[1].Backend.Service.Name)
+			assert.Equal(t, int32(9090), paths[1].Backend.Service.Port.Number)
+			require.Len(t, ingress.OwnerReferences, 1)
+			assert.Equal(t, "Gateway", ingress.OwnerReferences[0].Kind)
+
+			assert.Equal(t, tt.expectHost, gw.Status.Host)
+			assert.Equal(t, tt.expectURL, gw.Status.URL)
+			assert.Equal(t, int64(1), gw.Status.ObservedGeneration)
+			assert.Equal(t, []corev1.ObjectReference{
+				{APIVersion: "networking.k8s.io/v1", Kind: "Ingress", Namespace: "default", Name: "test-app"},
+			}, gw.Status.Routes)
+			cond := meta.FindStatusCondition(gw.Status.Conditions, tacokumogithubiov1alpha1.ConditionTypeReady)
+			require.NotNil(t, cond)
+			assert.Equal(t, tt.expectReady, cond.Status)
+			assert.Equal(t, tt.expectReason, cond.Reason)
+		})
+	}
+}
+
+func TestManager_Reconcile_HTTPRoute(t *testing.T) {
+	gw := newTestGateway(func(gw *tacokumogithubiov1alpha1.Gateway) {
+		gw.Spec.Implementation = tacokumogithubiov1alpha1.GatewayImplementationHTTPRoute
+		gw.Spec.ParentRefs = []tacokumogithubiov1alpha1.GatewayParentReference{
+			{Name: "shared", Namespace: "gateway-system", SectionName: "http"},
+		}
+		// Ingressから切り替えた状態を再現する
+		gw.Status.Routes = []corev1.ObjectReference{
+			{APIVersion: "networking.k8s.io/v1", Kind: "Ingress", Namespace: "default", Name: "test-app"},
+		}
+	})
+	parent := &unstructured.Unstructured{Object: map[string]any{
+		"status": map[string]any{
+			"addresses": []any{map[string]any{"type": "Hostname", "value": "lb.example.com"}},
+		},
+	}}
+	parent.SetGroupVersionKind(gatewayGVK)
+	parent.SetNamespace("gateway-system")
+	parent.SetName("shared")
+	oldIngress := &networkingv1.Ingress{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "test-app"}}
+
+	k8sClient := fake.NewClientBuilder().
+		WithScheme(newTestScheme(t)).
+		WithObjects(newTestApplication(), gw, parent, oldIngress).
+		WithStatusSubresource(gw).
+		Build()
+	m := NewManager(logr.Discard(), k8sClient)
+
+	require.NoError(t, m.Reconcile(context.Background(), gw))
+
+	route := &unstructured.Unstructured{}
+	route.SetGroupVersionKind(httpRouteGVK)
+	key := client.ObjectKey{Namespace: "default", Name: "test-app"}
+	require.NoError(t, k8sClient.Get(context.Background(), key, route))
+	parentRefs, _, err := unstructured.NestedSlice(route.Object, "spec", "parentRefs")
+	require.NoError(t, err)
+	assert.Equal(t, []any{
+		map[string]any{"name": "shared", "namespace": "gateway-system", "sectionName": "http"},
+	}, parentRefs)
+	rules, _, err := unstructured.NestedSlice(route.Object, "spec", "rules")
+	require.NoError(t, err)
+	require.Len(t, rules, 2)
+	assert.Equal(t, map[string]any{
+		"matches":     []any{map[string]any{"path": map[string]any{"type": "PathPrefix", "value": "/metrics"}}},
+		"backendRefs": []any{map[string]any{"name": "test-app-production", "port": int64(9090)}},
+	}, rules[1])
+
+	err = k8sClient.Get(context.Background(), key, &networkingv1.Ingress{})
+	assert.True(t, apierrors.IsNotFound(err))
+
+	assert.Equal(t, "lb.example.com", gw.Status.Host)
+	assert.Equal(t, "http://lb.example.com", gw.Status.URL)
+	assert.Equal(t, []corev1.ObjectReference{
+		{APIVersion: "gateway.networking.k8s.io/v1", Kind: "HTTPRoute", Namespace: "default", Name: "test-app"},
+	}, gw.Status.Routes)
+	assert.True(t, meta.IsStatusConditionTrue(gw.Status.Conditions, tacokumogithubiov1alpha1.ConditionTypeReady))
+}
+
+func TestManager_Reconcile_Error(t *testing.T) {
+	tests := []struct {
+		name         string
+		mutate       func(gw *tacokumogithubiov1alpha1.Gateway)
+		withApp      bool
+		expectErrMsg string
+	}{
+		{
+			name:         "application not found",
+			mutate:       func(*tacokumogithubiov1alpha1.Gateway) {},
+			expectErrMsg: "application test-app not found",
+		},
+		{
+			name: "HTTPRoute without parentRefs",
+			mutate: func(gw *tacokumogithubiov1alpha1.Gateway) {
+				gw.Spec.Implementation = tacokumogithubiov1alpha1.GatewayImplementationHTTPRoute
+			},
+			withApp:      true,
+			expectErrMsg: "spec.parentRefs is required to use HTTPRoute",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gw := newTestGateway(tt.mutate)
+			objects := []client.Object{gw}
+			if tt.withApp {
+				objects = append(objects, newTestApplication())
+			}
+			k8sClient := fake.NewClientBuilder().
+				WithScheme(newTestScheme(t)).
+				WithObjects(objects...).
+				WithStatusSubresource(gw).
+				Build()
+			m := NewManager(logr.Discard(), k8sClient)
+
+			require.EqualError(t, m.Reconcile(context.Background(), gw), tt.expectErrMsg)
+
+			cond := meta.FindStatusCondition(gw.Status.Conditions, tacokumogithubiov1alpha1.ConditionTypeReady)
+			require.NotNil(t, cond)
+			assert.Equal(t, metav1.ConditionFalse, cond.Status)
+			assert.Equal(t, tacokumogithubiov1alpha1.ReasonReconcileError, cond.Reason)
+			assert.Equal(t, tt.expectErrMsg, cond.Message)
+		})
+	}
+}
+
+func TestManager_chooseImplementation(t *testing.T) {
+	available := func(classes ...string) tacokumogithubiov1alpha1.NetworkingAPIStatus {
+		status := tacokumogithubiov1alpha1.NetworkingAPIStatus{Available: true}
+		for _, name := range classes {
+			status.Classes = append(status.Classes, tacokumogithubiov1alpha1.NetworkingClass{Name: name})
+		}
+		return status
+	}
+	parentRefs := []tacokumogithubiov1alpha1.GatewayParentReference{{Name: "shared"}}
+
+	tests := []struct {
+		name         string
+		spec         string
+		parentRefs   []tacokumogithubiov1alpha1.GatewayParentReference
+		networking   *tacokumogithubiov1alpha1.NetworkingStatus
+		expect       string
+		expectErrMsg string
+	}{
+		{
+			name:       "spec takes precedence over discovery",
+			spec:       tacokumogithubiov1alpha1.GatewayImplementationIngress,
+			parentRefs: parentRefs,
+			networking: &tacokumogithubiov1alpha1.NetworkingStatus{GatewayAPI: available("envoy")},
+			expect:     tacokumogithubiov1alpha1.GatewayImplementationIngress,
+		},
+		{
+			name:   "falls back to Ingress before discovery",
+			expect: tacokumogithubiov1alpha1.GatewayImplementationIngress,
+		},
+		{
+			name:       "prefers HTTPRoute when a gateway class and parentRefs exist",
+			parentRefs: parentRefs,
+			networking: &tacokumogithubiov1alpha1.NetworkingStatus{
+				Ingress:    available("nginx"),
+				GatewayAPI: available("envoy"),
+			},
+			expect: tacokumogithubiov1alpha1.GatewayImplementationHTTPRoute,
+		},
+		{
+			name: "uses Ingress without parentRefs",
+			networking: &tacokumogithubiov1alpha1.NetworkingStatus{
+				Ingress:    available("nginx"),
+				GatewayAPI: available("envoy"),
+			},
+			expect: tacokumogithubiov1alpha1.GatewayImplementationIngress,
+		},
+		{
+			name: "requires parentRefs when only gateway API is available",
+			networking: &tacokumogithubiov1alpha1.NetworkingStatus{
+				Ingress: available(), GatewayAPI: available("envoy"),
+			},
+			expectErrMsg: "no IngressClass is available in the cluster: spec.parentRefs is required to use HTTPRoute",
+		},
+		{
+			name:         "no controller is available",
+			networking:   &tacokumogithubiov1alpha1.NetworkingStatus{},
+			expectErrMsg: "no ingress or gateway API controller is available in the cluster",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gw := newTestGateway(func(gw *tacokumogithubiov1alpha1.Gateway) {
+				gw.Spec.Implementation = tt.spec
+				gw.Spec.ParentRefs = tt.parentRefs
+			})
+			portal := &tacokumogithubiov1alpha1.Portal{
+				ObjectMeta: metav1.ObjectMeta{Name: "portal"},
+				Status:     tacokumogithubiov1alpha1.PortalStatus{Networking: tt.networking},
+			}
+			k8sClient := fake.NewClientBuilder().
+				WithScheme(newTestScheme(t)).
+				WithObjects(portal).
+				Build()
+			m := NewManager(logr.Discard(), k8sClient)
+
+			implementation, err := m.chooseImplementation(context.Background(), gw)
+			if tt.expectErrMsg != "" {
+				require.EqualError(t, err, tt.expectErrMsg)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expect, implementation)
+		})
+	}
+}
@@ -0,0 +1,212 @@
+package gateway
+
+import (
+	"fmt"
+
+	tacokumogithubiov1alpha1 "github.com/tacokumo/portal-controller-kubernetes/api/v1alpha1"
+
+	"github.com/samber/lo"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/utils/ptr"
+)
+
+// httpRouteGVK はGateway APIのHTTPRouteを示す
+// Gateway APIのCRDはクラスタに存在しない場合があるため､型を持たずunstructuredとして扱う
+var httpRouteGVK = schema.GroupVersionKind{
+	Group:   "gateway.networking.k8s.io",
+	Version: "v1",
+	Kind:    "HTTPRoute",
+}
+
+// gatewayGVK はGateway APIのGatewayを示す
+var gatewayGVK = schema.GroupVersionKind{
+	Group:   "gateway.networking.k8s.io",
+	Version: "v1",
+	Kind:    "Gateway",
+}
+
+// serviceName は tacokumo-application チャートがReleaseごとに作成するServiceの名前を返す
+func serviceName(gw *tacokumogithubiov1alpha1.Gateway) string {
+	return fmt.Sprintf("%s-%s", gw.Spec.Application, gw.Spec.Stage)
+}
+
+// selectImplementation はクラスタで検出したコントローラーからGatewayが使用する実装を選択する
+// Gateway APIはGatewayの接続先が必要なため､spec.parentRefs が指定されている場合のみ選択する
+func selectImplementation(
+	gw *tacokumogithubiov1alpha1.Gateway,
+	networking *tacokumogithubiov1alpha1.NetworkingStatus,
+) (string, error) {
+	gatewayAPI := networking.GatewayAPI.Available && len(networking.GatewayAPI.Classes) > 0
+	ingress := networking.Ingress.Available && len(networking.Ingress.Classes) > 0
+	switch {
+	case gatewayAPI && len(gw.Spec.ParentRefs) > 0:
+		return tacokumogithubiov1alpha1.GatewayImplementationHTTPRoute, nil
+	case ingress:
+		return tacokumogithubiov1alpha1.GatewayImplementationIngress, nil
+	case gatewayAPI:
+		return "", fmt.Errorf(
+			"no IngressClass is available in the cluster: spec.parentRefs is required to use HTTPRoute")
+	default:
+		return "", fmt.Errorf("no ingress or gateway API controller is available in the cluster")
+	}
+}
+
+// routeOptions はIngressやHTTPRouteの構築に使用する､Gatewayのspec以外の情報を表す
+type routeOptions struct {
+	// host が空の場合はホスト名によるルーティングを行わない
+	host string
+	// tls が true の場合はTLSを終端する
+	tls bool
+	// tlsSecretName はTLSの終端に使用する証明書のSecretを示す
+	// 空の場合はIngressコントローラーのデフォルト証明書を使用する
+	tlsSecretName string
+	// protected が true の場合は､全てのリクエストを認証プロキシに転送する
+	protected bool
+}
+
+// renderRoutes は implementation に応じて､Gatewayのspecから作成するIngressやHTTPRouteを構築する
+func renderRoutes(
+	gw *tacokumogithubiov1alpha1.Gateway,
+	implementation string,
+	opts routeOptions,
+) ([]*unstructured.Unstructured, error) {
+	switch implementation {
+	case tacokumogithubiov1alpha1.GatewayImplementationIngress:
+		ingress, err := toUnstructured(renderIngress(gw, opts), networkingv1.SchemeGroupVersion.WithKind("Ingress"))
+		if err != nil {
+			return nil, err
+		}
+		return []*unstructured.Unstructured{ingress}, nil
+	case tacokumogithubiov1alpha1.GatewayImplementationHTTPRoute:
+		if len(gw.Spec.ParentRefs) == 0 {
+			return nil, fmt.Errorf("spec.parentRefs is required to use HTTPRoute")
+		}
+		return []*unstructured.Unstructured{renderHTTPRoute(gw, opts)}, nil
+	default:
+		return nil, fmt.Errorf("unsupported implementation %q", implementation)
+	}
+}
+
+func renderIngress(gw *tacokumogithubiov1alpha1.Gateway, opts routeOptions) *networkingv1.Ingress {
+	paths := lo.Map(gw.Spec.Ports, func(p tacokumogithubiov1alpha1.GatewayPort, _ int) networkingv1.HTTPIngressPath {
+		name, port := backendOf(gw, p, opts.protected)
+		return networkingv1.HTTPIngressPath{
+			Path:     pathOf(p),
+			PathType: ptr.To(networkingv1.PathTypePrefix),
+			Backend: networkingv1.IngressBackend{
+				Service: &networkingv1.IngressServiceBackend{
+					Name: name,
+					Port: networkingv1.ServiceBackendPort{Number: port},
+				},
+			},
+		}
+	})
+
+	ingress := &networkingv1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: gw.Namespace,
+			Name:      gw.Name,
+			Labels:    routeLabels(gw),
+		},
+		Spec: networkingv1.IngressSpec{
+			IngressClassName: gw.Spec.IngressClassName,
+			Rules: []networkingv1.IngressRule{
+				{
+					Host: opts.host,
+					IngressRuleValue: networkingv1.IngressRuleValue{
+						HTTP: &networkingv1.HTTPIngressRuleValue{Paths: paths},
+					},
+				},
+			},
+		},
+	}
+	if opts.tls {
+		ingress.Spec.TLS = []networkingv1.IngressTLS{
+			{Hosts: []string{opts.host}, SecretName: opts.tlsSecretName},
+		}
+	}
+	return ingress
+}
+
+// renderHTTPRoute はGateway APIのHTTPRouteを構築する
+// HTTPRouteではTLSは接続先のGatewayのリスナーで終端されるため､証明書は参照しない
+func renderHTTPRoute(gw *tacokumogithubiov1alpha1.Gateway, opts routeOptions) *unstructured.Unstructured {
+	parentRefs := lo.Map(gw.Spec.ParentRefs, func(ref tacokumogithubiov1alpha1.GatewayParentReference, _ int) any {
+		parentRef := map[string]any{"name": ref.Name}
+		if ref.Namespace != "" {
+			parentRef["namespace"] = ref.Namespace
+		}
+		if ref.SectionName != "" {
+			parentRef["sectionName"] = ref.SectionName
+		}
+		return parentRef
+	})
+	rules := lo.Map(gw.Spec.Ports, func(p tacokumogithubiov1alpha1.GatewayPort, _ int) any {
+		name, port := backendOf(gw, p, opts.protected)
+		return map[string]any{
+			"matches": []any{
+				map[string]any{
+					"path": map[string]any{"type": "PathPrefix", "value": pathOf(p)},
+				},
+			},
+			"backendRefs": []any{
+				map[string]any{"name": name, "port": int64(port)},
+			},
+		}
+	})
+
+	spec := map[string]any{
+		"parentRefs": parentRefs,
+		"rules":      rules,
+	}
+	if opts.host != "" {
+		spec["hostnames"] = []any{opts.host}
+	}
+
+	route := &unstructured.Unstructured{Object: map[string]any{"spec": spec}}
+	route.SetGroupVersionKind(httpRouteGVK)
+	route.SetNamespace(gw.Namespace)
+	route.SetName(gw.Name)
+	route.SetLabels(routeLabels(gw))
+	return route
+}
+
+// backendOf は p へのリクエストの転送先のServiceの名前とポートを返す
+func backendOf(
+	gw *tacokumogithubiov1alpha1.Gateway,
+	p tacokumogithubiov1alpha1.GatewayPort,
+	protected bool,
+) (string, int32) {
+	if protected {
+		return authProxyName(gw), authProxyPort
+	}
+	return serviceName(gw), p.Port
+}
+
+func routeLabels(gw *tacokumogithubiov1alpha1.Gateway) map[string]string {
+	return map[string]string{
+		tacokumogithubiov1alpha1.ManagedByLabelKey:   "portal-controller",
+		tacokumogithubiov1alpha1.ApplicationLabelKey: gw.Spec.Application,
+	}
+}
+
+func pathOf(p tacokumogithubiov1alpha1.GatewayPort) string {
+	if p.Path == "" {
+		return "/"
+	}
+	return p.Path
+}
+
+func toUnstructured(obj runtime.Object, gvk schema.GroupVersionKind) (*unstructured.Unstructured, error) {
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+	if err != nil {
+		return nil, err
+	}
+	u := &unstructured.Unstructured{Object: content}
+	u.SetGroupVersionKind(gvk)
+	return u, nil
+}
@@ -0,0 +1,302 @@
+package gateway
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"strings"
+	"time"
+
+	tacokumogithubiov1alpha1 "github.com/tacokumo/portal-controller-kubernetes/api/v1alpha1"
+
+	"github.com/samber/lo"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// certificateGVK はcert-managerのCertificateを示す
+// cert-managerはクラスタに存在しない場合があるため､型を持たずunstructuredとして扱う
+var certificateGVK = schema.GroupVersionKind{
+	Group:   "cert-manager.io",
+	Version: "v1",
+	Kind:    "Certificate",
+}
+
+// tlsSecretName はGatewayの証明書を保持するSecretの名前を返す
+func tlsSecretName(gw *tacokumogithubiov1alpha1.Gateway) string {
+	return fmt.Sprintf("%s-tls", gw.Name)
+}
+
+// reconcileTLS はPortalの設定に従って host の証明書を用意し､証明書を保持するSecretの名前と作成するリソースを返す
+// TLSを使用するかは gw.Status.TLS に記録される
+// ワイルドカード証明書をコピーしない場合はIngressコントローラーのデフォルト証明書で終端するため､空文字列を返す
+// 証明書を発行できない場合は､HTTPで公開せずにエラーを返す
+func (m *Manager) reconcileTLS(
+	ctx context.Context,
+	gw *tacokumogithubiov1alpha1.Gateway,
+	implementation string,
+	host string,
+) (string, []*unstructured.Unstructured, error) {
+	if implementation == tacokumogithubiov1alpha1.GatewayImplementationHTTPRoute {
+		// HTTPRouteではTLSは接続先のGatewayのリスナーで終端されるため､証明書を発行しても参照されない
+		gw.Status.TLS = nil
+		setTLSReadyCondition(gw, metav1.ConditionFalse, tacokumogithubiov1alpha1.ReasonTLSNotConfigured,
+			"HTTPRoute does not terminate TLS: configure the certificate on the listener of the parent gateway")
+		return "", nil, nil
+	}
+	if host == "" {
+		// ホスト名がない場合は証明書を発行できない
+		gw.Status.TLS = nil
+		meta.RemoveStatusCondition(&gw.Status.Conditions, tacokumogithubiov1alpha1.ConditionTypeTLSReady)
+		return "", nil, nil
+	}
+
+	config, err := m.portalTLS(ctx)
+	if err != nil {
+		return "", nil, err
+	}
+	if config == nil {
+		gw.Status.TLS = nil
+		setTLSReadyCondition(gw, metav1.ConditionFalse, tacokumogithubiov1alpha1.ReasonTLSNotConfigured,
+			fmt.Sprintf("no certificate issuer or wildcard certificate is configured on the portal: "+
+				"%s is served over plain HTTP", host))
+		return "", nil, nil
+	}
+
+	if config.IssuerRef != nil {
+		available, err := m.certificateAvailable()
+		if err != nil {
+			return "", nil, err
+		}
+		if available {
+			return m.reconcileCertificate(ctx, gw, host, config.IssuerRef)
+		}
+		if config.WildcardCertificateSecret == nil {
+			return "", nil, tlsError(gw, tacokumogithubiov1alpha1.ReasonIssuerNotFound,
+				fmt.Sprintf("cert-manager is not installed in the cluster: "+
+					"the certificate for %s cannot be issued", host))
+		}
+	}
+	return m.reconcileWildcardCertificate(ctx, gw, host, *config.WildcardCertificateSecret,
+		config.CopyWildcardCertificate)
+}
+
+// portalTLS はPortalに設定されたTLSの設定を返す
+// 複数のPortalが存在する場合は､名前順で最初に設定されているものを使用する
+func (m *Manager) portalTLS(ctx context.Context) (*tacokumogithubiov1alpha1.PortalTLS, error) {
+	portals, err := m.listPortals(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range portals {
+		if p.Spec.TLS != nil {
+			return p.Spec.TLS, nil
+		}
+	}
+	return nil, nil
+}
+
+func (m *Manager) certificateAvailable() (bool, error) {
+	if _, err := m.k8sClient.RESTMapper().RESTMapping(certificateGVK.GroupKind(), certificateGVK.Version); err != nil {
+		if meta.IsNoMatchError(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// reconcileCertificate はcert-managerのCertificateを構築し､発行済みの証明書の状態をstatusに記録する
+func (m *Manager) reconcileCertificate(
+	ctx context.Context,
+	gw *tacokumogithubiov1alpha1.Gateway,
+	host string,
+	ref *tacokumogithubiov1alpha1.CertificateIssuerReference,
+) (string, []*unstructured.Unstructured, error) {
+	kind := lo.CoalesceOrEmpty(ref.Kind, "ClusterIssuer")
+	issuer := &unstructured.Unstructured{}
+	issuer.SetGroupVersionKind(certificateGVK.GroupVersion().WithKind(kind))
+	key := client.ObjectKey{Name: ref.Name}
+	if kind == "Issuer" {
+		key.Namespace = gw.Namespace
+	}
+	if err := m.k8sClient.Get(ctx, key, issuer); err != nil {
+		if !apierrors.IsNotFound(err) && !meta.IsNoMatchError(err) {
+			return "", nil, err
+		}
+		return "", nil, tlsError(gw, tacokumogithubiov1alpha1.ReasonIssuerNotFound,
+			fmt.Sprintf("%s %s not found: the certificate for %s cannot be issued",
+				strings.ToLower(kind), ref.Name, host))
+	}
+
+	existing := &unstructured.Unstructured{}
+	existing.SetGroupVersionKind(certificateGVK)
+	existingKey := client.ObjectKey{Namespace: gw.Namespace, Name: tlsSecretName(gw)}
+	if err := m.k8sClient.Get(ctx, existingKey, existing); err != nil {
+		if !apierrors.IsNotFound(err) {
+			return "", nil, err
+		}
+		existing = nil
+	}
+	ready, notAfter := certificateStatusOf(existing, host)
+
+	gw.Status.TLS = &tacokumogithubiov1alpha1.GatewayTLSStatus{
+		Source:     tacokumogithubiov1alpha1.GatewayTLSSourceCertificate,
+		SecretName: tlsSecretName(gw),
+		NotAfter:   notAfter,
+	}
+	if ready {
+		setTLSReadyCondition(gw, metav1.ConditionTrue, tacokumogithubiov1alpha1.ReasonCertificateReady,
+			fmt.Sprintf("the certificate for %s is valid until %s", host, notAfter.UTC().Format(time.RFC3339)))
+	} else {
+		setTLSReadyCondition(gw, metav1.ConditionFalse, tacokumogithubiov1alpha1.ReasonCertificatePending,
+			fmt.Sprintf("waiting for %s %s to issue the certificate for %s", strings.ToLower(kind), ref.Name, host))
+	}
+	return tlsSecretName(gw), []*unstructured.Unstructured{renderCertificate(gw, host, kind, ref.Name)}, nil
+}
+
+// reconcileWildcardCertificate はワイルドカード証明書が host を含み有効であることを検証する
+// copySecret が有効な場合はGatewayのnamespaceにコピーしてIngressから参照する
+// 有効でない場合は､秘密鍵をテナントのnamespaceに複製しないよう､Ingressコントローラーのデフォルト証明書が
+// 同じ証明書であると仮定する
+// 実際に提供される証明書は確認できないため､有効期限は記録しない
+func (m *Manager) reconcileWildcardCertificate(
+	ctx context.Context,
+	gw *tacokumogithubiov1alpha1.Gateway,
+	host string,
+	ref tacokumogithubiov1alpha1.NamespacedName,
+	copySecret bool,
+) (string, []*unstructured.Unstructured, error) {
+	source := &corev1.Secret{}
+	if err := m.k8sClient.Get(ctx, client.ObjectKey{Namespace: ref.Namespace, Name: ref.Name}, source); err != nil {
+		if apierrors.IsNotFound(err) {
+			return "", nil, tlsError(gw, tacokumogithubiov1alpha1.ReasonInvalidCertificate,
+				fmt.Sprintf("wildcard certificate secret %s/%s not found", ref.Namespace, ref.Name))
+		}
+		return "", nil, err
+	}
+
+	cert, err := parseCertificate(source.Data[corev1.TLSCertKey])
+	if err != nil {
+		return "", nil, tlsError(gw, tacokumogithubiov1alpha1.ReasonInvalidCertificate,
+			fmt.Sprintf("failed to parse wildcard certificate %s/%s: %v", ref.Namespace, ref.Name, err))
+	}
+	if err := cert.VerifyHostname(host); err != nil {
+		return "", nil, tlsError(gw, tacokumogithubiov1alpha1.ReasonInvalidCertificate,
+			fmt.Sprintf("wildcard certificate %s/%s does not cover %s", ref.Namespace, ref.Name, host))
+	}
+	if time.Now().After(cert.NotAfter) {
+		return "", nil, tlsError(gw, tacokumogithubiov1alpha1.ReasonInvalidCertificate,
+			fmt.Sprintf("wildcard certificate %s/%s expired at %s",
+				ref.Namespace, ref.Name, cert.NotAfter.UTC().Format(time.RFC3339)))
+	}
+
+	if !copySecret {
+		gw.Status.TLS = &tacokumogithubiov1alpha1.GatewayTLSStatus{
+			Source: tacokumogithubiov1alpha1.GatewayTLSSourceAssumedDefaultCertificate,
+		}
+		setTLSReadyCondition(gw, metav1.ConditionTrue, tacokumogithubiov1alpha1.ReasonDefaultCertificateAssumed,
+			fmt.Sprintf("assuming the ingress controller serves wildcard certificate %s/%s for %s as its default certificate",
+				ref.Namespace, ref.Name, host))
+		return "", nil, nil
+	}
+
+	secret, err := toUnstructured(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: gw.Namespace, Name: tlsSecretName(gw), Labels: routeLabels(gw)},
+		Type:       corev1.SecretTypeTLS,
+		Data: map[string][]byte{
+			corev1.TLSCertKey:       source.Data[corev1.TLSCertKey],
+			corev1.TLSPrivateKeyKey: source.Data[corev1.TLSPrivateKeyKey],
+		},
+	}, corev1.SchemeGroupVersion.WithKind("Secret"))
+	if err != nil {
+		return "", nil, err
+	}
+	gw.Status.TLS = &tacokumogithubiov1alpha1.GatewayTLSStatus{
+		Source:     tacokumogithubiov1alpha1.GatewayTLSSourceWildcardSecret,
+		SecretName: tlsSecretName(gw),
+		NotAfter:   ptr.To(metav1.NewTime(cert.NotAfter)),
+	}
+	setTLSReadyCondition(gw, metav1.ConditionTrue, tacokumogithubiov1alpha1.ReasonCertificateReady,
+		fmt.Sprintf("the certificate for %s is valid until %s", host, cert.NotAfter.UTC().Format(time.RFC3339)))
+	return tlsSecretName(gw), []*unstructured.Unstructured{secret}, nil
+}
+
+func renderCertificate(
+	gw *tacokumogithubiov1alpha1.Gateway,
+	host, issuerKind, issuerName string,
+) *unstructured.Unstructured {
+	cert := &unstructured.Unstructured{Object: map[string]any{
+		"spec": map[string]any{
+			"secretName": tlsSecretName(gw),
+			"dnsNames":   []any{host},
+			"issuerRef": map[string]any{
+				"group": certificateGVK.Group,
+				"kind":  issuerKind,
+				"name":  issuerName,
+			},
+		},
+	}}
+	cert.SetGroupVersionKind(certificateGVK)
+	cert.SetNamespace(gw.Namespace)
+	cert.SetName(tlsSecretName(gw))
+	cert.SetLabels(routeLabels(gw))
+	return cert
+}
+
+// certificateStatusOf はCertificateが host の証明書を発行済みかと､その有効期限を返す
+func certificateStatusOf(cert *unstructured.Unstructured, host string) (bool, *metav1.Time) {
+	if cert == nil {
+		return false, nil
+	}
+	dnsNames, _, _ := unstructured.NestedStringSlice(cert.Object, "spec", "dnsNames")
+	if !lo.Contains(dnsNames, host) {
+		// ホスト名が変わった場合は､再発行されるまで発行済みとして扱わない
+		return false, nil
+	}
+
+	var notAfter *metav1.Time
+	if value, _, _ := unstructured.NestedString(cert.Object, "status", "notAfter"); value != "" {
+		if t, err := time.Parse(time.RFC3339, value); err == nil {
+			notAfter = ptr.To(metav1.NewTime(t))
+		}
+	}
+	conditions, _, _ := unstructured.NestedSlice(cert.Object, "status", "conditions")
+	ready := lo.ContainsBy(conditions, func(c any) bool {
+		condition, ok := c.(map[string]any)
+		return ok && condition["type"] == "Ready" && condition["status"] == string(metav1.ConditionTrue)
+	})
+	return ready && notAfter != nil, notAfter
+}
+
+func parseCertificate(data []byte) (*x509.Certificate, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s does not contain a PEM encoded certificate", corev1.TLSCertKey)
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
+
+// tlsError は証明書を用意できない理由をTLSReady Conditionに記録し､エラーとして返す
+func tlsError(gw *tacokumogithubiov1alpha1.Gateway, reason, message string) error {
+	gw.Status.TLS = nil
+	setTLSReadyCondition(gw, metav1.ConditionFalse, reason, message)
+	return fmt.Errorf("%s", message)
+}
+
+func setTLSReadyCondition(gw *tacokumogithubiov1alpha1.Gateway, status metav1.ConditionStatus, reason, message string) {
+	meta.SetStatusCondition(&gw.Status.Conditions, metav1.Condition{
+		Type:               tacokumogithubiov1alpha1.ConditionTypeTLSReady,
+		Status:             status,
+		ObservedGeneration: gw.Generation,
+		Reason:             reason,
+		Message:            message,
+	})
+}
@@ -0,0 +1,381 @@
+package gateway
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+
+	tacokumogithubiov1alpha1 "github.com/tacokumo/portal-controller-kubernetes/api/v1alpha1"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// newTestCertManagerRESTMapper はcert-managerのCRDがインストールされたクラスタのRESTMapperを返す
+func newTestCertManagerRESTMapper() meta.RESTMapper {
+	mapper := meta.NewDefaultRESTMapper(nil)
+	mapper.Add(certificateGVK, meta.RESTScopeNamespace)
+	mapper.Add(certificateGVK.GroupVersion().WithKind("Issuer"), meta.RESTScopeNamespace)
+	mapper.Add(certificateGVK.GroupVersion().WithKind("ClusterIssuer"), meta.RESTScopeRoot)
+	return mapper
+}
+
+func newTestClusterIssuer(name string) *unstructured.Unstructured {
+	issuer := &unstructured.Unstructured{}
+	issuer.SetGroupVersionKind(certificateGVK.GroupVersion().WithKind("ClusterIssuer"))
+	issuer.SetName(name)
+	return issuer
+}
+
+func newTestIssuedCertificate(host string, notAfter time.Time) *unstructured.Unstructured {
+	cert := &unstructured.Unstructured{Object: map[string]any{
+		"spec": map[string]any{
+			"secretName": "test-app-tls",
+			"dnsNames":   []any{host},
+		},
+		"status": map[string]any{
+			"notAfter":   notAfter.UTC().Format(time.RFC3339),
+			"conditions": []any{map[string]any{"type": "Ready", "status": "True"}},
+		},
+	}}
+	cert.SetGroupVersionKind(certificateGVK)
+	cert.SetNamespace("default")
+	cert.SetName("test-app-tls")
+	return cert
+}
+
+func newTestPortal(tls *tacokumogithubiov1alpha1.PortalTLS) *tacokumogithubiov1alpha1.Portal {
+	return &tacokumogithubiov1alpha1.Portal{
+		ObjectMeta: metav1.ObjectMeta{Name: "portal"},
+		Spec:       tacokumogithubiov1alpha1.PortalSpec{TLS: tls},
+	}
+}
+
+// newTestWildcardSecret は dnsName の自己署名証明書を保持するSecretを返す
+func newTestWildcardSecret(t *testing.T, dnsName string, notAfter time.Time) *corev1.Secret {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: dnsName},
+		DNSNames:     []string{dnsName},
+		NotBefore:    notAfter.Add(-365 * 24 * time.Hour),
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "tacokumo-system", Name: "wildcard"},
+		Type:       corev1.SecretTypeTLS,
+		Data: map[string][]byte{
+			corev1.TLSCertKey:       pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+			corev1.TLSPrivateKeyKey: pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+		},
+	}
+}
+
+func TestManager_Reconcile_TLS(t *testing.T) {
+	notAfter := time.Now().Add(90 * 24 * time.Hour).Truncate(time.Second)
+	issuerRef := &tacokumogithubiov1alpha1.CertificateIssuerReference{Name: "letsencrypt", Kind: "ClusterIssuer"}
+	wildcardRef := &tacokumogithubiov1alpha1.NamespacedName{Namespace: "tacokumo-system", Name: "wildcard"}
+
+	tests := []struct {
+		name             string
+		tls              *tacokumogithubiov1alpha1.PortalTLS
+		certManager      bool
+		objects          []client.Object
+		expectURL        string
+		expectSource     string
+		expectSecretName string
+		expectReady      metav1.ConditionStatus
+		expectReason     string
+		expectExpiry     bool
+	}{
+		{
+			name:         "serves plain HTTP without tls configuration",
+			expectURL:    "http://test-app.example.com",
+			expectReady:  metav1.ConditionFalse,
+			expectReason: tacokumogithubiov1alpha1.ReasonTLSNotConfigured,
+		},
+		{
+			name:             "requests a certificate from cert-manager",
+			tls:              &tacokumogithubiov1alpha1.PortalTLS{IssuerRef: issuerRef},
+			certManager:      true,
+			objects:          []client.Object{newTestClusterIssuer("letsencrypt")},
+			expectURL:        "https://test-app.example.com",
+			expectSource:     tacokumogithubiov1alpha1.GatewayTLSSourceCertificate,
+			expectSecretName: "test-app-tls",
+			expectReady:      metav1.ConditionFalse,
+			expectReason:     tacokumogithubiov1alpha1.ReasonCertificatePending,
+		},
+		{
+			name:        "reports the issued certificate",
+			tls:         &tacokumogithubiov1alpha1.PortalTLS{IssuerRef: issuerRef},
+			certManager: true,
+			objects: []client.Object{
+				newTestClusterIssuer("letsencrypt"),
+				newTestIssuedCertificate("test-app.example.com", notAfter),
+			},
+			expectURL:        "https://test-app.example.com",
+			expectSource:     tacokumogithubiov1alpha1.GatewayTLSSourceCertificate,
+			expectSecretName: "test-app-tls",
+			expectReady:      metav1.ConditionTrue,
+			expectReason:     tacokumogithubiov1alpha1.ReasonCertificateReady,
+			expectExpiry:     true,
+		},
+		{
+			// 提供される証明書は確認できないため､有効期限は記録しない
+			name:         "assumes the wildcard certificate is the default certificate",
+			tls:          &tacokumogithubiov1alpha1.PortalTLS{WildcardCertificateSecret: wildcardRef},
+			objects:      []client.Object{newTestWildcardSecret(t, "*.example.com", notAfter)},
+			expectURL:    "https://test-app.example.com",
+			expectSource: tacokumogithubiov1alpha1.GatewayTLSSourceAssumedDefaultCertificate,
+			expectReady:  metav1.ConditionTrue,
+			expectReason: tacokumogithubiov1alpha1.ReasonDefaultCertificateAssumed,
+		},
+		{
+			name: "copies the wildcard certificate when opted in",
+			tls: &tacokumogithubiov1alpha1.PortalTLS{
+				WildcardCertificateSecret: wildcardRef,
+				CopyWildcardCertificate:   true,
+			},
+			objects:          []client.Object{newTestWildcardSecret(t, "*.example.com", notAfter)},
+			expectURL:        "https://test-app.example.com",
+			expectSource:     tacokumogithubiov1alpha1.GatewayTLSSourceWildcardSecret,
+			expectSecretName: "test-app-tls",
+			expectReady:      metav1.ConditionTrue,
+			expectReason:     tacokumogithubiov1alpha1.ReasonCertificateReady,
+			expectExpiry:     true,
+		},
+		{
+			name: "falls back to the wildcard certificate without cert-manager",
+			tls: &tacokumogithubiov1alpha1.PortalTLS{
+				IssuerRef:                 issuerRef,
+				WildcardCertificateSecret: wildcardRef,
+			},
+			objects:      []client.Object{newTestWildcardSecret(t, "*.example.com", notAfter)},
+			expectURL:    "https://test-app.example.com",
+			expectSource: tacokumogithubiov1alpha1.GatewayTLSSourceAssumedDefaultCertificate,
+			expectReady:  metav1.ConditionTrue,
+			expectReason: tacokumogithubiov1alpha1.ReasonDefaultCertificateAssumed,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gw := newTestGateway(func(gw *tacokumogithubiov1alpha1.Gateway) {
+				gw.Spec.Host = "test-app.example.com"
+				gw.Spec.IngressClassName = ptr.To("nginx")
+			})
+			objects := append([]client.Object{
+				newTestApplication(), gw, newTestPortal(tt.tls),
+				newTestLoadBalancedIngress("lb.example.net"),
+			}, tt.objects...)
+			builder := fake.NewClientBuilder().
+				WithScheme(newTestScheme(t)).
+				WithObjects(objects...).
+				WithStatusSubresource(gw, &networkingv1.Ingress{})
+			if tt.certManager {
+				builder = builder.WithRESTMapper(newTestCertManagerRESTMapper())
+			}
+			k8sClient := builder.Build()
+			m := NewManager(logr.Discard(), k8sClient)
+
+			require.NoError(t, m.Reconcile(context.Background(), gw))
+
+			assert.Equal(t, tt.expectURL, gw.Status.URL)
+			cond := meta.FindStatusCondition(gw.Status.Conditions, tacokumogithubiov1alpha1.ConditionTypeTLSReady)
+			require.NotNil(t, cond)
+			assert.Equal(t, tt.expectReady, cond.Status)
+			assert.Equal(t, tt.expectReason, cond.Reason)
+
+			ingress := &networkingv1.Ingress{}
+			ingressKey := client.ObjectKey{Namespace: "default", Name: "test-app"}
+			require.NoError(t, k8sClient.Get(context.Background(), ingressKey, ingress))
+			if tt.expectSource == "" {
+				assert.Nil(t, gw.Status.TLS)
+				assert.Empty(t, ingress.Spec.TLS)
+				return
+			}
+			require.NotNil(t, gw.Status.TLS)
+			assert.Equal(t, tt.expectSource, gw.Status.TLS.Source)
+			assert.Equal(t, tt.expectSecretName, gw.Status.TLS.SecretName)
+			if tt.expectExpiry {
+				require.NotNil(t, gw.Status.TLS.NotAfter)
+				assert.True(t, notAfter.Equal(gw.Status.TLS.NotAfter.Time))
+			} else {
+				assert.Nil(t, gw.Status.TLS.NotAfter)
+			}
+			assert.Equal(t, []networkingv1.IngressTLS{
+				{Hosts: []string{"test-app.example.com"}, SecretName: tt.expectSecretName},
+			}, ingress.Spec.TLS)
+
+			tlsKey := client.ObjectKey{Namespace: "default", Name: "test-app-tls"}
+			switch tt.expectSource {
+			case tacokumogithubiov1alpha1.GatewayTLSSourceCertificate:
+				cert := &unstructured.Unstructured{}
+				cert.SetGroupVersionKind(certificateGVK)
+				require.NoError(t, k8sClient.Get(context.Background(), tlsKey, cert))
+				dnsNames, _, _ := unstructured.NestedStringSlice(cert.Object, "spec", "dnsNames")
+				assert.Equal(t, []string{"test-app.example.com"}, dnsNames)
+				issuer, _, _ := unstructured.NestedString(cert.Object, "spec", "issuerRef", "name")
+				assert.Equal(t, "letsencrypt", issuer)
+			case tacokumogithubiov1alpha1.GatewayTLSSourceWildcardSecret:
+				secret := &corev1.Secret{}
+				require.NoError(t, k8sClient.Get(context.Background(), tlsKey, secret))
+				assert.Equal(t, corev1.SecretTypeTLS, secret.Type)
+				assert.NotEmpty(t, secret.Data[corev1.TLSPrivateKeyKey])
+			case tacokumogithubiov1alpha1.GatewayTLSSourceAssumedDefaultCertificate:
+				// 明示的に有効にしない限り､秘密鍵をGatewayのnamespaceにコピーしない
+				err := k8sClient.Get(context.Background(), tlsKey, &corev1.Secret{})
+				assert.True(t, apierrors.IsNotFound(err))
+			}
+		})
+	}
+}
+
+func TestManager_Reconcile_TLSWithHTTPRoute(t *testing.T) {
+	notAfter := time.Now().Add(90 * 24 * time.Hour).Truncate(time.Second)
+	gw := newTestGateway(func(gw *tacokumogithubiov1alpha1.Gateway) {
+		gw.Spec.Host = "test-app.example.com"
+		gw.Spec.Implementation = tacokumogithubiov1alpha1.GatewayImplementationHTTPRoute
+		gw.Spec.ParentRefs = []tacokumogithubiov1alpha1.GatewayParentReference{
+			{Name: "shared", Namespace: "gateway-system"},
+		}
+	})
+	parent := &unstructured.Unstructured{Object: map[string]any{
+		"status": map[string]any{
+			"addresses": []any{map[string]any{"type": "Hostname", "value": "lb.example.com"}},
+		},
+	}}
+	parent.SetGroupVersionKind(gatewayGVK)
+	parent.SetNamespace("gateway-system")
+	parent.SetName("shared")
+	portal := newTestPortal(&tacokumogithubiov1alpha1.PortalTLS{
+		WildcardCertificateSecret: &tacokumogithubiov1alpha1.NamespacedName{
+			Namespace: "tacokumo-system", Name: "wildcard",
+		},
+	})
+	k8sClient := fake.NewClientBuilder().
+		WithScheme(newTestScheme(t)).
+		WithObjects(
+			newTestApplication(), gw, parent, portal, newTestWildcardSecret(t, "*.example.com", notAfter),
+		).
+		WithStatusSubresource(gw).
+		Build()
+	m := NewManager(logr.Discard(), k8sClient)
+
+	require.NoError(t, m.Reconcile(context.Background(), gw))
+
+	// TLSは接続先のGatewayのリスナーで終端されるため､HTTPRouteのエンドポイントとしてはHTTPを報告する
+	assert.Equal(t, "http://test-app.example.com", gw.Status.URL)
+	assert.Nil(t, gw.Status.TLS)
+	cond := meta.FindStatusCondition(gw.Status.Conditions, tacokumogithubiov1alpha1.ConditionTypeTLSReady)
+	require.NotNil(t, cond)
+	assert.Equal(t, metav1.ConditionFalse, cond.Status)
+	assert.Equal(t, tacokumogithubiov1alpha1.ReasonTLSNotConfigured, cond.Reason)
+	err := k8sClient.Get(context.Background(), client.ObjectKey{Namespace: "default", Name: "test-app-tls"},
+		&corev1.Secret{})
+	assert.True(t, apierrors.IsNotFound(err))
+}
+
+func TestManager_Reconcile_TLSError(t *testing.T) {
+	issuerTLS := &tacokumogithubiov1alpha1.PortalTLS{
+		IssuerRef: &tacokumogithubiov1alpha1.CertificateIssuerReference{Name: "letsencrypt"},
+	}
+	wildcardTLS := &tacokumogithubiov1alpha1.PortalTLS{
+		WildcardCertificateSecret: &tacokumogithubiov1alpha1.NamespacedName{
+			Namespace: "tacokumo-system", Name: "wildcard",
+		},
+	}
+	tests := []struct {
+		name          string
+		tls           *tacokumogithubiov1alpha1.PortalTLS
+		certManager   bool
+		objects       []client.Object
+		expectReason  string
+		expectMessage string
+	}{
+		{
+			name:         "cluster issuer not found",
+			tls:          issuerTLS,
+			certManager:  true,
+			expectReason: tacokumogithubiov1alpha1.ReasonIssuerNotFound,
+			expectMessage: "clusterissuer letsencrypt not found: " +
+				"the certificate for test-app.example.com cannot be issued",
+		},
+		{
+			name:         "cert-manager not installed",
+			tls:          issuerTLS,
+			expectReason: tacokumogithubiov1alpha1.ReasonIssuerNotFound,
+			expectMessage: "cert-manager is not installed in the cluster: " +
+				"the certificate for test-app.example.com cannot be issued",
+		},
+		{
+			name:          "wildcard certificate does not cover the host",
+			tls:           wildcardTLS,
+			objects:       []client.Object{newTestWildcardSecret(t, "*.example.org", time.Now().Add(time.Hour))},
+			expectReason:  tacokumogithubiov1alpha1.ReasonInvalidCertificate,
+			expectMessage: "wildcard certificate tacokumo-system/wildcard does not cover test-app.example.com",
+		},
+		{
+			name:          "wildcard certificate not found",
+			tls:           wildcardTLS,
+			expectReason:  tacokumogithubiov1alpha1.ReasonInvalidCertificate,
+			expectMessage: "wildcard certificate secret tacokumo-system/wildcard not found",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gw := newTestGateway(func(gw *tacokumogithubiov1alpha1.Gateway) {
+				gw.Spec.Host = "test-app.example.com"
+			})
+			objects := append([]client.Object{newTestApplication(), gw, newTestPortal(tt.tls)}, tt.objects...)
+			builder := fake.NewClientBuilder().
+				WithScheme(newTestScheme(t)).
+				WithObjects(objects...).
+				WithStatusSubresource(gw)
+			if tt.certManager {
+				builder = builder.WithRESTMapper(newTestCertManagerRESTMapper())
+			}
+			k8sClient := builder.Build()
+			m := NewManager(logr.Discard(), k8sClient)
+
+			require.Error(t, m.Reconcile(context.Background(), gw))
+
+			// 証明書を用意できない場合は､HTTPで公開しない
+			key := client.ObjectKey{Namespace: "default", Name: "test-app"}
+			err := k8sClient.Get(context.Background(), key, &networkingv1.Ingress{})
+			assert.True(t, apierrors.IsNotFound(err))
+			cond := meta.FindStatusCondition(gw.Status.Conditions, tacokumogithubiov1alpha1.ConditionTypeTLSReady)
+			require.NotNil(t, cond)
+			assert.Equal(t, metav1.ConditionFalse, cond.Status)
+			assert.Equal(t, tt.expectReason, cond.Reason)
+			assert.Equal(t, tt.expectMessage, cond.Message)
+			ready := meta.FindStatusCondition(gw.Status.Conditions, tacokumogithubiov1alpha1.ConditionTypeReady)
+			require.NotNil(t, ready)
+			assert.Equal(t, metav1.ConditionFalse, ready.Status)
+		})
+	}
+}
@@ -3,11 +3,27 @@ package helmutil
 import (
 	"context"
 
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
 func CreateOrUpdateObject(ctx context.Context, k8sClient client.Client, obj *unstructured.Unstructured) error {
+	return createOrUpdateObject(ctx, k8sClient, obj, nil)
+}
+
+// DryRunCreateOrUpdateObject は CreateOrUpdateObject と同じ作成または置き換えをserver-side dry-runで行う
+// obj にはサーバーが返した適用後のオブジェクトが設定され､クラスタ上のオブジェクトは変更されない
+func DryRunCreateOrUpdateObject(ctx context.Context, k8sClient client.Client, obj *unstructured.Unstructured) error {
+	return createOrUpdateObject(ctx, k8sClient, obj, []string{metav1.DryRunAll})
+}
+
+func createOrUpdateObject(
+	ctx context.Context,
+	k8sClient client.Client,
+	obj *unstructured.Unstructured,
+	dryRun []string,
+) error {
 	existingObj := &unstructured.Unstructured{}
 	existingObj.SetGroupVersionKind(obj.GroupVersionKind())
 	err := k8sClient.Get(ctx, client.ObjectKey{
@@ -19,9 +35,9 @@ func CreateOrUpdateObject(ctx context.Context, k8sClient client.Client, obj *uns
 			return err
 		}
 		// Not found, create
-		return k8sClient.Create(ctx, obj)
+		return k8sClient.Create(ctx, obj, &client.CreateOptions{DryRun: dryRun})
 	}
 	// Found, update
 	obj.SetResourceVersion(existingObj.GetResourceVersion())
-	return k8sClient.Update(ctx, obj)
+	return k8sClient.Update(ctx, obj, &client.UpdateOptions{DryRun: dryRun})
 }
@@ -102,3 +102,33 @@ func Test_createOrUpdateObject(t *testing.T) {
 		})
 	}
 }
+
+func TestDryRunCreateOrUpdateObject(t *testing.T) {
+	scheme := runtime.NewScheme()
+	assert.NoError(t, clientgoscheme.AddToScheme(scheme))
+
+	existing := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "test-ns", Name: "test-deploy"},
+		Spec:       appsv1.DeploymentSpec{Replicas: ptr.To(int32(1))},
+	}
+	k8sClient := fake.NewClientBuilder().WithScheme(scheme).WithObjects(existing).Build()
+
+	for _, name := range []string{"test-deploy", "new-deploy"} {
+		obj := &unstructured.Unstructured{}
+		obj.SetGroupVersionKind(appsv1.SchemeGroupVersion.WithKind("Deployment"))
+		obj.SetNamespace("test-ns")
+		obj.SetName(name)
+		assert.NoError(t, unstructured.SetNestedField(obj.Object, int64(5), "spec", "replicas"))
+
+		assert.NoError(t, helmutil.DryRunCreateOrUpdateObject(t.Context(), k8sClient, obj))
+	}
+
+	// クラスタ上のオブジェクトは変更されない
+	result := &appsv1.Deployment{}
+	key := types.NamespacedName{Namespace: "test-ns", Name: "test-deploy"}
+	assert.NoError(t, k8sClient.Get(t.Context(), key, result))
+	assert.Equal(t, int32(1), ptr.Deref(result.Spec.Replicas, 0))
+	key = types.NamespacedName{Namespace: "test-ns", Name: "new-deploy"}
+	err := k8sClient.Get(t.Context(), key, &appsv1.Deployment{})
+	assert.Error(t, err)
+}
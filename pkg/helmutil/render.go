@@ -16,19 +16,15 @@ func RenderChart(chartPath, releaseName, namespace string, values map[string]int
 		return "", err
 	}
 
-	chartValues := chart.Values
-	if chartValues == nil {
-		chartValues = make(map[string]interface{})
-	}
-	chartValues = chartutil.CoalesceTables(values, chartValues)
-
 	options := chartutil.ReleaseOptions{
 		Name:      releaseName,
 		Namespace: namespace,
 		IsInstall: true,
 	}
 
-	valuesToRender, err := chartutil.ToRenderValues(chart, chartValues, options, nil)
+	// チャートのデフォルトのvaluesとのマージはToRenderValuesで行われる
+	// nullを指定したキーはデフォルトから削除される
+	valuesToRender, err := chartutil.ToRenderValues(chart, values, options, nil)
 	if err != nil {
 		return "", err
 	}
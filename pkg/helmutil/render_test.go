@@ -111,3 +111,19 @@ func TestRenderChart_ParseableOutput(t *testing.T) {
 	// 少なくとも1つ以上のKubernetesオブジェクトが生成されていることを確認
 	assert.NotEmpty(t, objects)
 }
+
+// TestRenderChart_NullRemovesDefaultは、nullを指定したキーがチャートのデフォルトから削除され､
+// 以降のレンダリングに影響しないことを確認します。
+func TestRenderChart_NullRemovesDefault(t *testing.T) {
+	manifest, err := RenderChart(testChartPath, "null-test", "default", map[string]interface{}{
+		"main": map[string]interface{}{
+			"image": map[string]interface{}{"pullPolicy": nil},
+		},
+	})
+	require.NoError(t, err)
+	assert.NotContains(t, manifest, "IfNotPresent")
+
+	manifest, err = RenderChart(testChartPath, "null-test", "default", nil)
+	require.NoError(t, err)
+	assert.Contains(t, manifest, "imagePullPolicy: IfNotPresent")
+}
@@ -0,0 +1,85 @@
+package imageresolver
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Credential はレジストリの認証情報を表す
+type Credential struct {
+	Username string
+	Password string
+}
+
+// Credentials はレジストリのホストごとの認証情報を表す
+type Credentials map[string]Credential
+
+// dockerConfigJSON は kubernetes.io/dockerconfigjson 形式のSecretの内容を表す
+type dockerConfigJSON struct {
+	Auths map[string]dockerConfigEntry `json:"auths"`
+}
+
+type dockerConfigEntry struct {
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+	Auth     string `json:"auth,omitempty"`
+}
+
+// ParseDockerConfigJSON は `.dockerconfigjson` の内容から認証情報を読み込む
+func ParseDockerConfigJSON(data []byte) (Credentials, error) {
+	var cfg dockerConfigJSON
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse docker config: %w", err)
+	}
+
+	creds := Credentials{}
+	for server, entry := range cfg.Auths {
+		cred := Credential{Username: entry.Username, Password: entry.Password}
+		if entry.Auth != "" {
+			decoded, err := base64.StdEncoding.DecodeString(entry.Auth)
+			if err != nil {
+				return nil, fmt.Errorf("failed to decode auth for %s: %w", server, err)
+			}
+			username, password, ok := strings.Cut(string(decoded), ":")
+			if !ok {
+				return nil, fmt.Errorf("invalid auth for %s", server)
+			}
+			cred = Credential{Username: username, Password: password}
+		}
+		creds[normalizeRegistry(server)] = cred
+	}
+	return creds, nil
+}
+
+// Merge は other の認証情報を追加する
+// 同じレジストリの認証情報が既にある場合は既存のものを優先する
+func (c Credentials) Merge(other Credentials) {
+	for registry, cred := range other {
+		if _, ok := c[registry]; !ok {
+			c[registry] = cred
+		}
+	}
+}
+
+// Lookup はレジストリに対応する認証情報を返す
+func (c Credentials) Lookup(registry string) (Credential, bool) {
+	cred, ok := c[normalizeRegistry(registry)]
+	return cred, ok
+}
+
+// normalizeRegistry はdocker configのキーをレジストリのホスト名に揃える
+// `https://index.docker.io/v1/` のようなURL形式のキーも受け付ける
+func normalizeRegistry(server string) string {
+	server = strings.TrimPrefix(server, "https://")
+	server = strings.TrimPrefix(server, "http://")
+	if i := strings.Index(server, "/"); i >= 0 {
+		server = server[:i]
+	}
+	switch server {
+	case "index.docker.io", dockerHubAPIHost:
+		return DockerHubRegistry
+	}
+	return server
+}
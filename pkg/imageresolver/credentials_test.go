@@ -0,0 +1,47 @@
+package imageresolver
+
+import (
+	"encoding/base64"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseDockerConfigJSON(t *testing.T) {
+	auth := base64.StdEncoding.EncodeToString([]byte("robot:s3cr3t"))
+	data := []byte(`{"auths":{
+		"https://index.docker.io/v1/": {"auth": "` + auth + `"},
+		"ghcr.io": {"username": "user", "password": "pass"}
+	}}`)
+
+	creds, err := ParseDockerConfigJSON(data)
+	require.NoError(t, err)
+
+	cred, ok := creds.Lookup("docker.io")
+	require.True(t, ok)
+	assert.Equal(t, Credential{Username: "robot", Password: "s3cr3t"}, cred)
+
+	cred, ok = creds.Lookup("ghcr.io")
+	require.True(t, ok)
+	assert.Equal(t, Credential{Username: "user", Password: "pass"}, cred)
+
+	_, ok = creds.Lookup("quay.io")
+	assert.False(t, ok)
+}
+
+func TestParseDockerConfigJSON_InvalidAuth(t *testing.T) {
+	_, err := ParseDockerConfigJSON([]byte(`{"auths":{"ghcr.io":{"auth":"not-base64!"}}}`))
+	assert.Error(t, err)
+}
+
+func TestCredentials_Merge(t *testing.T) {
+	creds := Credentials{"ghcr.io": {Username: "first"}}
+	creds.Merge(Credentials{
+		"ghcr.io": {Username: "second"},
+		"quay.io": {Username: "quay"},
+	})
+
+	assert.Equal(t, "first", creds["ghcr.io"].Username)
+	assert.Equal(t, "quay", creds["quay.io"].Username)
+}
@@ -0,0 +1,86 @@
+package imageresolver
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// Resolver はコンテナイメージの参照をダイジェストに解決するインターフェース
+type Resolver interface {
+	// Resolve は image が指すマニフェストのダイジェスト(sha256:...)を返す
+	Resolve(ctx context.Context, image string, creds Credentials) (string, error)
+}
+
+const (
+	// DockerHubRegistry はレジストリが省略されたイメージの参照先
+	DockerHubRegistry = "docker.io"
+	// dockerHubAPIHost は Docker Hub のレジストリAPIのホスト
+	dockerHubAPIHost = "registry-1.docker.io"
+	defaultTag       = "latest"
+)
+
+// Reference はコンテナイメージの参照を表す
+type Reference struct {
+	// Registry はレジストリのホスト(ポートを含む)
+	Registry string
+	// Repository はレジストリ内のリポジトリ名
+	Repository string
+	// Tag はイメージのタグ
+	// 何も指定されない場合は latest が使用される
+	Tag string
+	// Digest はイメージのダイジェスト
+	// 参照にダイジェストが含まれている場合のみ設定される
+	Digest string
+}
+
+// ParseReference はイメージの参照を解析する
+// `nginx` のようにレジストリが省略された参照は Docker Hub のものとして扱う
+func ParseReference(image string) (Reference, error) {
+	if image == "" {
+		return Reference{}, fmt.Errorf("image reference is empty")
+	}
+
+	ref := Reference{}
+	name := image
+	if i := strings.Index(name, "@"); i >= 0 {
+		ref.Digest = name[i+1:]
+		name = name[:i]
+		if !strings.HasPrefix(ref.Digest, "sha256:") {
+			return Reference{}, fmt.Errorf("image %q: unsupported digest %q", image, ref.Digest)
+		}
+	}
+	// ポート番号の ":" と区別するため､最後の "/" より後ろの ":" のみをタグとみなす
+	if i := strings.LastIndex(name, ":"); i > strings.LastIndex(name, "/") {
+		ref.Tag = name[i+1:]
+		name = name[:i]
+	}
+	if ref.Tag == "" && ref.Digest == "" {
+		ref.Tag = defaultTag
+	}
+
+	first, rest, found := strings.Cut(name, "/")
+	if found && (strings.ContainsAny(first, ".:") || first == "localhost") {
+		ref.Registry = first
+		ref.Repository = rest
+	} else {
+		ref.Registry = DockerHubRegistry
+		ref.Repository = name
+	}
+	if ref.Registry == DockerHubRegistry && !strings.Contains(ref.Repository, "/") {
+		ref.Repository = "library/" + ref.Repository
+	}
+	if ref.Repository == "" {
+		return Reference{}, fmt.Errorf("image %q: repository is empty", image)
+	}
+	return ref, nil
+}
+
+// PinImage は image にダイジェストを付与した参照を返す
+// 可読性のためタグは残すが､コンテナランタイムはダイジェストを優先する
+func PinImage(image string, digest string) string {
+	if i := strings.Index(image, "@"); i >= 0 {
+		image = image[:i]
+	}
+	return image + "@" + digest
+}
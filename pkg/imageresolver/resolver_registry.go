@@ -0,0 +1,271 @@
+package imageresolver
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// defaultRequestTimeout はレジストリへの1回のリクエストのタイムアウト
+// 解決はReleaseのreconcileの中で行われるため､応答しないレジストリでワーカーが止まらないようにする
+const defaultRequestTimeout = 30 * time.Second
+
+// manifestMediaTypes はダイジェストの解決時に受け付けるマニフェストの種類
+// マルチアーキテクチャのイメージはインデックスのダイジェストに解決される
+var manifestMediaTypes = []string{
+	"application/vnd.oci.image.index.v1+json",
+	"application/vnd.docker.distribution.manifest.list.v2+json",
+	"application/vnd.oci.image.manifest.v1+json",
+	"application/vnd.docker.distribution.manifest.v2+json",
+}
+
+// RegistryResolver は OCI Distribution API を使用する Resolver の実装
+type RegistryResolver struct {
+	httpClient *http.Client
+	// plainHTTPRegistries はTLSを使用せずに接続するレジストリ
+	plainHTTPRegistries map[string]struct{}
+}
+
+// NewRegistryResolver は RegistryResolver を生成する
+func NewRegistryResolver() *RegistryResolver {
+	return &RegistryResolver{
+		httpClient:          &http.Client{Timeout: defaultRequestTimeout},
+		plainHTTPRegistries: map[string]struct{}{},
+	}
+}
+
+// WithHTTPClient はレジストリとの通信に使用する http.Client を設定する
+func (r *RegistryResolver) WithHTTPClient(c *http.Client) *RegistryResolver {
+	r.httpClient = c
+	return r
+}
+
+// WithPlainHTTPRegistries はTLSを使用せずに接続するレジストリを設定する
+// localhost と loopback アドレスのレジストリは指定しなくても平文で接続する
+func (r *RegistryResolver) WithPlainHTTPRegistries(registries ...string) *RegistryResolver {
+	for _, registry := range registries {
+		r.plainHTTPRegistries[registry] = struct{}{}
+	}
+	return r
+}
+
+// Resolve は image が指すマニフェストのダイジェストを返す
+// 参照にダイジェストが含まれている場合はレジストリに問い合わせずにそれを返す
+func (r *RegistryResolver) Resolve(ctx context.Context, image string, creds Credentials) (string, error) {
+	ref, err := ParseReference(image)
+	if err != nil {
+		return "", err
+	}
+	if ref.Digest != "" {
+		return ref.Digest, nil
+	}
+
+	manifestURL := fmt.Sprintf("%s/v2/%s/manifests/%s", r.baseURL(ref.Registry), ref.Repository, ref.Tag)
+	cred, hasCred := creds.Lookup(ref.Registry)
+
+	authorization := ""
+	resp, err := r.requestManifest(ctx, http.MethodHead, manifestURL, authorization)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode == http.StatusUnauthorized {
+		challenge := resp.Header.Get("WWW-Authenticate")
+		_ = resp.Body.Close()
+
+		authorization, err = r.authorize(ctx, challenge, ref, cred, hasCred)
+		if err != nil {
+			return "", err
+		}
+		resp, err = r.requestManifest(ctx, http.MethodHead, manifestURL, authorization)
+		if err != nil {
+			return "", err
+		}
+	}
+	defer func() { _ = resp.Body.Close() }()
+	return r.digestFromResponse(ctx, resp, manifestURL, authorization, image)
+}
+
+// digestFromResponse はマニフェストのレスポンスからダイジェストを取り出す
+// Docker-Content-Digest ヘッダを返さないレジストリのために､その場合はマニフェスト本体から計算する
+func (r *RegistryResolver) digestFromResponse(
+	ctx context.Context,
+	resp *http.Response,
+	manifestURL string,
+	authorization string,
+	image string,
+) (string, error) {
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to resolve image %s: registry returned %s", image, resp.Status)
+	}
+	if digest := resp.Header.Get("Docker-Content-Digest"); digest != "" {
+		return digest, nil
+	}
+
+	getResp, err := r.requestManifest(ctx, http.MethodGet, manifestURL, authorization)
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = getResp.Body.Close() }()
+	if getResp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to resolve image %s: registry returned %s", image, getResp.Status)
+	}
+	body, err := io.ReadAll(getResp.Body)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(body)
+	return "sha256:" + hex.EncodeToString(sum[:]), nil
+}
+
+func (r *RegistryResolver) requestManifest(
+	ctx context.Context,
+	method string,
+	manifestURL string,
+	authorization string,
+) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, manifestURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", strings.Join(manifestMediaTypes, ", "))
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+	return r.httpClient.Do(req)
+}
+
+// authorize は WWW-Authenticate ヘッダのチャレンジに応じた Authorization ヘッダの値を返す
+func (r *RegistryResolver) authorize(
+	ctx context.Context,
+	challenge string,
+	ref Reference,
+	cred Credential,
+	hasCred bool,
+) (string, error) {
+	scheme, params := parseChallenge(challenge)
+	switch strings.ToLower(scheme) {
+	case "basic":
+		if !hasCred {
+			return "", fmt.Errorf("registry %s requires credentials", ref.Registry)
+		}
+		req := &http.Request{Header: http.Header{}}
+		req.SetBasicAuth(cred.Username, cred.Password)
+		return req.Header.Get("Authorization"), nil
+	case "bearer":
+		token, err := r.fetchToken(ctx, params, ref, cred, hasCred)
+		if err != nil {
+			return "", err
+		}
+		return "Bearer " + token, nil
+	default:
+		return "", fmt.Errorf("registry %s: unsupported auth challenge %q", ref.Registry, challenge)
+	}
+}
+
+// fetchToken はトークンサーバからリポジトリのpull権限を持つトークンを取得する
+func (r *RegistryResolver) fetchToken(
+	ctx context.Context,
+	params map[string]string,
+	ref Reference,
+	cred Credential,
+	hasCred bool,
+) (string, error) {
+	realm := params["realm"]
+	if realm == "" {
+		return "", fmt.Errorf("registry %s: bearer challenge has no realm", ref.Registry)
+	}
+	tokenURL, err := url.Parse(realm)
+	if err != nil {
+		return "", fmt.Errorf("registry %s: invalid realm %q: %w", ref.Registry, realm, err)
+	}
+	query := tokenURL.Query()
+	if service := params["service"]; service != "" {
+		query.Set("service", service)
+	}
+	scope := params["scope"]
+	if scope == "" {
+		scope = fmt.Sprintf("repository:%s:pull", ref.Repository)
+	}
+	query.Set("scope", scope)
+	tokenURL.RawQuery = query.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, tokenURL.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	if hasCred {
+		req.SetBasicAuth(cred.Username, cred.Password)
+	}
+	resp, err := r.httpClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("registry %s: token request returned %s", ref.Registry, resp.Status)
+	}
+
+	var body struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", fmt.Errorf("registry %s: failed to decode token response: %w", ref.Registry, err)
+	}
+	if body.Token != "" {
+		return body.Token, nil
+	}
+	if body.AccessToken != "" {
+		return body.AccessToken, nil
+	}
+	return "", fmt.Errorf("registry %s: token response has no token", ref.Registry)
+}
+
+func (r *RegistryResolver) baseURL(registry string) string {
+	if registry == DockerHubRegistry {
+		return "https://" + dockerHubAPIHost
+	}
+	if _, ok := r.plainHTTPRegistries[registry]; ok || isLoopbackRegistry(registry) {
+		return "http://" + registry
+	}
+	return "https://" + registry
+}
+
+func isLoopbackRegistry(registry string) bool {
+	host := registry
+	if h, _, err := net.SplitHostPort(registry); err == nil {
+		host = h
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// parseChallenge は `Bearer realm="...",service="..."` 形式のチャレンジを解析する
+func parseChallenge(challenge string) (string, map[string]string) {
+	scheme, rest, _ := strings.Cut(strings.TrimSpace(challenge), " ")
+	params := map[string]string{}
+	for rest != "" {
+		var key, value string
+		key, rest, _ = strings.Cut(strings.TrimLeft(rest, " ,"), "=")
+		if strings.HasPrefix(rest, `"`) {
+			value, rest, _ = strings.Cut(rest[1:], `"`)
+		} else {
+			value, rest, _ = strings.Cut(rest, ",")
+		}
+		if key != "" {
+			params[strings.ToLower(strings.TrimSpace(key))] = value
+		}
+	}
+	return scheme, params
+}
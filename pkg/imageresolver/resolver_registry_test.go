@@ -0,0 +1,213 @@
+package imageresolver
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testManifest = `{"schemaVersion":2,"mediaType":"application/vnd.oci.image.manifest.v1+json"}`
+
+func testManifestDigest() string {
+	sum := sha256.Sum256([]byte(testManifest))
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// testRegistry はテスト用のローカルレジストリ
+type testRegistry struct {
+	// auth は "", "basic", "bearer" のいずれか
+	auth string
+	// omitDigestHeader は Docker-Content-Digest ヘッダを返さないレジストリを模倣する
+	omitDigestHeader bool
+	// tags はリポジトリとタグの組み合わせ(<repo>:<tag>)
+	tags map[string]struct{}
+}
+
+func (r *testRegistry) start(t *testing.T) *httptest.Server {
+	t.Helper()
+	mux := http.NewServeMux()
+	var server *httptest.Server
+
+	mux.HandleFunc("/token", func(w http.ResponseWriter, req *http.Request) {
+		user, pass, ok := req.BasicAuth()
+		if !ok || user != "robot" || pass != "s3cr3t" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if req.URL.Query().Get("scope") != "repository:team/app:pull" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		_, _ = fmt.Fprint(w, `{"token":"test-token"}`)
+	})
+	mux.HandleFunc("/v2/", func(w http.ResponseWriter, req *http.Request) {
+		switch r.auth {
+		case "basic":
+			user, pass, ok := req.BasicAuth()
+			if !ok || user != "robot" || pass != "s3cr3t" {
+				w.Header().Set("WWW-Authenticate", `Basic realm="registry"`)
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+		case "bearer":
+			if req.Header.Get("Authorization") != "Bearer test-token" {
+				w.Header().Set("WWW-Authenticate",
+					fmt.Sprintf(`Bearer realm="%s/token",service="test-registry"`, server.URL))
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+		}
+
+		repo, tag, ok := strings.Cut(strings.TrimPrefix(req.URL.Path, "/v2/"), "/manifests/")
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if _, ok := r.tags[repo+":"+tag]; !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if !strings.Contains(req.Header.Get("Accept"), "application/vnd.oci.image.index.v1+json") {
+			w.WriteHeader(http.StatusNotAcceptable)
+			return
+		}
+		w.Header().Set("Content-Type", "application/vnd.oci.image.manifest.v1+json")
+		if !r.omitDigestHeader {
+			w.Header().Set("Docker-Content-Digest", testManifestDigest())
+		}
+		if req.Method == http.MethodGet {
+			_, _ = fmt.Fprint(w, testManifest)
+		}
+	})
+
+	server = httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestRegistryResolver_Resolve(t *testing.T) {
+	tests := []struct {
+		name         string
+		registry     testRegistry
+		image        string
+		withCreds    bool
+		expectErrMsg string
+	}{
+		{
+			name:     "resolves tag on anonymous registry",
+			registry: testRegistry{tags: map[string]struct{}{"team/app:v1": {}}},
+			image:    "team/app:v1",
+		},
+		{
+			name:     "computes digest when header is missing",
+			registry: testRegistry{omitDigestHeader: true, tags: map[string]struct{}{"team/app:latest": {}}},
+			image:    "team/app",
+		},
+		{
+			name:      "authenticates with basic auth",
+			registry:  testRegistry{auth: "basic", tags: map[string]struct{}{"team/app:v1": {}}},
+			image:     "team/app:v1",
+			withCreds: true,
+		},
+		{
+			name:      "authenticates with bearer token",
+			registry:  testRegistry{auth: "bearer", tags: map[string]struct{}{"team/app:v1": {}}},
+			image:     "team/app:v1",
+			withCreds: true,
+		},
+		{
+			name:         "fails without credentials on private registry",
+			registry:     testRegistry{auth: "basic", tags: map[string]struct{}{"team/app:v1": {}}},
+			image:        "team/app:v1",
+			expectErrMsg: "requires credentials",
+		},
+		{
+			name:         "fails when token request is rejected",
+			registry:     testRegistry{auth: "bearer", tags: map[string]struct{}{"team/app:v1": {}}},
+			image:        "team/app:v1",
+			expectErrMsg: "token request returned 401",
+		},
+		{
+			name:         "fails when tag does not exist",
+			registry:     testRegistry{tags: map[string]struct{}{}},
+			image:        "team/app:v1",
+			expectErrMsg: "registry returned 404",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := tt.registry.start(t)
+			host := strings.TrimPrefix(server.URL, "http://")
+
+			creds := Credentials{}
+			if tt.withCreds {
+				creds[host] = Credential{Username: "robot", Password: "s3cr3t"}
+			}
+
+			resolver := NewRegistryResolver().WithHTTPClient(server.Client())
+			digest, err := resolver.Resolve(context.Background(), host+"/"+tt.image, creds)
+			if tt.expectErrMsg != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.expectErrMsg)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, testManifestDigest(), digest)
+		})
+	}
+}
+
+func TestRegistryResolver_Resolve_DigestReference(t *testing.T) {
+	// ダイジェストが指定されている場合はレジストリに問い合わせない
+	resolver := NewRegistryResolver().WithHTTPClient(&http.Client{
+		Transport: roundTripFunc(func(*http.Request) (*http.Response, error) {
+			return nil, fmt.Errorf("unexpected request")
+		}),
+	})
+	digest, err := resolver.Resolve(context.Background(), "ghcr.io/tacokumo/app:v1@sha256:abc", nil)
+	require.NoError(t, err)
+	assert.Equal(t, "sha256:abc", digest)
+}
+
+func TestNewRegistryResolver_Timeout(t *testing.T) {
+	// 応答しないレジストリでreconcileが止まらないよう､デフォルトのクライアントはタイムアウトを持つ
+	resolver := NewRegistryResolver()
+	assert.Equal(t, defaultRequestTimeout, resolver.httpClient.Timeout)
+}
+
+func TestRegistryResolver_baseURL(t *testing.T) {
+	resolver := NewRegistryResolver().WithPlainHTTPRegistries("registry.internal:5000")
+
+	assert.Equal(t, "https://registry-1.docker.io", resolver.baseURL("docker.io"))
+	assert.Equal(t, "https://ghcr.io", resolver.baseURL("ghcr.io"))
+	assert.Equal(t, "http://localhost:5000", resolver.baseURL("localhost:5000"))
+	assert.Equal(t, "http://127.0.0.1:5000", resolver.baseURL("127.0.0.1:5000"))
+	assert.Equal(t, "http://registry.internal:5000", resolver.baseURL("registry.internal:5000"))
+}
+
+func TestParseChallenge(t *testing.T) {
+	scheme, params := parseChallenge(
+		`Bearer realm="https://auth.example.com/token",service="registry.example.com",scope="repository:app:pull"`,
+	)
+	assert.Equal(t, "Bearer", scheme)
+	assert.Equal(t, map[string]string{
+		"realm":   "https://auth.example.com/token",
+		"service": "registry.example.com",
+		"scope":   "repository:app:pull",
+	}, params)
+}
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
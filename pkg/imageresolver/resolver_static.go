@@ -0,0 +1,34 @@
+package imageresolver
+
+import (
+	"context"
+	"fmt"
+)
+
+// StaticResolver は事前に設定したダイジェストを返すテスト用の Resolver 実装
+type StaticResolver struct {
+	// digests はイメージの参照とダイジェストのマッピング
+	digests map[string]string
+}
+
+// NewStaticResolver は StaticResolver を生成する
+func NewStaticResolver(digests map[string]string) *StaticResolver {
+	return &StaticResolver{digests: digests}
+}
+
+// Resolve は設定されたダイジェストを返す
+// 参照にダイジェストが含まれている場合はそれを返す
+func (r *StaticResolver) Resolve(_ context.Context, image string, _ Credentials) (string, error) {
+	ref, err := ParseReference(image)
+	if err != nil {
+		return "", err
+	}
+	if ref.Digest != "" {
+		return ref.Digest, nil
+	}
+	digest, ok := r.digests[image]
+	if !ok {
+		return "", fmt.Errorf("image %q not found in configured digests", image)
+	}
+	return digest, nil
+}
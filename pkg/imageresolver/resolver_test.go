@@ -0,0 +1,91 @@
+package imageresolver
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseReference(t *testing.T) {
+	tests := []struct {
+		name      string
+		image     string
+		expected  Reference
+		expectErr bool
+	}{
+		{
+			name:  "defaults to docker hub library and latest",
+			image: "nginx",
+			expected: Reference{
+				Registry:   "docker.io",
+				Repository: "library/nginx",
+				Tag:        "latest",
+			},
+		},
+		{
+			name:  "docker hub user repository",
+			image: "tacokumo/app:v1",
+			expected: Reference{
+				Registry:   "docker.io",
+				Repository: "tacokumo/app",
+				Tag:        "v1",
+			},
+		},
+		{
+			name:  "registry with port",
+			image: "localhost:5000/team/app:v1.2.3",
+			expected: Reference{
+				Registry:   "localhost:5000",
+				Repository: "team/app",
+				Tag:        "v1.2.3",
+			},
+		},
+		{
+			name:  "registry with port and no tag",
+			image: "registry.example.com:5000/app",
+			expected: Reference{
+				Registry:   "registry.example.com:5000",
+				Repository: "app",
+				Tag:        "latest",
+			},
+		},
+		{
+			name:  "tag and digest",
+			image: "ghcr.io/tacokumo/app:v1@sha256:abc",
+			expected: Reference{
+				Registry:   "ghcr.io",
+				Repository: "tacokumo/app",
+				Tag:        "v1",
+				Digest:     "sha256:abc",
+			},
+		},
+		{
+			name:      "rejects empty reference",
+			image:     "",
+			expectErr: true,
+		},
+		{
+			name:      "rejects unsupported digest algorithm",
+			image:     "ghcr.io/tacokumo/app@md5:abc",
+			expectErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ref, err := ParseReference(tt.image)
+			if tt.expectErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, ref)
+		})
+	}
+}
+
+func TestPinImage(t *testing.T) {
+	assert.Equal(t, "ghcr.io/tacokumo/app:v1@sha256:new", PinImage("ghcr.io/tacokumo/app:v1", "sha256:new"))
+	assert.Equal(t, "ghcr.io/tacokumo/app:v1@sha256:new", PinImage("ghcr.io/tacokumo/app:v1@sha256:old", "sha256:new"))
+}
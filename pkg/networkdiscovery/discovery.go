@@ -0,0 +1,155 @@
+package networkdiscovery
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	tacokumogithubiov1alpha1 "github.com/tacokumo/portal-controller-kubernetes/api/v1alpha1"
+
+	"github.com/samber/lo"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/discovery"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// GatewayClassGVK はGateway APIのGatewayClassを示す
+// Gateway APIのCRDはクラスタに存在しない場合があるため､型を持たずunstructuredとして扱う
+var GatewayClassGVK = schema.GroupVersionKind{
+	Group:   "gateway.networking.k8s.io",
+	Version: "v1",
+	Kind:    "GatewayClass",
+}
+
+// Discoverer はクラスタで利用可能なIngressとGateway APIのコントローラーを検出する
+type Discoverer struct {
+	discovery discovery.DiscoveryInterface
+	k8sClient client.Client
+}
+
+func NewDiscoverer(discovery discovery.DiscoveryInterface, k8sClient client.Client) *Discoverer {
+	return &Discoverer{
+		discovery: discovery,
+		k8sClient: k8sClient,
+	}
+}
+
+// Discover はAPIの提供状況をdiscoveryで確認し､提供されている場合はIngressClassとGatewayClassを列挙する
+func (d *Discoverer) Discover(ctx context.Context) (*tacokumogithubiov1alpha1.NetworkingStatus, error) {
+	status := &tacokumogithubiov1alpha1.NetworkingStatus{
+		LastDiscoveredTime: ptr.To(metav1.Now()),
+	}
+
+	ingressAvailable, err := d.IsAvailable(networkingv1.SchemeGroupVersion.String(), "ingresses", "ingressclasses")
+	if err != nil {
+		return nil, err
+	}
+	if ingressAvailable {
+		status.Ingress.Available = true
+		if status.Ingress.Classes, err = d.ingressClasses(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	gatewayAvailable, err := d.IsAvailable(GatewayClassGVK.GroupVersion().String(), "httproutes", "gatewayclasses")
+	if err != nil {
+		return nil, err
+	}
+	if gatewayAvailable {
+		status.GatewayAPI.Available = true
+		if status.GatewayAPI.Classes, err = d.gatewayClasses(ctx); err != nil {
+			return nil, err
+		}
+	}
+	return status, nil
+}
+
+// Reconcile は検出結果を全てのPortalの status.networking に記録する
+// 検出結果が変化していない場合は更新しない
+func (d *Discoverer) Reconcile(ctx context.Context) error {
+	status, err := d.Discover(ctx)
+	if err != nil {
+		return err
+	}
+
+	portals := &tacokumogithubiov1alpha1.PortalList{}
+	if err := d.k8sClient.List(ctx, portals); err != nil {
+		return err
+	}
+	for i := range portals.Items {
+		p := &portals.Items[i]
+		if p.Status.Networking != nil && equality.Semantic.DeepEqual(
+			p.Status.Networking.Ingress, status.Ingress) && equality.Semantic.DeepEqual(
+			p.Status.Networking.GatewayAPI, status.GatewayAPI) {
+			continue
+		}
+		patch := client.MergeFrom(p.DeepCopy())
+		p.Status.Networking = status
+		if err := d.k8sClient.Status().Patch(ctx, p, patch); err != nil {
+			return fmt.Errorf("failed to update networking status of portal %s: %w", p.Name, err)
+		}
+	}
+	return nil
+}
+
+// IsAvailable は groupVersion の resources がすべてクラスタで提供されているかどうかを返す
+func (d *Discoverer) IsAvailable(groupVersion string, resources ...string) (bool, error) {
+	list, err := d.discovery.ServerResourcesForGroupVersion(groupVersion)
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return lo.EveryBy(resources, func(resource string) bool {
+		return lo.ContainsBy(list.APIResources, func(r metav1.APIResource) bool {
+			return r.Name == resource
+		})
+	}), nil
+}
+
+func (d *Discoverer) ingressClasses(ctx context.Context) ([]tacokumogithubiov1alpha1.NetworkingClass, error) {
+	list := &networkingv1.IngressClassList{}
+	if err := d.k8sClient.List(ctx, list); err != nil {
+		return nil, err
+	}
+	classes := lo.Map(list.Items, func(c networkingv1.IngressClass, _ int) tacokumogithubiov1alpha1.NetworkingClass {
+		return tacokumogithubiov1alpha1.NetworkingClass{
+			Name:       c.Name,
+			Controller: c.Spec.Controller,
+			Default:    c.Annotations[networkingv1.AnnotationIsDefaultIngressClass] == "true",
+		}
+	})
+	sortClasses(classes)
+	return classes, nil
+}
+
+func (d *Discoverer) gatewayClasses(ctx context.Context) ([]tacokumogithubiov1alpha1.NetworkingClass, error) {
+	list := &unstructured.UnstructuredList{}
+	list.SetGroupVersionKind(GatewayClassGVK.GroupVersion().WithKind(GatewayClassGVK.Kind + "List"))
+	if err := d.k8sClient.List(ctx, list); err != nil {
+		return nil, err
+	}
+	classes := lo.Map(list.Items, func(c unstructured.Unstructured, _ int) tacokumogithubiov1alpha1.NetworkingClass {
+		controller, _, _ := unstructured.NestedString(c.Object, "spec", "controllerName")
+		return tacokumogithubiov1alpha1.NetworkingClass{
+			Name:       c.GetName(),
+			Controller: controller,
+		}
+	})
+	sortClasses(classes)
+	return classes, nil
+}
+
+// sortClasses は検出結果の比較が安定するよう､クラスを名前順に並べる
+func sortClasses(classes []tacokumogithubiov1alpha1.NetworkingClass) {
+	sort.Slice(classes, func(i, j int) bool {
+		return classes[i].Name < classes[j].Name
+	})
+}
@@ -0,0 +1,141 @@
+package networkdiscovery
+
+import (
+	"context"
+	"testing"
+
+	tacokumogithubiov1alpha1 "github.com/tacokumo/portal-controller-kubernetes/api/v1alpha1"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	k8sruntime "k8s.io/apimachinery/pkg/runtime"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	clienttesting "k8s.io/client-go/testing"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func newTestScheme(t *testing.T) *k8sruntime.Scheme {
+	t.Helper()
+	scheme := k8sruntime.NewScheme()
+	require.NoError(t, tacokumogithubiov1alpha1.AddToScheme(scheme))
+	require.NoError(t, networkingv1.AddToScheme(scheme))
+	return scheme
+}
+
+func newFakeDiscovery(resources ...*metav1.APIResourceList) *fakediscovery.FakeDiscovery {
+	return &fakediscovery.FakeDiscovery{Fake: &clienttesting.Fake{Resources: resources}}
+}
+
+var (
+	ingressResources = &metav1.APIResourceList{
+		GroupVersion: "networking.k8s.io/v1",
+		APIResources: []metav1.APIResource{{Name: "ingresses"}, {Name: "ingressclasses"}},
+	}
+	gatewayResources = &metav1.APIResourceList{
+		GroupVersion: "gateway.networking.k8s.io/v1",
+		APIResources: []metav1.APIResource{{Name: "gateways"}, {Name: "httproutes"}, {Name: "gatewayclasses"}},
+	}
+)
+
+func newGatewayClass(name, controller string) *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{Object: map[string]any{
+		"spec": map[string]any{"controllerName": controller},
+	}}
+	obj.SetGroupVersionKind(GatewayClassGVK)
+	obj.SetName(name)
+	return obj
+}
+
+func TestDiscoverer_Discover(t *testing.T) {
+	tests := []struct {
+		name             string
+		resources        []*metav1.APIResourceList
+		objects          []client.Object
+		expectIngress    tacokumogithubiov1alpha1.NetworkingAPIStatus
+		expectGatewayAPI tacokumogithubiov1alpha1.NetworkingAPIStatus
+	}{
+		{
+			name:      "ingress classes without gateway API",
+			resources: []*metav1.APIResourceList{ingressResources},
+			objects: []client.Object{
+				&networkingv1.IngressClass{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:        "nginx",
+						Annotations: map[string]string{networkingv1.AnnotationIsDefaultIngressClass: "true"},
+					},
+					Spec: networkingv1.IngressClassSpec{Controller: "k8s.io/ingress-nginx"},
+				},
+				&networkingv1.IngressClass{
+					ObjectMeta: metav1.ObjectMeta{Name: "alb"},
+					Spec:       networkingv1.IngressClassSpec{Controller: "ingress.k8s.aws/alb"},
+				},
+			},
+			expectIngress: tacokumogithubiov1alpha1.NetworkingAPIStatus{
+				Available: true,
+				Classes: []tacokumogithubiov1alpha1.NetworkingClass{
+					{Name: "alb", Controller: "ingress.k8s.aws/alb"},
+					{Name: "nginx", Controller: "k8s.io/ingress-nginx", Default: true},
+				},
+			},
+		},
+		{
+			name:      "gateway API with gateway classes",
+			resources: []*metav1.APIResourceList{ingressResources, gatewayResources},
+			objects:   []client.Object{newGatewayClass("envoy", "gateway.envoyproxy.io/gatewayclass-controller")},
+			expectIngress: tacokumogithubiov1alpha1.NetworkingAPIStatus{
+				Available: true,
+				Classes:   []tacokumogithubiov1alpha1.NetworkingClass{},
+			},
+			expectGatewayAPI: tacokumogithubiov1alpha1.NetworkingAPIStatus{
+				Available: true,
+				Classes: []tacokumogithubiov1alpha1.NetworkingClass{
+					{Name: "envoy", Controller: "gateway.envoyproxy.io/gatewayclass-controller"},
+				},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k8sClient := fake.NewClientBuilder().
+				WithScheme(newTestScheme(t)).
+				WithObjects(tt.objects...).
+				Build()
+			d := NewDiscoverer(newFakeDiscovery(tt.resources...), k8sClient)
+
+			status, err := d.Discover(context.Background())
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectIngress, status.Ingress)
+			assert.Equal(t, tt.expectGatewayAPI, status.GatewayAPI)
+			assert.NotNil(t, status.LastDiscoveredTime)
+		})
+	}
+}
+
+func TestDiscoverer_Reconcile(t *testing.T) {
+	portal := &tacokumogithubiov1alpha1.Portal{ObjectMeta: metav1.ObjectMeta{Name: "portal"}}
+	k8sClient := fake.NewClientBuilder().
+		WithScheme(newTestScheme(t)).
+		WithObjects(portal).
+		WithStatusSubresource(portal).
+		Build()
+	d := NewDiscoverer(newFakeDiscovery(ingressResources), k8sClient)
+
+	require.NoError(t, d.Reconcile(context.Background()))
+
+	updated := &tacokumogithubiov1alpha1.Portal{}
+	require.NoError(t, k8sClient.Get(context.Background(), client.ObjectKey{Name: "portal"}, updated))
+	require.NotNil(t, updated.Status.Networking)
+	assert.True(t, updated.Status.Networking.Ingress.Available)
+	assert.False(t, updated.Status.Networking.GatewayAPI.Available)
+
+	// 検出結果が変わらない場合は更新しない
+	require.NoError(t, d.Reconcile(context.Background()))
+	unchanged := &tacokumogithubiov1alpha1.Portal{}
+	require.NoError(t, k8sClient.Get(context.Background(), client.ObjectKey{Name: "portal"}, unchanged))
+	assert.Equal(t, updated.ResourceVersion, unchanged.ResourceVersion)
+}
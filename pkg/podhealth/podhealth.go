@@ -0,0 +1,147 @@
+package podhealth
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	tacokumogithubiov1alpha1 "github.com/tacokumo/portal-controller-kubernetes/api/v1alpha1"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// failingReasons はコンテナが自力で回復しない可能性が高い待機理由
+var failingReasons = map[string]struct{}{
+	"CrashLoopBackOff":           {},
+	"ImagePullBackOff":           {},
+	"ErrImagePull":               {},
+	"InvalidImageName":           {},
+	"CreateContainerConfigError": {},
+	"CreateContainerError":       {},
+	"RunContainerError":          {},
+}
+
+// Summarize はPodの一覧をStatusに記録する形式に変換する
+// 結果は名前順に並ぶ
+func Summarize(pods []corev1.Pod) []tacokumogithubiov1alpha1.PodReference {
+	refs := make([]tacokumogithubiov1alpha1.PodReference, 0, len(pods))
+	for _, pod := range pods {
+		ref := tacokumogithubiov1alpha1.PodReference{
+			NamespacedName: tacokumogithubiov1alpha1.NamespacedName{
+				Namespace: pod.Namespace,
+				Name:      pod.Name,
+			},
+			Ready: isPodReady(&pod),
+			Phase: string(pod.Status.Phase),
+		}
+		statuses := append(
+			append([]corev1.ContainerStatus{}, pod.Status.InitContainerStatuses...),
+			pod.Status.ContainerStatuses...,
+		)
+		for _, cs := range statuses {
+			ref.RestartCount += cs.RestartCount
+			if ref.Reason == "" {
+				ref.Reason = failureReason(cs)
+			}
+			if ref.LastTerminationReason == "" && cs.LastTerminationState.Terminated != nil {
+				ref.LastTerminationReason = cs.LastTerminationState.Terminated.Reason
+			}
+		}
+		refs = append(refs, ref)
+	}
+	sort.Slice(refs, func(i, j int) bool {
+		return refs[i].Name < refs[j].Name
+	})
+	return refs
+}
+
+// SetConditions はPodの状態から PodsReady と Degraded のConditionを設定する
+// リソース自体の状態を示す Ready のReasonを上書きしないよう､Podの状態は別のConditionに記録する
+// Podが一つもない場合は PodsReady にならない
+// 全てのPodがReadyの場合に true を返す
+func SetConditions(
+	conditions *[]metav1.Condition,
+	generation int64,
+	pods []tacokumogithubiov1alpha1.PodReference,
+) bool {
+	var notReady, failing []string
+	for _, p := range pods {
+		if !p.Ready {
+			notReady = append(notReady, p.Name)
+		}
+		if p.Reason != "" || p.Phase == string(corev1.PodFailed) {
+			failing = append(failing, fmt.Sprintf("%s (%s)", p.Name, describe(p)))
+		}
+	}
+
+	ready := len(pods) > 0 && len(notReady) == 0
+	podsReady := metav1.Condition{
+		Type:               tacokumogithubiov1alpha1.ConditionTypePodsReady,
+		Status:             metav1.ConditionFalse,
+		ObservedGeneration: generation,
+		Reason:             tacokumogithubiov1alpha1.ReasonPodsNotReady,
+	}
+	switch {
+	case ready:
+		podsReady.Status = metav1.ConditionTrue
+		podsReady.Reason = tacokumogithubiov1alpha1.ReasonPodsReady
+		podsReady.Message = fmt.Sprintf("%d/%d pods are ready", len(pods), len(pods))
+	case len(pods) == 0:
+		podsReady.Message = "no pods found"
+	default:
+		podsReady.Message = fmt.Sprintf("%d/%d pods are ready, not ready: %s",
+			len(pods)-len(notReady), len(pods), strings.Join(notReady, ", "))
+	}
+	meta.SetStatusCondition(conditions, podsReady)
+
+	degraded := metav1.Condition{
+		Type:               tacokumogithubiov1alpha1.ConditionTypeDegraded,
+		Status:             metav1.ConditionFalse,
+		ObservedGeneration: generation,
+		Reason:             tacokumogithubiov1alpha1.ReasonPodsHealthy,
+		Message:            "no pods are failing",
+	}
+	if len(failing) > 0 {
+		degraded.Status = metav1.ConditionTrue
+		degraded.Reason = tacokumogithubiov1alpha1.ReasonPodsFailing
+		degraded.Message = "failing pods: " + strings.Join(failing, ", ")
+	}
+	meta.SetStatusCondition(conditions, degraded)
+	return ready
+}
+
+func describe(p tacokumogithubiov1alpha1.PodReference) string {
+	reason := p.Reason
+	if reason == "" {
+		reason = p.Phase
+	}
+	if p.LastTerminationReason != "" {
+		reason = fmt.Sprintf("%s, last terminated: %s", reason, p.LastTerminationReason)
+	}
+	return fmt.Sprintf("%s, restarts: %d", reason, p.RestartCount)
+}
+
+// failureReason はコンテナが失敗している場合にその理由を返す
+// ContainerCreating のような一時的な待機理由は失敗とみなさない
+func failureReason(cs corev1.ContainerStatus) string {
+	if w := cs.State.Waiting; w != nil {
+		if _, ok := failingReasons[w.Reason]; ok {
+			return w.Reason
+		}
+	}
+	if t := cs.State.Terminated; t != nil && t.ExitCode != 0 {
+		return t.Reason
+	}
+	return ""
+}
+
+func isPodReady(pod *corev1.Pod) bool {
+	for _, c := range pod.Status.Conditions {
+		if c.Type == corev1.PodReady {
+			return c.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
@@ -0,0 +1,137 @@
+package podhealth
+
+import (
+	"testing"
+
+	tacokumogithubiov1alpha1 "github.com/tacokumo/portal-controller-kubernetes/api/v1alpha1"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func newPod(name string, ready bool, statuses ...corev1.ContainerStatus) corev1.Pod {
+	readyStatus := corev1.ConditionFalse
+	if ready {
+		readyStatus = corev1.ConditionTrue
+	}
+	return corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name},
+		Status: corev1.PodStatus{
+			Phase:             corev1.PodRunning,
+			Conditions:        []corev1.PodCondition{{Type: corev1.PodReady, Status: readyStatus}},
+			ContainerStatuses: statuses,
+		},
+	}
+}
+
+func TestSummarize(t *testing.T) {
+	pods := []corev1.Pod{
+		newPod("b", false, corev1.ContainerStatus{
+			State: corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: "ContainerCreating"}},
+		}),
+		newPod("a", true,
+			corev1.ContainerStatus{RestartCount: 1, LastTerminationState: corev1.ContainerState{
+				Terminated: &corev1.ContainerStateTerminated{Reason: "OOMKilled", ExitCode: 137},
+			}},
+			corev1.ContainerStatus{RestartCount: 2},
+		),
+		newPod("c", false, corev1.ContainerStatus{
+			State: corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: "ImagePullBackOff"}},
+		}),
+	}
+
+	refs := Summarize(pods)
+
+	assert.Equal(t, []tacokumogithubiov1alpha1.PodReference{
+		{
+			NamespacedName:        tacokumogithubiov1alpha1.NamespacedName{Namespace: "default", Name: "a"},
+			Ready:                 true,
+			Phase:                 "Running",
+			RestartCount:          3,
+			LastTerminationReason: "OOMKilled",
+		},
+		{
+			NamespacedName: tacokumogithubiov1alpha1.NamespacedName{Namespace: "default", Name: "b"},
+			Phase:          "Running",
+		},
+		{
+			NamespacedName: tacokumogithubiov1alpha1.NamespacedName{Namespace: "default", Name: "c"},
+			Phase:          "Running",
+			Reason:         "ImagePullBackOff",
+		},
+	}, refs)
+}
+
+func TestSetConditions(t *testing.T) {
+	tests := []struct {
+		name           string
+		pods           []tacokumogithubiov1alpha1.PodReference
+		expectReady    bool
+		expectMessage  string
+		expectDegraded metav1.ConditionStatus
+	}{
+		{
+			name:           "not ready without pods",
+			expectMessage:  "no pods found",
+			expectDegraded: metav1.ConditionFalse,
+		},
+		{
+			name: "ready when all pods are ready",
+			pods: []tacokumogithubiov1alpha1.PodReference{
+				{NamespacedName: tacokumogithubiov1alpha1.NamespacedName{Name: "a"}, Ready: true},
+				{NamespacedName: tacokumogithubiov1alpha1.NamespacedName{Name: "b"}, Ready: true},
+			},
+			expectReady:    true,
+			expectMessage:  "2/2 pods are ready",
+			expectDegraded: metav1.ConditionFalse,
+		},
+		{
+			name: "not ready and degraded when a pod is failing",
+			pods: []tacokumogithubiov1alpha1.PodReference{
+				{NamespacedName: tacokumogithubiov1alpha1.NamespacedName{Name: "a"}, Ready: true},
+				{NamespacedName: tacokumogithubiov1alpha1.NamespacedName{Name: "b"}, Reason: "CrashLoopBackOff"},
+			},
+			expectMessage:  "1/2 pods are ready, not ready: b",
+			expectDegraded: metav1.ConditionTrue,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var conditions []metav1.Condition
+			ready := SetConditions(&conditions, 3, tt.pods)
+
+			assert.Equal(t, tt.expectReady, ready)
+			readyCond := meta.FindStatusCondition(conditions, tacokumogithubiov1alpha1.ConditionTypePodsReady)
+			if assert.NotNil(t, readyCond) {
+				assert.Equal(t, tt.expectReady, readyCond.Status == metav1.ConditionTrue)
+				assert.Equal(t, tt.expectMessage, readyCond.Message)
+				assert.Equal(t, int64(3), readyCond.ObservedGeneration)
+			}
+			degraded := meta.FindStatusCondition(conditions, tacokumogithubiov1alpha1.ConditionTypeDegraded)
+			if assert.NotNil(t, degraded) {
+				assert.Equal(t, tt.expectDegraded, degraded.Status)
+			}
+		})
+	}
+}
+
+func TestSetConditions_KeepsReadyReason(t *testing.T) {
+	// Readyはリソース自体の状態を示すため､Podの状態で上書きしない
+	conditions := []metav1.Condition{{
+		Type:   tacokumogithubiov1alpha1.ConditionTypeReady,
+		Status: metav1.ConditionFalse,
+		Reason: tacokumogithubiov1alpha1.ReasonInvalidAppConfig,
+	}}
+	SetConditions(&conditions, 1, []tacokumogithubiov1alpha1.PodReference{
+		{NamespacedName: tacokumogithubiov1alpha1.NamespacedName{Name: "a"}, Ready: true},
+	})
+
+	ready := meta.FindStatusCondition(conditions, tacokumogithubiov1alpha1.ConditionTypeReady)
+	if assert.NotNil(t, ready) {
+		assert.Equal(t, tacokumogithubiov1alpha1.ReasonInvalidAppConfig, ready.Reason)
+	}
+	assert.True(t, meta.IsStatusConditionTrue(conditions, tacokumogithubiov1alpha1.ConditionTypePodsReady))
+}
@@ -2,17 +2,25 @@ package portal
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
 	"path/filepath"
 
 	tacokumogithubiov1alpha1 "github.com/tacokumo/portal-controller-kubernetes/api/v1alpha1"
+	"github.com/tacokumo/portal-controller-kubernetes/pkg/dryrun"
 	"github.com/tacokumo/portal-controller-kubernetes/pkg/helmutil"
+	"github.com/tacokumo/portal-controller-kubernetes/pkg/podhealth"
 
 	"github.com/go-logr/logr"
+	"helm.sh/helm/v3/pkg/chartutil"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	k8sruntime "k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
 )
 
@@ -37,6 +45,34 @@ func (m *Manager) Reconcile(
 	ctx context.Context,
 	p *tacokumogithubiov1alpha1.Portal,
 ) error {
+	// dry-runの間はリソースを変更せず､状態も遷移させない
+	if tacokumogithubiov1alpha1.IsDryRun(p.Annotations, p.Spec.DryRun) {
+		err := m.reconcileDryRun(ctx, p)
+		if updateErr := m.k8sClient.Status().Update(ctx, p); updateErr != nil {
+			return updateErr
+		}
+		return err
+	}
+	p.Status.DryRun = nil
+	meta.RemoveStatusCondition(&p.Status.Conditions, tacokumogithubiov1alpha1.ConditionTypeDryRun)
+
+	// 一時停止中はリソースを変更せず､状態も遷移させない
+	if p.Spec.Suspend {
+		tacokumogithubiov1alpha1.SetSuspendedCondition(&p.Status.Conditions, p.Generation, true,
+			tacokumogithubiov1alpha1.ReasonSuspended, "portal is suspended by spec.suspend")
+		_, err := m.updatePodHealth(ctx, p)
+		if updateErr := m.k8sClient.Status().Update(ctx, p); updateErr != nil {
+			return updateErr
+		}
+		return err
+	}
+	meta.RemoveStatusCondition(&p.Status.Conditions, tacokumogithubiov1alpha1.ConditionTypeSuspended)
+
+	// specが更新された場合はチャートを再レンダリングする
+	if p.Status.State != "" && p.Status.State != tacokumogithubiov1alpha1.PortalStateProvisioning &&
+		p.Generation != p.Status.ObservedGeneration {
+		p.Status.State = tacokumogithubiov1alpha1.PortalStateProvisioning
+	}
 
 	switch p.Status.State {
 	case tacokumogithubiov1alpha1.PortalStateProvisioning:
@@ -48,9 +84,13 @@ func (m *Manager) Reconcile(
 			return m.handleError(ctx, p, err)
 		}
 	case tacokumogithubiov1alpha1.PortalStateRunning:
-		// TODO: 差分を検知したらProvisioningに戻す
+		// Podの一覧の取得の失敗は一時的なものであり､Errorに遷移するとspecが更新されるまで再試行されないため､
+		// 状態を変えずにエラーを返して再試行させる
+		if _, err := m.updatePodHealth(ctx, p); err != nil {
+			return err
+		}
 	case tacokumogithubiov1alpha1.PortalStateError:
-		// TODO: 差分を検知したらProvisioningに戻す
+		// レンダリングしたspecが更新されるまで再試行しない
 	default:
 		p.Status.State = tacokumogithubiov1alpha1.PortalStateProvisioning
 	}
@@ -84,60 +124,125 @@ func (m *Manager) reconcileOnProvisioningState(
 		return nil
 	}
 
-	values := m.constructValues(p)
+	objects, err := m.renderObjects(p)
+	if err != nil {
+		return err
+	}
+
+	for _, obj := range objects {
+		if err := helmutil.CreateOrUpdateObject(ctx, m.k8sClient, obj); err != nil {
+			return err
+		}
+	}
+
+	p.Status.ObservedGeneration = p.Generation
+	p.Status.State = tacokumogithubiov1alpha1.PortalStateWaiting
+	return nil
+}
+
+// renderObjects はtacokumo-portalチャートをレンダリングし､Portalの名前空間に配置するオブジェクトを返す
+func (m *Manager) renderObjects(p *tacokumogithubiov1alpha1.Portal) ([]*unstructured.Unstructured, error) {
+	values, err := m.constructValues(p)
+	if err != nil {
+		return nil, err
+	}
 
 	chartPath := filepath.Join(m.workdir, "helm-charts", "charts", "tacokumo-portal")
 
 	manifests, err := helmutil.RenderChart(chartPath, p.Name, p.Name, values)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	objects, err := helmutil.ParseManifestsToUnstructured(manifests)
 	if err != nil {
-		return err
+		return nil, err
 	}
-
 	for _, obj := range objects {
 		obj.SetNamespace(p.Name)
-		if err := helmutil.CreateOrUpdateObject(ctx, m.k8sClient, obj); err != nil {
-			return err
-		}
 	}
-
-	p.Status.State = tacokumogithubiov1alpha1.PortalStateWaiting
-	return nil
+	return objects, nil
 }
 
-func (m *Manager) reconcileOnWaitingState(
+// reconcileDryRun はPortalのリソースを変更せずに､適用した場合の差分を p.Status.DryRun に記録する
+// 差分はspecが更新された場合にのみ再計算される
+func (m *Manager) reconcileDryRun(
 	ctx context.Context,
 	p *tacokumogithubiov1alpha1.Portal,
 ) error {
-	podList := corev1.PodList{}
-	err := m.k8sClient.List(ctx, &podList, client.InNamespace(p.Name), client.MatchingLabels{
-		tacokumogithubiov1alpha1.ManagedByLabelKey: "portal-controller",
-	})
+	if p.Status.DryRun != nil && p.Status.DryRun.ObservedGeneration == p.Generation {
+		return nil
+	}
+
+	changes, err := m.computeDryRunChanges(ctx, p)
 	if err != nil {
+		dryrun.SetCondition(&p.Status.Conditions, p.Generation, "", err)
 		return err
 	}
+	p.Status.DryRun = dryrun.NewStatus(p.Generation, changes)
+	dryrun.SetCondition(&p.Status.Conditions, p.Generation, p.Status.DryRun.Summary, nil)
+	return nil
+}
 
-	allReady := true
-	for _, pod := range podList.Items {
-		if pod.Status.Phase != corev1.PodRunning {
-			allReady = false
-		}
+func (m *Manager) computeDryRunChanges(
+	ctx context.Context,
+	p *tacokumogithubiov1alpha1.Portal,
+) ([]tacokumogithubiov1alpha1.DryRunChange, error) {
+	objects, err := m.renderObjects(p)
+	if err != nil {
+		return nil, err
 	}
 
-	if !allReady {
+	ns := &unstructured.Unstructured{}
+	ns.SetAPIVersion("v1")
+	ns.SetKind("Namespace")
+	ns.SetName(p.Name)
+
+	return dryrun.Compute(ctx, m.k8sClient, append([]*unstructured.Unstructured{ns}, objects...), nil)
+}
+
+func (m *Manager) reconcileOnWaitingState(
+	ctx context.Context,
+	p *tacokumogithubiov1alpha1.Portal,
+) error {
+	ready, err := m.updatePodHealth(ctx, p)
+	if err != nil {
+		return err
+	}
+	if !ready {
 		// pods are not ready yet, but the controller will requeue automatically
 		return nil
 	}
 
 	// TODO: healthcheckを実行もしくは監視し、成功していることを確認する
+	tacokumogithubiov1alpha1.SetReadyConditionTrue(&p.Status.Conditions, p.Generation,
+		tacokumogithubiov1alpha1.ReasonPodsReady, "portal is running")
 	p.Status.State = tacokumogithubiov1alpha1.PortalStateRunning
 	return nil
 }
 
+// updatePodHealth はPortalのPodの状態を p.Status に記録する
+// 全てのPodがReadyの場合に true を返す
+func (m *Manager) updatePodHealth(
+	ctx context.Context,
+	p *tacokumogithubiov1alpha1.Portal,
+) (bool, error) {
+	podList := corev1.PodList{}
+	err := m.k8sClient.List(ctx, &podList, client.InNamespace(p.Name), client.MatchingLabels(podLabelsOf(p)))
+	if err != nil {
+		return false, err
+	}
+
+	p.Status.Pods = podhealth.Summarize(podList.Items)
+	return podhealth.SetConditions(&p.Status.Conditions, p.Generation, p.Status.Pods), nil
+}
+
+// podLabelsOf はtacokumo-portalチャートがPodに付与するラベルのうち､PortalのPodを選択するものを返す
+// チャートのリリース名にはPortalの名前を使用している
+func podLabelsOf(p *tacokumogithubiov1alpha1.Portal) map[string]string {
+	return map[string]string{"app.kubernetes.io/instance": p.Name}
+}
+
 func (m *Manager) handleError(
 	ctx context.Context,
 	p *tacokumogithubiov1alpha1.Portal,
@@ -161,12 +266,117 @@ func (m *Manager) handleError(
 	return err
 }
 
+// constructValues はPortalのspecからtacokumo-portalチャートのvaluesを構築する
+// spec.values は最後に再帰的にマージされ､他のフィールドから生成した値より優先される
 func (m *Manager) constructValues(
 	p *tacokumogithubiov1alpha1.Portal,
-) map[string]any {
+) (map[string]any, error) {
 	values := map[string]any{
 		"namespace":  p.Name,
 		"namePrefix": p.Name,
 	}
+
+	portal, err := constructPortalValues(p)
+	if err != nil {
+		return nil, err
+	}
+	if len(portal) > 0 {
+		values["portal"] = portal
+	}
+	if p.Spec.Ingress != nil {
+		values["ingress"] = constructIngressValues(p)
+	}
+
+	if p.Spec.Values != nil && len(p.Spec.Values.Raw) > 0 {
+		overrides := map[string]any{}
+		if err := json.Unmarshal(p.Spec.Values.Raw, &overrides); err != nil {
+			return nil, fmt.Errorf("failed to parse spec.values: %w", err)
+		}
+		values = chartutil.CoalesceTables(overrides, values)
+	}
+	return values, nil
+}
+
+// constructPortalValues はチャートの `portal` に渡すvaluesを構築する
+func constructPortalValues(p *tacokumogithubiov1alpha1.Portal) (map[string]any, error) {
+	portal := map[string]any{}
+	if image := p.Spec.Image; image != nil {
+		imageValues := map[string]any{}
+		if image.Repository != "" {
+			imageValues["repository"] = image.Repository
+		}
+		if image.Tag != "" {
+			imageValues["tag"] = image.Tag
+		}
+		portal["image"] = imageValues
+	}
+	if p.Spec.Replicas != nil {
+		portal["replicaCount"] = int64(*p.Spec.Replicas)
+	}
+	if p.Spec.Resources != nil {
+		resources, err := k8sruntime.DefaultUnstructuredConverter.ToUnstructured(p.Spec.Resources)
+		if err != nil {
+			return nil, err
+		}
+		// チャートのデフォルトとマージされないよう､指定されていない項目はnilで削除する
+		for _, key := range []string{"limits", "requests"} {
+			list, ok := resources[key].(map[string]any)
+			if !ok {
+				resources[key] = nil
+				continue
+			}
+			for _, name := range []corev1.ResourceName{
+				corev1.ResourceCPU, corev1.ResourceMemory, corev1.ResourceEphemeralStorage,
+			} {
+				if _, ok := list[string(name)]; !ok {
+					list[string(name)] = nil
+				}
+			}
+		}
+		portal["resources"] = resources
+	}
+	if p.Spec.BaseDomain != "" {
+		portal["config"] = map[string]any{"baseDomain": p.Spec.BaseDomain}
+	}
+	return portal, nil
+}
+
+// constructIngressValues はチャートの `ingress` に渡すvaluesを構築する
+// ホスト名が決まる場合は､チャートのデフォルトのホストとTLSの設定を置き換える
+func constructIngressValues(p *tacokumogithubiov1alpha1.Portal) map[string]any {
+	ingress := p.Spec.Ingress
+	values := map[string]any{
+		"enabled": ingress.Enabled,
+	}
+	if ingress.ClassName != "" {
+		values["className"] = ingress.ClassName
+	}
+	if len(ingress.Annotations) > 0 {
+		annotations := map[string]any{}
+		for k, v := range ingress.Annotations {
+			annotations[k] = v
+		}
+		values["annotations"] = annotations
+	}
+
+	host := ingress.Host
+	if host == "" && p.Spec.BaseDomain != "" {
+		host = "portal." + p.Spec.BaseDomain
+	}
+	if host == "" {
+		return values
+	}
+	values["hosts"] = []any{
+		map[string]any{
+			"host":  host,
+			"paths": []any{map[string]any{"path": "/", "pathType": "Prefix"}},
+		},
+	}
+	values["tls"] = []any{}
+	if ingress.TLSSecretName != "" {
+		values["tls"] = []any{
+			map[string]any{"secretName": ingress.TLSSecretName, "hosts": []any{host}},
+		}
+	}
 	return values
 }
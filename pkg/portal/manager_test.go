@@ -1,9 +1,43 @@
 package portal
 
 import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
 	"testing"
+
+	tacokumogithubiov1alpha1 "github.com/tacokumo/portal-controller-kubernetes/api/v1alpha1"
+	"github.com/tacokumo/portal-controller-kubernetes/pkg/dryrun/dryruntest"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	k8sruntime "k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
 )
 
+func newTestScheme(t *testing.T) *k8sruntime.Scheme {
+	t.Helper()
+	scheme := k8sruntime.NewScheme()
+	require.NoError(t, tacokumogithubiov1alpha1.AddToScheme(scheme))
+	require.NoError(t, corev1.AddToScheme(scheme))
+	return scheme
+}
+
 func TestManager_reconcileOnProvisioningState(t *testing.T) {
 	tests := []struct {
 		name string
@@ -14,3 +48,497 @@ func TestManager_reconcileOnProvisioningState(t *testing.T) {
 		})
 	}
 }
+
+func TestManager_reconcileOnWaitingState(t *testing.T) {
+	// Podにはチャートがレンダリングしたテンプレートのラベルを付与する
+	rendered, err := NewManager(logr.Discard(), nil, portalChartWorkdir(t)).renderObjects(
+		&tacokumogithubiov1alpha1.Portal{ObjectMeta: metav1.ObjectMeta{Name: "portal"}})
+	require.NoError(t, err)
+	deploy := &appsv1.Deployment{}
+	findRenderedObject(t, rendered, "Deployment", "tacokumo-portal", deploy)
+
+	tests := []struct {
+		name          string
+		podReady      bool
+		expectedState string
+	}{
+		{
+			name:          "stays Waiting while a running pod is not ready",
+			podReady:      false,
+			expectedState: tacokumogithubiov1alpha1.PortalStateWaiting,
+		},
+		{
+			name:          "transitions to Running when all pods are ready",
+			podReady:      true,
+			expectedState: tacokumogithubiov1alpha1.PortalStateRunning,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			readyStatus := corev1.ConditionFalse
+			if tt.podReady {
+				readyStatus = corev1.ConditionTrue
+			}
+			p := &tacokumogithubiov1alpha1.Portal{
+				ObjectMeta: metav1.ObjectMeta{Name: "portal"},
+				Status: tacokumogithubiov1alpha1.PortalStatus{
+					State: tacokumogithubiov1alpha1.PortalStateWaiting,
+				},
+			}
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "portal",
+					Name:      "portal-api-1",
+					Labels:    deploy.Spec.Template.Labels,
+				},
+				Status: corev1.PodStatus{
+					Phase:      corev1.PodRunning,
+					Conditions: []corev1.PodCondition{{Type: corev1.PodReady, Status: readyStatus}},
+				},
+			}
+			k8sClient := fake.NewClientBuilder().
+				WithScheme(newTestScheme(t)).
+				WithObjects(p, pod).
+				WithStatusSubresource(p).
+				Build()
+			m := NewManager(logr.Discard(), k8sClient, ".")
+
+			require.NoError(t, m.Reconcile(t.Context(), p))
+
+			updated := &tacokumogithubiov1alpha1.Portal{}
+			require.NoError(t, k8sClient.Get(t.Context(), client.ObjectKeyFromObject(p), updated))
+			assert.Equal(t, tt.expectedState, updated.Status.State)
+			require.Len(t, updated.Status.Pods, 1)
+			assert.Equal(t, "portal-api-1", updated.Status.Pods[0].Name)
+			assert.Equal(t, tt.podReady, updated.Status.Pods[0].Ready)
+		})
+	}
+}
+
+func TestManager_Reconcile_DryRun(t *testing.T) {
+	tests := []struct {
+		name            string
+		annotations     map[string]string
+		specDryRun      bool
+		existingObjects []client.Object
+		expectedChanges []tacokumogithubiov1alpha1.DryRunChange
+		expectedConfig  map[string]string
+	}{
+		{
+			name:        "reports all objects as created for a new portal",
+			annotations: map[string]string{tacokumogithubiov1alpha1.DryRunAnnotationKey: "true"},
+			expectedChanges: []tacokumogithubiov1alpha1.DryRunChange{
+				{Action: "Created", APIVersion: "v1", Kind: "Namespace", Name: "portal"},
+				{Action: "Created", APIVersion: "v1", Kind: "ConfigMap", Namespace: "portal", Name: "portal-config"},
+			},
+		},
+		{
+			name:       "reports changed fields of live objects",
+			specDryRun: true,
+			existingObjects: []client.Object{
+				&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "portal"}},
+				&corev1.ConfigMap{
+					ObjectMeta: metav1.ObjectMeta{Namespace: "portal", Name: "portal-config"},
+					Data:       map[string]string{"namespace": "old"},
+				},
+			},
+			expectedChanges: []tacokumogithubiov1alpha1.DryRunChange{
+				{
+					Action: "Changed", APIVersion: "v1", Kind: "ConfigMap", Namespace: "portal", Name: "portal-config",
+					Fields: []string{"data.namespace"},
+				},
+			},
+			expectedConfig: map[string]string{"namespace": "old"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &tacokumogithubiov1alpha1.Portal{
+				ObjectMeta: metav1.ObjectMeta{Name: "portal", Generation: 1, Annotations: tt.annotations},
+				Spec:       tacokumogithubiov1alpha1.PortalSpec{DryRun: tt.specDryRun},
+			}
+			k8sClient := fake.NewClientBuilder().
+				WithScheme(newTestScheme(t)).
+				WithObjects(append([]client.Object{p}, tt.existingObjects...)...).
+				WithStatusSubresource(p).
+				WithInterceptorFuncs(dryruntest.ServerDryRun(t)).
+				Build()
+			m := NewManager(logr.Discard(), k8sClient, "testdata")
+
+			require.NoError(t, m.Reconcile(t.Context(), p))
+
+			updated := &tacokumogithubiov1alpha1.Portal{}
+			require.NoError(t, k8sClient.Get(t.Context(), client.ObjectKeyFromObject(p), updated))
+			// dry-runの間は状態を遷移させない
+			assert.Empty(t, updated.Status.State)
+			require.NotNil(t, updated.Status.DryRun)
+			assert.Equal(t, tt.expectedChanges, updated.Status.DryRun.Changes)
+			assert.True(t,
+				meta.IsStatusConditionTrue(updated.Status.Conditions, tacokumogithubiov1alpha1.ConditionTypeDryRun))
+
+			// 実際のオブジェクトは変更されない
+			cm := &corev1.ConfigMap{}
+			err := k8sClient.Get(t.Context(), client.ObjectKey{Namespace: "portal", Name: "portal-config"}, cm)
+			if tt.expectedConfig == nil {
+				assert.True(t, apierrors.IsNotFound(err))
+			} else {
+				require.NoError(t, err)
+				assert.Equal(t, tt.expectedConfig, cm.Data)
+			}
+		})
+	}
+}
+
+func TestManager_Reconcile_ClearsDryRunWhenDisabled(t *testing.T) {
+	p := &tacokumogithubiov1alpha1.Portal{
+		ObjectMeta: metav1.ObjectMeta{Name: "portal", Generation: 1},
+		Status: tacokumogithubiov1alpha1.PortalStatus{
+			State: tacokumogithubiov1alpha1.PortalStateError,
+			DryRun: &tacokumogithubiov1alpha1.DryRunStatus{
+				ObservedGeneration: 1, Summary: "1 created, 0 changed, 0 deleted",
+			},
+			Conditions: []metav1.Condition{{
+				Type: tacokumogithubiov1alpha1.ConditionTypeDryRun, Status: metav1.ConditionTrue,
+				Reason: tacokumogithubiov1alpha1.ReasonDryRunSucceeded, LastTransitionTime: metav1.Now(),
+			}},
+		},
+	}
+	k8sClient := fake.NewClientBuilder().
+		WithScheme(newTestScheme(t)).
+		WithObjects(p).
+		WithStatusSubresource(p).
+		Build()
+	m := NewManager(logr.Discard(), k8sClient, "testdata")
+
+	require.NoError(t, m.Reconcile(t.Context(), p))
+	assert.Nil(t, p.Status.DryRun)
+	assert.Nil(t, meta.FindStatusCondition(p.Status.Conditions, tacokumogithubiov1alpha1.ConditionTypeDryRun))
+}
+
+func TestManager_Reconcile_Suspended(t *testing.T) {
+	p := &tacokumogithubiov1alpha1.Portal{
+		ObjectMeta: metav1.ObjectMeta{Name: "portal", Generation: 1},
+		Spec:       tacokumogithubiov1alpha1.PortalSpec{Suspend: true},
+		Status: tacokumogithubiov1alpha1.PortalStatus{
+			State: tacokumogithubiov1alpha1.PortalStateProvisioning,
+		},
+	}
+	k8sClient := fake.NewClientBuilder().
+		WithScheme(newTestScheme(t)).
+		WithObjects(p).
+		WithStatusSubresource(p).
+		Build()
+	m := NewManager(logr.Discard(), k8sClient, "testdata")
+
+	require.NoError(t, m.Reconcile(t.Context(), p))
+
+	// 一時停止中はリソースを作成せず､状態も遷移させない
+	assert.Equal(t, tacokumogithubiov1alpha1.PortalStateProvisioning, p.Status.State)
+	err := k8sClient.Get(t.Context(), client.ObjectKey{Name: "portal"}, &corev1.Namespace{})
+	assert.True(t, apierrors.IsNotFound(err))
+	assert.True(t, meta.IsStatusConditionTrue(p.Status.Conditions, tacokumogithubiov1alpha1.ConditionTypeSuspended))
+
+	// 再開すると作成される
+	p.Spec.Suspend = false
+	require.NoError(t, m.Reconcile(t.Context(), p))
+	assert.Equal(t, tacokumogithubiov1alpha1.PortalStateWaiting, p.Status.State)
+	require.NoError(t, k8sClient.Get(t.Context(), client.ObjectKey{Name: "portal"}, &corev1.Namespace{}))
+	assert.Nil(t, meta.FindStatusCondition(p.Status.Conditions, tacokumogithubiov1alpha1.ConditionTypeSuspended))
+}
+
+func TestManager_constructValues(t *testing.T) {
+	tests := []struct {
+		name     string
+		spec     tacokumogithubiov1alpha1.PortalSpec
+		expected map[string]any
+		wantErr  bool
+	}{
+		{
+			name: "only namespace and namePrefix without configuration",
+			expected: map[string]any{
+				"namespace":  "portal",
+				"namePrefix": "portal",
+			},
+		},
+		{
+			name: "maps spec fields to chart values",
+			spec: tacokumogithubiov1alpha1.PortalSpec{
+				Image:    &tacokumogithubiov1alpha1.PortalImage{Repository: "ghcr.io/tacokumo/portal", Tag: "v1.2.3"},
+				Replicas: ptr.To[int32](3),
+				Resources: &corev1.ResourceRequirements{
+					Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("100m")},
+				},
+				BaseDomain: "example.com",
+				Ingress: &tacokumogithubiov1alpha1.PortalIngress{
+					Enabled:       true,
+					ClassName:     "nginx",
+					Host:          "console.example.com",
+					TLSSecretName: "console-tls",
+					Annotations:   map[string]string{"nginx.ingress.kubernetes.io/ssl-redirect": "true"},
+				},
+			},
+			expected: map[string]any{
+				"namespace":  "portal",
+				"namePrefix": "portal",
+				"portal": map[string]any{
+					"image":        map[string]any{"repository": "ghcr.io/tacokumo/portal", "tag": "v1.2.3"},
+					"replicaCount": int64(3),
+					"resources": map[string]any{
+						"limits":   nil,
+						"requests": map[string]any{"cpu": "100m", "memory": nil, "ephemeral-storage": nil},
+					},
+					"config": map[string]any{"baseDomain": "example.com"},
+				},
+				"ingress": map[string]any{
+					"enabled":     true,
+					"className":   "nginx",
+					"annotations": map[string]any{"nginx.ingress.kubernetes.io/ssl-redirect": "true"},
+					"hosts": []any{map[string]any{
+						"host":  "console.example.com",
+						"paths": []any{map[string]any{"path": "/", "pathType": "Prefix"}},
+					}},
+					"tls": []any{map[string]any{"secretName": "console-tls", "hosts": []any{"console.example.com"}}},
+				},
+			},
+		},
+		{
+			name: "derives the ingress host from the base domain",
+			spec: tacokumogithubiov1alpha1.PortalSpec{
+				BaseDomain: "example.com",
+				Ingress:    &tacokumogithubiov1alpha1.PortalIngress{Enabled: true},
+			},
+			expected: map[string]any{
+				"namespace":  "portal",
+				"namePrefix": "portal",
+				"portal":     map[string]any{"config": map[string]any{"baseDomain": "example.com"}},
+				"ingress": map[string]any{
+					"enabled": true,
+					"hosts": []any{map[string]any{
+						"host":  "portal.example.com",
+						"paths": []any{map[string]any{"path": "/", "pathType": "Prefix"}},
+					}},
+					"tls": []any{},
+				},
+			},
+		},
+		{
+			name: "merges values over generated values",
+			spec: tacokumogithubiov1alpha1.PortalSpec{
+				Image: &tacokumogithubiov1alpha1.PortalImage{Repository: "ghcr.io/tacokumo/portal", Tag: "v1.2.3"},
+				Values: &apiextensionsv1.JSON{
+					Raw: []byte(`{"portal":{"image":{"tag":"v1.3.0-rc.1"},"config":{"logLevel":"debug"}}}`),
+				},
+			},
+			expected: map[string]any{
+				"namespace":  "portal",
+				"namePrefix": "portal",
+				"portal": map[string]any{
+					"image":  map[string]any{"repository": "ghcr.io/tacokumo/portal", "tag": "v1.3.0-rc.1"},
+					"config": map[string]any{"logLevel": "debug"},
+				},
+			},
+		},
+		{
+			name: "rejects values that are not an object",
+			spec: tacokumogithubiov1alpha1.PortalSpec{
+				Values: &apiextensionsv1.JSON{Raw: []byte(`["invalid"]`)},
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &tacokumogithubiov1alpha1.Portal{
+				ObjectMeta: metav1.ObjectMeta{Name: "portal"},
+				Spec:       tt.spec,
+			}
+			m := NewManager(logr.Discard(), nil, "testdata")
+
+			values, err := m.constructValues(p)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, values)
+		})
+	}
+}
+
+// portalChartWorkdir は go.mod で指定されたバージョンのtacokumo-portalチャートを参照するworkdirを返す
+func portalChartWorkdir(t *testing.T) string {
+	t.Helper()
+	out, err := exec.Command("go", "list", "-m", "-f", "{{.Dir}}", "github.com/tacokumo/helm-charts").Output()
+	require.NoError(t, err)
+	dir := strings.TrimSpace(string(out))
+	require.NotEmpty(t, dir, "github.com/tacokumo/helm-charts is not downloaded")
+
+	workdir := t.TempDir()
+	require.NoError(t, os.Symlink(dir, filepath.Join(workdir, "helm-charts")))
+	return workdir
+}
+
+func findRenderedObject(
+	t *testing.T,
+	objects []*unstructured.Unstructured,
+	kind, name string,
+	into any,
+) {
+	t.Helper()
+	for _, obj := range objects {
+		if obj.GetKind() == kind && obj.GetName() == name {
+			require.NoError(t, k8sruntime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, into))
+			return
+		}
+	}
+	require.Failf(t, "object not rendered", "%s %s", kind, name)
+}
+
+func TestManager_renderObjects_PortalChart(t *testing.T) {
+	p := &tacokumogithubiov1alpha1.Portal{
+		ObjectMeta: metav1.ObjectMeta{Name: "portal"},
+		Spec: tacokumogithubiov1alpha1.PortalSpec{
+			Image:    &tacokumogithubiov1alpha1.PortalImage{Repository: "ghcr.io/tacokumo/portal", Tag: "v1.2.3"},
+			Replicas: ptr.To[int32](3),
+			Resources: &corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("200m")},
+			},
+			BaseDomain: "example.com",
+			Ingress: &tacokumogithubiov1alpha1.PortalIngress{
+				Enabled:       true,
+				ClassName:     "internal",
+				TLSSecretName: "portal-tls",
+			},
+		},
+	}
+	m := NewManager(logr.Discard(), nil, portalChartWorkdir(t))
+
+	objects, err := m.renderObjects(p)
+	require.NoError(t, err)
+
+	deploy := &appsv1.Deployment{}
+	findRenderedObject(t, objects, "Deployment", "tacokumo-portal", deploy)
+	assert.Equal(t, "portal", deploy.Namespace)
+	assert.Equal(t, int32(3), ptr.Deref(deploy.Spec.Replicas, 0))
+	require.Len(t, deploy.Spec.Template.Spec.Containers, 1)
+	container := deploy.Spec.Template.Spec.Containers[0]
+	assert.Equal(t, "ghcr.io/tacokumo/portal:v1.2.3", container.Image)
+	// チャートのデフォルトのlimitsとはマージされない
+	assert.Equal(t, corev1.ResourceRequirements{
+		Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("200m")},
+	}, container.Resources)
+
+	ingress := &networkingv1.Ingress{}
+	findRenderedObject(t, objects, "Ingress", "tacokumo-portal-ingress", ingress)
+	assert.Equal(t, "internal", ptr.Deref(ingress.Spec.IngressClassName, ""))
+	require.Len(t, ingress.Spec.Rules, 1)
+	assert.Equal(t, "portal.example.com", ingress.Spec.Rules[0].Host)
+	assert.Equal(t, []networkingv1.IngressTLS{{Hosts: []string{"portal.example.com"}, SecretName: "portal-tls"}},
+		ingress.Spec.TLS)
+
+	// PodのヘルスチェックはチャートがPodに付与するラベルで選択する
+	for k, v := range podLabelsOf(p) {
+		assert.Equal(t, v, deploy.Spec.Template.Labels[k])
+	}
+
+	cm := &corev1.ConfigMap{}
+	findRenderedObject(t, objects, "ConfigMap", "portal-tacokumo-portal-config", cm)
+	assert.Contains(t, cm.Data["config.yaml"], "base_domain: example.com")
+}
+
+func TestManager_Reconcile_RerendersOnSpecChange(t *testing.T) {
+	tests := []struct {
+		name          string
+		state         string
+		generation    int64
+		expectedState string
+	}{
+		{
+			name:          "re-renders a running portal when spec is updated",
+			state:         tacokumogithubiov1alpha1.PortalStateRunning,
+			generation:    2,
+			expectedState: tacokumogithubiov1alpha1.PortalStateWaiting,
+		},
+		{
+			name:          "re-renders an errored portal when spec is updated",
+			state:         tacokumogithubiov1alpha1.PortalStateError,
+			generation:    2,
+			expectedState: tacokumogithubiov1alpha1.PortalStateWaiting,
+		},
+		{
+			name:          "keeps running while spec is unchanged",
+			state:         tacokumogithubiov1alpha1.PortalStateRunning,
+			generation:    1,
+			expectedState: tacokumogithubiov1alpha1.PortalStateRunning,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &tacokumogithubiov1alpha1.Portal{
+				ObjectMeta: metav1.ObjectMeta{Name: "portal", Generation: tt.generation},
+				Status: tacokumogithubiov1alpha1.PortalStatus{
+					State:              tt.state,
+					ObservedGeneration: 1,
+				},
+			}
+			k8sClient := fake.NewClientBuilder().
+				WithScheme(newTestScheme(t)).
+				WithObjects(p, &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "portal"}}).
+				WithStatusSubresource(p).
+				Build()
+			m := NewManager(logr.Discard(), k8sClient, "testdata")
+
+			require.NoError(t, m.Reconcile(t.Context(), p))
+
+			assert.Equal(t, tt.expectedState, p.Status.State)
+			assert.Equal(t, tt.generation, p.Status.ObservedGeneration)
+			key := client.ObjectKey{Namespace: "portal", Name: "portal-config"}
+			err := k8sClient.Get(t.Context(), key, &corev1.ConfigMap{})
+			if tt.generation == 1 {
+				assert.True(t, apierrors.IsNotFound(err))
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestManager_Reconcile_RunningKeepsStateOnPodListError(t *testing.T) {
+	p := &tacokumogithubiov1alpha1.Portal{
+		ObjectMeta: metav1.ObjectMeta{Name: "portal", Generation: 1},
+		Status: tacokumogithubiov1alpha1.PortalStatus{
+			State:              tacokumogithubiov1alpha1.PortalStateRunning,
+			ObservedGeneration: 1,
+		},
+	}
+	listErr := apierrors.NewServiceUnavailable("temporarily unavailable")
+	failing := true
+	k8sClient := fake.NewClientBuilder().
+		WithScheme(newTestScheme(t)).
+		WithObjects(p).
+		WithStatusSubresource(p).
+		WithInterceptorFuncs(interceptor.Funcs{
+			List: func(ctx context.Context, c client.WithWatch, list client.ObjectList, opts ...client.ListOption) error {
+				if _, ok := list.(*corev1.PodList); ok && failing {
+					return listErr
+				}
+				return c.List(ctx, list, opts...)
+			},
+		}).
+		Build()
+	m := NewManager(logr.Discard(), k8sClient, "testdata")
+
+	// 一時的な失敗ではErrorに遷移せず､エラーを返して再試行させる
+	require.ErrorIs(t, m.Reconcile(t.Context(), p), listErr)
+	stored := &tacokumogithubiov1alpha1.Portal{}
+	require.NoError(t, k8sClient.Get(t.Context(), client.ObjectKeyFromObject(p), stored))
+	assert.Equal(t, tacokumogithubiov1alpha1.PortalStateRunning, stored.Status.State)
+
+	failing = false
+	require.NoError(t, m.Reconcile(t.Context(), stored))
+	assert.Equal(t, tacokumogithubiov1alpha1.PortalStateRunning, stored.Status.State)
+}
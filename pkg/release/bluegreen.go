@@ -0,0 +1,458 @@
+package release
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	tacokumogithubiov1alpha1 "github.com/tacokumo/portal-controller-kubernetes/api/v1alpha1"
+	"github.com/tacokumo/portal-controller-kubernetes/pkg/helmutil"
+
+	appconfig "github.com/tacokumo/appconfig"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+const (
+	// colorLabelKey はブルーグリーンデプロイのDeploymentのPodを区別するためのラベルのキー
+	colorLabelKey = "color"
+	// smokeTestHookName はスモークテストのJobに付与されるフック名
+	smokeTestHookName = "smoke-test"
+	// previewServiceHostEnv はスモークテストにプレビュー用のServiceのホスト名を渡す環境変数
+	previewServiceHostEnv = "PREVIEW_SERVICE_HOST"
+)
+
+func isBlueGreen(rel *tacokumogithubiov1alpha1.Release) bool {
+	strategy := rel.Spec.Strategy
+	return strategy != nil &&
+		strategy.Type == tacokumogithubiov1alpha1.ReleaseStrategyBlueGreen &&
+		strategy.BlueGreen != nil
+}
+
+// previewReady はPreviewColorのDeploymentが spec.commit で検証済みかどうかを返す
+func previewReady(rel *tacokumogithubiov1alpha1.Release) bool {
+	bg := rel.Status.BlueGreen
+	return bg != nil && bg.PreviewColor != "" && bg.PreviewVerified &&
+		bg.PreviewCommit == ptr.Deref(rel.Spec.Commit, "")
+}
+
+// shouldStartPreview はspec.commitをプレビューとして起動する必要があるかどうかを返す
+// 初回のデプロイと､検証済みのコミットへの切り替えではプレビューを起動しない
+func shouldStartPreview(rel *tacokumogithubiov1alpha1.Release) bool {
+	if !isBlueGreen(rel) {
+		return false
+	}
+	bg := rel.Status.BlueGreen
+	if bg == nil || bg.ActiveColor == "" {
+		return false
+	}
+	commit := ptr.Deref(rel.Spec.Commit, "")
+	if rel.Status.DeployedCommit == "" || rel.Status.DeployedCommit == commit {
+		return false
+	}
+	return !previewReady(rel)
+}
+
+// nextActiveColor はデプロイ後にServiceがトラフィックを流すcolorを返す
+func nextActiveColor(rel *tacokumogithubiov1alpha1.Release) string {
+	bg := rel.Status.BlueGreen
+	if bg == nil || bg.ActiveColor == "" {
+		return tacokumogithubiov1alpha1.BlueGreenColorBlue
+	}
+	if previewReady(rel) {
+		return bg.PreviewColor
+	}
+	return bg.ActiveColor
+}
+
+// recordActiveColor はServiceを color に切り替えたことを rel.Status に記録する
+// 以前のcolorは ScaleDownDelay の間､即座にロールバックできるよう検証済みのプレビューとして残す
+func recordActiveColor(rel *tacokumogithubiov1alpha1.Release, color string) {
+	bg := rel.Status.BlueGreen
+	if bg == nil {
+		rel.Status.BlueGreen = &tacokumogithubiov1alpha1.BlueGreenStatus{ActiveColor: color}
+		return
+	}
+	if bg.ActiveColor == color {
+		return
+	}
+
+	var delay time.Duration
+	if d := rel.Spec.Strategy.BlueGreen.ScaleDownDelay; d != nil {
+		delay = d.Duration
+	}
+	bg.PreviewColor = bg.ActiveColor
+	bg.PreviewCommit = rel.Status.DeployedCommit
+	bg.PreviewVerified = true
+	bg.ScaleDownAt = ptr.To(metav1.NewTime(time.Now().Add(delay)))
+	bg.ActiveColor = color
+	setPreviewCondition(rel, metav1.ConditionTrue, tacokumogithubiov1alpha1.ReasonPreviewVerified,
+		fmt.Sprintf("traffic has been switched to %s", color))
+}
+
+// startPreview はレンダリングされたDeploymentとServiceから､アクティブではないcolorのプレビューを作成する
+// アクティブなcolorのDeploymentとServiceは更新しない
+func (m *Manager) startPreview(
+	ctx context.Context,
+	rel *tacokumogithubiov1alpha1.Release,
+	objects []*unstructured.Unstructured,
+) error {
+	bg := rel.Status.BlueGreen
+	color := otherColor(bg.ActiveColor)
+
+	renderedDeploy := findObject(objects, "Deployment", rel.Name)
+	if renderedDeploy == nil {
+		return fmt.Errorf("chart did not render deployment %s", rel.Name)
+	}
+	active := &appsv1.Deployment{}
+	activeKey := client.ObjectKey{Namespace: rel.Namespace, Name: colorDeploymentName(rel, bg.ActiveColor)}
+	if err := m.k8sClient.Get(ctx, activeKey, active); err != nil {
+		return fmt.Errorf("failed to get active deployment: %w", err)
+	}
+
+	preview := renderedDeploy.DeepCopy()
+	preview.SetName(colorDeploymentName(rel, color))
+	if err := addDeploymentLabel(preview, colorLabelKey, color); err != nil {
+		return err
+	}
+	// アクティブなcolorと同じ数のPodで検証し､切り替え時に容量が不足しないようにする
+	replicas := int64(ptr.Deref(active.Spec.Replicas, 1))
+	if err := unstructured.SetNestedField(preview.Object, replicas, "spec", "replicas"); err != nil {
+		return err
+	}
+	previewObjects := []*unstructured.Unstructured{preview}
+
+	if renderedSvc := findObject(objects, "Service", rel.Name); renderedSvc != nil {
+		svc, err := previewServiceFrom(renderedSvc, rel, color)
+		if err != nil {
+			return err
+		}
+		previewObjects = append(previewObjects, svc)
+	}
+
+	for _, obj := range previewObjects {
+		obj.SetNamespace(rel.Namespace)
+		obj.SetResourceVersion("")
+		if err := controllerutil.SetControllerReference(rel, obj, m.k8sClient.Scheme()); err != nil {
+			return err
+		}
+		if err := helmutil.CreateOrUpdateObject(ctx, m.k8sClient, obj); err != nil {
+			return err
+		}
+	}
+
+	bg.PreviewColor = color
+	bg.PreviewCommit = ptr.Deref(rel.Spec.Commit, "")
+	bg.PreviewVerified = false
+	bg.ScaleDownAt = nil
+	setPreviewCondition(rel, metav1.ConditionUnknown, tacokumogithubiov1alpha1.ReasonPreviewProgressing,
+		fmt.Sprintf("waiting for preview %s to be rolled out", color))
+	rel.Status.ObservedGeneration = rel.Generation
+	rel.Status.State = tacokumogithubiov1alpha1.ReleaseStatePreviewing
+	return nil
+}
+
+// reconcileOnPreviewingState はプレビューのDeploymentのReadinessとスモークテストを確認する
+// 検証が完了した場合はServiceを切り替えるためにDeployingに遷移する
+func (m *Manager) reconcileOnPreviewingState(
+	ctx context.Context,
+	rel *tacokumogithubiov1alpha1.Release,
+) error {
+	bg := rel.Status.BlueGreen
+	// 検証の途中でspecが更新された場合は､新しいspecでやり直す
+	if rel.Generation != rel.Status.ObservedGeneration || !isBlueGreen(rel) || bg == nil || bg.PreviewColor == "" {
+		rel.Status.State = tacokumogithubiov1alpha1.ReleaseStateDeploying
+		return nil
+	}
+
+	if _, err := m.updatePodHealth(ctx, rel); err != nil {
+		return err
+	}
+
+	name := colorDeploymentName(rel, bg.PreviewColor)
+	preview := &appsv1.Deployment{}
+	if err := m.k8sClient.Get(ctx, client.ObjectKey{Namespace: rel.Namespace, Name: name}, preview); err != nil {
+		return fmt.Errorf("failed to get preview deployment: %w", err)
+	}
+
+	done, message, err := deploymentRolloutStatus(preview)
+	if err != nil {
+		message := fmt.Sprintf("preview deployment %s: %s", name, message)
+		setPreviewCondition(rel, metav1.ConditionFalse,
+			tacokumogithubiov1alpha1.ReasonProgressDeadlineExceeded, message)
+		return fmt.Errorf("%s: %w", message, err)
+	}
+	if !done {
+		setPreviewCondition(rel, metav1.ConditionUnknown, tacokumogithubiov1alpha1.ReasonPreviewProgressing,
+			fmt.Sprintf("preview deployment %s: %s", name, message))
+		return nil
+	}
+
+	if smokeTest := rel.Spec.Strategy.BlueGreen.SmokeTest; smokeTest != nil {
+		job, err := m.ensureSmokeTestJob(ctx, rel, preview, smokeTest)
+		if err != nil {
+			return err
+		}
+		if failed := findJobCondition(job, batchv1.JobFailed); failed != nil {
+			message := fmt.Sprintf("smoke test against preview %s failed (job %s): %s",
+				bg.PreviewColor, job.Name, m.hookFailureMessage(ctx, job, failed))
+			setPreviewCondition(rel, metav1.ConditionFalse, tacokumogithubiov1alpha1.ReasonSmokeTestFailed, message)
+			return errors.New(message)
+		}
+		if findJobCondition(job, batchv1.JobComplete) == nil {
+			setPreviewCondition(rel, metav1.ConditionUnknown, tacokumogithubiov1alpha1.ReasonPreviewProgressing,
+				fmt.Sprintf("waiting for smoke test (job %s) to complete", job.Name))
+			return nil
+		}
+	}
+
+	bg.PreviewVerified = true
+	setPreviewCondition(rel, metav1.ConditionUnknown, tacokumogithubiov1alpha1.ReasonPreviewProgressing,
+		fmt.Sprintf("preview %s has been verified, switching traffic", bg.PreviewColor))
+	rel.Status.State = tacokumogithubiov1alpha1.ReleaseStateDeploying
+	return nil
+}
+
+// ensureSmokeTestJob はスモークテストのJobを取得し､存在しない場合は作成する
+// Jobはプレビューのコンテナと同じイメージと環境変数で起動される
+func (m *Manager) ensureSmokeTestJob(
+	ctx context.Context,
+	rel *tacokumogithubiov1alpha1.Release,
+	preview *appsv1.Deployment,
+	smokeTest *tacokumogithubiov1alpha1.SmokeTest,
+) (*batchv1.Job, error) {
+	podSpec := preview.Spec.Template.Spec
+	if len(podSpec.Containers) == 0 {
+		return nil, fmt.Errorf("preview deployment %s has no containers", preview.Name)
+	}
+	container := podSpec.Containers[0]
+	for _, c := range podSpec.Containers {
+		if c.Name == rel.Name {
+			container = c
+		}
+	}
+
+	name := hookJobName(rel, smokeTestHook(smokeTest), container.Image)
+
+	job := &batchv1.Job{}
+	err := m.k8sClient.Get(ctx, client.ObjectKey{Namespace: rel.Namespace, Name: name}, job)
+	if err == nil {
+		return job, nil
+	}
+	if client.IgnoreNotFound(err) != nil {
+		return nil, err
+	}
+
+	env := append([]corev1.EnvVar{}, container.Env...)
+	env = append(env, corev1.EnvVar{
+		Name:  previewServiceHostEnv,
+		Value: fmt.Sprintf("%s.%s.svc", previewServiceName(rel), rel.Namespace),
+	})
+	job = &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: rel.Namespace,
+			Labels: map[string]string{
+				"application": rel.Name,
+				hookLabelKey:  smokeTestHookName,
+			},
+			Annotations: map[string]string{hookCommitAnnotationKey: ptr.Deref(rel.Spec.Commit, "")},
+		},
+		Spec: batchv1.JobSpec{
+			BackoffLimit: ptr.To(int32(0)),
+			Template: corev1.PodTemplateSpec{
+				Spec: corev1.PodSpec{
+					RestartPolicy:    corev1.RestartPolicyNever,
+					ImagePullSecrets: podSpec.ImagePullSecrets,
+					Containers: []corev1.Container{{
+						Name:                     smokeTestHookName,
+						Image:                    container.Image,
+						Command:                  smokeTest.Command,
+						Env:                      env,
+						EnvFrom:                  container.EnvFrom,
+						TerminationMessagePolicy: corev1.TerminationMessageFallbackToLogsOnError,
+					}},
+				},
+			},
+		},
+	}
+	if err := controllerutil.SetControllerReference(rel, job, m.k8sClient.Scheme()); err != nil {
+		return nil, err
+	}
+	if err := m.k8sClient.Create(ctx, job); err != nil {
+		return nil, err
+	}
+	m.logger.Info("created smoke test job", "job", job.Name, "color", rel.Status.BlueGreen.PreviewColor)
+	return job, nil
+}
+
+// scaleDownPreviousColor は ScaleDownAt を過ぎた以前のcolorのDeploymentとプレビュー用のServiceを削除する
+func (m *Manager) scaleDownPreviousColor(
+	ctx context.Context,
+	rel *tacokumogithubiov1alpha1.Release,
+) error {
+	bg := rel.Status.BlueGreen
+	if bg == nil || bg.ScaleDownAt == nil || time.Now().Before(bg.ScaleDownAt.Time) {
+		return nil
+	}
+	if err := m.deleteBlueGreenPreview(ctx, rel, bg.PreviewColor); err != nil {
+		return err
+	}
+	m.logger.Info("scaled down previous color", "color", bg.PreviewColor, "commit", bg.PreviewCommit)
+
+	bg.PreviewColor = ""
+	bg.PreviewCommit = ""
+	bg.PreviewVerified = false
+	bg.ScaleDownAt = nil
+	return nil
+}
+
+// deleteBlueGreenPreview は color のDeploymentとプレビュー用のServiceを削除する
+func (m *Manager) deleteBlueGreenPreview(
+	ctx context.Context,
+	rel *tacokumogithubiov1alpha1.Release,
+	color string,
+) error {
+	deploy := &appsv1.Deployment{}
+	deploy.SetNamespace(rel.Namespace)
+	deploy.SetName(colorDeploymentName(rel, color))
+	if err := m.k8sClient.Delete(ctx, deploy); client.IgnoreNotFound(err) != nil {
+		return fmt.Errorf("failed to delete deployment %s: %w", deploy.Name, err)
+	}
+	svc := &corev1.Service{}
+	svc.SetNamespace(rel.Namespace)
+	svc.SetName(previewServiceName(rel))
+	if err := m.k8sClient.Delete(ctx, svc); client.IgnoreNotFound(err) != nil {
+		return fmt.Errorf("failed to delete service %s: %w", svc.Name, err)
+	}
+	return nil
+}
+
+// applyBlueGreenColor はレンダリングされたオブジェクトを color のDeploymentに向ける
+// Deploymentは color ごとの名前に変更し､ServiceのセレクタとHPAの対象を合わせる
+func applyBlueGreenColor(
+	objects []*unstructured.Unstructured,
+	rel *tacokumogithubiov1alpha1.Release,
+	color string,
+) error {
+	if deploy := findObject(objects, "Deployment", rel.Name); deploy != nil {
+		deploy.SetName(colorDeploymentName(rel, color))
+		if err := addDeploymentLabel(deploy, colorLabelKey, color); err != nil {
+			return err
+		}
+	}
+	if svc := findObject(objects, "Service", rel.Name); svc != nil {
+		if err := unstructured.SetNestedField(svc.Object, color, "spec", "selector", colorLabelKey); err != nil {
+			return err
+		}
+	}
+	if hpa := findObject(objects, "HorizontalPodAutoscaler", rel.Name); hpa != nil {
+		target := colorDeploymentName(rel, color)
+		if err := unstructured.SetNestedField(hpa.Object, target, "spec", "scaleTargetRef", "name"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// carryOverLiveReplicas は color のDeploymentが既に存在する場合､そのレプリカ数をレンダリングされたDeploymentに引き継ぐ
+// チャートはreplicasを出力しないため､そのまま置き換えると切り替え時にスケール済みのプレビューが縮小されてしまう
+func (m *Manager) carryOverLiveReplicas(
+	ctx context.Context,
+	rel *tacokumogithubiov1alpha1.Release,
+	objects []*unstructured.Unstructured,
+	color string,
+) error {
+	deploy := findObject(objects, "Deployment", colorDeploymentName(rel, color))
+	if deploy == nil {
+		return nil
+	}
+	if _, found, err := unstructured.NestedFieldNoCopy(deploy.Object, "spec", "replicas"); err != nil || found {
+		return err
+	}
+	live := &appsv1.Deployment{}
+	if err := m.k8sClient.Get(ctx, client.ObjectKey{Namespace: rel.Namespace, Name: deploy.GetName()}, live); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	replicas := int64(ptr.Deref(live.Spec.Replicas, 1))
+	return unstructured.SetNestedField(deploy.Object, replicas, "spec", "replicas")
+}
+
+// previewServiceFrom はレンダリングされたServiceからプレビューのPodを選択するServiceを作成する
+// スモークテストからの接続にのみ使用するため､ClusterIPとする
+func previewServiceFrom(
+	rendered *unstructured.Unstructured,
+	rel *tacokumogithubiov1alpha1.Release,
+	color string,
+) (*unstructured.Unstructured, error) {
+	svc := rendered.DeepCopy()
+	svc.SetName(previewServiceName(rel))
+	if err := unstructured.SetNestedField(svc.Object, string(corev1.ServiceTypeClusterIP), "spec", "type"); err != nil {
+		return nil, err
+	}
+	if err := unstructured.SetNestedField(svc.Object, color, "spec", "selector", colorLabelKey); err != nil {
+		return nil, err
+	}
+	ports, found, err := unstructured.NestedSlice(svc.Object, "spec", "ports")
+	if err != nil {
+		return nil, err
+	}
+	if found {
+		for i, raw := range ports {
+			if port, ok := raw.(map[string]interface{}); ok {
+				delete(port, "nodePort")
+				ports[i] = port
+			}
+		}
+		if err := unstructured.SetNestedSlice(svc.Object, ports, "spec", "ports"); err != nil {
+			return nil, err
+		}
+	}
+	return svc, nil
+}
+
+// smokeTestHook はスモークテストのJobの名前をpre-deployフックと同じ規則で決めるためのフックを返す
+func smokeTestHook(smokeTest *tacokumogithubiov1alpha1.SmokeTest) appconfig.ReleaseConfig {
+	return appconfig.ReleaseConfig{
+		Name:   smokeTestHookName,
+		Action: appconfig.ReleaseActionConfig{Command: smokeTest.Command},
+	}
+}
+
+func otherColor(color string) string {
+	if color == tacokumogithubiov1alpha1.BlueGreenColorBlue {
+		return tacokumogithubiov1alpha1.BlueGreenColorGreen
+	}
+	return tacokumogithubiov1alpha1.BlueGreenColorBlue
+}
+
+func colorDeploymentName(rel *tacokumogithubiov1alpha1.Release, color string) string {
+	return rel.Name + "-" + color
+}
+
+func previewServiceName(rel *tacokumogithubiov1alpha1.Release) string {
+	return rel.Name + "-preview"
+}
+
+func setPreviewCondition(
+	rel *tacokumogithubiov1alpha1.Release,
+	status metav1.ConditionStatus,
+	reason string,
+	message string,
+) {
+	meta.SetStatusCondition(&rel.Status.Conditions, metav1.Condition{
+		Type:               tacokumogithubiov1alpha1.ConditionTypePreview,
+		Status:             status,
+		ObservedGeneration: rel.Generation,
+		Reason:             reason,
+		Message:            message,
+	})
+}
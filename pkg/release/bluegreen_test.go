@@ -0,0 +1,485 @@
+package release
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	tacokumogithubiov1alpha1 "github.com/tacokumo/portal-controller-kubernetes/api/v1alpha1"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func newBlueGreenTestStrategy(smokeTest *tacokumogithubiov1alpha1.SmokeTest) *tacokumogithubiov1alpha1.ReleaseStrategy {
+	return &tacokumogithubiov1alpha1.ReleaseStrategy{
+		Type: tacokumogithubiov1alpha1.ReleaseStrategyBlueGreen,
+		BlueGreen: &tacokumogithubiov1alpha1.BlueGreenStrategy{
+			SmokeTest:      smokeTest,
+			ScaleDownDelay: &metav1.Duration{Duration: 10 * time.Minute},
+		},
+	}
+}
+
+func newBlueGreenTestObjects() []*unstructured.Unstructured {
+	return []*unstructured.Unstructured{
+		{Object: map[string]interface{}{
+			"apiVersion": "apps/v1",
+			"kind":       "Deployment",
+			"metadata":   map[string]interface{}{"name": "app"},
+			"spec": map[string]interface{}{
+				"selector": map[string]interface{}{
+					"matchLabels": map[string]interface{}{"application": "app"},
+				},
+				"template": map[string]interface{}{
+					"metadata": map[string]interface{}{
+						"labels": map[string]interface{}{"application": "app"},
+					},
+				},
+			},
+		}},
+		{Object: map[string]interface{}{
+			"apiVersion": "v1",
+			"kind":       "Service",
+			"metadata":   map[string]interface{}{"name": "app"},
+			"spec": map[string]interface{}{
+				"type":     "NodePort",
+				"selector": map[string]interface{}{"application": "app"},
+				"ports": []interface{}{
+					map[string]interface{}{"name": "http", "port": int64(80), "nodePort": int64(30080)},
+				},
+			},
+		}},
+		{Object: map[string]interface{}{
+			"apiVersion": "autoscaling/v2",
+			"kind":       "HorizontalPodAutoscaler",
+			"metadata":   map[string]interface{}{"name": "app"},
+			"spec": map[string]interface{}{
+				"scaleTargetRef": map[string]interface{}{"apiVersion": "apps/v1", "kind": "Deployment", "name": "app"},
+			},
+		}},
+	}
+}
+
+func TestApplyBlueGreenColor(t *testing.T) {
+	rel := &tacokumogithubiov1alpha1.Release{ObjectMeta: metav1.ObjectMeta{Name: "app"}}
+	objects := newBlueGreenTestObjects()
+
+	require.NoError(t, applyBlueGreenColor(objects, rel, tacokumogithubiov1alpha1.BlueGreenColorGreen))
+
+	deploy := findObject(objects, "Deployment", "app-green")
+	require.NotNil(t, deploy)
+	selector, _, _ := unstructured.NestedStringMap(deploy.Object, "spec", "selector", "matchLabels")
+	assert.Equal(t, map[string]string{"application": "app", "color": "green"}, selector)
+	podLabels, _, _ := unstructured.NestedStringMap(deploy.Object, "spec", "template", "metadata", "labels")
+	assert.Equal(t, map[string]string{"application": "app", "color": "green"}, podLabels)
+
+	svcSelector, _, _ := unstructured.NestedStringMap(findObject(objects, "Service", "app").Object, "spec", "selector")
+	assert.Equal(t, map[string]string{"application": "app", "color": "green"}, svcSelector)
+
+	hpa := findObject(objects, "HorizontalPodAutoscaler", "app")
+	target, _, _ := unstructured.NestedString(hpa.Object, "spec", "scaleTargetRef", "name")
+	assert.Equal(t, "app-green", target)
+}
+
+func TestManager_carryOverLiveReplicas(t *testing.T) {
+	rel := &tacokumogithubiov1alpha1.Release{ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "default"}}
+	// 切り替え先のプレビューはアクティブなcolorと同じ数にスケールされている
+	preview := newRolloutTestDeployment("app-green", 1, completeDeploymentStatus)
+	preview.Spec.Replicas = ptr.To(int32(5))
+	k8sClient := fake.NewClientBuilder().
+		WithScheme(newProcessTestScheme(t)).
+		WithObjects(preview).
+		Build()
+	m := newTestManager(t, k8sClient, nil, "/tmp/test")
+
+	objects := newBlueGreenTestObjects()
+	require.NoError(t, applyBlueGreenColor(objects, rel, tacokumogithubiov1alpha1.BlueGreenColorGreen))
+	require.NoError(t, m.carryOverLiveReplicas(context.Background(), rel, objects,
+		tacokumogithubiov1alpha1.BlueGreenColorGreen))
+
+	replicas, found, err := unstructured.NestedInt64(
+		findObject(objects, "Deployment", "app-green").Object, "spec", "replicas")
+	require.NoError(t, err)
+	require.True(t, found)
+	assert.Equal(t, int64(5), replicas)
+
+	// 初回のデプロイでは既存のDeploymentがないため変更しない
+	objects = newBlueGreenTestObjects()
+	require.NoError(t, applyBlueGreenColor(objects, rel, tacokumogithubiov1alpha1.BlueGreenColorBlue))
+	require.NoError(t, m.carryOverLiveReplicas(context.Background(), rel, objects,
+		tacokumogithubiov1alpha1.BlueGreenColorBlue))
+	_, found, err = unstructured.NestedInt64(findObject(objects, "Deployment", "app-blue").Object, "spec", "replicas")
+	require.NoError(t, err)
+	assert.False(t, found)
+}
+
+func TestPreviewServiceFrom(t *testing.T) {
+	rel := &tacokumogithubiov1alpha1.Release{ObjectMeta: metav1.ObjectMeta{Name: "app"}}
+	rendered := findObject(newBlueGreenTestObjects(), "Service", "app")
+
+	svc, err := previewServiceFrom(rendered, rel, tacokumogithubiov1alpha1.BlueGreenColorBlue)
+	require.NoError(t, err)
+
+	assert.Equal(t, "app-preview", svc.GetName())
+	svcType, _, _ := unstructured.NestedString(svc.Object, "spec", "type")
+	assert.Equal(t, "ClusterIP", svcType)
+	selector, _, _ := unstructured.NestedStringMap(svc.Object, "spec", "selector")
+	assert.Equal(t, map[string]string{"application": "app", "color": "blue"}, selector)
+	ports, _, _ := unstructured.NestedSlice(svc.Object, "spec", "ports")
+	assert.NotContains(t, ports[0], "nodePort")
+
+	// レンダリング結果はアクティブなServiceの適用にも使われるため変更しない
+	assert.Equal(t, "app", rendered.GetName())
+}
+
+func TestBlueGreenColorSelection(t *testing.T) {
+	tests := []struct {
+		name           string
+		deployedCommit string
+		status         *tacokumogithubiov1alpha1.BlueGreenStatus
+		expectPreview  bool
+		expectActive   string
+	}{
+		{
+			name:         "first deployment starts with blue",
+			expectActive: tacokumogithubiov1alpha1.BlueGreenColorBlue,
+		},
+		{
+			name:           "new commit is previewed on the other color",
+			deployedCommit: "old",
+			status:         &tacokumogithubiov1alpha1.BlueGreenStatus{ActiveColor: "blue"},
+			expectPreview:  true,
+			expectActive:   tacokumogithubiov1alpha1.BlueGreenColorBlue,
+		},
+		{
+			name:           "same commit is updated in place",
+			deployedCommit: "new",
+			status:         &tacokumogithubiov1alpha1.BlueGreenStatus{ActiveColor: "blue"},
+			expectActive:   tacokumogithubiov1alpha1.BlueGreenColorBlue,
+		},
+		{
+			name:           "verified preview becomes active",
+			deployedCommit: "old",
+			status: &tacokumogithubiov1alpha1.BlueGreenStatus{
+				ActiveColor: "blue", PreviewColor: "green", PreviewCommit: "new", PreviewVerified: true,
+			},
+			expectActive: tacokumogithubiov1alpha1.BlueGreenColorGreen,
+		},
+		{
+			name:           "unverified preview is verified again",
+			deployedCommit: "old",
+			status: &tacokumogithubiov1alpha1.BlueGreenStatus{
+				ActiveColor: "blue", PreviewColor: "green", PreviewCommit: "new",
+			},
+			expectPreview: true,
+			expectActive:  tacokumogithubiov1alpha1.BlueGreenColorBlue,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rel := &tacokumogithubiov1alpha1.Release{
+				Spec: tacokumogithubiov1alpha1.ReleaseSpec{
+					Commit:   ptr.To("new"),
+					Strategy: newBlueGreenTestStrategy(nil),
+				},
+				Status: tacokumogithubiov1alpha1.ReleaseStatus{
+					DeployedCommit: tt.deployedCommit,
+					BlueGreen:      tt.status,
+				},
+			}
+			assert.Equal(t, tt.expectPreview, shouldStartPreview(rel))
+			assert.Equal(t, tt.expectActive, nextActiveColor(rel))
+		})
+	}
+}
+
+func TestRecordActiveColor(t *testing.T) {
+	rel := &tacokumogithubiov1alpha1.Release{
+		Spec: tacokumogithubiov1alpha1.ReleaseSpec{
+			Commit:   ptr.To("new"),
+			Strategy: newBlueGreenTestStrategy(nil),
+		},
+		Status: tacokumogithubiov1alpha1.ReleaseStatus{
+			DeployedCommit: "old",
+			BlueGreen: &tacokumogithubiov1alpha1.BlueGreenStatus{
+				ActiveColor: "blue", PreviewColor: "green", PreviewCommit: "new", PreviewVerified: true,
+			},
+		},
+	}
+
+	recordActiveColor(rel, tacokumogithubiov1alpha1.BlueGreenColorGreen)
+
+	bg := rel.Status.BlueGreen
+	assert.Equal(t, "green", bg.ActiveColor)
+	// 以前のcolorは即座にロールバックできるよう検証済みとして残す
+	assert.Equal(t, "blue", bg.PreviewColor)
+	assert.Equal(t, "old", bg.PreviewCommit)
+	assert.True(t, bg.PreviewVerified)
+	require.NotNil(t, bg.ScaleDownAt)
+	assert.WithinDuration(t, time.Now().Add(10*time.Minute), bg.ScaleDownAt.Time, time.Minute)
+
+	// 以前のコミットに戻すと､プレビューを起動せずに切り替える
+	rel.Spec.Commit = ptr.To("old")
+	rel.Status.DeployedCommit = "new"
+	assert.False(t, shouldStartPreview(rel))
+	assert.Equal(t, "blue", nextActiveColor(rel))
+}
+
+func TestManager_Reconcile_OnPreviewingState(t *testing.T) {
+	smokeTest := &tacokumogithubiov1alpha1.SmokeTest{
+		Command: []string{"/bin/smoke", "--target", "$(PREVIEW_SERVICE_HOST)"},
+	}
+	tests := []struct {
+		name              string
+		previewStatus     appsv1.DeploymentStatus
+		smokeTest         *tacokumogithubiov1alpha1.SmokeTest
+		smokeJobCondition *batchv1.JobCondition
+		expectErr         bool
+		expectState       string
+		expectVerified    bool
+		expectReason      string
+		expectSmokeJob    bool
+	}{
+		{
+			name: "waits for the preview to become ready",
+			previewStatus: appsv1.DeploymentStatus{
+				ObservedGeneration: 1, Replicas: 2, UpdatedReplicas: 2, AvailableReplicas: 1,
+			},
+			smokeTest:    smokeTest,
+			expectState:  tacokumogithubiov1alpha1.ReleaseStatePreviewing,
+			expectReason: tacokumogithubiov1alpha1.ReasonPreviewProgressing,
+		},
+		{
+			name:           "switches when the preview is ready and no smoke test is configured",
+			previewStatus:  completeDeploymentStatus,
+			expectState:    tacokumogithubiov1alpha1.ReleaseStateDeploying,
+			expectVerified: true,
+			expectReason:   tacokumogithubiov1alpha1.ReasonPreviewProgressing,
+		},
+		{
+			name:           "starts the smoke test when the preview is ready",
+			previewStatus:  completeDeploymentStatus,
+			smokeTest:      smokeTest,
+			expectState:    tacokumogithubiov1alpha1.ReleaseStatePreviewing,
+			expectReason:   tacokumogithubiov1alpha1.ReasonPreviewProgressing,
+			expectSmokeJob: true,
+		},
+		{
+			name:              "switches when the smoke test succeeds",
+			previewStatus:     completeDeploymentStatus,
+			smokeTest:         smokeTest,
+			smokeJobCondition: &batchv1.JobCondition{Type: batchv1.JobComplete, Status: corev1.ConditionTrue},
+			expectState:       tacokumogithubiov1alpha1.ReleaseStateDeploying,
+			expectVerified:    true,
+			expectReason:      tacokumogithubiov1alpha1.ReasonPreviewProgressing,
+			expectSmokeJob:    true,
+		},
+		{
+			name:          "fails when the smoke test fails",
+			previewStatus: completeDeploymentStatus,
+			smokeTest:     smokeTest,
+			smokeJobCondition: &batchv1.JobCondition{
+				Type: batchv1.JobFailed, Status: corev1.ConditionTrue, Reason: "BackoffLimitExceeded",
+			},
+			expectErr:      true,
+			expectState:    tacokumogithubiov1alpha1.ReleaseStateFailed,
+			expectReason:   tacokumogithubiov1alpha1.ReasonSmokeTestFailed,
+			expectSmokeJob: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scheme := newProcessTestScheme(t)
+			rel := &tacokumogithubiov1alpha1.Release{
+				ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "default", Generation: 1, UID: "rel-uid"},
+				Spec: tacokumogithubiov1alpha1.ReleaseSpec{
+					Commit:   ptr.To("new"),
+					Strategy: newBlueGreenTestStrategy(tt.smokeTest),
+				},
+				Status: tacokumogithubiov1alpha1.ReleaseStatus{
+					State:              tacokumogithubiov1alpha1.ReleaseStatePreviewing,
+					ObservedGeneration: 1,
+					DeployedCommit:     "old",
+					BlueGreen: &tacokumogithubiov1alpha1.BlueGreenStatus{
+						ActiveColor: "blue", PreviewColor: "green", PreviewCommit: "new",
+					},
+				},
+			}
+			preview := newRolloutTestDeployment("app-green", 1, tt.previewStatus)
+			preview.Spec.Template.Spec.Containers = []corev1.Container{{
+				Name:  "app",
+				Image: testImage + "@" + testImageDigest,
+				Env:   []corev1.EnvVar{{Name: "MODE", Value: "production"}},
+			}}
+			objects := []client.Object{rel, preview}
+			if tt.smokeJobCondition != nil {
+				name := smokeTestJobNameForTest(rel, preview)
+				objects = append(objects, &batchv1.Job{
+					ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name},
+					Status:     batchv1.JobStatus{Conditions: []batchv1.JobCondition{*tt.smokeJobCondition}},
+				})
+			}
+			k8sClient := fake.NewClientBuilder().
+				WithScheme(scheme).
+				WithObjects(objects...).
+				WithStatusSubresource(rel).
+				Build()
+			m := newTestManager(t, k8sClient, nil, "/tmp/test")
+
+			err := m.Reconcile(context.Background(), rel)
+			if tt.expectErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+
+			updated := &tacokumogithubiov1alpha1.Release{}
+			require.NoError(t, k8sClient.Get(context.Background(), client.ObjectKeyFromObject(rel), updated))
+			assert.Equal(t, tt.expectState, updated.Status.State)
+			assert.Equal(t, tt.expectVerified, updated.Status.BlueGreen.PreviewVerified)
+			cond := meta.FindStatusCondition(updated.Status.Conditions, tacokumogithubiov1alpha1.ConditionTypePreview)
+			require.NotNil(t, cond)
+			assert.Equal(t, tt.expectReason, cond.Reason)
+
+			jobs := &batchv1.JobList{}
+			require.NoError(t, k8sClient.List(context.Background(), jobs))
+			if !tt.expectSmokeJob {
+				assert.Empty(t, jobs.Items)
+				return
+			}
+			require.Len(t, jobs.Items, 1)
+			if tt.smokeJobCondition == nil {
+				container := jobs.Items[0].Spec.Template.Spec.Containers[0]
+				assert.Equal(t, testImage+"@"+testImageDigest, container.Image)
+				assert.Equal(t, smokeTest.Command, container.Command)
+				assert.Equal(t, []corev1.EnvVar{
+					{Name: "MODE", Value: "production"},
+					{Name: "PREVIEW_SERVICE_HOST", Value: "app-preview.default.svc"},
+				}, container.Env)
+			}
+		})
+	}
+}
+
+func smokeTestJobNameForTest(rel *tacokumogithubiov1alpha1.Release, preview *appsv1.Deployment) string {
+	hook := smokeTestHook(rel.Spec.Strategy.BlueGreen.SmokeTest)
+	return hookJobName(rel, hook, preview.Spec.Template.Spec.Containers[0].Image)
+}
+
+func TestManager_Reconcile_OnDeployedState_ScalesDownPreviousColor(t *testing.T) {
+	tests := []struct {
+		name         string
+		scaleDownAt  time.Time
+		expectDelete bool
+	}{
+		{name: "keeps the previous color until the delay elapses", scaleDownAt: time.Now().Add(time.Minute)},
+		{
+			name:         "deletes the previous color after the delay",
+			scaleDownAt:  time.Now().Add(-time.Minute),
+			expectDelete: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scheme := newProcessTestScheme(t)
+			rel := &tacokumogithubiov1alpha1.Release{
+				ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "default", Generation: 1},
+				Spec: tacokumogithubiov1alpha1.ReleaseSpec{
+					Commit:   ptr.To("new"),
+					Strategy: newBlueGreenTestStrategy(nil),
+				},
+				Status: tacokumogithubiov1alpha1.ReleaseStatus{
+					State:              tacokumogithubiov1alpha1.ReleaseStateDeployed,
+					ObservedGeneration: 1,
+					DeployedCommit:     "new",
+					BlueGreen: &tacokumogithubiov1alpha1.BlueGreenStatus{
+						ActiveColor:     "green",
+						PreviewColor:    "blue",
+						PreviewCommit:   "old",
+						PreviewVerified: true,
+						ScaleDownAt:     ptr.To(metav1.NewTime(tt.scaleDownAt)),
+					},
+				},
+			}
+			previewSvc := &corev1.Service{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "app-preview"}}
+			k8sClient := fake.NewClientBuilder().
+				WithScheme(scheme).
+				WithObjects(
+					rel,
+					previewSvc,
+					newRolloutTestDeployment("app-green", 1, completeDeploymentStatus),
+					newRolloutTestDeployment("app-blue", 1, completeDeploymentStatus),
+				).
+				WithStatusSubresource(rel).
+				Build()
+			m := newTestManager(t, k8sClient, nil, "/tmp/test")
+
+			require.NoError(t, m.Reconcile(context.Background(), rel))
+			assert.Equal(t, tacokumogithubiov1alpha1.ReleaseStateDeployed, rel.Status.State)
+			assert.Equal(t, "green", rel.Status.BlueGreen.ActiveColor)
+
+			blueKey := client.ObjectKey{Namespace: "default", Name: "app-blue"}
+			blueErr := k8sClient.Get(context.Background(), blueKey, &appsv1.Deployment{})
+			svcErr := k8sClient.Get(context.Background(), client.ObjectKeyFromObject(previewSvc), &corev1.Service{})
+			if tt.expectDelete {
+				assert.True(t, apierrors.IsNotFound(blueErr))
+				assert.True(t, apierrors.IsNotFound(svcErr))
+				assert.Empty(t, rel.Status.BlueGreen.PreviewColor)
+				assert.Nil(t, rel.Status.BlueGreen.ScaleDownAt)
+				return
+			}
+			assert.NoError(t, blueErr)
+			assert.NoError(t, svcErr)
+			assert.Equal(t, "blue", rel.Status.BlueGreen.PreviewColor)
+		})
+	}
+}
+
+func TestManager_Reconcile_OnRollingOutState_UsesActiveColor(t *testing.T) {
+	scheme := newProcessTestScheme(t)
+	rel := &tacokumogithubiov1alpha1.Release{
+		ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "default", Generation: 1},
+		Spec: tacokumogithubiov1alpha1.ReleaseSpec{
+			Commit:   ptr.To("new"),
+			Strategy: newBlueGreenTestStrategy(nil),
+		},
+		Status: tacokumogithubiov1alpha1.ReleaseStatus{
+			State:              tacokumogithubiov1alpha1.ReleaseStateRollingOut,
+			ObservedGeneration: 1,
+			BlueGreen:          &tacokumogithubiov1alpha1.BlueGreenStatus{ActiveColor: "blue"},
+		},
+	}
+	k8sClient := fake.NewClientBuilder().
+		WithScheme(scheme).
+		WithObjects(
+			rel,
+			// RollingUpdateから切り替えた場合に残るDeployment
+			newRolloutTestDeployment("app", 1, completeDeploymentStatus),
+			newRolloutTestDeployment("app-blue", 1, completeDeploymentStatus),
+		).
+		WithStatusSubresource(rel).
+		Build()
+	m := newTestManager(t, k8sClient, nil, "/tmp/test")
+
+	require.NoError(t, m.Reconcile(context.Background(), rel))
+	assert.Equal(t, tacokumogithubiov1alpha1.ReleaseStateDeployed, rel.Status.State)
+	assert.Equal(t, "new", rel.Status.DeployedCommit)
+
+	key := client.ObjectKey{Namespace: "default", Name: "app"}
+	err := k8sClient.Get(context.Background(), key, &appsv1.Deployment{})
+	assert.True(t, apierrors.IsNotFound(err))
+}
@@ -0,0 +1,365 @@
+package release
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"text/template"
+	"time"
+
+	tacokumogithubiov1alpha1 "github.com/tacokumo/portal-controller-kubernetes/api/v1alpha1"
+	"github.com/tacokumo/portal-controller-kubernetes/pkg/analysis"
+	"github.com/tacokumo/portal-controller-kubernetes/pkg/helmutil"
+
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+const (
+	// canaryTrackLabelKey はカナリアのPodを現在のPodと区別するためのラベルのキー
+	canaryTrackLabelKey = "track"
+	// canaryTrackLabelValue はカナリアのPodに付与されるラベルの値
+	canaryTrackLabelValue = "canary"
+	// defaultCanaryAnalysisTimeout は分析を評価できない状態が続いた場合にロールバックするまでのデフォルトの時間
+	defaultCanaryAnalysisTimeout = 5 * time.Minute
+)
+
+// shouldStartCanary はReleaseをカナリアリリースで更新するかどうかを返す
+// 初回のデプロイは比較対象のワークロードが存在しないため､通常通り適用する
+func shouldStartCanary(rel *tacokumogithubiov1alpha1.Release) bool {
+	strategy := rel.Spec.Strategy
+	if strategy == nil || strategy.Type != tacokumogithubiov1alpha1.ReleaseStrategyCanary || strategy.Canary == nil {
+		return false
+	}
+	commit := ptr.Deref(rel.Spec.Commit, "")
+	if rel.Status.DeployedCommit == "" || rel.Status.DeployedCommit == commit {
+		return false
+	}
+	// 全てのステップが成功したカナリアは新しいコミットとして適用する
+	c := rel.Status.Canary
+	return c == nil || c.Commit != commit || c.Phase != tacokumogithubiov1alpha1.CanaryPhasePromoted
+}
+
+// startCanary はレンダリングされたDeploymentからカナリアのDeploymentを作成する
+// 現在のDeploymentやServiceは更新せず､カナリアのPodを同じServiceに参加させる
+func (m *Manager) startCanary(
+	ctx context.Context,
+	rel *tacokumogithubiov1alpha1.Release,
+	objects []*unstructured.Unstructured,
+) error {
+	rendered := findObject(objects, "Deployment", rel.Name)
+	if rendered == nil {
+		return fmt.Errorf("chart did not render deployment %s", rel.Name)
+	}
+
+	stable, err := m.stableReplicas(ctx, rel)
+	if err != nil {
+		return err
+	}
+	steps := rel.Spec.Strategy.Canary.Steps
+	canary, err := canaryDeploymentFrom(rendered, rel, canaryReplicas(stable, steps[0].Weight))
+	if err != nil {
+		return err
+	}
+	if err := controllerutil.SetControllerReference(rel, canary, m.k8sClient.Scheme()); err != nil {
+		return err
+	}
+	if err := helmutil.CreateOrUpdateObject(ctx, m.k8sClient, canary); err != nil {
+		return err
+	}
+
+	rel.Status.Canary = &tacokumogithubiov1alpha1.CanaryStatus{
+		Commit:  ptr.Deref(rel.Spec.Commit, ""),
+		Phase:   tacokumogithubiov1alpha1.CanaryPhaseProgressing,
+		Step:    0,
+		Message: fmt.Sprintf("step 1/%d: scaling canary to %d%%", len(steps), steps[0].Weight),
+	}
+	setCanaryCondition(rel, metav1.ConditionTrue,
+		tacokumogithubiov1alpha1.ReasonCanaryProgressing, rel.Status.Canary.Message)
+	rel.Status.ObservedGeneration = rel.Generation
+	rel.Status.State = tacokumogithubiov1alpha1.ReleaseStateCanary
+	return nil
+}
+
+// reconcileOnCanaryState はカナリアのステップを1つずつ進める
+// ステップの分析が失敗した場合はカナリアを削除し､以前のコミットのワークロードに戻す
+func (m *Manager) reconcileOnCanaryState(
+	ctx context.Context,
+	rel *tacokumogithubiov1alpha1.Release,
+) error {
+	// カナリアの途中でspecが更新された場合は､新しいspecでやり直す
+	if rel.Generation != rel.Status.ObservedGeneration || rel.Status.Canary == nil ||
+		rel.Spec.Strategy == nil || rel.Spec.Strategy.Canary == nil {
+		if err := m.deleteCanary(ctx, rel); err != nil {
+			return err
+		}
+		rel.Status.State = tacokumogithubiov1alpha1.ReleaseStateDeploying
+		return nil
+	}
+
+	if _, err := m.updatePodHealth(ctx, rel); err != nil {
+		return err
+	}
+
+	c := rel.Status.Canary
+	steps := rel.Spec.Strategy.Canary.Steps
+	if int(c.Step) >= len(steps) {
+		m.promoteCanary(rel)
+		return nil
+	}
+	step := steps[c.Step]
+	progress := fmt.Sprintf("step %d/%d", c.Step+1, len(steps))
+
+	stable, err := m.stableReplicas(ctx, rel)
+	if err != nil {
+		return err
+	}
+	canary := &appsv1.Deployment{}
+	key := client.ObjectKey{Namespace: rel.Namespace, Name: canaryName(rel)}
+	if err := m.k8sClient.Get(ctx, key, canary); err != nil {
+		return fmt.Errorf("failed to get canary deployment: %w", err)
+	}
+
+	desired := canaryReplicas(stable, step.Weight)
+	if ptr.Deref(canary.Spec.Replicas, 1) != desired {
+		canary.Spec.Replicas = ptr.To(desired)
+		if err := m.k8sClient.Update(ctx, canary); err != nil {
+			return fmt.Errorf("failed to scale canary deployment: %w", err)
+		}
+		c.StepStartedAt = nil
+		m.setCanaryProgress(rel,
+			fmt.Sprintf("%s: scaling canary to %d replicas (%d%%)", progress, desired, step.Weight))
+		return nil
+	}
+
+	done, message, err := deploymentRolloutStatus(canary)
+	if err != nil {
+		return m.rollbackCanary(ctx, rel, fmt.Sprintf("%s: canary deployment: %s", progress, message))
+	}
+	if !done {
+		m.setCanaryProgress(rel, fmt.Sprintf("%s: canary deployment: %s", progress, message))
+		return nil
+	}
+
+	if c.StepStartedAt == nil {
+		c.StepStartedAt = ptr.To(metav1.Now())
+	}
+	if step.Pause != nil {
+		if remaining := time.Until(c.StepStartedAt.Add(step.Pause.Duration)); remaining > 0 {
+			m.setCanaryProgress(rel, fmt.Sprintf("%s: pausing for %s before analysis",
+				progress, remaining.Round(time.Second)))
+			return nil
+		}
+	}
+
+	passed, reason, err := m.analyzeCanary(ctx, rel)
+	if err != nil {
+		// 分析バックエンドの一時的な障害ではすぐにロールバックせず､タイムアウトするまで再評価する
+		if c.AnalysisFailingSince == nil {
+			c.AnalysisFailingSince = ptr.To(metav1.Now())
+		}
+		if timeout := canaryAnalysisTimeout(rel); time.Since(c.AnalysisFailingSince.Time) >= timeout {
+			return m.rollbackCanary(ctx, rel,
+				fmt.Sprintf("%s: analysis could not be evaluated for %s: %s", progress, timeout, err))
+		}
+		m.setCanaryProgress(rel, fmt.Sprintf("%s: analysis could not be evaluated: %s", progress, err))
+		return nil
+	}
+	c.AnalysisFailingSince = nil
+	if !passed {
+		return m.rollbackCanary(ctx, rel, fmt.Sprintf("%s: %s", progress, reason))
+	}
+
+	c.Step++
+	c.StepStartedAt = nil
+	if int(c.Step) >= len(steps) {
+		m.promoteCanary(rel)
+		return nil
+	}
+	m.setCanaryProgress(rel,
+		fmt.Sprintf("step %d/%d: scaling canary to %d%%", c.Step+1, len(steps), steps[c.Step].Weight))
+	return nil
+}
+
+// analyzeCanary は全てのメトリクスを評価し､閾値を満たしているかどうかを返す
+// 閾値を満たしていない場合はその理由を返す
+func (m *Manager) analyzeCanary(
+	ctx context.Context,
+	rel *tacokumogithubiov1alpha1.Release,
+) (bool, string, error) {
+	spec := rel.Spec.Strategy.Canary.Analysis
+	if spec == nil {
+		return true, "", nil
+	}
+
+	vars := canaryQueryVars{
+		Namespace: rel.Namespace,
+		Release:   rel.Name,
+		Canary:    canaryName(rel),
+		Commit:    rel.Status.Canary.Commit,
+	}
+	for _, metric := range spec.Metrics {
+		query, err := renderCanaryQuery(metric.Query, vars)
+		if err != nil {
+			return false, "", fmt.Errorf("metric %s: %w", metric.Name, err)
+		}
+		value, err := m.analysisProvider.Query(ctx, spec.Address, query)
+		if err != nil {
+			return false, "", fmt.Errorf("metric %s: %w", metric.Name, err)
+		}
+		ok, reason, err := analysis.Threshold{Min: metric.Min, Max: metric.Max}.Evaluate(value)
+		if err != nil {
+			return false, "", fmt.Errorf("metric %s: %w", metric.Name, err)
+		}
+		if !ok {
+			return false, fmt.Sprintf("metric %s: %s", metric.Name, reason), nil
+		}
+	}
+	return true, "", nil
+}
+
+// canaryAnalysisTimeout は分析を評価できない状態が続いた場合にロールバックするまでの時間を返す
+func canaryAnalysisTimeout(rel *tacokumogithubiov1alpha1.Release) time.Duration {
+	if spec := rel.Spec.Strategy.Canary.Analysis; spec != nil && spec.Timeout != nil {
+		return spec.Timeout.Duration
+	}
+	return defaultCanaryAnalysisTimeout
+}
+
+// canaryQueryVars はメトリクスのクエリに埋め込める値
+type canaryQueryVars struct {
+	Namespace string
+	Release   string
+	Canary    string
+	Commit    string
+}
+
+func renderCanaryQuery(query string, vars canaryQueryVars) (string, error) {
+	tmpl, err := template.New("query").Parse(query)
+	if err != nil {
+		return "", fmt.Errorf("invalid query template: %w", err)
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, vars); err != nil {
+		return "", fmt.Errorf("invalid query template: %w", err)
+	}
+	return buf.String(), nil
+}
+
+// promoteCanary は新しいコミットの全てのワークロードを適用するためにDeployingに遷移する
+// カナリアのDeploymentはロールアウトが完了するまで残される
+func (m *Manager) promoteCanary(rel *tacokumogithubiov1alpha1.Release) {
+	rel.Status.Canary.Phase = tacokumogithubiov1alpha1.CanaryPhasePromoted
+	rel.Status.Canary.Message = "all canary steps succeeded"
+	setCanaryCondition(rel, metav1.ConditionFalse,
+		tacokumogithubiov1alpha1.ReasonCanaryPromoted, rel.Status.Canary.Message)
+	rel.Status.State = tacokumogithubiov1alpha1.ReleaseStateDeploying
+}
+
+// rollbackCanary はカナリアを削除し､以前のコミットのワークロードのみが稼働する状態に戻す
+func (m *Manager) rollbackCanary(
+	ctx context.Context,
+	rel *tacokumogithubiov1alpha1.Release,
+	reason string,
+) error {
+	if err := m.deleteCanary(ctx, rel); err != nil {
+		return err
+	}
+	m.logger.Info("rolled back canary release", "commit", rel.Status.Canary.Commit, "reason", reason)
+
+	message := fmt.Sprintf("canary analysis failed, rolled back to %s: %s", rel.Status.DeployedCommit, reason)
+	rel.Status.Canary.Phase = tacokumogithubiov1alpha1.CanaryPhaseFailed
+	rel.Status.Canary.StepStartedAt = nil
+	rel.Status.Canary.AnalysisFailingSince = nil
+	rel.Status.Canary.Message = reason
+	setCanaryCondition(rel, metav1.ConditionFalse, tacokumogithubiov1alpha1.ReasonCanaryAnalysisFailed, message)
+	tacokumogithubiov1alpha1.SetReadyConditionFalse(
+		&rel.Status.Conditions,
+		rel.Generation,
+		tacokumogithubiov1alpha1.ReasonCanaryAnalysisFailed,
+		message,
+	)
+	rel.Status.State = tacokumogithubiov1alpha1.ReleaseStateRolledBack
+	return nil
+}
+
+func (m *Manager) deleteCanary(ctx context.Context, rel *tacokumogithubiov1alpha1.Release) error {
+	canary := &appsv1.Deployment{}
+	canary.SetNamespace(rel.Namespace)
+	canary.SetName(canaryName(rel))
+	if err := m.k8sClient.Delete(ctx, canary); client.IgnoreNotFound(err) != nil {
+		return fmt.Errorf("failed to delete canary deployment: %w", err)
+	}
+	return nil
+}
+
+func (m *Manager) setCanaryProgress(rel *tacokumogithubiov1alpha1.Release, message string) {
+	rel.Status.Canary.Message = message
+	setCanaryCondition(rel, metav1.ConditionTrue, tacokumogithubiov1alpha1.ReasonCanaryProgressing, message)
+}
+
+// stableReplicas は現在のDeploymentのレプリカ数を返す
+// HPAによってスケールされている場合はその値が使用される
+func (m *Manager) stableReplicas(ctx context.Context, rel *tacokumogithubiov1alpha1.Release) (int32, error) {
+	deploy := &appsv1.Deployment{}
+	if err := m.k8sClient.Get(ctx, client.ObjectKey{Namespace: rel.Namespace, Name: rel.Name}, deploy); err != nil {
+		return 0, fmt.Errorf("failed to get deployment %s: %w", rel.Name, err)
+	}
+	return ptr.Deref(deploy.Spec.Replicas, 1), nil
+}
+
+// canaryDeploymentFrom はレンダリングされたDeploymentをカナリア用に書き換えたコピーを返す
+// Podは `application` ラベルを保つためServiceの対象となり､`track` ラベルでセレクタを分ける
+func canaryDeploymentFrom(
+	rendered *unstructured.Unstructured,
+	rel *tacokumogithubiov1alpha1.Release,
+	replicas int32,
+) (*unstructured.Unstructured, error) {
+	canary := rendered.DeepCopy()
+	canary.SetName(canaryName(rel))
+	canary.SetNamespace(rel.Namespace)
+	canary.SetResourceVersion("")
+	canary.SetOwnerReferences(nil)
+
+	if err := addDeploymentLabel(canary, canaryTrackLabelKey, canaryTrackLabelValue); err != nil {
+		return nil, err
+	}
+	if err := unstructured.SetNestedField(canary.Object, int64(replicas), "spec", "replicas"); err != nil {
+		return nil, err
+	}
+	return canary, nil
+}
+
+// canaryReplicas は現在のレプリカ数に weight(%) を掛けたカナリアのレプリカ数を返す
+// 少なくとも1つのPodが起動するよう切り上げる
+func canaryReplicas(stable int32, weight int32) int32 {
+	replicas := (stable*weight + 99) / 100
+	if replicas < 1 {
+		return 1
+	}
+	return replicas
+}
+
+func canaryName(rel *tacokumogithubiov1alpha1.Release) string {
+	return rel.Name + "-canary"
+}
+
+func setCanaryCondition(
+	rel *tacokumogithubiov1alpha1.Release,
+	status metav1.ConditionStatus,
+	reason string,
+	message string,
+) {
+	meta.SetStatusCondition(&rel.Status.Conditions, metav1.Condition{
+		Type:               tacokumogithubiov1alpha1.ConditionTypeCanary,
+		Status:             status,
+		ObservedGeneration: rel.Generation,
+		Reason:             reason,
+		Message:            message,
+	})
+}
@@ -0,0 +1,458 @@
+package release
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	tacokumogithubiov1alpha1 "github.com/tacokumo/portal-controller-kubernetes/api/v1alpha1"
+	"github.com/tacokumo/portal-controller-kubernetes/pkg/analysis"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// startTestAnalysisBackend はクエリごとに固定の値を返す Prometheus 互換のサーバーを起動する
+// 値が設定されていないクエリには 503 を返す
+func startTestAnalysisBackend(t *testing.T, values map[string]string) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if err := req.ParseForm(); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		value, ok := values[req.Form.Get("query")]
+		if !ok {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			_, _ = fmt.Fprint(w, `{"status":"error","errorType":"unavailable","error":"no data"}`)
+			return
+		}
+		_, _ = fmt.Fprintf(w, `{"status":"success","data":{"resultType":"scalar","result":[1700000000,%q]}}`, value)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func newCanaryTestStrategy(address string) *tacokumogithubiov1alpha1.ReleaseStrategy {
+	return &tacokumogithubiov1alpha1.ReleaseStrategy{
+		Type: tacokumogithubiov1alpha1.ReleaseStrategyCanary,
+		Canary: &tacokumogithubiov1alpha1.CanaryStrategy{
+			Steps: []tacokumogithubiov1alpha1.CanaryStep{
+				{Weight: 25, Pause: &metav1.Duration{Duration: 5 * time.Minute}},
+				{Weight: 50},
+			},
+			Analysis: &tacokumogithubiov1alpha1.CanaryAnalysis{
+				Address: address,
+				Metrics: []tacokumogithubiov1alpha1.CanaryMetric{{
+					Name:  "error-rate",
+					Query: `error_rate{namespace="{{ .Namespace }}",deployment="{{ .Canary }}"}`,
+					Max:   ptr.To("0.05"),
+				}},
+			},
+		},
+	}
+}
+
+func TestShouldStartCanary(t *testing.T) {
+	canary := &tacokumogithubiov1alpha1.ReleaseStrategy{
+		Type:   tacokumogithubiov1alpha1.ReleaseStrategyCanary,
+		Canary: &tacokumogithubiov1alpha1.CanaryStrategy{Steps: []tacokumogithubiov1alpha1.CanaryStep{{Weight: 10}}},
+	}
+	tests := []struct {
+		name           string
+		strategy       *tacokumogithubiov1alpha1.ReleaseStrategy
+		deployedCommit string
+		canaryStatus   *tacokumogithubiov1alpha1.CanaryStatus
+		expected       bool
+	}{
+		{name: "no strategy", deployedCommit: "old", expected: false},
+		{
+			name: "rolling update strategy",
+			strategy: &tacokumogithubiov1alpha1.ReleaseStrategy{
+				Type: tacokumogithubiov1alpha1.ReleaseStrategyRollingUpdate,
+			},
+			deployedCommit: "old",
+			expected:       false,
+		},
+		{name: "first deployment", strategy: canary, expected: false},
+		{name: "same commit is already deployed", strategy: canary, deployedCommit: "new", expected: false},
+		{name: "new commit", strategy: canary, deployedCommit: "old", expected: true},
+		{
+			name:           "canary of a previous commit was promoted",
+			strategy:       canary,
+			deployedCommit: "old",
+			canaryStatus: &tacokumogithubiov1alpha1.CanaryStatus{
+				Commit: "older", Phase: tacokumogithubiov1alpha1.CanaryPhasePromoted,
+			},
+			expected: true,
+		},
+		{
+			name:           "canary of the commit was promoted",
+			strategy:       canary,
+			deployedCommit: "old",
+			canaryStatus: &tacokumogithubiov1alpha1.CanaryStatus{
+				Commit: "new", Phase: tacokumogithubiov1alpha1.CanaryPhasePromoted,
+			},
+			expected: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rel := &tacokumogithubiov1alpha1.Release{
+				Spec: tacokumogithubiov1alpha1.ReleaseSpec{Commit: ptr.To("new"), Strategy: tt.strategy},
+				Status: tacokumogithubiov1alpha1.ReleaseStatus{
+					DeployedCommit: tt.deployedCommit,
+					Canary:         tt.canaryStatus,
+				},
+			}
+			assert.Equal(t, tt.expected, shouldStartCanary(rel))
+		})
+	}
+}
+
+func TestCanaryReplicas(t *testing.T) {
+	tests := []struct {
+		stable   int32
+		weight   int32
+		expected int32
+	}{
+		{stable: 4, weight: 25, expected: 1},
+		{stable: 4, weight: 50, expected: 2},
+		{stable: 3, weight: 50, expected: 2},
+		{stable: 1, weight: 10, expected: 1},
+		{stable: 0, weight: 10, expected: 1},
+		{stable: 4, weight: 100, expected: 4},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%d replicas at %d%%", tt.stable, tt.weight), func(t *testing.T) {
+			assert.Equal(t, tt.expected, canaryReplicas(tt.stable, tt.weight))
+		})
+	}
+}
+
+func TestCanaryDeploymentFrom(t *testing.T) {
+	rel := &tacokumogithubiov1alpha1.Release{
+		ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "default"},
+	}
+	rendered := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "apps/v1",
+		"kind":       "Deployment",
+		"metadata": map[string]interface{}{
+			"name":   "app",
+			"labels": map[string]interface{}{"application": "app"},
+		},
+		"spec": map[string]interface{}{
+			"replicas": int64(4),
+			"selector": map[string]interface{}{
+				"matchLabels": map[string]interface{}{"application": "app"},
+			},
+			"template": map[string]interface{}{
+				"metadata": map[string]interface{}{
+					"labels": map[string]interface{}{"application": "app"},
+				},
+			},
+		},
+	}}
+
+	canary, err := canaryDeploymentFrom(rendered, rel, 1)
+	require.NoError(t, err)
+
+	assert.Equal(t, "app-canary", canary.GetName())
+	assert.Equal(t, "default", canary.GetNamespace())
+	assert.Equal(t, map[string]string{"application": "app", "track": "canary"}, canary.GetLabels())
+	selector, _, _ := unstructured.NestedStringMap(canary.Object, "spec", "selector", "matchLabels")
+	assert.Equal(t, map[string]string{"application": "app", "track": "canary"}, selector)
+	podLabels, _, _ := unstructured.NestedStringMap(canary.Object, "spec", "template", "metadata", "labels")
+	assert.Equal(t, map[string]string{"application": "app", "track": "canary"}, podLabels)
+	replicas, _, _ := unstructured.NestedInt64(canary.Object, "spec", "replicas")
+	assert.Equal(t, int64(1), replicas)
+
+	// レンダリング結果は現在のDeploymentの適用にも使われるため変更しない
+	assert.Equal(t, "app", rendered.GetName())
+	assert.Equal(t, map[string]string{"application": "app"}, rendered.GetLabels())
+}
+
+func TestManager_Reconcile_OnCanaryState(t *testing.T) {
+	tests := []struct {
+		name                string
+		generation          int64
+		step                int32
+		stepStartedAt       *metav1.Time
+		failingSince        *metav1.Time
+		canaryReplicas      int32
+		errorRate           string
+		expectState         string
+		expectStep          int32
+		expectPhase         string
+		expectCanaryReason  string
+		expectCanaryDeleted bool
+		expectReplicas      int32
+	}{
+		{
+			name:               "scales the canary for the current step",
+			generation:         1,
+			step:               1,
+			canaryReplicas:     1,
+			errorRate:          "0.01",
+			expectState:        tacokumogithubiov1alpha1.ReleaseStateCanary,
+			expectStep:         1,
+			expectPhase:        tacokumogithubiov1alpha1.CanaryPhaseProgressing,
+			expectCanaryReason: tacokumogithubiov1alpha1.ReasonCanaryProgressing,
+			expectReplicas:     2,
+		},
+		{
+			name:               "pauses before analysis",
+			generation:         1,
+			step:               0,
+			stepStartedAt:      ptr.To(metav1.NewTime(time.Now().Add(-time.Minute))),
+			canaryReplicas:     1,
+			errorRate:          "0.01",
+			expectState:        tacokumogithubiov1alpha1.ReleaseStateCanary,
+			expectStep:         0,
+			expectPhase:        tacokumogithubiov1alpha1.CanaryPhaseProgressing,
+			expectCanaryReason: tacokumogithubiov1alpha1.ReasonCanaryProgressing,
+			expectReplicas:     1,
+		},
+		{
+			name:               "advances to the next step when analysis passes",
+			generation:         1,
+			step:               0,
+			stepStartedAt:      ptr.To(metav1.NewTime(time.Now().Add(-10 * time.Minute))),
+			canaryReplicas:     1,
+			errorRate:          "0.01",
+			expectState:        tacokumogithubiov1alpha1.ReleaseStateCanary,
+			expectStep:         1,
+			expectPhase:        tacokumogithubiov1alpha1.CanaryPhaseProgressing,
+			expectCanaryReason: tacokumogithubiov1alpha1.ReasonCanaryProgressing,
+			expectReplicas:     1,
+		},
+		{
+			name:               "promotes the commit when the last step passes",
+			generation:         1,
+			step:               1,
+			canaryReplicas:     2,
+			errorRate:          "0.01",
+			expectState:        tacokumogithubiov1alpha1.ReleaseStateDeploying,
+			expectStep:         2,
+			expectPhase:        tacokumogithubiov1alpha1.CanaryPhasePromoted,
+			expectCanaryReason: tacokumogithubiov1alpha1.ReasonCanaryPromoted,
+			expectReplicas:     2,
+		},
+		{
+			name:                "rolls back when analysis fails",
+			generation:          1,
+			step:                1,
+			canaryReplicas:      2,
+			errorRate:           "0.2",
+			expectState:         tacokumogithubiov1alpha1.ReleaseStateRolledBack,
+			expectStep:          1,
+			expectPhase:         tacokumogithubiov1alpha1.CanaryPhaseFailed,
+			expectCanaryReason:  tacokumogithubiov1alpha1.ReasonCanaryAnalysisFailed,
+			expectCanaryDeleted: true,
+		},
+		{
+			name:               "keeps the step when the analysis backend is unavailable",
+			generation:         1,
+			step:               1,
+			canaryReplicas:     2,
+			expectState:        tacokumogithubiov1alpha1.ReleaseStateCanary,
+			expectStep:         1,
+			expectPhase:        tacokumogithubiov1alpha1.CanaryPhaseProgressing,
+			expectCanaryReason: tacokumogithubiov1alpha1.ReasonCanaryProgressing,
+			expectReplicas:     2,
+		},
+		{
+			name:                "rolls back when the analysis backend stays unavailable beyond the timeout",
+			generation:          1,
+			step:                1,
+			failingSince:        ptr.To(metav1.NewTime(time.Now().Add(-10 * time.Minute))),
+			canaryReplicas:      2,
+			expectState:         tacokumogithubiov1alpha1.ReleaseStateRolledBack,
+			expectStep:          1,
+			expectPhase:         tacokumogithubiov1alpha1.CanaryPhaseFailed,
+			expectCanaryReason:  tacokumogithubiov1alpha1.ReasonCanaryAnalysisFailed,
+			expectCanaryDeleted: true,
+		},
+		{
+			name:                "restarts when spec is updated during the canary",
+			generation:          2,
+			step:                1,
+			canaryReplicas:      2,
+			errorRate:           "0.01",
+			expectState:         tacokumogithubiov1alpha1.ReleaseStateDeploying,
+			expectStep:          1,
+			expectPhase:         tacokumogithubiov1alpha1.CanaryPhaseProgressing,
+			expectCanaryDeleted: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			values := map[string]string{}
+			if tt.errorRate != "" {
+				values[`error_rate{namespace="default",deployment="app-canary"}`] = tt.errorRate
+			}
+			server := startTestAnalysisBackend(t, values)
+
+			scheme := newProcessTestScheme(t)
+			rel := &tacokumogithubiov1alpha1.Release{
+				ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "default", Generation: tt.generation},
+				Spec: tacokumogithubiov1alpha1.ReleaseSpec{
+					Commit:   ptr.To("new"),
+					Strategy: newCanaryTestStrategy(server.URL),
+				},
+				Status: tacokumogithubiov1alpha1.ReleaseStatus{
+					State:              tacokumogithubiov1alpha1.ReleaseStateCanary,
+					ObservedGeneration: 1,
+					DeployedCommit:     "old",
+					Canary: &tacokumogithubiov1alpha1.CanaryStatus{
+						Commit:               "new",
+						Phase:                tacokumogithubiov1alpha1.CanaryPhaseProgressing,
+						Step:                 tt.step,
+						StepStartedAt:        tt.stepStartedAt,
+						AnalysisFailingSince: tt.failingSince,
+					},
+				},
+			}
+			stable := newRolloutTestDeployment("app", 1, completeDeploymentStatus)
+			stable.Spec.Replicas = ptr.To(int32(4))
+			canary := newRolloutTestDeployment("app-canary", 1, appsv1.DeploymentStatus{
+				ObservedGeneration: 1,
+				Replicas:           tt.canaryReplicas,
+				UpdatedReplicas:    tt.canaryReplicas,
+				AvailableReplicas:  tt.canaryReplicas,
+			})
+			canary.Spec.Replicas = ptr.To(tt.canaryReplicas)
+			k8sClient := fake.NewClientBuilder().
+				WithScheme(scheme).
+				WithObjects(rel, stable, canary).
+				WithStatusSubresource(rel).
+				Build()
+			m := newTestManager(t, k8sClient, nil, "/tmp/test").
+				WithAnalysisProvider(analysis.NewPrometheusProvider().WithHTTPClient(server.Client()))
+
+			require.NoError(t, m.Reconcile(context.Background(), rel))
+
+			updated := &tacokumogithubiov1alpha1.Release{}
+			require.NoError(t, k8sClient.Get(context.Background(), client.ObjectKeyFromObject(rel), updated))
+			assert.Equal(t, tt.expectState, updated.Status.State)
+			require.NotNil(t, updated.Status.Canary)
+			assert.Equal(t, tt.expectStep, updated.Status.Canary.Step)
+			assert.Equal(t, tt.expectPhase, updated.Status.Canary.Phase)
+			if tt.expectCanaryReason != "" {
+				cond := meta.FindStatusCondition(
+					updated.Status.Conditions, tacokumogithubiov1alpha1.ConditionTypeCanary)
+				require.NotNil(t, cond)
+				assert.Equal(t, tt.expectCanaryReason, cond.Reason)
+			}
+
+			got := &appsv1.Deployment{}
+			err := k8sClient.Get(context.Background(), client.ObjectKey{Namespace: "default", Name: "app-canary"}, got)
+			if tt.expectCanaryDeleted {
+				assert.True(t, apierrors.IsNotFound(err))
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectReplicas, ptr.Deref(got.Spec.Replicas, 0))
+		})
+	}
+}
+
+func TestManager_Reconcile_OnCanaryState_RollbackKeepsPreviousCommit(t *testing.T) {
+	server := startTestAnalysisBackend(t, map[string]string{
+		`error_rate{namespace="default",deployment="app-canary"}`: "0.5",
+	})
+	scheme := newProcessTestScheme(t)
+	rel := &tacokumogithubiov1alpha1.Release{
+		ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "default", Generation: 1},
+		Spec: tacokumogithubiov1alpha1.ReleaseSpec{
+			Commit:   ptr.To("new"),
+			Strategy: newCanaryTestStrategy(server.URL),
+		},
+		Status: tacokumogithubiov1alpha1.ReleaseStatus{
+			State:              tacokumogithubiov1alpha1.ReleaseStateCanary,
+			ObservedGeneration: 1,
+			DeployedCommit:     "old",
+			Canary: &tacokumogithubiov1alpha1.CanaryStatus{
+				Commit: "new",
+				Phase:  tacokumogithubiov1alpha1.CanaryPhaseProgressing,
+				Step:   1,
+			},
+		},
+	}
+	stable := newRolloutTestDeployment("app", 1, completeDeploymentStatus)
+	stable.Spec.Replicas = ptr.To(int32(4))
+	canary := newRolloutTestDeployment("app-canary", 1, completeDeploymentStatus)
+	k8sClient := fake.NewClientBuilder().
+		WithScheme(scheme).
+		WithObjects(rel, stable, canary).
+		WithStatusSubresource(rel).
+		Build()
+	m := newTestManager(t, k8sClient, nil, "/tmp/test").
+		WithAnalysisProvider(analysis.NewPrometheusProvider().WithHTTPClient(server.Client()))
+
+	require.NoError(t, m.Reconcile(context.Background(), rel))
+	assert.Equal(t, tacokumogithubiov1alpha1.ReleaseStateRolledBack, rel.Status.State)
+	assert.Equal(t, "old", rel.Status.DeployedCommit)
+	assert.Contains(t, rel.Status.Canary.Message, "metric error-rate")
+	assert.True(t, meta.IsStatusConditionFalse(rel.Status.Conditions, tacokumogithubiov1alpha1.ConditionTypeReady))
+
+	// 現在のDeploymentは以前のコミットのまま残る
+	require.NoError(t, k8sClient.Get(context.Background(), client.ObjectKeyFromObject(stable), &appsv1.Deployment{}))
+
+	// 失敗したコミットはspecが更新されるまで再デプロイされない
+	require.NoError(t, m.Reconcile(context.Background(), rel))
+	assert.Equal(t, tacokumogithubiov1alpha1.ReleaseStateRolledBack, rel.Status.State)
+
+	rel.Generation = 2
+	require.NoError(t, m.Reconcile(context.Background(), rel))
+	assert.Equal(t, tacokumogithubiov1alpha1.ReleaseStateDeploying, rel.Status.State)
+}
+
+func TestManager_Reconcile_OnRollingOutState_FinishesCanary(t *testing.T) {
+	scheme := newProcessTestScheme(t)
+	rel := &tacokumogithubiov1alpha1.Release{
+		ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "default", Generation: 1},
+		Spec:       tacokumogithubiov1alpha1.ReleaseSpec{Commit: ptr.To("new")},
+		Status: tacokumogithubiov1alpha1.ReleaseStatus{
+			State:              tacokumogithubiov1alpha1.ReleaseStateRollingOut,
+			ObservedGeneration: 1,
+			DeployedCommit:     "old",
+			Canary: &tacokumogithubiov1alpha1.CanaryStatus{
+				Commit: "new",
+				Phase:  tacokumogithubiov1alpha1.CanaryPhasePromoted,
+				Step:   2,
+			},
+		},
+	}
+	k8sClient := fake.NewClientBuilder().
+		WithScheme(scheme).
+		WithObjects(
+			rel,
+			newRolloutTestDeployment("app", 1, completeDeploymentStatus),
+			newRolloutTestDeployment("app-canary", 1, completeDeploymentStatus),
+		).
+		WithStatusSubresource(rel).
+		Build()
+	m := newTestManager(t, k8sClient, nil, "/tmp/test")
+
+	require.NoError(t, m.Reconcile(context.Background(), rel))
+	assert.Equal(t, tacokumogithubiov1alpha1.ReleaseStateDeployed, rel.Status.State)
+	assert.Equal(t, "new", rel.Status.DeployedCommit)
+
+	key := client.ObjectKey{Namespace: "default", Name: "app-canary"}
+	err := k8sClient.Get(context.Background(), key, &appsv1.Deployment{})
+	assert.True(t, apierrors.IsNotFound(err))
+}
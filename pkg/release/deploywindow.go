@@ -0,0 +1,59 @@
+package release
+
+import (
+	"context"
+	"time"
+
+	tacokumogithubiov1alpha1 "github.com/tacokumo/portal-controller-kubernetes/api/v1alpha1"
+	"github.com/tacokumo/portal-controller-kubernetes/pkg/deploywindow"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// checkDeployWindow はクラスタ内のDeployWindowによってReleaseのデプロイが止められているかどうかを返す
+// 止められている間は BlockedByDeployWindow Conditionを記録し､開いた時点で削除する
+func (m *Manager) checkDeployWindow(
+	ctx context.Context,
+	rel *tacokumogithubiov1alpha1.Release,
+) (bool, error) {
+	windows := tacokumogithubiov1alpha1.DeployWindowList{}
+	if err := m.k8sClient.List(ctx, &windows); err != nil {
+		return false, err
+	}
+
+	target := deploywindow.Target{Stage: rel.Spec.Stage}
+	if appName := rel.Labels[tacokumogithubiov1alpha1.ApplicationLabelKey]; appName != "" {
+		app := &tacokumogithubiov1alpha1.Application{}
+		err := m.k8sClient.Get(ctx, client.ObjectKey{Namespace: rel.Namespace, Name: appName}, app)
+		if client.IgnoreNotFound(err) != nil {
+			return false, err
+		}
+		if err == nil {
+			target.ApplicationLabels = labels.Set(app.Labels)
+			if target.ApplicationLabels == nil {
+				target.ApplicationLabels = labels.Set{}
+			}
+		}
+	}
+
+	blocked, message := deploywindow.Evaluate(windows.Items, target, time.Now())
+	if !blocked {
+		meta.RemoveStatusCondition(&rel.Status.Conditions, tacokumogithubiov1alpha1.ConditionTypeBlockedByDeployWindow)
+		return false, nil
+	}
+
+	if !meta.IsStatusConditionTrue(rel.Status.Conditions, tacokumogithubiov1alpha1.ConditionTypeBlockedByDeployWindow) {
+		m.logger.Info("deployment is blocked by deploy window", "release", rel.Name, "message", message)
+	}
+	meta.SetStatusCondition(&rel.Status.Conditions, metav1.Condition{
+		Type:               tacokumogithubiov1alpha1.ConditionTypeBlockedByDeployWindow,
+		Status:             metav1.ConditionTrue,
+		ObservedGeneration: rel.Generation,
+		Reason:             tacokumogithubiov1alpha1.ReasonDeployWindowClosed,
+		Message:            message,
+	})
+	return true, nil
+}
@@ -0,0 +1,84 @@
+package release
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	tacokumogithubiov1alpha1 "github.com/tacokumo/portal-controller-kubernetes/api/v1alpha1"
+	"github.com/tacokumo/portal-controller-kubernetes/pkg/repoconnector"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestManager_reconcileOnDeployingState_BlockedByDeployWindow(t *testing.T) {
+	rel := &tacokumogithubiov1alpha1.Release{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test-app-production",
+			Namespace: "production",
+			Labels:    map[string]string{tacokumogithubiov1alpha1.ApplicationLabelKey: "test-app"},
+		},
+		Spec: tacokumogithubiov1alpha1.ReleaseSpec{
+			AppConfigPath: "appconfig.yaml",
+			Commit:        stringPtr("main"),
+			Stage:         "production",
+		},
+		Status: tacokumogithubiov1alpha1.ReleaseStatus{
+			State: tacokumogithubiov1alpha1.ReleaseStateDeploying,
+		},
+	}
+	app := &tacokumogithubiov1alpha1.Application{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test-app",
+			Namespace: "production",
+			Labels:    map[string]string{"team": "payments"},
+		},
+	}
+	freeze := &tacokumogithubiov1alpha1.DeployWindow{
+		ObjectMeta: metav1.ObjectMeta{Name: "freeze"},
+		Spec: tacokumogithubiov1alpha1.DeployWindowSpec{
+			Stages:              []string{"production"},
+			ApplicationSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"team": "payments"}},
+			Windows: []tacokumogithubiov1alpha1.DeployWindowRule{{
+				Type:     tacokumogithubiov1alpha1.DeployWindowTypeDeny,
+				Schedule: "* * * * *",
+				Duration: metav1.Duration{Duration: time.Hour},
+				Reason:   "release freeze",
+			}},
+		},
+	}
+	k8sClient := fake.NewClientBuilder().
+		WithScheme(newProcessTestScheme(t)).
+		WithObjects(rel, app, freeze).
+		WithStatusSubresource(rel, &tacokumogithubiov1alpha1.ReleaseRevision{}).
+		Build()
+	connector := repoconnector.NewLocalConnector(repoTestdataPath("release-test-data"))
+	m := newTestManager(t, k8sClient, connector, testdataPath(""))
+
+	// ウィンドウが閉じている間は何も適用せず Deploying のまま待つ
+	require.NoError(t, m.reconcileOnDeployingState(context.Background(), rel))
+	assert.Equal(t, tacokumogithubiov1alpha1.ReleaseStateDeploying, rel.Status.State)
+	assert.Equal(t, int64(0), rel.Status.Revision)
+	cond := meta.FindStatusCondition(rel.Status.Conditions, tacokumogithubiov1alpha1.ConditionTypeBlockedByDeployWindow)
+	require.NotNil(t, cond)
+	assert.Equal(t, metav1.ConditionTrue, cond.Status)
+	assert.Equal(t, tacokumogithubiov1alpha1.ReasonDeployWindowClosed, cond.Reason)
+	assert.Contains(t, cond.Message, `deploy window "freeze" denies deployments until`)
+	assert.Contains(t, cond.Message, "release freeze")
+
+	revisions := &tacokumogithubiov1alpha1.ReleaseRevisionList{}
+	require.NoError(t, k8sClient.List(context.Background(), revisions))
+	assert.Empty(t, revisions.Items)
+
+	// ウィンドウが開くと自動的にデプロイされる
+	require.NoError(t, k8sClient.Delete(context.Background(), freeze))
+	require.NoError(t, m.reconcileOnDeployingState(context.Background(), rel))
+	assert.Equal(t, tacokumogithubiov1alpha1.ReleaseStateRollingOut, rel.Status.State)
+	assert.Equal(t, int64(1), rel.Status.Revision)
+	assert.Nil(t,
+		meta.FindStatusCondition(rel.Status.Conditions, tacokumogithubiov1alpha1.ConditionTypeBlockedByDeployWindow))
+}
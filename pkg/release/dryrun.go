@@ -0,0 +1,116 @@
+package release
+
+import (
+	"context"
+
+	tacokumogithubiov1alpha1 "github.com/tacokumo/portal-controller-kubernetes/api/v1alpha1"
+	"github.com/tacokumo/portal-controller-kubernetes/pkg/dryrun"
+
+	"github.com/samber/lo"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// reconcileDryRun はReleaseのリソースを変更せずに､適用した場合の差分を rel.Status.DryRun に記録する
+// 差分はspecが更新された場合にのみ再計算される
+// pre-deployフックのJobと､カナリアやプレビューの途中の状態は差分に含まれない
+func (m *Manager) reconcileDryRun(
+	ctx context.Context,
+	rel *tacokumogithubiov1alpha1.Release,
+) error {
+	if rel.Status.DryRun != nil && rel.Status.DryRun.ObservedGeneration == rel.Generation {
+		return nil
+	}
+
+	changes, err := m.computeDryRunChanges(ctx, rel)
+	if err != nil {
+		dryrun.SetCondition(&rel.Status.Conditions, rel.Generation, "", err)
+		return err
+	}
+	rel.Status.DryRun = dryrun.NewStatus(rel.Generation, changes)
+	dryrun.SetCondition(&rel.Status.Conditions, rel.Generation, rel.Status.DryRun.Summary, nil)
+	return nil
+}
+
+func (m *Manager) computeDryRunChanges(
+	ctx context.Context,
+	rel *tacokumogithubiov1alpha1.Release,
+) ([]tacokumogithubiov1alpha1.DryRunChange, error) {
+	// ダイジェストの解決結果などが記録されないよう､コピーに対してレンダリングする
+	target := rel.DeepCopy()
+
+	objects, processes, err := m.desiredObjects(ctx, target)
+	if err != nil {
+		return nil, err
+	}
+	for _, obj := range append(objects, processes...) {
+		obj.SetNamespace(rel.Namespace)
+	}
+
+	// ブルーグリーンデプロイでは､切り替えが完了した後の状態と比較する
+	if isBlueGreen(target) {
+		color := nextActiveColor(target)
+		if target.Spec.RollbackTo == nil && shouldStartPreview(target) {
+			color = otherColor(color)
+		}
+		if err := applyBlueGreenColor(objects, target, color); err != nil {
+			return nil, err
+		}
+		if err := m.carryOverLiveReplicas(ctx, target, objects, color); err != nil {
+			return nil, err
+		}
+	}
+	objects = append(objects, processes...)
+
+	// 適用時に削除されるのは宣言されなくなったプロセスのワークロードのみ
+	current := processReferencesOf(processes)
+	deleted := lo.Filter(rel.Status.Processes, func(ref corev1.ObjectReference, _ int) bool {
+		return !lo.ContainsBy(current, func(c corev1.ObjectReference) bool {
+			return c.Kind == ref.Kind && c.Name == ref.Name
+		})
+	})
+
+	return dryrun.Compute(ctx, m.k8sClient, objects, deleted)
+}
+
+// desiredObjects は適用されるチャートのオブジェクトとプロセスのワークロードを返す
+// spec.rollbackTo が指定されている場合はリビジョンに記録されたものを返す
+func (m *Manager) desiredObjects(
+	ctx context.Context,
+	rel *tacokumogithubiov1alpha1.Release,
+) ([]*unstructured.Unstructured, []*unstructured.Unstructured, error) {
+	if rel.Spec.RollbackTo != nil {
+		rev, err := m.getDeployedRevision(ctx, rel, *rel.Spec.RollbackTo)
+		if err != nil {
+			return nil, nil, err
+		}
+		return revisionObjects(rev)
+	}
+
+	appCfg, err := m.loadAppConfig(ctx, rel)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := m.resolveImageDigest(ctx, rel, appCfg.Build.Image); err != nil {
+		return nil, nil, err
+	}
+	if err := m.resolveServiceBindings(ctx, rel); err != nil {
+		return nil, nil, err
+	}
+	if _, err := m.resolveEncryptedSecretName(ctx, rel, &appCfg); err != nil {
+		return nil, nil, err
+	}
+	values, err := m.constructReleaseValues(ctx, rel, &appCfg)
+	if err != nil {
+		return nil, nil, err
+	}
+	objects, err := m.renderChartObjects(rel, values, &appCfg)
+	if err != nil {
+		return nil, nil, err
+	}
+	processes, err := m.constructProcessObjects(ctx, rel, &appCfg)
+	if err != nil {
+		return nil, nil, err
+	}
+	return objects, processes, nil
+}
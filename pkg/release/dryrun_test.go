@@ -0,0 +1,120 @@
+package release
+
+import (
+	"context"
+	"testing"
+
+	tacokumogithubiov1alpha1 "github.com/tacokumo/portal-controller-kubernetes/api/v1alpha1"
+	"github.com/tacokumo/portal-controller-kubernetes/pkg/dryrun/dryruntest"
+	"github.com/tacokumo/portal-controller-kubernetes/pkg/repoconnector"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func newDryRunTestRelease() *tacokumogithubiov1alpha1.Release {
+	return &tacokumogithubiov1alpha1.Release{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "test-app-production",
+			Namespace:   "production",
+			Generation:  2,
+			Annotations: map[string]string{tacokumogithubiov1alpha1.DryRunAnnotationKey: "true"},
+		},
+		Spec: tacokumogithubiov1alpha1.ReleaseSpec{
+			AppConfigPath:   "appconfig.yaml",
+			AppConfigBranch: "main",
+			Commit:          stringPtr("main"),
+		},
+		Status: tacokumogithubiov1alpha1.ReleaseStatus{
+			State: tacokumogithubiov1alpha1.ReleaseStateDeployed,
+			Processes: []corev1.ObjectReference{
+				{
+					APIVersion: "apps/v1", Kind: "Deployment",
+					Namespace: "production", Name: "test-app-production-worker",
+				},
+			},
+		},
+	}
+}
+
+func TestManager_Reconcile_DryRun(t *testing.T) {
+	rel := newDryRunTestRelease()
+	liveConfig := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "production", Name: "test-app-production"},
+		Data:       map[string]string{"image": "myregistry.example.com/test-app:v0.9.0", "replicaCount": "1"},
+	}
+	worker := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "production", Name: "test-app-production-worker"},
+	}
+	k8sClient := fake.NewClientBuilder().
+		WithScheme(newProcessTestScheme(t)).
+		WithObjects(rel, liveConfig, worker).
+		WithStatusSubresource(rel).
+		WithInterceptorFuncs(dryruntest.ServerDryRun(t)).
+		Build()
+	connector := repoconnector.NewLocalConnector(repoTestdataPath("release-test-data"))
+	m := newTestManager(t, k8sClient, connector, testdataPath(""))
+
+	require.NoError(t, m.Reconcile(context.Background(), rel))
+
+	// dry-runの間は状態を遷移させない
+	assert.Equal(t, tacokumogithubiov1alpha1.ReleaseStateDeployed, rel.Status.State)
+	assert.Nil(t, rel.Status.Image)
+	require.NotNil(t, rel.Status.DryRun)
+	assert.Equal(t, int64(2), rel.Status.DryRun.ObservedGeneration)
+	assert.Equal(t, "0 created, 1 changed, 1 deleted", rel.Status.DryRun.Summary)
+	assert.Equal(t, []tacokumogithubiov1alpha1.DryRunChange{
+		{
+			Action: tacokumogithubiov1alpha1.DryRunActionChanged, APIVersion: "v1", Kind: "ConfigMap",
+			Namespace: "production", Name: "test-app-production", Fields: []string{"data.image"},
+		},
+		{
+			Action: tacokumogithubiov1alpha1.DryRunActionDeleted, APIVersion: "apps/v1", Kind: "Deployment",
+			Namespace: "production", Name: "test-app-production-worker",
+		},
+	}, rel.Status.DryRun.Changes)
+	condition := meta.FindStatusCondition(rel.Status.Conditions, tacokumogithubiov1alpha1.ConditionTypeDryRun)
+	require.NotNil(t, condition)
+	assert.Equal(t, metav1.ConditionTrue, condition.Status)
+	assert.Equal(t, tacokumogithubiov1alpha1.ReasonDryRunSucceeded, condition.Reason)
+
+	// 実際のオブジェクトは変更も削除もされない
+	cm := &corev1.ConfigMap{}
+	require.NoError(t, k8sClient.Get(context.Background(), client.ObjectKeyFromObject(liveConfig), cm))
+	assert.Equal(t, liveConfig.Data, cm.Data)
+	require.NoError(t, k8sClient.Get(context.Background(), client.ObjectKeyFromObject(worker), &appsv1.Deployment{}))
+
+	// specが変わらない限り再計算しない
+	computedAt := rel.Status.DryRun.ComputedAt
+	require.NoError(t, k8sClient.Delete(context.Background(), worker))
+	require.NoError(t, m.Reconcile(context.Background(), rel))
+	assert.Equal(t, computedAt, rel.Status.DryRun.ComputedAt)
+	assert.Len(t, rel.Status.DryRun.Changes, 2)
+}
+
+func TestManager_Reconcile_DryRunFailure(t *testing.T) {
+	rel := newDryRunTestRelease()
+	rel.Spec.AppConfigPath = "nonexistent.yaml"
+	k8sClient := fake.NewClientBuilder().
+		WithScheme(newProcessTestScheme(t)).
+		WithObjects(rel).
+		WithStatusSubresource(rel).
+		Build()
+	connector := repoconnector.NewLocalConnector(repoTestdataPath("release-test-data"))
+	m := newTestManager(t, k8sClient, connector, testdataPath(""))
+
+	require.Error(t, m.Reconcile(context.Background(), rel))
+
+	assert.Equal(t, tacokumogithubiov1alpha1.ReleaseStateDeployed, rel.Status.State)
+	assert.Nil(t, rel.Status.DryRun)
+	condition := meta.FindStatusCondition(rel.Status.Conditions, tacokumogithubiov1alpha1.ConditionTypeDryRun)
+	require.NotNil(t, condition)
+	assert.Equal(t, metav1.ConditionFalse, condition.Status)
+	assert.Equal(t, tacokumogithubiov1alpha1.ReasonDryRunFailed, condition.Reason)
+}
@@ -0,0 +1,245 @@
+package release
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	tacokumogithubiov1alpha1 "github.com/tacokumo/portal-controller-kubernetes/api/v1alpha1"
+	"github.com/tacokumo/portal-controller-kubernetes/pkg/appconfigext"
+
+	appconfig "github.com/tacokumo/appconfig"
+	applicationchart "github.com/tacokumo/helm-charts/charts/tacokumo-application"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+const (
+	// hookLabelKey はpre-deployフックのJobに付与されるラベルのキー
+	hookLabelKey = "tacokumo.github.io/hook"
+	// hookCommitAnnotationKey はフックのJobを作成したときのコミットを記録するアノテーションのキー
+	hookCommitAnnotationKey = "tacokumo.github.io/commit"
+	// maxJobNameLength はJobの名前の最大長
+	// Podに付与される job-name ラベルの値の上限に合わせる
+	maxJobNameLength = 63
+)
+
+// runPreDeployHooks はappconfigの `releases` に宣言されたコマンドをpre-deployフックとして順に実行する
+// 全てのJobが成功した場合のみ true を返す
+// Jobはコミットとイメージから決まる名前で作成されるため､同じReleaseで再実行されることはない
+// ただしデプロイされたコミット以外のJobはロールアウト完了後に削除されるため､以前のコミットに戻すと再実行される
+func (m *Manager) runPreDeployHooks(
+	ctx context.Context,
+	rel *tacokumogithubiov1alpha1.Release,
+	appCfg *appconfigext.AppConfig,
+) (bool, error) {
+	for _, hook := range appCfg.Releases {
+		job, err := m.ensureHookJob(ctx, rel, appCfg, hook)
+		if err != nil {
+			return false, err
+		}
+
+		if failed := findJobCondition(job, batchv1.JobFailed); failed != nil {
+			message := fmt.Sprintf("pre-deploy hook %q failed (job %s): %s",
+				hook.Name, job.Name, m.hookFailureMessage(ctx, job, failed))
+			setPreDeployHookCondition(rel, metav1.ConditionFalse, tacokumogithubiov1alpha1.ReasonHookFailed, message)
+			return false, errors.New(message)
+		}
+		if findJobCondition(job, batchv1.JobComplete) == nil {
+			setPreDeployHookCondition(rel, metav1.ConditionUnknown, tacokumogithubiov1alpha1.ReasonHookRunning,
+				fmt.Sprintf("waiting for pre-deploy hook %q (job %s) to complete", hook.Name, job.Name))
+			return false, nil
+		}
+	}
+
+	if len(appCfg.Releases) > 0 {
+		setPreDeployHookCondition(rel, metav1.ConditionTrue, tacokumogithubiov1alpha1.ReasonHookSucceeded,
+			"all pre-deploy hooks succeeded")
+	}
+	return true, nil
+}
+
+// ensureHookJob はフックのJobを取得し､存在しない場合は作成する
+func (m *Manager) ensureHookJob(
+	ctx context.Context,
+	rel *tacokumogithubiov1alpha1.Release,
+	appCfg *appconfigext.AppConfig,
+	hook appconfig.ReleaseConfig,
+) (*batchv1.Job, error) {
+	image := pinnedImage(rel, appCfg.Build.Image)
+	name := hookJobName(rel, hook, image)
+
+	job := &batchv1.Job{}
+	err := m.k8sClient.Get(ctx, client.ObjectKey{Namespace: rel.Namespace, Name: name}, job)
+	if err == nil {
+		return job, nil
+	}
+	if client.IgnoreNotFound(err) != nil {
+		return nil, err
+	}
+
+	requirements, err := resourceRequirementsOf(applicationchart.ResourceConfig{
+		Limits: applicationchart.ResourceSpec{CPU: hook.Resources.CPU, Memory: hook.Resources.Memory},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("pre-deploy hook %q: %w", hook.Name, err)
+	}
+
+	job = &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: rel.Namespace,
+			Labels: map[string]string{
+				"application": rel.Name,
+				hookLabelKey:  hook.Name,
+			},
+			Annotations: map[string]string{hookCommitAnnotationKey: ptr.Deref(rel.Spec.Commit, "")},
+		},
+		Spec: batchv1.JobSpec{
+			// マイグレーションは冪等とは限らないため再試行しない
+			BackoffLimit: ptr.To(int32(0)),
+			Template: corev1.PodTemplateSpec{
+				Spec: corev1.PodSpec{
+					RestartPolicy:    corev1.RestartPolicyNever,
+					ImagePullSecrets: rel.Spec.ImagePullSecrets,
+					Containers: []corev1.Container{{
+						Name:      "hook",
+						Image:     image,
+						Command:   hook.Action.Command,
+						Env:       containerEnvOf(rel, appCfg.Ext.Service.Env),
+						EnvFrom:   envFromOf(rel, appCfg),
+						Resources: requirements,
+						// 失敗時にログの末尾を終了メッセージとして取得できるようにする
+						TerminationMessagePolicy: corev1.TerminationMessageFallbackToLogsOnError,
+					}},
+				},
+			},
+		},
+	}
+	if err := controllerutil.SetControllerReference(rel, job, m.k8sClient.Scheme()); err != nil {
+		return nil, err
+	}
+	if err := m.k8sClient.Create(ctx, job); err != nil {
+		return nil, err
+	}
+	m.logger.Info("created pre-deploy hook job", "hook", hook.Name, "job", job.Name)
+	return job, nil
+}
+
+// hookFailureMessage はJobの失敗理由を返す
+// Podの終了メッセージが取得できる場合はそれを含める
+func (m *Manager) hookFailureMessage(
+	ctx context.Context,
+	job *batchv1.Job,
+	failed *batchv1.JobCondition,
+) string {
+	message := fmt.Sprintf("%s: %s", failed.Reason, failed.Message)
+
+	pods := &corev1.PodList{}
+	if err := m.k8sClient.List(ctx, pods,
+		client.InNamespace(job.Namespace),
+		client.MatchingLabels{batchv1.JobNameLabel: job.Name},
+	); err != nil {
+		m.logger.Error(err, "failed to list pods of pre-deploy hook job", "job", job.Name)
+		return message
+	}
+	for _, pod := range pods.Items {
+		for _, status := range pod.Status.ContainerStatuses {
+			if terminated := status.State.Terminated; terminated != nil && terminated.ExitCode != 0 {
+				return fmt.Sprintf("%s: exit code %d: %s",
+					message, terminated.ExitCode, strings.TrimSpace(terminated.Message))
+			}
+		}
+	}
+	return message
+}
+
+// hookJobName はフックのJobの名前を返す
+// コミット､イメージ､コマンドが同じであれば同じ名前となる
+func hookJobName(rel *tacokumogithubiov1alpha1.Release, hook appconfig.ReleaseConfig, image string) string {
+	h := sha256.New()
+	for _, s := range append([]string{ptr.Deref(rel.Spec.Commit, ""), image, hook.Name}, hook.Action.Command...) {
+		h.Write([]byte(s))
+		h.Write([]byte{0})
+	}
+	suffix := hex.EncodeToString(h.Sum(nil))[:10]
+
+	prefix := fmt.Sprintf("%s-%s", rel.Name, hook.Name)
+	if maxPrefix := maxJobNameLength - len(suffix) - 1; len(prefix) > maxPrefix {
+		prefix = strings.TrimRight(prefix[:maxPrefix], "-")
+	}
+	return fmt.Sprintf("%s-%s", prefix, suffix)
+}
+
+// pruneHookJobs はデプロイされたコミット以外のフックのJobを削除する
+// ロールアウトが完了した後に呼び出す
+// JobにTTLを設定すると､同じコミットを再デプロイしたときにJobが再作成されてマイグレーションが再実行されるため､
+// デプロイされたコミットのJobは残す
+func (m *Manager) pruneHookJobs(
+	ctx context.Context,
+	rel *tacokumogithubiov1alpha1.Release,
+) error {
+	jobs := &batchv1.JobList{}
+	if err := m.k8sClient.List(ctx, jobs,
+		client.InNamespace(rel.Namespace),
+		client.MatchingLabels{"application": rel.Name},
+		client.HasLabels{hookLabelKey},
+	); err != nil {
+		return err
+	}
+	for i := range jobs.Items {
+		job := &jobs.Items[i]
+		if job.Annotations[hookCommitAnnotationKey] == rel.Status.DeployedCommit {
+			continue
+		}
+		if err := m.k8sClient.Delete(ctx, job,
+			client.PropagationPolicy(metav1.DeletePropagationBackground),
+		); client.IgnoreNotFound(err) != nil {
+			return fmt.Errorf("failed to delete job %s: %w", job.Name, err)
+		}
+	}
+	return nil
+}
+
+// hasFailedPreDeployHook は現在のspecのpre-deployフックのJobが失敗したことを返す
+// Jobの名前はコミット､イメージ､コマンドのハッシュから決まるため､
+// specが更新されない限り同じJobが再び失敗として参照される
+func hasFailedPreDeployHook(rel *tacokumogithubiov1alpha1.Release) bool {
+	hook := meta.FindStatusCondition(rel.Status.Conditions, tacokumogithubiov1alpha1.ConditionTypePreDeployHook)
+	return hook != nil &&
+		hook.Reason == tacokumogithubiov1alpha1.ReasonHookFailed &&
+		hook.ObservedGeneration == rel.Generation
+}
+
+func findJobCondition(job *batchv1.Job, conditionType batchv1.JobConditionType) *batchv1.JobCondition {
+	for i := range job.Status.Conditions {
+		c := &job.Status.Conditions[i]
+		if c.Type == conditionType && c.Status == corev1.ConditionTrue {
+			return c
+		}
+	}
+	return nil
+}
+
+func setPreDeployHookCondition(
+	rel *tacokumogithubiov1alpha1.Release,
+	status metav1.ConditionStatus,
+	reason string,
+	message string,
+) {
+	meta.SetStatusCondition(&rel.Status.Conditions, metav1.Condition{
+		Type:               tacokumogithubiov1alpha1.ConditionTypePreDeployHook,
+		Status:             status,
+		ObservedGeneration: rel.Generation,
+		Reason:             reason,
+		Message:            message,
+	})
+}
@@ -0,0 +1,230 @@
+package release
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	tacokumogithubiov1alpha1 "github.com/tacokumo/portal-controller-kubernetes/api/v1alpha1"
+	"github.com/tacokumo/portal-controller-kubernetes/pkg/repoconnector"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appconfig "github.com/tacokumo/appconfig"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+var testHook = appconfig.ReleaseConfig{
+	Name:   "migrate",
+	Action: appconfig.ReleaseActionConfig{Command: []string{"npm", "run", "migrate"}},
+}
+
+func newHookTestRelease() *tacokumogithubiov1alpha1.Release {
+	return &tacokumogithubiov1alpha1.Release{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-app-production", Namespace: "default", UID: "rel-uid"},
+		Spec: tacokumogithubiov1alpha1.ReleaseSpec{
+			AppConfigPath: "appconfig.yaml",
+			Commit:        stringPtr("abc123"),
+		},
+		Status: tacokumogithubiov1alpha1.ReleaseStatus{
+			State: tacokumogithubiov1alpha1.ReleaseStateDeploying,
+			Image: &tacokumogithubiov1alpha1.ReleaseImageStatus{
+				Reference: testImage,
+				Digest:    testImageDigest,
+				Commit:    "abc123",
+			},
+		},
+	}
+}
+
+func TestManager_reconcileOnDeployingState_PreDeployHook(t *testing.T) {
+	hookJobKey := func(rel *tacokumogithubiov1alpha1.Release) client.ObjectKey {
+		return client.ObjectKey{
+			Namespace: rel.Namespace,
+			Name:      hookJobName(rel, testHook, testImage+"@"+testImageDigest),
+		}
+	}
+
+	tests := []struct {
+		name            string
+		jobConditions   []batchv1.JobCondition
+		pods            []corev1.Pod
+		expectErrMsg    string
+		expectState     string
+		expectCondition metav1.ConditionStatus
+		expectMessage   string
+		expectDeployed  bool
+	}{
+		{
+			name:            "creates job and waits for it",
+			expectState:     tacokumogithubiov1alpha1.ReleaseStateDeploying,
+			expectCondition: metav1.ConditionUnknown,
+			expectMessage:   `waiting for pre-deploy hook "migrate"`,
+		},
+		{
+			name: "deploys after job succeeded",
+			jobConditions: []batchv1.JobCondition{
+				{Type: batchv1.JobComplete, Status: corev1.ConditionTrue},
+			},
+			expectState:     tacokumogithubiov1alpha1.ReleaseStateRollingOut,
+			expectCondition: metav1.ConditionTrue,
+			expectMessage:   "all pre-deploy hooks succeeded",
+			expectDeployed:  true,
+		},
+		{
+			name: "fails with termination message of failed pod",
+			jobConditions: []batchv1.JobCondition{
+				{
+					Type:    batchv1.JobFailed,
+					Status:  corev1.ConditionTrue,
+					Reason:  "BackoffLimitExceeded",
+					Message: "Job has reached the specified backoff limit",
+				},
+			},
+			pods: []corev1.Pod{{
+				Status: corev1.PodStatus{
+					ContainerStatuses: []corev1.ContainerStatus{{
+						Name: "hook",
+						State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{
+							ExitCode: 1,
+							Message:  "relation \"users\" already exists\n",
+						}},
+					}},
+				},
+			}},
+			expectErrMsg:    `pre-deploy hook "migrate" failed`,
+			expectState:     tacokumogithubiov1alpha1.ReleaseStateDeploying,
+			expectCondition: metav1.ConditionFalse,
+			expectMessage: "BackoffLimitExceeded: Job has reached the specified backoff limit: " +
+				`exit code 1: relation "users" already exists`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scheme := newProcessTestScheme(t)
+			rel := newHookTestRelease()
+
+			objects := []client.Object{rel}
+			if tt.jobConditions != nil {
+				key := hookJobKey(rel)
+				objects = append(objects, &batchv1.Job{
+					ObjectMeta: metav1.ObjectMeta{Namespace: key.Namespace, Name: key.Name},
+					Status:     batchv1.JobStatus{Conditions: tt.jobConditions},
+				})
+				for i := range tt.pods {
+					pod := tt.pods[i]
+					pod.Namespace = key.Namespace
+					pod.Name = key.Name + "-pod"
+					pod.Labels = map[string]string{batchv1.JobNameLabel: key.Name}
+					objects = append(objects, &pod)
+				}
+			}
+			k8sClient := fake.NewClientBuilder().
+				WithScheme(scheme).
+				WithObjects(objects...).
+				WithStatusSubresource(rel).
+				Build()
+			connector := repoconnector.NewLocalConnector(repoTestdataPath("release-hooks"))
+			m := newTestManager(t, k8sClient, connector, testdataPath(""))
+
+			err := m.reconcileOnDeployingState(context.Background(), rel)
+			if tt.expectErrMsg != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.expectErrMsg)
+			} else {
+				require.NoError(t, err)
+			}
+			assert.Equal(t, tt.expectState, rel.Status.State)
+
+			cond := meta.FindStatusCondition(rel.Status.Conditions, tacokumogithubiov1alpha1.ConditionTypePreDeployHook)
+			require.NotNil(t, cond)
+			assert.Equal(t, tt.expectCondition, cond.Status)
+			assert.Contains(t, cond.Message, tt.expectMessage)
+
+			job := &batchv1.Job{}
+			require.NoError(t, k8sClient.Get(context.Background(), hookJobKey(rel), job))
+			if tt.jobConditions == nil {
+				container := job.Spec.Template.Spec.Containers[0]
+				assert.Equal(t, testImage+"@"+testImageDigest, container.Image)
+				assert.Equal(t, testHook.Action.Command, container.Command)
+				assert.Equal(t, corev1.RestartPolicyNever, job.Spec.Template.Spec.RestartPolicy)
+				assert.Equal(t, "abc123", job.Annotations[hookCommitAnnotationKey])
+				require.Len(t, job.OwnerReferences, 1)
+				assert.Equal(t, rel.Name, job.OwnerReferences[0].Name)
+			}
+
+			key := client.ObjectKey{Namespace: rel.Namespace, Name: rel.Name}
+			err = k8sClient.Get(context.Background(), key, &corev1.ConfigMap{})
+			if tt.expectDeployed {
+				assert.NoError(t, err)
+			} else {
+				assert.True(t, apierrors.IsNotFound(err), "workload must not be applied before the hook succeeds")
+			}
+		})
+	}
+}
+
+func TestManager_pruneHookJobs(t *testing.T) {
+	rel := newHookTestRelease()
+	rel.Status.DeployedCommit = "abc123"
+
+	hookJob := func(name, application, commit string) *batchv1.Job {
+		return &batchv1.Job{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace:   rel.Namespace,
+				Name:        name,
+				Labels:      map[string]string{"application": application, hookLabelKey: testHook.Name},
+				Annotations: map[string]string{hookCommitAnnotationKey: commit},
+			},
+		}
+	}
+	unrelated := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: rel.Namespace,
+			Name:      "backup",
+			Labels:    map[string]string{"application": rel.Name},
+		},
+	}
+
+	k8sClient := fake.NewClientBuilder().
+		WithScheme(newProcessTestScheme(t)).
+		WithObjects(
+			hookJob("deployed", rel.Name, "abc123"),
+			hookJob("previous", rel.Name, "000000"),
+			hookJob("other-release", "other-app", "000000"),
+			unrelated,
+		).
+		Build()
+	m := newTestManager(t, k8sClient, nil, testdataPath(""))
+
+	require.NoError(t, m.pruneHookJobs(context.Background(), rel))
+
+	jobs := &batchv1.JobList{}
+	require.NoError(t, k8sClient.List(context.Background(), jobs))
+	var names []string
+	for _, job := range jobs.Items {
+		names = append(names, job.Name)
+	}
+	assert.ElementsMatch(t, []string{"deployed", "other-release", "backup"}, names)
+}
+
+func TestHookJobName(t *testing.T) {
+	rel := newHookTestRelease()
+	name := hookJobName(rel, testHook, testImage)
+	assert.Equal(t, name, hookJobName(rel, testHook, testImage), "name must be deterministic")
+	assert.True(t, strings.HasPrefix(name, "test-app-production-migrate-"))
+
+	rel.Spec.Commit = stringPtr("def456")
+	assert.NotEqual(t, name, hookJobName(rel, testHook, testImage), "name must change with commit")
+
+	rel.Name = strings.Repeat("a", 80)
+	long := hookJobName(rel, testHook, testImage)
+	assert.LessOrEqual(t, len(long), maxJobNameLength)
+}
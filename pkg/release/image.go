@@ -0,0 +1,85 @@
+package release
+
+import (
+	"context"
+	"fmt"
+
+	tacokumogithubiov1alpha1 "github.com/tacokumo/portal-controller-kubernetes/api/v1alpha1"
+	"github.com/tacokumo/portal-controller-kubernetes/pkg/imageresolver"
+
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// resolveImageDigest は image をダイジェストに解決し､結果を rel.Status.Image に記録する
+// 同じコミットで既に解決済みの場合はレジストリに問い合わせない
+func (m *Manager) resolveImageDigest(
+	ctx context.Context,
+	rel *tacokumogithubiov1alpha1.Release,
+	image string,
+) error {
+	if image == "" {
+		return nil
+	}
+	commit := ""
+	if rel.Spec.Commit != nil {
+		commit = *rel.Spec.Commit
+	}
+	if resolved := rel.Status.Image; resolved != nil &&
+		resolved.Reference == image && resolved.Commit == commit {
+		return nil
+	}
+
+	creds, err := m.loadRegistryCredentials(ctx, rel)
+	if err != nil {
+		return err
+	}
+	digest, err := m.imageResolver.Resolve(ctx, image, creds)
+	if err != nil {
+		return fmt.Errorf("failed to resolve image digest: %w", err)
+	}
+
+	m.logger.Info("resolved image digest", "image", image, "digest", digest)
+	rel.Status.Image = &tacokumogithubiov1alpha1.ReleaseImageStatus{
+		Reference: image,
+		Digest:    digest,
+		Commit:    commit,
+	}
+	return nil
+}
+
+// loadRegistryCredentials は spec.imagePullSecrets からレジストリの認証情報を読み込む
+// 同じレジストリの認証情報が複数ある場合は先に指定されたものを優先する
+func (m *Manager) loadRegistryCredentials(
+	ctx context.Context,
+	rel *tacokumogithubiov1alpha1.Release,
+) (imageresolver.Credentials, error) {
+	creds := imageresolver.Credentials{}
+	for _, ref := range rel.Spec.ImagePullSecrets {
+		secret := &corev1.Secret{}
+		if err := m.k8sClient.Get(ctx, client.ObjectKey{
+			Namespace: rel.Namespace,
+			Name:      ref.Name,
+		}, secret); err != nil {
+			return nil, fmt.Errorf("failed to get image pull secret %s: %w", ref.Name, err)
+		}
+		if secret.Type != corev1.SecretTypeDockerConfigJson {
+			return nil, fmt.Errorf("image pull secret %s: unsupported type %s", ref.Name, secret.Type)
+		}
+		secretCreds, err := imageresolver.ParseDockerConfigJSON(secret.Data[corev1.DockerConfigJsonKey])
+		if err != nil {
+			return nil, fmt.Errorf("image pull secret %s: %w", ref.Name, err)
+		}
+		creds.Merge(secretCreds)
+	}
+	return creds, nil
+}
+
+// pinnedImage はダイジェストで固定したイメージの参照を返す
+// ダイジェストが解決されていない場合は image をそのまま返す
+func pinnedImage(rel *tacokumogithubiov1alpha1.Release, image string) string {
+	if resolved := rel.Status.Image; resolved != nil && resolved.Reference == image {
+		return imageresolver.PinImage(image, resolved.Digest)
+	}
+	return image
+}
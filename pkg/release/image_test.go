@@ -0,0 +1,180 @@
+package release
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"testing"
+
+	tacokumogithubiov1alpha1 "github.com/tacokumo/portal-controller-kubernetes/api/v1alpha1"
+	"github.com/tacokumo/portal-controller-kubernetes/pkg/imageresolver"
+	"github.com/tacokumo/portal-controller-kubernetes/pkg/repoconnector"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// recordingResolver は Resolve に渡された認証情報を記録するテスト用の Resolver
+type recordingResolver struct {
+	digest string
+	calls  int
+	creds  imageresolver.Credentials
+}
+
+func (r *recordingResolver) Resolve(_ context.Context, _ string, creds imageresolver.Credentials) (string, error) {
+	r.calls++
+	r.creds = creds
+	return r.digest, nil
+}
+
+func newDockerConfigSecret(namespace, name, registry string) *corev1.Secret {
+	auth := base64.StdEncoding.EncodeToString([]byte("robot:s3cr3t"))
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name},
+		Type:       corev1.SecretTypeDockerConfigJson,
+		Data: map[string][]byte{
+			corev1.DockerConfigJsonKey: fmt.Appendf(nil, `{"auths":{%q:{"auth":%q}}}`, registry, auth),
+		},
+	}
+}
+
+func TestManager_resolveImageDigest(t *testing.T) {
+	tests := []struct {
+		name         string
+		status       *tacokumogithubiov1alpha1.ReleaseImageStatus
+		pullSecrets  []corev1.LocalObjectReference
+		objects      []client.Object
+		expectCalls  int
+		expectDigest string
+		expectCreds  imageresolver.Credentials
+		expectErrMsg string
+		expectImage  string
+	}{
+		{
+			name:         "resolves and pins digest",
+			expectCalls:  1,
+			expectDigest: "sha256:new",
+			expectCreds:  imageresolver.Credentials{},
+			expectImage:  testImage + "@sha256:new",
+		},
+		{
+			name: "reuses digest resolved for the same commit",
+			status: &tacokumogithubiov1alpha1.ReleaseImageStatus{
+				Reference: testImage,
+				Digest:    "sha256:old",
+				Commit:    "abc123",
+			},
+			expectCalls:  0,
+			expectDigest: "sha256:old",
+			expectImage:  testImage + "@sha256:old",
+		},
+		{
+			name: "re-resolves when commit changes",
+			status: &tacokumogithubiov1alpha1.ReleaseImageStatus{
+				Reference: testImage,
+				Digest:    "sha256:old",
+				Commit:    "previous",
+			},
+			expectCalls:  1,
+			expectDigest: "sha256:new",
+			expectCreds:  imageresolver.Credentials{},
+			expectImage:  testImage + "@sha256:new",
+		},
+		{
+			name:        "uses credentials from image pull secrets",
+			pullSecrets: []corev1.LocalObjectReference{{Name: "regcred"}},
+			objects: []client.Object{
+				newDockerConfigSecret("default", "regcred", "myregistry.example.com"),
+			},
+			expectCalls:  1,
+			expectDigest: "sha256:new",
+			expectCreds: imageresolver.Credentials{
+				"myregistry.example.com": {Username: "robot", Password: "s3cr3t"},
+			},
+			expectImage: testImage + "@sha256:new",
+		},
+		{
+			name:         "fails when image pull secret does not exist",
+			pullSecrets:  []corev1.LocalObjectReference{{Name: "missing"}},
+			expectErrMsg: "failed to get image pull secret missing",
+		},
+		{
+			name:        "fails when image pull secret has unsupported type",
+			pullSecrets: []corev1.LocalObjectReference{{Name: "opaque"}},
+			objects: []client.Object{
+				&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "opaque"}},
+			},
+			expectErrMsg: "unsupported type",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scheme := newTestScheme(t)
+			require.NoError(t, corev1.AddToScheme(scheme))
+			k8sClient := fake.NewClientBuilder().WithScheme(scheme).WithObjects(tt.objects...).Build()
+
+			resolver := &recordingResolver{digest: "sha256:new"}
+			m := newTestManager(t, k8sClient, nil, "/tmp/test").WithImageResolver(resolver)
+
+			rel := &tacokumogithubiov1alpha1.Release{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-release", Namespace: "default"},
+				Spec: tacokumogithubiov1alpha1.ReleaseSpec{
+					Commit:           stringPtr("abc123"),
+					ImagePullSecrets: tt.pullSecrets,
+				},
+				Status: tacokumogithubiov1alpha1.ReleaseStatus{Image: tt.status},
+			}
+
+			err := m.resolveImageDigest(context.Background(), rel, testImage)
+			if tt.expectErrMsg != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.expectErrMsg)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectCalls, resolver.calls)
+			assert.Equal(t, tt.expectCreds, resolver.creds)
+			require.NotNil(t, rel.Status.Image)
+			assert.Equal(t, tt.expectDigest, rel.Status.Image.Digest)
+			assert.Equal(t, "abc123", rel.Status.Image.Commit)
+			assert.Equal(t, tt.expectImage, pinnedImage(rel, testImage))
+		})
+	}
+}
+
+func TestManager_reconcileOnDeployingState_PinsImageDigest(t *testing.T) {
+	scheme := newTestScheme(t)
+	require.NoError(t, corev1.AddToScheme(scheme))
+
+	rel := &tacokumogithubiov1alpha1.Release{
+		ObjectMeta: metav1.ObjectMeta{Name: "pinned", Namespace: "default"},
+		Spec: tacokumogithubiov1alpha1.ReleaseSpec{
+			AppConfigPath: "appconfig.yaml",
+			Commit:        stringPtr("abc123"),
+		},
+		Status: tacokumogithubiov1alpha1.ReleaseStatus{
+			State: tacokumogithubiov1alpha1.ReleaseStateDeploying,
+		},
+	}
+	k8sClient := fake.NewClientBuilder().
+		WithScheme(scheme).
+		WithObjects(rel).
+		WithStatusSubresource(rel).
+		Build()
+	connector := repoconnector.NewLocalConnector(repoTestdataPath("release-test-data"))
+	m := newTestManager(t, k8sClient, connector, testdataPath(""))
+
+	require.NoError(t, m.reconcileOnDeployingState(context.Background(), rel))
+
+	require.NotNil(t, rel.Status.Image)
+	assert.Equal(t, testImageDigest, rel.Status.Image.Digest)
+
+	cm := &corev1.ConfigMap{}
+	require.NoError(t, k8sClient.Get(context.Background(), client.ObjectKey{Namespace: "default", Name: "pinned"}, cm))
+	assert.Equal(t, testImage+"@"+testImageDigest, cm.Data["image"])
+}
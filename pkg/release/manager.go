@@ -2,25 +2,39 @@ package release
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"path/filepath"
 
 	"github.com/samber/lo"
 	tacokumogithubiov1alpha1 "github.com/tacokumo/portal-controller-kubernetes/api/v1alpha1"
+	"github.com/tacokumo/portal-controller-kubernetes/pkg/analysis"
+	"github.com/tacokumo/portal-controller-kubernetes/pkg/appconfigext"
 	"github.com/tacokumo/portal-controller-kubernetes/pkg/helmutil"
+	"github.com/tacokumo/portal-controller-kubernetes/pkg/imageresolver"
 	"github.com/tacokumo/portal-controller-kubernetes/pkg/repoconnector"
 
 	"github.com/go-logr/logr"
-	appconfig "github.com/tacokumo/appconfig"
 	applicationchart "github.com/tacokumo/helm-charts/charts/tacokumo-application"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
+// errInvalidAppConfig はコミットのappconfigが不正であることを示す
+// specが更新されるまで再試行しても成功しない
+var errInvalidAppConfig = errors.New("invalid appconfig")
+
 type Manager struct {
-	logger    logr.Logger
-	k8sClient client.Client
-	connector repoconnector.GitRepositoryConnector
-	workdir   string
+	logger        logr.Logger
+	k8sClient     client.Client
+	connector     repoconnector.GitRepositoryConnector
+	workdir       string
+	imageResolver imageresolver.Resolver
+	// analysisProvider はカナリアリリースの分析に使用される
+	analysisProvider analysis.Provider
 }
 
 func NewManager(
@@ -29,10 +43,12 @@ func NewManager(
 	workdir string,
 ) *Manager {
 	return &Manager{
-		logger:    logger,
-		k8sClient: k8sClient,
-		workdir:   workdir,
-		connector: repoconnector.NewDefaultConnector(),
+		logger:           logger,
+		k8sClient:        k8sClient,
+		workdir:          workdir,
+		connector:        repoconnector.NewDefaultConnector(),
+		imageResolver:    imageresolver.NewRegistryResolver(),
+		analysisProvider: analysis.NewPrometheusProvider(),
 	}
 }
 
@@ -43,15 +59,92 @@ func (m *Manager) WithConnector(connector repoconnector.GitRepositoryConnector)
 	return m
 }
 
+// WithImageResolver は Manager に imageresolver.Resolver を設定する
+// テスト用に公開されている
+func (m *Manager) WithImageResolver(resolver imageresolver.Resolver) *Manager {
+	m.imageResolver = resolver
+	return m
+}
+
+// WithAnalysisProvider は Manager に analysis.Provider を設定する
+// テスト用に公開されている
+func (m *Manager) WithAnalysisProvider(provider analysis.Provider) *Manager {
+	m.analysisProvider = provider
+	return m
+}
+
 func (m *Manager) Reconcile(
 	ctx context.Context,
 	rel *tacokumogithubiov1alpha1.Release,
 ) error {
+	// dry-runの間はリソースを変更せず､状態も遷移させない
+	if tacokumogithubiov1alpha1.IsDryRun(rel.Annotations, rel.Spec.DryRun) {
+		err := m.reconcileDryRun(ctx, rel)
+		if updateErr := m.k8sClient.Status().Update(ctx, rel); updateErr != nil {
+			return updateErr
+		}
+		return err
+	}
+	rel.Status.DryRun = nil
+	meta.RemoveStatusCondition(&rel.Status.Conditions, tacokumogithubiov1alpha1.ConditionTypeDryRun)
+
+	// 一時停止中はワークロードを変更せず､状態も遷移させない
+	if tacokumogithubiov1alpha1.IsSuspended(rel.Annotations, rel.Spec.Suspend) {
+		err := m.reconcileSuspended(ctx, rel)
+		if updateErr := m.k8sClient.Status().Update(ctx, rel); updateErr != nil {
+			return updateErr
+		}
+		return err
+	}
+	meta.RemoveStatusCondition(&rel.Status.Conditions, tacokumogithubiov1alpha1.ConditionTypeSuspended)
+
 	switch rel.Status.State {
 	case tacokumogithubiov1alpha1.ReleaseStateDeploying:
 		if err := m.reconcileOnDeployingState(ctx, rel); err != nil {
 			return m.handleError(ctx, rel, err)
 		}
+	case tacokumogithubiov1alpha1.ReleaseStateRollingOut:
+		if err := m.reconcileOnRollingOutState(ctx, rel); err != nil {
+			return m.handleError(ctx, rel, err)
+		}
+	case tacokumogithubiov1alpha1.ReleaseStateCanary:
+		if err := m.reconcileOnCanaryState(ctx, rel); err != nil {
+			return m.handleError(ctx, rel, err)
+		}
+	case tacokumogithubiov1alpha1.ReleaseStatePreviewing:
+		if err := m.reconcileOnPreviewingState(ctx, rel); err != nil {
+			return m.handleError(ctx, rel, err)
+		}
+	case tacokumogithubiov1alpha1.ReleaseStateRolledBack:
+		// 失敗したコミットは､specが更新されるまで再デプロイしない
+		if rel.Generation != rel.Status.ObservedGeneration {
+			rel.Status.State = tacokumogithubiov1alpha1.ReleaseStateDeploying
+		}
+	case tacokumogithubiov1alpha1.ReleaseStateFailed:
+		// appconfigが不正なコミットや､pre-deployフックが失敗したコミット､
+		// ロールアウトが期限内に進まなかったコミットは再試行しても成功しないため､specが更新されるまで再デプロイしない
+		if !hasInvalidAppConfig(rel) && !hasFailedPreDeployHook(rel) && !hasExceededProgressDeadline(rel) {
+			rel.Status.State = tacokumogithubiov1alpha1.ReleaseStateDeploying
+		}
+	case tacokumogithubiov1alpha1.ReleaseStateDeployed:
+		// specが更新された場合のみ再デプロイする
+		if rel.Generation != rel.Status.ObservedGeneration {
+			rel.Status.State = tacokumogithubiov1alpha1.ReleaseStateDeploying
+			break
+		}
+		// 接続情報が変わった場合は､新しい値を読み込ませるため再デプロイする
+		if m.serviceBindingsChanged(ctx, rel) {
+			rel.Status.State = tacokumogithubiov1alpha1.ReleaseStateDeploying
+			break
+		}
+		// 稼働中のReleaseの監視の失敗は一時的なものであり､Failedに遷移すると再デプロイされるため､
+		// 状態を変えずにエラーを返して再試行させる
+		if err := m.scaleDownPreviousColor(ctx, rel); err != nil {
+			return err
+		}
+		if _, err := m.updatePodHealth(ctx, rel); err != nil {
+			return err
+		}
 	default:
 		rel.Status.State = tacokumogithubiov1alpha1.ReleaseStateDeploying
 	}
@@ -62,52 +155,221 @@ func (m *Manager) Reconcile(
 	return nil
 }
 
+// reconcileSuspended は一時停止中のReleaseのPodの状態を監視し､Suspended Conditionを記録する
+func (m *Manager) reconcileSuspended(
+	ctx context.Context,
+	rel *tacokumogithubiov1alpha1.Release,
+) error {
+	reason, message := tacokumogithubiov1alpha1.ReasonSuspended, "release is suspended by spec.suspend"
+	if !rel.Spec.Suspend {
+		reason, message = tacokumogithubiov1alpha1.ReasonApplicationSuspended, "release is suspended by its application"
+	}
+	tacokumogithubiov1alpha1.SetSuspendedCondition(&rel.Status.Conditions, rel.Generation, true, reason, message)
+
+	_, err := m.updatePodHealth(ctx, rel)
+	return err
+}
+
 func (m *Manager) reconcileOnDeployingState(
 	ctx context.Context,
 	rel *tacokumogithubiov1alpha1.Release,
 ) error {
-	if rel.Spec.Commit == nil {
-		return fmt.Errorf("spec.commit is required")
+	if rel.Spec.RollbackTo != nil {
+		return m.rollbackToRevision(ctx, rel, *rel.Spec.RollbackTo)
 	}
-	referenceName := *rel.Spec.Commit
-	appCfg, err := repoconnector.CloneApplicationRepository(
-		ctx,
-		m.connector,
-		rel.Spec.Repo.URL,
-		referenceName,
-		rel.Spec.AppConfigPath)
+
+	// DeployWindowが閉じている間はDeploying のまま保留し､開いてから適用する
+	// 障害対応を妨げないよう､ロールバックは対象外とする
+	blocked, err := m.checkDeployWindow(ctx, rel)
 	if err != nil {
 		return err
 	}
+	if blocked {
+		return nil
+	}
 
-	values, err := m.constructReleaseValues(rel, &appCfg)
+	appCfg, err := m.loadAppConfig(ctx, rel)
 	if err != nil {
 		return err
 	}
 
-	chartPath := filepath.Join(m.workdir, "helm-charts", "charts", "tacokumo-application")
+	// 可変なタグで同じコミットのReleaseの中身が変わらないよう､ダイジェストで固定する
+	if err := m.resolveImageDigest(ctx, rel, appCfg.Build.Image); err != nil {
+		return err
+	}
+	if err := m.resolveServiceBindings(ctx, rel); err != nil {
+		return err
+	}
 
-	manifests, err := helmutil.RenderChart(chartPath, rel.Name, rel.Namespace, values)
+	// フックやワークロードが参照するため､環境変数のSecretを先に作成する
+	if err := m.reconcileEncryptedSecrets(ctx, rel, &appCfg); err != nil {
+		return err
+	}
+
+	values, err := m.constructReleaseValues(ctx, rel, &appCfg)
 	if err != nil {
 		return err
 	}
 
-	objects, err := helmutil.ParseManifestsToUnstructured(manifests)
+	// マイグレーションなどが完了するまで新しいワークロードは適用しない
+	done, err := m.runPreDeployHooks(ctx, rel, &appCfg)
+	if err != nil {
+		return err
+	}
+	if !done {
+		return nil
+	}
+
+	objects, err := m.renderChartObjects(rel, values, &appCfg)
 	if err != nil {
 		return err
 	}
 
-	for _, obj := range objects {
+	// 以前のコミットが稼働している場合は､カナリアで分析してから切り替える
+	if shouldStartCanary(rel) {
+		return m.startCanary(ctx, rel, objects)
+	}
+	// ブルーグリーンデプロイでは､検証前のコミットをプレビューとして並行して起動する
+	if shouldStartPreview(rel) {
+		return m.startPreview(ctx, rel, objects)
+	}
+
+	processes, err := m.constructProcessObjects(ctx, rel, &appCfg)
+	if err != nil {
+		return err
+	}
+	for _, obj := range append(objects, processes...) {
 		obj.SetNamespace(rel.Namespace)
+	}
+
+	// ロールバックできるよう､colorを反映する前のオブジェクトをスナップショットとして記録する
+	snapshot, err := newRevisionSnapshot(rel, objects, processes, values)
+	if err != nil {
+		return err
+	}
+	if err := m.applyReleaseObjects(ctx, rel, objects, processes); err != nil {
+		return err
+	}
+	return m.createRevision(ctx, rel, snapshot)
+}
+
+// rollbackToRevision はリビジョンに記録されたマニフェストを､gitを参照せずにそのまま再適用する
+// pre-deployフック､カナリアリリース､プレビューは実行しない
+func (m *Manager) rollbackToRevision(
+	ctx context.Context,
+	rel *tacokumogithubiov1alpha1.Release,
+	revision int64,
+) error {
+	rev, err := m.getDeployedRevision(ctx, rel, revision)
+	if err != nil {
+		return err
+	}
+	objects, processes, err := revisionObjects(rev)
+	if err != nil {
+		return err
+	}
+	if err := m.applyReleaseObjects(ctx, rel, objects, processes); err != nil {
+		return err
+	}
+	rel.Status.Image = rev.Spec.Image.DeepCopy()
+	rel.Status.EncryptedEnvSecretName = rev.Spec.EncryptedEnvSecretName
+	rel.Status.Revision = revision
+	return nil
+}
+
+// applyReleaseObjects はチャートとプロセスのオブジェクトを適用し､ロールアウトの監視に遷移する
+// ブルーグリーンデプロイの場合はチャートのオブジェクトにcolorを反映してから適用する
+func (m *Manager) applyReleaseObjects(
+	ctx context.Context,
+	rel *tacokumogithubiov1alpha1.Release,
+	objects []*unstructured.Unstructured,
+	processes []*unstructured.Unstructured,
+) error {
+	activeColor := ""
+	if isBlueGreen(rel) {
+		activeColor = nextActiveColor(rel)
+		if err := applyBlueGreenColor(objects, rel, activeColor); err != nil {
+			return err
+		}
+		if err := m.carryOverLiveReplicas(ctx, rel, objects, activeColor); err != nil {
+			return err
+		}
+	}
+
+	for _, obj := range append(objects, processes...) {
 		if err := helmutil.CreateOrUpdateObject(ctx, m.k8sClient, obj); err != nil {
 			return err
 		}
 	}
 
-	rel.Status.State = tacokumogithubiov1alpha1.ReleaseStateDeployed
+	processRefs := processReferencesOf(processes)
+	if err := m.pruneProcesses(ctx, rel, processRefs); err != nil {
+		return err
+	}
+	rel.Status.Processes = processRefs
+	if activeColor != "" {
+		recordActiveColor(rel, activeColor)
+	}
+
+	rel.Status.ObservedGeneration = rel.Generation
+	setProgressingCondition(rel, metav1.ConditionTrue, tacokumogithubiov1alpha1.ReasonRolloutInProgress,
+		"waiting for workloads to be rolled out")
+	rel.Status.State = tacokumogithubiov1alpha1.ReleaseStateRollingOut
 	return nil
 }
 
+// loadAppConfig はspec.commitのappconfigを読み込み､Stageごとの上書き設定を反映して返す
+func (m *Manager) loadAppConfig(
+	ctx context.Context,
+	rel *tacokumogithubiov1alpha1.Release,
+) (appconfigext.AppConfig, error) {
+	if rel.Spec.Commit == nil {
+		return appconfigext.AppConfig{}, fmt.Errorf("spec.commit is required")
+	}
+	appCfg, err := repoconnector.CloneApplicationRepository(
+		ctx,
+		m.connector,
+		rel.Spec.Repo.URL,
+		*rel.Spec.Commit,
+		rel.Spec.AppConfigPath)
+	if err != nil {
+		return appconfigext.AppConfig{}, err
+	}
+	return appCfg.ForStage(rel.Spec.Stage), nil
+}
+
+// renderChartObjects はtacokumo-applicationチャートをレンダリングし､
+// チャートが表現できない設定を反映したオブジェクトを返す
+func (m *Manager) renderChartObjects(
+	rel *tacokumogithubiov1alpha1.Release,
+	values map[string]any,
+	appCfg *appconfigext.AppConfig,
+) ([]*unstructured.Unstructured, error) {
+	chartPath := filepath.Join(m.workdir, "helm-charts", "charts", "tacokumo-application")
+
+	manifests, err := helmutil.RenderChart(chartPath, rel.Name, rel.Namespace, values)
+	if err != nil {
+		return nil, err
+	}
+
+	objects, err := helmutil.ParseManifestsToUnstructured(manifests)
+	if err != nil {
+		return nil, err
+	}
+
+	// チャートが表現できない設定はレンダリング後のオブジェクトに反映する
+	if err := applyServiceAppProtocols(objects, rel.Name, appCfg.ServicePorts()); err != nil {
+		return nil, err
+	}
+	if err := applyContainerEnv(objects, rel.Name, appCfg.Ext.Service.Env); err != nil {
+		return nil, err
+	}
+	if err := applyServiceBindings(objects, rel.Name, rel); err != nil {
+		return nil, err
+	}
+	return objects, nil
+}
+
 func (m *Manager) handleError(
 	ctx context.Context,
 	rel *tacokumogithubiov1alpha1.Release,
@@ -116,11 +378,16 @@ func (m *Manager) handleError(
 	// 引数のerrorは必ずnilではない
 	rel.Status.State = tacokumogithubiov1alpha1.ReleaseStateFailed
 
+	reason := tacokumogithubiov1alpha1.ReasonReconcileError
+	if errors.Is(err, errInvalidAppConfig) {
+		reason = tacokumogithubiov1alpha1.ReasonInvalidAppConfig
+	}
+
 	// Ready Conditionを設定し、エラーメッセージを記録
 	tacokumogithubiov1alpha1.SetReadyConditionFalse(
 		&rel.Status.Conditions,
 		rel.Generation,
-		tacokumogithubiov1alpha1.ReasonReconcileError,
+		reason,
 		err.Error(),
 	)
 
@@ -131,30 +398,61 @@ func (m *Manager) handleError(
 	return err
 }
 
+// hasInvalidAppConfig は現在のspecのappconfigが不正でデプロイが失敗したことを返す
+func hasInvalidAppConfig(rel *tacokumogithubiov1alpha1.Release) bool {
+	ready := meta.FindStatusCondition(rel.Status.Conditions, tacokumogithubiov1alpha1.ConditionTypeReady)
+	return ready != nil &&
+		ready.Reason == tacokumogithubiov1alpha1.ReasonInvalidAppConfig &&
+		ready.ObservedGeneration == rel.Generation
+}
+
+// constructReleaseValues はtacokumo-applicationチャートに渡すvaluesを構築する
+// 決定したリソース設定から求まるQoSクラスは rel.Status に記録される
 func (m *Manager) constructReleaseValues(
+	ctx context.Context,
 	rel *tacokumogithubiov1alpha1.Release,
-	appCfg *appconfig.AppConfig,
+	appCfg *appconfigext.AppConfig,
 ) (map[string]interface{}, error) {
+	if err := appCfg.Validate(); err != nil {
+		return nil, fmt.Errorf("%w: %w", errInvalidAppConfig, err)
+	}
+
+	policy, err := m.loadResourcePolicy(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	hpa := constructHPAValues(appCfg)
 	svc := constructServiceValues(appCfg)
-	resource := constructResourceValues(appCfg)
+	resource, err := constructResourceValues(appCfg, policy)
+	if err != nil {
+		return nil, err
+	}
+	rel.Status.QOSClass = qosClassOf(resource)
 
-	// TODO: probe, env, annotation
+	// TODO: probe, annotation
 
 	values := applicationchart.Values{
 		Main: applicationchart.MainConfig{
 			ApplicationName: rel.Name,
-			Image:           appCfg.Build.Image,
-			Service:         svc,
-			HPA:             hpa,
-			Resources:       resource,
+			Image:           pinnedImage(rel, appCfg.Build.Image),
+			ImagePullSecrets: lo.Map(
+				rel.Spec.ImagePullSecrets,
+				func(ref corev1.LocalObjectReference, _ int) applicationchart.ImagePullSecret {
+					return applicationchart.ImagePullSecret{Name: ref.Name}
+				},
+			),
+			Service:   svc,
+			HPA:       hpa,
+			Resources: resource,
+			EnvFrom:   constructEnvFromValues(rel, appCfg),
 		},
 	}
 	return helmutil.StructToValueMap(values)
 }
 
 func constructHPAValues(
-	appCfg *appconfig.AppConfig,
+	appCfg *appconfigext.AppConfig,
 ) applicationchart.HPAConfig {
 	// TODO: メトリクスの指定はまだできない
 	if appCfg.Service.Scale == nil {
@@ -172,48 +470,30 @@ func constructHPAValues(
 }
 
 func constructServiceValues(
-	appCfg *appconfig.AppConfig,
+	appCfg *appconfigext.AppConfig,
 ) applicationchart.ServiceConfig {
-	// TODO: HTTP以外もサポートする
-	if len(appCfg.Service.HTTP) == 0 {
+	ports := appCfg.ServicePorts()
+	if len(ports) == 0 {
 		return applicationchart.ServiceConfig{
 			Enabled: false,
 		}
 	}
 
-	ports := lo.Map(appCfg.Service.HTTP, func(c appconfig.ServiceHTTPConfig, _ int) applicationchart.ServicePortConfig {
-		return applicationchart.ServicePortConfig{
-			Name:       fmt.Sprintf("http-%d", c.TargetPort),
-			Port:       c.TargetPort, // NOTE: 本当にtagretPortで良いのか？
-			TargetPort: c.TargetPort,
-			Protocol:   "TCP",
-		}
-	})
+	serviceType := appCfg.Ext.Service.Type
+	if serviceType == "" {
+		serviceType = appconfigext.ServiceTypeClusterIP
+	}
 
 	return applicationchart.ServiceConfig{
 		Enabled: true,
-		Type:    "ClusterIP",
-		Ports:   ports,
-	}
-}
-
-func constructResourceValues(
-	appCfg *appconfig.AppConfig,
-) applicationchart.ResourceConfig {
-	// TODO: flavor
-	if appCfg.Service.MachineConfig == nil {
-		return applicationchart.ResourceConfig{
-			Limits: applicationchart.ResourceSpec{
-				CPU:    "100m",
-				Memory: "128Mi",
-			},
-		}
-	}
-	return applicationchart.ResourceConfig{
-		// TODO: リソース指定をサポートする
-		Limits: applicationchart.ResourceSpec{
-			CPU:    appCfg.Service.MachineConfig.CPU,
-			Memory: appCfg.Service.MachineConfig.Memory,
-		},
+		Type:    serviceType,
+		Ports: lo.Map(ports, func(p appconfigext.ServicePortConfig, _ int) applicationchart.ServicePortConfig {
+			return applicationchart.ServicePortConfig{
+				Name:       p.NormalizedName(),
+				Port:       p.Port,
+				TargetPort: p.NormalizedTargetPort(),
+				Protocol:   p.NormalizedProtocol(),
+			}
+		}),
 	}
 }
@@ -8,16 +8,24 @@ import (
 	"testing"
 
 	tacokumogithubiov1alpha1 "github.com/tacokumo/portal-controller-kubernetes/api/v1alpha1"
+	"github.com/tacokumo/portal-controller-kubernetes/pkg/appconfigext"
+	"github.com/tacokumo/portal-controller-kubernetes/pkg/imageresolver"
 	"github.com/tacokumo/portal-controller-kubernetes/pkg/repoconnector"
 
 	"github.com/go-logr/logr"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	appconfig "github.com/tacokumo/appconfig"
+	applicationchart "github.com/tacokumo/helm-charts/charts/tacokumo-application"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	k8sruntime "k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/utils/ptr"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
 )
 
 // Helper functions
@@ -37,9 +45,17 @@ func newTestScheme(t *testing.T) *k8sruntime.Scheme {
 	scheme := k8sruntime.NewScheme()
 	err := tacokumogithubiov1alpha1.AddToScheme(scheme)
 	require.NoError(t, err)
+	err = corev1.AddToScheme(scheme)
+	require.NoError(t, err)
 	return scheme
 }
 
+const (
+	// testImage は repoconnector のテストデータのappconfigに記述されたイメージ
+	testImage       = "myregistry.example.com/test-app:v1.0.0"
+	testImageDigest = "sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+)
+
 func newTestManager(
 	t *testing.T,
 	k8sClient client.Client,
@@ -47,7 +63,10 @@ func newTestManager(
 	workdir string,
 ) *Manager {
 	t.Helper()
-	m := NewManager(logr.Discard(), k8sClient, workdir)
+	m := NewManager(logr.Discard(), k8sClient, workdir).
+		WithImageResolver(imageresolver.NewStaticResolver(map[string]string{
+			testImage: testImageDigest,
+		}))
 	if connector != nil {
 		m.WithConnector(connector)
 	}
@@ -58,11 +77,12 @@ func newTestManager(
 
 func TestManager_constructReleaseValues(t *testing.T) {
 	tests := []struct {
-		name         string
-		releaseName  string
-		image        string
-		expectError  bool
-		validateFunc func(*testing.T, map[string]interface{})
+		name          string
+		releaseName   string
+		image         string
+		envSecretName *string
+		expectError   bool
+		validateFunc  func(*testing.T, map[string]interface{})
 	}{
 		{
 			name:        "populates values correctly with image",
@@ -107,6 +127,31 @@ func TestManager_constructReleaseValues(t *testing.T) {
 				assert.Equal(t, 1, hpa["maxReplicas"])
 			},
 		},
+		{
+			name:          "loads env secret of the stage via envFrom",
+			releaseName:   "env-test",
+			image:         "test-image:latest",
+			envSecretName: ptr.To("test-app-staging-env"),
+			expectError:   false,
+			validateFunc: func(t *testing.T, values map[string]interface{}) {
+				main := values["main"].(map[string]interface{})
+				assert.Equal(t, []interface{}{
+					map[string]interface{}{
+						"secretRef": map[string]interface{}{"name": "test-app-staging-env"},
+					},
+				}, main["envFrom"])
+			},
+		},
+		{
+			name:        "omits envFrom without env secret",
+			releaseName: "no-env-test",
+			image:       "test-image:latest",
+			expectError: false,
+			validateFunc: func(t *testing.T, values map[string]interface{}) {
+				main := values["main"].(map[string]interface{})
+				assert.NotContains(t, main, "envFrom")
+			},
+		},
 	}
 
 	for _, tt := range tests {
@@ -120,15 +165,20 @@ func TestManager_constructReleaseValues(t *testing.T) {
 					Name:      tt.releaseName,
 					Namespace: "default",
 				},
+				Spec: tacokumogithubiov1alpha1.ReleaseSpec{
+					EnvSecretName: tt.envSecretName,
+				},
 			}
 
-			appCfg := &appconfig.AppConfig{
-				Build: appconfig.BuildConfig{
-					Image: tt.image,
+			appCfg := &appconfigext.AppConfig{
+				AppConfig: appconfig.AppConfig{
+					Build: appconfig.BuildConfig{
+						Image: tt.image,
+					},
 				},
 			}
 
-			values, err := m.constructReleaseValues(rel, appCfg)
+			values, err := m.constructReleaseValues(context.Background(), rel, appCfg)
 
 			if tt.expectError {
 				assert.Error(t, err)
@@ -140,6 +190,97 @@ func TestManager_constructReleaseValues(t *testing.T) {
 	}
 }
 
+// Tests for constructServiceValues
+
+func TestConstructServiceValues(t *testing.T) {
+	tests := []struct {
+		name     string
+		appCfg   appconfigext.AppConfig
+		expected applicationchart.ServiceConfig
+	}{
+		{
+			name:   "disables service when no ports are configured",
+			appCfg: appconfigext.AppConfig{},
+			expected: applicationchart.ServiceConfig{
+				Enabled: false,
+			},
+		},
+		{
+			name: "keeps target_port as service port for http config",
+			appCfg: appconfigext.AppConfig{
+				AppConfig: appconfig.AppConfig{
+					Service: appconfig.ServiceConfig{
+						HTTP: []appconfig.ServiceHTTPConfig{{TargetPort: 8080}},
+					},
+				},
+			},
+			expected: applicationchart.ServiceConfig{
+				Enabled: true,
+				Type:    "ClusterIP",
+				Ports: []applicationchart.ServicePortConfig{
+					{Name: "http-8080", Port: 8080, TargetPort: 8080, Protocol: "TCP"},
+				},
+			},
+		},
+		{
+			name: "supports grpc, udp and separate port/targetPort",
+			appCfg: appconfigext.AppConfig{
+				Ext: appconfigext.Extension{
+					Service: appconfigext.ServiceExtension{
+						Type: "LoadBalancer",
+						Ports: []appconfigext.ServicePortConfig{
+							{Port: 443, TargetPort: 50051, AppProtocol: "grpc"},
+							{Name: "dns", Port: 53, Protocol: "udp"},
+							{Port: 9000},
+						},
+					},
+				},
+			},
+			expected: applicationchart.ServiceConfig{
+				Enabled: true,
+				Type:    "LoadBalancer",
+				Ports: []applicationchart.ServicePortConfig{
+					{Name: "grpc-443", Port: 443, TargetPort: 50051, Protocol: "TCP"},
+					{Name: "dns", Port: 53, TargetPort: 53, Protocol: "UDP"},
+					{Name: "tcp-9000", Port: 9000, TargetPort: 9000, Protocol: "TCP"},
+				},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, constructServiceValues(&tt.appCfg))
+		})
+	}
+}
+
+func TestManager_constructReleaseValues_RejectsInvalidPorts(t *testing.T) {
+	scheme := newTestScheme(t)
+	k8sClient := fake.NewClientBuilder().WithScheme(scheme).Build()
+	m := newTestManager(t, k8sClient, nil, "/tmp/test")
+
+	rel := &tacokumogithubiov1alpha1.Release{
+		ObjectMeta: metav1.ObjectMeta{Name: "dup", Namespace: "default"},
+	}
+	appCfg := &appconfigext.AppConfig{
+		AppConfig: appconfig.AppConfig{
+			Service: appconfig.ServiceConfig{
+				HTTP: []appconfig.ServiceHTTPConfig{{TargetPort: 8080}},
+			},
+		},
+		Ext: appconfigext.Extension{
+			Service: appconfigext.ServiceExtension{
+				Ports: []appconfigext.ServicePortConfig{{Name: "admin", Port: 8080}},
+			},
+		},
+	}
+
+	_, err := m.constructReleaseValues(context.Background(), rel, appCfg)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "duplicate port 8080/TCP")
+}
+
 // Tests for handleError
 
 func TestManager_handleError(t *testing.T) {
@@ -265,6 +406,239 @@ func TestManager_Reconcile_OnDefaultState(t *testing.T) {
 	}
 }
 
+func TestManager_Reconcile_OnFailedState(t *testing.T) {
+	tests := []struct {
+		name               string
+		reason             string
+		observedGeneration int64
+		expectedState      string
+	}{
+		{
+			name:               "retries after a reconcile error",
+			reason:             tacokumogithubiov1alpha1.ReasonReconcileError,
+			observedGeneration: 2,
+			expectedState:      tacokumogithubiov1alpha1.ReleaseStateDeploying,
+		},
+		{
+			name:               "stays Failed while the appconfig of the spec is invalid",
+			reason:             tacokumogithubiov1alpha1.ReasonInvalidAppConfig,
+			observedGeneration: 2,
+			expectedState:      tacokumogithubiov1alpha1.ReleaseStateFailed,
+		},
+		{
+			name:               "retries invalid appconfig after the spec is updated",
+			reason:             tacokumogithubiov1alpha1.ReasonInvalidAppConfig,
+			observedGeneration: 1,
+			expectedState:      tacokumogithubiov1alpha1.ReleaseStateDeploying,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rel := &tacokumogithubiov1alpha1.Release{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-release", Namespace: "default", Generation: 2},
+				Status: tacokumogithubiov1alpha1.ReleaseStatus{
+					State: tacokumogithubiov1alpha1.ReleaseStateFailed,
+					Conditions: []metav1.Condition{{
+						Type:               tacokumogithubiov1alpha1.ConditionTypeReady,
+						Status:             metav1.ConditionFalse,
+						ObservedGeneration: tt.observedGeneration,
+						Reason:             tt.reason,
+						LastTransitionTime: metav1.Now(),
+					}},
+				},
+			}
+			k8sClient := fake.NewClientBuilder().
+				WithScheme(newTestScheme(t)).
+				WithObjects(rel).
+				WithStatusSubresource(rel).
+				Build()
+			m := newTestManager(t, k8sClient, nil, "/tmp/test")
+
+			require.NoError(t, m.Reconcile(context.Background(), rel))
+			assert.Equal(t, tt.expectedState, rel.Status.State)
+		})
+	}
+}
+
+func TestManager_Reconcile_OnFailedState_HookFailed(t *testing.T) {
+	tests := []struct {
+		name               string
+		observedGeneration int64
+		expectedState      string
+	}{
+		{
+			name:               "stays Failed while the pre-deploy hook of the spec has failed",
+			observedGeneration: 2,
+			expectedState:      tacokumogithubiov1alpha1.ReleaseStateFailed,
+		},
+		{
+			name:               "retries the pre-deploy hook after the spec is updated",
+			observedGeneration: 1,
+			expectedState:      tacokumogithubiov1alpha1.ReleaseStateDeploying,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rel := &tacokumogithubiov1alpha1.Release{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-release", Namespace: "default", Generation: 2},
+				Status: tacokumogithubiov1alpha1.ReleaseStatus{
+					State: tacokumogithubiov1alpha1.ReleaseStateFailed,
+					Conditions: []metav1.Condition{
+						{
+							Type:               tacokumogithubiov1alpha1.ConditionTypeReady,
+							Status:             metav1.ConditionFalse,
+							ObservedGeneration: tt.observedGeneration,
+							Reason:             tacokumogithubiov1alpha1.ReasonReconcileError,
+							LastTransitionTime: metav1.Now(),
+						},
+						{
+							Type:               tacokumogithubiov1alpha1.ConditionTypePreDeployHook,
+							Status:             metav1.ConditionFalse,
+							ObservedGeneration: tt.observedGeneration,
+							Reason:             tacokumogithubiov1alpha1.ReasonHookFailed,
+							LastTransitionTime: metav1.Now(),
+						},
+					},
+				},
+			}
+			k8sClient := fake.NewClientBuilder().
+				WithScheme(newTestScheme(t)).
+				WithObjects(rel).
+				WithStatusSubresource(rel).
+				Build()
+			m := newTestManager(t, k8sClient, nil, "/tmp/test")
+
+			require.NoError(t, m.Reconcile(context.Background(), rel))
+			assert.Equal(t, tt.expectedState, rel.Status.State)
+		})
+	}
+}
+
+func TestManager_Reconcile_DeployedKeepsStateOnPodListError(t *testing.T) {
+	rel := &tacokumogithubiov1alpha1.Release{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-release", Namespace: "default", Generation: 1},
+		Status: tacokumogithubiov1alpha1.ReleaseStatus{
+			State:              tacokumogithubiov1alpha1.ReleaseStateDeployed,
+			ObservedGeneration: 1,
+		},
+	}
+	listErr := apierrors.NewServiceUnavailable("temporarily unavailable")
+	k8sClient := fake.NewClientBuilder().
+		WithScheme(newTestScheme(t)).
+		WithObjects(rel).
+		WithStatusSubresource(rel).
+		WithInterceptorFuncs(interceptor.Funcs{
+			List: func(ctx context.Context, c client.WithWatch, list client.ObjectList, opts ...client.ListOption) error {
+				if _, ok := list.(*corev1.PodList); ok {
+					return listErr
+				}
+				return c.List(ctx, list, opts...)
+			},
+		}).
+		Build()
+	m := newTestManager(t, k8sClient, nil, "/tmp/test")
+
+	// 一時的な失敗ではFailedに遷移せず､再デプロイしない
+	require.ErrorIs(t, m.Reconcile(context.Background(), rel), listErr)
+	stored := &tacokumogithubiov1alpha1.Release{}
+	require.NoError(t, k8sClient.Get(context.Background(), client.ObjectKeyFromObject(rel), stored))
+	assert.Equal(t, tacokumogithubiov1alpha1.ReleaseStateDeployed, stored.Status.State)
+}
+
+func TestManager_Reconcile_OnFailedState_ProgressDeadlineExceeded(t *testing.T) {
+	tests := []struct {
+		name               string
+		conditionType      string
+		observedGeneration int64
+		expectedState      string
+	}{
+		{
+			name:               "stays Failed while the rollout of the spec exceeded its deadline",
+			conditionType:      tacokumogithubiov1alpha1.ConditionTypeProgressing,
+			observedGeneration: 2,
+			expectedState:      tacokumogithubiov1alpha1.ReleaseStateFailed,
+		},
+		{
+			name:               "stays Failed while the preview of the spec exceeded its deadline",
+			conditionType:      tacokumogithubiov1alpha1.ConditionTypePreview,
+			observedGeneration: 2,
+			expectedState:      tacokumogithubiov1alpha1.ReleaseStateFailed,
+		},
+		{
+			name:               "retries the rollout after the spec is updated",
+			conditionType:      tacokumogithubiov1alpha1.ConditionTypeProgressing,
+			observedGeneration: 1,
+			expectedState:      tacokumogithubiov1alpha1.ReleaseStateDeploying,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rel := &tacokumogithubiov1alpha1.Release{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-release", Namespace: "default", Generation: 2},
+				Status: tacokumogithubiov1alpha1.ReleaseStatus{
+					State: tacokumogithubiov1alpha1.ReleaseStateFailed,
+					Conditions: []metav1.Condition{
+						{
+							Type:               tacokumogithubiov1alpha1.ConditionTypeReady,
+							Status:             metav1.ConditionFalse,
+							ObservedGeneration: tt.observedGeneration,
+							Reason:             tacokumogithubiov1alpha1.ReasonReconcileError,
+							LastTransitionTime: metav1.Now(),
+						},
+						{
+							Type:               tt.conditionType,
+							Status:             metav1.ConditionFalse,
+							ObservedGeneration: tt.observedGeneration,
+							Reason:             tacokumogithubiov1alpha1.ReasonProgressDeadlineExceeded,
+							LastTransitionTime: metav1.Now(),
+						},
+					},
+				},
+			}
+			k8sClient := fake.NewClientBuilder().
+				WithScheme(newTestScheme(t)).
+				WithObjects(rel).
+				WithStatusSubresource(rel).
+				Build()
+			m := newTestManager(t, k8sClient, nil, "/tmp/test")
+
+			require.NoError(t, m.Reconcile(context.Background(), rel))
+			assert.Equal(t, tt.expectedState, rel.Status.State)
+		})
+	}
+}
+
+func TestManager_handleError_InvalidAppConfig(t *testing.T) {
+	rel := &tacokumogithubiov1alpha1.Release{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-release", Namespace: "default", Generation: 1},
+	}
+	k8sClient := fake.NewClientBuilder().
+		WithScheme(newTestScheme(t)).
+		WithObjects(rel).
+		WithStatusSubresource(rel).
+		Build()
+	m := newTestManager(t, k8sClient, nil, "/tmp/test")
+
+	appCfg := &appconfigext.AppConfig{
+		AppConfig: appconfig.AppConfig{
+			Releases: []appconfig.ReleaseConfig{{Name: "db_migrate"}},
+		},
+	}
+	_, err := m.constructReleaseValues(context.Background(), rel, appCfg)
+	require.Error(t, err)
+
+	_ = m.handleError(context.Background(), rel, err)
+
+	ready := meta.FindStatusCondition(rel.Status.Conditions, tacokumogithubiov1alpha1.ConditionTypeReady)
+	require.NotNil(t, ready)
+	assert.Equal(t, tacokumogithubiov1alpha1.ReasonInvalidAppConfig, ready.Reason)
+	assert.Contains(t, ready.Message, `release "db_migrate": invalid name`)
+	assert.True(t, hasInvalidAppConfig(rel))
+}
+
 // Tests for reconcileOnDeployingState
 
 func TestManager_reconcileOnDeployingState(t *testing.T) {
@@ -374,3 +748,83 @@ func TestManager_reconcileOnDeployingState(t *testing.T) {
 func stringPtr(s string) *string {
 	return &s
 }
+
+func TestManager_Reconcile_Suspended(t *testing.T) {
+	tests := []struct {
+		name           string
+		specSuspend    bool
+		annotations    map[string]string
+		state          string
+		expectedReason string
+		resumedState   string
+	}{
+		{
+			name:           "does not deploy while suspended by spec",
+			specSuspend:    true,
+			state:          tacokumogithubiov1alpha1.ReleaseStateDeploying,
+			expectedReason: tacokumogithubiov1alpha1.ReasonSuspended,
+		},
+		{
+			name:           "does not redeploy while suspended by application",
+			annotations:    map[string]string{tacokumogithubiov1alpha1.ApplicationSuspendedAnnotationKey: "true"},
+			state:          tacokumogithubiov1alpha1.ReleaseStateDeployed,
+			expectedReason: tacokumogithubiov1alpha1.ReasonApplicationSuspended,
+			resumedState:   tacokumogithubiov1alpha1.ReleaseStateDeploying,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rel := &tacokumogithubiov1alpha1.Release{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "app", Namespace: "default", Generation: 3, Annotations: tt.annotations,
+				},
+				Spec: tacokumogithubiov1alpha1.ReleaseSpec{Suspend: tt.specSuspend},
+				Status: tacokumogithubiov1alpha1.ReleaseStatus{
+					State:              tt.state,
+					ObservedGeneration: 2,
+				},
+			}
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "app-abc", Namespace: "default", Labels: map[string]string{"application": "app"},
+				},
+				Status: corev1.PodStatus{
+					Phase:      corev1.PodRunning,
+					Conditions: []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionTrue}},
+				},
+			}
+			k8sClient := fake.NewClientBuilder().
+				WithScheme(newProcessTestScheme(t)).
+				WithObjects(rel, pod).
+				WithStatusSubresource(rel).
+				Build()
+			// 一時停止中はリポジトリにアクセスしないため､connectorは不要
+			m := newTestManager(t, k8sClient, nil, "/tmp/test")
+
+			require.NoError(t, m.Reconcile(context.Background(), rel))
+			assert.Equal(t, tt.state, rel.Status.State)
+
+			condition := meta.FindStatusCondition(
+				rel.Status.Conditions, tacokumogithubiov1alpha1.ConditionTypeSuspended)
+			require.NotNil(t, condition)
+			assert.Equal(t, metav1.ConditionTrue, condition.Status)
+			assert.Equal(t, tt.expectedReason, condition.Reason)
+			// 一時停止中もPodの状態は監視される
+			require.Len(t, rel.Status.Pods, 1)
+			assert.True(t,
+				meta.IsStatusConditionTrue(rel.Status.Conditions, tacokumogithubiov1alpha1.ConditionTypePodsReady))
+
+			if tt.resumedState == "" {
+				return
+			}
+			// 再開するとSuspended Conditionは削除され､保留されていた更新がデプロイされる
+			rel.Spec.Suspend = false
+			rel.Annotations = nil
+			require.NoError(t, m.Reconcile(context.Background(), rel))
+			assert.Nil(t,
+				meta.FindStatusCondition(rel.Status.Conditions, tacokumogithubiov1alpha1.ConditionTypeSuspended))
+			assert.Equal(t, tt.resumedState, rel.Status.State)
+		})
+	}
+}
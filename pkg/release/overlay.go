@@ -0,0 +1,134 @@
+package release
+
+import (
+	"sort"
+
+	"github.com/samber/lo"
+	"github.com/tacokumo/portal-controller-kubernetes/pkg/appconfigext"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// applyServiceAppProtocols はレンダリングされたServiceの各ポートにappProtocolを設定する
+// tacokumo-applicationチャートがappProtocolを扱えないため､レンダリング後に反映する
+func applyServiceAppProtocols(
+	objects []*unstructured.Unstructured,
+	serviceName string,
+	ports []appconfigext.ServicePortConfig,
+) error {
+	appProtocols := map[string]string{}
+	for _, p := range ports {
+		if p.AppProtocol != "" {
+			appProtocols[p.NormalizedName()] = p.AppProtocol
+		}
+	}
+	if len(appProtocols) == 0 {
+		return nil
+	}
+
+	svc := findObject(objects, "Service", serviceName)
+	if svc == nil {
+		return nil
+	}
+
+	svcPorts, found, err := unstructured.NestedSlice(svc.Object, "spec", "ports")
+	if err != nil || !found {
+		return err
+	}
+	for i, raw := range svcPorts {
+		port, ok := raw.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		name, _ := port["name"].(string)
+		if appProtocol, ok := appProtocols[name]; ok {
+			port["appProtocol"] = appProtocol
+			svcPorts[i] = port
+		}
+	}
+	return unstructured.SetNestedSlice(svc.Object, svcPorts, "spec", "ports")
+}
+
+// applyContainerEnv はレンダリングされたDeploymentのコンテナに環境変数を設定する
+// tacokumo-applicationチャートが env を扱えないため､レンダリング後に反映する
+// 差分が出ないよう､環境変数は名前順に並べる
+func applyContainerEnv(
+	objects []*unstructured.Unstructured,
+	deploymentName string,
+	env map[string]string,
+) error {
+	if len(env) == 0 {
+		return nil
+	}
+
+	deploy := findObject(objects, "Deployment", deploymentName)
+	if deploy == nil {
+		return nil
+	}
+
+	containers, found, err := unstructured.NestedSlice(deploy.Object, "spec", "template", "spec", "containers")
+	if err != nil || !found {
+		return err
+	}
+	names := lo.Keys(env)
+	sort.Strings(names)
+	for i, raw := range containers {
+		container, ok := raw.(map[string]interface{})
+		if !ok || container["name"] != deploymentName {
+			continue
+		}
+		vars := make([]interface{}, 0, len(names))
+		for _, name := range names {
+			vars = append(vars, map[string]interface{}{
+				"name":  name,
+				"value": env[name],
+			})
+		}
+		container["env"] = vars
+		containers[i] = container
+	}
+	return unstructured.SetNestedSlice(deploy.Object, containers, "spec", "template", "spec", "containers")
+}
+
+// findObject は指定されたKindとNameを持つオブジェクトを探す
+func findObject(
+	objects []*unstructured.Unstructured,
+	kind string,
+	name string,
+) *unstructured.Unstructured {
+	for _, obj := range objects {
+		if obj.GetKind() == kind && obj.GetName() == name {
+			return obj
+		}
+	}
+	return nil
+}
+
+// addDeploymentLabel はDeploymentとそのセレクタ､Podテンプレートにラベルを追加する
+// 同じ `application` ラベルを持つDeploymentのPodを区別するために使用する
+func addDeploymentLabel(deploy *unstructured.Unstructured, key string, value string) error {
+	labels := deploy.GetLabels()
+	if labels == nil {
+		labels = map[string]string{}
+	}
+	labels[key] = value
+	deploy.SetLabels(labels)
+
+	for _, path := range [][]string{
+		{"spec", "selector", "matchLabels"},
+		{"spec", "template", "metadata", "labels"},
+	} {
+		selected, _, err := unstructured.NestedStringMap(deploy.Object, path...)
+		if err != nil {
+			return err
+		}
+		if selected == nil {
+			selected = map[string]string{}
+		}
+		selected[key] = value
+		if err := unstructured.SetNestedStringMap(deploy.Object, selected, path...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+package release
+
+import (
+	"testing"
+
+	"github.com/tacokumo/portal-controller-kubernetes/pkg/appconfigext"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func newTestService(name string, portNames ...string) *unstructured.Unstructured {
+	ports := make([]interface{}, 0, len(portNames))
+	for _, n := range portNames {
+		ports = append(ports, map[string]interface{}{"name": n})
+	}
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "Service",
+		"metadata":   map[string]interface{}{"name": name},
+		"spec":       map[string]interface{}{"ports": ports},
+	}}
+}
+
+func TestApplyServiceAppProtocols(t *testing.T) {
+	svc := newTestService("test-app", "grpc-443", "udp-53")
+	other := newTestService("other", "grpc-443")
+
+	err := applyServiceAppProtocols(
+		[]*unstructured.Unstructured{other, svc},
+		"test-app",
+		[]appconfigext.ServicePortConfig{
+			{Port: 443, AppProtocol: "grpc"},
+			{Port: 53, Protocol: "UDP"},
+		},
+	)
+	require.NoError(t, err)
+
+	ports, _, err := unstructured.NestedSlice(svc.Object, "spec", "ports")
+	require.NoError(t, err)
+	assert.Equal(t, "grpc", ports[0].(map[string]interface{})["appProtocol"])
+	assert.NotContains(t, ports[1].(map[string]interface{}), "appProtocol")
+
+	otherPorts, _, err := unstructured.NestedSlice(other.Object, "spec", "ports")
+	require.NoError(t, err)
+	assert.NotContains(t, otherPorts[0].(map[string]interface{}), "appProtocol")
+}
+
+func newTestDeployment(name string, containerNames ...string) *unstructured.Unstructured {
+	containers := make([]interface{}, 0, len(containerNames))
+	for _, n := range containerNames {
+		containers = append(containers, map[string]interface{}{"name": n})
+	}
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "apps/v1",
+		"kind":       "Deployment",
+		"metadata":   map[string]interface{}{"name": name},
+		"spec": map[string]interface{}{
+			"template": map[string]interface{}{
+				"spec": map[string]interface{}{"containers": containers},
+			},
+		},
+	}}
+}
+
+func TestApplyContainerEnv(t *testing.T) {
+	deploy := newTestDeployment("test-app", "test-app", "sidecar")
+
+	err := applyContainerEnv(
+		[]*unstructured.Unstructured{deploy},
+		"test-app",
+		map[string]string{"LOG_LEVEL": "debug", "APP_ENV": "staging"},
+	)
+	require.NoError(t, err)
+
+	containers, _, err := unstructured.NestedSlice(deploy.Object, "spec", "template", "spec", "containers")
+	require.NoError(t, err)
+	assert.Equal(t, []interface{}{
+		map[string]interface{}{"name": "APP_ENV", "value": "staging"},
+		map[string]interface{}{"name": "LOG_LEVEL", "value": "debug"},
+	}, containers[0].(map[string]interface{})["env"])
+	assert.NotContains(t, containers[1].(map[string]interface{}), "env")
+}
@@ -0,0 +1,221 @@
+package release
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	tacokumogithubiov1alpha1 "github.com/tacokumo/portal-controller-kubernetes/api/v1alpha1"
+	"github.com/tacokumo/portal-controller-kubernetes/pkg/appconfigext"
+
+	"github.com/samber/lo"
+	applicationchart "github.com/tacokumo/helm-charts/charts/tacokumo-application"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+const (
+	// processLabelKey はプロセスのワークロードに付与されるラベルのキー
+	processLabelKey = "process"
+)
+
+// constructProcessObjects は `processes` に宣言されたプロセスのワークロードを構築する
+// worker は Deployment､cron は CronJob としてレンダリングされ､Releaseが所有者となる
+func (m *Manager) constructProcessObjects(
+	ctx context.Context,
+	rel *tacokumogithubiov1alpha1.Release,
+	appCfg *appconfigext.AppConfig,
+) ([]*unstructured.Unstructured, error) {
+	if len(appCfg.Ext.Processes) == 0 {
+		return nil, nil
+	}
+
+	policy, err := m.loadResourcePolicy(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	objects := make([]*unstructured.Unstructured, 0, len(appCfg.Ext.Processes))
+	for _, p := range appCfg.Ext.Processes {
+		procCfg := appCfg.ForProcess(p)
+		res, err := constructResourceValues(&procCfg, policy)
+		if err != nil {
+			return nil, fmt.Errorf("process %q: %w", p.Name, err)
+		}
+		requirements, err := resourceRequirementsOf(res)
+		if err != nil {
+			return nil, fmt.Errorf("process %q: %w", p.Name, err)
+		}
+
+		name := processWorkloadName(rel, p)
+		labels := map[string]string{
+			"application":   name,
+			processLabelKey: p.Name,
+		}
+		podSpec := corev1.PodSpec{
+			ImagePullSecrets: rel.Spec.ImagePullSecrets,
+			Containers: []corev1.Container{{
+				Name:      p.Name,
+				Image:     pinnedImage(rel, appCfg.Build.Image),
+				Command:   p.Command,
+				Env:       containerEnvOf(rel, appCfg.Ext.Service.Env),
+				EnvFrom:   envFromOf(rel, appCfg),
+				Resources: requirements,
+			}},
+		}
+		podMeta := metav1.ObjectMeta{Labels: labels, Annotations: podTemplateAnnotationsOf(rel)}
+
+		var obj runtime.Object
+		var gvk schema.GroupVersionKind
+		switch p.Type {
+		case appconfigext.ProcessTypeWorker:
+			gvk = appsv1.SchemeGroupVersion.WithKind("Deployment")
+			obj = &appsv1.Deployment{
+				ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: rel.Namespace, Labels: labels},
+				Spec: appsv1.DeploymentSpec{
+					Replicas: ptr.To(int32(p.NormalizedReplicas())),
+					Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"application": name}},
+					Template: corev1.PodTemplateSpec{
+						ObjectMeta: podMeta,
+						Spec:       podSpec,
+					},
+				},
+			}
+		case appconfigext.ProcessTypeCron:
+			gvk = batchv1.SchemeGroupVersion.WithKind("CronJob")
+			podSpec.RestartPolicy = corev1.RestartPolicyOnFailure
+			obj = &batchv1.CronJob{
+				ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: rel.Namespace, Labels: labels},
+				Spec: batchv1.CronJobSpec{
+					Schedule:          p.Schedule,
+					ConcurrencyPolicy: batchv1.ConcurrencyPolicy(p.NormalizedConcurrencyPolicy()),
+					JobTemplate: batchv1.JobTemplateSpec{
+						Spec: batchv1.JobSpec{
+							Template: corev1.PodTemplateSpec{
+								ObjectMeta: podMeta,
+								Spec:       podSpec,
+							},
+						},
+					},
+				},
+			}
+		default:
+			return nil, fmt.Errorf("process %q: unsupported type %q", p.Name, p.Type)
+		}
+
+		u, err := toUnstructured(obj, gvk)
+		if err != nil {
+			return nil, err
+		}
+		if err := controllerutil.SetControllerReference(rel, u, m.k8sClient.Scheme()); err != nil {
+			return nil, err
+		}
+		objects = append(objects, u)
+	}
+	return objects, nil
+}
+
+// pruneProcesses は前回のデプロイで作成され､今回のappconfigから削除されたプロセスのワークロードを削除する
+func (m *Manager) pruneProcesses(
+	ctx context.Context,
+	rel *tacokumogithubiov1alpha1.Release,
+	current []corev1.ObjectReference,
+) error {
+	for _, ref := range rel.Status.Processes {
+		if lo.ContainsBy(current, func(c corev1.ObjectReference) bool {
+			return c.Kind == ref.Kind && c.Name == ref.Name
+		}) {
+			continue
+		}
+		obj := &unstructured.Unstructured{}
+		obj.SetAPIVersion(ref.APIVersion)
+		obj.SetKind(ref.Kind)
+		obj.SetNamespace(ref.Namespace)
+		obj.SetName(ref.Name)
+		if err := m.k8sClient.Delete(ctx, obj); client.IgnoreNotFound(err) != nil {
+			return fmt.Errorf("failed to delete process %s %s: %w", ref.Kind, ref.Name, err)
+		}
+		m.logger.Info("deleted process workload", "kind", ref.Kind, "name", ref.Name)
+	}
+	return nil
+}
+
+// processReferencesOf はプロセスのワークロードをReleaseのStatusに記録する形式に変換する
+func processReferencesOf(objects []*unstructured.Unstructured) []corev1.ObjectReference {
+	return lo.Map(objects, func(obj *unstructured.Unstructured, _ int) corev1.ObjectReference {
+		return corev1.ObjectReference{
+			APIVersion: obj.GetAPIVersion(),
+			Kind:       obj.GetKind(),
+			Namespace:  obj.GetNamespace(),
+			Name:       obj.GetName(),
+		}
+	})
+}
+
+// processWorkloadName はプロセスのワークロードの名前を返す
+func processWorkloadName(rel *tacokumogithubiov1alpha1.Release, p appconfigext.ProcessConfig) string {
+	return fmt.Sprintf("%s-%s", rel.Name, p.Name)
+}
+
+// resourceRequirementsOf はチャートのリソース設定を corev1.ResourceRequirements に変換する
+func resourceRequirementsOf(res applicationchart.ResourceConfig) (corev1.ResourceRequirements, error) {
+	requests, err := resourceListOf(res.Requests)
+	if err != nil {
+		return corev1.ResourceRequirements{}, err
+	}
+	limits, err := resourceListOf(res.Limits)
+	if err != nil {
+		return corev1.ResourceRequirements{}, err
+	}
+	return corev1.ResourceRequirements{Requests: requests, Limits: limits}, nil
+}
+
+func resourceListOf(spec applicationchart.ResourceSpec) (corev1.ResourceList, error) {
+	list := corev1.ResourceList{}
+	for name, value := range map[corev1.ResourceName]string{
+		corev1.ResourceCPU:    spec.CPU,
+		corev1.ResourceMemory: spec.Memory,
+	} {
+		if value == "" {
+			continue
+		}
+		q, err := resource.ParseQuantity(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s %q: %w", name, value, err)
+		}
+		list[name] = q
+	}
+	if len(list) == 0 {
+		return nil, nil
+	}
+	return list, nil
+}
+
+// envVarsOf は環境変数を名前順の corev1.EnvVar に変換する
+func envVarsOf(env map[string]string) []corev1.EnvVar {
+	names := lo.Keys(env)
+	sort.Strings(names)
+	return lo.Map(names, func(name string, _ int) corev1.EnvVar {
+		return corev1.EnvVar{Name: name, Value: env[name]}
+	})
+}
+
+// toUnstructured は型付きのオブジェクトを unstructured.Unstructured に変換する
+func toUnstructured(obj runtime.Object, gvk schema.GroupVersionKind) (*unstructured.Unstructured, error) {
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+	if err != nil {
+		return nil, err
+	}
+	u := &unstructured.Unstructured{Object: content}
+	u.SetGroupVersionKind(gvk)
+	return u, nil
+}
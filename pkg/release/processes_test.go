@@ -0,0 +1,109 @@
+package release
+
+import (
+	"context"
+	"testing"
+
+	tacokumogithubiov1alpha1 "github.com/tacokumo/portal-controller-kubernetes/api/v1alpha1"
+	"github.com/tacokumo/portal-controller-kubernetes/pkg/repoconnector"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sruntime "k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func newProcessTestScheme(t *testing.T) *k8sruntime.Scheme {
+	t.Helper()
+	scheme := newTestScheme(t)
+	require.NoError(t, appsv1.AddToScheme(scheme))
+	require.NoError(t, batchv1.AddToScheme(scheme))
+	return scheme
+}
+
+func TestManager_reconcileOnDeployingState_Processes(t *testing.T) {
+	scheme := newProcessTestScheme(t)
+	rel := &tacokumogithubiov1alpha1.Release{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-app-production", Namespace: "default", UID: "rel-uid"},
+		Spec: tacokumogithubiov1alpha1.ReleaseSpec{
+			AppConfigPath: "appconfig.yaml",
+			Commit:        stringPtr("abc123"),
+		},
+		Status: tacokumogithubiov1alpha1.ReleaseStatus{
+			State: tacokumogithubiov1alpha1.ReleaseStateDeploying,
+		},
+	}
+	k8sClient := fake.NewClientBuilder().
+		WithScheme(scheme).
+		WithObjects(rel).
+		WithStatusSubresource(rel).
+		Build()
+	connector := repoconnector.NewLocalConnector(repoTestdataPath("release-processes"))
+	m := newTestManager(t, k8sClient, connector, testdataPath(""))
+
+	require.NoError(t, m.reconcileOnDeployingState(context.Background(), rel))
+
+	assert.Equal(t, []corev1.ObjectReference{
+		{APIVersion: "apps/v1", Kind: "Deployment", Namespace: "default", Name: "test-app-production-worker"},
+		{APIVersion: "batch/v1", Kind: "CronJob", Namespace: "default", Name: "test-app-production-cleanup"},
+	}, rel.Status.Processes)
+
+	worker := &appsv1.Deployment{}
+	require.NoError(t, k8sClient.Get(context.Background(), client.ObjectKey{
+		Namespace: "default", Name: "test-app-production-worker",
+	}, worker))
+	assert.Equal(t, int32(2), *worker.Spec.Replicas)
+	assert.Equal(t, "test-app-production-worker", worker.Spec.Selector.MatchLabels["application"])
+	require.Len(t, worker.OwnerReferences, 1)
+	assert.Equal(t, "Release", worker.OwnerReferences[0].Kind)
+	assert.Equal(t, "test-app-production", worker.OwnerReferences[0].Name)
+	container := worker.Spec.Template.Spec.Containers[0]
+	assert.Equal(t, testImage+"@"+testImageDigest, container.Image)
+	assert.Equal(t, []string{"npm", "run", "worker"}, container.Command)
+	assert.Equal(t, []corev1.EnvVar{{Name: "QUEUE_URL", Value: "redis://redis:6379"}}, container.Env)
+
+	cron := &batchv1.CronJob{}
+	require.NoError(t, k8sClient.Get(context.Background(), client.ObjectKey{
+		Namespace: "default", Name: "test-app-production-cleanup",
+	}, cron))
+	assert.Equal(t, "0 3 * * *", cron.Spec.Schedule)
+	assert.Equal(t, batchv1.ForbidConcurrent, cron.Spec.ConcurrencyPolicy)
+	podSpec := cron.Spec.JobTemplate.Spec.Template.Spec
+	assert.Equal(t, corev1.RestartPolicyOnFailure, podSpec.RestartPolicy)
+	assert.True(t, resource.MustParse("512Mi").Equal(podSpec.Containers[0].Resources.Limits[corev1.ResourceMemory]))
+	assert.NotContains(t, podSpec.Containers[0].Resources.Limits, corev1.ResourceCPU)
+}
+
+func TestManager_pruneProcesses(t *testing.T) {
+	scheme := newProcessTestScheme(t)
+	stale := &batchv1.CronJob{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "app-old"}}
+	kept := &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "app-worker"}}
+	k8sClient := fake.NewClientBuilder().WithScheme(scheme).WithObjects(stale, kept).Build()
+	m := newTestManager(t, k8sClient, nil, "/tmp/test")
+
+	workerRef := corev1.ObjectReference{
+		APIVersion: "apps/v1", Kind: "Deployment", Namespace: "default", Name: "app-worker",
+	}
+	rel := &tacokumogithubiov1alpha1.Release{
+		Status: tacokumogithubiov1alpha1.ReleaseStatus{
+			Processes: []corev1.ObjectReference{
+				workerRef,
+				{APIVersion: "batch/v1", Kind: "CronJob", Namespace: "default", Name: "app-old"},
+				{APIVersion: "batch/v1", Kind: "CronJob", Namespace: "default", Name: "app-already-deleted"},
+			},
+		},
+	}
+
+	require.NoError(t, m.pruneProcesses(context.Background(), rel, []corev1.ObjectReference{workerRef}))
+
+	err := k8sClient.Get(context.Background(), client.ObjectKeyFromObject(stale), &batchv1.CronJob{})
+	assert.True(t, apierrors.IsNotFound(err))
+	assert.NoError(t, k8sClient.Get(context.Background(), client.ObjectKeyFromObject(kept), &appsv1.Deployment{}))
+}
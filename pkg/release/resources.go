@@ -0,0 +1,212 @@
+package release
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+
+	tacokumogithubiov1alpha1 "github.com/tacokumo/portal-controller-kubernetes/api/v1alpha1"
+	"github.com/tacokumo/portal-controller-kubernetes/pkg/appconfigext"
+
+	applicationchart "github.com/tacokumo/helm-charts/charts/tacokumo-application"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// resourcePolicy はクラスタ内のResourcePolicyを合成したもの
+type resourcePolicy struct {
+	flavors              map[string]corev1.ResourceRequirements
+	maxLimitRequestRatio corev1.ResourceList
+}
+
+// loadResourcePolicy はクラスタ内のResourcePolicyをすべて読み込み､一つのポリシーに合成する
+// 同名のFlavorは名前順で最初のResourcePolicyのものが優先され､
+// 比率の上限は最も厳しい値が使用される
+func (m *Manager) loadResourcePolicy(ctx context.Context) (resourcePolicy, error) {
+	policies := tacokumogithubiov1alpha1.ResourcePolicyList{}
+	if err := m.k8sClient.List(ctx, &policies); err != nil {
+		return resourcePolicy{}, err
+	}
+	sort.Slice(policies.Items, func(i, j int) bool {
+		return policies.Items[i].Name < policies.Items[j].Name
+	})
+
+	policy := resourcePolicy{
+		flavors:              map[string]corev1.ResourceRequirements{},
+		maxLimitRequestRatio: corev1.ResourceList{},
+	}
+	for _, p := range policies.Items {
+		for _, f := range p.Spec.Flavors {
+			if _, ok := policy.flavors[f.Name]; !ok {
+				policy.flavors[f.Name] = f.Resources
+			}
+		}
+		for name, ratio := range p.Spec.MaxLimitRequestRatio {
+			if current, ok := policy.maxLimitRequestRatio[name]; !ok || ratio.Cmp(current) < 0 {
+				policy.maxLimitRequestRatio[name] = ratio
+			}
+		}
+	}
+	return policy, nil
+}
+
+// constructResourceValues はappconfigとResourcePolicyからコンテナのrequests/limitsを決定する
+// 優先順位は以下の通り
+//   - `service.resources` で個別に指定された値
+//   - `service.machine_config.flavor` で指定されたFlavorの値
+//   - `service.machine_config` の cpu/memory (limitsとして扱う)
+func constructResourceValues(
+	appCfg *appconfigext.AppConfig,
+	policy resourcePolicy,
+) (applicationchart.ResourceConfig, error) {
+	res := applicationchart.ResourceConfig{}
+	mc := appCfg.Service.MachineConfig
+	switch {
+	case mc != nil && mc.Flavor != "":
+		flavor, ok := policy.flavors[mc.Flavor]
+		if !ok {
+			return applicationchart.ResourceConfig{},
+				fmt.Errorf("flavor %q is not defined in any ResourcePolicy", mc.Flavor)
+		}
+		res.Requests = resourceSpecFromList(flavor.Requests)
+		res.Limits = resourceSpecFromList(flavor.Limits)
+	case mc != nil:
+		res.Limits = applicationchart.ResourceSpec{
+			CPU:    mc.CPU,
+			Memory: mc.Memory,
+		}
+	case appCfg.Ext.Service.Resources == nil:
+		res.Limits = applicationchart.ResourceSpec{
+			CPU:    "100m",
+			Memory: "128Mi",
+		}
+	}
+
+	if ext := appCfg.Ext.Service.Resources; ext != nil {
+		res.Requests = overlayResourceSpec(res.Requests, ext.Requests)
+		res.Limits = overlayResourceSpec(res.Limits, ext.Limits)
+	}
+
+	if err := validateResources(res, policy); err != nil {
+		return applicationchart.ResourceConfig{}, err
+	}
+	return res, nil
+}
+
+// validateResources はrequests/limitsが正しい値であり､ポリシーの比率の上限を満たしていることを確認する
+func validateResources(
+	res applicationchart.ResourceConfig,
+	policy resourcePolicy,
+) error {
+	var errs []error
+	check := func(name corev1.ResourceName, request, limit string) {
+		req, err := parseOptionalQuantity(request)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("invalid %s request %q: %w", name, request, err))
+			return
+		}
+		lim, err := parseOptionalQuantity(limit)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("invalid %s limit %q: %w", name, limit, err))
+			return
+		}
+		// limitのみの場合はKubernetesがrequestにlimitと同じ値を設定するため比率は1になる
+		if req == nil {
+			return
+		}
+		maxRatio, ok := policy.maxLimitRequestRatio[name]
+		// requestのみの場合はlimitが設定されず上限がなくなるため､比率の上限があるなら満たせない
+		if lim == nil {
+			if ok {
+				errs = append(errs, fmt.Errorf(
+					"%s limit must be set because ResourcePolicy limits the limit/request ratio to %s",
+					name, maxRatio.String()))
+			}
+			return
+		}
+		if req.Cmp(*lim) > 0 {
+			errs = append(errs, fmt.Errorf("%s request %s must not exceed limit %s", name, request, limit))
+			return
+		}
+		if !ok || req.IsZero() {
+			return
+		}
+		ratio := lim.AsApproximateFloat64() / req.AsApproximateFloat64()
+		if ratio > maxRatio.AsApproximateFloat64() {
+			errs = append(errs, fmt.Errorf(
+				"%s limit/request ratio %.2f exceeds the maximum %s allowed by ResourcePolicy",
+				name, ratio, maxRatio.String()))
+		}
+	}
+	check(corev1.ResourceCPU, res.Requests.CPU, res.Limits.CPU)
+	check(corev1.ResourceMemory, res.Requests.Memory, res.Limits.Memory)
+	return errors.Join(errs...)
+}
+
+// qosClassOf はコンテナのrequests/limitsからPodのQoSクラスを求める
+// 判定方法はKubernetesのものに従う
+func qosClassOf(res applicationchart.ResourceConfig) corev1.PodQOSClass {
+	if res.Requests == (applicationchart.ResourceSpec{}) && res.Limits == (applicationchart.ResourceSpec{}) {
+		return corev1.PodQOSBestEffort
+	}
+	if res.Limits.CPU == "" || res.Limits.Memory == "" {
+		return corev1.PodQOSBurstable
+	}
+	// requestが省略された場合はlimitと同じ値になる
+	if !quantityEqualOrEmpty(res.Requests.CPU, res.Limits.CPU) ||
+		!quantityEqualOrEmpty(res.Requests.Memory, res.Limits.Memory) {
+		return corev1.PodQOSBurstable
+	}
+	return corev1.PodQOSGuaranteed
+}
+
+func resourceSpecFromList(list corev1.ResourceList) applicationchart.ResourceSpec {
+	spec := applicationchart.ResourceSpec{}
+	if q, ok := list[corev1.ResourceCPU]; ok {
+		spec.CPU = q.String()
+	}
+	if q, ok := list[corev1.ResourceMemory]; ok {
+		spec.Memory = q.String()
+	}
+	return spec
+}
+
+func overlayResourceSpec(
+	base applicationchart.ResourceSpec,
+	override appconfigext.ResourceValues,
+) applicationchart.ResourceSpec {
+	if override.CPU != "" {
+		base.CPU = override.CPU
+	}
+	if override.Memory != "" {
+		base.Memory = override.Memory
+	}
+	return base
+}
+
+func parseOptionalQuantity(s string) (*resource.Quantity, error) {
+	if s == "" {
+		return nil, nil
+	}
+	q, err := resource.ParseQuantity(s)
+	if err != nil {
+		return nil, err
+	}
+	return &q, nil
+}
+
+func quantityEqualOrEmpty(request, limit string) bool {
+	if request == "" {
+		return true
+	}
+	req, err := resource.ParseQuantity(request)
+	if err != nil {
+		return false
+	}
+	lim, err := resource.ParseQuantity(limit)
+	if err != nil {
+		return false
+	}
+	return req.Cmp(lim) == 0
+}
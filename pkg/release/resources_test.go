@@ -0,0 +1,198 @@
+package release
+
+import (
+	"context"
+	"testing"
+
+	tacokumogithubiov1alpha1 "github.com/tacokumo/portal-controller-kubernetes/api/v1alpha1"
+	"github.com/tacokumo/portal-controller-kubernetes/pkg/appconfigext"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appconfig "github.com/tacokumo/appconfig"
+	applicationchart "github.com/tacokumo/helm-charts/charts/tacokumo-application"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestManager_loadResourcePolicy(t *testing.T) {
+	scheme := newTestScheme(t)
+	k8sClient := fake.NewClientBuilder().
+		WithScheme(scheme).
+		WithObjects(
+			&tacokumogithubiov1alpha1.ResourcePolicy{
+				ObjectMeta: metav1.ObjectMeta{Name: "a"},
+				Spec: tacokumogithubiov1alpha1.ResourcePolicySpec{
+					Flavors: []tacokumogithubiov1alpha1.ResourceFlavor{{
+						Name: "small",
+						Resources: corev1.ResourceRequirements{
+							Limits: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("200m")},
+						},
+					}},
+					MaxLimitRequestRatio: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("4")},
+				},
+			},
+			&tacokumogithubiov1alpha1.ResourcePolicy{
+				ObjectMeta: metav1.ObjectMeta{Name: "b"},
+				Spec: tacokumogithubiov1alpha1.ResourcePolicySpec{
+					Flavors: []tacokumogithubiov1alpha1.ResourceFlavor{{
+						Name: "small",
+						Resources: corev1.ResourceRequirements{
+							Limits: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("1")},
+						},
+					}},
+					MaxLimitRequestRatio: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("2")},
+				},
+			},
+		).
+		Build()
+	m := newTestManager(t, k8sClient, nil, "/tmp/test")
+
+	policy, err := m.loadResourcePolicy(context.Background())
+	require.NoError(t, err)
+
+	cpu := policy.flavors["small"].Limits[corev1.ResourceCPU]
+	assert.Equal(t, "200m", cpu.String())
+	ratio := policy.maxLimitRequestRatio[corev1.ResourceCPU]
+	assert.Equal(t, "2", ratio.String())
+}
+
+func TestConstructResourceValues(t *testing.T) {
+	policy := resourcePolicy{
+		flavors: map[string]corev1.ResourceRequirements{
+			"small": {
+				Requests: corev1.ResourceList{
+					corev1.ResourceCPU:    resource.MustParse("50m"),
+					corev1.ResourceMemory: resource.MustParse("64Mi"),
+				},
+				Limits: corev1.ResourceList{
+					corev1.ResourceCPU:    resource.MustParse("200m"),
+					corev1.ResourceMemory: resource.MustParse("128Mi"),
+				},
+			},
+		},
+		maxLimitRequestRatio: corev1.ResourceList{
+			corev1.ResourceCPU: resource.MustParse("4"),
+		},
+	}
+
+	tests := []struct {
+		name         string
+		machine      *appconfig.MachineConfig
+		resources    *appconfigext.ResourcesConfig
+		expected     applicationchart.ResourceConfig
+		expectedQOS  corev1.PodQOSClass
+		expectErrMsg string
+	}{
+		{
+			name: "uses default limits when nothing is configured",
+			expected: applicationchart.ResourceConfig{
+				Limits: applicationchart.ResourceSpec{CPU: "100m", Memory: "128Mi"},
+			},
+			expectedQOS: corev1.PodQOSGuaranteed,
+		},
+		{
+			name:    "uses machine_config as limits",
+			machine: &appconfig.MachineConfig{CPU: "500m", Memory: "256Mi"},
+			expected: applicationchart.ResourceConfig{
+				Limits: applicationchart.ResourceSpec{CPU: "500m", Memory: "256Mi"},
+			},
+			expectedQOS: corev1.PodQOSGuaranteed,
+		},
+		{
+			name:    "uses flavor from ResourcePolicy",
+			machine: &appconfig.MachineConfig{Flavor: "small"},
+			expected: applicationchart.ResourceConfig{
+				Requests: applicationchart.ResourceSpec{CPU: "50m", Memory: "64Mi"},
+				Limits:   applicationchart.ResourceSpec{CPU: "200m", Memory: "128Mi"},
+			},
+			expectedQOS: corev1.PodQOSBurstable,
+		},
+		{
+			name:    "overrides flavor with explicit requests",
+			machine: &appconfig.MachineConfig{Flavor: "small"},
+			resources: &appconfigext.ResourcesConfig{
+				Requests: appconfigext.ResourceValues{CPU: "200m", Memory: "128Mi"},
+			},
+			expected: applicationchart.ResourceConfig{
+				Requests: applicationchart.ResourceSpec{CPU: "200m", Memory: "128Mi"},
+				Limits:   applicationchart.ResourceSpec{CPU: "200m", Memory: "128Mi"},
+			},
+			expectedQOS: corev1.PodQOSGuaranteed,
+		},
+		{
+			name: "allows requests without limits",
+			resources: &appconfigext.ResourcesConfig{
+				Requests: appconfigext.ResourceValues{Memory: "128Mi"},
+			},
+			expected: applicationchart.ResourceConfig{
+				Requests: applicationchart.ResourceSpec{Memory: "128Mi"},
+			},
+			expectedQOS: corev1.PodQOSBurstable,
+		},
+		{
+			name: "rejects requests without limits when the ratio is limited",
+			resources: &appconfigext.ResourcesConfig{
+				Requests: appconfigext.ResourceValues{CPU: "100m"},
+			},
+			expectErrMsg: "cpu limit must be set because ResourcePolicy limits the limit/request ratio to 4",
+		},
+		{
+			name:         "rejects unknown flavor",
+			machine:      &appconfig.MachineConfig{Flavor: "huge"},
+			expectErrMsg: `flavor "huge" is not defined`,
+		},
+		{
+			name: "rejects ratio exceeding the policy",
+			resources: &appconfigext.ResourcesConfig{
+				Requests: appconfigext.ResourceValues{CPU: "100m"},
+				Limits:   appconfigext.ResourceValues{CPU: "1"},
+			},
+			expectErrMsg: "cpu limit/request ratio 10.00 exceeds the maximum 4",
+		},
+		{
+			name: "rejects request greater than limit",
+			resources: &appconfigext.ResourcesConfig{
+				Requests: appconfigext.ResourceValues{Memory: "1Gi"},
+				Limits:   appconfigext.ResourceValues{Memory: "512Mi"},
+			},
+			expectErrMsg: "memory request 1Gi must not exceed limit 512Mi",
+		},
+		{
+			name: "rejects invalid quantity",
+			resources: &appconfigext.ResourcesConfig{
+				Limits: appconfigext.ResourceValues{CPU: "lots"},
+			},
+			expectErrMsg: `invalid cpu limit "lots"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			appCfg := &appconfigext.AppConfig{
+				AppConfig: appconfig.AppConfig{
+					Service: appconfig.ServiceConfig{MachineConfig: tt.machine},
+				},
+				Ext: appconfigext.Extension{
+					Service: appconfigext.ServiceExtension{Resources: tt.resources},
+				},
+			}
+
+			res, err := constructResourceValues(appCfg, policy)
+			if tt.expectErrMsg != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.expectErrMsg)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, res)
+			assert.Equal(t, tt.expectedQOS, qosClassOf(res))
+		})
+	}
+}
+
+func TestQOSClassOf_BestEffort(t *testing.T) {
+	assert.Equal(t, corev1.PodQOSBestEffort, qosClassOf(applicationchart.ResourceConfig{}))
+}
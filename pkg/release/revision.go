@@ -0,0 +1,303 @@
+package release
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+
+	tacokumogithubiov1alpha1 "github.com/tacokumo/portal-controller-kubernetes/api/v1alpha1"
+	"github.com/tacokumo/portal-controller-kubernetes/pkg/helmutil"
+
+	"github.com/samber/lo"
+	"go.yaml.in/yaml/v3"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+// defaultRevisionHistoryLimit は spec.revisionHistoryLimit が指定されない場合に保持するReleaseRevisionの数
+const defaultRevisionHistoryLimit = 10
+
+// newRevisionSnapshot は適用するオブジェクトとvaluesから､リビジョン番号が未定のReleaseRevisionを作成する
+// objects はブルーグリーンデプロイのcolorを反映する前のものでなければならない
+func newRevisionSnapshot(
+	rel *tacokumogithubiov1alpha1.Release,
+	objects []*unstructured.Unstructured,
+	processes []*unstructured.Unstructured,
+	values map[string]any,
+) (*tacokumogithubiov1alpha1.ReleaseRevision, error) {
+	manifests, err := encodeManifests(append(append([]*unstructured.Unstructured{}, objects...), processes...))
+	if err != nil {
+		return nil, err
+	}
+	valuesJSON, err := json.Marshal(values)
+	if err != nil {
+		return nil, err
+	}
+	compressedValues, err := compress(valuesJSON)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tacokumogithubiov1alpha1.ReleaseRevision{
+		Spec: tacokumogithubiov1alpha1.ReleaseRevisionSpec{
+			Release:                rel.Name,
+			Commit:                 ptr.Deref(rel.Spec.Commit, ""),
+			Image:                  rel.Status.Image.DeepCopy(),
+			EncryptedEnvSecretName: rel.Status.EncryptedEnvSecretName,
+			Processes: lo.Map(
+				processes,
+				func(obj *unstructured.Unstructured, _ int) tacokumogithubiov1alpha1.ReleaseRevisionProcess {
+					return tacokumogithubiov1alpha1.ReleaseRevisionProcess{
+						APIVersion: obj.GetAPIVersion(),
+						Kind:       obj.GetKind(),
+						Name:       obj.GetName(),
+					}
+				},
+			),
+			Manifests: manifests,
+			Values:    compressedValues,
+		},
+	}, nil
+}
+
+// createRevision はスナップショットに次のリビジョン番号を割り当てて作成し､rel.Status.Revision に記録する
+// 失敗からの再試行などで最新のリビジョンと内容が変わらない場合は､作成せずに最新のリビジョンを使用する
+// 作成した場合は､ロールアウトが完了しなかった以前のリビジョンを削除する
+func (m *Manager) createRevision(
+	ctx context.Context,
+	rel *tacokumogithubiov1alpha1.Release,
+	snapshot *tacokumogithubiov1alpha1.ReleaseRevision,
+) error {
+	revisions, err := m.listRevisions(ctx, rel)
+	if err != nil {
+		return err
+	}
+	var latest *tacokumogithubiov1alpha1.ReleaseRevision
+	for i := range revisions {
+		if latest == nil || revisions[i].Spec.Revision > latest.Spec.Revision {
+			latest = &revisions[i]
+		}
+	}
+	if latest != nil {
+		same, err := sameRevisionContent(latest, snapshot)
+		if err != nil {
+			return err
+		}
+		if same {
+			rel.Status.Revision = latest.Spec.Revision
+			return nil
+		}
+	}
+	next := int64(1)
+	if latest != nil {
+		next = latest.Spec.Revision + 1
+	}
+
+	snapshot.Namespace = rel.Namespace
+	snapshot.Name = revisionName(rel, next)
+	snapshot.Labels = map[string]string{tacokumogithubiov1alpha1.ReleaseLabelKey: rel.Name}
+	snapshot.Spec.Revision = next
+	if err := controllerutil.SetControllerReference(rel, snapshot, m.k8sClient.Scheme()); err != nil {
+		return err
+	}
+	if err := m.k8sClient.Create(ctx, snapshot); err != nil {
+		return fmt.Errorf("failed to create revision %d: %w", next, err)
+	}
+
+	rel.Status.Revision = next
+	return m.pruneRevisions(ctx, rel)
+}
+
+// sameRevisionContent はリビジョンとスナップショットのコミット､イメージのダイジェスト､マニフェストが同じかどうかを返す
+func sameRevisionContent(rev, snapshot *tacokumogithubiov1alpha1.ReleaseRevision) (bool, error) {
+	digestOf := func(r *tacokumogithubiov1alpha1.ReleaseRevision) string {
+		if r.Spec.Image == nil {
+			return ""
+		}
+		return r.Spec.Image.Digest
+	}
+	if rev.Spec.Commit != snapshot.Spec.Commit ||
+		digestOf(rev) != digestOf(snapshot) ||
+		rev.Spec.EncryptedEnvSecretName != snapshot.Spec.EncryptedEnvSecretName {
+		return false, nil
+	}
+	// gzipの出力には依存せず､展開したマニフェストを比較する
+	current, err := decompress(rev.Spec.Manifests)
+	if err != nil {
+		return false, fmt.Errorf("failed to decode revision %d: %w", rev.Spec.Revision, err)
+	}
+	desired, err := decompress(snapshot.Spec.Manifests)
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(current, desired), nil
+}
+
+// getDeployedRevision はロールアウトが完了したことのあるリビジョンを取得する
+func (m *Manager) getDeployedRevision(
+	ctx context.Context,
+	rel *tacokumogithubiov1alpha1.Release,
+	revision int64,
+) (*tacokumogithubiov1alpha1.ReleaseRevision, error) {
+	rev := &tacokumogithubiov1alpha1.ReleaseRevision{}
+	if err := m.k8sClient.Get(ctx, client.ObjectKey{
+		Namespace: rel.Namespace,
+		Name:      revisionName(rel, revision),
+	}, rev); err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil, fmt.Errorf("revision %d of release %s not found", revision, rel.Name)
+		}
+		return nil, err
+	}
+	if rev.Status.DeployedAt == nil {
+		return nil, fmt.Errorf("revision %d of release %s has never been rolled out successfully", revision, rel.Name)
+	}
+	return rev, nil
+}
+
+// markRevisionDeployed は rel.Status.Revision のロールアウトが完了したことを記録し､そのコミットを返す
+// リビジョンが存在しない場合は spec.commit を返す
+func (m *Manager) markRevisionDeployed(
+	ctx context.Context,
+	rel *tacokumogithubiov1alpha1.Release,
+) (string, error) {
+	if rel.Status.Revision == 0 {
+		return ptr.Deref(rel.Spec.Commit, ""), nil
+	}
+	rev := &tacokumogithubiov1alpha1.ReleaseRevision{}
+	if err := m.k8sClient.Get(ctx, client.ObjectKey{
+		Namespace: rel.Namespace,
+		Name:      revisionName(rel, rel.Status.Revision),
+	}, rev); err != nil {
+		if apierrors.IsNotFound(err) {
+			return ptr.Deref(rel.Spec.Commit, ""), nil
+		}
+		return "", err
+	}
+	if rev.Status.DeployedAt == nil {
+		rev.Status.DeployedAt = ptr.To(metav1.Now())
+		if err := m.k8sClient.Status().Update(ctx, rev); err != nil {
+			return "", fmt.Errorf("failed to update revision %d: %w", rev.Spec.Revision, err)
+		}
+	}
+	return rev.Spec.Commit, nil
+}
+
+// pruneRevisions はロールバック先として使えないリビジョンと､spec.revisionHistoryLimit を超えた古いリビジョンを削除する
+// ロールアウトが完了しなかったリビジョンはロールバック先にならないため上限に数えずに削除し､
+// ロールアウトが完了したリビジョンは新しいものから上限の数だけ保持する
+// 最後に適用したリビジョンは削除しない
+func (m *Manager) pruneRevisions(
+	ctx context.Context,
+	rel *tacokumogithubiov1alpha1.Release,
+) error {
+	revisions, err := m.listRevisions(ctx, rel)
+	if err != nil {
+		return err
+	}
+	sort.Slice(revisions, func(i, j int) bool {
+		return revisions[i].Spec.Revision > revisions[j].Spec.Revision
+	})
+
+	limit := int(ptr.Deref(rel.Spec.RevisionHistoryLimit, defaultRevisionHistoryLimit))
+	deployed := 0
+	for i := range revisions {
+		rev := &revisions[i]
+		if rev.Status.DeployedAt != nil && deployed < limit {
+			deployed++
+			continue
+		}
+		if rev.Spec.Revision == rel.Status.Revision {
+			continue
+		}
+		if err := m.k8sClient.Delete(ctx, rev); client.IgnoreNotFound(err) != nil {
+			return fmt.Errorf("failed to delete revision %d: %w", rev.Spec.Revision, err)
+		}
+	}
+	return nil
+}
+
+func (m *Manager) listRevisions(
+	ctx context.Context,
+	rel *tacokumogithubiov1alpha1.Release,
+) ([]tacokumogithubiov1alpha1.ReleaseRevision, error) {
+	list := &tacokumogithubiov1alpha1.ReleaseRevisionList{}
+	if err := m.k8sClient.List(ctx, list,
+		client.InNamespace(rel.Namespace),
+		client.MatchingLabels{tacokumogithubiov1alpha1.ReleaseLabelKey: rel.Name},
+	); err != nil {
+		return nil, err
+	}
+	return list.Items, nil
+}
+
+// revisionObjects はリビジョンに記録されたマニフェストを､チャートのオブジェクトとプロセスのワークロードに分けて返す
+func revisionObjects(
+	rev *tacokumogithubiov1alpha1.ReleaseRevision,
+) ([]*unstructured.Unstructured, []*unstructured.Unstructured, error) {
+	objects, err := decodeManifests(rev.Spec.Manifests)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to decode revision %d: %w", rev.Spec.Revision, err)
+	}
+	processes, others := lo.FilterReject(objects, func(obj *unstructured.Unstructured, _ int) bool {
+		return lo.ContainsBy(rev.Spec.Processes, func(p tacokumogithubiov1alpha1.ReleaseRevisionProcess) bool {
+			return p.Kind == obj.GetKind() && p.Name == obj.GetName()
+		})
+	})
+	return others, processes, nil
+}
+
+func revisionName(rel *tacokumogithubiov1alpha1.Release, revision int64) string {
+	return fmt.Sprintf("%s-r%d", rel.Name, revision)
+}
+
+// encodeManifests はオブジェクトをYAMLのマルチドキュメントとしてgzipで圧縮する
+func encodeManifests(objects []*unstructured.Unstructured) ([]byte, error) {
+	var buf bytes.Buffer
+	for _, obj := range objects {
+		data, err := yaml.Marshal(obj.Object)
+		if err != nil {
+			return nil, err
+		}
+		buf.WriteString("---\n")
+		buf.Write(data)
+	}
+	return compress(buf.Bytes())
+}
+
+func decodeManifests(data []byte) ([]*unstructured.Unstructured, error) {
+	manifests, err := decompress(data)
+	if err != nil {
+		return nil, err
+	}
+	return helmutil.ParseManifestsToUnstructured(string(manifests))
+}
+
+func compress(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func decompress(data []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = r.Close() }()
+	return io.ReadAll(r)
+}
@@ -0,0 +1,374 @@
+package release
+
+import (
+	"context"
+	"testing"
+
+	tacokumogithubiov1alpha1 "github.com/tacokumo/portal-controller-kubernetes/api/v1alpha1"
+	"github.com/tacokumo/portal-controller-kubernetes/pkg/repoconnector"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func newRevisionTestConfigMap(name, image string) *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{}
+	obj.SetAPIVersion("v1")
+	obj.SetKind("ConfigMap")
+	obj.SetNamespace("production")
+	obj.SetName(name)
+	obj.Object["data"] = map[string]interface{}{"image": image}
+	return obj
+}
+
+// newTestRevision はロールアウトが完了した､ConfigMapとworkerプロセスを含むリビジョンを作成する
+func newTestRevision(
+	t *testing.T,
+	rel *tacokumogithubiov1alpha1.Release,
+	revision int64,
+	image string,
+) *tacokumogithubiov1alpha1.ReleaseRevision {
+	t.Helper()
+	worker, err := toUnstructured(&appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Namespace: rel.Namespace, Name: rel.Name + "-worker"},
+	}, appsv1.SchemeGroupVersion.WithKind("Deployment"))
+	require.NoError(t, err)
+
+	snapshot, err := newRevisionSnapshot(rel,
+		[]*unstructured.Unstructured{newRevisionTestConfigMap(rel.Name, image)},
+		[]*unstructured.Unstructured{worker},
+		map[string]any{"main": map[string]any{"image": image}},
+	)
+	require.NoError(t, err)
+	snapshot.Namespace = rel.Namespace
+	snapshot.Name = revisionName(rel, revision)
+	snapshot.Labels = map[string]string{tacokumogithubiov1alpha1.ReleaseLabelKey: rel.Name}
+	snapshot.Spec.Revision = revision
+	snapshot.Spec.Image = &tacokumogithubiov1alpha1.ReleaseImageStatus{
+		Reference: image, Digest: "sha256:old", Commit: snapshot.Spec.Commit,
+	}
+	snapshot.Status.DeployedAt = ptr.To(metav1.Now())
+	return snapshot
+}
+
+func TestRevisionObjects(t *testing.T) {
+	rel := &tacokumogithubiov1alpha1.Release{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-app-production", Namespace: "production"},
+		Spec:       tacokumogithubiov1alpha1.ReleaseSpec{Commit: stringPtr("abc123")},
+	}
+	rev := newTestRevision(t, rel, 1, "app:v1")
+
+	assert.Equal(t, "abc123", rev.Spec.Commit)
+	assert.Equal(t, []tacokumogithubiov1alpha1.ReleaseRevisionProcess{
+		{APIVersion: "apps/v1", Kind: "Deployment", Name: "test-app-production-worker"},
+	}, rev.Spec.Processes)
+
+	objects, processes, err := revisionObjects(rev)
+	require.NoError(t, err)
+	require.Len(t, objects, 1)
+	assert.Equal(t, newRevisionTestConfigMap("test-app-production", "app:v1").Object, objects[0].Object)
+	require.Len(t, processes, 1)
+	assert.Equal(t, "Deployment", processes[0].GetKind())
+	assert.Equal(t, "test-app-production-worker", processes[0].GetName())
+
+	values, err := decompress(rev.Spec.Values)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"main":{"image":"app:v1"}}`, string(values))
+}
+
+func TestManager_reconcileOnDeployingState_RecordsRevision(t *testing.T) {
+	rel := &tacokumogithubiov1alpha1.Release{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-app-production", Namespace: "production", UID: "rel-uid"},
+		Spec: tacokumogithubiov1alpha1.ReleaseSpec{
+			AppConfigPath: "appconfig.yaml",
+			Commit:        stringPtr("main"),
+		},
+		Status: tacokumogithubiov1alpha1.ReleaseStatus{
+			State: tacokumogithubiov1alpha1.ReleaseStateDeploying,
+		},
+	}
+	k8sClient := fake.NewClientBuilder().
+		WithScheme(newProcessTestScheme(t)).
+		WithObjects(rel).
+		WithStatusSubresource(rel, &tacokumogithubiov1alpha1.ReleaseRevision{}).
+		Build()
+	connector := repoconnector.NewLocalConnector(repoTestdataPath("release-test-data"))
+	m := newTestManager(t, k8sClient, connector, testdataPath(""))
+
+	require.NoError(t, m.reconcileOnDeployingState(context.Background(), rel))
+	assert.Equal(t, int64(1), rel.Status.Revision)
+
+	rev := &tacokumogithubiov1alpha1.ReleaseRevision{}
+	require.NoError(t, k8sClient.Get(context.Background(), client.ObjectKey{
+		Namespace: "production", Name: "test-app-production-r1",
+	}, rev))
+	assert.Equal(t, "test-app-production", rev.Spec.Release)
+	assert.Equal(t, "main", rev.Spec.Commit)
+	assert.Equal(t, testImageDigest, rev.Spec.Image.Digest)
+	assert.Equal(t, "test-app-production", rev.Labels[tacokumogithubiov1alpha1.ReleaseLabelKey])
+	require.Len(t, rev.OwnerReferences, 1)
+	assert.Equal(t, "Release", rev.OwnerReferences[0].Kind)
+	assert.Nil(t, rev.Status.DeployedAt)
+
+	objects, _, err := revisionObjects(rev)
+	require.NoError(t, err)
+	require.Len(t, objects, 1)
+	assert.Equal(t, "ConfigMap", objects[0].GetKind())
+	assert.Equal(t, "production", objects[0].GetNamespace())
+	assert.Equal(t, testImage+"@"+testImageDigest, objects[0].Object["data"].(map[string]interface{})["image"])
+
+	// 内容が変わらない再試行では新しいリビジョンを作成しない
+	rel.Status.State = tacokumogithubiov1alpha1.ReleaseStateDeploying
+	require.NoError(t, m.reconcileOnDeployingState(context.Background(), rel))
+	assert.Equal(t, int64(1), rel.Status.Revision)
+	revisions := &tacokumogithubiov1alpha1.ReleaseRevisionList{}
+	require.NoError(t, k8sClient.List(context.Background(), revisions))
+	assert.Len(t, revisions.Items, 1)
+}
+
+func TestManager_createRevision(t *testing.T) {
+	rel := &tacokumogithubiov1alpha1.Release{
+		ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "default", UID: "rel-uid"},
+		Spec:       tacokumogithubiov1alpha1.ReleaseSpec{Commit: stringPtr("abc123")},
+	}
+	undeployed := func(revision int64, image string) *tacokumogithubiov1alpha1.ReleaseRevision {
+		rev := newTestRevision(t, rel, revision, image)
+		rev.Status.DeployedAt = nil
+		return rev
+	}
+
+	tests := []struct {
+		name           string
+		existing       []*tacokumogithubiov1alpha1.ReleaseRevision
+		image          string
+		expectRevision int64
+		expectNames    []string
+	}{
+		{
+			name: "reuses the latest revision with the same content",
+			existing: []*tacokumogithubiov1alpha1.ReleaseRevision{
+				newTestRevision(t, rel, 1, "app:v1"), undeployed(2, "app:v2"),
+			},
+			image:          "app:v2",
+			expectRevision: 2,
+			expectNames:    []string{"app-r1", "app-r2"},
+		},
+		{
+			// 失敗した試行のリビジョンは､新しいリビジョンを作成した時点で削除される
+			name: "creates a new revision and prunes undeployed revisions",
+			existing: []*tacokumogithubiov1alpha1.ReleaseRevision{
+				newTestRevision(t, rel, 1, "app:v1"), undeployed(2, "app:v2"), undeployed(3, "app:v3"),
+			},
+			image:          "app:v4",
+			expectRevision: 4,
+			expectNames:    []string{"app-r1", "app-r4"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			objects := []client.Object{rel.DeepCopy()}
+			for _, rev := range tt.existing {
+				objects = append(objects, rev)
+			}
+			k8sClient := fake.NewClientBuilder().
+				WithScheme(newProcessTestScheme(t)).
+				WithObjects(objects...).
+				Build()
+			m := newTestManager(t, k8sClient, nil, "/tmp/test")
+
+			target := rel.DeepCopy()
+			snapshot := newTestRevision(t, target, 0, tt.image)
+			snapshot.ObjectMeta = metav1.ObjectMeta{}
+			snapshot.Status = tacokumogithubiov1alpha1.ReleaseRevisionStatus{}
+			require.NoError(t, m.createRevision(context.Background(), target, snapshot))
+			assert.Equal(t, tt.expectRevision, target.Status.Revision)
+
+			revisions := &tacokumogithubiov1alpha1.ReleaseRevisionList{}
+			require.NoError(t, k8sClient.List(context.Background(), revisions))
+			names := make([]string, 0, len(revisions.Items))
+			for _, rev := range revisions.Items {
+				names = append(names, rev.Name)
+			}
+			assert.ElementsMatch(t, tt.expectNames, names)
+		})
+	}
+}
+
+func TestManager_reconcileOnDeployingState_RollbackToRevision(t *testing.T) {
+	tests := []struct {
+		name         string
+		deployed     bool
+		expectErrMsg string
+	}{
+		{
+			name:     "re-applies the snapshot without git",
+			deployed: true,
+		},
+		{
+			name:         "rejects a revision that was never rolled out",
+			expectErrMsg: "revision 1 of release test-app-production has never been rolled out successfully",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rel := &tacokumogithubiov1alpha1.Release{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-app-production", Namespace: "production", Generation: 4},
+				Spec: tacokumogithubiov1alpha1.ReleaseSpec{
+					Commit:     stringPtr("bad456"),
+					RollbackTo: ptr.To(int64(1)),
+				},
+				Status: tacokumogithubiov1alpha1.ReleaseStatus{
+					State:    tacokumogithubiov1alpha1.ReleaseStateDeploying,
+					Revision: 2,
+					Processes: []corev1.ObjectReference{
+						{
+							APIVersion: "batch/v1", Kind: "CronJob",
+							Namespace: "production", Name: "test-app-production-cleanup",
+						},
+					},
+				},
+			}
+			snapshotRel := rel.DeepCopy()
+			snapshotRel.Spec.Commit = stringPtr("good123")
+			rev := newTestRevision(t, snapshotRel, 1, "app:v1")
+			if !tt.deployed {
+				rev.Status.DeployedAt = nil
+			}
+			live := newRevisionTestConfigMap("test-app-production", "app:v2")
+
+			k8sClient := fake.NewClientBuilder().
+				WithScheme(newProcessTestScheme(t)).
+				WithObjects(rel, rev, live).
+				WithStatusSubresource(rel, rev).
+				Build()
+			// gitが利用できない状態を再現するため､connectorは存在しないリポジトリを指す
+			connector := repoconnector.NewLocalConnector(repoTestdataPath("nonexistent"))
+			m := newTestManager(t, k8sClient, connector, testdataPath(""))
+
+			err := m.reconcileOnDeployingState(context.Background(), rel)
+			if tt.expectErrMsg != "" {
+				require.EqualError(t, err, tt.expectErrMsg)
+				return
+			}
+			require.NoError(t, err)
+
+			assert.Equal(t, tacokumogithubiov1alpha1.ReleaseStateRollingOut, rel.Status.State)
+			assert.Equal(t, int64(1), rel.Status.Revision)
+			assert.Equal(t, int64(4), rel.Status.ObservedGeneration)
+			assert.Equal(t, "good123", rel.Status.Image.Commit)
+			assert.Equal(t, []corev1.ObjectReference{
+				{
+					APIVersion: "apps/v1", Kind: "Deployment",
+					Namespace: "production", Name: "test-app-production-worker",
+				},
+			}, rel.Status.Processes)
+
+			cm := &corev1.ConfigMap{}
+			require.NoError(t, k8sClient.Get(context.Background(), client.ObjectKey{
+				Namespace: "production", Name: "test-app-production",
+			}, cm))
+			assert.Equal(t, "app:v1", cm.Data["image"])
+			require.NoError(t, k8sClient.Get(context.Background(), client.ObjectKey{
+				Namespace: "production", Name: "test-app-production-worker",
+			}, &appsv1.Deployment{}))
+
+			// ロールバックではリビジョンを作成しない
+			revisions := &tacokumogithubiov1alpha1.ReleaseRevisionList{}
+			require.NoError(t, k8sClient.List(context.Background(), revisions))
+			assert.Len(t, revisions.Items, 1)
+		})
+	}
+}
+
+func TestManager_Reconcile_OnRollingOutState_MarksRevisionDeployed(t *testing.T) {
+	rel := &tacokumogithubiov1alpha1.Release{
+		ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "default", Generation: 1},
+		Spec: tacokumogithubiov1alpha1.ReleaseSpec{
+			Commit:               stringPtr("bad456"),
+			RollbackTo:           ptr.To(int64(3)),
+			RevisionHistoryLimit: ptr.To(int32(2)),
+		},
+		Status: tacokumogithubiov1alpha1.ReleaseStatus{
+			State:              tacokumogithubiov1alpha1.ReleaseStateRollingOut,
+			ObservedGeneration: 1,
+			Revision:           3,
+		},
+	}
+	objects := []client.Object{rel, newRolloutTestDeployment("app", 1, completeDeploymentStatus)}
+	for _, revision := range []int64{1, 2, 3, 4, 5} {
+		snapshotRel := rel.DeepCopy()
+		snapshotRel.Spec.Commit = stringPtr("commit-" + revisionName(rel, revision))
+		rev := newTestRevision(t, snapshotRel, revision, "app:v1")
+		if revision == 3 {
+			rev.Status.DeployedAt = nil
+		}
+		objects = append(objects, rev)
+	}
+	k8sClient := fake.NewClientBuilder().
+		WithScheme(newProcessTestScheme(t)).
+		WithObjects(objects...).
+		WithStatusSubresource(rel, &tacokumogithubiov1alpha1.ReleaseRevision{}).
+		Build()
+	m := newTestManager(t, k8sClient, nil, "/tmp/test")
+
+	require.NoError(t, m.Reconcile(context.Background(), rel))
+	assert.Equal(t, tacokumogithubiov1alpha1.ReleaseStateDeployed, rel.Status.State)
+	assert.Equal(t, "commit-app-r3", rel.Status.DeployedCommit)
+
+	revisions := &tacokumogithubiov1alpha1.ReleaseRevisionList{}
+	require.NoError(t, k8sClient.List(context.Background(), revisions))
+	names := make([]string, 0, len(revisions.Items))
+	for _, rev := range revisions.Items {
+		names = append(names, rev.Name)
+		if rev.Spec.Revision == 3 {
+			assert.NotNil(t, rev.Status.DeployedAt)
+		}
+	}
+	// 最新の2つと､最後に適用したリビジョンが保持される
+	assert.ElementsMatch(t, []string{"app-r3", "app-r4", "app-r5"}, names)
+}
+
+func TestManager_pruneRevisions_KeepsDeployedRevisions(t *testing.T) {
+	// 失敗が続いても､ロールバック先となるロールアウトが完了したリビジョンは上限の数だけ残る
+	rel := &tacokumogithubiov1alpha1.Release{
+		ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "default", Generation: 1},
+		Spec: tacokumogithubiov1alpha1.ReleaseSpec{
+			Commit:               stringPtr("good"),
+			RevisionHistoryLimit: ptr.To(int32(2)),
+		},
+		Status: tacokumogithubiov1alpha1.ReleaseStatus{Revision: 6},
+	}
+	objects := []client.Object{rel}
+	for _, revision := range []int64{1, 2, 3, 4, 5, 6} {
+		rev := newTestRevision(t, rel, revision, "app:v1")
+		// 3から5は失敗した適用の試行
+		if revision >= 3 && revision <= 5 {
+			rev.Status.DeployedAt = nil
+		}
+		objects = append(objects, rev)
+	}
+	k8sClient := fake.NewClientBuilder().
+		WithScheme(newProcessTestScheme(t)).
+		WithObjects(objects...).
+		Build()
+	m := newTestManager(t, k8sClient, nil, "/tmp/test")
+
+	require.NoError(t, m.pruneRevisions(context.Background(), rel))
+
+	revisions := &tacokumogithubiov1alpha1.ReleaseRevisionList{}
+	require.NoError(t, k8sClient.List(context.Background(), revisions))
+	names := make([]string, 0, len(revisions.Items))
+	for _, rev := range revisions.Items {
+		names = append(names, rev.Name)
+	}
+	assert.ElementsMatch(t, []string{"app-r2", "app-r6"}, names)
+}
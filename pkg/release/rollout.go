@@ -0,0 +1,232 @@
+package release
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	tacokumogithubiov1alpha1 "github.com/tacokumo/portal-controller-kubernetes/api/v1alpha1"
+	"github.com/tacokumo/portal-controller-kubernetes/pkg/podhealth"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/selection"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// errProgressDeadlineExceeded はDeploymentのロールアウトが期限内に進まなかったことを示す
+var errProgressDeadlineExceeded = errors.New("progress deadline exceeded")
+
+// reconcileOnRollingOutState はReleaseのDeploymentのロールアウトを監視する
+// 全てのDeploymentのロールアウトが完了した時点でDeployedに遷移する
+func (m *Manager) reconcileOnRollingOutState(
+	ctx context.Context,
+	rel *tacokumogithubiov1alpha1.Release,
+) error {
+	if _, err := m.updatePodHealth(ctx, rel); err != nil {
+		return err
+	}
+
+	for _, name := range rolloutTargets(rel) {
+		deploy := &appsv1.Deployment{}
+		if err := m.k8sClient.Get(ctx, client.ObjectKey{Namespace: rel.Namespace, Name: name}, deploy); err != nil {
+			return fmt.Errorf("failed to get deployment %s: %w", name, err)
+		}
+
+		done, message, err := deploymentRolloutStatus(deploy)
+		if err != nil {
+			message := fmt.Sprintf("deployment %s: %s", name, message)
+			setProgressingCondition(rel, metav1.ConditionFalse,
+				tacokumogithubiov1alpha1.ReasonProgressDeadlineExceeded, message)
+			return fmt.Errorf("%s: %w", message, err)
+		}
+		if !done {
+			setProgressingCondition(rel, metav1.ConditionTrue, tacokumogithubiov1alpha1.ReasonRolloutInProgress,
+				fmt.Sprintf("deployment %s: %s", name, message))
+			return nil
+		}
+	}
+
+	// 新しいコミットに切り替わったため､他の更新方法で作成したワークロードは不要になる
+	if err := m.pruneStrategyWorkloads(ctx, rel); err != nil {
+		return err
+	}
+	commit, err := m.markRevisionDeployed(ctx, rel)
+	if err != nil {
+		return err
+	}
+	rel.Status.DeployedCommit = commit
+	rel.Status.DeployedAt = ptr.To(metav1.Now())
+	if err := m.pruneRevisions(ctx, rel); err != nil {
+		return err
+	}
+	if err := m.pruneEncryptedSecrets(ctx, rel); err != nil {
+		return err
+	}
+	if err := m.pruneHookJobs(ctx, rel); err != nil {
+		return err
+	}
+
+	setProgressingCondition(rel, metav1.ConditionFalse, tacokumogithubiov1alpha1.ReasonRolloutComplete,
+		"all workloads have been rolled out")
+	tacokumogithubiov1alpha1.SetReadyConditionTrue(
+		&rel.Status.Conditions,
+		rel.Generation,
+		tacokumogithubiov1alpha1.ReasonRolloutComplete,
+		"release has been deployed",
+	)
+	rel.Status.State = tacokumogithubiov1alpha1.ReleaseStateDeployed
+	return nil
+}
+
+// updatePodHealth はReleaseのワークロードのPodの状態を rel.Status に記録する
+// 全てのPodがReadyの場合に true を返す
+func (m *Manager) updatePodHealth(
+	ctx context.Context,
+	rel *tacokumogithubiov1alpha1.Release,
+) (bool, error) {
+	selector, err := labels.NewRequirement("application", selection.In, workloadApplications(rel))
+	if err != nil {
+		return false, err
+	}
+	pods := &corev1.PodList{}
+	if err := m.k8sClient.List(ctx, pods,
+		client.InNamespace(rel.Namespace),
+		client.MatchingLabelsSelector{Selector: labels.NewSelector().Add(*selector)},
+	); err != nil {
+		return false, err
+	}
+
+	rel.Status.Pods = podhealth.Summarize(pods.Items)
+	return podhealth.SetConditions(&rel.Status.Conditions, rel.Generation, rel.Status.Pods), nil
+}
+
+// rolloutTargets はロールアウトを監視するDeploymentの名前を返す
+// メインのDeploymentとworkerプロセスのDeploymentが対象となる
+func rolloutTargets(rel *tacokumogithubiov1alpha1.Release) []string {
+	targets := []string{mainDeploymentName(rel)}
+	for _, ref := range rel.Status.Processes {
+		if ref.Kind == "Deployment" {
+			targets = append(targets, ref.Name)
+		}
+	}
+	return targets
+}
+
+// workloadApplications はReleaseのワークロードのPodに付与される `application` ラベルの値を返す
+// カナリアとブルーグリーンデプロイのPodはメインのDeploymentと同じ値を持つ
+func workloadApplications(rel *tacokumogithubiov1alpha1.Release) []string {
+	applications := []string{rel.Name}
+	for _, ref := range rel.Status.Processes {
+		if ref.Kind == "Deployment" {
+			applications = append(applications, ref.Name)
+		}
+	}
+	return applications
+}
+
+// mainDeploymentName はServiceがトラフィックを流すDeploymentの名前を返す
+func mainDeploymentName(rel *tacokumogithubiov1alpha1.Release) string {
+	if bg := rel.Status.BlueGreen; isBlueGreen(rel) && bg != nil && bg.ActiveColor != "" {
+		return colorDeploymentName(rel, bg.ActiveColor)
+	}
+	return rel.Name
+}
+
+// pruneStrategyWorkloads はロールアウトが完了した後に不要になったワークロードを削除する
+// カナリアのDeploymentと､更新方法を変更した場合の以前の方法のDeploymentが対象となる
+func (m *Manager) pruneStrategyWorkloads(
+	ctx context.Context,
+	rel *tacokumogithubiov1alpha1.Release,
+) error {
+	if err := m.deleteCanary(ctx, rel); err != nil {
+		return err
+	}
+
+	if isBlueGreen(rel) {
+		deploy := &appsv1.Deployment{}
+		deploy.SetNamespace(rel.Namespace)
+		deploy.SetName(rel.Name)
+		if err := m.k8sClient.Delete(ctx, deploy); client.IgnoreNotFound(err) != nil {
+			return fmt.Errorf("failed to delete deployment %s: %w", rel.Name, err)
+		}
+		return nil
+	}
+
+	if rel.Status.BlueGreen == nil {
+		return nil
+	}
+	for _, color := range []string{
+		tacokumogithubiov1alpha1.BlueGreenColorBlue,
+		tacokumogithubiov1alpha1.BlueGreenColorGreen,
+	} {
+		if err := m.deleteBlueGreenPreview(ctx, rel, color); err != nil {
+			return err
+		}
+	}
+	rel.Status.BlueGreen = nil
+	return nil
+}
+
+// deploymentRolloutStatus はDeploymentのロールアウトが完了したかどうかを返す
+// 判定は kubectl rollout status と同じ基準で行う
+func deploymentRolloutStatus(deploy *appsv1.Deployment) (bool, string, error) {
+	if deploy.Generation > deploy.Status.ObservedGeneration {
+		return false, "waiting for the deployment spec update to be observed", nil
+	}
+	for _, c := range deploy.Status.Conditions {
+		if c.Type == appsv1.DeploymentProgressing && c.Reason == "ProgressDeadlineExceeded" {
+			return false, c.Message, errProgressDeadlineExceeded
+		}
+	}
+
+	replicas := ptr.Deref(deploy.Spec.Replicas, 1)
+	status := deploy.Status
+	switch {
+	case status.UpdatedReplicas < replicas:
+		return false, fmt.Sprintf("%d out of %d new replicas have been updated", status.UpdatedReplicas, replicas), nil
+	case status.Replicas > status.UpdatedReplicas:
+		pending := status.Replicas - status.UpdatedReplicas
+		return false, fmt.Sprintf("%d old replicas are pending termination", pending), nil
+	case status.AvailableReplicas < status.UpdatedReplicas:
+		return false, fmt.Sprintf("%d of %d updated replicas are available",
+			status.AvailableReplicas, status.UpdatedReplicas), nil
+	}
+	return true, "rollout complete", nil
+}
+
+// hasExceededProgressDeadline は現在のspecのDeploymentのロールアウトが期限内に進まなかったことを返す
+// 同じspecを再適用してもDeploymentの ProgressDeadlineExceeded は解消されないため､再デプロイしても再び失敗する
+func hasExceededProgressDeadline(rel *tacokumogithubiov1alpha1.Release) bool {
+	for _, conditionType := range []string{
+		tacokumogithubiov1alpha1.ConditionTypeProgressing,
+		tacokumogithubiov1alpha1.ConditionTypePreview,
+	} {
+		c := meta.FindStatusCondition(rel.Status.Conditions, conditionType)
+		if c != nil &&
+			c.Reason == tacokumogithubiov1alpha1.ReasonProgressDeadlineExceeded &&
+			c.ObservedGeneration == rel.Generation {
+			return true
+		}
+	}
+	return false
+}
+
+func setProgressingCondition(
+	rel *tacokumogithubiov1alpha1.Release,
+	status metav1.ConditionStatus,
+	reason string,
+	message string,
+) {
+	meta.SetStatusCondition(&rel.Status.Conditions, metav1.Condition{
+		Type:               tacokumogithubiov1alpha1.ConditionTypeProgressing,
+		Status:             status,
+		ObservedGeneration: rel.Generation,
+		Reason:             reason,
+		Message:            message,
+	})
+}
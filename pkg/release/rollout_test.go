@@ -0,0 +1,316 @@
+package release
+
+import (
+	"context"
+	"testing"
+
+	tacokumogithubiov1alpha1 "github.com/tacokumo/portal-controller-kubernetes/api/v1alpha1"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func newRolloutTestDeployment(name string, generation int64, status appsv1.DeploymentStatus) *appsv1.Deployment {
+	return &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name, Generation: generation},
+		Spec:       appsv1.DeploymentSpec{Replicas: ptr.To(int32(2))},
+		Status:     status,
+	}
+}
+
+var completeDeploymentStatus = appsv1.DeploymentStatus{
+	ObservedGeneration: 1,
+	Replicas:           2,
+	UpdatedReplicas:    2,
+	AvailableReplicas:  2,
+}
+
+func TestDeploymentRolloutStatus(t *testing.T) {
+	tests := []struct {
+		name          string
+		generation    int64
+		status        appsv1.DeploymentStatus
+		expectDone    bool
+		expectMessage string
+		expectErr     bool
+	}{
+		{
+			name:          "waits for spec update to be observed",
+			generation:    2,
+			status:        completeDeploymentStatus,
+			expectMessage: "waiting for the deployment spec update to be observed",
+		},
+		{
+			name:       "waits for replicas to be updated",
+			generation: 1,
+			status: appsv1.DeploymentStatus{
+				ObservedGeneration: 1, Replicas: 3, UpdatedReplicas: 1, AvailableReplicas: 2,
+			},
+			expectMessage: "1 out of 2 new replicas have been updated",
+		},
+		{
+			name:       "waits for old replicas to terminate",
+			generation: 1,
+			status: appsv1.DeploymentStatus{
+				ObservedGeneration: 1, Replicas: 3, UpdatedReplicas: 2, AvailableReplicas: 2,
+			},
+			expectMessage: "1 old replicas are pending termination",
+		},
+		{
+			name:       "waits for updated replicas to become available",
+			generation: 1,
+			status: appsv1.DeploymentStatus{
+				ObservedGeneration: 1, Replicas: 2, UpdatedReplicas: 2, AvailableReplicas: 1,
+			},
+			expectMessage: "1 of 2 updated replicas are available",
+		},
+		{
+			name:       "fails when progress deadline is exceeded",
+			generation: 1,
+			status: appsv1.DeploymentStatus{
+				ObservedGeneration: 1, Replicas: 2, UpdatedReplicas: 1,
+				Conditions: []appsv1.DeploymentCondition{{
+					Type:    appsv1.DeploymentProgressing,
+					Status:  corev1.ConditionFalse,
+					Reason:  "ProgressDeadlineExceeded",
+					Message: `ReplicaSet "app-5d8" has timed out progressing.`,
+				}},
+			},
+			expectMessage: `ReplicaSet "app-5d8" has timed out progressing.`,
+			expectErr:     true,
+		},
+		{
+			name:          "completes when all replicas are updated and available",
+			generation:    1,
+			status:        completeDeploymentStatus,
+			expectDone:    true,
+			expectMessage: "rollout complete",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			done, message, err := deploymentRolloutStatus(newRolloutTestDeployment("app", tt.generation, tt.status))
+			if tt.expectErr {
+				assert.ErrorIs(t, err, errProgressDeadlineExceeded)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tt.expectDone, done)
+			assert.Equal(t, tt.expectMessage, message)
+		})
+	}
+}
+
+func TestManager_Reconcile_OnRollingOutState(t *testing.T) {
+	tests := []struct {
+		name              string
+		workerStatus      appsv1.DeploymentStatus
+		expectErr         bool
+		expectState       string
+		expectProgressing metav1.ConditionStatus
+		expectReason      string
+		expectReady       bool
+	}{
+		{
+			name:              "transitions to Deployed when all deployments are rolled out",
+			workerStatus:      completeDeploymentStatus,
+			expectState:       tacokumogithubiov1alpha1.ReleaseStateDeployed,
+			expectProgressing: metav1.ConditionFalse,
+			expectReason:      tacokumogithubiov1alpha1.ReasonRolloutComplete,
+			expectReady:       true,
+		},
+		{
+			name: "stays RollingOut while a process deployment is progressing",
+			workerStatus: appsv1.DeploymentStatus{
+				ObservedGeneration: 1, Replicas: 2, UpdatedReplicas: 2, AvailableReplicas: 0,
+			},
+			expectState:       tacokumogithubiov1alpha1.ReleaseStateRollingOut,
+			expectProgressing: metav1.ConditionTrue,
+			expectReason:      tacokumogithubiov1alpha1.ReasonRolloutInProgress,
+		},
+		{
+			name: "fails when progress deadline is exceeded",
+			workerStatus: appsv1.DeploymentStatus{
+				ObservedGeneration: 1,
+				Conditions: []appsv1.DeploymentCondition{{
+					Type:   appsv1.DeploymentProgressing,
+					Status: corev1.ConditionFalse,
+					Reason: "ProgressDeadlineExceeded",
+				}},
+			},
+			expectErr:         true,
+			expectState:       tacokumogithubiov1alpha1.ReleaseStateFailed,
+			expectProgressing: metav1.ConditionFalse,
+			expectReason:      tacokumogithubiov1alpha1.ReasonProgressDeadlineExceeded,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scheme := newProcessTestScheme(t)
+			rel := &tacokumogithubiov1alpha1.Release{
+				ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "default", Generation: 1},
+				Status: tacokumogithubiov1alpha1.ReleaseStatus{
+					State:              tacokumogithubiov1alpha1.ReleaseStateRollingOut,
+					ObservedGeneration: 1,
+					Processes: []corev1.ObjectReference{
+						{APIVersion: "apps/v1", Kind: "Deployment", Namespace: "default", Name: "app-worker"},
+						{APIVersion: "batch/v1", Kind: "CronJob", Namespace: "default", Name: "app-cleanup"},
+					},
+				},
+			}
+			k8sClient := fake.NewClientBuilder().
+				WithScheme(scheme).
+				WithObjects(
+					rel,
+					newRolloutTestDeployment("app", 1, completeDeploymentStatus),
+					newRolloutTestDeployment("app-worker", 1, tt.workerStatus),
+				).
+				WithStatusSubresource(rel).
+				Build()
+			m := newTestManager(t, k8sClient, nil, "/tmp/test")
+
+			err := m.Reconcile(context.Background(), rel)
+			if tt.expectErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+
+			updated := &tacokumogithubiov1alpha1.Release{}
+			require.NoError(t, k8sClient.Get(context.Background(), client.ObjectKeyFromObject(rel), updated))
+			assert.Equal(t, tt.expectState, updated.Status.State)
+
+			progressing := meta.FindStatusCondition(
+				updated.Status.Conditions, tacokumogithubiov1alpha1.ConditionTypeProgressing)
+			require.NotNil(t, progressing)
+			assert.Equal(t, tt.expectProgressing, progressing.Status)
+			assert.Equal(t, tt.expectReason, progressing.Reason)
+			assert.Equal(t, tt.expectReady,
+				meta.IsStatusConditionTrue(updated.Status.Conditions, tacokumogithubiov1alpha1.ConditionTypeReady))
+		})
+	}
+}
+
+func TestManager_Reconcile_OnDeployedState(t *testing.T) {
+	tests := []struct {
+		name               string
+		generation         int64
+		observedGeneration int64
+		expectedState      string
+	}{
+		{
+			name:               "stays Deployed while spec is unchanged",
+			generation:         2,
+			observedGeneration: 2,
+			expectedState:      tacokumogithubiov1alpha1.ReleaseStateDeployed,
+		},
+		{
+			name:               "redeploys when spec is updated",
+			generation:         3,
+			observedGeneration: 2,
+			expectedState:      tacokumogithubiov1alpha1.ReleaseStateDeploying,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scheme := newProcessTestScheme(t)
+			rel := &tacokumogithubiov1alpha1.Release{
+				ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "default", Generation: tt.generation},
+				Status: tacokumogithubiov1alpha1.ReleaseStatus{
+					State:              tacokumogithubiov1alpha1.ReleaseStateDeployed,
+					ObservedGeneration: tt.observedGeneration,
+				},
+			}
+			k8sClient := fake.NewClientBuilder().
+				WithScheme(scheme).
+				WithObjects(rel).
+				WithStatusSubresource(rel).
+				Build()
+			m := newTestManager(t, k8sClient, nil, "/tmp/test")
+
+			require.NoError(t, m.Reconcile(context.Background(), rel))
+			assert.Equal(t, tt.expectedState, rel.Status.State)
+		})
+	}
+}
+
+func TestManager_Reconcile_OnDeployedState_ReportsPodHealth(t *testing.T) {
+	scheme := newProcessTestScheme(t)
+	rel := &tacokumogithubiov1alpha1.Release{
+		ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "default", Generation: 1},
+		Status: tacokumogithubiov1alpha1.ReleaseStatus{
+			State:              tacokumogithubiov1alpha1.ReleaseStateDeployed,
+			ObservedGeneration: 1,
+			Processes: []corev1.ObjectReference{
+				{APIVersion: "apps/v1", Kind: "Deployment", Namespace: "default", Name: "app-worker"},
+			},
+		},
+	}
+	readyPod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "default", Name: "app-1", Labels: map[string]string{"application": "app"},
+		},
+		Status: corev1.PodStatus{
+			Phase:      corev1.PodRunning,
+			Conditions: []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionTrue}},
+		},
+	}
+	crashingWorker := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "default", Name: "app-worker-1", Labels: map[string]string{"application": "app-worker"},
+		},
+		Status: corev1.PodStatus{
+			Phase: corev1.PodRunning,
+			ContainerStatuses: []corev1.ContainerStatus{{
+				RestartCount: 4,
+				State: corev1.ContainerState{
+					Waiting: &corev1.ContainerStateWaiting{Reason: "CrashLoopBackOff"},
+				},
+				LastTerminationState: corev1.ContainerState{
+					Terminated: &corev1.ContainerStateTerminated{Reason: "OOMKilled", ExitCode: 137},
+				},
+			}},
+		},
+	}
+	otherPod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "default", Name: "other-1", Labels: map[string]string{"application": "other"},
+		},
+	}
+	k8sClient := fake.NewClientBuilder().
+		WithScheme(scheme).
+		WithObjects(rel, readyPod, crashingWorker, otherPod).
+		WithStatusSubresource(rel).
+		Build()
+	m := newTestManager(t, k8sClient, nil, "/tmp/test")
+
+	require.NoError(t, m.Reconcile(context.Background(), rel))
+
+	assert.Equal(t, tacokumogithubiov1alpha1.ReleaseStateDeployed, rel.Status.State)
+	require.Len(t, rel.Status.Pods, 2)
+	assert.Equal(t, "app-1", rel.Status.Pods[0].Name)
+	assert.True(t, rel.Status.Pods[0].Ready)
+	assert.Equal(t, tacokumogithubiov1alpha1.PodReference{
+		NamespacedName:        tacokumogithubiov1alpha1.NamespacedName{Namespace: "default", Name: "app-worker-1"},
+		Phase:                 "Running",
+		RestartCount:          4,
+		Reason:                "CrashLoopBackOff",
+		LastTerminationReason: "OOMKilled",
+	}, rel.Status.Pods[1])
+
+	assert.True(t, meta.IsStatusConditionFalse(rel.Status.Conditions, tacokumogithubiov1alpha1.ConditionTypePodsReady))
+	degraded := meta.FindStatusCondition(rel.Status.Conditions, tacokumogithubiov1alpha1.ConditionTypeDegraded)
+	require.NotNil(t, degraded)
+	assert.Equal(t, metav1.ConditionTrue, degraded.Status)
+	assert.Contains(t, degraded.Message, "app-worker-1 (CrashLoopBackOff, last terminated: OOMKilled, restarts: 4)")
+}
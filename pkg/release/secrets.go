@@ -0,0 +1,202 @@
+package release
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+
+	tacokumogithubiov1alpha1 "github.com/tacokumo/portal-controller-kubernetes/api/v1alpha1"
+	"github.com/tacokumo/portal-controller-kubernetes/pkg/appconfigext"
+	"github.com/tacokumo/portal-controller-kubernetes/pkg/repoconnector"
+	"github.com/tacokumo/portal-controller-kubernetes/pkg/secretfile"
+
+	"github.com/samber/lo"
+	applicationchart "github.com/tacokumo/helm-charts/charts/tacokumo-application"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+// encryptedEnvLabelKey は暗号化された環境変数を復号して格納したSecretに付与されるラベルのキー
+const encryptedEnvLabelKey = "tacokumo.github.io/encrypted-env"
+
+// encryptedSecretName はappconfigの暗号化された環境変数を復号して格納するSecretの名前を返す
+// ロールバックしたリビジョンが当時の値を参照できるよう､暗号化ファイルの内容ごとに異なる名前とする
+// 平文の値が推測されないよう､ハッシュは暗号化された内容から計算する
+func encryptedSecretName(rel *tacokumogithubiov1alpha1.Release, data []byte) string {
+	sum := sha256.Sum256(data)
+	return fmt.Sprintf("%s-encrypted-env-%s", rel.Name, hex.EncodeToString(sum[:])[:10])
+}
+
+// envFromOf はコンテナに読み込ませる環境変数のSecretを返す
+// 同じ名前の環境変数は後に指定されたものが優先されるため､
+// APIで設定された spec.envSecretName の値がリポジトリの値より優先される
+func envFromOf(
+	rel *tacokumogithubiov1alpha1.Release,
+	appCfg *appconfigext.AppConfig,
+) []corev1.EnvFromSource {
+	var envFrom []corev1.EnvFromSource
+	if appCfg.EncryptedSecretsFile() != "" && rel.Status.EncryptedEnvSecretName != "" {
+		envFrom = append(envFrom, corev1.EnvFromSource{
+			SecretRef: &corev1.SecretEnvSource{
+				LocalObjectReference: corev1.LocalObjectReference{Name: rel.Status.EncryptedEnvSecretName},
+			},
+		})
+	}
+	if rel.Spec.EnvSecretName != nil && *rel.Spec.EnvSecretName != "" {
+		envFrom = append(envFrom, corev1.EnvFromSource{
+			SecretRef: &corev1.SecretEnvSource{
+				LocalObjectReference: corev1.LocalObjectReference{Name: *rel.Spec.EnvSecretName},
+			},
+		})
+	}
+	return envFrom
+}
+
+// constructEnvFromValues は envFromOf をtacokumo-applicationチャートのvaluesに変換する
+func constructEnvFromValues(
+	rel *tacokumogithubiov1alpha1.Release,
+	appCfg *appconfigext.AppConfig,
+) []applicationchart.EnvFromSource {
+	return lo.Map(envFromOf(rel, appCfg), func(source corev1.EnvFromSource, _ int) applicationchart.EnvFromSource {
+		return applicationchart.EnvFromSource{
+			SecretRef: &applicationchart.SecretEnvSource{Name: source.SecretRef.Name},
+		}
+	})
+}
+
+// resolveEncryptedSecretName はappconfigの暗号化された環境変数のファイルを読み込み､
+// 復号した値を格納するSecretの名前を rel.Status.EncryptedEnvSecretName に記録する
+// ファイルが設定されていない場合は空文字列を記録し､nil を返す
+func (m *Manager) resolveEncryptedSecretName(
+	ctx context.Context,
+	rel *tacokumogithubiov1alpha1.Release,
+	appCfg *appconfigext.AppConfig,
+) ([]byte, error) {
+	path := appCfg.EncryptedSecretsFile()
+	if path == "" {
+		rel.Status.EncryptedEnvSecretName = ""
+		return nil, nil
+	}
+	data, err := repoconnector.ReadRepositoryFile(ctx, m.connector, rel.Spec.Repo.URL, *rel.Spec.Commit, path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read encrypted secrets file %s: %w", path, err)
+	}
+	rel.Status.EncryptedEnvSecretName = encryptedSecretName(rel, data)
+	return data, nil
+}
+
+// reconcileEncryptedSecrets はappconfigの暗号化された環境変数のファイルを復号し､
+// Releaseが所有するSecretとして作成する
+// 以前のリビジョンが参照するSecretは pruneEncryptedSecrets で削除されるまで残す
+// 平文が記録されないよう､復号した値はエラーやログに含めない
+func (m *Manager) reconcileEncryptedSecrets(
+	ctx context.Context,
+	rel *tacokumogithubiov1alpha1.Release,
+	appCfg *appconfigext.AppConfig,
+) error {
+	data, err := m.resolveEncryptedSecretName(ctx, rel, appCfg)
+	if err != nil {
+		return err
+	}
+	if data == nil {
+		return nil
+	}
+	path := appCfg.EncryptedSecretsFile()
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: rel.Namespace,
+			Name:      rel.Status.EncryptedEnvSecretName,
+		},
+	}
+	privateKeys, err := m.loadDecryptionKeys(ctx, rel)
+	if err != nil {
+		return err
+	}
+	values, err := secretfile.Decrypt(data, privateKeys)
+	if err != nil {
+		return fmt.Errorf("failed to decrypt encrypted secrets file %s: %w", path, err)
+	}
+
+	if _, err := controllerutil.CreateOrUpdate(ctx, m.k8sClient, secret, func() error {
+		if secret.Labels == nil {
+			secret.Labels = map[string]string{}
+		}
+		secret.Labels["application"] = rel.Name
+		secret.Labels[tacokumogithubiov1alpha1.ReleaseLabelKey] = rel.Name
+		secret.Labels[encryptedEnvLabelKey] = "true"
+		secret.Type = corev1.SecretTypeOpaque
+		secret.Data = make(map[string][]byte, len(values))
+		for name, value := range values {
+			secret.Data[name] = []byte(value)
+		}
+		return controllerutil.SetControllerReference(rel, secret, m.k8sClient.Scheme())
+	}); err != nil {
+		return fmt.Errorf("failed to apply secret %s: %w", secret.Name, err)
+	}
+	return nil
+}
+
+// pruneEncryptedSecrets は現在のデプロイと保持しているリビジョンのいずれからも参照されなくなった､
+// 暗号化された環境変数のSecretを削除する
+func (m *Manager) pruneEncryptedSecrets(
+	ctx context.Context,
+	rel *tacokumogithubiov1alpha1.Release,
+) error {
+	revisions, err := m.listRevisions(ctx, rel)
+	if err != nil {
+		return err
+	}
+	referenced := lo.Map(revisions, func(rev tacokumogithubiov1alpha1.ReleaseRevision, _ int) string {
+		return rev.Spec.EncryptedEnvSecretName
+	})
+	referenced = append(referenced, rel.Status.EncryptedEnvSecretName)
+
+	secrets := &corev1.SecretList{}
+	if err := m.k8sClient.List(ctx, secrets,
+		client.InNamespace(rel.Namespace),
+		client.MatchingLabels{tacokumogithubiov1alpha1.ReleaseLabelKey: rel.Name, encryptedEnvLabelKey: "true"},
+	); err != nil {
+		return err
+	}
+	for i := range secrets.Items {
+		secret := &secrets.Items[i]
+		if lo.Contains(referenced, secret.Name) {
+			continue
+		}
+		if err := m.k8sClient.Delete(ctx, secret); client.IgnoreNotFound(err) != nil {
+			return fmt.Errorf("failed to delete secret %s: %w", secret.Name, err)
+		}
+	}
+	return nil
+}
+
+// loadDecryptionKeys は spec.decryptionKeySecretName のSecretから秘密鍵を読み込む
+// 結果が安定するよう､キーの名前順に返す
+func (m *Manager) loadDecryptionKeys(
+	ctx context.Context,
+	rel *tacokumogithubiov1alpha1.Release,
+) ([]string, error) {
+	name := tacokumogithubiov1alpha1.DefaultDecryptionKeySecretName
+	if rel.Spec.DecryptionKeySecretName != nil && *rel.Spec.DecryptionKeySecretName != "" {
+		name = *rel.Spec.DecryptionKeySecretName
+	}
+
+	secret := &corev1.Secret{}
+	if err := m.k8sClient.Get(ctx, client.ObjectKey{
+		Namespace: rel.Namespace,
+		Name:      name,
+	}, secret); err != nil {
+		return nil, fmt.Errorf("failed to get decryption key secret %s: %w", name, err)
+	}
+
+	keys := lo.Keys(secret.Data)
+	sort.Strings(keys)
+	return lo.Map(keys, func(key string, _ int) string {
+		return string(secret.Data[key])
+	}), nil
+}
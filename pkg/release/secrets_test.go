@@ -0,0 +1,221 @@
+package release
+
+import (
+	"context"
+	"testing"
+
+	tacokumogithubiov1alpha1 "github.com/tacokumo/portal-controller-kubernetes/api/v1alpha1"
+	"github.com/tacokumo/portal-controller-kubernetes/pkg/appconfigext"
+	"github.com/tacokumo/portal-controller-kubernetes/pkg/repoconnector"
+	"github.com/tacokumo/portal-controller-kubernetes/pkg/secretfile"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// testDecryptionKey は release-encrypted-secrets のテストデータの暗号化に使用した公開鍵に対応する秘密鍵
+const testDecryptionKey = "JgFONjsuZ2Z+bAyvMXHyhvweZX+EmvphxppcEwxA+hc="
+
+func newEncryptedSecretsTestRelease() *tacokumogithubiov1alpha1.Release {
+	return &tacokumogithubiov1alpha1.Release{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-app-production", Namespace: "default", UID: "rel-uid"},
+		Spec: tacokumogithubiov1alpha1.ReleaseSpec{
+			Repo:          tacokumogithubiov1alpha1.RepositoryRef{URL: "https://example.com/repo.git"},
+			AppConfigPath: "appconfig.yaml",
+			Commit:        stringPtr("abc123"),
+		},
+	}
+}
+
+func newDecryptionKeySecret(name string, keys map[string]string) *corev1.Secret {
+	data := map[string][]byte{}
+	for k, v := range keys {
+		data[k] = []byte(v)
+	}
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name},
+		Data:       data,
+	}
+}
+
+func TestManager_reconcileEncryptedSecrets(t *testing.T) {
+	_, otherKey, err := secretfile.GenerateKey()
+	require.NoError(t, err)
+	defaultKeySecretName := tacokumogithubiov1alpha1.DefaultDecryptionKeySecretName
+
+	tests := []struct {
+		name           string
+		keySecretName  *string
+		keySecret      *corev1.Secret
+		expectErrMsg   string
+		expectedValues map[string]string
+	}{
+		{
+			name:      "decrypts the file into a secret owned by the release",
+			keySecret: newDecryptionKeySecret(defaultKeySecretName, map[string]string{"current": testDecryptionKey}),
+			expectedValues: map[string]string{
+				"DATABASE_URL": "postgres://user:pass@db:5432/app",
+				"API_TOKEN":    "s3cr3t-token",
+			},
+		},
+		{
+			name:          "uses the decryption key secret of spec with rotated keys",
+			keySecretName: stringPtr("custom-keys"),
+			keySecret: newDecryptionKeySecret("custom-keys", map[string]string{
+				"new": otherKey,
+				"old": testDecryptionKey,
+			}),
+			expectedValues: map[string]string{
+				"DATABASE_URL": "postgres://user:pass@db:5432/app",
+				"API_TOKEN":    "s3cr3t-token",
+			},
+		},
+		{
+			name:         "missing decryption key secret causes error",
+			expectErrMsg: "failed to get decryption key secret portal-decryption-keys",
+		},
+		{
+			name:         "wrong key causes error without plaintext",
+			keySecret:    newDecryptionKeySecret(defaultKeySecretName, map[string]string{"current": otherKey}),
+			expectErrMsg: "failed to decrypt encrypted secrets file secrets.enc.env",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scheme := newProcessTestScheme(t)
+			rel := newEncryptedSecretsTestRelease()
+			rel.Spec.DecryptionKeySecretName = tt.keySecretName
+
+			objects := []client.Object{rel}
+			if tt.keySecret != nil {
+				objects = append(objects, tt.keySecret)
+			}
+			k8sClient := fake.NewClientBuilder().WithScheme(scheme).WithObjects(objects...).Build()
+			connector := repoconnector.NewLocalConnector(repoTestdataPath("release-encrypted-secrets"))
+			m := newTestManager(t, k8sClient, connector, testdataPath(""))
+
+			appCfg, err := m.loadAppConfig(context.Background(), rel)
+			require.NoError(t, err)
+
+			err = m.reconcileEncryptedSecrets(context.Background(), rel, &appCfg)
+			secret := &corev1.Secret{}
+			getErr := k8sClient.Get(context.Background(), client.ObjectKey{
+				Namespace: rel.Namespace,
+				Name:      rel.Status.EncryptedEnvSecretName,
+			}, secret)
+			if tt.expectErrMsg != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.expectErrMsg)
+				assert.NotContains(t, err.Error(), "s3cr3t-token")
+				assert.True(t, apierrors.IsNotFound(getErr))
+				return
+			}
+			require.NoError(t, err)
+			require.NoError(t, getErr)
+			assert.Regexp(t, `^test-app-production-encrypted-env-[0-9a-f]{10}$`, secret.Name)
+
+			values := map[string]string{}
+			for k, v := range secret.Data {
+				values[k] = string(v)
+			}
+			assert.Equal(t, tt.expectedValues, values)
+			require.Len(t, secret.OwnerReferences, 1)
+			assert.Equal(t, rel.Name, secret.OwnerReferences[0].Name)
+		})
+	}
+}
+
+func TestEncryptedSecretName(t *testing.T) {
+	rel := newEncryptedSecretsTestRelease()
+
+	name := encryptedSecretName(rel, []byte("API_TOKEN=ENC[a]\n"))
+	assert.Equal(t, name, encryptedSecretName(rel, []byte("API_TOKEN=ENC[a]\n")))
+	// 暗号化ファイルが変わった場合は以前のリビジョンのSecretを上書きしない
+	assert.NotEqual(t, name, encryptedSecretName(rel, []byte("API_TOKEN=ENC[b]\n")))
+}
+
+func TestManager_reconcileEncryptedSecrets_NotConfigured(t *testing.T) {
+	scheme := newProcessTestScheme(t)
+	rel := newEncryptedSecretsTestRelease()
+	rel.Status.EncryptedEnvSecretName = "test-app-production-encrypted-env-0123456789"
+	k8sClient := fake.NewClientBuilder().WithScheme(scheme).WithObjects(rel).Build()
+	m := newTestManager(t, k8sClient, nil, testdataPath(""))
+
+	require.NoError(t, m.reconcileEncryptedSecrets(context.Background(), rel, &appconfigext.AppConfig{}))
+
+	assert.Empty(t, rel.Status.EncryptedEnvSecretName)
+}
+
+func TestManager_pruneEncryptedSecrets(t *testing.T) {
+	rel := newEncryptedSecretsTestRelease()
+	rel.Status.EncryptedEnvSecretName = "test-app-production-encrypted-env-current"
+	newSecret := func(name string, labels map[string]string) *corev1.Secret {
+		return &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name, Labels: labels}}
+	}
+	encryptedLabels := map[string]string{
+		tacokumogithubiov1alpha1.ReleaseLabelKey: rel.Name,
+		encryptedEnvLabelKey:                     "true",
+	}
+	revision := &tacokumogithubiov1alpha1.ReleaseRevision{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "default",
+			Name:      revisionName(rel, 1),
+			Labels:    map[string]string{tacokumogithubiov1alpha1.ReleaseLabelKey: rel.Name},
+		},
+		Spec: tacokumogithubiov1alpha1.ReleaseRevisionSpec{
+			Release:                rel.Name,
+			Revision:               1,
+			EncryptedEnvSecretName: "test-app-production-encrypted-env-previous",
+		},
+	}
+	k8sClient := fake.NewClientBuilder().
+		WithScheme(newProcessTestScheme(t)).
+		WithObjects(
+			rel, revision,
+			newSecret("test-app-production-encrypted-env-current", encryptedLabels),
+			newSecret("test-app-production-encrypted-env-previous", encryptedLabels),
+			newSecret("test-app-production-encrypted-env-pruned", encryptedLabels),
+			newSecret("test-app-production-env", nil),
+		).
+		Build()
+	m := newTestManager(t, k8sClient, nil, testdataPath(""))
+
+	require.NoError(t, m.pruneEncryptedSecrets(context.Background(), rel))
+
+	secrets := &corev1.SecretList{}
+	require.NoError(t, k8sClient.List(context.Background(), secrets))
+	var names []string
+	for _, secret := range secrets.Items {
+		names = append(names, secret.Name)
+	}
+	// 保持しているリビジョンが参照するSecretはロールバックのために残す
+	assert.ElementsMatch(t, []string{
+		"test-app-production-encrypted-env-current",
+		"test-app-production-encrypted-env-previous",
+		"test-app-production-env",
+	}, names)
+}
+
+func TestEnvFromOf(t *testing.T) {
+	rel := newEncryptedSecretsTestRelease()
+	rel.Spec.EnvSecretName = stringPtr("test-app-production-env")
+	rel.Status.EncryptedEnvSecretName = "test-app-production-encrypted-env-0123456789"
+	appCfg := &appconfigext.AppConfig{
+		Ext: appconfigext.Extension{
+			Secrets: &appconfigext.SecretsConfig{EncryptedFile: "secrets.enc.env"},
+		},
+	}
+
+	envFrom := envFromOf(rel, appCfg)
+
+	require.Len(t, envFrom, 2)
+	// APIで設定された値が優先されるよう､spec.envSecretName が後になる
+	assert.Equal(t, "test-app-production-encrypted-env-0123456789", envFrom[0].SecretRef.Name)
+	assert.Equal(t, "test-app-production-env", envFrom[1].SecretRef.Name)
+}
@@ -0,0 +1,227 @@
+package release
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+
+	tacokumogithubiov1alpha1 "github.com/tacokumo/portal-controller-kubernetes/api/v1alpha1"
+	"github.com/tacokumo/portal-controller-kubernetes/pkg/servicebinding"
+
+	"github.com/samber/lo"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// serviceBindingsOf はReleaseに注入するServiceBindingと､その接続情報のSecretから計算したハッシュを返す
+// ハッシュは参照するキーの値だけから計算するため､他のキーやメタデータの更新では再デプロイしない
+// Applicationが作成していないReleaseには注入しない
+func (m *Manager) serviceBindingsOf(
+	ctx context.Context,
+	rel *tacokumogithubiov1alpha1.Release,
+) ([]tacokumogithubiov1alpha1.BoundServiceBinding, string, error) {
+	application := rel.Labels[tacokumogithubiov1alpha1.ApplicationLabelKey]
+	if application == "" {
+		return nil, "", nil
+	}
+	bindings, err := servicebinding.ForRelease(ctx, m.k8sClient, rel.Namespace, application, rel.Spec.Stage)
+	if err != nil {
+		return nil, "", err
+	}
+	if len(bindings) == 0 {
+		return nil, "", nil
+	}
+
+	bound := make([]tacokumogithubiov1alpha1.BoundServiceBinding, 0, len(bindings))
+	boundBy := map[string]string{}
+	// 秘密の値をstatusから推測されないよう､ReleaseごとのキーによるHMACで計算する
+	hash := hmac.New(sha256.New, []byte(rel.UID))
+	for _, sb := range bindings {
+		secret, err := servicebinding.Secret(ctx, m.k8sClient, &sb)
+		if err != nil {
+			return nil, "", err
+		}
+		for _, env := range sb.Spec.Env {
+			if other, ok := boundBy[env.Name]; ok {
+				return nil, "", fmt.Errorf("env %s is bound by both servicebinding %s and %s", env.Name, other, sb.Name)
+			}
+			boundBy[env.Name] = sb.Name
+			// 値の区切りを曖昧にしないよう､長さを含めてハッシュに書き込む
+			fields := []string{sb.Name, secret.Name, env.Name, env.Key, string(secret.Data[env.Key])}
+			for _, field := range fields {
+				_, _ = fmt.Fprintf(hash, "%d:%s;", len(field), field)
+			}
+		}
+		bound = append(bound, tacokumogithubiov1alpha1.BoundServiceBinding{
+			Name:       sb.Name,
+			SecretName: sb.Spec.SecretName,
+			Env:        sb.Spec.Env,
+		})
+	}
+	return bound, hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// resolveServiceBindings はReleaseに注入するServiceBindingを rel.Status に記録する
+// ワークロードの構築時には記録された内容が使用される
+func (m *Manager) resolveServiceBindings(
+	ctx context.Context,
+	rel *tacokumogithubiov1alpha1.Release,
+) error {
+	bound, hash, err := m.serviceBindingsOf(ctx, rel)
+	if err != nil {
+		return err
+	}
+	rel.Status.ServiceBindings = bound
+	rel.Status.ServiceBindingsHash = hash
+	return nil
+}
+
+// serviceBindingsChanged はデプロイ後にServiceBindingやそのSecretが変わったかどうかを返す
+// Secretやキーが削除された場合でも稼働中のPodは動作し続けるため､Releaseを失敗させず､
+// ServiceBinding Conditionに記録して false を返す
+func (m *Manager) serviceBindingsChanged(
+	ctx context.Context,
+	rel *tacokumogithubiov1alpha1.Release,
+) bool {
+	_, hash, err := m.serviceBindingsOf(ctx, rel)
+	if err != nil {
+		meta.SetStatusCondition(&rel.Status.Conditions, metav1.Condition{
+			Type:               tacokumogithubiov1alpha1.ConditionTypeServiceBinding,
+			Status:             metav1.ConditionFalse,
+			ObservedGeneration: rel.Generation,
+			Reason:             tacokumogithubiov1alpha1.ReasonSecretUnavailable,
+			Message:            err.Error(),
+		})
+		return false
+	}
+	meta.RemoveStatusCondition(&rel.Status.Conditions, tacokumogithubiov1alpha1.ConditionTypeServiceBinding)
+	return hash != rel.Status.ServiceBindingsHash
+}
+
+// serviceBindingEnvVarsOf は rel.Status に記録されたServiceBindingをSecretを参照する環境変数に変換する
+func serviceBindingEnvVarsOf(rel *tacokumogithubiov1alpha1.Release) []corev1.EnvVar {
+	var envVars []corev1.EnvVar
+	for _, sb := range rel.Status.ServiceBindings {
+		for _, env := range sb.Env {
+			envVars = append(envVars, corev1.EnvVar{
+				Name: env.Name,
+				ValueFrom: &corev1.EnvVarSource{
+					SecretKeyRef: &corev1.SecretKeySelector{
+						LocalObjectReference: corev1.LocalObjectReference{Name: sb.SecretName},
+						Key:                  env.Key,
+					},
+				},
+			})
+		}
+	}
+	sort.Slice(envVars, func(i, j int) bool {
+		return envVars[i].Name < envVars[j].Name
+	})
+	return envVars
+}
+
+// containerEnvOf はappconfigの環境変数とServiceBindingの環境変数を合わせて名前順に返す
+// 同じ名前の環境変数はServiceBindingの値が優先される
+func containerEnvOf(rel *tacokumogithubiov1alpha1.Release, env map[string]string) []corev1.EnvVar {
+	bindings := serviceBindingEnvVarsOf(rel)
+	bound := lo.SliceToMap(bindings, func(envVar corev1.EnvVar) (string, struct{}) {
+		return envVar.Name, struct{}{}
+	})
+	envVars := lo.Filter(envVarsOf(env), func(envVar corev1.EnvVar, _ int) bool {
+		_, ok := bound[envVar.Name]
+		return !ok
+	})
+	envVars = append(envVars, bindings...)
+	sort.Slice(envVars, func(i, j int) bool {
+		return envVars[i].Name < envVars[j].Name
+	})
+	if len(envVars) == 0 {
+		return nil
+	}
+	return envVars
+}
+
+// podTemplateAnnotationsOf はワークロードのPodテンプレートに付与するアノテーションを返す
+// 接続情報が変わった場合にPodを再作成させるため､ServiceBindingのハッシュを含める
+func podTemplateAnnotationsOf(rel *tacokumogithubiov1alpha1.Release) map[string]string {
+	if rel.Status.ServiceBindingsHash == "" {
+		return nil
+	}
+	return map[string]string{
+		tacokumogithubiov1alpha1.ServiceBindingsHashAnnotationKey: rel.Status.ServiceBindingsHash,
+	}
+}
+
+// applyServiceBindings はレンダリングされたDeploymentのコンテナにServiceBindingの環境変数を追加し､
+// Podテンプレートにハッシュのアノテーションを付与する
+// tacokumo-applicationチャートが valueFrom の環境変数を扱えないため､レンダリング後に反映する
+func applyServiceBindings(
+	objects []*unstructured.Unstructured,
+	deploymentName string,
+	rel *tacokumogithubiov1alpha1.Release,
+) error {
+	bindings := serviceBindingEnvVarsOf(rel)
+	if len(bindings) == 0 {
+		return nil
+	}
+
+	deploy := findObject(objects, "Deployment", deploymentName)
+	if deploy == nil {
+		return nil
+	}
+
+	annotationsPath := []string{"spec", "template", "metadata", "annotations"}
+	annotations, _, err := unstructured.NestedStringMap(deploy.Object, annotationsPath...)
+	if err != nil {
+		return err
+	}
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	for k, v := range podTemplateAnnotationsOf(rel) {
+		annotations[k] = v
+	}
+	if err := unstructured.SetNestedStringMap(deploy.Object, annotations, annotationsPath...); err != nil {
+		return err
+	}
+
+	containers, found, err := unstructured.NestedSlice(deploy.Object, "spec", "template", "spec", "containers")
+	if err != nil || !found {
+		return err
+	}
+	for i, raw := range containers {
+		container, ok := raw.(map[string]interface{})
+		if !ok || container["name"] != deploymentName {
+			continue
+		}
+		existing, _, err := unstructured.NestedSlice(container, "env")
+		if err != nil {
+			return err
+		}
+		vars := make([]interface{}, 0, len(existing)+len(bindings))
+		for _, v := range existing {
+			if envVar, ok := v.(map[string]interface{}); ok && lo.ContainsBy(bindings, func(b corev1.EnvVar) bool {
+				return b.Name == envVar["name"]
+			}) {
+				continue
+			}
+			vars = append(vars, v)
+		}
+		for j := range bindings {
+			envVar, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&bindings[j])
+			if err != nil {
+				return err
+			}
+			vars = append(vars, envVar)
+		}
+		container["env"] = vars
+		containers[i] = container
+	}
+	return unstructured.SetNestedSlice(deploy.Object, containers, "spec", "template", "spec", "containers")
+}
@@ -0,0 +1,244 @@
+package release
+
+import (
+	"context"
+	"testing"
+
+	tacokumogithubiov1alpha1 "github.com/tacokumo/portal-controller-kubernetes/api/v1alpha1"
+	"github.com/tacokumo/portal-controller-kubernetes/pkg/repoconnector"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func newServiceBindingTestObjects(password string) (*tacokumogithubiov1alpha1.ServiceBinding, *corev1.Secret) {
+	sb := &tacokumogithubiov1alpha1.ServiceBinding{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "postgresql"},
+		Spec: tacokumogithubiov1alpha1.ServiceBindingSpec{
+			Application: "test-app",
+			Stages:      []string{"production"},
+			SecretName:  "postgresql-connection",
+			Env: []tacokumogithubiov1alpha1.ServiceBindingEnv{
+				{Name: "DB_HOST", Key: "host"},
+				{Name: "QUEUE_URL", Key: "queue"},
+			},
+		},
+	}
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "postgresql-connection"},
+		Data: map[string][]byte{
+			"host":     []byte("db.example.com"),
+			"queue":    []byte("redis://managed:6379"),
+			"password": []byte(password),
+		},
+	}
+	return sb, secret
+}
+
+func newServiceBindingTestRelease() *tacokumogithubiov1alpha1.Release {
+	return &tacokumogithubiov1alpha1.Release{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test-app-production",
+			Namespace: "default",
+			UID:       "rel-uid",
+			Labels:    map[string]string{tacokumogithubiov1alpha1.ApplicationLabelKey: "test-app"},
+		},
+		Spec: tacokumogithubiov1alpha1.ReleaseSpec{
+			AppConfigPath: "appconfig.yaml",
+			Stage:         "production",
+			Commit:        stringPtr("abc123"),
+		},
+		Status: tacokumogithubiov1alpha1.ReleaseStatus{
+			State: tacokumogithubiov1alpha1.ReleaseStateDeploying,
+		},
+	}
+}
+
+func TestManager_reconcileOnDeployingState_ServiceBindings(t *testing.T) {
+	scheme := newProcessTestScheme(t)
+	rel := newServiceBindingTestRelease()
+	sb, secret := newServiceBindingTestObjects("s3cr3t")
+	k8sClient := fake.NewClientBuilder().
+		WithScheme(scheme).
+		WithObjects(rel, sb, secret).
+		WithStatusSubresource(rel).
+		Build()
+	connector := repoconnector.NewLocalConnector(repoTestdataPath("release-processes"))
+	m := newTestManager(t, k8sClient, connector, testdataPath(""))
+
+	require.NoError(t, m.reconcileOnDeployingState(context.Background(), rel))
+
+	assert.Equal(t, []tacokumogithubiov1alpha1.BoundServiceBinding{
+		{Name: "postgresql", SecretName: "postgresql-connection", Env: sb.Spec.Env},
+	}, rel.Status.ServiceBindings)
+	require.NotEmpty(t, rel.Status.ServiceBindingsHash)
+
+	worker := &appsv1.Deployment{}
+	require.NoError(t, k8sClient.Get(context.Background(), client.ObjectKey{
+		Namespace: "default", Name: "test-app-production-worker",
+	}, worker))
+	assert.Equal(t, rel.Status.ServiceBindingsHash,
+		worker.Spec.Template.Annotations[tacokumogithubiov1alpha1.ServiceBindingsHashAnnotationKey])
+	// appconfigの QUEUE_URL はServiceBindingの値で置き換えられる
+	assert.Equal(t, []corev1.EnvVar{
+		{Name: "DB_HOST", ValueFrom: &corev1.EnvVarSource{SecretKeyRef: &corev1.SecretKeySelector{
+			LocalObjectReference: corev1.LocalObjectReference{Name: "postgresql-connection"}, Key: "host",
+		}}},
+		{Name: "QUEUE_URL", ValueFrom: &corev1.EnvVarSource{SecretKeyRef: &corev1.SecretKeySelector{
+			LocalObjectReference: corev1.LocalObjectReference{Name: "postgresql-connection"}, Key: "queue",
+		}}},
+	}, worker.Spec.Template.Spec.Containers[0].Env)
+}
+
+func TestManager_Reconcile_DeployedRedeploysOnServiceBindingChange(t *testing.T) {
+	tests := []struct {
+		name            string
+		updateSecret    func(secret *corev1.Secret)
+		deleteSecret    bool
+		expectState     string
+		expectCondition *metav1.ConditionStatus
+	}{
+		{
+			name:        "unchanged secret keeps the release deployed",
+			expectState: tacokumogithubiov1alpha1.ReleaseStateDeployed,
+		},
+		{
+			name: "update of a bound key redeploys the release",
+			updateSecret: func(secret *corev1.Secret) {
+				secret.Data["host"] = []byte("db-replica.example.com")
+			},
+			expectState: tacokumogithubiov1alpha1.ReleaseStateDeploying,
+		},
+		{
+			name: "update of an unbound key keeps the release deployed",
+			updateSecret: func(secret *corev1.Secret) {
+				secret.Data["password"] = []byte("rotated")
+			},
+			expectState: tacokumogithubiov1alpha1.ReleaseStateDeployed,
+		},
+		{
+			name: "metadata-only update keeps the release deployed",
+			updateSecret: func(secret *corev1.Secret) {
+				secret.Labels = map[string]string{"owner": "platform"}
+			},
+			expectState: tacokumogithubiov1alpha1.ReleaseStateDeployed,
+		},
+		{
+			// 稼働中のPodは以前の値で動作し続けるため､Failedにはしない
+			name:            "deleted secret keeps the release deployed with condition",
+			deleteSecret:    true,
+			expectState:     tacokumogithubiov1alpha1.ReleaseStateDeployed,
+			expectCondition: ptr.To(metav1.ConditionFalse),
+		},
+		{
+			name: "removed key keeps the release deployed with condition",
+			updateSecret: func(secret *corev1.Secret) {
+				delete(secret.Data, "queue")
+			},
+			expectState:     tacokumogithubiov1alpha1.ReleaseStateDeployed,
+			expectCondition: ptr.To(metav1.ConditionFalse),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scheme := newProcessTestScheme(t)
+			rel := newServiceBindingTestRelease()
+			sb, secret := newServiceBindingTestObjects("s3cr3t")
+			k8sClient := fake.NewClientBuilder().
+				WithScheme(scheme).
+				WithObjects(rel, sb, secret).
+				WithStatusSubresource(rel).
+				Build()
+			m := newTestManager(t, k8sClient, nil, testdataPath(""))
+
+			require.NoError(t, k8sClient.Get(context.Background(), client.ObjectKeyFromObject(secret), secret))
+			require.NoError(t, m.resolveServiceBindings(context.Background(), rel))
+			rel.Status.State = tacokumogithubiov1alpha1.ReleaseStateDeployed
+
+			if tt.updateSecret != nil {
+				tt.updateSecret(secret)
+				require.NoError(t, k8sClient.Update(context.Background(), secret))
+			}
+			if tt.deleteSecret {
+				require.NoError(t, k8sClient.Delete(context.Background(), secret))
+			}
+
+			require.NoError(t, m.Reconcile(context.Background(), rel))
+			assert.Equal(t, tt.expectState, rel.Status.State)
+			assert.NotContains(t, rel.Status.ServiceBindingsHash, "s3cr3t")
+
+			condition := meta.FindStatusCondition(rel.Status.Conditions,
+				tacokumogithubiov1alpha1.ConditionTypeServiceBinding)
+			if tt.expectCondition == nil {
+				assert.Nil(t, condition)
+				return
+			}
+			require.NotNil(t, condition)
+			assert.Equal(t, *tt.expectCondition, condition.Status)
+			assert.Equal(t, tacokumogithubiov1alpha1.ReasonSecretUnavailable, condition.Reason)
+		})
+	}
+}
+
+func TestManager_serviceBindingsOf_HashIsKeyedPerRelease(t *testing.T) {
+	sb, secret := newServiceBindingTestObjects("s3cr3t")
+	k8sClient := fake.NewClientBuilder().
+		WithScheme(newProcessTestScheme(t)).
+		WithObjects(sb, secret).
+		Build()
+	m := newTestManager(t, k8sClient, nil, testdataPath(""))
+
+	rel := newServiceBindingTestRelease()
+	_, hash, err := m.serviceBindingsOf(context.Background(), rel)
+	require.NoError(t, err)
+
+	other := newServiceBindingTestRelease()
+	other.UID = "other-uid"
+	_, otherHash, err := m.serviceBindingsOf(context.Background(), other)
+	require.NoError(t, err)
+	assert.NotEqual(t, hash, otherHash, "releases with the same secret must not share the hash")
+}
+
+func TestApplyServiceBindings(t *testing.T) {
+	deploy := newTestDeployment("test-app", "test-app", "sidecar")
+	require.NoError(t, applyContainerEnv([]*unstructured.Unstructured{deploy}, "test-app",
+		map[string]string{"DB_HOST": "localhost", "LOG_LEVEL": "debug"}))
+	rel := &tacokumogithubiov1alpha1.Release{
+		Status: tacokumogithubiov1alpha1.ReleaseStatus{
+			ServiceBindings: []tacokumogithubiov1alpha1.BoundServiceBinding{{
+				Name:       "postgresql",
+				SecretName: "postgresql-connection",
+				Env:        []tacokumogithubiov1alpha1.ServiceBindingEnv{{Name: "DB_HOST", Key: "host"}},
+			}},
+			ServiceBindingsHash: "abc",
+		},
+	}
+
+	require.NoError(t, applyServiceBindings([]*unstructured.Unstructured{deploy}, "test-app", rel))
+
+	annotations, _, err := unstructured.NestedStringMap(deploy.Object, "spec", "template", "metadata", "annotations")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{tacokumogithubiov1alpha1.ServiceBindingsHashAnnotationKey: "abc"}, annotations)
+
+	containers, _, err := unstructured.NestedSlice(deploy.Object, "spec", "template", "spec", "containers")
+	require.NoError(t, err)
+	assert.Equal(t, []interface{}{
+		map[string]interface{}{"name": "LOG_LEVEL", "value": "debug"},
+		map[string]interface{}{
+			"name": "DB_HOST",
+			"valueFrom": map[string]interface{}{
+				"secretKeyRef": map[string]interface{}{"name": "postgresql-connection", "key": "host"},
+			},
+		},
+	}, containers[0].(map[string]interface{})["env"])
+	assert.NotContains(t, containers[1].(map[string]interface{}), "env")
+}
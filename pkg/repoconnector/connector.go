@@ -4,9 +4,7 @@ import (
 	"context"
 	"io"
 
-	appconfig "github.com/tacokumo/appconfig"
-
-	"go.yaml.in/yaml/v3"
+	"github.com/tacokumo/portal-controller-kubernetes/pkg/appconfigext"
 )
 
 // GitRepositoryConnector はGitリポジトリへの接続を抽象化するインターフェース
@@ -30,24 +28,45 @@ func CloneApplicationRepository(
 	url string,
 	refName string,
 	appConfigPath string,
-) (cfg appconfig.AppConfig, err error) {
+) (appconfigext.AppConfig, error) {
 	wt, err := connector.Clone(ctx, url, refName)
 	if err != nil {
-		return appconfig.AppConfig{}, err
+		return appconfigext.AppConfig{}, err
 	}
 
-	f, err := wt.Open(appConfigPath)
+	data, err := readFile(wt, appConfigPath)
 	if err != nil {
-		return appconfig.AppConfig{}, err
+		return appconfigext.AppConfig{}, err
 	}
-	defer func() {
-		err = f.Close()
-	}()
+	return appconfigext.Parse(data)
+}
 
-	var appCfg appconfig.AppConfig
-	if err := yaml.NewDecoder(f).Decode(&appCfg); err != nil {
-		return appconfig.AppConfig{}, err
+// ReadRepositoryFile は指定されたGitリポジトリのファイルの内容を返す
+// appconfigから参照される､appconfig以外のファイルを読み込むために使用する
+func ReadRepositoryFile(
+	ctx context.Context,
+	connector GitRepositoryConnector,
+	url string,
+	refName string,
+	path string,
+) ([]byte, error) {
+	wt, err := connector.Clone(ctx, url, refName)
+	if err != nil {
+		return nil, err
 	}
+	return readFile(wt, path)
+}
 
-	return appCfg, nil
+func readFile(wt Worktree, path string) (data []byte, err error) {
+	f, err := wt.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		// 読み込みのエラーをCloseの結果で上書きしない
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+	}()
+	return io.ReadAll(f)
 }
@@ -0,0 +1,172 @@
+package secretfile
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/nacl/box"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+const (
+	encryptedPrefix = "ENC["
+	encryptedSuffix = "]"
+	keySize         = 32
+)
+
+// GenerateKey は暗号化に使用する公開鍵と､復号に使用する秘密鍵をbase64で返す
+// 秘密鍵はクラスタのSecretに格納し､公開鍵はリポジトリで値を暗号化するために使用する
+func GenerateKey() (publicKey, privateKey string, err error) {
+	pub, priv, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		return "", "", err
+	}
+	return base64.StdEncoding.EncodeToString(pub[:]), base64.StdEncoding.EncodeToString(priv[:]), nil
+}
+
+// EncryptValue は plaintext を publicKey 宛てに暗号化し､暗号化ファイルに記述できる `ENC[...]` の形式で返す
+func EncryptValue(publicKey string, plaintext string) (string, error) {
+	pub, err := decodeKey(publicKey)
+	if err != nil {
+		return "", fmt.Errorf("invalid public key: %w", err)
+	}
+	sealed, err := box.SealAnonymous(nil, []byte(plaintext), pub, rand.Reader)
+	if err != nil {
+		return "", err
+	}
+	return encryptedPrefix + base64.StdEncoding.EncodeToString(sealed) + encryptedSuffix, nil
+}
+
+// EncryptFile は平文のdotenv形式のファイルの値を publicKey 宛てに暗号化し､暗号化ファイルの内容を返す
+// コメントと空行はそのまま残し､既に `ENC[...]` の形式で暗号化されている値は再度暗号化しない
+func EncryptFile(publicKey string, data []byte) ([]byte, error) {
+	var out bytes.Buffer
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			out.WriteString(text + "\n")
+			continue
+		}
+		name, value, found := strings.Cut(text, "=")
+		if !found {
+			return nil, fmt.Errorf("line %d: expected NAME=VALUE", line)
+		}
+		name = strings.TrimSpace(name)
+		if errs := validation.IsEnvVarName(name); len(errs) > 0 {
+			return nil, fmt.Errorf("line %d: invalid name %q: %s", line, name, strings.Join(errs, ", "))
+		}
+		value = strings.TrimSpace(value)
+		if !strings.HasPrefix(value, encryptedPrefix) || !strings.HasSuffix(value, encryptedSuffix) {
+			encrypted, err := EncryptValue(publicKey, value)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %q: %w", line, name, err)
+			}
+			value = encrypted
+		}
+		out.WriteString(name + "=" + value + "\n")
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// Decrypt は暗号化ファイルの内容を復号し､環境変数の名前と値を返す
+// ファイルはdotenv形式で､値は全て `ENC[...]` の形式で暗号化されている必要がある
+// 鍵のローテーションのため､privateKeys のいずれかで復号できれば良い
+// 返すエラーには平文の値を含めない
+func Decrypt(data []byte, privateKeys []string) (map[string]string, error) {
+	keys := make([]keyPair, 0, len(privateKeys))
+	for i, privateKey := range privateKeys {
+		pair, err := newKeyPair(privateKey)
+		if err != nil {
+			return nil, fmt.Errorf("private key #%d: %w", i, err)
+		}
+		keys = append(keys, pair)
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no private key is available")
+	}
+
+	values := map[string]string{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		name, value, found := strings.Cut(text, "=")
+		if !found {
+			return nil, fmt.Errorf("line %d: expected NAME=ENC[...]", line)
+		}
+		name = strings.TrimSpace(name)
+		if errs := validation.IsEnvVarName(name); len(errs) > 0 {
+			return nil, fmt.Errorf("line %d: invalid name %q: %s", line, name, strings.Join(errs, ", "))
+		}
+		if _, ok := values[name]; ok {
+			return nil, fmt.Errorf("line %d: duplicate name %q", line, name)
+		}
+		plaintext, err := decryptValue(strings.TrimSpace(value), keys)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %q: %w", line, name, err)
+		}
+		values[name] = plaintext
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+type keyPair struct {
+	publicKey  *[keySize]byte
+	privateKey *[keySize]byte
+}
+
+func newKeyPair(privateKey string) (keyPair, error) {
+	priv, err := decodeKey(privateKey)
+	if err != nil {
+		return keyPair{}, err
+	}
+	pub, err := curve25519.X25519(priv[:], curve25519.Basepoint)
+	if err != nil {
+		return keyPair{}, err
+	}
+	return keyPair{publicKey: (*[keySize]byte)(pub), privateKey: priv}, nil
+}
+
+// decryptValue は `ENC[...]` の形式の値を復号する
+// 暗号化されていない値は､平文がリポジトリにコミットされている可能性があるためエラーとする
+func decryptValue(value string, keys []keyPair) (string, error) {
+	if !strings.HasPrefix(value, encryptedPrefix) || !strings.HasSuffix(value, encryptedSuffix) {
+		return "", fmt.Errorf("value is not encrypted")
+	}
+	sealed, err := base64.StdEncoding.DecodeString(
+		strings.TrimSuffix(strings.TrimPrefix(value, encryptedPrefix), encryptedSuffix))
+	if err != nil {
+		return "", fmt.Errorf("malformed encrypted value")
+	}
+	for _, key := range keys {
+		if plaintext, ok := box.OpenAnonymous(nil, sealed, key.publicKey, key.privateKey); ok {
+			return string(plaintext), nil
+		}
+	}
+	return "", fmt.Errorf("failed to decrypt with any of the private keys")
+}
+
+func decodeKey(key string) (*[keySize]byte, error) {
+	decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(key))
+	if err != nil {
+		return nil, fmt.Errorf("key must be base64 encoded")
+	}
+	if len(decoded) != keySize {
+		return nil, fmt.Errorf("key must be %d bytes, got %d", keySize, len(decoded))
+	}
+	return (*[keySize]byte)(decoded), nil
+}
@@ -0,0 +1,114 @@
+package secretfile
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecrypt(t *testing.T) {
+	publicKey, privateKey, err := GenerateKey()
+	require.NoError(t, err)
+	_, otherPrivateKey, err := GenerateKey()
+	require.NoError(t, err)
+
+	encrypt := func(plaintext string) string {
+		t.Helper()
+		value, err := EncryptValue(publicKey, plaintext)
+		require.NoError(t, err)
+		return value
+	}
+
+	tests := []struct {
+		name        string
+		data        string
+		privateKeys []string
+		expected    map[string]string
+		expectError bool
+	}{
+		{
+			name: "decrypts all values",
+			data: fmt.Sprintf("# comment\nDATABASE_URL=%s\n\nAPI_TOKEN = %s\n",
+				encrypt("postgres://db"), encrypt("s3cr3t")),
+			privateKeys: []string{privateKey},
+			expected: map[string]string{
+				"DATABASE_URL": "postgres://db",
+				"API_TOKEN":    "s3cr3t",
+			},
+		},
+		{
+			name:        "tries every private key",
+			data:        fmt.Sprintf("API_TOKEN=%s\n", encrypt("s3cr3t")),
+			privateKeys: []string{otherPrivateKey, privateKey},
+			expected:    map[string]string{"API_TOKEN": "s3cr3t"},
+		},
+		{
+			name:        "wrong private key causes error",
+			data:        fmt.Sprintf("API_TOKEN=%s\n", encrypt("s3cr3t")),
+			privateKeys: []string{otherPrivateKey},
+			expectError: true,
+		},
+		{
+			name:        "plaintext value causes error",
+			data:        "API_TOKEN=s3cr3t\n",
+			privateKeys: []string{privateKey},
+			expectError: true,
+		},
+		{
+			name:        "duplicate name causes error",
+			data:        fmt.Sprintf("API_TOKEN=%s\nAPI_TOKEN=%s\n", encrypt("a"), encrypt("b")),
+			privateKeys: []string{privateKey},
+			expectError: true,
+		},
+		{
+			name:        "no private key causes error",
+			data:        fmt.Sprintf("API_TOKEN=%s\n", encrypt("s3cr3t")),
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			values, err := Decrypt([]byte(tt.data), tt.privateKeys)
+			if tt.expectError {
+				require.Error(t, err)
+				assert.NotContains(t, err.Error(), "s3cr3t")
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, values)
+		})
+	}
+}
+
+func TestEncryptValue_InvalidPublicKey(t *testing.T) {
+	_, err := EncryptValue("not-a-key", "s3cr3t")
+	assert.Error(t, err)
+}
+
+func TestEncryptFile(t *testing.T) {
+	publicKey, privateKey, err := GenerateKey()
+	require.NoError(t, err)
+	alreadyEncrypted, err := EncryptValue(publicKey, "already")
+	require.NoError(t, err)
+
+	data := fmt.Sprintf("# comment\nDATABASE_URL=postgres://db\n\nAPI_TOKEN = s3cr3t\nOTHER=%s\n", alreadyEncrypted)
+	encrypted, err := EncryptFile(publicKey, []byte(data))
+	require.NoError(t, err)
+	assert.NotContains(t, string(encrypted), "s3cr3t")
+	assert.Contains(t, string(encrypted), "# comment\n")
+	assert.Contains(t, string(encrypted), "OTHER="+alreadyEncrypted+"\n")
+
+	values, err := Decrypt(encrypted, []string{privateKey})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"DATABASE_URL": "postgres://db",
+		"API_TOKEN":    "s3cr3t",
+		"OTHER":        "already",
+	}, values)
+
+	_, err = EncryptFile(publicKey, []byte("INVALID\n"))
+	require.Error(t, err)
+}
@@ -0,0 +1,158 @@
+package servicebinding
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+
+	tacokumogithubiov1alpha1 "github.com/tacokumo/portal-controller-kubernetes/api/v1alpha1"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+type Manager struct {
+	logger    logr.Logger
+	k8sClient client.Client
+}
+
+func NewManager(
+	logger logr.Logger,
+	k8sClient client.Client,
+) *Manager {
+	return &Manager{
+		logger:    logger,
+		k8sClient: k8sClient,
+	}
+}
+
+// Reconcile はServiceBindingのSecretが対応付けられた全てのキーを持つか確認し､
+// 接続情報が注入されるReleaseをstatusに記録する
+func (m *Manager) Reconcile(
+	ctx context.Context,
+	sb *tacokumogithubiov1alpha1.ServiceBinding,
+) error {
+	if _, err := Secret(ctx, m.k8sClient, sb); err != nil {
+		return m.handleError(ctx, sb, err)
+	}
+
+	releases, err := m.boundReleases(ctx, sb)
+	if err != nil {
+		return m.handleError(ctx, sb, err)
+	}
+	sb.Status.BoundReleases = releases
+	sb.Status.ObservedGeneration = sb.Generation
+	tacokumogithubiov1alpha1.SetReadyConditionTrue(&sb.Status.Conditions, sb.Generation,
+		tacokumogithubiov1alpha1.ReasonSecretBound,
+		fmt.Sprintf("bound to %d releases", len(releases)))
+
+	if err := m.k8sClient.Status().Update(ctx, sb); err != nil {
+		return m.handleError(ctx, sb, err)
+	}
+	return nil
+}
+
+// Secret は spec.secretName のSecretを返す
+// spec.env で対応付けられたキーが存在しない場合はエラーを返す
+// エラーには接続情報の値を含めない
+func Secret(
+	ctx context.Context,
+	k8sClient client.Client,
+	sb *tacokumogithubiov1alpha1.ServiceBinding,
+) (*corev1.Secret, error) {
+	secret := &corev1.Secret{}
+	key := client.ObjectKey{Namespace: sb.Namespace, Name: sb.Spec.SecretName}
+	if err := k8sClient.Get(ctx, key, secret); err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil, fmt.Errorf("secret %s of servicebinding %s not found", sb.Spec.SecretName, sb.Name)
+		}
+		return nil, err
+	}
+	var missing []string
+	for _, env := range sb.Spec.Env {
+		if _, ok := secret.Data[env.Key]; !ok {
+			missing = append(missing, env.Key)
+		}
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("keys %s not found in secret %s of servicebinding %s",
+			strings.Join(missing, ", "), sb.Spec.SecretName, sb.Name)
+	}
+	return secret, nil
+}
+
+// Applies はServiceBindingが application の stage に接続情報を注入するかどうかを返す
+func Applies(sb *tacokumogithubiov1alpha1.ServiceBinding, application, stage string) bool {
+	if sb.Spec.Application != application {
+		return false
+	}
+	return len(sb.Spec.Stages) == 0 || slices.Contains(sb.Spec.Stages, stage)
+}
+
+// ForRelease は namespace のServiceBindingのうち､application の stage に注入するものを名前順に返す
+func ForRelease(
+	ctx context.Context,
+	k8sClient client.Client,
+	namespace, application, stage string,
+) ([]tacokumogithubiov1alpha1.ServiceBinding, error) {
+	bindings := &tacokumogithubiov1alpha1.ServiceBindingList{}
+	if err := k8sClient.List(ctx, bindings, client.InNamespace(namespace)); err != nil {
+		return nil, err
+	}
+	var applied []tacokumogithubiov1alpha1.ServiceBinding
+	for _, sb := range bindings.Items {
+		if Applies(&sb, application, stage) {
+			applied = append(applied, sb)
+		}
+	}
+	sort.Slice(applied, func(i, j int) bool {
+		return applied[i].Name < applied[j].Name
+	})
+	return applied, nil
+}
+
+// boundReleases はServiceBindingの接続情報が注入されるReleaseの名前を返す
+func (m *Manager) boundReleases(
+	ctx context.Context,
+	sb *tacokumogithubiov1alpha1.ServiceBinding,
+) ([]string, error) {
+	releases := &tacokumogithubiov1alpha1.ReleaseList{}
+	if err := m.k8sClient.List(ctx, releases,
+		client.InNamespace(sb.Namespace),
+		client.MatchingLabels{tacokumogithubiov1alpha1.ApplicationLabelKey: sb.Spec.Application},
+	); err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, rel := range releases.Items {
+		if Applies(sb, sb.Spec.Application, rel.Spec.Stage) {
+			names = append(names, rel.Name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (m *Manager) handleError(
+	ctx context.Context,
+	sb *tacokumogithubiov1alpha1.ServiceBinding,
+	err error,
+) error {
+	// 引数のerrorは必ずnilではない
+	tacokumogithubiov1alpha1.SetReadyConditionFalse(
+		&sb.Status.Conditions,
+		sb.Generation,
+		tacokumogithubiov1alpha1.ReasonReconcileError,
+		err.Error(),
+	)
+
+	// errorだとしても､Statusの更新は必要
+	if updateErr := m.k8sClient.Status().Update(ctx, sb); updateErr != nil {
+		return updateErr
+	}
+	return err
+}
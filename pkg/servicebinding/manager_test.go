@@ -0,0 +1,151 @@
+package servicebinding
+
+import (
+	"context"
+	"testing"
+
+	tacokumogithubiov1alpha1 "github.com/tacokumo/portal-controller-kubernetes/api/v1alpha1"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sruntime "k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func newTestScheme(t *testing.T) *k8sruntime.Scheme {
+	t.Helper()
+	scheme := k8sruntime.NewScheme()
+	require.NoError(t, tacokumogithubiov1alpha1.AddToScheme(scheme))
+	require.NoError(t, corev1.AddToScheme(scheme))
+	return scheme
+}
+
+func newTestServiceBinding(name string, stages ...string) *tacokumogithubiov1alpha1.ServiceBinding {
+	return &tacokumogithubiov1alpha1.ServiceBinding{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name, Generation: 1},
+		Spec: tacokumogithubiov1alpha1.ServiceBindingSpec{
+			Application: "test-app",
+			Stages:      stages,
+			SecretName:  "postgresql",
+			Env: []tacokumogithubiov1alpha1.ServiceBindingEnv{
+				{Name: "DB_HOST", Key: "host"},
+				{Name: "DB_PASSWORD", Key: "password"},
+			},
+		},
+	}
+}
+
+func newTestRelease(name, application, stage string) *tacokumogithubiov1alpha1.Release {
+	return &tacokumogithubiov1alpha1.Release{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "default",
+			Name:      name,
+			Labels:    map[string]string{tacokumogithubiov1alpha1.ApplicationLabelKey: application},
+		},
+		Spec: tacokumogithubiov1alpha1.ReleaseSpec{Stage: stage},
+	}
+}
+
+func TestManager_Reconcile(t *testing.T) {
+	tests := []struct {
+		name           string
+		stages         []string
+		secretData     map[string][]byte
+		expectReady    metav1.ConditionStatus
+		expectMessage  string
+		expectReleases []string
+	}{
+		{
+			name:           "binds to every stage of the application",
+			secretData:     map[string][]byte{"host": []byte("db.example.com"), "password": []byte("s3cr3t")},
+			expectReady:    metav1.ConditionTrue,
+			expectMessage:  "bound to 2 releases",
+			expectReleases: []string{"test-app-production", "test-app-staging"},
+		},
+		{
+			name:           "binds only to the specified stages",
+			stages:         []string{"production"},
+			secretData:     map[string][]byte{"host": []byte("db.example.com"), "password": []byte("s3cr3t")},
+			expectReady:    metav1.ConditionTrue,
+			expectMessage:  "bound to 1 releases",
+			expectReleases: []string{"test-app-production"},
+		},
+		{
+			name:          "missing key is reported without values",
+			secretData:    map[string][]byte{"host": []byte("db.example.com")},
+			expectReady:   metav1.ConditionFalse,
+			expectMessage: `keys password not found in secret postgresql of servicebinding test-binding`,
+		},
+		{
+			name:          "missing secret is reported",
+			expectReady:   metav1.ConditionFalse,
+			expectMessage: "secret postgresql of servicebinding test-binding not found",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sb := newTestServiceBinding("test-binding", tt.stages...)
+			objects := []client.Object{
+				sb,
+				newTestRelease("test-app-staging", "test-app", "staging"),
+				newTestRelease("test-app-production", "test-app", "production"),
+				newTestRelease("other-app-production", "other-app", "production"),
+			}
+			if tt.secretData != nil {
+				objects = append(objects, &corev1.Secret{
+					ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "postgresql"},
+					Data:       tt.secretData,
+				})
+			}
+			k8sClient := fake.NewClientBuilder().
+				WithScheme(newTestScheme(t)).
+				WithObjects(objects...).
+				WithStatusSubresource(sb).
+				Build()
+			m := NewManager(logr.Discard(), k8sClient)
+
+			err := m.Reconcile(context.Background(), sb)
+			if tt.expectReady == metav1.ConditionTrue {
+				require.NoError(t, err)
+			} else {
+				require.Error(t, err)
+				assert.NotContains(t, err.Error(), "db.example.com")
+			}
+
+			cond := meta.FindStatusCondition(sb.Status.Conditions, tacokumogithubiov1alpha1.ConditionTypeReady)
+			require.NotNil(t, cond)
+			assert.Equal(t, tt.expectReady, cond.Status)
+			assert.Equal(t, tt.expectMessage, cond.Message)
+			assert.Equal(t, tt.expectReleases, sb.Status.BoundReleases)
+		})
+	}
+}
+
+func TestForRelease(t *testing.T) {
+	k8sClient := fake.NewClientBuilder().
+		WithScheme(newTestScheme(t)).
+		WithObjects(
+			newTestServiceBinding("redis"),
+			newTestServiceBinding("postgresql", "production"),
+			newTestServiceBinding("staging-only", "staging"),
+		).
+		Build()
+
+	bindings, err := ForRelease(context.Background(), k8sClient, "default", "test-app", "production")
+	require.NoError(t, err)
+	names := make([]string, 0, len(bindings))
+	for _, sb := range bindings {
+		names = append(names, sb.Name)
+	}
+	assert.Equal(t, []string{"postgresql", "redis"}, names)
+
+	bindings, err = ForRelease(context.Background(), k8sClient, "default", "other-app", "production")
+	require.NoError(t, err)
+	assert.Empty(t, bindings)
+}